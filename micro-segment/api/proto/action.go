@@ -0,0 +1,39 @@
+package proto
+
+import (
+	"errors"
+	"fmt"
+)
+
+// 策略动作在proto字段（PolicyRule.action、Connection.policy_action等）中的取值
+// Agent与Controller共用这一组定义及名称转换，避免两端各自映射
+const (
+	PolicyActionOpen    uint32 = 0
+	PolicyActionAllow   uint32 = 1
+	PolicyActionDeny    uint32 = 2
+	PolicyActionViolate uint32 = 3
+)
+
+// ErrUnknownPolicyAction 未知的策略动作名称或取值
+var ErrUnknownPolicyAction = errors.New("unknown policy action")
+
+// policyActionNames 策略动作名称，下标为取值，与REST规则中的动作字符串一致
+var policyActionNames = []string{"open", "allow", "deny", "violate"}
+
+// ParsePolicyAction 解析策略动作名称，未知名称返回ErrUnknownPolicyAction
+func ParsePolicyAction(name string) (uint32, error) {
+	for i, n := range policyActionNames {
+		if n == name {
+			return uint32(i), nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q", ErrUnknownPolicyAction, name)
+}
+
+// PolicyActionName 获取策略动作名称，超出范围返回ErrUnknownPolicyAction
+func PolicyActionName(action uint32) (string, error) {
+	if action >= uint32(len(policyActionNames)) {
+		return "", fmt.Errorf("%w: %d", ErrUnknownPolicyAction, action)
+	}
+	return policyActionNames[action], nil
+}
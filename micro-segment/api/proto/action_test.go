@@ -0,0 +1,41 @@
+package proto
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPolicyActionRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		action uint32
+	}{
+		{"open", PolicyActionOpen},
+		{"allow", PolicyActionAllow},
+		{"deny", PolicyActionDeny},
+		{"violate", PolicyActionViolate},
+	}
+	for _, tt := range tests {
+		action, err := ParsePolicyAction(tt.name)
+		if err != nil || action != tt.action {
+			t.Errorf("ParsePolicyAction(%q) = %d, %v, want %d", tt.name, action, err, tt.action)
+		}
+		name, err := PolicyActionName(tt.action)
+		if err != nil || name != tt.name {
+			t.Errorf("PolicyActionName(%d) = %q, %v, want %q", tt.action, name, err, tt.name)
+		}
+	}
+}
+
+func TestPolicyActionUnknown(t *testing.T) {
+	for _, name := range []string{"", "alow", "Allow", "drop"} {
+		if _, err := ParsePolicyAction(name); !errors.Is(err, ErrUnknownPolicyAction) {
+			t.Errorf("ParsePolicyAction(%q) error = %v", name, err)
+		}
+	}
+	for _, action := range []uint32{4, 255, 256} {
+		if _, err := PolicyActionName(action); !errors.Is(err, ErrUnknownPolicyAction) {
+			t.Errorf("PolicyActionName(%d) error = %v", action, err)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package proto
+
+import "net"
+
+// IP地址在proto bytes字段中的编码
+// 统一为16字节形式，IPv4使用IPv4映射地址(::ffff:a.b.c.d)，两端使用同一对函数编解码
+
+// EncodeIP 将IP编码为proto字节，nil或非法长度返回nil
+func EncodeIP(ip net.IP) []byte {
+	ip16 := ip.To16()
+	if ip16 == nil {
+		return nil
+	}
+	b := make([]byte, net.IPv6len)
+	copy(b, ip16)
+	return b
+}
+
+// DecodeIP 从proto字节解码IP
+// 兼容旧版Agent上报的4字节IPv4，IPv4映射地址解码为4字节形式，其他长度返回nil
+func DecodeIP(b []byte) net.IP {
+	switch len(b) {
+	case net.IPv4len, net.IPv6len:
+		ip := make(net.IP, len(b))
+		copy(ip, b)
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4
+		}
+		return ip
+	default:
+		return nil
+	}
+}
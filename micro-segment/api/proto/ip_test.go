@@ -0,0 +1,52 @@
+package proto
+
+import (
+	"net"
+	"testing"
+)
+
+func TestIPRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		ip   net.IP
+		want string
+		v4   bool
+	}{
+		{"ipv4 4-byte", net.IP{10, 0, 0, 1}, "10.0.0.1", true},
+		{"ipv4 16-byte", net.IPv4(10, 0, 0, 1), "10.0.0.1", true},
+		{"ipv4-mapped", net.ParseIP("::ffff:192.168.1.5"), "192.168.1.5", true},
+		{"ipv6", net.ParseIP("2001:db8::1"), "2001:db8::1", false},
+		{"ipv6 loopback", net.IPv6loopback, "::1", false},
+		{"ipv4-compatible", net.ParseIP("::10.0.0.1"), "::a00:1", false},
+	}
+	for _, tt := range tests {
+		b := EncodeIP(tt.ip)
+		if len(b) != net.IPv6len {
+			t.Errorf("%s: encoded length %d", tt.name, len(b))
+			continue
+		}
+		ip := DecodeIP(b)
+		if ip.String() != tt.want {
+			t.Errorf("%s: decoded %v, want %s", tt.name, ip, tt.want)
+		}
+		if (len(ip) == net.IPv4len) != tt.v4 {
+			t.Errorf("%s: decoded length %d", tt.name, len(ip))
+		}
+	}
+
+	// 旧版4字节编码
+	if ip := DecodeIP([]byte{10, 0, 0, 2}); ip.String() != "10.0.0.2" {
+		t.Errorf("Legacy IPv4 decoded %v", ip)
+	}
+	for _, b := range [][]byte{nil, {}, {1, 2, 3}, make([]byte, 5), make([]byte, 17)} {
+		if ip := DecodeIP(b); ip != nil {
+			t.Errorf("Invalid length %d decoded %v", len(b), ip)
+		}
+	}
+	if b := EncodeIP(nil); b != nil {
+		t.Errorf("nil encoded %v", b)
+	}
+	if b := EncodeIP(net.IP{1, 2, 3}); b != nil {
+		t.Errorf("Invalid IP encoded %v", b)
+	}
+}
@@ -179,6 +179,8 @@ type AgentInfo struct {
 	HostName      string                 `protobuf:"bytes,3,opt,name=host_name,json=hostName,proto3" json:"host_name,omitempty"`
 	Version       string                 `protobuf:"bytes,4,opt,name=version,proto3" json:"version,omitempty"`
 	Platform      string                 `protobuf:"bytes,5,opt,name=platform,proto3" json:"platform,omitempty"`
+	Workloads     []*Workload            `protobuf:"bytes,6,rep,name=workloads,proto3" json:"workloads,omitempty"`
+	FullSync      bool                   `protobuf:"varint,7,opt,name=full_sync,json=fullSync,proto3" json:"full_sync,omitempty"` // workloads为Agent当前完整的工作负载列表，Controller据此替换该Agent的工作负载
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -248,14 +250,29 @@ func (x *AgentInfo) GetPlatform() string {
 	return ""
 }
 
+func (x *AgentInfo) GetWorkloads() []*Workload {
+	if x != nil {
+		return x.Workloads
+	}
+	return nil
+}
+
+func (x *AgentInfo) GetFullSync() bool {
+	if x != nil {
+		return x.FullSync
+	}
+	return false
+}
+
 type RegisterResponse struct {
-	state          protoimpl.MessageState `protogen:"open.v1"`
-	Code           int32                  `protobuf:"varint,1,opt,name=code,proto3" json:"code,omitempty"`
-	Message        string                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
-	ClusterId      string                 `protobuf:"bytes,3,opt,name=cluster_id,json=clusterId,proto3" json:"cluster_id,omitempty"`
-	ReportInterval uint32                 `protobuf:"varint,4,opt,name=report_interval,json=reportInterval,proto3" json:"report_interval,omitempty"`
-	unknownFields  protoimpl.UnknownFields
-	sizeCache      protoimpl.SizeCache
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	Code             int32                  `protobuf:"varint,1,opt,name=code,proto3" json:"code,omitempty"`
+	Message          string                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	ClusterId        string                 `protobuf:"bytes,3,opt,name=cluster_id,json=clusterId,proto3" json:"cluster_id,omitempty"`
+	ReportInterval   uint32                 `protobuf:"varint,4,opt,name=report_interval,json=reportInterval,proto3" json:"report_interval,omitempty"`
+	HeartbeatTimeout uint32                 `protobuf:"varint,5,opt,name=heartbeat_timeout,json=heartbeatTimeout,proto3" json:"heartbeat_timeout,omitempty"` // Agent心跳超时（秒），超过此时间未收到心跳视为离线
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
 }
 
 func (x *RegisterResponse) Reset() {
@@ -316,13 +333,22 @@ func (x *RegisterResponse) GetReportInterval() uint32 {
 	return 0
 }
 
+func (x *RegisterResponse) GetHeartbeatTimeout() uint32 {
+	if x != nil {
+		return x.HeartbeatTimeout
+	}
+	return 0
+}
+
 type HeartbeatRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	AgentId       string                 `protobuf:"bytes,1,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
-	Timestamp     uint64                 `protobuf:"varint,2,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
-	Stats         *AgentStats            `protobuf:"bytes,3,opt,name=stats,proto3" json:"stats,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	state              protoimpl.MessageState `protogen:"open.v1"`
+	AgentId            string                 `protobuf:"bytes,1,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
+	Timestamp          uint64                 `protobuf:"varint,2,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	Stats              *AgentStats            `protobuf:"bytes,3,opt,name=stats,proto3" json:"stats,omitempty"`
+	Results            []*AgentCommandResult  `protobuf:"bytes,4,rep,name=results,proto3" json:"results,omitempty"`                                                 // 上次心跳后执行完成的命令结果
+	CapturedContainers []string               `protobuf:"bytes,5,rep,name=captured_containers,json=capturedContainers,proto3" json:"captured_containers,omitempty"` // 正在捕获流量的容器ID
+	unknownFields      protoimpl.UnknownFields
+	sizeCache          protoimpl.SizeCache
 }
 
 func (x *HeartbeatRequest) Reset() {
@@ -376,10 +402,25 @@ func (x *HeartbeatRequest) GetStats() *AgentStats {
 	return nil
 }
 
+func (x *HeartbeatRequest) GetResults() []*AgentCommandResult {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+func (x *HeartbeatRequest) GetCapturedContainers() []string {
+	if x != nil {
+		return x.CapturedContainers
+	}
+	return nil
+}
+
 type HeartbeatResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Code          int32                  `protobuf:"varint,1,opt,name=code,proto3" json:"code,omitempty"`
 	Timestamp     uint64                 `protobuf:"varint,2,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	Commands      []*AgentCommand        `protobuf:"bytes,3,rep,name=commands,proto3" json:"commands,omitempty"` // 待Agent执行的命令
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -428,6 +469,13 @@ func (x *HeartbeatResponse) GetTimestamp() uint64 {
 	return 0
 }
 
+func (x *HeartbeatResponse) GetCommands() []*AgentCommand {
+	if x != nil {
+		return x.Commands
+	}
+	return nil
+}
+
 type AgentStats struct {
 	state           protoimpl.MessageState `protogen:"open.v1"`
 	WorkloadCount   uint32                 `protobuf:"varint,1,opt,name=workload_count,json=workloadCount,proto3" json:"workload_count,omitempty"`
@@ -504,6 +552,126 @@ func (x *AgentStats) GetCpuUsage() float32 {
 	return 0
 }
 
+type Host struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Name          string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Platform      string                 `protobuf:"bytes,3,opt,name=platform,proto3" json:"platform,omitempty"`
+	Ifaces        []*NetworkInterface    `protobuf:"bytes,4,rep,name=ifaces,proto3" json:"ifaces,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Host) Reset() {
+	*x = Host{}
+	mi := &file_microseg_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Host) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Host) ProtoMessage() {}
+
+func (x *Host) ProtoReflect() protoreflect.Message {
+	mi := &file_microseg_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Host.ProtoReflect.Descriptor instead.
+func (*Host) Descriptor() ([]byte, []int) {
+	return file_microseg_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *Host) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Host) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Host) GetPlatform() string {
+	if x != nil {
+		return x.Platform
+	}
+	return ""
+}
+
+func (x *Host) GetIfaces() []*NetworkInterface {
+	if x != nil {
+		return x.Ifaces
+	}
+	return nil
+}
+
+type HostReport struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	AgentId       string                 `protobuf:"bytes,1,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
+	Host          *Host                  `protobuf:"bytes,2,opt,name=host,proto3" json:"host,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HostReport) Reset() {
+	*x = HostReport{}
+	mi := &file_microseg_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HostReport) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HostReport) ProtoMessage() {}
+
+func (x *HostReport) ProtoReflect() protoreflect.Message {
+	mi := &file_microseg_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HostReport.ProtoReflect.Descriptor instead.
+func (*HostReport) Descriptor() ([]byte, []int) {
+	return file_microseg_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *HostReport) GetAgentId() string {
+	if x != nil {
+		return x.AgentId
+	}
+	return ""
+}
+
+func (x *HostReport) GetHost() *Host {
+	if x != nil {
+		return x.Host
+	}
+	return nil
+}
+
 type AgentStatus struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	AgentId       string                 `protobuf:"bytes,1,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
@@ -519,7 +687,7 @@ type AgentStatus struct {
 
 func (x *AgentStatus) Reset() {
 	*x = AgentStatus{}
-	mi := &file_microseg_proto_msgTypes[8]
+	mi := &file_microseg_proto_msgTypes[10]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -531,7 +699,7 @@ func (x *AgentStatus) String() string {
 func (*AgentStatus) ProtoMessage() {}
 
 func (x *AgentStatus) ProtoReflect() protoreflect.Message {
-	mi := &file_microseg_proto_msgTypes[8]
+	mi := &file_microseg_proto_msgTypes[10]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -544,7 +712,7 @@ func (x *AgentStatus) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use AgentStatus.ProtoReflect.Descriptor instead.
 func (*AgentStatus) Descriptor() ([]byte, []int) {
-	return file_microseg_proto_rawDescGZIP(), []int{8}
+	return file_microseg_proto_rawDescGZIP(), []int{10}
 }
 
 func (x *AgentStatus) GetAgentId() string {
@@ -610,13 +778,18 @@ type Workload struct {
 	Pid           int32                  `protobuf:"varint,10,opt,name=pid,proto3" json:"pid,omitempty"`
 	Ifaces        []*NetworkInterface    `protobuf:"bytes,11,rep,name=ifaces,proto3" json:"ifaces,omitempty"`
 	Labels        map[string]string      `protobuf:"bytes,12,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	PodName       string                 `protobuf:"bytes,13,opt,name=pod_name,json=podName,proto3" json:"pod_name,omitempty"`       // K8s Pod名称，domain为其namespace
+	OwnerKind     string                 `protobuf:"bytes,14,opt,name=owner_kind,json=ownerKind,proto3" json:"owner_kind,omitempty"` // Pod所属控制器类型，如Deployment
+	OwnerName     string                 `protobuf:"bytes,15,opt,name=owner_name,json=ownerName,proto3" json:"owner_name,omitempty"` // Pod所属控制器名称
+	State         string                 `protobuf:"bytes,16,opt,name=state,proto3" json:"state,omitempty"`                          // 容器状态：created, running, paused, restarting, removing, exited, dead
+	Health        string                 `protobuf:"bytes,17,opt,name=health,proto3" json:"health,omitempty"`                        // 健康检查状态：starting, healthy, unhealthy，未配置时为空
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *Workload) Reset() {
 	*x = Workload{}
-	mi := &file_microseg_proto_msgTypes[9]
+	mi := &file_microseg_proto_msgTypes[11]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -628,7 +801,7 @@ func (x *Workload) String() string {
 func (*Workload) ProtoMessage() {}
 
 func (x *Workload) ProtoReflect() protoreflect.Message {
-	mi := &file_microseg_proto_msgTypes[9]
+	mi := &file_microseg_proto_msgTypes[11]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -641,7 +814,7 @@ func (x *Workload) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Workload.ProtoReflect.Descriptor instead.
 func (*Workload) Descriptor() ([]byte, []int) {
-	return file_microseg_proto_rawDescGZIP(), []int{9}
+	return file_microseg_proto_rawDescGZIP(), []int{11}
 }
 
 func (x *Workload) GetId() string {
@@ -728,6 +901,41 @@ func (x *Workload) GetLabels() map[string]string {
 	return nil
 }
 
+func (x *Workload) GetPodName() string {
+	if x != nil {
+		return x.PodName
+	}
+	return ""
+}
+
+func (x *Workload) GetOwnerKind() string {
+	if x != nil {
+		return x.OwnerKind
+	}
+	return ""
+}
+
+func (x *Workload) GetOwnerName() string {
+	if x != nil {
+		return x.OwnerName
+	}
+	return ""
+}
+
+func (x *Workload) GetState() string {
+	if x != nil {
+		return x.State
+	}
+	return ""
+}
+
+func (x *Workload) GetHealth() string {
+	if x != nil {
+		return x.Health
+	}
+	return ""
+}
+
 type NetworkInterface struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
@@ -739,7 +947,7 @@ type NetworkInterface struct {
 
 func (x *NetworkInterface) Reset() {
 	*x = NetworkInterface{}
-	mi := &file_microseg_proto_msgTypes[10]
+	mi := &file_microseg_proto_msgTypes[12]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -751,7 +959,7 @@ func (x *NetworkInterface) String() string {
 func (*NetworkInterface) ProtoMessage() {}
 
 func (x *NetworkInterface) ProtoReflect() protoreflect.Message {
-	mi := &file_microseg_proto_msgTypes[10]
+	mi := &file_microseg_proto_msgTypes[12]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -764,7 +972,7 @@ func (x *NetworkInterface) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use NetworkInterface.ProtoReflect.Descriptor instead.
 func (*NetworkInterface) Descriptor() ([]byte, []int) {
-	return file_microseg_proto_rawDescGZIP(), []int{10}
+	return file_microseg_proto_rawDescGZIP(), []int{12}
 }
 
 func (x *NetworkInterface) GetName() string {
@@ -799,7 +1007,7 @@ type IPAddress struct {
 
 func (x *IPAddress) Reset() {
 	*x = IPAddress{}
-	mi := &file_microseg_proto_msgTypes[11]
+	mi := &file_microseg_proto_msgTypes[13]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -811,7 +1019,7 @@ func (x *IPAddress) String() string {
 func (*IPAddress) ProtoMessage() {}
 
 func (x *IPAddress) ProtoReflect() protoreflect.Message {
-	mi := &file_microseg_proto_msgTypes[11]
+	mi := &file_microseg_proto_msgTypes[13]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -824,7 +1032,7 @@ func (x *IPAddress) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use IPAddress.ProtoReflect.Descriptor instead.
 func (*IPAddress) Descriptor() ([]byte, []int) {
-	return file_microseg_proto_rawDescGZIP(), []int{11}
+	return file_microseg_proto_rawDescGZIP(), []int{13}
 }
 
 func (x *IPAddress) GetIp() string {
@@ -857,7 +1065,7 @@ type WorkloadList struct {
 
 func (x *WorkloadList) Reset() {
 	*x = WorkloadList{}
-	mi := &file_microseg_proto_msgTypes[12]
+	mi := &file_microseg_proto_msgTypes[14]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -869,7 +1077,7 @@ func (x *WorkloadList) String() string {
 func (*WorkloadList) ProtoMessage() {}
 
 func (x *WorkloadList) ProtoReflect() protoreflect.Message {
-	mi := &file_microseg_proto_msgTypes[12]
+	mi := &file_microseg_proto_msgTypes[14]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -882,7 +1090,7 @@ func (x *WorkloadList) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use WorkloadList.ProtoReflect.Descriptor instead.
 func (*WorkloadList) Descriptor() ([]byte, []int) {
-	return file_microseg_proto_rawDescGZIP(), []int{12}
+	return file_microseg_proto_rawDescGZIP(), []int{14}
 }
 
 func (x *WorkloadList) GetWorkloads() []*Workload {
@@ -903,7 +1111,7 @@ type WorkloadEvent struct {
 
 func (x *WorkloadEvent) Reset() {
 	*x = WorkloadEvent{}
-	mi := &file_microseg_proto_msgTypes[13]
+	mi := &file_microseg_proto_msgTypes[15]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -915,7 +1123,7 @@ func (x *WorkloadEvent) String() string {
 func (*WorkloadEvent) ProtoMessage() {}
 
 func (x *WorkloadEvent) ProtoReflect() protoreflect.Message {
-	mi := &file_microseg_proto_msgTypes[13]
+	mi := &file_microseg_proto_msgTypes[15]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -928,7 +1136,7 @@ func (x *WorkloadEvent) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use WorkloadEvent.ProtoReflect.Descriptor instead.
 func (*WorkloadEvent) Descriptor() ([]byte, []int) {
-	return file_microseg_proto_rawDescGZIP(), []int{13}
+	return file_microseg_proto_rawDescGZIP(), []int{15}
 }
 
 func (x *WorkloadEvent) GetAgentId() string {
@@ -953,36 +1161,40 @@ func (x *WorkloadEvent) GetWorkload() *Workload {
 }
 
 type Connection struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	ClientWl      string                 `protobuf:"bytes,1,opt,name=client_wl,json=clientWl,proto3" json:"client_wl,omitempty"`
-	ServerWl      string                 `protobuf:"bytes,2,opt,name=server_wl,json=serverWl,proto3" json:"server_wl,omitempty"`
-	ClientIp      []byte                 `protobuf:"bytes,3,opt,name=client_ip,json=clientIp,proto3" json:"client_ip,omitempty"`
-	ServerIp      []byte                 `protobuf:"bytes,4,opt,name=server_ip,json=serverIp,proto3" json:"server_ip,omitempty"`
-	ClientPort    uint32                 `protobuf:"varint,5,opt,name=client_port,json=clientPort,proto3" json:"client_port,omitempty"`
-	ServerPort    uint32                 `protobuf:"varint,6,opt,name=server_port,json=serverPort,proto3" json:"server_port,omitempty"`
-	IpProto       uint32                 `protobuf:"varint,7,opt,name=ip_proto,json=ipProto,proto3" json:"ip_proto,omitempty"`
-	Application   uint32                 `protobuf:"varint,8,opt,name=application,proto3" json:"application,omitempty"`
-	Bytes         uint64                 `protobuf:"varint,9,opt,name=bytes,proto3" json:"bytes,omitempty"`
-	Sessions      uint32                 `protobuf:"varint,10,opt,name=sessions,proto3" json:"sessions,omitempty"`
-	FirstSeenAt   uint32                 `protobuf:"varint,11,opt,name=first_seen_at,json=firstSeenAt,proto3" json:"first_seen_at,omitempty"`
-	LastSeenAt    uint32                 `protobuf:"varint,12,opt,name=last_seen_at,json=lastSeenAt,proto3" json:"last_seen_at,omitempty"`
-	ThreatId      uint32                 `protobuf:"varint,13,opt,name=threat_id,json=threatId,proto3" json:"threat_id,omitempty"`
-	Severity      uint32                 `protobuf:"varint,14,opt,name=severity,proto3" json:"severity,omitempty"`
-	PolicyAction  uint32                 `protobuf:"varint,15,opt,name=policy_action,json=policyAction,proto3" json:"policy_action,omitempty"`
-	PolicyId      uint32                 `protobuf:"varint,16,opt,name=policy_id,json=policyId,proto3" json:"policy_id,omitempty"`
-	Ingress       bool                   `protobuf:"varint,17,opt,name=ingress,proto3" json:"ingress,omitempty"`
-	ExternalPeer  bool                   `protobuf:"varint,18,opt,name=external_peer,json=externalPeer,proto3" json:"external_peer,omitempty"`
-	LocalPeer     bool                   `protobuf:"varint,19,opt,name=local_peer,json=localPeer,proto3" json:"local_peer,omitempty"`
-	Scope         string                 `protobuf:"bytes,20,opt,name=scope,proto3" json:"scope,omitempty"`
-	Network       string                 `protobuf:"bytes,21,opt,name=network,proto3" json:"network,omitempty"`
-	Violates      uint32                 `protobuf:"varint,22,opt,name=violates,proto3" json:"violates,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	ClientWl        string                 `protobuf:"bytes,1,opt,name=client_wl,json=clientWl,proto3" json:"client_wl,omitempty"`
+	ServerWl        string                 `protobuf:"bytes,2,opt,name=server_wl,json=serverWl,proto3" json:"server_wl,omitempty"`
+	ClientIp        []byte                 `protobuf:"bytes,3,opt,name=client_ip,json=clientIp,proto3" json:"client_ip,omitempty"`
+	ServerIp        []byte                 `protobuf:"bytes,4,opt,name=server_ip,json=serverIp,proto3" json:"server_ip,omitempty"`
+	ClientPort      uint32                 `protobuf:"varint,5,opt,name=client_port,json=clientPort,proto3" json:"client_port,omitempty"`
+	ServerPort      uint32                 `protobuf:"varint,6,opt,name=server_port,json=serverPort,proto3" json:"server_port,omitempty"`
+	IpProto         uint32                 `protobuf:"varint,7,opt,name=ip_proto,json=ipProto,proto3" json:"ip_proto,omitempty"`
+	Application     uint32                 `protobuf:"varint,8,opt,name=application,proto3" json:"application,omitempty"`
+	Bytes           uint64                 `protobuf:"varint,9,opt,name=bytes,proto3" json:"bytes,omitempty"`
+	Sessions        uint32                 `protobuf:"varint,10,opt,name=sessions,proto3" json:"sessions,omitempty"`
+	FirstSeenAt     uint32                 `protobuf:"varint,11,opt,name=first_seen_at,json=firstSeenAt,proto3" json:"first_seen_at,omitempty"`
+	LastSeenAt      uint32                 `protobuf:"varint,12,opt,name=last_seen_at,json=lastSeenAt,proto3" json:"last_seen_at,omitempty"`
+	ThreatId        uint32                 `protobuf:"varint,13,opt,name=threat_id,json=threatId,proto3" json:"threat_id,omitempty"`
+	Severity        uint32                 `protobuf:"varint,14,opt,name=severity,proto3" json:"severity,omitempty"`
+	PolicyAction    uint32                 `protobuf:"varint,15,opt,name=policy_action,json=policyAction,proto3" json:"policy_action,omitempty"`
+	PolicyId        uint32                 `protobuf:"varint,16,opt,name=policy_id,json=policyId,proto3" json:"policy_id,omitempty"`
+	Ingress         bool                   `protobuf:"varint,17,opt,name=ingress,proto3" json:"ingress,omitempty"`
+	ExternalPeer    bool                   `protobuf:"varint,18,opt,name=external_peer,json=externalPeer,proto3" json:"external_peer,omitempty"`
+	LocalPeer       bool                   `protobuf:"varint,19,opt,name=local_peer,json=localPeer,proto3" json:"local_peer,omitempty"`
+	Scope           string                 `protobuf:"bytes,20,opt,name=scope,proto3" json:"scope,omitempty"`
+	Network         string                 `protobuf:"bytes,21,opt,name=network,proto3" json:"network,omitempty"`
+	Violates        uint32                 `protobuf:"varint,22,opt,name=violates,proto3" json:"violates,omitempty"`
+	L7              []*L7Metadata          `protobuf:"bytes,23,rep,name=l7,proto3" json:"l7,omitempty"`                                                   // 应用层元数据，不参与连接标识
+	Capped          bool                   `protobuf:"varint,24,opt,name=capped,proto3" json:"capped,omitempty"`                                          // sessions/violates已饱和
+	Summary         bool                   `protobuf:"varint,25,opt,name=summary,proto3" json:"summary,omitempty"`                                        // 容量超限时按端点对合并的低优先级流量汇总，不含端口和协议
+	InternetIngress bool                   `protobuf:"varint,26,opt,name=internet_ingress,json=internetIngress,proto3" json:"internet_ingress,omitempty"` // 客户端为公网地址、服务端为内部地址
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
 }
 
 func (x *Connection) Reset() {
 	*x = Connection{}
-	mi := &file_microseg_proto_msgTypes[14]
+	mi := &file_microseg_proto_msgTypes[16]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -994,7 +1206,7 @@ func (x *Connection) String() string {
 func (*Connection) ProtoMessage() {}
 
 func (x *Connection) ProtoReflect() protoreflect.Message {
-	mi := &file_microseg_proto_msgTypes[14]
+	mi := &file_microseg_proto_msgTypes[16]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1007,7 +1219,7 @@ func (x *Connection) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Connection.ProtoReflect.Descriptor instead.
 func (*Connection) Descriptor() ([]byte, []int) {
-	return file_microseg_proto_rawDescGZIP(), []int{14}
+	return file_microseg_proto_rawDescGZIP(), []int{16}
 }
 
 func (x *Connection) GetClientWl() string {
@@ -1164,6 +1376,102 @@ func (x *Connection) GetViolates() uint32 {
 	return 0
 }
 
+func (x *Connection) GetL7() []*L7Metadata {
+	if x != nil {
+		return x.L7
+	}
+	return nil
+}
+
+func (x *Connection) GetCapped() bool {
+	if x != nil {
+		return x.Capped
+	}
+	return false
+}
+
+func (x *Connection) GetSummary() bool {
+	if x != nil {
+		return x.Summary
+	}
+	return false
+}
+
+func (x *Connection) GetInternetIngress() bool {
+	if x != nil {
+		return x.InternetIngress
+	}
+	return false
+}
+
+type L7Metadata struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	HttpMethod    string                 `protobuf:"bytes,1,opt,name=http_method,json=httpMethod,proto3" json:"http_method,omitempty"`
+	HttpHost      string                 `protobuf:"bytes,2,opt,name=http_host,json=httpHost,proto3" json:"http_host,omitempty"`
+	DnsQuery      string                 `protobuf:"bytes,3,opt,name=dns_query,json=dnsQuery,proto3" json:"dns_query,omitempty"`
+	TlsSni        string                 `protobuf:"bytes,4,opt,name=tls_sni,json=tlsSni,proto3" json:"tls_sni,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *L7Metadata) Reset() {
+	*x = L7Metadata{}
+	mi := &file_microseg_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *L7Metadata) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*L7Metadata) ProtoMessage() {}
+
+func (x *L7Metadata) ProtoReflect() protoreflect.Message {
+	mi := &file_microseg_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use L7Metadata.ProtoReflect.Descriptor instead.
+func (*L7Metadata) Descriptor() ([]byte, []int) {
+	return file_microseg_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *L7Metadata) GetHttpMethod() string {
+	if x != nil {
+		return x.HttpMethod
+	}
+	return ""
+}
+
+func (x *L7Metadata) GetHttpHost() string {
+	if x != nil {
+		return x.HttpHost
+	}
+	return ""
+}
+
+func (x *L7Metadata) GetDnsQuery() string {
+	if x != nil {
+		return x.DnsQuery
+	}
+	return ""
+}
+
+func (x *L7Metadata) GetTlsSni() string {
+	if x != nil {
+		return x.TlsSni
+	}
+	return ""
+}
+
 type ConnectionReport struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	AgentId       string                 `protobuf:"bytes,1,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
@@ -1175,7 +1483,7 @@ type ConnectionReport struct {
 
 func (x *ConnectionReport) Reset() {
 	*x = ConnectionReport{}
-	mi := &file_microseg_proto_msgTypes[15]
+	mi := &file_microseg_proto_msgTypes[18]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1187,7 +1495,7 @@ func (x *ConnectionReport) String() string {
 func (*ConnectionReport) ProtoMessage() {}
 
 func (x *ConnectionReport) ProtoReflect() protoreflect.Message {
-	mi := &file_microseg_proto_msgTypes[15]
+	mi := &file_microseg_proto_msgTypes[18]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1200,7 +1508,7 @@ func (x *ConnectionReport) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ConnectionReport.ProtoReflect.Descriptor instead.
 func (*ConnectionReport) Descriptor() ([]byte, []int) {
-	return file_microseg_proto_rawDescGZIP(), []int{15}
+	return file_microseg_proto_rawDescGZIP(), []int{18}
 }
 
 func (x *ConnectionReport) GetAgentId() string {
@@ -1239,13 +1547,16 @@ type ThreatLog struct {
 	PktIngress    bool                   `protobuf:"varint,11,opt,name=pkt_ingress,json=pktIngress,proto3" json:"pkt_ingress,omitempty"`
 	LocalPeer     bool                   `protobuf:"varint,12,opt,name=local_peer,json=localPeer,proto3" json:"local_peer,omitempty"`
 	ReportedAt    uint64                 `protobuf:"varint,13,opt,name=reported_at,json=reportedAt,proto3" json:"reported_at,omitempty"`
+	TcpFlags      uint32                 `protobuf:"varint,14,opt,name=tcp_flags,json=tcpFlags,proto3" json:"tcp_flags,omitempty"`
+	PktLen        uint32                 `protobuf:"varint,15,opt,name=pkt_len,json=pktLen,proto3" json:"pkt_len,omitempty"`
+	PktSummary    string                 `protobuf:"bytes,16,opt,name=pkt_summary,json=pktSummary,proto3" json:"pkt_summary,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *ThreatLog) Reset() {
 	*x = ThreatLog{}
-	mi := &file_microseg_proto_msgTypes[16]
+	mi := &file_microseg_proto_msgTypes[19]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1257,7 +1568,7 @@ func (x *ThreatLog) String() string {
 func (*ThreatLog) ProtoMessage() {}
 
 func (x *ThreatLog) ProtoReflect() protoreflect.Message {
-	mi := &file_microseg_proto_msgTypes[16]
+	mi := &file_microseg_proto_msgTypes[19]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1270,7 +1581,7 @@ func (x *ThreatLog) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ThreatLog.ProtoReflect.Descriptor instead.
 func (*ThreatLog) Descriptor() ([]byte, []int) {
-	return file_microseg_proto_rawDescGZIP(), []int{16}
+	return file_microseg_proto_rawDescGZIP(), []int{19}
 }
 
 func (x *ThreatLog) GetId() string {
@@ -1364,6 +1675,27 @@ func (x *ThreatLog) GetReportedAt() uint64 {
 	return 0
 }
 
+func (x *ThreatLog) GetTcpFlags() uint32 {
+	if x != nil {
+		return x.TcpFlags
+	}
+	return 0
+}
+
+func (x *ThreatLog) GetPktLen() uint32 {
+	if x != nil {
+		return x.PktLen
+	}
+	return 0
+}
+
+func (x *ThreatLog) GetPktSummary() string {
+	if x != nil {
+		return x.PktSummary
+	}
+	return ""
+}
+
 type ThreatReport struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	AgentId       string                 `protobuf:"bytes,1,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
@@ -1375,7 +1707,7 @@ type ThreatReport struct {
 
 func (x *ThreatReport) Reset() {
 	*x = ThreatReport{}
-	mi := &file_microseg_proto_msgTypes[17]
+	mi := &file_microseg_proto_msgTypes[20]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1387,7 +1719,7 @@ func (x *ThreatReport) String() string {
 func (*ThreatReport) ProtoMessage() {}
 
 func (x *ThreatReport) ProtoReflect() protoreflect.Message {
-	mi := &file_microseg_proto_msgTypes[17]
+	mi := &file_microseg_proto_msgTypes[20]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1400,7 +1732,7 @@ func (x *ThreatReport) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ThreatReport.ProtoReflect.Descriptor instead.
 func (*ThreatReport) Descriptor() ([]byte, []int) {
-	return file_microseg_proto_rawDescGZIP(), []int{17}
+	return file_microseg_proto_rawDescGZIP(), []int{20}
 }
 
 func (x *ThreatReport) GetAgentId() string {
@@ -1436,13 +1768,14 @@ type PolicyRule struct {
 	Priority      uint32                 `protobuf:"varint,8,opt,name=priority,proto3" json:"priority,omitempty"`
 	Disable       bool                   `protobuf:"varint,9,opt,name=disable,proto3" json:"disable,omitempty"`
 	Comment       string                 `protobuf:"bytes,10,opt,name=comment,proto3" json:"comment,omitempty"`
+	Log           bool                   `protobuf:"varint,11,opt,name=log,proto3" json:"log,omitempty"` // 命中时记录审计，不影响动作
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *PolicyRule) Reset() {
 	*x = PolicyRule{}
-	mi := &file_microseg_proto_msgTypes[18]
+	mi := &file_microseg_proto_msgTypes[21]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1454,7 +1787,7 @@ func (x *PolicyRule) String() string {
 func (*PolicyRule) ProtoMessage() {}
 
 func (x *PolicyRule) ProtoReflect() protoreflect.Message {
-	mi := &file_microseg_proto_msgTypes[18]
+	mi := &file_microseg_proto_msgTypes[21]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1467,7 +1800,7 @@ func (x *PolicyRule) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use PolicyRule.ProtoReflect.Descriptor instead.
 func (*PolicyRule) Descriptor() ([]byte, []int) {
-	return file_microseg_proto_rawDescGZIP(), []int{18}
+	return file_microseg_proto_rawDescGZIP(), []int{21}
 }
 
 func (x *PolicyRule) GetId() uint32 {
@@ -1540,6 +1873,13 @@ func (x *PolicyRule) GetComment() string {
 	return ""
 }
 
+func (x *PolicyRule) GetLog() bool {
+	if x != nil {
+		return x.Log
+	}
+	return false
+}
+
 type IPRule struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Id            uint32                 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
@@ -1558,7 +1898,7 @@ type IPRule struct {
 
 func (x *IPRule) Reset() {
 	*x = IPRule{}
-	mi := &file_microseg_proto_msgTypes[19]
+	mi := &file_microseg_proto_msgTypes[22]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1570,7 +1910,7 @@ func (x *IPRule) String() string {
 func (*IPRule) ProtoMessage() {}
 
 func (x *IPRule) ProtoReflect() protoreflect.Message {
-	mi := &file_microseg_proto_msgTypes[19]
+	mi := &file_microseg_proto_msgTypes[22]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1583,7 +1923,7 @@ func (x *IPRule) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use IPRule.ProtoReflect.Descriptor instead.
 func (*IPRule) Descriptor() ([]byte, []int) {
-	return file_microseg_proto_rawDescGZIP(), []int{19}
+	return file_microseg_proto_rawDescGZIP(), []int{22}
 }
 
 func (x *IPRule) GetId() uint32 {
@@ -1670,7 +2010,7 @@ type PolicyConfig struct {
 
 func (x *PolicyConfig) Reset() {
 	*x = PolicyConfig{}
-	mi := &file_microseg_proto_msgTypes[20]
+	mi := &file_microseg_proto_msgTypes[23]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1682,7 +2022,7 @@ func (x *PolicyConfig) String() string {
 func (*PolicyConfig) ProtoMessage() {}
 
 func (x *PolicyConfig) ProtoReflect() protoreflect.Message {
-	mi := &file_microseg_proto_msgTypes[20]
+	mi := &file_microseg_proto_msgTypes[23]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1695,7 +2035,7 @@ func (x *PolicyConfig) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use PolicyConfig.ProtoReflect.Descriptor instead.
 func (*PolicyConfig) Descriptor() ([]byte, []int) {
-	return file_microseg_proto_rawDescGZIP(), []int{20}
+	return file_microseg_proto_rawDescGZIP(), []int{23}
 }
 
 func (x *PolicyConfig) GetWorkloadId() string {
@@ -1743,13 +2083,14 @@ func (x *PolicyConfig) GetRules() []*IPRule {
 type PolicyList struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Rules         []*PolicyRule          `protobuf:"bytes,1,rep,name=rules,proto3" json:"rules,omitempty"`
+	WorkloadModes []*WorkloadMode        `protobuf:"bytes,2,rep,name=workload_modes,json=workloadModes,proto3" json:"workload_modes,omitempty"` // 订阅Agent各工作负载生效的策略模式
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *PolicyList) Reset() {
 	*x = PolicyList{}
-	mi := &file_microseg_proto_msgTypes[21]
+	mi := &file_microseg_proto_msgTypes[24]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1761,7 +2102,7 @@ func (x *PolicyList) String() string {
 func (*PolicyList) ProtoMessage() {}
 
 func (x *PolicyList) ProtoReflect() protoreflect.Message {
-	mi := &file_microseg_proto_msgTypes[21]
+	mi := &file_microseg_proto_msgTypes[24]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1774,7 +2115,7 @@ func (x *PolicyList) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use PolicyList.ProtoReflect.Descriptor instead.
 func (*PolicyList) Descriptor() ([]byte, []int) {
-	return file_microseg_proto_rawDescGZIP(), []int{21}
+	return file_microseg_proto_rawDescGZIP(), []int{24}
 }
 
 func (x *PolicyList) GetRules() []*PolicyRule {
@@ -1784,6 +2125,13 @@ func (x *PolicyList) GetRules() []*PolicyRule {
 	return nil
 }
 
+func (x *PolicyList) GetWorkloadModes() []*WorkloadMode {
+	if x != nil {
+		return x.WorkloadModes
+	}
+	return nil
+}
+
 type PolicyRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	AgentId       string                 `protobuf:"bytes,1,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
@@ -1794,7 +2142,7 @@ type PolicyRequest struct {
 
 func (x *PolicyRequest) Reset() {
 	*x = PolicyRequest{}
-	mi := &file_microseg_proto_msgTypes[22]
+	mi := &file_microseg_proto_msgTypes[25]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1806,7 +2154,7 @@ func (x *PolicyRequest) String() string {
 func (*PolicyRequest) ProtoMessage() {}
 
 func (x *PolicyRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_microseg_proto_msgTypes[22]
+	mi := &file_microseg_proto_msgTypes[25]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1819,7 +2167,7 @@ func (x *PolicyRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use PolicyRequest.ProtoReflect.Descriptor instead.
 func (*PolicyRequest) Descriptor() ([]byte, []int) {
-	return file_microseg_proto_rawDescGZIP(), []int{22}
+	return file_microseg_proto_rawDescGZIP(), []int{25}
 }
 
 func (x *PolicyRequest) GetAgentId() string {
@@ -1846,7 +2194,7 @@ type GroupModeConfig struct {
 
 func (x *GroupModeConfig) Reset() {
 	*x = GroupModeConfig{}
-	mi := &file_microseg_proto_msgTypes[23]
+	mi := &file_microseg_proto_msgTypes[26]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1858,7 +2206,7 @@ func (x *GroupModeConfig) String() string {
 func (*GroupModeConfig) ProtoMessage() {}
 
 func (x *GroupModeConfig) ProtoReflect() protoreflect.Message {
-	mi := &file_microseg_proto_msgTypes[23]
+	mi := &file_microseg_proto_msgTypes[26]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1871,7 +2219,7 @@ func (x *GroupModeConfig) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use GroupModeConfig.ProtoReflect.Descriptor instead.
 func (*GroupModeConfig) Descriptor() ([]byte, []int) {
-	return file_microseg_proto_rawDescGZIP(), []int{23}
+	return file_microseg_proto_rawDescGZIP(), []int{26}
 }
 
 func (x *GroupModeConfig) GetGroupName() string {
@@ -1899,7 +2247,7 @@ type Subnet struct {
 
 func (x *Subnet) Reset() {
 	*x = Subnet{}
-	mi := &file_microseg_proto_msgTypes[24]
+	mi := &file_microseg_proto_msgTypes[27]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1911,7 +2259,7 @@ func (x *Subnet) String() string {
 func (*Subnet) ProtoMessage() {}
 
 func (x *Subnet) ProtoReflect() protoreflect.Message {
-	mi := &file_microseg_proto_msgTypes[24]
+	mi := &file_microseg_proto_msgTypes[27]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1924,7 +2272,7 @@ func (x *Subnet) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Subnet.ProtoReflect.Descriptor instead.
 func (*Subnet) Descriptor() ([]byte, []int) {
-	return file_microseg_proto_rawDescGZIP(), []int{24}
+	return file_microseg_proto_rawDescGZIP(), []int{27}
 }
 
 func (x *Subnet) GetIp() []byte {
@@ -1957,7 +2305,7 @@ type SubnetConfig struct {
 
 func (x *SubnetConfig) Reset() {
 	*x = SubnetConfig{}
-	mi := &file_microseg_proto_msgTypes[25]
+	mi := &file_microseg_proto_msgTypes[28]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1969,7 +2317,7 @@ func (x *SubnetConfig) String() string {
 func (*SubnetConfig) ProtoMessage() {}
 
 func (x *SubnetConfig) ProtoReflect() protoreflect.Message {
-	mi := &file_microseg_proto_msgTypes[25]
+	mi := &file_microseg_proto_msgTypes[28]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1982,7 +2330,7 @@ func (x *SubnetConfig) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use SubnetConfig.ProtoReflect.Descriptor instead.
 func (*SubnetConfig) Descriptor() ([]byte, []int) {
-	return file_microseg_proto_rawDescGZIP(), []int{25}
+	return file_microseg_proto_rawDescGZIP(), []int{28}
 }
 
 func (x *SubnetConfig) GetSubnets() []*Subnet {
@@ -1992,6 +2340,187 @@ func (x *SubnetConfig) GetSubnets() []*Subnet {
 	return nil
 }
 
+// Agent命令，随心跳响应下发，Agent执行后在下次心跳中回报结果
+type AgentCommand struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Type          string                 `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`                                  // force-capture, force-stop, get-debug
+	ContainerId   string                 `protobuf:"bytes,3,opt,name=container_id,json=containerId,proto3" json:"container_id,omitempty"` // force-capture/force-stop的目标容器
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AgentCommand) Reset() {
+	*x = AgentCommand{}
+	mi := &file_microseg_proto_msgTypes[29]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AgentCommand) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AgentCommand) ProtoMessage() {}
+
+func (x *AgentCommand) ProtoReflect() protoreflect.Message {
+	mi := &file_microseg_proto_msgTypes[29]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AgentCommand.ProtoReflect.Descriptor instead.
+func (*AgentCommand) Descriptor() ([]byte, []int) {
+	return file_microseg_proto_rawDescGZIP(), []int{29}
+}
+
+func (x *AgentCommand) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *AgentCommand) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *AgentCommand) GetContainerId() string {
+	if x != nil {
+		return x.ContainerId
+	}
+	return ""
+}
+
+type AgentCommandResult struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Success       bool                   `protobuf:"varint,2,opt,name=success,proto3" json:"success,omitempty"`
+	Error         string                 `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`
+	Output        string                 `protobuf:"bytes,4,opt,name=output,proto3" json:"output,omitempty"` // get-debug返回的JSON
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AgentCommandResult) Reset() {
+	*x = AgentCommandResult{}
+	mi := &file_microseg_proto_msgTypes[30]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AgentCommandResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AgentCommandResult) ProtoMessage() {}
+
+func (x *AgentCommandResult) ProtoReflect() protoreflect.Message {
+	mi := &file_microseg_proto_msgTypes[30]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AgentCommandResult.ProtoReflect.Descriptor instead.
+func (*AgentCommandResult) Descriptor() ([]byte, []int) {
+	return file_microseg_proto_rawDescGZIP(), []int{30}
+}
+
+func (x *AgentCommandResult) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *AgentCommandResult) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *AgentCommandResult) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *AgentCommandResult) GetOutput() string {
+	if x != nil {
+		return x.Output
+	}
+	return ""
+}
+
+type WorkloadMode struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	WorkloadId    string                 `protobuf:"bytes,1,opt,name=workload_id,json=workloadId,proto3" json:"workload_id,omitempty"`
+	Mode          string                 `protobuf:"bytes,2,opt,name=mode,proto3" json:"mode,omitempty"` // Monitor, Protect
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WorkloadMode) Reset() {
+	*x = WorkloadMode{}
+	mi := &file_microseg_proto_msgTypes[31]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WorkloadMode) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WorkloadMode) ProtoMessage() {}
+
+func (x *WorkloadMode) ProtoReflect() protoreflect.Message {
+	mi := &file_microseg_proto_msgTypes[31]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WorkloadMode.ProtoReflect.Descriptor instead.
+func (*WorkloadMode) Descriptor() ([]byte, []int) {
+	return file_microseg_proto_rawDescGZIP(), []int{31}
+}
+
+func (x *WorkloadMode) GetWorkloadId() string {
+	if x != nil {
+		return x.WorkloadId
+	}
+	return ""
+}
+
+func (x *WorkloadMode) GetMode() string {
+	if x != nil {
+		return x.Mode
+	}
+	return ""
+}
+
 var File_microseg_proto protoreflect.FileDescriptor
 
 const file_microseg_proto_rawDesc = "" +
@@ -2004,33 +2533,48 @@ const file_microseg_proto_rawDesc = "" +
 	"\x0eReportResponse\x12\x12\n" +
 	"\x04code\x18\x01 \x01(\x05R\x04code\x12\x18\n" +
 	"\amessage\x18\x02 \x01(\tR\amessage\x12'\n" +
-	"\x0freport_interval\x18\x03 \x01(\rR\x0ereportInterval\"\x92\x01\n" +
+	"\x0freport_interval\x18\x03 \x01(\rR\x0ereportInterval\"\xe1\x01\n" +
 	"\tAgentInfo\x12\x19\n" +
 	"\bagent_id\x18\x01 \x01(\tR\aagentId\x12\x17\n" +
 	"\ahost_id\x18\x02 \x01(\tR\x06hostId\x12\x1b\n" +
 	"\thost_name\x18\x03 \x01(\tR\bhostName\x12\x18\n" +
 	"\aversion\x18\x04 \x01(\tR\aversion\x12\x1a\n" +
-	"\bplatform\x18\x05 \x01(\tR\bplatform\"\x88\x01\n" +
+	"\bplatform\x18\x05 \x01(\tR\bplatform\x120\n" +
+	"\tworkloads\x18\x06 \x03(\v2\x12.microseg.WorkloadR\tworkloads\x12\x1b\n" +
+	"\tfull_sync\x18\a \x01(\bR\bfullSync\"\xb5\x01\n" +
 	"\x10RegisterResponse\x12\x12\n" +
 	"\x04code\x18\x01 \x01(\x05R\x04code\x12\x18\n" +
 	"\amessage\x18\x02 \x01(\tR\amessage\x12\x1d\n" +
 	"\n" +
 	"cluster_id\x18\x03 \x01(\tR\tclusterId\x12'\n" +
-	"\x0freport_interval\x18\x04 \x01(\rR\x0ereportInterval\"w\n" +
+	"\x0freport_interval\x18\x04 \x01(\rR\x0ereportInterval\x12+\n" +
+	"\x11heartbeat_timeout\x18\x05 \x01(\rR\x10heartbeatTimeout\"\xe0\x01\n" +
 	"\x10HeartbeatRequest\x12\x19\n" +
 	"\bagent_id\x18\x01 \x01(\tR\aagentId\x12\x1c\n" +
 	"\ttimestamp\x18\x02 \x01(\x04R\ttimestamp\x12*\n" +
-	"\x05stats\x18\x03 \x01(\v2\x14.microseg.AgentStatsR\x05stats\"E\n" +
+	"\x05stats\x18\x03 \x01(\v2\x14.microseg.AgentStatsR\x05stats\x126\n" +
+	"\aresults\x18\x04 \x03(\v2\x1c.microseg.AgentCommandResultR\aresults\x12/\n" +
+	"\x13captured_containers\x18\x05 \x03(\tR\x12capturedContainers\"y\n" +
 	"\x11HeartbeatResponse\x12\x12\n" +
 	"\x04code\x18\x01 \x01(\x05R\x04code\x12\x1c\n" +
-	"\ttimestamp\x18\x02 \x01(\x04R\ttimestamp\"\xc1\x01\n" +
+	"\ttimestamp\x18\x02 \x01(\x04R\ttimestamp\x122\n" +
+	"\bcommands\x18\x03 \x03(\v2\x16.microseg.AgentCommandR\bcommands\"\xc1\x01\n" +
 	"\n" +
 	"AgentStats\x12%\n" +
 	"\x0eworkload_count\x18\x01 \x01(\rR\rworkloadCount\x12)\n" +
 	"\x10connection_count\x18\x02 \x01(\rR\x0fconnectionCount\x12!\n" +
 	"\fpolicy_count\x18\x03 \x01(\rR\vpolicyCount\x12!\n" +
 	"\fmemory_usage\x18\x04 \x01(\x04R\vmemoryUsage\x12\x1b\n" +
-	"\tcpu_usage\x18\x05 \x01(\x02R\bcpuUsage\"\xe8\x01\n" +
+	"\tcpu_usage\x18\x05 \x01(\x02R\bcpuUsage\"z\n" +
+	"\x04Host\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x1a\n" +
+	"\bplatform\x18\x03 \x01(\tR\bplatform\x122\n" +
+	"\x06ifaces\x18\x04 \x03(\v2\x1a.microseg.NetworkInterfaceR\x06ifaces\"K\n" +
+	"\n" +
+	"HostReport\x12\x19\n" +
+	"\bagent_id\x18\x01 \x01(\tR\aagentId\x12\"\n" +
+	"\x04host\x18\x02 \x01(\v2\x0e.microseg.HostR\x04host\"\xe8\x01\n" +
 	"\vAgentStatus\x12\x19\n" +
 	"\bagent_id\x18\x01 \x01(\tR\aagentId\x12\x17\n" +
 	"\ahost_id\x18\x02 \x01(\tR\x06hostId\x12\x1b\n" +
@@ -2039,7 +2583,7 @@ const file_microseg_proto_rawDesc = "" +
 	"\fdp_connected\x18\x05 \x01(\bR\vdpConnected\x12\x1f\n" +
 	"\vpolicy_mode\x18\x06 \x01(\tR\n" +
 	"policyMode\x12*\n" +
-	"\x05stats\x18\a \x01(\v2\x14.microseg.AgentStatsR\x05stats\"\xa0\x03\n" +
+	"\x05stats\x18\a \x01(\v2\x14.microseg.AgentStatsR\x05stats\"\xa7\x04\n" +
 	"\bWorkload\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
 	"\x04name\x18\x02 \x01(\tR\x04name\x12\x17\n" +
@@ -2054,7 +2598,14 @@ const file_microseg_proto_rawDesc = "" +
 	"\x03pid\x18\n" +
 	" \x01(\x05R\x03pid\x122\n" +
 	"\x06ifaces\x18\v \x03(\v2\x1a.microseg.NetworkInterfaceR\x06ifaces\x126\n" +
-	"\x06labels\x18\f \x03(\v2\x1e.microseg.Workload.LabelsEntryR\x06labels\x1a9\n" +
+	"\x06labels\x18\f \x03(\v2\x1e.microseg.Workload.LabelsEntryR\x06labels\x12\x19\n" +
+	"\bpod_name\x18\r \x01(\tR\apodName\x12\x1d\n" +
+	"\n" +
+	"owner_kind\x18\x0e \x01(\tR\townerKind\x12\x1d\n" +
+	"\n" +
+	"owner_name\x18\x0f \x01(\tR\townerName\x12\x14\n" +
+	"\x05state\x18\x10 \x01(\tR\x05state\x12\x16\n" +
+	"\x06health\x18\x11 \x01(\tR\x06health\x1a9\n" +
 	"\vLabelsEntry\x12\x10\n" +
 	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
 	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"c\n" +
@@ -2072,7 +2623,7 @@ const file_microseg_proto_rawDesc = "" +
 	"\bagent_id\x18\x01 \x01(\tR\aagentId\x12\x1d\n" +
 	"\n" +
 	"event_type\x18\x02 \x01(\tR\teventType\x12.\n" +
-	"\bworkload\x18\x03 \x01(\v2\x12.microseg.WorkloadR\bworkload\"\x9c\x05\n" +
+	"\bworkload\x18\x03 \x01(\v2\x12.microseg.WorkloadR\bworkload\"\x9f\x06\n" +
 	"\n" +
 	"Connection\x12\x1b\n" +
 	"\tclient_wl\x18\x01 \x01(\tR\bclientWl\x12\x1b\n" +
@@ -2101,11 +2652,22 @@ const file_microseg_proto_rawDesc = "" +
 	"local_peer\x18\x13 \x01(\bR\tlocalPeer\x12\x14\n" +
 	"\x05scope\x18\x14 \x01(\tR\x05scope\x12\x18\n" +
 	"\anetwork\x18\x15 \x01(\tR\anetwork\x12\x1a\n" +
-	"\bviolates\x18\x16 \x01(\rR\bviolates\"~\n" +
+	"\bviolates\x18\x16 \x01(\rR\bviolates\x12$\n" +
+	"\x02l7\x18\x17 \x03(\v2\x14.microseg.L7MetadataR\x02l7\x12\x16\n" +
+	"\x06capped\x18\x18 \x01(\bR\x06capped\x12\x18\n" +
+	"\asummary\x18\x19 \x01(\bR\asummary\x12)\n" +
+	"\x10internet_ingress\x18\x1a \x01(\bR\x0finternetIngress\"\x80\x01\n" +
+	"\n" +
+	"L7Metadata\x12\x1f\n" +
+	"\vhttp_method\x18\x01 \x01(\tR\n" +
+	"httpMethod\x12\x1b\n" +
+	"\thttp_host\x18\x02 \x01(\tR\bhttpHost\x12\x1b\n" +
+	"\tdns_query\x18\x03 \x01(\tR\bdnsQuery\x12\x17\n" +
+	"\atls_sni\x18\x04 \x01(\tR\x06tlsSni\"~\n" +
 	"\x10ConnectionReport\x12\x19\n" +
 	"\bagent_id\x18\x01 \x01(\tR\aagentId\x12\x17\n" +
 	"\ahost_id\x18\x02 \x01(\tR\x06hostId\x126\n" +
-	"\vconnections\x18\x03 \x03(\v2\x14.microseg.ConnectionR\vconnections\"\x86\x03\n" +
+	"\vconnections\x18\x03 \x03(\v2\x14.microseg.ConnectionR\vconnections\"\xdd\x03\n" +
 	"\tThreatLog\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1b\n" +
 	"\tthreat_id\x18\x02 \x01(\rR\bthreatId\x12\x1f\n" +
@@ -2125,11 +2687,15 @@ const file_microseg_proto_rawDesc = "" +
 	"\n" +
 	"local_peer\x18\f \x01(\bR\tlocalPeer\x12\x1f\n" +
 	"\vreported_at\x18\r \x01(\x04R\n" +
-	"reportedAt\"q\n" +
+	"reportedAt\x12\x1b\n" +
+	"\ttcp_flags\x18\x0e \x01(\rR\btcpFlags\x12\x17\n" +
+	"\apkt_len\x18\x0f \x01(\rR\x06pktLen\x12\x1f\n" +
+	"\vpkt_summary\x18\x10 \x01(\tR\n" +
+	"pktSummary\"q\n" +
 	"\fThreatReport\x12\x19\n" +
 	"\bagent_id\x18\x01 \x01(\tR\aagentId\x12\x17\n" +
 	"\ahost_id\x18\x02 \x01(\tR\x06hostId\x12-\n" +
-	"\athreats\x18\x03 \x03(\v2\x13.microseg.ThreatLogR\athreats\"\xfc\x01\n" +
+	"\athreats\x18\x03 \x03(\v2\x13.microseg.ThreatLogR\athreats\"\x8e\x02\n" +
 	"\n" +
 	"PolicyRule\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\rR\x02id\x12\x12\n" +
@@ -2142,7 +2708,8 @@ const file_microseg_proto_rawDesc = "" +
 	"\bpriority\x18\b \x01(\rR\bpriority\x12\x18\n" +
 	"\adisable\x18\t \x01(\bR\adisable\x12\x18\n" +
 	"\acomment\x18\n" +
-	" \x01(\tR\acomment\"\xf4\x01\n" +
+	" \x01(\tR\acomment\x12\x10\n" +
+	"\x03log\x18\v \x01(\bR\x03log\"\xf4\x01\n" +
 	"\x06IPRule\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\rR\x02id\x12\x15\n" +
 	"\x06src_ip\x18\x02 \x01(\fR\x05srcIp\x12\x15\n" +
@@ -2163,10 +2730,11 @@ const file_microseg_proto_rawDesc = "" +
 	"\n" +
 	"def_action\x18\x04 \x01(\rR\tdefAction\x12\x1b\n" +
 	"\tapply_dir\x18\x05 \x01(\x05R\bapplyDir\x12&\n" +
-	"\x05rules\x18\x06 \x03(\v2\x10.microseg.IPRuleR\x05rules\"8\n" +
+	"\x05rules\x18\x06 \x03(\v2\x10.microseg.IPRuleR\x05rules\"w\n" +
 	"\n" +
 	"PolicyList\x12*\n" +
-	"\x05rules\x18\x01 \x03(\v2\x14.microseg.PolicyRuleR\x05rules\"M\n" +
+	"\x05rules\x18\x01 \x03(\v2\x14.microseg.PolicyRuleR\x05rules\x12=\n" +
+	"\x0eworkload_modes\x18\x02 \x03(\v2\x16.microseg.WorkloadModeR\rworkloadModes\"M\n" +
 	"\rPolicyRequest\x12\x19\n" +
 	"\bagent_id\x18\x01 \x01(\tR\aagentId\x12!\n" +
 	"\fworkload_ids\x18\x02 \x03(\tR\vworkloadIds\"D\n" +
@@ -2179,20 +2747,36 @@ const file_microseg_proto_rawDesc = "" +
 	"\x04mask\x18\x02 \x01(\fR\x04mask\x12\x14\n" +
 	"\x05scope\x18\x03 \x01(\tR\x05scope\":\n" +
 	"\fSubnetConfig\x12*\n" +
-	"\asubnets\x18\x01 \x03(\v2\x10.microseg.SubnetR\asubnets2\xc9\x02\n" +
+	"\asubnets\x18\x01 \x03(\v2\x10.microseg.SubnetR\asubnets\"U\n" +
+	"\fAgentCommand\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
+	"\x04type\x18\x02 \x01(\tR\x04type\x12!\n" +
+	"\fcontainer_id\x18\x03 \x01(\tR\vcontainerId\"l\n" +
+	"\x12AgentCommandResult\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x18\n" +
+	"\asuccess\x18\x02 \x01(\bR\asuccess\x12\x14\n" +
+	"\x05error\x18\x03 \x01(\tR\x05error\x12\x16\n" +
+	"\x06output\x18\x04 \x01(\tR\x06output\"C\n" +
+	"\fWorkloadMode\x12\x1f\n" +
+	"\vworkload_id\x18\x01 \x01(\tR\n" +
+	"workloadId\x12\x12\n" +
+	"\x04mode\x18\x02 \x01(\tR\x04mode2\xc9\x02\n" +
 	"\fAgentService\x12@\n" +
 	"\fConfigPolicy\x12\x16.microseg.PolicyConfig\x1a\x18.microseg.ConfigResponse\x12F\n" +
 	"\x0fConfigGroupMode\x12\x19.microseg.GroupModeConfig\x1a\x18.microseg.ConfigResponse\x12A\n" +
 	"\rConfigSubnets\x12\x16.microseg.SubnetConfig\x1a\x18.microseg.ConfigResponse\x123\n" +
 	"\tGetStatus\x12\x0f.microseg.Empty\x1a\x15.microseg.AgentStatus\x127\n" +
-	"\fGetWorkloads\x12\x0f.microseg.Empty\x1a\x16.microseg.WorkloadList2\xa7\x03\n" +
+	"\fGetWorkloads\x12\x0f.microseg.Empty\x1a\x16.microseg.WorkloadList2\xa7\x04\n" +
 	"\x11ControllerService\x12;\n" +
 	"\bRegister\x12\x13.microseg.AgentInfo\x1a\x1a.microseg.RegisterResponse\x12D\n" +
 	"\tHeartbeat\x12\x1a.microseg.HeartbeatRequest\x1a\x1b.microseg.HeartbeatResponse\x12I\n" +
 	"\x11ReportConnections\x12\x1a.microseg.ConnectionReport\x1a\x18.microseg.ReportResponse\x12A\n" +
 	"\rReportThreats\x12\x16.microseg.ThreatReport\x1a\x18.microseg.ReportResponse\x12C\n" +
 	"\x0eReportWorkload\x12\x17.microseg.WorkloadEvent\x1a\x18.microseg.ReportResponse\x12<\n" +
-	"\vGetPolicies\x12\x17.microseg.PolicyRequest\x1a\x14.microseg.PolicyListB$Z\"github.com/micro-segment/api/protob\x06proto3"
+	"\n" +
+	"ReportHost\x12\x14.microseg.HostReport\x1a\x18.microseg.ReportResponse\x12<\n" +
+	"\vGetPolicies\x12\x17.microseg.PolicyRequest\x1a\x14.microseg.PolicyList\x12@\n" +
+	"\rWatchPolicies\x12\x17.microseg.PolicyRequest\x1a\x14.microseg.PolicyList0\x01B$Z\"github.com/micro-segment/api/protob\x06proto3"
 
 var (
 	file_microseg_proto_rawDescOnce sync.Once
@@ -2206,76 +2790,93 @@ func file_microseg_proto_rawDescGZIP() []byte {
 	return file_microseg_proto_rawDescData
 }
 
-var file_microseg_proto_msgTypes = make([]protoimpl.MessageInfo, 27)
+var file_microseg_proto_msgTypes = make([]protoimpl.MessageInfo, 33)
 var file_microseg_proto_goTypes = []any{
-	(*Empty)(nil),             // 0: microseg.Empty
-	(*ConfigResponse)(nil),    // 1: microseg.ConfigResponse
-	(*ReportResponse)(nil),    // 2: microseg.ReportResponse
-	(*AgentInfo)(nil),         // 3: microseg.AgentInfo
-	(*RegisterResponse)(nil),  // 4: microseg.RegisterResponse
-	(*HeartbeatRequest)(nil),  // 5: microseg.HeartbeatRequest
-	(*HeartbeatResponse)(nil), // 6: microseg.HeartbeatResponse
-	(*AgentStats)(nil),        // 7: microseg.AgentStats
-	(*AgentStatus)(nil),       // 8: microseg.AgentStatus
-	(*Workload)(nil),          // 9: microseg.Workload
-	(*NetworkInterface)(nil),  // 10: microseg.NetworkInterface
-	(*IPAddress)(nil),         // 11: microseg.IPAddress
-	(*WorkloadList)(nil),      // 12: microseg.WorkloadList
-	(*WorkloadEvent)(nil),     // 13: microseg.WorkloadEvent
-	(*Connection)(nil),        // 14: microseg.Connection
-	(*ConnectionReport)(nil),  // 15: microseg.ConnectionReport
-	(*ThreatLog)(nil),         // 16: microseg.ThreatLog
-	(*ThreatReport)(nil),      // 17: microseg.ThreatReport
-	(*PolicyRule)(nil),        // 18: microseg.PolicyRule
-	(*IPRule)(nil),            // 19: microseg.IPRule
-	(*PolicyConfig)(nil),      // 20: microseg.PolicyConfig
-	(*PolicyList)(nil),        // 21: microseg.PolicyList
-	(*PolicyRequest)(nil),     // 22: microseg.PolicyRequest
-	(*GroupModeConfig)(nil),   // 23: microseg.GroupModeConfig
-	(*Subnet)(nil),            // 24: microseg.Subnet
-	(*SubnetConfig)(nil),      // 25: microseg.SubnetConfig
-	nil,                       // 26: microseg.Workload.LabelsEntry
+	(*Empty)(nil),              // 0: microseg.Empty
+	(*ConfigResponse)(nil),     // 1: microseg.ConfigResponse
+	(*ReportResponse)(nil),     // 2: microseg.ReportResponse
+	(*AgentInfo)(nil),          // 3: microseg.AgentInfo
+	(*RegisterResponse)(nil),   // 4: microseg.RegisterResponse
+	(*HeartbeatRequest)(nil),   // 5: microseg.HeartbeatRequest
+	(*HeartbeatResponse)(nil),  // 6: microseg.HeartbeatResponse
+	(*AgentStats)(nil),         // 7: microseg.AgentStats
+	(*Host)(nil),               // 8: microseg.Host
+	(*HostReport)(nil),         // 9: microseg.HostReport
+	(*AgentStatus)(nil),        // 10: microseg.AgentStatus
+	(*Workload)(nil),           // 11: microseg.Workload
+	(*NetworkInterface)(nil),   // 12: microseg.NetworkInterface
+	(*IPAddress)(nil),          // 13: microseg.IPAddress
+	(*WorkloadList)(nil),       // 14: microseg.WorkloadList
+	(*WorkloadEvent)(nil),      // 15: microseg.WorkloadEvent
+	(*Connection)(nil),         // 16: microseg.Connection
+	(*L7Metadata)(nil),         // 17: microseg.L7Metadata
+	(*ConnectionReport)(nil),   // 18: microseg.ConnectionReport
+	(*ThreatLog)(nil),          // 19: microseg.ThreatLog
+	(*ThreatReport)(nil),       // 20: microseg.ThreatReport
+	(*PolicyRule)(nil),         // 21: microseg.PolicyRule
+	(*IPRule)(nil),             // 22: microseg.IPRule
+	(*PolicyConfig)(nil),       // 23: microseg.PolicyConfig
+	(*PolicyList)(nil),         // 24: microseg.PolicyList
+	(*PolicyRequest)(nil),      // 25: microseg.PolicyRequest
+	(*GroupModeConfig)(nil),    // 26: microseg.GroupModeConfig
+	(*Subnet)(nil),             // 27: microseg.Subnet
+	(*SubnetConfig)(nil),       // 28: microseg.SubnetConfig
+	(*AgentCommand)(nil),       // 29: microseg.AgentCommand
+	(*AgentCommandResult)(nil), // 30: microseg.AgentCommandResult
+	(*WorkloadMode)(nil),       // 31: microseg.WorkloadMode
+	nil,                        // 32: microseg.Workload.LabelsEntry
 }
 var file_microseg_proto_depIdxs = []int32{
-	7,  // 0: microseg.HeartbeatRequest.stats:type_name -> microseg.AgentStats
-	7,  // 1: microseg.AgentStatus.stats:type_name -> microseg.AgentStats
-	10, // 2: microseg.Workload.ifaces:type_name -> microseg.NetworkInterface
-	26, // 3: microseg.Workload.labels:type_name -> microseg.Workload.LabelsEntry
-	11, // 4: microseg.NetworkInterface.addrs:type_name -> microseg.IPAddress
-	9,  // 5: microseg.WorkloadList.workloads:type_name -> microseg.Workload
-	9,  // 6: microseg.WorkloadEvent.workload:type_name -> microseg.Workload
-	14, // 7: microseg.ConnectionReport.connections:type_name -> microseg.Connection
-	16, // 8: microseg.ThreatReport.threats:type_name -> microseg.ThreatLog
-	19, // 9: microseg.PolicyConfig.rules:type_name -> microseg.IPRule
-	18, // 10: microseg.PolicyList.rules:type_name -> microseg.PolicyRule
-	24, // 11: microseg.SubnetConfig.subnets:type_name -> microseg.Subnet
-	20, // 12: microseg.AgentService.ConfigPolicy:input_type -> microseg.PolicyConfig
-	23, // 13: microseg.AgentService.ConfigGroupMode:input_type -> microseg.GroupModeConfig
-	25, // 14: microseg.AgentService.ConfigSubnets:input_type -> microseg.SubnetConfig
-	0,  // 15: microseg.AgentService.GetStatus:input_type -> microseg.Empty
-	0,  // 16: microseg.AgentService.GetWorkloads:input_type -> microseg.Empty
-	3,  // 17: microseg.ControllerService.Register:input_type -> microseg.AgentInfo
-	5,  // 18: microseg.ControllerService.Heartbeat:input_type -> microseg.HeartbeatRequest
-	15, // 19: microseg.ControllerService.ReportConnections:input_type -> microseg.ConnectionReport
-	17, // 20: microseg.ControllerService.ReportThreats:input_type -> microseg.ThreatReport
-	13, // 21: microseg.ControllerService.ReportWorkload:input_type -> microseg.WorkloadEvent
-	22, // 22: microseg.ControllerService.GetPolicies:input_type -> microseg.PolicyRequest
-	1,  // 23: microseg.AgentService.ConfigPolicy:output_type -> microseg.ConfigResponse
-	1,  // 24: microseg.AgentService.ConfigGroupMode:output_type -> microseg.ConfigResponse
-	1,  // 25: microseg.AgentService.ConfigSubnets:output_type -> microseg.ConfigResponse
-	8,  // 26: microseg.AgentService.GetStatus:output_type -> microseg.AgentStatus
-	12, // 27: microseg.AgentService.GetWorkloads:output_type -> microseg.WorkloadList
-	4,  // 28: microseg.ControllerService.Register:output_type -> microseg.RegisterResponse
-	6,  // 29: microseg.ControllerService.Heartbeat:output_type -> microseg.HeartbeatResponse
-	2,  // 30: microseg.ControllerService.ReportConnections:output_type -> microseg.ReportResponse
-	2,  // 31: microseg.ControllerService.ReportThreats:output_type -> microseg.ReportResponse
-	2,  // 32: microseg.ControllerService.ReportWorkload:output_type -> microseg.ReportResponse
-	21, // 33: microseg.ControllerService.GetPolicies:output_type -> microseg.PolicyList
-	23, // [23:34] is the sub-list for method output_type
-	12, // [12:23] is the sub-list for method input_type
-	12, // [12:12] is the sub-list for extension type_name
-	12, // [12:12] is the sub-list for extension extendee
-	0,  // [0:12] is the sub-list for field type_name
+	11, // 0: microseg.AgentInfo.workloads:type_name -> microseg.Workload
+	7,  // 1: microseg.HeartbeatRequest.stats:type_name -> microseg.AgentStats
+	30, // 2: microseg.HeartbeatRequest.results:type_name -> microseg.AgentCommandResult
+	29, // 3: microseg.HeartbeatResponse.commands:type_name -> microseg.AgentCommand
+	12, // 4: microseg.Host.ifaces:type_name -> microseg.NetworkInterface
+	8,  // 5: microseg.HostReport.host:type_name -> microseg.Host
+	7,  // 6: microseg.AgentStatus.stats:type_name -> microseg.AgentStats
+	12, // 7: microseg.Workload.ifaces:type_name -> microseg.NetworkInterface
+	32, // 8: microseg.Workload.labels:type_name -> microseg.Workload.LabelsEntry
+	13, // 9: microseg.NetworkInterface.addrs:type_name -> microseg.IPAddress
+	11, // 10: microseg.WorkloadList.workloads:type_name -> microseg.Workload
+	11, // 11: microseg.WorkloadEvent.workload:type_name -> microseg.Workload
+	17, // 12: microseg.Connection.l7:type_name -> microseg.L7Metadata
+	16, // 13: microseg.ConnectionReport.connections:type_name -> microseg.Connection
+	19, // 14: microseg.ThreatReport.threats:type_name -> microseg.ThreatLog
+	22, // 15: microseg.PolicyConfig.rules:type_name -> microseg.IPRule
+	21, // 16: microseg.PolicyList.rules:type_name -> microseg.PolicyRule
+	31, // 17: microseg.PolicyList.workload_modes:type_name -> microseg.WorkloadMode
+	27, // 18: microseg.SubnetConfig.subnets:type_name -> microseg.Subnet
+	23, // 19: microseg.AgentService.ConfigPolicy:input_type -> microseg.PolicyConfig
+	26, // 20: microseg.AgentService.ConfigGroupMode:input_type -> microseg.GroupModeConfig
+	28, // 21: microseg.AgentService.ConfigSubnets:input_type -> microseg.SubnetConfig
+	0,  // 22: microseg.AgentService.GetStatus:input_type -> microseg.Empty
+	0,  // 23: microseg.AgentService.GetWorkloads:input_type -> microseg.Empty
+	3,  // 24: microseg.ControllerService.Register:input_type -> microseg.AgentInfo
+	5,  // 25: microseg.ControllerService.Heartbeat:input_type -> microseg.HeartbeatRequest
+	18, // 26: microseg.ControllerService.ReportConnections:input_type -> microseg.ConnectionReport
+	20, // 27: microseg.ControllerService.ReportThreats:input_type -> microseg.ThreatReport
+	15, // 28: microseg.ControllerService.ReportWorkload:input_type -> microseg.WorkloadEvent
+	9,  // 29: microseg.ControllerService.ReportHost:input_type -> microseg.HostReport
+	25, // 30: microseg.ControllerService.GetPolicies:input_type -> microseg.PolicyRequest
+	25, // 31: microseg.ControllerService.WatchPolicies:input_type -> microseg.PolicyRequest
+	1,  // 32: microseg.AgentService.ConfigPolicy:output_type -> microseg.ConfigResponse
+	1,  // 33: microseg.AgentService.ConfigGroupMode:output_type -> microseg.ConfigResponse
+	1,  // 34: microseg.AgentService.ConfigSubnets:output_type -> microseg.ConfigResponse
+	10, // 35: microseg.AgentService.GetStatus:output_type -> microseg.AgentStatus
+	14, // 36: microseg.AgentService.GetWorkloads:output_type -> microseg.WorkloadList
+	4,  // 37: microseg.ControllerService.Register:output_type -> microseg.RegisterResponse
+	6,  // 38: microseg.ControllerService.Heartbeat:output_type -> microseg.HeartbeatResponse
+	2,  // 39: microseg.ControllerService.ReportConnections:output_type -> microseg.ReportResponse
+	2,  // 40: microseg.ControllerService.ReportThreats:output_type -> microseg.ReportResponse
+	2,  // 41: microseg.ControllerService.ReportWorkload:output_type -> microseg.ReportResponse
+	2,  // 42: microseg.ControllerService.ReportHost:output_type -> microseg.ReportResponse
+	24, // 43: microseg.ControllerService.GetPolicies:output_type -> microseg.PolicyList
+	24, // 44: microseg.ControllerService.WatchPolicies:output_type -> microseg.PolicyList
+	32, // [32:45] is the sub-list for method output_type
+	19, // [19:32] is the sub-list for method input_type
+	19, // [19:19] is the sub-list for extension type_name
+	19, // [19:19] is the sub-list for extension extendee
+	0,  // [0:19] is the sub-list for field type_name
 }
 
 func init() { file_microseg_proto_init() }
@@ -2289,7 +2890,7 @@ func file_microseg_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_microseg_proto_rawDesc), len(file_microseg_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   27,
+			NumMessages:   33,
 			NumExtensions: 0,
 			NumServices:   2,
 		},
@@ -291,7 +291,9 @@ const (
 	ControllerService_ReportConnections_FullMethodName = "/microseg.ControllerService/ReportConnections"
 	ControllerService_ReportThreats_FullMethodName     = "/microseg.ControllerService/ReportThreats"
 	ControllerService_ReportWorkload_FullMethodName    = "/microseg.ControllerService/ReportWorkload"
+	ControllerService_ReportHost_FullMethodName        = "/microseg.ControllerService/ReportHost"
 	ControllerService_GetPolicies_FullMethodName       = "/microseg.ControllerService/GetPolicies"
+	ControllerService_WatchPolicies_FullMethodName     = "/microseg.ControllerService/WatchPolicies"
 )
 
 // ControllerServiceClient is the client API for ControllerService service.
@@ -308,8 +310,12 @@ type ControllerServiceClient interface {
 	ReportThreats(ctx context.Context, in *ThreatReport, opts ...grpc.CallOption) (*ReportResponse, error)
 	// 上报工作负载变更
 	ReportWorkload(ctx context.Context, in *WorkloadEvent, opts ...grpc.CallOption) (*ReportResponse, error)
+	// 上报主机信息
+	ReportHost(ctx context.Context, in *HostReport, opts ...grpc.CallOption) (*ReportResponse, error)
 	// 获取策略
 	GetPolicies(ctx context.Context, in *PolicyRequest, opts ...grpc.CallOption) (*PolicyList, error)
+	// 订阅策略，订阅时及每次规则变更时推送完整规则集
+	WatchPolicies(ctx context.Context, in *PolicyRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[PolicyList], error)
 }
 
 type controllerServiceClient struct {
@@ -370,6 +376,16 @@ func (c *controllerServiceClient) ReportWorkload(ctx context.Context, in *Worklo
 	return out, nil
 }
 
+func (c *controllerServiceClient) ReportHost(ctx context.Context, in *HostReport, opts ...grpc.CallOption) (*ReportResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ReportResponse)
+	err := c.cc.Invoke(ctx, ControllerService_ReportHost_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *controllerServiceClient) GetPolicies(ctx context.Context, in *PolicyRequest, opts ...grpc.CallOption) (*PolicyList, error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
 	out := new(PolicyList)
@@ -380,6 +396,25 @@ func (c *controllerServiceClient) GetPolicies(ctx context.Context, in *PolicyReq
 	return out, nil
 }
 
+func (c *controllerServiceClient) WatchPolicies(ctx context.Context, in *PolicyRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[PolicyList], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ControllerService_ServiceDesc.Streams[0], ControllerService_WatchPolicies_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[PolicyRequest, PolicyList]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ControllerService_WatchPoliciesClient = grpc.ServerStreamingClient[PolicyList]
+
 // ControllerServiceServer is the server API for ControllerService service.
 // All implementations must embed UnimplementedControllerServiceServer
 // for forward compatibility.
@@ -394,8 +429,12 @@ type ControllerServiceServer interface {
 	ReportThreats(context.Context, *ThreatReport) (*ReportResponse, error)
 	// 上报工作负载变更
 	ReportWorkload(context.Context, *WorkloadEvent) (*ReportResponse, error)
+	// 上报主机信息
+	ReportHost(context.Context, *HostReport) (*ReportResponse, error)
 	// 获取策略
 	GetPolicies(context.Context, *PolicyRequest) (*PolicyList, error)
+	// 订阅策略，订阅时及每次规则变更时推送完整规则集
+	WatchPolicies(*PolicyRequest, grpc.ServerStreamingServer[PolicyList]) error
 	mustEmbedUnimplementedControllerServiceServer()
 }
 
@@ -421,9 +460,15 @@ func (UnimplementedControllerServiceServer) ReportThreats(context.Context, *Thre
 func (UnimplementedControllerServiceServer) ReportWorkload(context.Context, *WorkloadEvent) (*ReportResponse, error) {
 	return nil, status.Error(codes.Unimplemented, "method ReportWorkload not implemented")
 }
+func (UnimplementedControllerServiceServer) ReportHost(context.Context, *HostReport) (*ReportResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ReportHost not implemented")
+}
 func (UnimplementedControllerServiceServer) GetPolicies(context.Context, *PolicyRequest) (*PolicyList, error) {
 	return nil, status.Error(codes.Unimplemented, "method GetPolicies not implemented")
 }
+func (UnimplementedControllerServiceServer) WatchPolicies(*PolicyRequest, grpc.ServerStreamingServer[PolicyList]) error {
+	return status.Error(codes.Unimplemented, "method WatchPolicies not implemented")
+}
 func (UnimplementedControllerServiceServer) mustEmbedUnimplementedControllerServiceServer() {}
 func (UnimplementedControllerServiceServer) testEmbeddedByValue()                           {}
 
@@ -535,6 +580,24 @@ func _ControllerService_ReportWorkload_Handler(srv interface{}, ctx context.Cont
 	return interceptor(ctx, in, info, handler)
 }
 
+func _ControllerService_ReportHost_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HostReport)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControllerServiceServer).ReportHost(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ControllerService_ReportHost_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControllerServiceServer).ReportHost(ctx, req.(*HostReport))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _ControllerService_GetPolicies_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(PolicyRequest)
 	if err := dec(in); err != nil {
@@ -553,6 +616,17 @@ func _ControllerService_GetPolicies_Handler(srv interface{}, ctx context.Context
 	return interceptor(ctx, in, info, handler)
 }
 
+func _ControllerService_WatchPolicies_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(PolicyRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ControllerServiceServer).WatchPolicies(m, &grpc.GenericServerStream[PolicyRequest, PolicyList]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ControllerService_WatchPoliciesServer = grpc.ServerStreamingServer[PolicyList]
+
 // ControllerService_ServiceDesc is the grpc.ServiceDesc for ControllerService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -580,11 +654,21 @@ var ControllerService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "ReportWorkload",
 			Handler:    _ControllerService_ReportWorkload_Handler,
 		},
+		{
+			MethodName: "ReportHost",
+			Handler:    _ControllerService_ReportHost_Handler,
+		},
 		{
 			MethodName: "GetPolicies",
 			Handler:    _ControllerService_GetPolicies_Handler,
 		},
 	},
-	Streams:  []grpc.StreamDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchPolicies",
+			Handler:       _ControllerService_WatchPolicies_Handler,
+			ServerStreams: true,
+		},
+	},
 	Metadata: "microseg.proto",
 }
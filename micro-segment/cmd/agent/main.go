@@ -6,12 +6,15 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/micro-segment/internal/agent/engine"
+	agentgrpc "github.com/micro-segment/internal/agent/grpc"
 	"github.com/micro-segment/internal/agent/network"
 )
 
@@ -20,13 +23,28 @@ var (
 	buildTime = "2026-01-07"
 )
 
+// agentIDNamespace Agent ID的UUID命名空间，由主机ID派生稳定的Agent ID
+var agentIDNamespace = uuid.MustParse("5f0e6c1a-8d2b-4c3e-9a71-2b6f4d8e0c15")
+
 func main() {
 	// 命令行参数
 	var (
-		dpSocket     = flag.String("dp-socket", "/var/run/dp.sock", "DP Unix socket path")
+		dpSocket     = flag.String("dp-socket", "/var/run/dp.sock", "DP Unix socket paths, comma separated for multiple DP queues")
+		dpTimeout    = flag.Duration("dp-connect-timeout", 5*time.Second, "Timeout for connecting to each DP socket")
 		grpcAddr     = flag.String("grpc-addr", "localhost:18400", "Controller gRPC address")
 		logLevel     = flag.String("log-level", "info", "Log level (debug, info, warn, error)")
 		enableCapture = flag.Bool("enable-capture", true, "Enable Docker container traffic capture")
+		agentIDFlag  = flag.String("agent-id", "", "Agent ID (default: derived from host machine-id)")
+		internalNets = flag.String("internal-subnets", "", "Additional internal subnets, comma separated CIDRs")
+		flushFrac    = flag.Float64("flush-fraction", 0.8, "Flush connections early when the connection map reaches this fraction of capacity (0,1]")
+		captureMeth  = flag.String("capture-method", "tc", "Container traffic capture method (tc, nfqueue)")
+		capturePar   = flag.Int("capture-parallelism", 4, "Maximum number of containers whose traffic capture is set up or torn down concurrently")
+		reconcile    = flag.Duration("capture-reconcile-interval", 60*time.Second, "Interval for reconciling running containers with active captures, 0 to disable")
+		svcLabels    = flag.String("service-labels", strings.Join(network.DefaultServiceLabels, ","), "Container labels to derive workload service names from, comma separated in precedence order; falls back to the image name")
+		reportFlds   = flag.String("report-fields", "all", "Optional connection fields to report, comma separated ("+strings.Join(agentgrpc.ConnectionFieldNames(), ", ")+"), or all/none")
+		hostProcs    = flag.Bool("host-processes", false, "Report host processes listening on non-loopback addresses as workloads")
+		heartbeat    = flag.Duration("heartbeat-interval", 10*time.Second, "Heartbeat interval, shortened to a third of the controller's agent timeout if larger")
+		hbJitter     = flag.Float64("heartbeat-jitter", 0.1, "Random jitter fraction applied to each heartbeat interval, also delays the first heartbeat randomly (0 disables, max 0.5)")
 		showVer      = flag.Bool("version", false, "Show version")
 	)
 	flag.Parse()
@@ -46,10 +64,34 @@ func main() {
 		FullTimestamp: true,
 	})
 
+	method, err := network.ParseCaptureMethod(*captureMeth)
+	if err != nil {
+		log.WithError(err).Fatal("Invalid flag")
+	}
+	if *heartbeat <= 0 {
+		log.WithField("heartbeat_interval", *heartbeat).Fatal("Invalid heartbeat interval")
+	}
+	if *hbJitter < 0 || *hbJitter > agentgrpc.MaxHeartbeatJitter {
+		log.WithField("heartbeat_jitter", *hbJitter).Fatal("Invalid heartbeat jitter")
+	}
+
+	fieldMask, err := agentgrpc.ParseReportFields(*reportFlds)
+	if err != nil {
+		log.WithError(err).Fatal("Invalid flag")
+	}
+
+	staticSubnets, err := engine.ParseSubnets(*internalNets)
+	if err != nil {
+		log.WithError(err).Fatal("Invalid internal subnets")
+	}
+
 	// 获取主机信息
 	hostname, _ := os.Hostname()
 	hostID := getHostID()
-	agentID := uuid.New().String()
+	agentID := *agentIDFlag
+	if agentID == "" {
+		agentID = agentIDForHost(hostID)
+	}
 
 	log.WithFields(log.Fields{
 		"version":        version,
@@ -62,9 +104,9 @@ func main() {
 	// 初始化网络管理器（如果启用流量捕获）
 	var networkManager *network.Manager
 	if *enableCapture {
-		log.Info("Initializing Docker container traffic capture")
+		log.WithField("method", method).Info("Initializing Docker container traffic capture")
 		
-		networkManager, err = network.NewManager()
+		networkManager, err = network.NewManager(method)
 		if err != nil {
 			log.WithError(err).Fatal("Failed to create network manager")
 		}
@@ -73,14 +115,6 @@ func main() {
 		if err := networkManager.ValidateSetup(); err != nil {
 			log.WithError(err).Warn("Network setup validation failed, disabling traffic capture")
 			networkManager = nil
-		} else {
-			// 启动网络管理器
-			if err := networkManager.Start(); err != nil {
-				log.WithError(err).Warn("Failed to start network manager, disabling traffic capture")
-				networkManager = nil
-			} else {
-				log.Info("Docker container traffic capture enabled")
-			}
 		}
 	}
 
@@ -89,9 +123,21 @@ func main() {
 		AgentID:        agentID,
 		HostID:         hostID,
 		HostName:       hostname,
-		DPSocketPath:   *dpSocket,
+		DPSocketPaths:  splitList(*dpSocket),
+		DPDialTimeout:  *dpTimeout,
 		GRPCAddr:       *grpcAddr,
-		NetworkManager: networkManager,
+		StaticSubnets:  staticSubnets,
+		FlushFraction:  *flushFrac,
+
+		HeartbeatInterval: *heartbeat,
+		HeartbeatJitter:   *hbJitter,
+		ReportFieldMask:   fieldMask,
+		HostProcesses:     *hostProcs,
+	}
+	if networkManager != nil {
+		// 仅在启用时赋值，避免接口持有nil指针
+		config.NetworkManager = networkManager
+		config.DockerSubnets = networkManager.GetDockerSubnets
 	}
 
 	// 创建并启动引擎
@@ -100,6 +146,20 @@ func main() {
 		log.WithError(err).Fatal("Failed to start agent engine")
 	}
 
+	// 启动网络管理器，容器事件经引擎上报为工作负载
+	if networkManager != nil {
+		networkManager.SetOnContainerEvent(eng.HandleContainerEvent)
+		networkManager.SetCaptureParallelism(*capturePar)
+		networkManager.SetReconcileInterval(*reconcile)
+		networkManager.SetServiceLabels(splitList(*svcLabels))
+		if err := networkManager.Start(); err != nil {
+			log.WithError(err).Warn("Failed to start network manager, disabling traffic capture")
+			networkManager = nil
+		} else {
+			log.Info("Docker container traffic capture enabled")
+		}
+	}
+
 	log.Info("Agent started successfully")
 
 	// 等待退出信号
@@ -124,8 +184,27 @@ func main() {
 func getHostID() string {
 	// 尝试读取machine-id
 	if data, err := os.ReadFile("/etc/machine-id"); err == nil {
-		return string(data[:len(data)-1]) // 去掉换行符
+		if id := strings.TrimSpace(string(data)); id != "" {
+			return id
+		}
 	}
 	// 回退到UUID
 	return uuid.New().String()
 }
+
+// agentIDForHost 由主机ID派生Agent ID
+// 同一主机重启后ID保持不变，Controller不会残留旧Agent
+func agentIDForHost(hostID string) string {
+	return uuid.NewSHA1(agentIDNamespace, []byte(hostID)).String()
+}
+
+// splitList 解析逗号分隔的列表，忽略空项
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,17 @@
+package main
+
+import "testing"
+
+func TestAgentIDForHost(t *testing.T) {
+	a := agentIDForHost("4c4c4544-0042-3510-8052-b4c04f4e3332")
+	b := agentIDForHost("4c4c4544-0042-3510-8052-b4c04f4e3332")
+	if a != b {
+		t.Errorf("Agent ID not stable: %s != %s", a, b)
+	}
+	if c := agentIDForHost("another-host"); c == a {
+		t.Errorf("Different hosts share agent ID %s", c)
+	}
+	if a == "4c4c4544-0042-3510-8052-b4c04f4e3332" {
+		t.Errorf("Agent ID equals host ID")
+	}
+}
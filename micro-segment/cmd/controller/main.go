@@ -8,10 +8,13 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/micro-segment/internal/controller/alert"
 	"github.com/micro-segment/internal/controller/cache"
+	"github.com/micro-segment/internal/controller/geo"
 	ctrlgrpc "github.com/micro-segment/internal/controller/grpc"
 	"github.com/micro-segment/internal/controller/policy"
 	"github.com/micro-segment/internal/controller/rest"
@@ -27,6 +30,17 @@ func main() {
 	var (
 		httpPort = flag.Int("http-port", 10443, "HTTP API port")
 		grpcPort = flag.Int("grpc-port", 18400, "gRPC port")
+		connTTL  = flag.Duration("connection-ttl", 300*time.Second, "Connection cache TTL")
+		sevQuiet = flag.Duration("severity-quiet", 10*time.Minute, "Quiet period before a connection's severity decays one level (0 disables)")
+		ruleFile = flag.String("policy-file", "", "File to persist policy rules in (empty keeps rules in memory only)")
+		strictGr = flag.Bool("strict-groups", false, "Reject policy rules that reference nonexistent groups")
+		dupAgent = flag.String("duplicate-agent", "replace", "Duplicate agent registration on the same host (replace, reject, offline)")
+		agentTTL = flag.Duration("agent-timeout", ctrlgrpc.DefaultAgentTimeout, "Mark an agent offline after this long without a heartbeat; agents keep their heartbeat within a third of it")
+		alertURL = flag.String("alert-webhook", "", "URL to POST violation and threat alerts to as JSON (empty disables)")
+		alertSev = flag.String("alert-severity", "high", "Minimum severity of alerts sent to the webhook (info, low, medium, high, critical)")
+		geoDB    = flag.String("geo-db", "", "CSV file of network,country,asn[,org] rows for enriching external peers (empty disables)")
+		svcTmpl  = flag.String("service-template", "", "Template deriving a workload's service from its labels when the agent reports none, e.g. {app}")
+		domTmpl  = flag.String("domain-template", "", "Template deriving a workload's domain from its labels when the agent reports none, e.g. {io.kubernetes.pod.namespace}")
 		logLevel = flag.String("log-level", "info", "Log level (debug, info, warn, error)")
 		showVer  = flag.Bool("version", false, "Show version")
 	)
@@ -55,14 +69,67 @@ func main() {
 
 	// 初始化缓存
 	c := cache.NewCache()
+	c.SetConnectionTTL(*connTTL)
+	c.SetSeverityQuietPeriod(*sevQuiet)
+	if err := c.SetWorkloadNaming(cache.WorkloadNaming{Service: *svcTmpl, Domain: *domTmpl}); err != nil {
+		log.WithError(err).Fatal("Invalid flag")
+	}
+	c.Start()
 	log.Info("Cache initialized")
 
 	// 初始化策略引擎
 	p := policy.NewEngine()
-	log.Info("Policy engine initialized")
+	if *ruleFile != "" {
+		if p, err = policy.NewEngineWithStore(policy.NewFileStore(*ruleFile)); err != nil {
+			log.WithError(err).Fatal("Failed to initialize policy engine")
+		}
+	}
+	if *strictGr {
+		p.SetGroupValidator(func(name string) bool { return c.GetGroup(name) != nil })
+	}
+	c.SetRuleLogged(p.IsRuleLogged)
+	log.WithField("rules", p.GetRuleCount()).Info("Policy engine initialized")
+
+	// 违规和威胁告警推送
+	var webhook *alert.Webhook
+	if *alertURL != "" {
+		threshold, err := alert.ParseSeverity(*alertSev)
+		if err != nil {
+			log.WithError(err).Fatal("Invalid flag")
+		}
+		webhook = alert.NewWebhook(*alertURL, threshold)
+		webhook.Start()
+		c.SetOnAlert(webhook.Notify)
+		log.WithFields(log.Fields{"url": *alertURL, "severity": *alertSev}).Info("Alert webhook enabled")
+	}
+
+	// 外部端点地理信息
+	var enricher *geo.Enricher
+	if *geoDB != "" {
+		table, err := geo.LoadTable(*geoDB)
+		if err != nil {
+			log.WithError(err).Fatal("Failed to load geo database")
+		}
+		enricher = geo.NewEnricher(table)
+		enricher.Start()
+		c.SetGeoLookup(enricher.Lookup)
+		log.WithFields(log.Fields{"path": *geoDB, "networks": table.Len()}).Info("Geo enrichment enabled")
+	}
+
+	// 策略变更后重新评估已有连接
+	stopCh := make(chan struct{})
+	go reevaluateOnPolicyChange(c, p, stopCh)
 
 	// 初始化gRPC服务器
 	grpcServer := ctrlgrpc.NewServer(*grpcPort, c, p)
+	dupPolicy, err := ctrlgrpc.ParseDuplicateAgentPolicy(*dupAgent)
+	if err != nil {
+		log.WithError(err).Fatal("Invalid flag")
+	}
+	grpcServer.SetDuplicateAgentPolicy(dupPolicy)
+	if err := grpcServer.SetAgentTimeout(*agentTTL); err != nil {
+		log.WithError(err).Fatal("Invalid flag")
+	}
 
 	// 设置gRPC回调
 	grpcServer.SetOnAgentJoin(func(agentID, hostID string) {
@@ -78,6 +145,13 @@ func main() {
 		}).Info("Agent left")
 	})
 
+	// 组策略模式传播到成员后向其Agent重新推送
+	c.SetOnModeChange(func(agentID string) {
+		if err := grpcServer.ResyncAgent(agentID); err != nil {
+			log.WithError(err).WithField("agent_id", agentID).Debug("Skip policy mode push")
+		}
+	})
+
 	// 启动gRPC服务器
 	if err := grpcServer.Start(); err != nil {
 		log.WithError(err).Fatal("Failed to start gRPC server")
@@ -86,6 +160,10 @@ func main() {
 
 	// 初始化REST路由
 	router := rest.NewRouter(c, p)
+	router.SetAgentResyncer(grpcServer)
+	router.SetReportStats(grpcServer)
+	router.SetAgentLister(grpcServer)
+	router.SetAgentCommander(grpcServer)
 
 	// 启动HTTP服务器
 	httpServer := &http.Server{
@@ -108,8 +186,36 @@ func main() {
 	log.Info("Shutting down...")
 
 	// 停止服务
+	close(stopCh)
 	grpcServer.Stop()
 	httpServer.Close()
+	c.Stop()
+	if enricher != nil {
+		enricher.Stop()
+	}
+	if webhook != nil {
+		webhook.Stop()
+	}
 
 	log.Info("Controller stopped")
 }
+
+// reevaluateOnPolicyChange 规则变更时按受影响的组重新评估连接策略动作
+// 使拓扑图中的链接与当前策略保持一致
+func reevaluateOnPolicyChange(c *cache.Cache, p *policy.Engine, stopCh chan struct{}) {
+	changed := p.Changed()
+	for {
+		select {
+		case <-changed:
+		case <-stopCh:
+			return
+		}
+
+		// 先取新的通知通道再取变更，避免漏掉期间发生的变更
+		changed = p.Changed()
+		groups, all := p.TakeDirtyGroups()
+		if n := c.ReevaluateConnections(groups, all, p.MatchConnection); n > 0 {
+			log.WithField("count", n).Info("Connections re-evaluated after policy change")
+		}
+	}
+}
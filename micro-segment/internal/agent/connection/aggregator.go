@@ -12,9 +12,11 @@ Package connection 提供连接聚合功能
 package connection
 
 import (
-	"fmt"
+	"encoding/binary"
+	"math"
 	"net"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -32,26 +34,56 @@ const connectionListMax int = 2048 * 4
 // reportInterval 上报间隔（秒），定期将聚合数据发送给Controller
 const reportInterval uint32 = 5
 
+// defaultFlushFraction 默认提前上报阈值占connectionMapMax的比例
+const defaultFlushFraction = 0.8
+
+// flushTimeBudget 单次flush上报连接的时间预算，需小于上报间隔
+const flushTimeBudget = 2 * time.Second
+
+// l7MetaMax 每条聚合连接保留的应用层元数据条数上限
+const l7MetaMax = 4
+
+// summaryMapMax 流量汇总映射表最大容量，超出后低优先级连接直接丢弃
+const summaryMapMax = connectionMapMax / 8
+
 // Aggregator 连接聚合器，负责收集和批量上报连接信息
 type Aggregator struct {
 	mutex          sync.Mutex                    // 连接映射表锁
 	connectionMap  map[string]*agent.Connection  // 连接聚合映射表
+	summaryMap     map[string]*agent.Connection  // 映射表满时按端点对合并的低优先级流量汇总，与映射表共用锁
 	connsCache     []*agent.ConnectionData       // 连接数据缓存
 	connsCacheMux  sync.Mutex                    // 缓存锁
 	threatLogCache []*threatLogEntry             // 威胁日志缓存
 	threatMutex    sync.Mutex                    // 威胁日志锁
 
+	// 统计计数，原子读写，读取时不占用连接映射表锁
+	connCount     atomic.Int64  // 连接映射表当前条目数，与映射表同步增减
+	droppedCount  atomic.Uint64 // 因映射表满而丢弃的连接数
+	summarized    atomic.Uint64 // 因映射表满而合并到流量汇总的连接数
+	reportedCount atomic.Uint64 // 已上报的连接数
+	earlyFlushes  atomic.Uint64 // 因超过高水位提前上报的次数
+
+	// flushHighWater 连接映射表或待处理缓存达到此数量时提前上报，不等定时器
+	flushHighWater atomic.Int64
+
 	// 回调函数
 	onConnections func([]*agent.Connection) // 连接上报回调
 	onThreatLogs  func([]*agent.ThreatLog)  // 威胁日志上报回调
 
+	// loggedPolicy 查询规则是否开启审计日志，可为nil
+	loggedPolicy func(policyID uint32) bool
+
 	// Agent信息
 	agentID  string // Agent标识
 	hostID   string // 主机标识
 
 	// 运行状态
-	running bool
-	stopCh  chan struct{}
+	running  bool
+	stopCh   chan struct{}
+	startOnce sync.Once     // 保证只启动一个定时器循环
+	stopOnce sync.Once      // 保证stopCh只关闭一次、最终刷新只执行一次
+	loopWg   sync.WaitGroup // 定时器循环退出后再执行最终刷新
+	flushCh  chan struct{}  // 提前刷新信号，与定时器在同一循环中处理
 }
 
 // threatLogEntry 威胁日志条目，包含MAC地址和日志内容
@@ -62,14 +94,31 @@ type threatLogEntry struct {
 
 // NewAggregator 创建新的连接聚合器实例
 func NewAggregator(agentID, hostID string) *Aggregator {
-	return &Aggregator{
+	a := &Aggregator{
 		connectionMap:  make(map[string]*agent.Connection),
+		summaryMap:     make(map[string]*agent.Connection),
 		connsCache:     make([]*agent.ConnectionData, 0),
 		threatLogCache: make([]*threatLogEntry, 0),
 		agentID:        agentID,
 		hostID:         hostID,
 		stopCh:         make(chan struct{}),
+		flushCh:        make(chan struct{}, 1),
+	}
+	a.SetFlushFraction(defaultFlushFraction)
+	return a
+}
+
+// SetFlushFraction 设置提前上报阈值，取值为connectionMapMax的比例(0,1]
+// 超出范围时使用默认值
+func (a *Aggregator) SetFlushFraction(fraction float64) {
+	if fraction <= 0 || fraction > 1 {
+		fraction = defaultFlushFraction
+	}
+	highWater := int64(float64(connectionMapMax) * fraction)
+	if highWater < 1 {
+		highWater = 1
 	}
+	a.flushHighWater.Store(highWater)
 }
 
 // SetOnConnections 设置连接数据上报回调函数
@@ -82,20 +131,36 @@ func (a *Aggregator) SetOnThreatLogs(cb func([]*agent.ThreatLog)) {
 	a.onThreatLogs = cb
 }
 
-// Start 启动聚合器，开始定时上报循环
+// SetLoggedPolicy 设置规则审计开关查询
+// 映射表满时，命中开启审计规则的新连接与VIOLATE/DENY连接一样保留
+func (a *Aggregator) SetLoggedPolicy(logged func(policyID uint32) bool) {
+	a.loggedPolicy = logged
+}
+
+// Start 启动聚合器，开始定时上报循环；重复调用无效
 func (a *Aggregator) Start() {
-	a.running = true
-	go a.timerLoop()
+	a.startOnce.Do(func() {
+		a.running = true
+		a.loopWg.Add(1)
+		go a.timerLoop()
+	})
 }
 
-// Stop 停止聚合器，清理资源
+// Stop 停止聚合器
+// 等待定时器循环退出后同步执行最终刷新，使最后一个周期的数据上报后再返回；重复调用无效
 func (a *Aggregator) Stop() {
-	a.running = false
-	close(a.stopCh)
+	a.stopOnce.Do(func() {
+		a.running = false
+		close(a.stopCh)
+		a.loopWg.Wait()
+		a.flush()
+	})
 }
 
 // timerLoop 定时器循环，定期刷新和上报数据
+// 超过高水位的提前刷新也在此处理，两者不会并发执行
 func (a *Aggregator) timerLoop() {
+	defer a.loopWg.Done()
 	ticker := time.NewTicker(time.Second * time.Duration(reportInterval))
 	defer ticker.Stop()
 
@@ -103,12 +168,23 @@ func (a *Aggregator) timerLoop() {
 		select {
 		case <-ticker.C:
 			a.flush() // 定时刷新数据
+		case <-a.flushCh:
+			a.earlyFlushes.Add(1)
+			a.flush()
 		case <-a.stopCh:
 			return
 		}
 	}
 }
 
+// triggerFlush 请求提前刷新，已有未处理的请求时忽略
+func (a *Aggregator) triggerFlush() {
+	select {
+	case a.flushCh <- struct{}{}:
+	default:
+	}
+}
+
 // flush 刷新缓存数据，执行威胁日志上报、连接更新和连接上报
 func (a *Aggregator) flush() {
 	a.putThreatLogs()    // 上报威胁日志
@@ -120,7 +196,12 @@ func (a *Aggregator) flush() {
 func (a *Aggregator) AddConnection(data *agent.ConnectionData) {
 	a.connsCacheMux.Lock()
 	a.connsCache = append(a.connsCache, data)
+	pending := len(a.connsCache)
 	a.connsCacheMux.Unlock()
+
+	if int64(pending) >= a.flushHighWater.Load() {
+		a.triggerFlush()
+	}
 }
 
 // AddThreatLog 添加威胁日志到缓存
@@ -131,6 +212,7 @@ func (a *Aggregator) AddThreatLog(mac net.HardwareAddr, slog *agent.ThreatLog) {
 }
 
 // updateConnections 处理缓存的连接数据，更新到聚合映射表
+// 映射表超过高水位时先上报已有连接再继续，避免突发流量填满映射表而丢弃
 func (a *Aggregator) updateConnections() {
 	a.connsCacheMux.Lock()
 	conns := a.connsCache
@@ -142,19 +224,41 @@ func (a *Aggregator) updateConnections() {
 		conn.AgentID = a.agentID
 		conn.HostID = a.hostID
 		a.updateConnectionMap(conn)
+
+		if int64(a.GetConnectionCount()) >= a.flushHighWater.Load() {
+			a.earlyFlushes.Add(1)
+			a.putConnections()
+		}
+	}
+}
+
+// connKeyLen 连接键长度：客户端IP(16)、服务端IP(16)、端口(2)、协议(1)、方向(1)、策略ID(4)、应用(4)
+const connKeyLen = 16 + 16 + 2 + 1 + 1 + 4 + 4
+
+// connectionKey 按定长字节编码生成连接键
+// IP统一为16字节形式，IPv4与IPv4-in-IPv6表示得到相同的键；无效IP编码为全0
+func connectionKey(client, server net.IP, port uint16, proto uint8, ingress bool, policyID, app uint32) string {
+	var b [connKeyLen]byte
+	copy(b[0:16], client.To16())
+	copy(b[16:32], server.To16())
+	binary.BigEndian.PutUint16(b[32:34], port)
+	b[34] = proto
+	if ingress {
+		b[35] = 1
 	}
+	binary.BigEndian.PutUint32(b[36:40], policyID)
+	binary.BigEndian.PutUint32(b[40:44], app)
+	return string(b[:])
 }
 
 // keyTCPUDPConnection 为TCP/UDP连接生成唯一键
 func keyTCPUDPConnection(conn *agent.Connection) string {
-	return fmt.Sprintf("%v-%v-%v-%v-%v-%v-%v",
-		conn.ClientIP, conn.ServerIP, conn.ServerPort, conn.IPProto, conn.Ingress, conn.PolicyId, conn.Application)
+	return connectionKey(conn.ClientIP, conn.ServerIP, conn.ServerPort, conn.IPProto, conn.Ingress, conn.PolicyId, conn.Application)
 }
 
-// keyOtherConnection 为其他协议连接生成唯一键
+// keyOtherConnection 为其他协议连接生成唯一键，不区分端口和协议
 func keyOtherConnection(conn *agent.Connection) string {
-	return fmt.Sprintf("%v-%v-%v-%v-%v",
-		conn.ClientIP, conn.ServerIP, conn.Ingress, conn.PolicyId, conn.Application)
+	return connectionKey(conn.ClientIP, conn.ServerIP, 0, 0, conn.Ingress, conn.PolicyId, conn.Application)
 }
 
 // updateConnectionMap 更新连接聚合映射表，合并相同连接的统计信息
@@ -172,8 +276,11 @@ func (a *Aggregator) updateConnectionMap(conn *agent.Connection) {
 	if entry, exist := a.connectionMap[key]; exist {
 		// 更新已存在的连接统计信息
 		entry.Bytes += conn.Bytes
-		entry.Sessions += conn.Sessions
-		entry.Violates += conn.Violates
+		var capped bool
+		entry.Sessions, capped = addSaturating(entry.Sessions, conn.Sessions)
+		entry.Capped = entry.Capped || capped
+		entry.Violates, capped = addSaturating(entry.Violates, conn.Violates)
+		entry.Capped = entry.Capped || capped || conn.Capped
 		if entry.LastSeenAt <= conn.LastSeenAt {
 			entry.LastSeenAt = conn.LastSeenAt
 			entry.PolicyAction = conn.PolicyAction
@@ -183,36 +290,153 @@ func (a *Aggregator) updateConnectionMap(conn *agent.Connection) {
 			entry.Severity = conn.Severity
 			entry.ThreatID = conn.ThreatID
 		}
-	} else if len(a.connectionMap) < connectionMapMax || conn.PolicyAction > uint8(agent.PolicyActionAllow) {
-		// 新连接：容量未满或高优先级（VIOLATE/DENY）
+		entry.L7 = mergeL7(entry.L7, conn.L7)
+	} else if len(a.connectionMap) < connectionMapMax || conn.PolicyAction > uint8(agent.PolicyActionAllow) ||
+		a.isLogged(conn.PolicyId) {
+		// 新连接：容量未满或高优先级（VIOLATE/DENY或开启审计的规则）
 		a.connectionMap[key] = conn
+		a.connCount.Add(1)
+	} else if a.summarize(conn) {
+		a.summarized.Add(1)
 	} else {
+		a.droppedCount.Add(1)
 		log.WithFields(log.Fields{
 			"conn": conn, "len": len(a.connectionMap),
 		}).Debug("Connection map full -- drop")
 	}
 }
 
+// summarize 将低优先级连接合并到其客户端/服务端对的流量汇总，调用方需持有锁
+// 汇总保留字节数和会话数总量，不区分端口、协议、应用和规则；汇总表也满时返回false
+func (a *Aggregator) summarize(conn *agent.Connection) bool {
+	key := connectionKey(conn.ClientIP, conn.ServerIP, 0, 0, conn.Ingress, 0, 0)
+	entry, exist := a.summaryMap[key]
+	if !exist {
+		if len(a.summaryMap) >= summaryMapMax {
+			return false
+		}
+		a.summaryMap[key] = &agent.Connection{
+			AgentID:      conn.AgentID,
+			HostID:       conn.HostID,
+			ClientWL:     conn.ClientWL,
+			ServerWL:     conn.ServerWL,
+			ClientIP:     conn.ClientIP,
+			ServerIP:     conn.ServerIP,
+			Bytes:        conn.Bytes,
+			Sessions:     conn.Sessions,
+			FirstSeenAt:  conn.FirstSeenAt,
+			LastSeenAt:   conn.LastSeenAt,
+			PolicyAction: conn.PolicyAction,
+			Ingress:      conn.Ingress,
+			ExternalPeer: conn.ExternalPeer,
+			LocalPeer:    conn.LocalPeer,
+			Capped:       conn.Capped,
+			Summary:      true,
+
+			InternetIngress: conn.InternetIngress,
+		}
+		return true
+	}
+
+	entry.Bytes += conn.Bytes
+	var capped bool
+	entry.Sessions, capped = addSaturating(entry.Sessions, conn.Sessions)
+	entry.Capped = entry.Capped || capped || conn.Capped
+	if conn.FirstSeenAt < entry.FirstSeenAt {
+		entry.FirstSeenAt = conn.FirstSeenAt
+	}
+	if conn.LastSeenAt > entry.LastSeenAt {
+		entry.LastSeenAt = conn.LastSeenAt
+	}
+	if conn.PolicyAction > entry.PolicyAction {
+		entry.PolicyAction = conn.PolicyAction
+	}
+	return true
+}
+
+// isLogged 检查连接命中的规则是否开启审计日志
+func (a *Aggregator) isLogged(policyID uint32) bool {
+	return policyID != 0 && a.loggedPolicy != nil && a.loggedPolicy(policyID)
+}
+
+// addSaturating uint32计数累加，溢出时停在最大值而不回绕
+// 第二个返回值表示是否已饱和
+func addSaturating(a, b uint32) (uint32, bool) {
+	if a > math.MaxUint32-b {
+		return math.MaxUint32, true
+	}
+	return a + b, false
+}
+
+// mergeL7 合并应用层元数据，去重并限制条数
+func mergeL7(dst, src []agent.L7Meta) []agent.L7Meta {
+	for _, m := range src {
+		if len(dst) >= l7MetaMax {
+			break
+		}
+		dup := false
+		for _, d := range dst {
+			if d == m {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			dst = append(dst, m)
+		}
+	}
+	return dst
+}
+
 // putConnections 批量上报连接数据给Controller
+// 按connectionListMax分批循环上报，直到映射表清空、达到总量上限或超出时间预算
 func (a *Aggregator) putConnections() {
-	var list []*agent.Connection
-	var keys []string
+	start := time.Now()
+	total := 0
+	for total < connectionMapMax+summaryMapMax && time.Since(start) < flushTimeBudget {
+		list := a.takeConnections(connectionListMax)
+		if len(list) == 0 {
+			return
+		}
+		total += len(list)
+		a.reportedCount.Add(uint64(len(list)))
 
+		if a.onConnections != nil {
+			a.onConnections(list)
+		}
+	}
+
+	if remain := a.GetConnectionCount(); remain > 0 {
+		log.WithFields(log.Fields{
+			"reported": total, "remain": remain,
+		}).Debug("Connection flush budget exhausted")
+	}
+}
+
+// takeConnections 从映射表中取出最多max条连接，映射表取空后再取流量汇总
+func (a *Aggregator) takeConnections(max int) []*agent.Connection {
 	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	var list []*agent.Connection
 	for key, conn := range a.connectionMap {
 		list = append(list, conn)
-		keys = append(keys, key)
 		delete(a.connectionMap, key)
 
-		if len(list) == connectionListMax {
+		if len(list) == max {
 			break
 		}
 	}
-	a.mutex.Unlock()
+	a.connCount.Add(-int64(len(list)))
 
-	if len(list) > 0 && a.onConnections != nil {
-		a.onConnections(list)
+	for key, conn := range a.summaryMap {
+		if len(list) == max {
+			break
+		}
+		list = append(list, conn)
+		delete(a.summaryMap, key)
 	}
+	return list
 }
 
 // putThreatLogs 批量上报威胁日志给Controller
@@ -233,9 +457,27 @@ func (a *Aggregator) putThreatLogs() {
 
 // GetConnectionCount 获取当前连接映射表中的连接数量
 func (a *Aggregator) GetConnectionCount() int {
-	a.mutex.Lock()
-	defer a.mutex.Unlock()
-	return len(a.connectionMap)
+	return int(a.connCount.Load())
+}
+
+// GetDroppedCount 获取因映射表满而丢弃的连接数量
+func (a *Aggregator) GetDroppedCount() uint64 {
+	return a.droppedCount.Load()
+}
+
+// GetSummarizedCount 获取因映射表满而合并到流量汇总的连接数量
+func (a *Aggregator) GetSummarizedCount() uint64 {
+	return a.summarized.Load()
+}
+
+// GetReportedCount 获取已上报的连接数量
+func (a *Aggregator) GetReportedCount() uint64 {
+	return a.reportedCount.Load()
+}
+
+// GetEarlyFlushCount 获取因超过高水位提前上报的次数
+func (a *Aggregator) GetEarlyFlushCount() uint64 {
+	return a.earlyFlushes.Load()
 }
 
 // GetMaxConnections 获取连接映射表的最大容量
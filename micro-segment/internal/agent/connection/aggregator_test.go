@@ -0,0 +1,498 @@
+package connection
+
+import (
+	"math"
+	"net"
+	"runtime"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/micro-segment/internal/agent"
+)
+
+// makeConn 生成第i条不同的TCP连接
+func makeConn(i int) *agent.Connection {
+	return &agent.Connection{
+		ClientIP:   net.IPv4(10, byte(i>>16), byte(i>>8), byte(i)),
+		ServerIP:   net.IPv4(10, 1, 0, 1),
+		ServerPort: 80,
+		IPProto:    syscall.IPPROTO_TCP,
+		Bytes:      100,
+		Sessions:   1,
+	}
+}
+
+func TestFlushDrainsConnectionMap(t *testing.T) {
+	a := NewAggregator("agent", "host")
+
+	var batches, total int
+	a.SetOnConnections(func(conns []*agent.Connection) {
+		if len(conns) > connectionListMax {
+			t.Errorf("Batch too large: %d", len(conns))
+		}
+		batches++
+		total += len(conns)
+	})
+
+	count := connectionListMax*2 + 10
+	for i := 0; i < count; i++ {
+		a.updateConnectionMap(makeConn(i))
+	}
+
+	a.flush()
+
+	if n := a.GetConnectionCount(); n != 0 {
+		t.Errorf("Connection map not drained: %d", n)
+	}
+	if total != count || batches != 3 {
+		t.Errorf("Unexpected report: batches=%d total=%d", batches, total)
+	}
+}
+
+func TestConnectionCountConsistency(t *testing.T) {
+	a := NewAggregator("agent", "host")
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				a.updateConnectionMap(makeConn(w*2000 + i))
+				if i%100 == 0 {
+					a.takeConnections(50)
+				}
+			}
+		}(w)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			a.GetConnectionCount()
+		}
+	}()
+	wg.Wait()
+
+	a.mutex.Lock()
+	size := len(a.connectionMap)
+	a.mutex.Unlock()
+	if n := a.GetConnectionCount(); n != size {
+		t.Errorf("Count %d mismatches map size %d", n, size)
+	}
+}
+
+func TestDroppedCount(t *testing.T) {
+	a := NewAggregator("agent", "host")
+	// 映射表和汇总表均满后才丢弃
+	for i := 0; i < connectionMapMax+summaryMapMax+5; i++ {
+		a.updateConnectionMap(makeConn(i))
+	}
+	if n := a.GetConnectionCount(); n != connectionMapMax {
+		t.Errorf("Unexpected count: %d", n)
+	}
+	if n := a.GetSummarizedCount(); n != uint64(summaryMapMax) {
+		t.Errorf("Unexpected summarized: %d", n)
+	}
+	if n := a.GetDroppedCount(); n != 5 {
+		t.Errorf("Unexpected dropped: %d", n)
+	}
+
+	a.flush()
+	if n := a.GetReportedCount(); n != uint64(connectionMapMax+summaryMapMax) {
+		t.Errorf("Unexpected reported: %d", n)
+	}
+}
+
+func TestSummaryWhenFull(t *testing.T) {
+	a := NewAggregator("agent", "host")
+	var reported []*agent.Connection
+	a.SetOnConnections(func(conns []*agent.Connection) { reported = append(reported, conns...) })
+
+	var total uint64
+	for i := 0; i < connectionMapMax; i++ {
+		conn := makeConn(i)
+		total += conn.Bytes
+		a.updateConnectionMap(conn)
+	}
+
+	// 同一端点对不同端口的低优先级连接合并为一条汇总
+	client := net.IPv4(10, 200, 0, 1)
+	for port := 1000; port < 1100; port++ {
+		conn := makeConn(0)
+		conn.ClientIP, conn.ServerPort = client, uint16(port)
+		conn.FirstSeenAt, conn.LastSeenAt = uint32(port), uint32(port)
+		conn.PolicyAction = uint8(agent.PolicyActionAllow)
+		total += conn.Bytes
+		a.updateConnectionMap(conn)
+	}
+	// 高优先级连接仍单独保留
+	deny := makeConn(0)
+	deny.ClientIP, deny.ServerPort, deny.PolicyAction = client, 22, uint8(agent.PolicyActionDeny)
+	total += deny.Bytes
+	a.updateConnectionMap(deny)
+
+	if n := a.GetSummarizedCount(); n != 100 {
+		t.Errorf("Unexpected summarized: %d", n)
+	}
+	if n := a.GetDroppedCount(); n != 0 {
+		t.Errorf("Unexpected dropped: %d", n)
+	}
+
+	a.flush()
+	var sum uint64
+	var summaries []*agent.Connection
+	for _, conn := range reported {
+		sum += conn.Bytes
+		if conn.Summary {
+			summaries = append(summaries, conn)
+		}
+	}
+	if sum != total {
+		t.Errorf("Reported bytes %d, want %d", sum, total)
+	}
+	if len(summaries) != 1 {
+		t.Fatalf("Unexpected summaries: %d", len(summaries))
+	}
+	s := summaries[0]
+	if !s.ClientIP.Equal(client) || s.ServerPort != 0 || s.IPProto != 0 || s.Bytes != 10000 || s.Sessions != 100 ||
+		s.FirstSeenAt != 1000 || s.LastSeenAt != 1099 || s.PolicyAction != uint8(agent.PolicyActionAllow) {
+		t.Errorf("Unexpected summary: %+v", s)
+	}
+	if len(reported) != connectionMapMax+2 {
+		t.Errorf("Unexpected reported count: %d", len(reported))
+	}
+}
+
+func TestLoggedPolicyKeptWhenFull(t *testing.T) {
+	a := NewAggregator("agent", "host")
+	a.SetLoggedPolicy(func(policyID uint32) bool { return policyID == 7 })
+	for i := 0; i < connectionMapMax; i++ {
+		a.updateConnectionMap(makeConn(i))
+	}
+
+	logged := makeConn(connectionMapMax)
+	logged.PolicyId, logged.PolicyAction = 7, uint8(agent.PolicyActionAllow)
+	a.updateConnectionMap(logged)
+	plain := makeConn(connectionMapMax + 1)
+	plain.PolicyId, plain.PolicyAction = 8, uint8(agent.PolicyActionAllow)
+	a.updateConnectionMap(plain)
+
+	if n := a.GetConnectionCount(); n != connectionMapMax+1 {
+		t.Errorf("Unexpected count: %d", n)
+	}
+	if n := a.GetSummarizedCount(); n != 1 {
+		t.Errorf("Unexpected summarized: %d", n)
+	}
+}
+
+// BenchmarkCountUnderLoad 在连接映射表持续写入时读取统计
+func BenchmarkCountUnderLoad(b *testing.B) {
+	a := NewAggregator("agent", "host")
+	stop := make(chan struct{})
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				a.updateConnectionMap(makeConn(i % 60000))
+			}
+		}
+	}()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		a.GetConnectionCount()
+	}
+	b.StopTimer()
+	close(stop)
+}
+
+func TestL7MetaMerge(t *testing.T) {
+	a := NewAggregator("agent", "host")
+
+	hosts := []string{"a.example.com", "b.example.com", "a.example.com",
+		"c.example.com", "d.example.com", "e.example.com"}
+	for _, h := range hosts {
+		conn := makeConn(1)
+		conn.L7 = []agent.L7Meta{{HTTPMethod: "GET", HTTPHost: h}}
+		a.updateConnectionMap(conn)
+	}
+
+	// L7元数据不参与聚合键
+	if n := a.GetConnectionCount(); n != 1 {
+		t.Fatalf("Expected 1 aggregated connection, got %d", n)
+	}
+	list := a.takeConnections(connectionListMax)
+	l7 := list[0].L7
+	if len(l7) != l7MetaMax {
+		t.Fatalf("Expected %d L7 variants, got %d", l7MetaMax, len(l7))
+	}
+	if l7[0].HTTPHost != "a.example.com" || l7[1].HTTPHost != "b.example.com" || l7[2].HTTPHost != "c.example.com" {
+		t.Errorf("Unexpected L7 variants: %+v", l7)
+	}
+}
+
+func TestCounterSaturation(t *testing.T) {
+	a := NewAggregator("agent", "host")
+
+	conn := makeConn(1)
+	conn.Sessions = math.MaxUint32 - 5
+	conn.Violates = 10
+	a.updateConnectionMap(conn)
+
+	more := makeConn(1)
+	more.Sessions = 10
+	more.Violates = 10
+	a.updateConnectionMap(more)
+
+	list := a.takeConnections(connectionListMax)
+	if len(list) != 1 {
+		t.Fatalf("Expected 1 connection, got %d", len(list))
+	}
+	c := list[0]
+	if c.Sessions != math.MaxUint32 || !c.Capped {
+		t.Errorf("Sessions not saturated: %d capped=%v", c.Sessions, c.Capped)
+	}
+	if c.Violates != 20 {
+		t.Errorf("Unexpected violates: %d", c.Violates)
+	}
+	if c.Bytes != 200 {
+		t.Errorf("Unexpected bytes: %d", c.Bytes)
+	}
+
+	// 饱和后继续累加保持最大值
+	conn = makeConn(2)
+	conn.Violates = math.MaxUint32
+	a.updateConnectionMap(conn)
+	more = makeConn(2)
+	more.Violates = 1
+	a.updateConnectionMap(more)
+	list = a.takeConnections(connectionListMax)
+	if list[0].Violates != math.MaxUint32 || !list[0].Capped {
+		t.Errorf("Violates not saturated: %d capped=%v", list[0].Violates, list[0].Capped)
+	}
+}
+
+func TestAddSaturating(t *testing.T) {
+	cases := []struct {
+		a, b   uint32
+		sum    uint32
+		capped bool
+	}{
+		{1, 2, 3, false},
+		{math.MaxUint32 - 1, 1, math.MaxUint32, false},
+		{math.MaxUint32 - 1, 2, math.MaxUint32, true},
+		{math.MaxUint32, math.MaxUint32, math.MaxUint32, true},
+	}
+	for _, tc := range cases {
+		if sum, capped := addSaturating(tc.a, tc.b); sum != tc.sum || capped != tc.capped {
+			t.Errorf("addSaturating(%d, %d) = %d, %v", tc.a, tc.b, sum, capped)
+		}
+	}
+}
+
+func TestFlushOnHighWater(t *testing.T) {
+	a := NewAggregator("agent", "host")
+
+	var mutex sync.Mutex
+	total := 0
+	a.SetOnConnections(func(conns []*agent.Connection) {
+		mutex.Lock()
+		total += len(conns)
+		mutex.Unlock()
+	})
+
+	// 写入速度远快于定时上报周期，突发在循环处理前全部进入待处理缓存
+	count := connectionMapMax + connectionListMax
+	for i := 0; i < count; i++ {
+		a.AddConnection(&agent.ConnectionData{Conn: makeConn(i)})
+	}
+	a.Start()
+	defer a.Stop()
+
+	deadline := time.Now().Add(time.Duration(reportInterval) * time.Second / 2)
+	for {
+		mutex.Lock()
+		n := total
+		mutex.Unlock()
+		if n == count {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("No early flush before timer: reported=%d early=%d", n, a.GetEarlyFlushCount())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 待处理缓存触发一次提前刷新，处理过程中映射表每达到高水位上报一次，不再有多余的刷新
+	time.Sleep(50 * time.Millisecond)
+	want := uint64(1 + count/int(a.flushHighWater.Load()))
+	if n := a.GetEarlyFlushCount(); n != want {
+		t.Errorf("Unexpected early flushes: %d, want %d", n, want)
+	}
+	if n := a.GetDroppedCount(); n != 0 {
+		t.Errorf("Connections dropped: %d", n)
+	}
+}
+
+func TestStopFlushes(t *testing.T) {
+	a := NewAggregator("agent", "host")
+
+	var mutex sync.Mutex
+	var conns []*agent.Connection
+	var threats []*agent.ThreatLog
+	a.SetOnConnections(func(list []*agent.Connection) {
+		mutex.Lock()
+		conns = append(conns, list...)
+		mutex.Unlock()
+	})
+	a.SetOnThreatLogs(func(list []*agent.ThreatLog) {
+		mutex.Lock()
+		threats = append(threats, list...)
+		mutex.Unlock()
+	})
+	a.Start()
+
+	// 不等定时上报直接停止
+	const count = 10
+	for i := 0; i < count; i++ {
+		a.AddConnection(&agent.ConnectionData{Conn: makeConn(i)})
+	}
+	a.AddThreatLog(nil, &agent.ThreatLog{ThreatID: 1001})
+	a.Stop()
+
+	mutex.Lock()
+	if len(conns) != count || len(threats) != 1 {
+		t.Errorf("Final flush incomplete: conns=%d threats=%d", len(conns), len(threats))
+	}
+	if conns[0].AgentID != "agent" {
+		t.Errorf("Unexpected connection: %+v", conns[0])
+	}
+	mutex.Unlock()
+
+	// 重复停止不会再次关闭通道
+	a.Stop()
+
+	// 未启动时停止同样执行刷新
+	b := NewAggregator("agent", "host")
+	flushed := 0
+	b.SetOnConnections(func(list []*agent.Connection) { flushed += len(list) })
+	b.AddConnection(&agent.ConnectionData{Conn: makeConn(0)})
+	b.Stop()
+	if flushed != 1 {
+		t.Errorf("Unstarted aggregator not flushed: %d", flushed)
+	}
+}
+
+func TestStartIdempotent(t *testing.T) {
+	a := NewAggregator("agent", "host")
+	before := runtime.NumGoroutine()
+	a.Start()
+	a.Start()
+	if n := runtime.NumGoroutine() - before; n != 1 {
+		t.Errorf("Expected 1 timer loop, got %d new goroutines", n)
+	}
+
+	a.Stop()
+	a.Stop()
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > before && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("Timer loop leaked: %d goroutines, %d before start", n, before)
+	}
+}
+
+func TestFlushFraction(t *testing.T) {
+	a := NewAggregator("agent", "host")
+	a.SetFlushFraction(0.01)
+	highWater := int(a.flushHighWater.Load())
+	if highWater != connectionMapMax/100 {
+		t.Fatalf("Unexpected high water: %d", highWater)
+	}
+
+	a.SetOnConnections(func(conns []*agent.Connection) {})
+
+	// 突发量远小于映射表容量，但超过配置的阈值
+	for i := 0; i < highWater*2; i++ {
+		a.AddConnection(&agent.ConnectionData{Conn: makeConn(i)})
+	}
+	a.Start()
+	defer a.Stop()
+
+	deadline := time.Now().Add(time.Duration(reportInterval) * time.Second / 2)
+	for a.GetReportedCount() != uint64(highWater*2) {
+		if time.Now().After(deadline) {
+			t.Fatalf("No early flush before timer: reported=%d", a.GetReportedCount())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 待处理缓存触发一次，映射表两次达到阈值各上报一次
+	time.Sleep(50 * time.Millisecond)
+	if n := a.GetEarlyFlushCount(); n != 3 {
+		t.Errorf("Unexpected early flushes: %d, want 3", n)
+	}
+
+	// 非法值恢复默认阈值
+	a.SetFlushFraction(1.5)
+	fraction := defaultFlushFraction
+	if n := a.flushHighWater.Load(); n != int64(float64(connectionMapMax)*fraction) {
+		t.Errorf("Expected default high water, got %d", n)
+	}
+}
+
+func TestConnectionKeyCanonicalIP(t *testing.T) {
+	v4 := makeConn(1)
+	v4.ClientIP = v4.ClientIP.To4()
+	v4.ServerIP = v4.ServerIP.To4()
+	mapped := makeConn(1) // net.IPv4返回16字节IPv4-in-IPv6形式
+	if len(v4.ClientIP) == len(mapped.ClientIP) {
+		t.Fatalf("Test connections use the same IP representation")
+	}
+	if keyTCPUDPConnection(v4) != keyTCPUDPConnection(mapped) {
+		t.Errorf("Equivalent TCP connections got different keys")
+	}
+
+	icmp := func(ip net.IP) *agent.Connection {
+		return &agent.Connection{ClientIP: ip, ServerIP: net.ParseIP("10.1.0.1"), IPProto: syscall.IPPROTO_ICMP}
+	}
+	if keyOtherConnection(icmp(net.IPv4(10, 0, 0, 1))) != keyOtherConnection(icmp(net.IPv4(10, 0, 0, 1).To4())) {
+		t.Errorf("Equivalent ICMP connections got different keys")
+	}
+
+	// 合并为同一条连接
+	a := NewAggregator("agent", "host")
+	a.updateConnectionMap(v4)
+	a.updateConnectionMap(mapped)
+	if n := a.GetConnectionCount(); n != 1 {
+		t.Fatalf("Expected 1 connection, got %d", n)
+	}
+	for _, conn := range a.connectionMap {
+		if conn.Sessions != 2 {
+			t.Errorf("Sessions not merged: %d", conn.Sessions)
+		}
+	}
+
+	// 任一字段不同则键不同
+	base := keyTCPUDPConnection(makeConn(1))
+	for name, mod := range map[string]func(*agent.Connection){
+		"client":  func(c *agent.Connection) { c.ClientIP = net.ParseIP("::ffff:10.0.0.2") },
+		"server":  func(c *agent.Connection) { c.ServerIP = net.ParseIP("fd00::1") },
+		"port":    func(c *agent.Connection) { c.ServerPort = 81 },
+		"proto":   func(c *agent.Connection) { c.IPProto = syscall.IPPROTO_UDP },
+		"ingress": func(c *agent.Connection) { c.Ingress = true },
+		"policy":  func(c *agent.Connection) { c.PolicyId = 1 },
+		"app":     func(c *agent.Connection) { c.Application = 1 },
+	} {
+		conn := makeConn(1)
+		mod(conn)
+		if keyTCPUDPConnection(conn) == base {
+			t.Errorf("%s change did not change key", name)
+		}
+	}
+}
@@ -6,21 +6,37 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// defaultDialTimeout 默认连接DP的超时时间
+const defaultDialTimeout = 5 * time.Second
+
 // DPClient DP层客户端
 type DPClient struct {
-	mutex      sync.Mutex
-	socketPath string
+	mutex       sync.Mutex
+	socketPath  string
+	localPath   string        // 本地绑定地址，DP据此回发消息
+	dialTimeout time.Duration // 连接超时，0表示不限
 	conn       net.Conn
 	connected  bool
+	readerDone chan struct{} // 读取循环退出时关闭
+
+	// 读取统计，原子读写
+	messages    atomic.Uint64
+	connections atomic.Uint64
+	threats     atomic.Uint64
+	parseErrors atomic.Uint64
 
 	// 回调
 	onConnection func(*DPConnection)
 	onThreatLog  func(*DPThreatLog)
+	onConnect    func() // 建立连接后调用，DP重启后需重新下发配置
 }
 
 // DPConnection DP连接数据
@@ -39,14 +55,22 @@ type DPConnection struct {
 	Severity     uint8
 	PolicyAction uint8
 	PolicyId     uint32
-	Ingress      bool
+	Ingress      *bool // 方向，DP未判定时为nil
 	ExternalPeer bool
 	EPMAC        net.HardwareAddr
+	TcpFlags     uint8 // 首个数据包的TCP标志位，抓包来源提供
+
+	// 应用层元数据，DP未解析时为空
+	HTTPMethod string
+	HTTPHost   string
+	DNSQuery   string
+	TLSSNI     string
 }
 
 // DPThreatLog DP威胁日志
 type DPThreatLog struct {
 	ThreatID   uint32
+	ThreatName string
 	Severity   uint8
 	ClientIP   net.IP
 	ServerIP   net.IP
@@ -54,6 +78,11 @@ type DPThreatLog struct {
 	IPProto    uint8
 	PktIngress bool
 	EPMAC      net.HardwareAddr
+
+	// 数据包上下文，DP未提供时为零值
+	TcpFlags   uint8
+	PktLen     uint16
+	PktSummary string
 }
 
 // DPPolicy DP策略
@@ -75,48 +104,88 @@ type DPPolicy struct {
 // 初始化Unix socket连接配置
 func NewDPClient(socketPath string) *DPClient {
 	return &DPClient{
-		socketPath: socketPath,
+		socketPath:  socketPath,
+		localPath:   fmt.Sprintf("/tmp/dp_client.%d.sock", os.Getpid()),
+		dialTimeout: defaultDialTimeout,
 	}
 }
 
+// SetDialTimeout 设置连接DP的超时时间，0表示不限
+func (c *DPClient) SetDialTimeout(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.dialTimeout = d
+}
+
 // Connect 连接到DP
-// 建立Unix datagram socket连接，启动消息读取循环
+// 校验DP套接字后建立Unix datagram socket连接，启动消息读取循环
+// 新建立连接时在释放锁后调用onConnect
 func (c *DPClient) Connect() error {
+	connected, err := c.connect()
+	if connected && c.onConnect != nil {
+		c.onConnect()
+	}
+	return err
+}
+
+// connect 建立连接，返回是否新建立了连接
+func (c *DPClient) connect() (bool, error) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
 	if c.connected {
-		return nil
+		return false, nil
+	}
+
+	if err := validateSocket(c.socketPath); err != nil {
+		return false, err
 	}
 
 	// DP uses Unix datagram socket (SOCK_DGRAM), so we use "unixgram"
-	addr := &net.UnixAddr{Name: c.socketPath, Net: "unixgram"}
-	conn, err := net.DialUnix("unixgram", nil, addr)
+	// 数据报套接字需绑定本地地址才能收到DP的消息，清理上次残留的文件
+	os.Remove(c.localPath)
+	dialer := net.Dialer{
+		Timeout:   c.dialTimeout,
+		LocalAddr: &net.UnixAddr{Name: c.localPath, Net: "unixgram"},
+	}
+	conn, err := dialer.Dial("unixgram", c.socketPath)
 	if err != nil {
-		return fmt.Errorf("failed to connect to DP: %v", err)
+		return false, fmt.Errorf("failed to connect to DP: %v", err)
+	}
+
+	// 本地套接字仅允许当前用户访问
+	if err := os.Chmod(c.localPath, 0o600); err != nil {
+		conn.Close()
+		os.Remove(c.localPath)
+		return false, fmt.Errorf("failed to set local socket permission: %v", err)
 	}
 
 	c.conn = conn
 	c.connected = true
+	c.readerDone = make(chan struct{})
 
-	go c.readLoop()
+	go c.readLoop(conn, c.readerDone)
 
 	log.WithField("socket", c.socketPath).Info("Connected to DP")
-	return nil
+	return true, nil
 }
 
 // Disconnect 断开连接
-// 关闭socket连接，停止消息处理
+// 关闭socket连接，等待读取循环退出
 func (c *DPClient) Disconnect() {
 	c.mutex.Lock()
-	defer c.mutex.Unlock()
-
 	if !c.connected {
+		c.mutex.Unlock()
 		return
 	}
 
 	c.conn.Close()
+	os.Remove(c.localPath)
 	c.connected = false
+	done := c.readerDone
+	c.mutex.Unlock()
+
+	<-done
 }
 
 // IsConnected 检查是否已连接
@@ -139,35 +208,75 @@ func (c *DPClient) SetOnThreatLog(cb func(*DPThreatLog)) {
 	c.onThreatLog = cb
 }
 
+// SetOnConnect 设置建立连接回调
+// 每次新建立连接后调用，用于DP重连后重新下发策略等配置
+func (c *DPClient) SetOnConnect(cb func()) {
+	c.onConnect = cb
+}
+
 // readLoop 读取循环
-// 持续读取DP消息并分发处理
-func (c *DPClient) readLoop() {
+// 持续读取DP消息并分发处理，连接关闭后退出
+func (c *DPClient) readLoop(conn net.Conn, done chan struct{}) {
+	defer close(done)
+
 	buf := make([]byte, 65536)
 	for {
-		n, err := c.conn.Read(buf)
+		n, err := conn.Read(buf)
 		if err != nil {
-			log.WithError(err).Error("DP read error")
 			c.mutex.Lock()
-			c.connected = false
+			if c.connected && c.conn == conn {
+				// 非主动断开
+				log.WithError(err).WithField("socket", c.socketPath).Error("DP read error")
+				c.connected = false
+			}
 			c.mutex.Unlock()
 			return
 		}
 
+		c.messages.Add(1)
 		c.handleMessage(buf[:n])
 	}
 }
 
+// ReaderStats DP读取统计
+type ReaderStats struct {
+	Messages    uint64 `json:"messages"`
+	Connections uint64 `json:"connections"`
+	Threats     uint64 `json:"threats"`
+	ParseErrors uint64 `json:"parse_errors"`
+}
+
+// add 累加统计
+func (s *ReaderStats) add(o ReaderStats) {
+	s.Messages += o.Messages
+	s.Connections += o.Connections
+	s.Threats += o.Threats
+	s.ParseErrors += o.ParseErrors
+}
+
+// Stats 获取读取统计
+func (c *DPClient) Stats() ReaderStats {
+	return ReaderStats{
+		Messages:    c.messages.Load(),
+		Connections: c.connections.Load(),
+		Threats:     c.threats.Load(),
+		ParseErrors: c.parseErrors.Load(),
+	}
+}
+
 // handleMessage 处理消息
 // 解析JSON消息并调用相应回调函数
 func (c *DPClient) handleMessage(data []byte) {
 	var msg DPMessage
 	if err := json.Unmarshal(data, &msg); err != nil {
+		c.parseErrors.Add(1)
 		log.WithError(err).Error("Failed to parse DP message")
 		return
 	}
 
 	switch msg.Type {
 	case "connection":
+		c.connections.Add(1)
 		if c.onConnection != nil {
 			var conn DPConnection
 			if err := json.Unmarshal(msg.Data, &conn); err == nil {
@@ -175,6 +284,7 @@ func (c *DPClient) handleMessage(data []byte) {
 			}
 		}
 	case "threat":
+		c.threats.Add(1)
 		if c.onThreatLog != nil {
 			var threat DPThreatLog
 			if err := json.Unmarshal(msg.Data, &threat); err == nil {
@@ -273,6 +383,35 @@ func (c *DPClient) DelMAC(mac net.HardwareAddr) error {
 	return err
 }
 
+// SetWorkloadMode 设置工作负载策略模式
+// DP按模式决定对违规连接仅告警（Monitor）还是阻断（Protect）
+func (c *DPClient) SetWorkloadMode(workloadID, mode string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.connected {
+		return fmt.Errorf("not connected to DP")
+	}
+
+	msg := struct {
+		Type       string `json:"type"`
+		WorkloadID string `json:"workload_id"`
+		Mode       string `json:"mode"`
+	}{
+		Type:       "workload_mode",
+		WorkloadID: workloadID,
+		Mode:       mode,
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.conn.Write(data)
+	return err
+}
+
 // ConfigSubnets 配置内部子网
 // 设置DP的内部网络子网范围
 func (c *DPClient) ConfigSubnets(subnets []net.IPNet) error {
@@ -0,0 +1,246 @@
+package dp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// listenUnixgram 在临时目录创建一个数据报套接字
+func listenUnixgram(t *testing.T, name string) (string, *net.UnixConn) {
+	path := filepath.Join(t.TempDir(), name)
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return path, conn
+}
+
+// asUser 模拟无CAP_DAC_OVERRIDE的进程身份
+func asUser(t *testing.T, euid int, groups ...int) {
+	oldEuid, oldGroups, oldCap := geteuid, getgroups, hasDACOverride
+	geteuid = func() int { return euid }
+	getgroups = func() []int { return groups }
+	hasDACOverride = func() bool { return false }
+	t.Cleanup(func() { geteuid, getgroups, hasDACOverride = oldEuid, oldGroups, oldCap })
+}
+
+func TestValidateSocketMissing(t *testing.T) {
+	err := validateSocket(filepath.Join(t.TempDir(), "missing.sock"))
+	if !errors.Is(err, ErrSocketNotFound) {
+		t.Errorf("Expected ErrSocketNotFound, got %v", err)
+	}
+}
+
+func TestValidateSocketWrongType(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "regular")
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateSocket(path); !errors.Is(err, ErrNotSocket) {
+		t.Errorf("Expected ErrNotSocket, got %v", err)
+	}
+}
+
+func TestValidateSocketPermission(t *testing.T) {
+	path, _ := listenUnixgram(t, "dp.sock")
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := fi.Sys().(*syscall.Stat_t)
+	owner, group := int(st.Uid), int(st.Gid)
+	other := owner + 1000
+
+	cases := []struct {
+		mode   os.FileMode
+		euid   int
+		groups []int
+		err    error
+	}{
+		{0o500, other, nil, ErrSocketPermission},          // 其他用户无写权限
+		{0o502, other, nil, ErrSocketInsecure},            // 任意用户可写
+		{0o520, other, []int{group}, nil},                 // 同组有写权限
+		{0o550, other, []int{group}, ErrSocketPermission}, // 同组按组权限判断
+		{0o700, owner, nil, nil},                          // 属主有写权限
+		{0o500, 0, nil, nil},                              // root不受权限位限制
+		{0o777, 0, nil, ErrSocketInsecure},                // root也拒绝任意用户可写
+	}
+	for _, tc := range cases {
+		if err := os.Chmod(path, tc.mode); err != nil {
+			t.Fatal(err)
+		}
+		asUser(t, tc.euid, tc.groups...)
+		err := validateSocket(path)
+		if tc.err == nil && err != nil {
+			t.Errorf("mode %v euid %d: unexpected error: %v", tc.mode, tc.euid, err)
+		}
+		if tc.err != nil && !errors.Is(err, tc.err) {
+			t.Errorf("mode %v euid %d: expected %v, got %v", tc.mode, tc.euid, tc.err, err)
+		}
+	}
+}
+
+func TestConnectValidatesSocket(t *testing.T) {
+	dir := t.TempDir()
+	regular := filepath.Join(dir, "regular")
+	if err := os.WriteFile(regular, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	valid, _ := listenUnixgram(t, "dp.sock")
+
+	for _, tc := range []struct {
+		path string
+		err  error
+	}{
+		{filepath.Join(dir, "missing.sock"), ErrSocketNotFound},
+		{regular, ErrNotSocket},
+		{valid, nil},
+	} {
+		c := NewDPClient(tc.path)
+		c.localPath = filepath.Join(dir, "client.sock")
+		c.SetDialTimeout(time.Second)
+		err := c.Connect()
+		if tc.err == nil {
+			if err != nil || !c.IsConnected() {
+				t.Errorf("%s: connect failed: %v", tc.path, err)
+			}
+			c.Disconnect()
+			continue
+		}
+		if !errors.Is(err, tc.err) || c.IsConnected() {
+			t.Errorf("%s: expected %v, got %v", tc.path, tc.err, err)
+		}
+	}
+}
+
+func TestConnectLocalSocket(t *testing.T) {
+	path, _ := listenUnixgram(t, "dp.sock")
+
+	c := NewDPClient(path)
+	c.localPath = filepath.Join(t.TempDir(), "client.sock")
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+
+	fi, err := os.Stat(c.localPath)
+	if err != nil {
+		t.Fatalf("Local socket not created: %v", err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0o600 {
+		t.Errorf("Unexpected local socket permission: %v", perm)
+	}
+
+	c.Disconnect()
+	if _, err := os.Stat(c.localPath); !os.IsNotExist(err) {
+		t.Errorf("Local socket not removed: %v", err)
+	}
+}
+
+func TestOnConnect(t *testing.T) {
+	path, _ := listenUnixgram(t, "dp.sock")
+
+	c := NewDPClient(path)
+	c.localPath = filepath.Join(t.TempDir(), "client.sock")
+	calls := 0
+	// 回调在释放锁后调用，可直接向DP下发配置
+	c.SetOnConnect(func() {
+		calls++
+		if err := c.SendPolicy(nil); err != nil {
+			t.Errorf("SendPolicy in callback failed: %v", err)
+		}
+	})
+
+	for i := 0; i < 2; i++ {
+		if err := c.Connect(); err != nil {
+			t.Fatalf("Connect failed: %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Expected 1 callback for repeated Connect, got %d", calls)
+	}
+
+	c.Disconnect()
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Reconnect failed: %v", err)
+	}
+	defer c.Disconnect()
+	if calls != 2 {
+		t.Errorf("Expected callback on reconnect, got %d", calls)
+	}
+}
+
+func TestPoolMultipleSockets(t *testing.T) {
+	path1, dp1 := listenUnixgram(t, "dp1.sock")
+	path2, dp2 := listenUnixgram(t, "dp2.sock")
+
+	pool := NewDPPool([]string{path1, path2})
+	dir := t.TempDir()
+	for i, c := range pool.clients {
+		c.localPath = filepath.Join(dir, fmt.Sprintf("client%d.sock", i))
+	}
+
+	// 两个读取循环共用同一个接收端
+	var mutex sync.Mutex
+	received := make(map[uint16]bool)
+	pool.SetOnConnection(func(conn *DPConnection) {
+		mutex.Lock()
+		received[conn.ServerPort] = true
+		mutex.Unlock()
+	})
+	if err := pool.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	if n := pool.ConnectedCount(); n != 2 {
+		t.Fatalf("Unexpected connected count: %d", n)
+	}
+
+	send := func(dp *net.UnixConn, local string, port uint16) {
+		data, _ := json.Marshal(DPConnection{ServerPort: port})
+		msg, _ := json.Marshal(DPMessage{Type: "connection", Data: data})
+		if _, err := dp.WriteToUnix(msg, &net.UnixAddr{Name: local, Net: "unixgram"}); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+	send(dp1, pool.clients[0].localPath, 80)
+	send(dp2, pool.clients[1].localPath, 443)
+	send(dp2, pool.clients[1].localPath, 8080)
+
+	deadline := time.Now().Add(time.Second)
+	for pool.Stats().Connections < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mutex.Lock()
+	if len(received) != 3 || !received[80] || !received[443] || !received[8080] {
+		t.Errorf("Unexpected connections: %v", received)
+	}
+	mutex.Unlock()
+	if s := pool.clients[0].Stats(); s.Connections != 1 {
+		t.Errorf("Unexpected reader 0 stats: %+v", s)
+	}
+	if s := pool.Stats(); s.Connections != 3 || s.Messages != 3 {
+		t.Errorf("Unexpected pool stats: %+v", s)
+	}
+
+	// 断开后所有读取循环退出
+	pool.Disconnect()
+	if pool.IsConnected() {
+		t.Errorf("Pool still connected")
+	}
+	for _, c := range pool.clients {
+		select {
+		case <-c.readerDone:
+		default:
+			t.Errorf("Reader not stopped")
+		}
+	}
+}
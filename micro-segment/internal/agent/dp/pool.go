@@ -0,0 +1,175 @@
+// Package dp DP多连接池
+package dp
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DPPool DP连接池
+// 每个DP套接字对应一个客户端和读取循环，配置消息发送到所有已连接的DP
+type DPPool struct {
+	clients []*DPClient
+}
+
+// NewDPPool 创建DP连接池
+// 每个套接字使用独立的本地绑定地址
+func NewDPPool(socketPaths []string) *DPPool {
+	p := &DPPool{clients: make([]*DPClient, 0, len(socketPaths))}
+	for i, path := range socketPaths {
+		c := NewDPClient(path)
+		if len(socketPaths) > 1 {
+			c.localPath = fmt.Sprintf("/tmp/dp_client.%d.%d.sock", os.Getpid(), i)
+		}
+		p.clients = append(p.clients, c)
+	}
+	return p
+}
+
+// SetDialTimeout 设置各DP连接的超时时间，0表示不限
+func (p *DPPool) SetDialTimeout(d time.Duration) {
+	for _, c := range p.clients {
+		c.SetDialTimeout(d)
+	}
+}
+
+// Size 获取DP套接字数量
+func (p *DPPool) Size() int {
+	return len(p.clients)
+}
+
+// Connect 连接所有DP
+// 部分连接失败时记录日志，全部失败时返回第一个错误
+func (p *DPPool) Connect() error {
+	var firstErr error
+	connected := 0
+	for _, c := range p.clients {
+		if err := c.Connect(); err != nil {
+			log.WithError(err).WithField("socket", c.socketPath).Warn("Failed to connect to DP socket")
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		connected++
+	}
+
+	if connected == 0 && firstErr != nil {
+		return firstErr
+	}
+	return nil
+}
+
+// Disconnect 断开所有DP，等待所有读取循环退出
+func (p *DPPool) Disconnect() {
+	for _, c := range p.clients {
+		c.Disconnect()
+	}
+}
+
+// IsConnected 检查是否有DP已连接
+func (p *DPPool) IsConnected() bool {
+	for _, c := range p.clients {
+		if c.IsConnected() {
+			return true
+		}
+	}
+	return false
+}
+
+// ConnectedCount 获取已连接的DP数量
+func (p *DPPool) ConnectedCount() int {
+	count := 0
+	for _, c := range p.clients {
+		if c.IsConnected() {
+			count++
+		}
+	}
+	return count
+}
+
+// SetOnConnection 设置连接回调，所有读取循环共用
+// 需在Connect之前设置
+func (p *DPPool) SetOnConnection(cb func(*DPConnection)) {
+	for _, c := range p.clients {
+		c.SetOnConnection(cb)
+	}
+}
+
+// SetOnThreatLog 设置威胁日志回调，所有读取循环共用
+// 需在Connect之前设置
+func (p *DPPool) SetOnThreatLog(cb func(*DPThreatLog)) {
+	for _, c := range p.clients {
+		c.SetOnThreatLog(cb)
+	}
+}
+
+// SetOnConnect 设置建立连接回调，任一DP新建立连接后调用
+// 需在Connect之前设置
+func (p *DPPool) SetOnConnect(cb func()) {
+	for _, c := range p.clients {
+		c.SetOnConnect(cb)
+	}
+}
+
+// Stats 汇总所有读取循环的统计
+func (p *DPPool) Stats() ReaderStats {
+	var stats ReaderStats
+	for _, c := range p.clients {
+		stats.add(c.Stats())
+	}
+	return stats
+}
+
+// SendPolicy 发送策略到所有已连接的DP
+func (p *DPPool) SendPolicy(policies []*DPPolicy) error {
+	return p.broadcast(func(c *DPClient) error { return c.SendPolicy(policies) })
+}
+
+// AddMAC 向所有已连接的DP添加MAC地址
+func (p *DPPool) AddMAC(mac net.HardwareAddr, workloadID string) error {
+	return p.broadcast(func(c *DPClient) error { return c.AddMAC(mac, workloadID) })
+}
+
+// DelMAC 从所有已连接的DP删除MAC地址
+func (p *DPPool) DelMAC(mac net.HardwareAddr) error {
+	return p.broadcast(func(c *DPClient) error { return c.DelMAC(mac) })
+}
+
+// SetWorkloadMode 向所有已连接的DP设置工作负载策略模式
+func (p *DPPool) SetWorkloadMode(workloadID, mode string) error {
+	return p.broadcast(func(c *DPClient) error { return c.SetWorkloadMode(workloadID, mode) })
+}
+
+// ConfigSubnets 向所有已连接的DP配置内部子网
+func (p *DPPool) ConfigSubnets(subnets []net.IPNet) error {
+	return p.broadcast(func(c *DPClient) error { return c.ConfigSubnets(subnets) })
+}
+
+// broadcast 对所有已连接的DP执行操作，返回第一个错误
+func (p *DPPool) broadcast(fn func(c *DPClient) error) error {
+	var firstErr error
+	sent := 0
+	for _, c := range p.clients {
+		if !c.IsConnected() {
+			continue
+		}
+		if err := fn(c); err != nil {
+			log.WithError(err).WithField("socket", c.socketPath).Warn("Failed to send to DP")
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		sent++
+	}
+
+	if firstErr == nil && sent == 0 {
+		return fmt.Errorf("not connected to DP")
+	}
+	return firstErr
+}
@@ -0,0 +1,118 @@
+// Package dp DP套接字校验
+package dp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// DP套接字校验错误，可用errors.Is区分
+var (
+	ErrSocketNotFound   = errors.New("dp socket not found")
+	ErrNotSocket        = errors.New("dp socket path is not a socket")
+	ErrSocketPermission = errors.New("dp socket not writable")
+	ErrSocketInsecure   = errors.New("dp socket is world-writable")
+)
+
+// capDACOverride CAP_DAC_OVERRIDE能力位，可绕过文件权限检查
+const capDACOverride = 1
+
+// 测试时可替换
+var (
+	geteuid        = os.Geteuid
+	getgroups      = currentGroups
+	hasDACOverride = func() bool { return hasCapability(capDACOverride) }
+)
+
+// validateSocket 连接前校验DP套接字
+// 依次检查路径存在、类型为socket、其他用户不可写、当前进程可写
+// 任意用户可写的套接字可能被其他进程替换或滥用，拒绝连接
+func validateSocket(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w: %s", ErrSocketNotFound, path)
+		}
+		return fmt.Errorf("failed to stat dp socket %s: %v", path, err)
+	}
+
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%w: %s (mode %v)", ErrNotSocket, path, fi.Mode())
+	}
+
+	if fi.Mode().Perm()&0o002 != 0 {
+		return fmt.Errorf("%w: %s (mode %v)", ErrSocketInsecure, path, fi.Mode().Perm())
+	}
+
+	if !socketWritable(fi) {
+		return fmt.Errorf("%w: %s (mode %v, euid %d), run as root or grant CAP_DAC_OVERRIDE",
+			ErrSocketPermission, path, fi.Mode().Perm(), geteuid())
+	}
+	return nil
+}
+
+// socketWritable 按属主、属组和其他用户权限位判断当前进程能否写入
+// root或拥有CAP_DAC_OVERRIDE时总是可写
+func socketWritable(fi os.FileInfo) bool {
+	euid := geteuid()
+	if euid == 0 || hasDACOverride() {
+		return true
+	}
+
+	perm := fi.Mode().Perm()
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return perm&0o222 != 0
+	}
+
+	switch {
+	case int(st.Uid) == euid:
+		return perm&0o200 != 0
+	case inGroup(int(st.Gid)):
+		return perm&0o020 != 0
+	default:
+		return perm&0o002 != 0
+	}
+}
+
+// inGroup 检查当前进程是否属于指定组
+func inGroup(gid int) bool {
+	for _, g := range getgroups() {
+		if g == gid {
+			return true
+		}
+	}
+	return false
+}
+
+// currentGroups 返回当前进程的有效组和附加组
+func currentGroups() []int {
+	groups, _ := os.Getgroups()
+	return append(groups, os.Getegid())
+}
+
+// hasCapability 检查当前进程的有效能力集是否包含指定能力
+// 读取/proc/self/status，非Linux系统返回false
+func hasCapability(capBit uint) bool {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "CapEff:") {
+			continue
+		}
+		caps, err := strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, "CapEff:")), 16, 64)
+		return err == nil && caps&(1<<capBit) != 0
+	}
+	return false
+}
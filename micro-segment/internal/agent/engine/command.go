@@ -0,0 +1,81 @@
+// Package engine Controller远程命令处理
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/micro-segment/internal/agent"
+	"github.com/micro-segment/internal/agent/network"
+)
+
+// packetSampleDuration 远程命令触发的流量采样时长
+const packetSampleDuration = 10 * time.Second
+
+// captureController 流量捕获控制，由network.Manager实现
+type captureController interface {
+	ForceStartCapture(containerID string) error
+	ForceStopCapture(containerID string) error
+	GetDebugInfo() map[string]interface{}
+}
+
+// packetSampler 容器流量采样，由network.Manager实现
+type packetSampler interface {
+	StartPacketSample(containerID string, duration time.Duration, path string) (*network.PacketSample, error)
+}
+
+// HandleCommand 执行Controller随心跳下发的命令
+// 未启用流量捕获时命令均返回失败
+func (e *Engine) HandleCommand(cmd *agent.Command) *agent.CommandResult {
+	result := &agent.CommandResult{ID: cmd.ID}
+	output, err := e.runCommand(cmd)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Success = true
+	result.Output = output
+	return result
+}
+
+// runCommand 按命令类型调用网络管理器
+func (e *Engine) runCommand(cmd *agent.Command) (string, error) {
+	ctl, ok := e.config.NetworkManager.(captureController)
+	if !ok {
+		return "", fmt.Errorf("traffic capture not enabled")
+	}
+
+	switch cmd.Type {
+	case agent.CommandForceCapture:
+		return "", ctl.ForceStartCapture(cmd.ContainerID)
+	case agent.CommandForceStop:
+		return "", ctl.ForceStopCapture(cmd.ContainerID)
+	case agent.CommandGetDebug:
+		data, err := json.Marshal(ctl.GetDebugInfo())
+		if err != nil {
+			return "", fmt.Errorf("marshal debug info failed: %v", err)
+		}
+		return string(data), nil
+	case agent.CommandPacketSample:
+		return e.startPacketSample(cmd.ContainerID)
+	default:
+		return "", fmt.Errorf("unknown command: %s", cmd.Type)
+	}
+}
+
+// startPacketSample 在后台采样容器流量，返回pcap文件在Agent主机上的路径
+func (e *Engine) startPacketSample(containerID string) (string, error) {
+	sampler, ok := e.config.NetworkManager.(packetSampler)
+	if !ok {
+		return "", fmt.Errorf("packet sample not supported")
+	}
+	name := fmt.Sprintf("microseg-%.12s-%d.pcap", containerID, time.Now().UnixNano())
+	sample, err := sampler.StartPacketSample(containerID, packetSampleDuration, filepath.Join(os.TempDir(), name))
+	if err != nil {
+		return "", err
+	}
+	return sample.Path, nil
+}
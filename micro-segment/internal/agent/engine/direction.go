@@ -0,0 +1,90 @@
+// Package engine 连接方向推断
+package engine
+
+import (
+	"net"
+
+	"github.com/micro-segment/internal/agent"
+	"github.com/micro-segment/internal/agent/dp"
+)
+
+// 端口范围划分
+const (
+	wellKnownPortMax = 1023  // 知名端口上限，通常为服务端
+	ephemeralPortMin = 32768 // 临时端口下限，Linux默认ip_local_port_range起点
+)
+
+// TCP标志位
+const (
+	tcpFlagSYN = 0x02
+	tcpFlagACK = 0x10
+)
+
+// ipProtoTCP TCP协议号
+const ipProtoTCP = 6
+
+// inferServerSwap 判断DP上报的客户端/服务端是否需要互换
+// 优先依据TCP握手标志位：SYN由客户端发出，SYN+ACK由服务端发出；
+// 否则依据端口：知名端口或非临时端口一侧视为服务端
+func inferServerSwap(conn *dp.DPConnection) bool {
+	if conn.IPProto == ipProtoTCP && conn.TcpFlags&tcpFlagSYN != 0 {
+		return conn.TcpFlags&tcpFlagACK != 0
+	}
+
+	cp, sp := conn.ClientPort, conn.ServerPort
+	switch {
+	case cp <= wellKnownPortMax && sp > wellKnownPortMax:
+		return true
+	case sp >= ephemeralPortMin && cp < ephemeralPortMin:
+		return true
+	}
+	return false
+}
+
+// inferDirection 在DP未给出方向时推断连接方向
+// 先确定服务端，再以服务端是否为本机工作负载判定入站
+func (e *Engine) inferDirection(conn *agent.Connection, dpConn *dp.DPConnection) {
+	if dpConn.Ingress != nil {
+		conn.Ingress = *dpConn.Ingress
+		return
+	}
+
+	if inferServerSwap(dpConn) {
+		conn.ClientIP, conn.ServerIP = conn.ServerIP, conn.ClientIP
+		conn.ClientPort, conn.ServerPort = conn.ServerPort, conn.ClientPort
+	}
+	conn.Ingress = e.isWorkloadIP(conn.ServerIP)
+}
+
+// isWorkloadIP 检查IP地址是否属于本机管理的工作负载
+func (e *Engine) isWorkloadIP(ip net.IP) bool {
+	return e.workloadByIP(ip) != ""
+}
+
+// workloadByIP 查找拥有该IP地址的本机工作负载，未找到返回空字符串
+func (e *Engine) workloadByIP(ip net.IP) string {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	for _, wl := range e.workloads {
+		for _, addrs := range wl.Ifaces {
+			for _, addr := range addrs {
+				if addr.IP.Equal(ip) {
+					return wl.ID
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// isInternetIngress 判断连接是否为来自公网的入站访问
+// 客户端为非私有的公网单播地址且不在内部子网中，服务端在内部子网中
+func (e *Engine) isInternetIngress(conn *agent.Connection) bool {
+	return isPublicIP(conn.ClientIP) && !e.IsInternalIP(conn.ClientIP) && e.IsInternalIP(conn.ServerIP)
+}
+
+// isPublicIP 检查IP地址是否为公网单播地址，排除RFC1918及IPv6 ULA私有地址
+func isPublicIP(ip net.IP) bool {
+	return ip != nil && ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
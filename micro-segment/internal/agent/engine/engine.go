@@ -13,7 +13,9 @@ Agent引擎是微隔离系统的核心组件，负责：
 package engine
 
 import (
+	"errors"
 	"net"
+	"sort"
 	"sync"
 	"time"
 
@@ -23,9 +25,13 @@ import (
 	"github.com/micro-segment/internal/agent/connection"
 	"github.com/micro-segment/internal/agent/dp"
 	agentgrpc "github.com/micro-segment/internal/agent/grpc"
+	"github.com/micro-segment/internal/agent/network"
 	"github.com/micro-segment/internal/agent/policy"
 )
 
+// ErrEngineStopped 引擎已停止，不能再次启动
+var ErrEngineStopped = errors.New("engine already stopped")
+
 // Engine Agent引擎，协调各组件协同工作
 type Engine struct {
 	mutex sync.RWMutex
@@ -35,7 +41,7 @@ type Engine struct {
 
 	// 核心组件
 	aggregator *connection.Aggregator // 连接聚合器
-	dpClient   *dp.DPClient           // DP客户端
+	dpClient   *dp.DPPool             // DP连接池，每个套接字一个读取循环
 	grpcClient *agentgrpc.Client      // gRPC客户端
 	policy     *policy.NetworkPolicy  // 网络策略管理器
 
@@ -46,12 +52,29 @@ type Engine struct {
 	hostIPs    map[string]bool               // 主机IP集合
 	subnets    map[string]*agent.Subnet      // 内部子网映射表
 
+	// 主机网络接口，测试时可替换
+	hostInterfaces func() (map[string][]agent.IPAddr, error)
+	hostReported   bool // 当前主机信息是否已上报
+
+	// 连接跟踪表来源及缓存，用于SNAT连接归属，测试时可替换
+	conntrack func() ([]conntrackEntry, error)
+	natTable  natTable
+
+	// 主机进程工作负载，procRoot测试时可替换
+	procRoot      string
+	hostProcesses map[string]bool        // 当前的主机进程工作负载ID
+	hostPorts     map[hostPortKey]string // 主机监听端口 -> 工作负载ID
+
 	// 默认策略模式
 	defaultPolicyMode agent.PolicyMode
-
-	// 运行状态
-	running bool
-	stopCh  chan struct{}
+	// Controller按组模式下发的工作负载生效模式，未下发的工作负载使用默认模式
+	workloadModes map[string]agent.PolicyMode
+
+	// 运行状态，由stateMutex保护，Start和Stop各只生效一次
+	stateMutex sync.Mutex
+	running    bool
+	stopped    bool
+	stopCh     chan struct{}
 }
 
 // Config 引擎配置参数
@@ -59,9 +82,23 @@ type Config struct {
 	AgentID        string      // Agent唯一标识
 	HostID         string      // 主机唯一标识
 	HostName       string      // 主机名称
-	DPSocketPath   string      // DP进程Unix套接字路径
+	DPSocketPaths  []string    // DP进程Unix套接字路径，每个对应一个读取循环
 	GRPCAddr       string      // Controller gRPC地址
 	NetworkManager interface{} // 网络管理器接口
+
+	StaticSubnets []net.IPNet                 // 静态配置的内部子网，补充自动发现结果
+	DockerSubnets func() ([]net.IPNet, error) // Docker网络子网来源，可为nil
+
+	FlushFraction float64 // 连接映射表达到容量的此比例时提前上报，0使用默认值
+
+	DPDialTimeout time.Duration // 连接每个DP套接字的超时时间，0使用默认值
+
+	HeartbeatInterval time.Duration // 心跳间隔，0使用默认值，注册时按Controller心跳超时缩短
+	HeartbeatJitter   float64       // 心跳间隔的随机抖动比例，0不抖动
+
+	ReportFieldMask agentgrpc.ConnectionFieldMask // 上报连接时省略的可选字段，0全部上报
+
+	HostProcesses bool // 将主机上监听非回环地址的进程作为工作负载上报
 }
 
 // NewEngine 创建新的Agent引擎实例
@@ -71,37 +108,69 @@ func NewEngine(config *Config) *Engine {
 		workloads:         make(map[string]*agent.Workload),
 		hostIPs:           make(map[string]bool),
 		subnets:           make(map[string]*agent.Subnet),
+		hostInterfaces:    listHostInterfaces,
+		conntrack:         readConntrack,
+		procRoot:          "/proc",
 		defaultPolicyMode: agent.PolicyModeMonitor, // 默认Monitor模式
+		workloadModes:     make(map[string]agent.PolicyMode),
 		stopCh:            make(chan struct{}),
 	}
 
 	// 初始化核心组件
 	e.aggregator = connection.NewAggregator(config.AgentID, config.HostID)
-	e.dpClient = dp.NewDPClient(config.DPSocketPath)
+	if config.FlushFraction > 0 {
+		e.aggregator.SetFlushFraction(config.FlushFraction)
+	}
+	e.dpClient = dp.NewDPPool(config.DPSocketPaths)
+	if config.DPDialTimeout > 0 {
+		e.dpClient.SetDialTimeout(config.DPDialTimeout)
+	}
 	e.grpcClient = agentgrpc.NewClient(config.GRPCAddr, config.AgentID, config.HostID, config.HostName, "0.1.0")
 	e.policy = policy.NewNetworkPolicy(e.dpClient)
 
 	// 设置回调函数
 	e.aggregator.SetOnConnections(e.onConnections)
 	e.aggregator.SetOnThreatLogs(e.onThreatLogs)
+	e.aggregator.SetLoggedPolicy(e.policy.IsLogged)
+	e.grpcClient.SetOnPolicies(e.UpdatePolicies)
+	e.grpcClient.SetOnWorkloadModes(e.UpdateWorkloadModes)
+	e.grpcClient.SetWorkloadSource(e.ListWorkloads)
+	e.grpcClient.SetOnCommand(e.HandleCommand)
+	e.grpcClient.SetCaptureSource(e.CapturedContainers)
+	if config.HeartbeatInterval > 0 {
+		e.grpcClient.SetHeartbeatInterval(config.HeartbeatInterval)
+	}
+	e.grpcClient.SetHeartbeatJitter(config.HeartbeatJitter)
+	e.grpcClient.SetConnectionFieldMask(config.ReportFieldMask)
 
 	return e
 }
 
 // Start 启动Agent引擎，建立各组件连接
+// 重复调用不再启动组件，Stop之后调用返回ErrEngineStopped
 func (e *Engine) Start() error {
+	e.stateMutex.Lock()
+	defer e.stateMutex.Unlock()
+
+	if e.stopped {
+		return ErrEngineStopped
+	}
+	if e.running {
+		return nil
+	}
 	log.Info("Starting agent engine")
 
+	// 设置DP回调函数，所有读取循环共用同一个聚合器
+	e.dpClient.SetOnConnection(e.onDPConnection)
+	e.dpClient.SetOnThreatLog(e.onDPThreatLog)
+	e.dpClient.SetOnConnect(e.policy.Resync)
+
 	// 连接DP进程
 	if err := e.dpClient.Connect(); err != nil {
 		log.WithError(err).Warn("Failed to connect to DP")
 		// 不阻止启动，DP可能稍后启动
 	}
 
-	// 设置DP回调函数
-	e.dpClient.SetOnConnection(e.onDPConnection)
-	e.dpClient.SetOnThreatLog(e.onDPThreatLog)
-
 	// 连接Controller
 	if err := e.grpcClient.Connect(); err != nil {
 		log.WithError(err).Warn("Failed to connect to Controller")
@@ -116,20 +185,39 @@ func (e *Engine) Start() error {
 	// 启动聚合器
 	e.aggregator.Start()
 
+	// 收集主机信息和内部子网并定期刷新
+	e.refreshHost()
+	e.refreshSubnets()
+	if e.config.HostProcesses {
+		e.refreshHostProcesses()
+	}
+	go e.hostNetworkLoop()
+
 	e.running = true
 	log.Info("Agent engine started")
 	return nil
 }
 
 // Stop 停止Agent引擎，清理所有资源
+// 重复调用无效果，未启动时只标记为已停止，不停止组件
 func (e *Engine) Stop() {
-	log.Info("Stopping agent engine")
+	e.stateMutex.Lock()
+	defer e.stateMutex.Unlock()
 
-	e.running = false
+	if e.stopped {
+		return
+	}
+	e.stopped = true
 	close(e.stopCh)
+	if !e.running {
+		return
+	}
+	e.running = false
+	log.Info("Stopping agent engine")
 
-	e.aggregator.Stop()
+	// 先断开DP停止产生新数据，聚合器最终刷新上报后再断开Controller
 	e.dpClient.Disconnect()
+	e.aggregator.Stop()
 	e.grpcClient.Disconnect()
 
 	log.Info("Agent engine stopped")
@@ -177,9 +265,20 @@ func (e *Engine) onDPConnection(conn *dp.DPConnection) {
 		Severity:     conn.Severity,
 		PolicyAction: conn.PolicyAction,
 		PolicyId:     conn.PolicyId,
-		Ingress:      conn.Ingress,
 		ExternalPeer: conn.ExternalPeer,
 	}
+	e.inferDirection(agentConn, conn)
+	e.attributeSNAT(agentConn)
+	e.attributeHostProcess(agentConn)
+	agentConn.InternetIngress = e.isInternetIngress(agentConn)
+	if conn.HTTPMethod != "" || conn.HTTPHost != "" || conn.DNSQuery != "" || conn.TLSSNI != "" {
+		agentConn.L7 = []agent.L7Meta{{
+			HTTPMethod: conn.HTTPMethod,
+			HTTPHost:   conn.HTTPHost,
+			DNSQuery:   conn.DNSQuery,
+			TLSSNI:     conn.TLSSNI,
+		}}
+	}
 
 	// 添加到聚合器进行批量处理
 	e.aggregator.AddConnection(&agent.ConnectionData{
@@ -188,11 +287,24 @@ func (e *Engine) onDPConnection(conn *dp.DPConnection) {
 	})
 }
 
+// maxPktSummaryLen 威胁日志数据包摘要的最大长度
+const maxPktSummaryLen = 256
+
 // onDPThreatLog DP威胁日志回调，将DP的威胁信息转换并添加到聚合器
 func (e *Engine) onDPThreatLog(threat *dp.DPThreatLog) {
-	// 转换为agent.ThreatLog格式
-	agentThreat := &agent.ThreatLog{
+	// 添加到聚合器进行批量处理
+	e.aggregator.AddThreatLog(threat.EPMAC, threatFromDP(threat))
+}
+
+// threatFromDP 转换为agent.ThreatLog格式
+func threatFromDP(threat *dp.DPThreatLog) *agent.ThreatLog {
+	summary := threat.PktSummary
+	if len(summary) > maxPktSummaryLen {
+		summary = summary[:maxPktSummaryLen]
+	}
+	return &agent.ThreatLog{
 		ThreatID:   threat.ThreatID,
+		ThreatName: threat.ThreatName,
 		Severity:   severityToString(threat.Severity),
 		ClientIP:   threat.ClientIP,
 		ServerIP:   threat.ServerIP,
@@ -200,10 +312,10 @@ func (e *Engine) onDPThreatLog(threat *dp.DPThreatLog) {
 		IPProto:    threat.IPProto,
 		PktIngress: threat.PktIngress,
 		ReportedAt: time.Now(),
+		TcpFlags:   threat.TcpFlags,
+		PktLen:     threat.PktLen,
+		PktSummary: summary,
 	}
-
-	// 添加到聚合器进行批量处理
-	e.aggregator.AddThreatLog(threat.EPMAC, agentThreat)
 }
 
 // severityToString 将数字严重级别转换为字符串
@@ -256,6 +368,73 @@ func (e *Engine) RemoveWorkload(id string) {
 	}
 }
 
+// HandleContainerEvent 处理容器生命周期事件
+// 将容器转换为工作负载并上报Controller，K8s容器以namespace作为域
+func (e *Engine) HandleContainerEvent(ev *network.ContainerEvent) {
+	var eventType string
+	switch ev.Type {
+	case "start":
+		eventType = "add"
+	case "update":
+		eventType = "update"
+	case "stop", "die":
+		eventType = "delete"
+	default:
+		return
+	}
+
+	wl := e.workloadFromContainer(ev)
+	if eventType == "delete" {
+		e.RemoveWorkload(wl.ID)
+	} else {
+		e.AddWorkload(wl)
+	}
+
+	if e.grpcClient.IsConnected() {
+		if err := e.grpcClient.ReportWorkload(eventType, wl); err != nil {
+			log.WithError(err).WithField("workload", wl.Name).Warn("Failed to report workload")
+		}
+	}
+}
+
+// workloadFromContainer 由容器事件构造工作负载
+func (e *Engine) workloadFromContainer(ev *network.ContainerEvent) *agent.Workload {
+	wl := &agent.Workload{
+		ID:         ev.ContainerID,
+		Name:       ev.Name,
+		Service:    ev.Service,
+		HostID:     e.config.HostID,
+		HostName:   e.config.HostName,
+		Image:      ev.Image,
+		Labels:     ev.Labels,
+		PolicyMode: e.GetDefaultPolicyMode(),
+		Running:    ev.Type == "start" || ev.Type == "update",
+		Pid:        ev.Pid,
+		State:      ev.State,
+		Health:     ev.Health,
+		Ifaces:     make(map[string][]agent.IPAddr),
+	}
+	for name, cfg := range ev.Addrs {
+		ip, ipNet, err := net.ParseCIDR(cfg.IPAddr)
+		if err != nil {
+			continue
+		}
+		wl.Ifaces[name] = []agent.IPAddr{{
+			IP:      ip,
+			IPNet:   *ipNet,
+			Scope:   "global",
+			Gateway: cfg.Gateway,
+		}}
+	}
+	if ev.Pod != nil {
+		wl.Domain = ev.Pod.Namespace
+		wl.PodName = ev.Pod.PodName
+		wl.OwnerKind = ev.Pod.OwnerKind
+		wl.OwnerName = ev.Pod.OwnerName
+	}
+	return wl
+}
+
 // GetWorkload 根据ID获取工作负载
 func (e *Engine) GetWorkload(id string) *agent.Workload {
 	e.mutex.RLock()
@@ -275,6 +454,23 @@ func (e *Engine) ListWorkloads() []*agent.Workload {
 	return result
 }
 
+// captureLister 列出正在捕获的容器，由network.Manager实现
+type captureLister interface {
+	GetCapturedContainers() []string
+}
+
+// CapturedContainers 列出正在捕获流量的容器ID，按ID排序
+// 未启用流量捕获时返回nil
+func (e *Engine) CapturedContainers() []string {
+	lister, ok := e.config.NetworkManager.(captureLister)
+	if !ok {
+		return nil
+	}
+	ids := lister.GetCapturedContainers()
+	sort.Strings(ids)
+	return ids
+}
+
 // UpdatePolicies 更新网络策略规则
 func (e *Engine) UpdatePolicies(rules []*agent.PolicyRule) {
 	e.policy.UpdateRules(rules)
@@ -295,6 +491,43 @@ func (e *Engine) GetDefaultPolicyMode() agent.PolicyMode {
 	return e.defaultPolicyMode
 }
 
+// UpdateWorkloadModes 更新Controller下发的工作负载生效模式，变化的模式同步到DP
+// 不在下发列表中的工作负载恢复默认模式
+func (e *Engine) UpdateWorkloadModes(modes map[string]agent.PolicyMode) {
+	e.mutex.Lock()
+	changed := make(map[string]agent.PolicyMode)
+	for id, mode := range modes {
+		if e.workloadModes[id] != mode {
+			changed[id] = mode
+		}
+	}
+	for id := range e.workloadModes {
+		if _, ok := modes[id]; !ok {
+			changed[id] = e.defaultPolicyMode
+		}
+	}
+	e.workloadModes = modes
+	e.mutex.Unlock()
+
+	for id, mode := range changed {
+		log.WithFields(log.Fields{"workload": id, "mode": mode}).Info("Workload policy mode changed")
+		if err := e.dpClient.SetWorkloadMode(id, string(mode)); err != nil {
+			log.WithError(err).WithField("workload", id).Debug("Failed to set workload mode in DP")
+		}
+	}
+}
+
+// GetWorkloadPolicyMode 获取工作负载生效的策略模式
+func (e *Engine) GetWorkloadPolicyMode(id string) agent.PolicyMode {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	if mode, ok := e.workloadModes[id]; ok {
+		return mode
+	}
+	return e.defaultPolicyMode
+}
+
 // IsLocalIP 检查IP地址是否为本地主机IP
 func (e *Engine) IsLocalIP(ip net.IP) bool {
 	e.mutex.RLock()
@@ -343,7 +576,13 @@ func (e *Engine) GetStats() map[string]interface{} {
 		"policies":         e.policy.GetRuleCount(),
 		"connections":      e.aggregator.GetConnectionCount(),
 		"max_connections":  e.aggregator.GetMaxConnections(),
+		"dropped_conns":    e.aggregator.GetDroppedCount(),
+		"summarized_conns": e.aggregator.GetSummarizedCount(),
+		"reported_conns":   e.aggregator.GetReportedCount(),
 		"dp_connected":     e.dpClient.IsConnected(),
+		"dp_sockets":       e.dpClient.Size(),
+		"dp_connected_num": e.dpClient.ConnectedCount(),
+		"dp_readers":       e.dpClient.Stats(),
 		"default_mode":     e.defaultPolicyMode,
 	}
 }
@@ -0,0 +1,374 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+
+	"github.com/micro-segment/internal/agent"
+	"github.com/micro-segment/internal/agent/dp"
+	"github.com/micro-segment/internal/agent/network"
+)
+
+func TestWorkloadFromContainer(t *testing.T) {
+	e := NewEngine(&Config{AgentID: "agent", HostID: "host", HostName: "node-1"})
+
+	wl := e.workloadFromContainer(&network.ContainerEvent{
+		Type:        "update",
+		ContainerID: "c1",
+		Name:        "web",
+		Service:     "frontend",
+		Pod:         &network.K8sPodInfo{PodName: "web-0", Namespace: "shop", OwnerKind: "StatefulSet", OwnerName: "web"},
+		Addrs: map[string]*network.IPConfig{
+			"eth0": {IPAddr: "172.17.0.9/16", Gateway: "172.17.0.1"},
+			"eth1": {IPAddr: "garbage"},
+		},
+	})
+
+	if wl.Domain != "shop" || wl.PodName != "web-0" || wl.OwnerKind != "StatefulSet" {
+		t.Errorf("Unexpected pod metadata: %+v", wl)
+	}
+	if !wl.Running || wl.HostID != "host" || wl.Service != "frontend" {
+		t.Errorf("Unexpected workload: %+v", wl)
+	}
+	addrs := wl.Ifaces["eth0"]
+	if len(addrs) != 1 || addrs[0].IP.String() != "172.17.0.9" || addrs[0].IPNet.String() != "172.17.0.0/16" {
+		t.Errorf("Unexpected eth0 addrs: %+v", addrs)
+	}
+	if _, ok := wl.Ifaces["eth1"]; ok {
+		t.Errorf("Invalid address converted")
+	}
+}
+
+func TestThreatFromDP(t *testing.T) {
+	long := strings.Repeat("x", maxPktSummaryLen+10)
+	threat := threatFromDP(&dp.DPThreatLog{
+		ThreatID:   1001,
+		ThreatName: "TCP SYN flood",
+		Severity:   3,
+		ClientIP:   net.ParseIP("10.0.0.1"),
+		ServerIP:   net.ParseIP("10.0.0.2"),
+		ServerPort: 80,
+		IPProto:    6,
+		TcpFlags:   0x02,
+		PktLen:     60,
+		PktSummary: long,
+	})
+
+	if threat.ThreatName != "TCP SYN flood" || threat.Severity != "High" {
+		t.Errorf("Unexpected threat: %+v", threat)
+	}
+	if threat.TcpFlags != 0x02 || threat.PktLen != 60 {
+		t.Errorf("Packet context lost: flags=%x len=%d", threat.TcpFlags, threat.PktLen)
+	}
+	if len(threat.PktSummary) != maxPktSummaryLen {
+		t.Errorf("Packet summary not truncated: %d", len(threat.PktSummary))
+	}
+}
+
+func mustCIDR(t *testing.T, s string) *net.IPNet {
+	ip, ipnet, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 保留主机地址，模拟网卡地址
+	return &net.IPNet{IP: ip, Mask: ipnet.Mask}
+}
+
+// mockInterfaces 模拟主机网络接口
+func mockInterfaces(t *testing.T, ifaces map[string][]string) func() (map[string][]agent.IPAddr, error) {
+	return func() (map[string][]agent.IPAddr, error) {
+		result := make(map[string][]agent.IPAddr)
+		for name, cidrs := range ifaces {
+			for _, cidr := range cidrs {
+				ipnet := mustCIDR(t, cidr)
+				result[name] = append(result[name], agent.IPAddr{
+					IP:    ipnet.IP,
+					IPNet: net.IPNet{IP: ipnet.IP.Mask(ipnet.Mask), Mask: ipnet.Mask},
+					Scope: addrScope(ipnet.IP),
+				})
+			}
+		}
+		return result, nil
+	}
+}
+
+func TestDiscoverSubnets(t *testing.T) {
+	static, err := ParseSubnets("10.100.0.0/16, 192.168.5.0/24")
+	if err != nil {
+		t.Fatalf("ParseSubnets failed: %v", err)
+	}
+	if _, err := ParseSubnets("10.0.0.0/33"); err == nil {
+		t.Errorf("Expected error for invalid subnet")
+	}
+
+	e := NewEngine(&Config{
+		AgentID:       "agent",
+		StaticSubnets: static,
+		DockerSubnets: func() ([]net.IPNet, error) {
+			return []net.IPNet{*mustCIDR(t, "172.18.0.0/16"), *mustCIDR(t, "192.168.5.0/24")}, nil
+		},
+	})
+	e.hostInterfaces = mockInterfaces(t, map[string][]string{
+		"lo":      {"127.0.0.1/8"},
+		"eth0":    {"10.0.2.15/24", "fe80::1/64"},
+		"docker0": {"172.17.0.1/16"},
+	})
+
+	subnets := e.discoverSubnets()
+	want := map[string]string{
+		"10.100.0.0/16":  subnetScopeStatic,
+		"192.168.5.0/24": subnetScopeStatic,
+		"10.0.2.0/24":    subnetScopeHost,
+		"172.17.0.0/16":  subnetScopeHost,
+		"172.18.0.0/16":  subnetScopeDocker,
+	}
+	if len(subnets) != len(want) {
+		t.Fatalf("Unexpected subnets: %v", subnets)
+	}
+	for key, scope := range want {
+		if s, ok := subnets[key]; !ok || s.Scope != scope {
+			t.Errorf("Subnet %s: got %+v, want scope %s", key, s, scope)
+		}
+	}
+
+	e.refreshSubnets()
+	cases := map[string]bool{
+		"127.0.0.1":   true,
+		"10.0.2.99":   true,
+		"172.17.5.5":  true,
+		"172.18.0.2":  true,
+		"10.100.9.9":  true,
+		"192.168.5.7": true,
+		"8.8.8.8":     false,
+		"10.0.3.1":    false,
+	}
+	for ip, internal := range cases {
+		if got := e.IsInternalIP(net.ParseIP(ip)); got != internal {
+			t.Errorf("IsInternalIP(%s) = %v", ip, got)
+		}
+	}
+}
+
+// startableEngine 创建连接本地空gRPC服务的引擎，并统计读取主机接口的次数
+func startableEngine(t *testing.T) (*Engine, *int) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	srv := grpc.NewServer()
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	e := NewEngine(&Config{AgentID: "agent", HostID: "host", GRPCAddr: lis.Addr().String()})
+	reads := 0
+	e.hostInterfaces = func() (map[string][]agent.IPAddr, error) {
+		reads++
+		return nil, nil
+	}
+	return e, &reads
+}
+
+func TestStartStopIdempotent(t *testing.T) {
+	e, reads := startableEngine(t)
+	if err := e.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	started := *reads
+	if err := e.Start(); err != nil {
+		t.Fatalf("Second Start: %v", err)
+	}
+	if *reads != started {
+		t.Errorf("Components started twice")
+	}
+
+	e.Stop()
+	e.Stop()
+	select {
+	case <-e.stopCh:
+	default:
+		t.Errorf("Stop channel not closed")
+	}
+	if err := e.Start(); !errors.Is(err, ErrEngineStopped) {
+		t.Errorf("Start after Stop: %v", err)
+	}
+	if *reads != started {
+		t.Errorf("Restarted after Stop")
+	}
+}
+
+func TestStopWithoutStart(t *testing.T) {
+	e, reads := startableEngine(t)
+	e.Stop()
+	e.Stop()
+	if err := e.Start(); !errors.Is(err, ErrEngineStopped) {
+		t.Errorf("Start after Stop: %v", err)
+	}
+	if *reads != 0 || e.grpcClient.IsConnected() {
+		t.Errorf("Components started after Stop")
+	}
+}
+
+func TestRefreshHost(t *testing.T) {
+	e := NewEngine(&Config{AgentID: "agent", HostID: "host", HostName: "node-1"})
+	e.hostInterfaces = mockInterfaces(t, map[string][]string{
+		"lo":   {"127.0.0.1/8"},
+		"eth0": {"10.0.2.15/24"},
+	})
+
+	// 未连接Controller时只更新本地信息
+	e.refreshHost()
+	if e.host == nil || e.host.ID != "host" || e.host.Name != "node-1" || len(e.host.Ifaces["eth0"]) != 1 {
+		t.Fatalf("Unexpected host: %+v", e.host)
+	}
+	if e.hostReported {
+		t.Errorf("Host reported without controller")
+	}
+	for ip, local := range map[string]bool{"10.0.2.15": true, "127.0.0.1": true, "10.0.2.16": false} {
+		if got := e.IsLocalIP(net.ParseIP(ip)); got != local {
+			t.Errorf("IsLocalIP(%s) = %v", ip, got)
+		}
+	}
+}
+
+func TestInferDirection(t *testing.T) {
+	e := NewEngine(&Config{AgentID: "agent", HostID: "host"})
+	e.AddWorkload(&agent.Workload{
+		ID: "web",
+		Ifaces: map[string][]agent.IPAddr{
+			"eth0": {{IP: net.ParseIP("172.17.0.2")}},
+		},
+	})
+
+	local, remote := net.ParseIP("172.17.0.2"), net.ParseIP("10.0.0.9")
+	ingress := true
+	cases := []struct {
+		name       string
+		conn       dp.DPConnection
+		client     net.IP
+		serverPort uint16
+		ingress    bool
+	}{
+		{"explicit direction kept", dp.DPConnection{ClientIP: local, ServerIP: remote, ClientPort: 80, ServerPort: 45000, IPProto: 6, Ingress: &ingress}, local, 45000, true},
+		{"client to well-known port", dp.DPConnection{ClientIP: remote, ServerIP: local, ClientPort: 51234, ServerPort: 443, IPProto: 6}, remote, 443, true},
+		{"reply from well-known port", dp.DPConnection{ClientIP: local, ServerIP: remote, ClientPort: 80, ServerPort: 51234, IPProto: 6}, remote, 80, true},
+		{"egress to dns", dp.DPConnection{ClientIP: remote, ServerIP: local, ClientPort: 53, ServerPort: 40000, IPProto: 17}, local, 53, false},
+		{"registered port vs ephemeral", dp.DPConnection{ClientIP: remote, ServerIP: local, ClientPort: 8080, ServerPort: 60000, IPProto: 6}, local, 8080, false},
+		{"both ephemeral unchanged", dp.DPConnection{ClientIP: local, ServerIP: remote, ClientPort: 40000, ServerPort: 50000, IPProto: 17}, local, 50000, false},
+		{"syn from client", dp.DPConnection{ClientIP: local, ServerIP: remote, ClientPort: 80, ServerPort: 45000, IPProto: 6, TcpFlags: tcpFlagSYN}, local, 45000, false},
+		{"syn-ack from server", dp.DPConnection{ClientIP: local, ServerIP: remote, ClientPort: 45000, ServerPort: 80, IPProto: 6, TcpFlags: tcpFlagSYN | tcpFlagACK}, remote, 45000, true},
+	}
+	for _, c := range cases {
+		conn := &agent.Connection{ClientIP: c.conn.ClientIP, ServerIP: c.conn.ServerIP, ClientPort: c.conn.ClientPort, ServerPort: c.conn.ServerPort}
+		e.inferDirection(conn, &c.conn)
+		if !conn.ClientIP.Equal(c.client) || conn.ServerPort != c.serverPort || conn.Ingress != c.ingress {
+			t.Errorf("%s: got client=%v server_port=%d ingress=%v", c.name, conn.ClientIP, conn.ServerPort, conn.Ingress)
+		}
+	}
+}
+
+func TestInternetIngress(t *testing.T) {
+	e := NewEngine(&Config{AgentID: "agent", HostID: "host"})
+	e.subnets = map[string]*agent.Subnet{}
+	for _, cidr := range []string{"172.17.0.0/16", "10.0.0.0/8"} {
+		_, ipnet, _ := net.ParseCIDR(cidr)
+		e.subnets[cidr] = &agent.Subnet{Subnet: *ipnet}
+	}
+
+	cases := []struct {
+		name           string
+		client, server string
+		want           bool
+	}{
+		{"internal to internal", "10.0.0.9", "172.17.0.2", false},
+		{"public to internal", "8.8.8.8", "172.17.0.2", true},
+		{"public ipv6 to internal", "2001:4860::8888", "172.17.0.2", true},
+		{"private outside subnets", "192.168.1.5", "172.17.0.2", false},
+		{"internal to public", "172.17.0.2", "8.8.8.8", false},
+		{"public to public", "8.8.8.8", "1.1.1.1", false},
+	}
+	for _, c := range cases {
+		conn := &agent.Connection{ClientIP: net.ParseIP(c.client), ServerIP: net.ParseIP(c.server)}
+		if got := e.isInternetIngress(conn); got != c.want {
+			t.Errorf("%s: got %v", c.name, got)
+		}
+	}
+}
+
+// fakeCapture 记录调用的捕获控制
+type fakeCapture struct {
+	started []string
+}
+
+func (f *fakeCapture) ForceStartCapture(containerID string) error {
+	if containerID == "bad" {
+		return fmt.Errorf("container %s has no valid PID", containerID)
+	}
+	f.started = append(f.started, containerID)
+	return nil
+}
+
+func (f *fakeCapture) ForceStopCapture(containerID string) error { return nil }
+
+func (f *fakeCapture) GetDebugInfo() map[string]interface{} {
+	return map[string]interface{}{"running": true}
+}
+
+func (f *fakeCapture) GetCapturedContainers() []string {
+	return append([]string(nil), f.started...)
+}
+
+func TestCapturedContainers(t *testing.T) {
+	e := NewEngine(&Config{AgentID: "agent", HostID: "host"})
+	if ids := e.CapturedContainers(); ids != nil {
+		t.Errorf("Unexpected captures without capture: %v", ids)
+	}
+
+	e.config.NetworkManager = &fakeCapture{started: []string{"c2", "c1"}}
+	if ids := e.CapturedContainers(); !reflect.DeepEqual(ids, []string{"c1", "c2"}) {
+		t.Errorf("Unexpected captures: %v", ids)
+	}
+}
+
+func TestHandleCommand(t *testing.T) {
+	e := NewEngine(&Config{AgentID: "agent", HostID: "host"})
+
+	// 未启用流量捕获
+	if r := e.HandleCommand(&agent.Command{ID: "1", Type: agent.CommandGetDebug}); r.Success || r.ID != "1" {
+		t.Errorf("Unexpected result without capture: %+v", r)
+	}
+
+	capture := &fakeCapture{}
+	e.config.NetworkManager = capture
+	if r := e.HandleCommand(&agent.Command{ID: "2", Type: agent.CommandForceCapture, ContainerID: "c1"}); !r.Success || len(capture.started) != 1 {
+		t.Errorf("Unexpected capture result: %+v", r)
+	}
+	if r := e.HandleCommand(&agent.Command{ID: "3", Type: agent.CommandForceCapture, ContainerID: "bad"}); r.Success || !strings.Contains(r.Error, "no valid PID") {
+		t.Errorf("Unexpected failure result: %+v", r)
+	}
+	if r := e.HandleCommand(&agent.Command{ID: "4", Type: agent.CommandGetDebug}); !r.Success || r.Output != `{"running":true}` {
+		t.Errorf("Unexpected debug result: %+v", r)
+	}
+	if r := e.HandleCommand(&agent.Command{ID: "5", Type: "reboot"}); r.Success {
+		t.Errorf("Unknown command succeeded: %+v", r)
+	}
+}
+
+func TestUpdateWorkloadModes(t *testing.T) {
+	e := NewEngine(&Config{AgentID: "agent", HostID: "host"})
+	e.UpdateWorkloadModes(map[string]agent.PolicyMode{"w1": agent.PolicyModeProtect})
+	if e.GetWorkloadPolicyMode("w1") != agent.PolicyModeProtect || e.GetWorkloadPolicyMode("w2") != agent.PolicyModeMonitor {
+		t.Errorf("Unexpected modes: w1=%s w2=%s", e.GetWorkloadPolicyMode("w1"), e.GetWorkloadPolicyMode("w2"))
+	}
+
+	// 未下发的工作负载恢复默认模式
+	e.UpdateWorkloadModes(map[string]agent.PolicyMode{})
+	if e.GetWorkloadPolicyMode("w1") != agent.PolicyModeMonitor {
+		t.Errorf("Expected default mode, got %s", e.GetWorkloadPolicyMode("w1"))
+	}
+}
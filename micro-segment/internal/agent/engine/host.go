@@ -0,0 +1,129 @@
+// Package engine 主机信息收集与上报
+package engine
+
+import (
+	"net"
+	"reflect"
+	"runtime"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/micro-segment/internal/agent"
+)
+
+// hostRefreshInterval 重新收集主机网络信息和内部子网的周期
+const hostRefreshInterval = 60 * time.Second
+
+// listHostInterfaces 列出主机网络接口及其地址
+func listHostInterfaces() (map[string][]agent.IPAddr, error) {
+	links, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	ifaces := make(map[string][]agent.IPAddr, len(links))
+	for _, link := range links {
+		addrs, err := link.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			ifaces[link.Name] = append(ifaces[link.Name], agent.IPAddr{
+				IP:    ipnet.IP,
+				IPNet: net.IPNet{IP: ipnet.IP.Mask(ipnet.Mask), Mask: ipnet.Mask},
+				Scope: addrScope(ipnet.IP),
+			})
+		}
+	}
+	return ifaces, nil
+}
+
+// addrScope 返回地址作用域
+func addrScope(ip net.IP) string {
+	switch {
+	case ip.IsLoopback():
+		return "host"
+	case ip.IsLinkLocalUnicast():
+		return "link"
+	default:
+		return "global"
+	}
+}
+
+// gatherHost 收集主机信息
+func (e *Engine) gatherHost() *agent.Host {
+	host := &agent.Host{
+		ID:       e.config.HostID,
+		Name:     e.config.HostName,
+		Platform: runtime.GOOS,
+		Ifaces:   make(map[string][]agent.IPAddr),
+	}
+
+	ifaces, err := e.hostInterfaces()
+	if err != nil {
+		log.WithError(err).Warn("Failed to list host interfaces")
+		return host
+	}
+	host.Ifaces = ifaces
+	return host
+}
+
+// refreshHost 更新主机信息和主机IP集合
+// 信息变化或尚未成功上报时上报Controller
+func (e *Engine) refreshHost() {
+	host := e.gatherHost()
+
+	hostIPs := make(map[string]bool)
+	for _, addrs := range host.Ifaces {
+		for _, addr := range addrs {
+			hostIPs[addr.IP.String()] = true
+		}
+	}
+
+	e.mutex.Lock()
+	if e.host == nil || !reflect.DeepEqual(e.host, host) {
+		e.hostReported = false
+	}
+	e.host = host
+	e.hostIPs = hostIPs
+	reported := e.hostReported
+	e.mutex.Unlock()
+
+	if reported || !e.grpcClient.IsConnected() {
+		return
+	}
+	if err := e.grpcClient.ReportHost(host); err != nil {
+		log.WithError(err).Warn("Failed to report host")
+		return
+	}
+
+	e.mutex.Lock()
+	if e.host == host {
+		e.hostReported = true
+	}
+	e.mutex.Unlock()
+}
+
+// hostNetworkLoop 定期刷新主机信息和内部子网
+func (e *Engine) hostNetworkLoop() {
+	ticker := time.NewTicker(hostRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			e.refreshHost()
+			e.refreshSubnets()
+			if e.config.HostProcesses {
+				e.refreshHostProcesses()
+			}
+		case <-e.stopCh:
+			return
+		}
+	}
+}
@@ -0,0 +1,311 @@
+// Package engine 主机进程工作负载
+package engine
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/micro-segment/internal/agent"
+)
+
+// HostProcessLabel 主机进程工作负载的标签，值为进程名
+const HostProcessLabel = "micro-segment.host-process"
+
+// hostPortsLabel 主机进程工作负载监听的端口，如"tcp/22,udp/53"
+const hostPortsLabel = "micro-segment.host-ports"
+
+// 套接字状态，见内核include/net/tcp_states.h
+const (
+	tcpStateListen = 0x0a
+	udpStateClose  = 0x07 // 未连接的UDP套接字
+)
+
+// hostListener 主机网络命名空间中的监听套接字
+type hostListener struct {
+	Proto uint8
+	IP    net.IP
+	Port  uint16
+	Inode uint64
+}
+
+// hostProc 持有套接字的进程
+type hostProc struct {
+	Pid  int
+	Comm string
+}
+
+// procNetFiles /proc/net下的套接字表及其协议
+var procNetFiles = []struct {
+	name  string
+	proto uint8
+}{
+	{"tcp", 6}, {"tcp6", 6}, {"udp", 17}, {"udp6", 17},
+}
+
+// readHostListeners 读取procRoot/net下的套接字表，返回非回环地址上的监听套接字
+// 不存在的表（如未启用IPv6）被忽略
+func readHostListeners(procRoot string) ([]hostListener, error) {
+	var listeners []hostListener
+	for _, file := range procNetFiles {
+		f, err := os.Open(filepath.Join(procRoot, "net", file.name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if l, ok := parseProcNetLine(scanner.Text(), file.proto); ok && !l.IP.IsLoopback() {
+				listeners = append(listeners, l)
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return listeners, nil
+}
+
+// parseProcNetLine 解析/proc/net/tcp格式的一行，格式如
+// 0: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000 0 0 12345 1 ...
+// TCP只取LISTEN状态，UDP只取未连接的套接字；表头和无法解析的行返回false
+func parseProcNetLine(line string, proto uint8) (hostListener, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 10 || !strings.HasSuffix(fields[0], ":") {
+		return hostListener{}, false
+	}
+	state, err := strconv.ParseUint(fields[3], 16, 8)
+	if err != nil {
+		return hostListener{}, false
+	}
+	switch {
+	case proto == 6 && state == tcpStateListen:
+	case proto == 17 && state == udpStateClose && strings.HasSuffix(fields[2], ":0000"):
+	default:
+		return hostListener{}, false
+	}
+
+	ip, port, ok := parseProcNetAddr(fields[1])
+	if !ok {
+		return hostListener{}, false
+	}
+	inode, err := strconv.ParseUint(fields[9], 10, 64)
+	if err != nil {
+		return hostListener{}, false
+	}
+	return hostListener{Proto: proto, IP: ip, Port: port, Inode: inode}, true
+}
+
+// parseProcNetAddr 解析"地址:端口"，地址按32位字以主机字节序（小端）存储
+func parseProcNetAddr(s string) (net.IP, uint16, bool) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return nil, 0, false
+	}
+	raw, err := hex.DecodeString(s[:i])
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return nil, 0, false
+	}
+	port, err := strconv.ParseUint(s[i+1:], 16, 16)
+	if err != nil {
+		return nil, 0, false
+	}
+	ip := make(net.IP, len(raw))
+	for w := 0; w < len(raw); w += 4 {
+		ip[w], ip[w+1], ip[w+2], ip[w+3] = raw[w+3], raw[w+2], raw[w+1], raw[w]
+	}
+	return ip, uint16(port), true
+}
+
+// readSocketOwners 扫描procRoot下各进程的文件描述符，返回套接字inode到进程的映射
+// 无权限读取的进程被跳过
+func readSocketOwners(procRoot string) map[uint64]hostProc {
+	owners := make(map[uint64]hostProc)
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return owners
+	}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		dir := filepath.Join(procRoot, entry.Name())
+		fds, err := os.ReadDir(filepath.Join(dir, "fd"))
+		if err != nil {
+			continue
+		}
+		var comm string
+		for _, fd := range fds {
+			target, err := os.Readlink(filepath.Join(dir, "fd", fd.Name()))
+			if err != nil || !strings.HasPrefix(target, "socket:[") {
+				continue
+			}
+			inode, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(target, "socket:["), "]"), 10, 64)
+			if err != nil {
+				continue
+			}
+			if comm == "" {
+				b, _ := os.ReadFile(filepath.Join(dir, "comm"))
+				comm = strings.TrimSpace(string(b))
+			}
+			// 子进程继承的套接字归属于最小的pid，通常为父进程
+			if old, ok := owners[inode]; !ok || pid < old.Pid {
+				owners[inode] = hostProc{Pid: pid, Comm: comm}
+			}
+		}
+	}
+	return owners
+}
+
+// hostProtoName 端口标签和工作负载名中使用的协议名
+func hostProtoName(proto uint8) string {
+	if proto == 17 {
+		return "udp"
+	}
+	return "tcp"
+}
+
+// hostWorkloads 按进程名将监听套接字合并为主机进程工作负载，并返回各监听端口所属的工作负载ID
+// 找不到所属进程的套接字按协议和端口各自成为一个工作负载
+func hostWorkloads(hostID, hostName string, listeners []hostListener, owners map[uint64]hostProc, mode agent.PolicyMode) ([]*agent.Workload, map[hostPortKey]string) {
+	type group struct {
+		pid   int
+		ports map[string]bool
+	}
+	groups := make(map[string]*group)
+	portOwners := make(map[hostPortKey]string)
+	for _, l := range listeners {
+		port := fmt.Sprintf("%s/%d", hostProtoName(l.Proto), l.Port)
+		name := fmt.Sprintf("%s-%d", hostProtoName(l.Proto), l.Port)
+		proc, ok := owners[l.Inode]
+		if ok && proc.Comm != "" {
+			name = proc.Comm
+		}
+		g, ok := groups[name]
+		if !ok {
+			g = &group{pid: proc.Pid, ports: make(map[string]bool)}
+			groups[name] = g
+		}
+		if proc.Pid != 0 && (g.pid == 0 || proc.Pid < g.pid) {
+			g.pid = proc.Pid
+		}
+		g.ports[port] = true
+		portOwners[hostPortKey{l.Proto, l.Port}] = hostWorkloadID(hostID, name)
+	}
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	wls := make([]*agent.Workload, 0, len(names))
+	for _, name := range names {
+		g := groups[name]
+		ports := make([]string, 0, len(g.ports))
+		for port := range g.ports {
+			ports = append(ports, port)
+		}
+		sort.Strings(ports)
+		wls = append(wls, &agent.Workload{
+			ID:         hostWorkloadID(hostID, name),
+			Name:       name,
+			Service:    name,
+			HostID:     hostID,
+			HostName:   hostName,
+			Labels:     map[string]string{HostProcessLabel: name, hostPortsLabel: strings.Join(ports, ",")},
+			PolicyMode: mode,
+			Running:    true,
+			Pid:        g.pid,
+			State:      "running",
+			Ifaces:     make(map[string][]agent.IPAddr),
+		})
+	}
+	return wls, portOwners
+}
+
+// hostWorkloadID 主机进程工作负载ID，进程重启后保持不变
+func hostWorkloadID(hostID, name string) string {
+	return "host:" + hostID + ":" + name
+}
+
+// refreshHostProcesses 重新扫描主机监听端口，增删和更新主机进程工作负载并上报Controller
+func (e *Engine) refreshHostProcesses() {
+	listeners, err := readHostListeners(e.procRoot)
+	if err != nil {
+		log.WithError(err).Warn("Failed to read host listening sockets")
+		return
+	}
+	wls, ports := hostWorkloads(e.config.HostID, e.config.HostName, listeners, readSocketOwners(e.procRoot), e.GetDefaultPolicyMode())
+
+	type event struct {
+		typ string
+		wl  *agent.Workload
+	}
+	var events []event
+	current := make(map[string]bool, len(wls))
+	e.mutex.Lock()
+	for _, wl := range wls {
+		current[wl.ID] = true
+		old, ok := e.workloads[wl.ID]
+		switch {
+		case !ok:
+			events = append(events, event{"add", wl})
+		case old.Labels[hostPortsLabel] != wl.Labels[hostPortsLabel] || old.Pid != wl.Pid:
+			events = append(events, event{"update", wl})
+		default:
+			continue
+		}
+		e.workloads[wl.ID] = wl
+	}
+	for id := range e.hostProcesses {
+		if wl, ok := e.workloads[id]; ok && !current[id] {
+			events = append(events, event{"delete", wl})
+			delete(e.workloads, id)
+		}
+	}
+	e.hostProcesses = current
+	e.hostPorts = ports
+	e.mutex.Unlock()
+
+	for _, ev := range events {
+		log.WithFields(log.Fields{"workload": ev.wl.Name, "ports": ev.wl.Labels[hostPortsLabel], "event": ev.typ}).Info("Host process workload changed")
+		if e.grpcClient.IsConnected() {
+			if err := e.grpcClient.ReportWorkload(ev.typ, ev.wl); err != nil {
+				log.WithError(err).WithField("workload", ev.wl.Name).Warn("Failed to report workload")
+			}
+		}
+	}
+}
+
+// hostPortKey 主机监听端口
+type hostPortKey struct {
+	proto uint8
+	port  uint16
+}
+
+// attributeHostProcess 服务端为本机地址且端口由主机进程监听时，将连接的服务端归属到主机进程工作负载
+func (e *Engine) attributeHostProcess(conn *agent.Connection) {
+	if conn.ServerWL != "" || !e.IsLocalIP(conn.ServerIP) {
+		return
+	}
+	e.mutex.RLock()
+	id := e.hostPorts[hostPortKey{conn.IPProto, conn.ServerPort}]
+	e.mutex.RUnlock()
+	if id != "" {
+		conn.ServerWL = id
+	}
+}
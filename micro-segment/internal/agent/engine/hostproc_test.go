@@ -0,0 +1,180 @@
+package engine
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/micro-segment/internal/agent"
+)
+
+const procNetHeader = "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode"
+
+func TestParseProcNetLine(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		line  string
+		proto uint8
+		ok    bool
+		ip    string
+		port  uint16
+		inode uint64
+	}{
+		{"header", procNetHeader, 6, false, "", 0, 0},
+		{"tcp listen", "   0: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1001 1 0000000000000000 100 0 0 10 0", 6, true, "0.0.0.0", 22, 1001},
+		{"tcp listen addr", "   1: 0F02000A:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1003 1 0000000000000000 100 0 0 10 0", 6, true, "10.0.2.15", 8080, 1003},
+		{"tcp established", "   2: 0F02000A:0016 0202000A:D431 01 00000000:00000000 02:000AFA2B 00000000     0        0 1004 4 0000000000000000 20 4 30 10 -1", 6, false, "", 0, 0},
+		{"tcp6 listen", "   0: 00000000000000000000000000000000:1538 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000   999        0 1002 1 0000000000000000 100 0 0 10 0", 6, true, "::", 5432, 1002},
+		{"tcp6 address", "   1: B80D0120000000000000000001000000:0050 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1005 1 0000000000000000 100 0 0 10 0", 6, true, "2001:db8::1", 80, 1005},
+		{"udp unconnected", "  10: 00000000:0035 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 2001 2 0000000000000000 0", 17, true, "0.0.0.0", 53, 2001},
+		{"udp connected", "  11: 0F02000A:A1B2 08080808:0035 01 00000000:00000000 00:00000000 00000000     0        0 2002 2 0000000000000000 0", 17, false, "", 0, 0},
+		{"bad address", "   0: 0000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1001 1", 6, false, "", 0, 0},
+	} {
+		l, ok := parseProcNetLine(tc.line, tc.proto)
+		if ok != tc.ok {
+			t.Errorf("%s: ok = %v", tc.name, ok)
+			continue
+		}
+		if ok && (!l.IP.Equal(net.ParseIP(tc.ip)) || l.Port != tc.port || l.Inode != tc.inode || l.Proto != tc.proto) {
+			t.Errorf("%s: unexpected listener %+v", tc.name, l)
+		}
+	}
+}
+
+// writeProcFixture 在临时目录中生成/proc结构：net下的套接字表及进程的comm和套接字fd
+func writeProcFixture(t *testing.T, tables map[string][]string, procs map[string]struct {
+	comm    string
+	sockets []string
+}) string {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "net"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, lines := range tables {
+		content := procNetHeader + "\n" + strings.Join(lines, "\n") + "\n"
+		if err := os.WriteFile(filepath.Join(root, "net", name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for pid, p := range procs {
+		fdDir := filepath.Join(root, pid, "fd")
+		if err := os.MkdirAll(fdDir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, pid, "comm"), []byte(p.comm+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		for i, inode := range append([]string{"/dev/null"}, p.sockets...) {
+			target := inode
+			if i > 0 {
+				target = "socket:[" + inode + "]"
+			}
+			if err := os.Symlink(target, filepath.Join(fdDir, string(rune('0'+i)))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return root
+}
+
+var hostProcTables = map[string][]string{
+	"tcp": {
+		"   0: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1001 1 0000000000000000 100 0 0 10 0",
+		"   1: 0100007F:0CEA 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1009 1 0000000000000000 100 0 0 10 0",
+		"   2: 0F02000A:0016 0202000A:D431 01 00000000:00000000 02:000AFA2B 00000000     0        0 1004 4 0000000000000000 20 4 30 10 -1",
+	},
+	"tcp6": {
+		"   0: 00000000000000000000000000000000:1538 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000   999        0 1002 1 0000000000000000 100 0 0 10 0",
+		"   1: 00000000000000000000000000000000:1539 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000   999        0 1003 1 0000000000000000 100 0 0 10 0",
+	},
+	"udp": {
+		"  10: 00000000:0035 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 2001 2 0000000000000000 0",
+	},
+}
+
+var hostProcOwners = map[string]struct {
+	comm    string
+	sockets []string
+}{
+	"100": {"sshd", []string{"1001"}},
+	"200": {"postgres", []string{"1002", "1003"}},
+	"201": {"postgres", []string{"1002"}}, // 子进程继承的监听套接字
+	"300": {"redis-server", []string{"1009"}},
+}
+
+func TestHostWorkloads(t *testing.T) {
+	root := writeProcFixture(t, hostProcTables, hostProcOwners)
+	listeners, err := readHostListeners(root)
+	if err != nil {
+		t.Fatalf("readHostListeners: %v", err)
+	}
+	// 回环地址上的redis和已建立的连接不计入
+	if len(listeners) != 4 {
+		t.Fatalf("Unexpected listeners: %+v", listeners)
+	}
+
+	wls, ports := hostWorkloads("host1", "node-1", listeners, readSocketOwners(root), agent.PolicyModeMonitor)
+	var got []string
+	for _, wl := range wls {
+		got = append(got, wl.ID+" "+wl.Labels[hostPortsLabel])
+		if wl.HostID != "host1" || wl.HostName != "node-1" || !wl.Running || wl.Labels[HostProcessLabel] != wl.Name || len(wl.Ifaces) != 0 {
+			t.Errorf("Unexpected workload: %+v", wl)
+		}
+	}
+	want := []string{
+		"host:host1:postgres tcp/5432,tcp/5433",
+		"host:host1:sshd tcp/22",
+		"host:host1:udp-53 udp/53",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Unexpected workloads:\n%s", strings.Join(got, "\n"))
+	}
+	if wls[0].Pid != 200 || wls[1].Pid != 100 || wls[2].Pid != 0 {
+		t.Errorf("Unexpected pids: %d %d %d", wls[0].Pid, wls[1].Pid, wls[2].Pid)
+	}
+	if ports[hostPortKey{6, 5433}] != "host:host1:postgres" || ports[hostPortKey{17, 53}] != "host:host1:udp-53" {
+		t.Errorf("Unexpected port owners: %v", ports)
+	}
+}
+
+func TestRefreshHostProcesses(t *testing.T) {
+	e := NewEngine(&Config{AgentID: "agent", HostID: "host1", HostName: "node-1", HostProcesses: true})
+	e.hostInterfaces = mockInterfaces(t, map[string][]string{"eth0": {"10.0.2.15/24"}})
+	e.refreshHost()
+	e.procRoot = writeProcFixture(t, hostProcTables, hostProcOwners)
+
+	e.refreshHostProcesses()
+	if wl := e.GetWorkload("host:host1:sshd"); wl == nil || wl.Service != "sshd" {
+		t.Fatalf("Host workload not added: %+v", wl)
+	}
+
+	// 访问本机监听端口的连接归属到主机进程
+	conn := &agent.Connection{ClientIP: net.ParseIP("10.0.2.2"), ServerIP: net.ParseIP("10.0.2.15"), ServerPort: 22, IPProto: 6}
+	e.attributeHostProcess(conn)
+	if conn.ServerWL != "host:host1:sshd" {
+		t.Errorf("Unexpected server workload: %q", conn.ServerWL)
+	}
+	for _, c := range []*agent.Connection{
+		{ServerIP: net.ParseIP("10.0.2.16"), ServerPort: 22, IPProto: 6}, // 非本机地址
+		{ServerIP: net.ParseIP("10.0.2.15"), ServerPort: 22, IPProto: 17},
+		{ServerIP: net.ParseIP("10.0.2.15"), ServerPort: 22, IPProto: 6, ServerWL: "c1"},
+	} {
+		want := c.ServerWL
+		if e.attributeHostProcess(c); c.ServerWL != want {
+			t.Errorf("Unexpected attribution for %+v", c)
+		}
+	}
+
+	// 进程停止监听后工作负载被删除
+	e.procRoot = writeProcFixture(t, map[string][]string{"tcp": hostProcTables["tcp"]}, hostProcOwners)
+	e.refreshHostProcesses()
+	if e.GetWorkload("host:host1:postgres") != nil || e.GetWorkload("host:host1:sshd") == nil {
+		t.Errorf("Unexpected workloads after refresh: %v", e.ListWorkloads())
+	}
+	conn = &agent.Connection{ServerIP: net.ParseIP("10.0.2.15"), ServerPort: 5432, IPProto: 6}
+	if e.attributeHostProcess(conn); conn.ServerWL != "" {
+		t.Errorf("Stale port attributed: %q", conn.ServerWL)
+	}
+}
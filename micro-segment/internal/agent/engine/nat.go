@@ -0,0 +1,170 @@
+// Package engine 源地址转换连接归属
+package engine
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/micro-segment/internal/agent"
+)
+
+// conntrackPath 内核连接跟踪表
+const conntrackPath = "/proc/net/nf_conntrack"
+
+// conntrackTTL 连接跟踪表缓存有效期，避免每条连接都重新读取
+const conntrackTTL = 5 * time.Second
+
+// conntrackTuple 连接跟踪五元组中的一个方向
+type conntrackTuple struct {
+	Src   net.IP
+	Dst   net.IP
+	Sport uint16
+	Dport uint16
+}
+
+// conntrackEntry 连接跟踪表项，Orig为发起方向，Reply为经地址转换后的应答方向
+type conntrackEntry struct {
+	Proto uint8
+	Orig  conntrackTuple
+	Reply conntrackTuple
+}
+
+// natTable 连接跟踪表缓存
+type natTable struct {
+	mutex   sync.Mutex
+	entries []conntrackEntry
+	readAt  time.Time
+}
+
+// readConntrack 读取并解析内核连接跟踪表
+func readConntrack() ([]conntrackEntry, error) {
+	f, err := os.Open(conntrackPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []conntrackEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if entry, ok := parseConntrackLine(scanner.Text()); ok {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// parseConntrackLine 解析nf_conntrack的一行，格式如
+// ipv4 2 tcp 6 431999 ESTABLISHED src=... dst=... sport=... dport=... src=... dst=... sport=... dport=... [ASSURED] ...
+// 第一组键值为发起方向，第二组为应答方向；不含端口的协议忽略
+func parseConntrackLine(line string) (conntrackEntry, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 {
+		return conntrackEntry{}, false
+	}
+	proto, err := strconv.ParseUint(fields[3], 10, 8)
+	if err != nil {
+		return conntrackEntry{}, false
+	}
+
+	entry := conntrackEntry{Proto: uint8(proto)}
+	tuple := &entry.Orig
+	var seen [2]int
+	dir := 0
+	for _, field := range fields[4:] {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		if kv[0] == "src" && seen[dir] != 0 {
+			if dir == 1 {
+				break
+			}
+			dir = 1
+			tuple = &entry.Reply
+		}
+		switch kv[0] {
+		case "src":
+			tuple.Src = net.ParseIP(kv[1])
+		case "dst":
+			tuple.Dst = net.ParseIP(kv[1])
+		case "sport", "dport":
+			port, err := strconv.ParseUint(kv[1], 10, 16)
+			if err != nil {
+				return conntrackEntry{}, false
+			}
+			if kv[0] == "sport" {
+				tuple.Sport = uint16(port)
+			} else {
+				tuple.Dport = uint16(port)
+			}
+		default:
+			continue
+		}
+		seen[dir]++
+	}
+	if seen[0] != 4 || seen[1] != 4 || entry.Orig.Src == nil || entry.Reply.Dst == nil {
+		return conntrackEntry{}, false
+	}
+	return entry, true
+}
+
+// lookupSNAT 查找应答方向目的地址为client的连接跟踪表项，返回地址转换前的发起方
+// 表缓存过期时重新读取
+func (e *Engine) lookupSNAT(conn *agent.Connection) (conntrackTuple, bool) {
+	t := &e.natTable
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if time.Since(t.readAt) > conntrackTTL {
+		entries, err := e.conntrack()
+		if err != nil {
+			log.WithError(err).Debug("Failed to read conntrack table")
+		}
+		t.entries, t.readAt = entries, time.Now()
+	}
+
+	for _, entry := range t.entries {
+		if entry.Proto != conn.IPProto {
+			continue
+		}
+		r := entry.Reply
+		if r.Dst.Equal(conn.ClientIP) && r.Dport == conn.ClientPort &&
+			r.Src.Equal(conn.ServerIP) && r.Sport == conn.ServerPort {
+			return entry.Orig, true
+		}
+	}
+	return conntrackTuple{}, false
+}
+
+// attributeSNAT 将被SNAT为主机IP的连接归属到发起的本机容器
+// 仅当客户端为主机IP，且连接跟踪中地址转换前的发起方为本机工作负载的临时端口时改写客户端
+func (e *Engine) attributeSNAT(conn *agent.Connection) {
+	if !e.IsLocalIP(conn.ClientIP) {
+		return
+	}
+	orig, ok := e.lookupSNAT(conn)
+	if !ok || orig.Sport < ephemeralPortMin || orig.Src.Equal(conn.ClientIP) {
+		return
+	}
+	wlID := e.workloadByIP(orig.Src)
+	if wlID == "" {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"host_ip":  conn.ClientIP,
+		"client":   orig.Src,
+		"workload": wlID,
+	}).Debug("Attributed NAT'd connection to workload")
+	conn.ClientIP = orig.Src
+	conn.ClientPort = orig.Sport
+	conn.ClientWL = wlID
+}
@@ -0,0 +1,71 @@
+package engine
+
+import (
+	"net"
+	"testing"
+
+	"github.com/micro-segment/internal/agent"
+)
+
+func TestParseConntrackLine(t *testing.T) {
+	line := "ipv4     2 tcp      6 431999 ESTABLISHED src=172.17.0.2 dst=93.184.216.34 sport=45000 dport=443 src=93.184.216.34 dst=10.0.0.5 sport=443 dport=45000 [ASSURED] mark=0 zone=0 use=2"
+	entry, ok := parseConntrackLine(line)
+	if !ok {
+		t.Fatalf("Failed to parse conntrack line")
+	}
+	if entry.Proto != 6 || !entry.Orig.Src.Equal(net.ParseIP("172.17.0.2")) || entry.Orig.Sport != 45000 ||
+		!entry.Reply.Dst.Equal(net.ParseIP("10.0.0.5")) || entry.Reply.Sport != 443 || entry.Reply.Dport != 45000 {
+		t.Errorf("Unexpected entry: %+v", entry)
+	}
+
+	// ICMP表项没有端口
+	if _, ok := parseConntrackLine("ipv4 2 icmp 1 29 src=172.17.0.2 dst=8.8.8.8 type=8 code=0 id=1 src=8.8.8.8 dst=10.0.0.5 type=0 code=0 id=1 mark=0 use=1"); ok {
+		t.Errorf("Expected ICMP entry to be skipped")
+	}
+}
+
+func TestAttributeSNAT(t *testing.T) {
+	e := NewEngine(&Config{AgentID: "agent", HostID: "host"})
+	e.hostIPs = map[string]bool{"10.0.0.5": true, "172.17.0.1": true}
+	e.AddWorkload(&agent.Workload{
+		ID: "web",
+		Ifaces: map[string][]agent.IPAddr{
+			"eth0": {{IP: net.ParseIP("172.17.0.2")}},
+		},
+	})
+
+	hostIP, remote := net.ParseIP("10.0.0.5"), net.ParseIP("93.184.216.34")
+	reads := 0
+	e.conntrack = func() ([]conntrackEntry, error) {
+		reads++
+		return []conntrackEntry{
+			{Proto: 6, Orig: conntrackTuple{net.ParseIP("172.17.0.2"), remote, 45000, 443}, Reply: conntrackTuple{remote, hostIP, 443, 45000}},
+			{Proto: 6, Orig: conntrackTuple{net.ParseIP("172.17.0.2"), remote, 900, 443}, Reply: conntrackTuple{remote, hostIP, 443, 900}},
+			{Proto: 6, Orig: conntrackTuple{net.ParseIP("172.17.0.9"), remote, 46000, 443}, Reply: conntrackTuple{remote, hostIP, 443, 46000}},
+		}, nil
+	}
+
+	cases := []struct {
+		name   string
+		conn   agent.Connection
+		client string
+		wl     string
+	}{
+		{"snat to host ip", agent.Connection{ClientIP: hostIP, ServerIP: remote, ClientPort: 45000, ServerPort: 443, IPProto: 6}, "172.17.0.2", "web"},
+		{"client not host ip", agent.Connection{ClientIP: net.ParseIP("10.0.0.7"), ServerIP: remote, ClientPort: 45000, ServerPort: 443, IPProto: 6}, "10.0.0.7", ""},
+		{"no conntrack entry", agent.Connection{ClientIP: hostIP, ServerIP: remote, ClientPort: 47000, ServerPort: 443, IPProto: 6}, "10.0.0.5", ""},
+		{"protocol mismatch", agent.Connection{ClientIP: hostIP, ServerIP: remote, ClientPort: 45000, ServerPort: 443, IPProto: 17}, "10.0.0.5", ""},
+		{"non-ephemeral source port", agent.Connection{ClientIP: hostIP, ServerIP: remote, ClientPort: 900, ServerPort: 443, IPProto: 6}, "10.0.0.5", ""},
+		{"source not a local workload", agent.Connection{ClientIP: hostIP, ServerIP: remote, ClientPort: 46000, ServerPort: 443, IPProto: 6}, "10.0.0.5", ""},
+	}
+	for _, c := range cases {
+		conn := c.conn
+		e.attributeSNAT(&conn)
+		if conn.ClientIP.String() != c.client || conn.ClientWL != c.wl {
+			t.Errorf("%s: got client=%v wl=%q", c.name, conn.ClientIP, conn.ClientWL)
+		}
+	}
+	if reads != 1 {
+		t.Errorf("Expected conntrack table to be cached, read %d times", reads)
+	}
+}
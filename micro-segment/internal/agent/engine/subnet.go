@@ -0,0 +1,113 @@
+// Package engine 内部子网自动发现
+package engine
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/micro-segment/internal/agent"
+)
+
+// 内部子网来源
+const (
+	subnetScopeHost   = "host"   // 主机网卡
+	subnetScopeDocker = "docker" // Docker网络
+	subnetScopeStatic = "static" // 静态配置
+)
+
+// ParseSubnets 解析逗号分隔的CIDR列表
+func ParseSubnets(s string) ([]net.IPNet, error) {
+	var subnets []net.IPNet
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subnet %s: %v", item, err)
+		}
+		subnets = append(subnets, *ipnet)
+	}
+	return subnets, nil
+}
+
+// discoverSubnets 从主机网卡、Docker网络和静态配置收集内部子网
+// 回环和链路本地地址不计入，以网段CIDR去重
+func (e *Engine) discoverSubnets() map[string]*agent.Subnet {
+	subnets := make(map[string]*agent.Subnet)
+	add := func(ipnet net.IPNet, scope string) {
+		if ipnet.IP.IsLoopback() || ipnet.IP.IsLinkLocalUnicast() {
+			return
+		}
+		ipnet.IP = ipnet.IP.Mask(ipnet.Mask)
+		key := ipnet.String()
+		if _, ok := subnets[key]; !ok {
+			subnets[key] = &agent.Subnet{Subnet: ipnet, Scope: scope}
+		}
+	}
+
+	// 静态配置优先，来源标记为static
+	for _, ipnet := range e.config.StaticSubnets {
+		add(ipnet, subnetScopeStatic)
+	}
+
+	if ifaces, err := e.hostInterfaces(); err != nil {
+		log.WithError(err).Warn("Failed to list host interfaces")
+	} else {
+		for _, addrs := range ifaces {
+			for _, addr := range addrs {
+				add(addr.IPNet, subnetScopeHost)
+			}
+		}
+	}
+
+	if e.config.DockerSubnets != nil {
+		if nets, err := e.config.DockerSubnets(); err != nil {
+			log.WithError(err).Warn("Failed to list docker network subnets")
+		} else {
+			for _, ipnet := range nets {
+				add(ipnet, subnetScopeDocker)
+			}
+		}
+	}
+	return subnets
+}
+
+// refreshSubnets 重新发现内部子网，变化时同步到DP
+func (e *Engine) refreshSubnets() {
+	subnets := e.discoverSubnets()
+
+	e.mutex.RLock()
+	changed := !sameSubnets(e.subnets, subnets)
+	e.mutex.RUnlock()
+	if !changed {
+		return
+	}
+
+	keys := make([]string, 0, len(subnets))
+	for key := range subnets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	log.WithField("subnets", keys).Info("Internal subnets updated")
+
+	e.UpdateSubnets(subnets)
+}
+
+// sameSubnets 比较两组子网的网段是否一致
+func sameSubnets(a, b map[string]*agent.Subnet) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key := range a {
+		if _, ok := b[key]; !ok {
+			return false
+		}
+	}
+	return true
+}
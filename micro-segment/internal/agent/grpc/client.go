@@ -4,7 +4,7 @@ package grpc
 import (
 	"context"
 	"fmt"
-	"net"
+	"math/rand"
 	"sync"
 	"time"
 
@@ -33,7 +33,27 @@ type Client struct {
 
 	// 心跳
 	heartbeatInterval time.Duration
+	heartbeatJitter   float64
 	stopCh            chan struct{}
+
+	// 策略订阅
+	watchRetryInterval time.Duration
+	onPolicies         func([]*agent.PolicyRule)
+	onWorkloadModes    func(map[string]agent.PolicyMode)
+
+	// 注册时上报的完整工作负载列表
+	workloadSource func() []*agent.Workload
+
+	// 随心跳上报的正在捕获的容器列表
+	captureSource func() []string
+
+	// 上报连接时省略的可选字段
+	fieldMask ConnectionFieldMask
+
+	// 远程命令，执行结果暂存到下一次心跳回传
+	onCommand      func(*agent.Command) *agent.CommandResult
+	resultsMutex   sync.Mutex
+	pendingResults []*pb.AgentCommandResult
 }
 
 // NewClient 创建gRPC客户端
@@ -47,7 +67,94 @@ func NewClient(serverAddr, agentID, hostID, hostName, version string) *Client {
 		version:           version,
 		heartbeatInterval: 10 * time.Second,
 		stopCh:            make(chan struct{}),
+
+		watchRetryInterval: 5 * time.Second,
+	}
+}
+
+// heartbeatTimeoutFraction 心跳间隔不超过Controller心跳超时的此分之一，容忍连续两次心跳丢失
+const heartbeatTimeoutFraction = 3
+
+// SetHeartbeatInterval 设置心跳间隔
+// 注册时若超过Controller心跳超时的安全比例则自动缩短，需在Register之前设置
+func (c *Client) SetHeartbeatInterval(interval time.Duration) {
+	c.heartbeatInterval = interval
+}
+
+// MaxHeartbeatJitter 心跳抖动比例上限，保证抖动后的间隔不超过协商间隔的1.5倍
+const MaxHeartbeatJitter = 0.5
+
+// SetHeartbeatJitter 设置心跳间隔的随机抖动比例，每次间隔在[1-jitter, 1+jitter]倍之间随机取值，
+// 大于0时首次心跳前另加一个心跳间隔内的随机延迟，避免大量Agent同时启动时心跳对齐；
+// 0表示不抖动，超出[0, MaxHeartbeatJitter]时截断，需在Register之前设置
+func (c *Client) SetHeartbeatJitter(jitter float64) {
+	if jitter < 0 {
+		jitter = 0
 	}
+	if jitter > MaxHeartbeatJitter {
+		jitter = MaxHeartbeatJitter
+	}
+	c.heartbeatJitter = jitter
+}
+
+// SetConnectionFieldMask 设置上报连接时省略的可选字段，需在连接上报开始之前设置
+func (c *Client) SetConnectionFieldMask(mask ConnectionFieldMask) {
+	c.fieldMask = mask
+}
+
+// HeartbeatInterval 获取实际使用的心跳间隔
+func (c *Client) HeartbeatInterval() time.Duration {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.heartbeatInterval
+}
+
+// negotiateHeartbeat 按Controller心跳超时（秒）计算心跳间隔
+// 超时为0表示Controller未告知，保持配置值
+func negotiateHeartbeat(interval time.Duration, timeoutSec uint32) time.Duration {
+	if timeoutSec == 0 {
+		return interval
+	}
+	limit := time.Duration(timeoutSec) * time.Second / heartbeatTimeoutFraction
+	if interval > limit {
+		return limit
+	}
+	return interval
+}
+
+// jitterInterval 按随机数r（[0, 1)）在[1-jitter, 1+jitter]倍之间调整间隔
+func jitterInterval(interval time.Duration, jitter, r float64) time.Duration {
+	return time.Duration(float64(interval) * (1 + jitter*(2*r-1)))
+}
+
+// SetOnPolicies 设置策略推送回调
+// 设置后注册成功时订阅Controller策略推送
+func (c *Client) SetOnPolicies(cb func([]*agent.PolicyRule)) {
+	c.onPolicies = cb
+}
+
+// SetOnWorkloadModes 设置工作负载策略模式推送回调
+// 模式随策略一同推送，回调在策略回调之后调用
+func (c *Client) SetOnWorkloadModes(cb func(map[string]agent.PolicyMode)) {
+	c.onWorkloadModes = cb
+}
+
+// SetWorkloadSource 设置工作负载列表来源
+// 设置后注册时上报完整工作负载列表，Controller据此清理该Agent已不存在的工作负载
+func (c *Client) SetWorkloadSource(source func() []*agent.Workload) {
+	c.workloadSource = source
+}
+
+// SetCaptureSource 设置正在捕获的容器列表来源
+// 设置后每次心跳上报当前捕获的容器，便于在Controller排查工作负载无流量的原因
+func (c *Client) SetCaptureSource(source func() []string) {
+	c.captureSource = source
+}
+
+// SetOnCommand 设置远程命令处理回调
+// 心跳响应携带的命令依次交给回调执行，结果随下一次心跳回传
+func (c *Client) SetOnCommand(cb func(*agent.Command) *agent.CommandResult) {
+	c.onCommand = cb
 }
 
 // Connect 连接到Controller
@@ -114,12 +221,20 @@ func (c *Client) Register() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	resp, err := client.Register(ctx, &pb.AgentInfo{
+	info := &pb.AgentInfo{
 		AgentId:  c.agentID,
 		HostId:   c.hostID,
 		HostName: c.hostName,
 		Version:  c.version,
-	})
+	}
+	if c.workloadSource != nil {
+		for _, wl := range c.workloadSource() {
+			info.Workloads = append(info.Workloads, workloadToProto(wl))
+		}
+		info.FullSync = true
+	}
+
+	resp, err := client.Register(ctx, info)
 	if err != nil {
 		return fmt.Errorf("register failed: %v", err)
 	}
@@ -128,27 +243,106 @@ func (c *Client) Register() error {
 		return fmt.Errorf("register failed: %s", resp.Message)
 	}
 
+	c.mutex.Lock()
+	interval := negotiateHeartbeat(c.heartbeatInterval, resp.HeartbeatTimeout)
+	if interval != c.heartbeatInterval {
+		log.WithFields(log.Fields{
+			"configured":        c.heartbeatInterval,
+			"heartbeat_timeout": resp.HeartbeatTimeout,
+		}).Warn("Heartbeat interval too close to controller timeout, shortened")
+		c.heartbeatInterval = interval
+	}
+	c.mutex.Unlock()
+
 	log.WithFields(log.Fields{
-		"cluster_id":      resp.ClusterId,
-		"report_interval": resp.ReportInterval,
+		"cluster_id":         resp.ClusterId,
+		"report_interval":    resp.ReportInterval,
+		"heartbeat_interval": interval,
 	}).Info("Agent registered")
 
 	// 启动心跳
 	go c.heartbeatLoop()
 
+	// 订阅策略推送
+	if c.onPolicies != nil {
+		go c.watchPoliciesLoop()
+	}
+
 	return nil
 }
 
+// watchPoliciesLoop 策略订阅循环
+// 订阅流断开后按重试间隔重新订阅，直到客户端断开
+func (c *Client) watchPoliciesLoop() {
+	for {
+		if err := c.watchPolicies(); err != nil {
+			log.WithError(err).Warn("Policy watch stream broken, retrying")
+		}
+
+		select {
+		case <-time.After(c.watchRetryInterval):
+		case <-c.stopCh:
+			return
+		}
+	}
+}
+
+// watchPolicies 订阅策略并处理推送，直到流结束
+func (c *Client) watchPolicies() error {
+	c.mutex.RLock()
+	if !c.connected {
+		c.mutex.RUnlock()
+		return fmt.Errorf("not connected")
+	}
+	client := c.client
+	c.mutex.RUnlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-c.stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	stream, err := client.WatchPolicies(ctx, &pb.PolicyRequest{AgentId: c.agentID})
+	if err != nil {
+		return fmt.Errorf("watch policies failed: %v", err)
+	}
+
+	for {
+		list, err := stream.Recv()
+		if err != nil {
+			return fmt.Errorf("watch policies failed: %v", err)
+		}
+
+		rules := rulesFromProto(list.Rules)
+		log.WithField("rules", len(rules)).Info("Policies pushed from Controller")
+		c.onPolicies(rules)
+		if c.onWorkloadModes != nil {
+			c.onWorkloadModes(modesFromProto(list.WorkloadModes))
+		}
+	}
+}
+
 // heartbeatLoop 心跳循环
-// 定期向Controller发送心跳保持连接
+// 定期向Controller发送心跳保持连接，配置抖动时首次心跳随机延迟、此后每次间隔随机调整
 func (c *Client) heartbeatLoop() {
-	ticker := time.NewTicker(c.heartbeatInterval)
-	defer ticker.Stop()
+	interval := c.HeartbeatInterval()
+	delay := interval
+	if c.heartbeatJitter > 0 {
+		delay = time.Duration(rand.Int63n(int64(interval)))
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
 
 	for {
 		select {
-		case <-ticker.C:
+		case <-timer.C:
 			c.sendHeartbeat()
+			timer.Reset(jitterInterval(interval, c.heartbeatJitter, rand.Float64()))
 		case <-c.stopCh:
 			return
 		}
@@ -169,15 +363,72 @@ func (c *Client) sendHeartbeat() {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	_, err := client.Heartbeat(ctx, &pb.HeartbeatRequest{
+	results := c.takeResults()
+	req := &pb.HeartbeatRequest{
 		AgentId:   c.agentID,
 		Timestamp: uint64(time.Now().Unix()),
-	})
+		Results:   results,
+	}
+	if c.captureSource != nil {
+		req.CapturedContainers = c.captureSource()
+	}
+	resp, err := client.Heartbeat(ctx, req)
 	if err != nil {
+		// 结果未送达，留待下一次心跳重新回传
+		c.queueResults(results...)
 		log.WithError(err).Warn("Heartbeat failed")
+		return
+	}
+	if len(resp.Commands) > 0 {
+		go c.runCommands(resp.Commands)
+	}
+}
+
+// runCommands 依次执行心跳下发的命令并暂存结果
+func (c *Client) runCommands(cmds []*pb.AgentCommand) {
+	for _, cmd := range cmds {
+		result := &agent.CommandResult{ID: cmd.Id, Error: "command not supported"}
+		if c.onCommand != nil {
+			result = c.onCommand(&agent.Command{
+				ID:          cmd.Id,
+				Type:        cmd.Type,
+				ContainerID: cmd.ContainerId,
+			})
+			result.ID = cmd.Id
+		}
+		log.WithFields(log.Fields{
+			"id":      cmd.Id,
+			"command": cmd.Type,
+			"success": result.Success,
+		}).Info("Controller command executed")
+		c.queueResults(&pb.AgentCommandResult{
+			Id:      result.ID,
+			Success: result.Success,
+			Error:   result.Error,
+			Output:  result.Output,
+		})
 	}
 }
 
+// queueResults 暂存命令执行结果
+func (c *Client) queueResults(results ...*pb.AgentCommandResult) {
+	if len(results) == 0 {
+		return
+	}
+	c.resultsMutex.Lock()
+	defer c.resultsMutex.Unlock()
+	c.pendingResults = append(c.pendingResults, results...)
+}
+
+// takeResults 取出全部暂存的命令执行结果
+func (c *Client) takeResults() []*pb.AgentCommandResult {
+	c.resultsMutex.Lock()
+	defer c.resultsMutex.Unlock()
+	results := c.pendingResults
+	c.pendingResults = nil
+	return results
+}
+
 // ReportConnections 上报连接
 // 批量上报网络连接数据到Controller
 func (c *Client) ReportConnections(conns []*agent.Connection) error {
@@ -192,13 +443,41 @@ func (c *Client) ReportConnections(conns []*agent.Connection) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	pbConns := connectionsToProto(conns, c.fieldMask)
+
+	resp, err := client.ReportConnections(ctx, &pb.ConnectionReport{
+		AgentId:     c.agentID,
+		HostId:      c.hostID,
+		Connections: pbConns,
+	})
+	if err != nil {
+		return fmt.Errorf("report connections failed: %v", err)
+	}
+
+	if resp.Code != 0 {
+		return fmt.Errorf("report connections failed: %s", resp.Message)
+	}
+
+	return nil
+}
+
+// connectionsToProto 转换连接
+// 跳过策略动作超出范围的连接，清除mask中省略的字段
+func connectionsToProto(conns []*agent.Connection, mask ConnectionFieldMask) []*pb.Connection {
 	pbConns := make([]*pb.Connection, 0, len(conns))
 	for _, conn := range conns {
-		pbConns = append(pbConns, &pb.Connection{
+		// 未知的策略动作会被Controller拒绝，在此跳过
+		if conn.PolicyAction > uint8(agent.PolicyActionViolate) {
+			log.WithFields(log.Fields{
+				"client_ip": conn.ClientIP, "server_ip": conn.ServerIP, "policy_action": conn.PolicyAction,
+			}).Warn("Skip connection with out of range policy action")
+			continue
+		}
+		pbConn := &pb.Connection{
 			ClientWl:     conn.ClientWL,
 			ServerWl:     conn.ServerWL,
-			ClientIp:     conn.ClientIP,
-			ServerIp:     conn.ServerIP,
+			ClientIp:     pb.EncodeIP(conn.ClientIP),
+			ServerIp:     pb.EncodeIP(conn.ServerIP),
 			ClientPort:   uint32(conn.ClientPort),
 			ServerPort:   uint32(conn.ServerPort),
 			IpProto:      uint32(conn.IPProto),
@@ -217,23 +496,16 @@ func (c *Client) ReportConnections(conns []*agent.Connection) error {
 			Scope:        conn.Scope,
 			Network:      conn.Network,
 			Violates:     conn.Violates,
-		})
-	}
+			L7:           l7ToProto(conn.L7),
+			Capped:       conn.Capped,
+			Summary:      conn.Summary,
 
-	resp, err := client.ReportConnections(ctx, &pb.ConnectionReport{
-		AgentId:     c.agentID,
-		HostId:      c.hostID,
-		Connections: pbConns,
-	})
-	if err != nil {
-		return fmt.Errorf("report connections failed: %v", err)
-	}
-
-	if resp.Code != 0 {
-		return fmt.Errorf("report connections failed: %s", resp.Message)
+			InternetIngress: conn.InternetIngress,
+		}
+		mask.apply(pbConn)
+		pbConns = append(pbConns, pbConn)
 	}
-
-	return nil
+	return pbConns
 }
 
 // ReportThreats 上报威胁日志
@@ -252,21 +524,7 @@ func (c *Client) ReportThreats(threats []*agent.ThreatLog) error {
 
 	pbThreats := make([]*pb.ThreatLog, 0, len(threats))
 	for _, threat := range threats {
-		pbThreats = append(pbThreats, &pb.ThreatLog{
-			Id:         threat.ID,
-			ThreatId:   threat.ThreatID,
-			ThreatName: threat.ThreatName,
-			Severity:   threat.Severity,
-			ClientWl:   threat.ClientWL,
-			ServerWl:   threat.ServerWL,
-			ClientIp:   threat.ClientIP,
-			ServerIp:   threat.ServerIP,
-			ServerPort: uint32(threat.ServerPort),
-			IpProto:    uint32(threat.IPProto),
-			PktIngress: threat.PktIngress,
-			LocalPeer:  threat.LocalPeer,
-			ReportedAt: uint64(threat.ReportedAt.Unix()),
-		})
+		pbThreats = append(pbThreats, threatToProto(threat))
 	}
 
 	resp, err := client.ReportThreats(ctx, &pb.ThreatReport{
@@ -285,6 +543,45 @@ func (c *Client) ReportThreats(threats []*agent.ThreatLog) error {
 	return nil
 }
 
+// threatToProto 转换威胁日志
+func threatToProto(threat *agent.ThreatLog) *pb.ThreatLog {
+	return &pb.ThreatLog{
+		Id:         threat.ID,
+		ThreatId:   threat.ThreatID,
+		ThreatName: threat.ThreatName,
+		Severity:   threat.Severity,
+		ClientWl:   threat.ClientWL,
+		ServerWl:   threat.ServerWL,
+		ClientIp:   pb.EncodeIP(threat.ClientIP),
+		ServerIp:   pb.EncodeIP(threat.ServerIP),
+		ServerPort: uint32(threat.ServerPort),
+		IpProto:    uint32(threat.IPProto),
+		PktIngress: threat.PktIngress,
+		LocalPeer:  threat.LocalPeer,
+		ReportedAt: uint64(threat.ReportedAt.Unix()),
+		TcpFlags:   uint32(threat.TcpFlags),
+		PktLen:     uint32(threat.PktLen),
+		PktSummary: threat.PktSummary,
+	}
+}
+
+// l7ToProto 转换应用层元数据
+func l7ToProto(meta []agent.L7Meta) []*pb.L7Metadata {
+	if len(meta) == 0 {
+		return nil
+	}
+	result := make([]*pb.L7Metadata, 0, len(meta))
+	for _, m := range meta {
+		result = append(result, &pb.L7Metadata{
+			HttpMethod: m.HTTPMethod,
+			HttpHost:   m.HTTPHost,
+			DnsQuery:   m.DNSQuery,
+			TlsSni:     m.TLSSNI,
+		})
+	}
+	return result
+}
+
 // ReportWorkload 上报工作负载变更
 // 上报容器生命周期事件到Controller
 func (c *Client) ReportWorkload(eventType string, wl *agent.Workload) error {
@@ -299,38 +596,10 @@ func (c *Client) ReportWorkload(eventType string, wl *agent.Workload) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	// 转换接口
-	ifaces := make([]*pb.NetworkInterface, 0)
-	for name, addrs := range wl.Ifaces {
-		pbAddrs := make([]*pb.IPAddress, 0, len(addrs))
-		for _, addr := range addrs {
-			pbAddrs = append(pbAddrs, &pb.IPAddress{
-				Ip:      addr.IP.String(),
-				Scope:   addr.Scope,
-				Gateway: addr.Gateway,
-			})
-		}
-		ifaces = append(ifaces, &pb.NetworkInterface{
-			Name:  name,
-			Addrs: pbAddrs,
-		})
-	}
-
 	resp, err := client.ReportWorkload(ctx, &pb.WorkloadEvent{
 		AgentId:   c.agentID,
 		EventType: eventType,
-		Workload: &pb.Workload{
-			Id:         wl.ID,
-			Name:       wl.Name,
-			HostId:     wl.HostID,
-			HostName:   wl.HostName,
-			Domain:     wl.Domain,
-			Service:    wl.Service,
-			PolicyMode: string(wl.PolicyMode),
-			Running:    wl.Running,
-			Pid:        int32(wl.Pid),
-			Ifaces:     ifaces,
-		},
+		Workload:  workloadToProto(wl),
 	})
 	if err != nil {
 		return fmt.Errorf("report workload failed: %v", err)
@@ -343,6 +612,83 @@ func (c *Client) ReportWorkload(eventType string, wl *agent.Workload) error {
 	return nil
 }
 
+// workloadToProto 转换工作负载
+func workloadToProto(wl *agent.Workload) *pb.Workload {
+	return &pb.Workload{
+		Id:         wl.ID,
+		Name:       wl.Name,
+		HostId:     wl.HostID,
+		HostName:   wl.HostName,
+		Domain:     wl.Domain,
+		Service:    wl.Service,
+		Image:      wl.Image,
+		PolicyMode: string(wl.PolicyMode),
+		Running:    wl.Running,
+		Pid:        int32(wl.Pid),
+		Ifaces:     ifacesToProto(wl.Ifaces),
+		Labels:     wl.Labels,
+		PodName:    wl.PodName,
+		OwnerKind:  wl.OwnerKind,
+		OwnerName:  wl.OwnerName,
+		State:      wl.State,
+		Health:     wl.Health,
+	}
+}
+
+// ReportHost 上报主机信息
+// 上报主机名称、平台和网络接口到Controller
+func (c *Client) ReportHost(host *agent.Host) error {
+	c.mutex.RLock()
+	if !c.connected {
+		c.mutex.RUnlock()
+		return fmt.Errorf("not connected")
+	}
+	client := c.client
+	c.mutex.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := client.ReportHost(ctx, &pb.HostReport{
+		AgentId: c.agentID,
+		Host: &pb.Host{
+			Id:       host.ID,
+			Name:     host.Name,
+			Platform: host.Platform,
+			Ifaces:   ifacesToProto(host.Ifaces),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("report host failed: %v", err)
+	}
+
+	if resp.Code != 0 {
+		return fmt.Errorf("report host failed: %s", resp.Message)
+	}
+
+	return nil
+}
+
+// ifacesToProto 转换网络接口
+func ifacesToProto(ifaces map[string][]agent.IPAddr) []*pb.NetworkInterface {
+	result := make([]*pb.NetworkInterface, 0, len(ifaces))
+	for name, addrs := range ifaces {
+		pbAddrs := make([]*pb.IPAddress, 0, len(addrs))
+		for _, addr := range addrs {
+			pbAddrs = append(pbAddrs, &pb.IPAddress{
+				Ip:      addr.IP.String(),
+				Scope:   addr.Scope,
+				Gateway: addr.Gateway,
+			})
+		}
+		result = append(result, &pb.NetworkInterface{
+			Name:  name,
+			Addrs: pbAddrs,
+		})
+	}
+	return result
+}
+
 // GetPolicies 获取策略
 // 从Controller获取指定工作负载的网络策略
 func (c *Client) GetPolicies(workloadIDs []string) ([]*agent.PolicyRule, error) {
@@ -365,8 +711,31 @@ func (c *Client) GetPolicies(workloadIDs []string) ([]*agent.PolicyRule, error)
 		return nil, fmt.Errorf("get policies failed: %v", err)
 	}
 
-	rules := make([]*agent.PolicyRule, 0, len(resp.Rules))
-	for _, r := range resp.Rules {
+	return rulesFromProto(resp.Rules), nil
+}
+
+// modesFromProto 转换工作负载策略模式，未知模式按Monitor处理
+func modesFromProto(pbModes []*pb.WorkloadMode) map[string]agent.PolicyMode {
+	modes := make(map[string]agent.PolicyMode, len(pbModes))
+	for _, m := range pbModes {
+		if m.Mode == string(agent.PolicyModeProtect) {
+			modes[m.WorkloadId] = agent.PolicyModeProtect
+		} else {
+			modes[m.WorkloadId] = agent.PolicyModeMonitor
+		}
+	}
+	return modes
+}
+
+// rulesFromProto 转换proto策略规则
+func rulesFromProto(pbRules []*pb.PolicyRule) []*agent.PolicyRule {
+	rules := make([]*agent.PolicyRule, 0, len(pbRules))
+	for _, r := range pbRules {
+		// 超出范围的动作转换为uint8会回绕成其他动作，跳过该规则
+		if _, err := pb.PolicyActionName(r.Action); err != nil {
+			log.WithFields(log.Fields{"rule": r.Id, "action": r.Action}).Warn("Skip policy rule with out of range action")
+			continue
+		}
 		rules = append(rules, &agent.PolicyRule{
 			ID:           r.Id,
 			From:         r.From,
@@ -375,17 +744,8 @@ func (c *Client) GetPolicies(workloadIDs []string) ([]*agent.PolicyRule, error)
 			Applications: r.Applications,
 			Action:       agent.PolicyAction(r.Action),
 			Ingress:      r.Ingress,
+			Log:          r.Log,
 		})
 	}
-
-	return rules, nil
-}
-
-// ipToBytes 转换IP为字节
-// 将IP地址转换为字节数组，支持IPv4和IPv6
-func ipToBytes(ip net.IP) []byte {
-	if ip4 := ip.To4(); ip4 != nil {
-		return ip4
-	}
-	return ip
+	return rules
 }
@@ -0,0 +1,270 @@
+package grpc
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+
+	pb "github.com/micro-segment/api/proto"
+	"github.com/micro-segment/internal/agent"
+	"github.com/micro-segment/internal/controller/cache"
+)
+
+func TestL7ToProto(t *testing.T) {
+	if l7ToProto(nil) != nil {
+		t.Errorf("Expected nil for empty metadata")
+	}
+
+	pbMeta := l7ToProto([]agent.L7Meta{
+		{HTTPMethod: "POST", HTTPHost: "api.example.com"},
+		{DNSQuery: "db.internal"},
+		{TLSSNI: "login.example.com"},
+	})
+	if len(pbMeta) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(pbMeta))
+	}
+	if pbMeta[0].HttpMethod != "POST" || pbMeta[0].HttpHost != "api.example.com" {
+		t.Errorf("Unexpected HTTP metadata: %v", pbMeta[0])
+	}
+	if pbMeta[1].DnsQuery != "db.internal" || pbMeta[2].TlsSni != "login.example.com" {
+		t.Errorf("Unexpected DNS/TLS metadata: %v %v", pbMeta[1], pbMeta[2])
+	}
+}
+
+func TestThreatToProto(t *testing.T) {
+	threat := threatToProto(&agent.ThreatLog{
+		ThreatID:   1001,
+		ThreatName: "TCP SYN flood",
+		Severity:   "High",
+		ServerPort: 80,
+		IPProto:    6,
+		TcpFlags:   0x12,
+		PktLen:     1500,
+		PktSummary: "10.0.0.1:4321 > 10.0.0.2:80 [SYN,ACK]",
+		ReportedAt: time.Unix(1700000000, 0),
+	})
+
+	if threat.ThreatName != "TCP SYN flood" || threat.ServerPort != 80 {
+		t.Errorf("Unexpected threat: %v", threat)
+	}
+	if threat.TcpFlags != 0x12 || threat.PktLen != 1500 || threat.PktSummary == "" {
+		t.Errorf("Packet context lost: %v", threat)
+	}
+	if threat.ReportedAt != 1700000000 {
+		t.Errorf("Unexpected reported_at: %d", threat.ReportedAt)
+	}
+}
+
+func TestConnectionsToProtoRange(t *testing.T) {
+	conns := connectionsToProto([]*agent.Connection{
+		{ServerPort: 65535, Severity: 255, PolicyAction: uint8(agent.PolicyActionDeny)},
+		{ServerPort: 80, PolicyAction: 200},
+	}, 0)
+	if len(conns) != 1 {
+		t.Fatalf("Out of range policy action not skipped: %v", conns)
+	}
+	if conns[0].ServerPort != 65535 || conns[0].Severity != 255 || conns[0].PolicyAction != 2 {
+		t.Errorf("Unexpected connection: %v", conns[0])
+	}
+}
+
+func TestConnectionFieldMask(t *testing.T) {
+	full := &agent.Connection{
+		ClientWL: "web", ServerWL: "db", ClientIP: net.IPv4(10, 0, 0, 1), ServerIP: net.IPv4(10, 0, 0, 2),
+		ServerPort: 3306, IPProto: 6, Application: 2001, Bytes: 100, Sessions: 2,
+		FirstSeenAt: 1700000000, LastSeenAt: 1700000010, ThreatID: 7, Severity: 3,
+		PolicyAction: uint8(agent.PolicyActionDeny), PolicyId: 5, Ingress: true,
+		ExternalPeer: true, Scope: "global", Network: "bridge", Violates: 1,
+		L7: []agent.L7Meta{{HTTPMethod: "GET"}},
+	}
+
+	mask, err := ParseReportFields("application, threat")
+	if err != nil {
+		t.Fatalf("ParseReportFields: %v", err)
+	}
+	conn := connectionsToProto([]*agent.Connection{full}, mask)[0]
+	if conn.Application != 2001 || conn.ThreatId != 7 || conn.Severity != 3 {
+		t.Errorf("Included fields dropped: %v", conn)
+	}
+	if conn.FirstSeenAt != 0 || conn.LastSeenAt != 0 || conn.L7 != nil || conn.Scope != "" || conn.Network != "" ||
+		conn.Violates != 0 || conn.ExternalPeer {
+		t.Errorf("Masked fields reported: %v", conn)
+	}
+	if conn.ClientWl != "web" || conn.ServerPort != 3306 || conn.Bytes != 100 || conn.PolicyAction != 2 || conn.PolicyId != 5 || !conn.Ingress {
+		t.Errorf("Required fields dropped: %v", conn)
+	}
+
+	// Controller按零值接收省略的字段
+	c := cache.NewCache()
+	if err := c.UpdateAgentConnection("agent1", conn); err != nil {
+		t.Fatalf("Controller rejected masked connection: %v", err)
+	}
+	if got := c.ListConnections(); len(got) != 1 || got[0].Bytes != 100 || !got[0].FirstSeenAt.IsZero() {
+		t.Errorf("Unexpected ingested connections: %+v", got)
+	}
+
+	if mask, err := ParseReportFields("all"); err != nil || mask != 0 {
+		t.Errorf("all: %v %v", mask, err)
+	}
+	conn = connectionsToProto([]*agent.Connection{full}, 0)[0]
+	if conn.LastSeenAt != 1700000010 || len(conn.L7) != 1 || !conn.ExternalPeer {
+		t.Errorf("Unmasked fields dropped: %v", conn)
+	}
+	if mask, err := ParseReportFields("none"); err != nil || connectionsToProto([]*agent.Connection{full}, mask)[0].Application != 0 {
+		t.Errorf("none: %v %v", mask, err)
+	}
+	for _, s := range []string{"", "bytes", "application,"} {
+		if _, err := ParseReportFields(s); err == nil {
+			t.Errorf("%q accepted", s)
+		}
+	}
+}
+
+func TestConnectionsToProtoIP(t *testing.T) {
+	v4, v6 := net.IPv4(10, 0, 0, 1).To4(), net.ParseIP("2001:db8::2")
+	conns := connectionsToProto([]*agent.Connection{{ClientIP: v4, ServerIP: v6}}, 0)
+	if len(conns[0].ClientIp) != net.IPv6len || len(conns[0].ServerIp) != net.IPv6len {
+		t.Fatalf("Non-canonical encoding: %v %v", conns[0].ClientIp, conns[0].ServerIp)
+	}
+	if ip := pb.DecodeIP(conns[0].ClientIp); !ip.Equal(v4) || ip.To4() == nil {
+		t.Errorf("Unexpected client IP: %v", ip)
+	}
+	if ip := pb.DecodeIP(conns[0].ServerIp); !ip.Equal(v6) || ip.To4() != nil {
+		t.Errorf("Unexpected server IP: %v", ip)
+	}
+
+	threat := threatToProto(&agent.ThreatLog{ClientIP: v6, ServerIP: net.IPv4(10, 0, 0, 2)})
+	if pb.DecodeIP(threat.ClientIp).String() != "2001:db8::2" || pb.DecodeIP(threat.ServerIp).String() != "10.0.0.2" {
+		t.Errorf("Unexpected threat IPs: %v %v", threat.ClientIp, threat.ServerIp)
+	}
+}
+
+func TestRulesFromProtoRange(t *testing.T) {
+	rules := rulesFromProto([]*pb.PolicyRule{
+		{Id: 1, Action: uint32(agent.PolicyActionDeny)},
+		{Id: 2, Action: 257}, // 转换为uint8会回绕为allow
+	})
+	if len(rules) != 1 || rules[0].ID != 1 || rules[0].Action != agent.PolicyActionDeny {
+		t.Errorf("Unexpected rules: %+v", rules)
+	}
+}
+
+func TestRulesFromProtoApplications(t *testing.T) {
+	rules := rulesFromProto([]*pb.PolicyRule{
+		{Id: 1, From: "web", To: "db", Applications: []uint32{1001, 2001}, Action: uint32(agent.PolicyActionAllow)},
+	})
+	if len(rules) != 1 || len(rules[0].Applications) != 2 || rules[0].Applications[1] != 2001 {
+		t.Errorf("Applications not carried: %+v", rules)
+	}
+}
+
+func TestRunCommandsQueuesResults(t *testing.T) {
+	c := NewClient("", "agent1", "host1", "node-1", "test")
+	c.runCommands([]*pb.AgentCommand{{Id: "1", Type: agent.CommandGetDebug}})
+	if results := c.takeResults(); len(results) != 1 || results[0].Id != "1" || results[0].Success {
+		t.Fatalf("Unexpected results without handler: %v", results)
+	}
+
+	c.SetOnCommand(func(cmd *agent.Command) *agent.CommandResult {
+		return &agent.CommandResult{Success: cmd.ContainerID == "c1", Output: cmd.Type}
+	})
+	c.runCommands([]*pb.AgentCommand{
+		{Id: "2", Type: agent.CommandForceCapture, ContainerId: "c1"},
+		{Id: "3", Type: agent.CommandForceStop, ContainerId: "c2"},
+	})
+	results := c.takeResults()
+	if len(results) != 2 || results[0].Id != "2" || !results[0].Success || results[1].Id != "3" || results[1].Success || results[1].Output != agent.CommandForceStop {
+		t.Fatalf("Unexpected results: %v", results)
+	}
+	if results := c.takeResults(); len(results) != 0 {
+		t.Errorf("Results not cleared: %v", results)
+	}
+}
+
+func TestNegotiateHeartbeat(t *testing.T) {
+	for _, tc := range []struct {
+		interval time.Duration
+		timeout  uint32
+		want     time.Duration
+	}{
+		{10 * time.Second, 60, 10 * time.Second},
+		{30 * time.Second, 60, 20 * time.Second},
+		{10 * time.Second, 15, 5 * time.Second},
+		{10 * time.Second, 0, 10 * time.Second}, // Controller未告知超时
+	} {
+		got := negotiateHeartbeat(tc.interval, tc.timeout)
+		if got != tc.want {
+			t.Errorf("negotiateHeartbeat(%v, %d) = %v, want %v", tc.interval, tc.timeout, got, tc.want)
+		}
+		if tc.timeout > 0 && got*heartbeatTimeoutFraction > time.Duration(tc.timeout)*time.Second {
+			t.Errorf("Interval %v exceeds safe fraction of timeout %ds", got, tc.timeout)
+		}
+	}
+}
+
+func TestHeartbeatJitter(t *testing.T) {
+	const interval = 10 * time.Second
+	c := NewClient("", "agent1", "host1", "node-1", "test")
+	for _, tc := range []struct{ set, want float64 }{{0.2, 0.2}, {-1, 0}, {2, MaxHeartbeatJitter}} {
+		if c.SetHeartbeatJitter(tc.set); c.heartbeatJitter != tc.want {
+			t.Errorf("SetHeartbeatJitter(%v) = %v, want %v", tc.set, c.heartbeatJitter, tc.want)
+		}
+	}
+
+	if got := jitterInterval(interval, 0, 0.9); got != interval {
+		t.Errorf("Interval changed without jitter: %v", got)
+	}
+	if lo, hi := jitterInterval(interval, 0.2, 0), jitterInterval(interval, 0.2, 0.999999); lo != 8*time.Second || hi <= 11900*time.Millisecond || hi > 12*time.Second {
+		t.Errorf("Unexpected jitter bounds: %v %v", lo, hi)
+	}
+
+	// 连续间隔在抖动范围内随机变化
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 100; i++ {
+		got := jitterInterval(interval, 0.2, rand.Float64())
+		if got < 8*time.Second || got > 12*time.Second {
+			t.Fatalf("Interval %v outside jitter bounds", got)
+		}
+		seen[got] = true
+	}
+	if len(seen) < 50 {
+		t.Errorf("Intervals not varying: %d distinct of 100", len(seen))
+	}
+}
+
+// heartbeatRecorder 记录心跳请求的Controller客户端
+type heartbeatRecorder struct {
+	pb.ControllerServiceClient
+	reqs []*pb.HeartbeatRequest
+}
+
+func (r *heartbeatRecorder) Heartbeat(ctx context.Context, req *pb.HeartbeatRequest, opts ...grpc.CallOption) (*pb.HeartbeatResponse, error) {
+	r.reqs = append(r.reqs, req)
+	return &pb.HeartbeatResponse{}, nil
+}
+
+func TestHeartbeatReportsCaptures(t *testing.T) {
+	rec := &heartbeatRecorder{}
+	c := NewClient("", "agent1", "host1", "node-1", "test")
+	c.client, c.connected = rec, true
+
+	c.sendHeartbeat()
+	captured := []string{"c1", "c2"}
+	c.SetCaptureSource(func() []string { return captured })
+	c.sendHeartbeat()
+
+	if len(rec.reqs) != 2 {
+		t.Fatalf("Expected 2 heartbeats, got %d", len(rec.reqs))
+	}
+	if rec.reqs[0].CapturedContainers != nil {
+		t.Errorf("Captures reported without source: %v", rec.reqs[0].CapturedContainers)
+	}
+	if got := rec.reqs[1].CapturedContainers; !reflect.DeepEqual(got, captured) {
+		t.Errorf("Unexpected captures: %v", got)
+	}
+}
@@ -0,0 +1,103 @@
+// Package grpc 连接上报的可选字段
+package grpc
+
+import (
+	"fmt"
+	"strings"
+
+	pb "github.com/micro-segment/api/proto"
+)
+
+// ConnectionFieldMask 上报连接时省略的可选字段，0表示全部上报
+// 端点、IP、端口、协议、计数、策略动作和方向始终上报，Controller按零值处理省略的字段
+type ConnectionFieldMask uint32
+
+const (
+	ConnFieldApplication ConnectionFieldMask = 1 << iota // application
+	ConnFieldThreat                                      // threat_id、severity
+	ConnFieldTimestamps                                  // first_seen_at、last_seen_at
+	ConnFieldL7                                          // l7
+	ConnFieldNetwork                                     // scope、network
+	ConnFieldViolates                                    // violates
+	ConnFieldPeer                                        // external_peer、local_peer、internet_ingress
+)
+
+// connectionFields 可选字段名称，用于配置
+var connectionFields = []struct {
+	name  string
+	field ConnectionFieldMask
+}{
+	{"application", ConnFieldApplication},
+	{"threat", ConnFieldThreat},
+	{"timestamps", ConnFieldTimestamps},
+	{"l7", ConnFieldL7},
+	{"network", ConnFieldNetwork},
+	{"violates", ConnFieldViolates},
+	{"peer", ConnFieldPeer},
+}
+
+// ConnectionFieldNames 可选字段名称列表
+func ConnectionFieldNames() []string {
+	names := make([]string, 0, len(connectionFields))
+	for _, f := range connectionFields {
+		names = append(names, f.name)
+	}
+	return names
+}
+
+// ParseReportFields 由逗号分隔的要上报的可选字段生成省略掩码
+// "all"上报全部，"none"只上报必需字段
+func ParseReportFields(s string) (ConnectionFieldMask, error) {
+	var all ConnectionFieldMask
+	for _, f := range connectionFields {
+		all |= f.field
+	}
+	switch strings.TrimSpace(s) {
+	case "all":
+		return 0, nil
+	case "none":
+		return all, nil
+	}
+
+	include := ConnectionFieldMask(0)
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, f := range connectionFields {
+			if f.name == name {
+				include |= f.field
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown connection field %q, expected one of %s", name, strings.Join(ConnectionFieldNames(), ","))
+		}
+	}
+	return all &^ include, nil
+}
+
+// apply 清除省略的字段
+func (m ConnectionFieldMask) apply(conn *pb.Connection) {
+	if m&ConnFieldApplication != 0 {
+		conn.Application = 0
+	}
+	if m&ConnFieldThreat != 0 {
+		conn.ThreatId, conn.Severity = 0, 0
+	}
+	if m&ConnFieldTimestamps != 0 {
+		conn.FirstSeenAt, conn.LastSeenAt = 0, 0
+	}
+	if m&ConnFieldL7 != 0 {
+		conn.L7 = nil
+	}
+	if m&ConnFieldNetwork != 0 {
+		conn.Scope, conn.Network = "", ""
+	}
+	if m&ConnFieldViolates != 0 {
+		conn.Violates = 0
+	}
+	if m&ConnFieldPeer != 0 {
+		conn.ExternalPeer, conn.LocalPeer, conn.InternetIngress = false, false, false
+	}
+}
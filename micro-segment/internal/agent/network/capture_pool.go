@@ -0,0 +1,69 @@
+// Package network 容器捕获任务池
+package network
+
+import "sync"
+
+// defaultCaptureParallelism 默认同时执行的容器捕获任务数
+const defaultCaptureParallelism = 4
+
+// capturePool 有界并发的容器捕获任务池
+// 不同容器的任务并发执行，同时执行的任务数不超过并发上限；
+// 同一容器的任务按提交顺序串行执行，保证start先于stop
+type capturePool struct {
+	sem chan struct{} // 执行槽位，容量为并发上限
+
+	mutex  sync.Mutex
+	queues map[string][]func() // 各容器待执行的任务，存在即表示该容器有任务在处理
+	wg     sync.WaitGroup
+}
+
+// newCapturePool 创建任务池，parallelism小于1时使用默认值
+func newCapturePool(parallelism int) *capturePool {
+	if parallelism < 1 {
+		parallelism = defaultCaptureParallelism
+	}
+	return &capturePool{
+		sem:    make(chan struct{}, parallelism),
+		queues: make(map[string][]func()),
+	}
+}
+
+// submit 提交容器任务，不阻塞调用方
+func (p *capturePool) submit(key string, task func()) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	queue, busy := p.queues[key]
+	p.queues[key] = append(queue, task)
+	if !busy {
+		p.wg.Add(1)
+		go p.drain(key)
+	}
+}
+
+// drain 依次执行容器的任务，队列为空时退出
+func (p *capturePool) drain(key string) {
+	defer p.wg.Done()
+
+	for {
+		p.mutex.Lock()
+		queue := p.queues[key]
+		if len(queue) == 0 {
+			delete(p.queues, key)
+			p.mutex.Unlock()
+			return
+		}
+		task := queue[0]
+		p.queues[key] = queue[1:]
+		p.mutex.Unlock()
+
+		p.sem <- struct{}{}
+		task()
+		<-p.sem
+	}
+}
+
+// wait 等待已提交的任务全部完成
+func (p *capturePool) wait() {
+	p.wg.Wait()
+}
@@ -0,0 +1,90 @@
+// Package network 流量捕获方式选择
+package network
+
+import "fmt"
+
+// CaptureMethod 容器流量捕获方式
+type CaptureMethod string
+
+const (
+	// CaptureMethodTC 通过veth pair和TC mirror将流量镜像到nv-br
+	CaptureMethodTC CaptureMethod = "tc"
+	// CaptureMethodNFQueue 通过iptables NFQUEUE规则将流量送往DP
+	CaptureMethodNFQueue CaptureMethod = "nfqueue"
+)
+
+// ParseCaptureMethod 解析流量捕获方式
+func ParseCaptureMethod(s string) (CaptureMethod, error) {
+	switch m := CaptureMethod(s); m {
+	case CaptureMethodTC, CaptureMethodNFQueue:
+		return m, nil
+	default:
+		return "", fmt.Errorf("invalid capture method: %s", s)
+	}
+}
+
+// Capturer 容器流量捕获实现，由TCTrafficCapture和TrafficCapture实现
+type Capturer interface {
+	// StartContainerCapture 开始捕获容器流量
+	StartContainerCapture(containerID, containerName string, pid int) error
+	// StopContainerCapture 停止捕获容器流量并清理规则
+	StopContainerCapture(containerID string) error
+	// GetCapturedContainers 列出正在捕获的容器
+	GetCapturedContainers() []string
+	// GetContainerIPConfigs 获取已捕获容器各接口的IP配置
+	GetContainerIPConfigs(containerID string) map[string]*IPConfig
+	// CheckIPChanges 检查已捕获容器接口IP变化，返回发生变化的容器ID
+	CheckIPChanges() []string
+	// ValidateSetup 检查该捕获方式依赖的命令是否可用
+	ValidateSetup() error
+	// Cleanup 停止全部捕获并清理主机上的规则
+	Cleanup() error
+}
+
+// 两种捕获方式均实现Capturer
+var (
+	_ Capturer = (*TCTrafficCapture)(nil)
+	_ Capturer = (*TrafficCapture)(nil)
+)
+
+// pausableCapturer 支持暂停捕获而保留网络配置的实现
+type pausableCapturer interface {
+	PauseContainer(containerID string) error
+	ResumeContainer(containerID string) error
+}
+
+// capturerConstructors 各捕获方式的构造函数，测试时可替换
+var capturerConstructors = map[CaptureMethod]func() Capturer{
+	CaptureMethodTC:      func() Capturer { return NewTCTrafficCapture() },
+	CaptureMethodNFQueue: func() Capturer { return NewTrafficCapture() },
+}
+
+// newCapturer 创建指定方式的流量捕获实现
+func newCapturer(method CaptureMethod) (Capturer, error) {
+	newFn, ok := capturerConstructors[method]
+	if !ok {
+		return nil, fmt.Errorf("invalid capture method: %s", method)
+	}
+	return newFn(), nil
+}
+
+// commandStatsSource 统计所执行命令的实现
+type commandStatsSource interface {
+	CommandStats() map[string]CommandStats
+}
+
+// commandCheck 捕获方式依赖的命令及其检查方式
+type commandCheck struct {
+	name string
+	cmd  string
+}
+
+// checkCommands 依次执行检查命令，返回第一个不可用命令的错误
+func checkCommands(executor Executor, checks []commandCheck) error {
+	for _, check := range checks {
+		if _, err := executor.Run(check.cmd); err != nil {
+			return fmt.Errorf("%s command not available: %v", check.name, err)
+		}
+	}
+	return nil
+}
@@ -5,7 +5,13 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/docker/api/types"
@@ -17,25 +23,57 @@ import (
 
 // ContainerMonitor Docker容器监控器
 type ContainerMonitor struct {
-	client    *client.Client
-	tcCapture *TCTrafficCapture
-	ctx       context.Context
-	cancel    context.CancelFunc
+	client  *client.Client
+	capture Capturer
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	// 容器事件回调，流量捕获处理完成后调用
+	onEvent func(*ContainerEvent)
+
+	// 已启动容器的最近一次事件，IP变化时据此重新上报
+	eventsMutex sync.Mutex
+	events      map[string]*ContainerEvent
+
+	// 捕获启停任务池，不同容器并发处理，同一容器按事件顺序处理
+	pool *capturePool
+
+	// 推导服务名称的标签，按优先级排列，为空时使用DefaultServiceLabels
+	serviceLabels []string
+
+	// PID查询，测试时可替换
+	inspectPid    func(containerID string) (int, error) // 通过Docker inspect查询PID
+	procPid       func(containerID string) (int, error) // 通过/proc下的cgroup查找PID
+	pidRetryDelay time.Duration                         // PID查询重试间隔
 }
 
+// ipCheckInterval 检查已捕获容器接口IP变化的周期
+const ipCheckInterval = 30 * time.Second
+
+// pidRetryMax 容器启动时查询PID的最大尝试次数
+const pidRetryMax = 5
+
+// defaultPidRetryDelay 默认PID查询重试间隔
+const defaultPidRetryDelay = 500 * time.Millisecond
+
 // ContainerEvent 容器事件
 type ContainerEvent struct {
-	Type        string            // start, stop, die
-	ContainerID string            // 容器ID
-	Name        string            // 容器名称
-	Image       string            // 镜像名称
-	Labels      map[string]string // 标签
-	Pid         int               // 容器PID
+	Type        string               // start, stop, die, update
+	ContainerID string               // 容器ID
+	Name        string               // 容器名称
+	Service     string               // 服务名称，由标签或镜像名推导
+	Image       string               // 镜像名称
+	Labels      map[string]string    // 标签
+	Pid         int                  // 容器PID
+	Pod         *K8sPodInfo          // K8s Pod信息，非K8s容器为nil
+	State       string               // 容器状态，见containerState
+	Health      string               // 健康检查状态，未配置时为空
+	Addrs       map[string]*IPConfig // 接口IP配置，来自流量捕获
 }
 
 // NewContainerMonitor 创建容器监控器
-// 初始化Docker客户端和TC流量捕获器连接
-func NewContainerMonitor(tcCapture *TCTrafficCapture) (*ContainerMonitor, error) {
+// 初始化Docker客户端和流量捕获实现连接
+func NewContainerMonitor(capture Capturer) (*ContainerMonitor, error) {
 	// 连接Docker daemon
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
@@ -45,15 +83,34 @@ func NewContainerMonitor(tcCapture *TCTrafficCapture) (*ContainerMonitor, error)
 	ctx, cancel := context.WithCancel(context.Background())
 	
 	monitor := &ContainerMonitor{
-		client:    cli,
-		tcCapture: tcCapture,
-		ctx:       ctx,
-		cancel:    cancel,
+		client:        cli,
+		capture:       capture,
+		ctx:           ctx,
+		cancel:        cancel,
+		events:        make(map[string]*ContainerEvent),
+		pool:          newCapturePool(defaultCaptureParallelism),
+		pidRetryDelay: defaultPidRetryDelay,
+	}
+	monitor.inspectPid = monitor.dockerPid
+	monitor.procPid = func(containerID string) (int, error) {
+		return findPidByCgroup("/proc", containerID)
 	}
 	
 	return monitor, nil
 }
 
+// SetOnContainerEvent 设置容器事件回调
+// 需在Start之前设置，以便收到现有容器的事件
+func (cm *ContainerMonitor) SetOnContainerEvent(cb func(*ContainerEvent)) {
+	cm.onEvent = cb
+}
+
+// SetCaptureParallelism 设置同时执行的捕获启停任务数上限
+// 需在Start之前设置，小于1时使用默认值
+func (cm *ContainerMonitor) SetCaptureParallelism(n int) {
+	cm.pool = newCapturePool(n)
+}
+
 // Start 启动容器监控
 // 扫描现有容器并启动事件监听
 func (cm *ContainerMonitor) Start() error {
@@ -67,15 +124,67 @@ func (cm *ContainerMonitor) Start() error {
 	// 监控容器事件
 	go cm.monitorContainerEvents()
 	
+	// 检查接口IP变化
+	go cm.ipCheckLoop()
+	
 	return nil
 }
 
+// ipCheckLoop 周期检查已捕获容器的接口IP变化
+func (cm *ContainerMonitor) ipCheckLoop() {
+	ticker := time.NewTicker(ipCheckInterval)
+	defer ticker.Stop()
+	
+	for {
+		select {
+		case <-ticker.C:
+			cm.checkIPChanges()
+		case <-cm.ctx.Done():
+			return
+		}
+	}
+}
+
+// checkIPChanges 检查接口IP变化并以update事件重新上报
+// 只刷新捕获记录中的IP配置，veth pair和TC规则保持不变；
+// 重新上报提交到任务池，与同一容器的start/stop按顺序执行
+func (cm *ContainerMonitor) checkIPChanges() {
+	for _, id := range cm.capture.CheckIPChanges() {
+		cm.pool.submit(id, func() {
+			cm.reportIPChange(id)
+		})
+	}
+}
+
+// reportIPChange 以update事件重新上报容器的IP配置，容器已停止时忽略
+func (cm *ContainerMonitor) reportIPChange(id string) {
+	cm.eventsMutex.Lock()
+	last, ok := cm.events[id]
+	cm.eventsMutex.Unlock()
+	if !ok {
+		return
+	}
+	
+	event := *last
+	event.Type = "update"
+	event.Addrs = cm.capture.GetContainerIPConfigs(id)
+	
+	cm.eventsMutex.Lock()
+	cm.events[id] = &event
+	cm.eventsMutex.Unlock()
+	
+	if cm.onEvent != nil {
+		cm.onEvent(&event)
+	}
+}
+
 // Stop 停止容器监控
 // 取消监听并关闭Docker客户端连接
 func (cm *ContainerMonitor) Stop() error {
 	log.Info("Stopping Docker container monitor")
 	
 	cm.cancel()
+	cm.pool.wait()
 	
 	if cm.client != nil {
 		return cm.client.Close()
@@ -111,10 +220,14 @@ func (cm *ContainerMonitor) monitorExistingContainers() error {
 			event := &ContainerEvent{
 				Type:        "start",
 				ContainerID: container.ID,
-				Name:        strings.TrimPrefix(container.Names[0], "/"),
+				Name:        cm.containerName(container.ID, container.Names),
+				Service:     cm.serviceName(container.Labels, container.Image),
 				Image:       container.Image,
 				Labels:      container.Labels,
 				Pid:         inspect.State.Pid,
+				State:       containerState(inspect.State),
+				Health:      containerHealth(inspect.State),
+				Pod:         parseK8sLabels(container.Labels),
 			}
 			
 			cm.handleContainerEvent(event)
@@ -168,7 +281,7 @@ func (cm *ContainerMonitor) monitorContainerEvents() {
 func (cm *ContainerMonitor) processDockerEvent(event events.Message) {
 	log.WithFields(log.Fields{
 		"action":    event.Action,
-		"container": event.Actor.ID[:12],
+		"container": shortID(event.Actor.ID),
 		"image":     event.Actor.Attributes["image"],
 	}).Debug("Docker event received")
 	
@@ -187,44 +300,142 @@ func (cm *ContainerMonitor) processDockerEvent(event events.Message) {
 	containerEvent := &ContainerEvent{
 		Type:        string(event.Action),
 		ContainerID: event.Actor.ID,
-		Name:        strings.TrimPrefix(inspect.Name, "/"),
+		Name:        cm.containerName(event.Actor.ID, []string{inspect.Name}),
+		Service:     cm.serviceName(inspect.Config.Labels, inspect.Config.Image),
 		Image:       inspect.Config.Image,
 		Labels:      inspect.Config.Labels,
 		Pid:         inspect.State.Pid,
+		State:       containerState(inspect.State),
+		Health:      containerHealth(inspect.State),
+		Pod:         parseK8sLabels(inspect.Config.Labels),
 	}
 	
 	cm.handleContainerEvent(containerEvent)
 }
 
 // handleContainerEvent 处理容器事件
-// 根据容器生命周期事件启动或停止流量捕获
+// 提交到任务池异步处理，不阻塞事件循环
 func (cm *ContainerMonitor) handleContainerEvent(event *ContainerEvent) {
+	cm.pool.submit(event.ContainerID, func() {
+		cm.applyContainerEvent(event)
+	})
+}
+
+// applyContainerEvent 执行容器事件
+// 根据容器生命周期事件启动或停止流量捕获
+func (cm *ContainerMonitor) applyContainerEvent(event *ContainerEvent) {
 	log.WithFields(log.Fields{
 		"action":    event.Type,
 		"container": event.Name,
-		"id":        event.ContainerID[:12],
+		"id":        shortID(event.ContainerID),
 		"pid":       event.Pid,
 	}).Info("Processing container event")
 	
 	switch event.Type {
 	case "start":
 		// 容器启动，开始流量捕获
+		if event.Pid <= 0 {
+			event.Pid = cm.resolvePid(event.ContainerID)
+		}
 		if event.Pid > 0 {
-			if err := cm.tcCapture.StartContainerCapture(event.ContainerID, event.Name, event.Pid); err != nil {
-				log.WithError(err).WithField("container", event.Name).Error("Failed to start TC traffic capture")
+			if err := cm.capture.StartContainerCapture(event.ContainerID, event.Name, event.Pid); err != nil {
+				log.WithError(err).WithField("container", event.Name).Error("Failed to start traffic capture")
 			}
 		} else {
-			log.WithField("container", event.Name).Warn("Container has no PID, skipping TC traffic capture")
+			log.WithField("container", event.Name).Warn("Container has no PID, skipping traffic capture")
 		}
+		event.Addrs = cm.capture.GetContainerIPConfigs(event.ContainerID)
+		
+		cm.eventsMutex.Lock()
+		cm.events[event.ContainerID] = event
+		cm.eventsMutex.Unlock()
 		
 	case "stop", "die":
 		// 容器停止，停止流量捕获
-		if err := cm.tcCapture.StopContainerCapture(event.ContainerID); err != nil {
-			log.WithError(err).WithField("container", event.Name).Warn("Failed to stop TC traffic capture")
+		if err := cm.capture.StopContainerCapture(event.ContainerID); err != nil {
+			log.WithError(err).WithField("container", event.Name).Warn("Failed to stop traffic capture")
 		}
+		
+		cm.eventsMutex.Lock()
+		delete(cm.events, event.ContainerID)
+		cm.eventsMutex.Unlock()
+	}
+
+	if cm.onEvent != nil {
+		cm.onEvent(event)
 	}
 }
 
+// resolvePid 查询容器PID
+// inspect返回的PID为0时（重启中或暂停的容器）回退到/proc下的cgroup扫描，
+// 多次重试后仍未找到返回0
+func (cm *ContainerMonitor) resolvePid(containerID string) int {
+	for attempt := 1; attempt <= pidRetryMax; attempt++ {
+		for _, lookup := range []func(string) (int, error){cm.inspectPid, cm.procPid} {
+			if lookup == nil {
+				continue
+			}
+			pid, err := lookup(containerID)
+			if err != nil {
+				log.WithError(err).WithField("container", containerID).Debug("Container PID lookup failed")
+				continue
+			}
+			if pid > 0 {
+				return pid
+			}
+		}
+
+		if attempt == pidRetryMax {
+			break
+		}
+		select {
+		case <-time.After(cm.pidRetryDelay):
+		case <-cm.ctx.Done():
+			return 0
+		}
+	}
+	return 0
+}
+
+// dockerPid 通过Docker inspect查询容器PID
+func (cm *ContainerMonitor) dockerPid(containerID string) (int, error) {
+	inspect, err := cm.client.ContainerInspect(cm.ctx, containerID)
+	if err != nil {
+		return 0, err
+	}
+	return inspect.State.Pid, nil
+}
+
+// findPidByCgroup 扫描procRoot下各进程的cgroup文件，返回属于容器的最小PID
+// 容器的首个进程PID最小，即容器init进程，其网络命名空间即容器网络命名空间
+func findPidByCgroup(procRoot, containerID string) (int, error) {
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return 0, err
+	}
+
+	var pids []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid <= 0 {
+			continue
+		}
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+
+	for _, pid := range pids {
+		data, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "cgroup"))
+		if err != nil {
+			continue // 进程已退出
+		}
+		if strings.Contains(string(data), containerID) {
+			return pid, nil
+		}
+	}
+	return 0, nil
+}
+
 // shouldSkipContainer 判断是否应该跳过容器
 // 过滤系统容器、特权容器和主机网络模式容器
 func (cm *ContainerMonitor) shouldSkipContainer(inspect *types.ContainerJSON) bool {
@@ -277,13 +488,41 @@ func (cm *ContainerMonitor) GetContainerInfo(containerID string) (*ContainerEven
 	return &ContainerEvent{
 		Type:        "info",
 		ContainerID: containerID,
-		Name:        strings.TrimPrefix(inspect.Name, "/"),
+		Name:        cm.containerName(containerID, []string{inspect.Name}),
+		Service:     cm.serviceName(inspect.Config.Labels, inspect.Config.Image),
 		Image:       inspect.Config.Image,
 		Labels:      inspect.Config.Labels,
 		Pid:         inspect.State.Pid,
+		State:       containerState(inspect.State),
+		Health:      containerHealth(inspect.State),
+		Pod:         parseK8sLabels(inspect.Config.Labels),
 	}, nil
 }
 
+// ListNetworkSubnets 列出Docker网络的子网
+// 返回所有Docker网络IPAM配置中的子网
+func (cm *ContainerMonitor) ListNetworkSubnets() ([]net.IPNet, error) {
+	networks, err := cm.client.NetworkList(cm.ctx, types.NetworkListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var subnets []net.IPNet
+	for _, nw := range networks {
+		for _, cfg := range nw.IPAM.Config {
+			if cfg.Subnet == "" {
+				continue
+			}
+			_, ipnet, err := net.ParseCIDR(cfg.Subnet)
+			if err != nil {
+				continue
+			}
+			subnets = append(subnets, *ipnet)
+		}
+	}
+	return subnets, nil
+}
+
 // ListRunningContainers 列出正在运行的容器
 // 返回当前所有运行中的非系统容器列表
 func (cm *ContainerMonitor) ListRunningContainers() ([]*ContainerEvent, error) {
@@ -307,10 +546,14 @@ func (cm *ContainerMonitor) ListRunningContainers() ([]*ContainerEvent, error) {
 			event := &ContainerEvent{
 				Type:        "running",
 				ContainerID: container.ID,
-				Name:        strings.TrimPrefix(container.Names[0], "/"),
+				Name:        cm.containerName(container.ID, container.Names),
+				Service:     cm.serviceName(container.Labels, container.Image),
 				Image:       container.Image,
 				Labels:      container.Labels,
 				Pid:         inspect.State.Pid,
+				State:       containerState(inspect.State),
+				Health:      containerHealth(inspect.State),
+				Pod:         parseK8sLabels(container.Labels),
 			}
 			
 			events = append(events, event)
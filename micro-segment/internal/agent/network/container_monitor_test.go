@@ -0,0 +1,448 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// newTestMonitor 创建不连接Docker和bridge的监控器，IP查询结果由ips提供
+func newTestMonitor(ips map[string]string) (*ContainerMonitor, *TCTrafficCapture) {
+	tc := &TCTrafficCapture{
+		containers: make(map[string]*TCContainerInfo),
+		prefs:      make(map[uint]bool),
+		indexes:    make(map[uint]bool),
+		portMap:    make(map[string]*TCPortInfo),
+	}
+	tc.ipConfigFn = func(pid int, iface string) (*IPConfig, error) {
+		ip, ok := ips[iface]
+		if !ok {
+			return nil, fmt.Errorf("no IP address found")
+		}
+		return &IPConfig{IPAddr: ip, Gateway: "172.17.0.1"}, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cm := &ContainerMonitor{
+		capture: tc,
+		ctx:     ctx,
+		cancel:  cancel,
+		events:  make(map[string]*ContainerEvent),
+		pool:    newCapturePool(defaultCaptureParallelism),
+	}
+	return cm, tc
+}
+
+func TestIPChangeReReport(t *testing.T) {
+	ips := map[string]string{"eth0": "172.17.0.2/16"}
+	cm, tc := newTestMonitor(ips)
+
+	const id = "3f4e5d6c7b8a9f0e1d2c3b4a"
+	vethPair := &VethPairInfo{
+		OriginalName: "eth0",
+		InternalName: "nv-in-eth0",
+		ExternalName: "nv-ex-eth0",
+		Index:        7,
+		IPConfig:     &IPConfig{IPAddr: "172.17.0.2/16", Gateway: "172.17.0.1"},
+	}
+	tc.containers[id] = &TCContainerInfo{
+		ID: id, Name: "web", Pid: 1234,
+		VethPairs: map[string]*VethPairInfo{"eth0": vethPair},
+	}
+	cm.events[id] = &ContainerEvent{
+		Type: "start", ContainerID: id, Name: "web", Pid: 1234,
+		Pod:   &K8sPodInfo{PodName: "web-0", Namespace: "shop"},
+		Addrs: tc.GetContainerIPConfigs(id),
+	}
+
+	var reported []*ContainerEvent
+	cm.SetOnContainerEvent(func(ev *ContainerEvent) { reported = append(reported, ev) })
+
+	// IP未变化时不上报
+	cm.checkIPChanges()
+	cm.pool.wait()
+	if len(reported) != 0 {
+		t.Fatalf("Unexpected re-report: %+v", reported)
+	}
+
+	// 模拟DHCP续租后IP变化
+	ips["eth0"] = "172.17.0.9/16"
+	cm.checkIPChanges()
+	cm.pool.wait()
+	if len(reported) != 1 {
+		t.Fatalf("Expected 1 re-report, got %d", len(reported))
+	}
+	ev := reported[0]
+	if ev.Type != "update" || ev.Addrs["eth0"].IPAddr != "172.17.0.9/16" {
+		t.Errorf("Unexpected event: %+v", ev)
+	}
+	if ev.Pod == nil || ev.Pod.Namespace != "shop" {
+		t.Errorf("Pod metadata lost on re-report: %+v", ev.Pod)
+	}
+
+	// 只刷新IP配置，veth pair保持不变
+	if tc.containers[id].VethPairs["eth0"] != vethPair || vethPair.Index != 7 {
+		t.Errorf("Veth pair rebuilt")
+	}
+	if vethPair.IPConfig.IPAddr != "172.17.0.9/16" {
+		t.Errorf("Stored IP config not refreshed: %+v", vethPair.IPConfig)
+	}
+
+	cm.checkIPChanges()
+	cm.pool.wait()
+	if len(reported) != 1 {
+		t.Errorf("Re-reported without further change")
+	}
+}
+
+// ipChangeCapturer 报告指定容器IP变化的流量捕获实现
+type ipChangeCapturer struct {
+	fakeCapturer
+	changed []string
+}
+
+func (c *ipChangeCapturer) CheckIPChanges() []string { return c.changed }
+
+func TestIPChangeAfterStop(t *testing.T) {
+	const id = "3f4e5d6c7b8a9f0e1d2c3b4a"
+	capture := &ipChangeCapturer{
+		fakeCapturer: fakeCapturer{addrs: map[string]*IPConfig{"eth0": {IPAddr: "172.17.0.9/16"}}},
+		changed:      []string{id},
+	}
+	cm, _ := newTestMonitor(nil)
+	cm.capture = capture
+	cm.events[id] = &ContainerEvent{Type: "start", ContainerID: id, Name: "web", Pid: 1234}
+
+	var mutex sync.Mutex
+	var reported []string
+	cm.SetOnContainerEvent(func(ev *ContainerEvent) {
+		mutex.Lock()
+		reported = append(reported, ev.Type)
+		mutex.Unlock()
+	})
+
+	// stop已在任务池中排队时检测到IP变化
+	release := make(chan struct{})
+	cm.pool.submit(id, func() { <-release })
+	cm.handleContainerEvent(&ContainerEvent{Type: "stop", ContainerID: id, Name: "web"})
+	cm.checkIPChanges()
+	close(release)
+	cm.pool.wait()
+
+	if _, ok := cm.events[id]; ok {
+		t.Errorf("Stopped container tracked again")
+	}
+	if len(reported) != 1 || reported[0] != "stop" {
+		t.Errorf("Unexpected events after stop: %v", reported)
+	}
+}
+
+func TestResolvePidRetry(t *testing.T) {
+	cm, _ := newTestMonitor(nil)
+	cm.pidRetryDelay = 0
+
+	calls := 0
+	cm.inspectPid = func(containerID string) (int, error) {
+		calls++
+		if calls < 3 {
+			return 0, nil // 容器重启中
+		}
+		return 4321, nil
+	}
+	if pid := cm.resolvePid("abc"); pid != 4321 || calls != 3 {
+		t.Errorf("Expected pid 4321 after 3 calls, got %d after %d", pid, calls)
+	}
+
+	// inspect始终返回0时回退到cgroup扫描
+	cm.inspectPid = func(containerID string) (int, error) { return 0, nil }
+	cm.procPid = func(containerID string) (int, error) { return 99, nil }
+	if pid := cm.resolvePid("abc"); pid != 99 {
+		t.Errorf("Expected fallback pid 99, got %d", pid)
+	}
+
+	// 重试次数用尽后放弃
+	calls = 0
+	cm.inspectPid = func(containerID string) (int, error) {
+		calls++
+		return 0, fmt.Errorf("no such container")
+	}
+	cm.procPid = nil
+	if pid := cm.resolvePid("abc"); pid != 0 || calls != pidRetryMax {
+		t.Errorf("Expected give up after %d calls, got pid %d after %d", pidRetryMax, pid, calls)
+	}
+}
+
+func TestFindPidByCgroup(t *testing.T) {
+	const id = "3f4e5d6c7b8a9f0e1d2c3b4a"
+	root := t.TempDir()
+	procs := map[string]string{
+		"1":    "0::/init.scope\n",
+		"812":  "0::/system.slice/docker-" + id + ".scope\n",
+		"95":   "0::/system.slice/docker-" + id + ".scope\n",
+		"self": "0::/user.slice\n",
+	}
+	for pid, cgroup := range procs {
+		dir := filepath.Join(root, pid)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "cgroup"), []byte(cgroup), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if pid, err := findPidByCgroup(root, id); err != nil || pid != 95 {
+		t.Errorf("Expected pid 95, got %d (%v)", pid, err)
+	}
+	if pid, err := findPidByCgroup(root, "unknown"); err != nil || pid != 0 {
+		t.Errorf("Expected no pid, got %d (%v)", pid, err)
+	}
+}
+
+func TestCanonicalName(t *testing.T) {
+	const id = "3f4e5d6c7b8a9f0e1d2c3b4a"
+	cases := []struct {
+		names []string
+		want  string
+	}{
+		{[]string{"/web"}, "web"},
+		{[]string{"/web", "/api/web-alias"}, "web"},
+		{[]string{"/api/db", "/db"}, "db"},
+		{[]string{"/zeta", "/alpha"}, "alpha"},
+		{[]string{"/api/cache"}, "cache"},
+		{[]string{"web"}, "web"},
+		{[]string{"/", ""}, "3f4e5d6c7b8a"},
+		{nil, "3f4e5d6c7b8a"},
+	}
+	for _, c := range cases {
+		if got := canonicalName(c.names, id); got != c.want {
+			t.Errorf("canonicalName(%v) = %s, want %s", c.names, got, c.want)
+		}
+	}
+	if got := canonicalName(nil, "abc"); got != "abc" {
+		t.Errorf("Short container ID not kept: %s", got)
+	}
+}
+
+func TestContainerNameDuplicates(t *testing.T) {
+	cm, _ := newTestMonitor(nil)
+	const first = "1111111111111111aaaa"
+	const second = "2222222222222222bbbb"
+
+	if name := cm.containerName(first, []string{"/web"}); name != "web" {
+		t.Fatalf("Unexpected name: %s", name)
+	}
+	cm.events[first] = &ContainerEvent{ContainerID: first, Name: "web"}
+
+	// 同名容器追加短ID后缀
+	if name := cm.containerName(second, []string{"/web"}); name != "web-222222222222" {
+		t.Errorf("Duplicate not disambiguated: %s", name)
+	}
+	cm.events[second] = &ContainerEvent{ContainerID: second, Name: "web-222222222222"}
+
+	// 已跟踪容器沿用之前分配的名称
+	if name := cm.containerName(second, []string{"/web"}); name != "web-222222222222" {
+		t.Errorf("Assigned name not stable: %s", name)
+	}
+	if name := cm.containerName(first, []string{"/web"}); name != "web" {
+		t.Errorf("Original name changed: %s", name)
+	}
+
+	// 原容器退出后名称可重新使用
+	delete(cm.events, first)
+	if name := cm.containerName("3333333333333333cccc", []string{"/web"}); name != "web" {
+		t.Errorf("Released name not reused: %s", name)
+	}
+}
+
+func TestCapturePoolConcurrency(t *testing.T) {
+	const parallelism, containers, perContainer = 3, 20, 5
+	p := newCapturePool(parallelism)
+
+	var running, peak atomic.Int32
+	var mutex sync.Mutex
+	order := make(map[string][]int)
+	for i := 0; i < perContainer; i++ {
+		for c := 0; c < containers; c++ {
+			key, seq := fmt.Sprintf("c%d", c), i
+			p.submit(key, func() {
+				n := running.Add(1)
+				for {
+					old := peak.Load()
+					if n <= old || peak.CompareAndSwap(old, n) {
+						break
+					}
+				}
+				time.Sleep(time.Millisecond)
+				mutex.Lock()
+				order[key] = append(order[key], seq)
+				mutex.Unlock()
+				running.Add(-1)
+			})
+		}
+	}
+	p.wait()
+
+	if n := peak.Load(); n > parallelism || n < 2 {
+		t.Errorf("Unexpected peak concurrency: %d", n)
+	}
+	for key, seqs := range order {
+		for i, seq := range seqs {
+			if seq != i {
+				t.Fatalf("%s: out of order %v", key, seqs)
+			}
+		}
+	}
+	if len(order) != containers {
+		t.Errorf("Unexpected containers: %d", len(order))
+	}
+}
+
+func TestContainerEventOrdering(t *testing.T) {
+	cm, _ := newTestMonitor(nil)
+	cm.SetCaptureParallelism(2)
+	cm.inspectPid = func(string) (int, error) { return 0, nil }
+
+	var mutex sync.Mutex
+	var seen []string
+	types := make(map[string][]string)
+	cm.SetOnContainerEvent(func(ev *ContainerEvent) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		seen = append(seen, ev.ContainerID)
+		types[ev.ContainerID] = append(types[ev.ContainerID], ev.Type)
+	})
+
+	const n = 10
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("container-%d", i)
+		cm.handleContainerEvent(&ContainerEvent{Type: "start", ContainerID: id, Name: id})
+		cm.handleContainerEvent(&ContainerEvent{Type: "stop", ContainerID: id, Name: id})
+	}
+	cm.pool.wait()
+
+	if len(seen) != 2*n {
+		t.Fatalf("Unexpected event count: %d", len(seen))
+	}
+	for id, evs := range types {
+		if len(evs) != 2 || evs[0] != "start" || evs[1] != "stop" {
+			t.Errorf("%s: unexpected order %v", id, evs)
+		}
+	}
+	if len(cm.events) != 0 {
+		t.Errorf("Stopped containers still tracked: %d", len(cm.events))
+	}
+}
+
+func TestSlowCaptureSetupDoesNotBlockOthers(t *testing.T) {
+	cm, _ := newTestMonitor(nil)
+	release := make(chan struct{})
+	cm.inspectPid = func(id string) (int, error) {
+		if id == "slow" {
+			<-release
+		}
+		return 0, nil
+	}
+	done := make(chan string, 3)
+	cm.SetOnContainerEvent(func(ev *ContainerEvent) { done <- ev.ContainerID + " " + ev.Type })
+
+	// 事件循环提交后立即返回，慢容器的启动阻塞在任务池中
+	cm.handleContainerEvent(&ContainerEvent{Type: "start", ContainerID: "slow", Name: "slow"})
+	cm.handleContainerEvent(&ContainerEvent{Type: "stop", ContainerID: "slow", Name: "slow"})
+	cm.handleContainerEvent(&ContainerEvent{Type: "start", ContainerID: "fast", Name: "fast"})
+	select {
+	case got := <-done:
+		if got != "fast start" {
+			t.Fatalf("Unexpected first event: %s", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Fast container delayed by slow capture setup")
+	}
+
+	close(release)
+	cm.pool.wait()
+	if first, second := <-done, <-done; first != "slow start" || second != "slow stop" {
+		t.Errorf("Slow container events out of order: %s, %s", first, second)
+	}
+}
+
+func TestContainerState(t *testing.T) {
+	tests := []struct {
+		name   string
+		state  *types.ContainerState
+		want   string
+		health string
+	}{
+		{"nil", nil, "unknown", ""},
+		{"running", &types.ContainerState{Status: "running", Running: true, Pid: 42}, "running", ""},
+		{"paused", &types.ContainerState{Status: "paused", Running: true, Paused: true}, "paused", ""},
+		{"restarting", &types.ContainerState{Status: "restarting", Running: true, Restarting: true}, "restarting", ""},
+		{"created", &types.ContainerState{Status: "created"}, "created", ""},
+		{"exited", &types.ContainerState{Status: "exited", ExitCode: 137, OOMKilled: true}, "exited", ""},
+		{"dead", &types.ContainerState{Status: "dead", Dead: true}, "dead", ""},
+		{"removing", &types.ContainerState{Status: "removing"}, "removing", ""},
+		{"unrecognized", &types.ContainerState{Status: "bogus"}, "unknown", ""},
+		{"healthy", &types.ContainerState{Status: "running", Running: true,
+			Health: &types.Health{Status: types.Healthy}}, "running", "healthy"},
+		{"starting", &types.ContainerState{Status: "running", Running: true,
+			Health: &types.Health{Status: types.Starting}}, "running", "starting"},
+		{"unhealthy", &types.ContainerState{Status: "running", Running: true,
+			Health: &types.Health{Status: types.Unhealthy}}, "running", "unhealthy"},
+		{"no healthcheck", &types.ContainerState{Status: "running", Running: true,
+			Health: &types.Health{Status: types.NoHealthcheck}}, "running", ""},
+	}
+	for _, tt := range tests {
+		if got := containerState(tt.state); got != tt.want {
+			t.Errorf("%s: state %q, want %q", tt.name, got, tt.want)
+		}
+		if got := containerHealth(tt.state); got != tt.health {
+			t.Errorf("%s: health %q, want %q", tt.name, got, tt.health)
+		}
+	}
+}
+
+func TestMonitorWithCapturer(t *testing.T) {
+	capture := &fakeCapturer{addrs: map[string]*IPConfig{"eth0": {IPAddr: "172.17.0.3/16"}}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cm := &ContainerMonitor{
+		capture: capture,
+		ctx:     ctx,
+		cancel:  cancel,
+		events:  make(map[string]*ContainerEvent),
+		pool:    newCapturePool(defaultCaptureParallelism),
+	}
+	var reported []*ContainerEvent
+	cm.SetOnContainerEvent(func(e *ContainerEvent) { reported = append(reported, e) })
+
+	cm.applyContainerEvent(&ContainerEvent{Type: "start", ContainerID: "c1", Name: "web", Pid: 100})
+	if len(capture.started) != 1 || capture.started[0] != "c1" {
+		t.Fatalf("Capture not started: %v", capture.started)
+	}
+	if len(reported) != 1 || reported[0].Addrs["eth0"].IPAddr != "172.17.0.3/16" {
+		t.Fatalf("Unexpected reported events: %+v", reported)
+	}
+
+	// 查不到PID时不启动捕获，仍上报事件
+	cm.pidRetryDelay = 0
+	cm.inspectPid = func(string) (int, error) { return 0, nil }
+	cm.applyContainerEvent(&ContainerEvent{Type: "start", ContainerID: "c2", Name: "db"})
+	if len(capture.started) != 1 || len(reported) != 2 {
+		t.Errorf("Unexpected capture without PID: %v", capture.started)
+	}
+
+	cm.applyContainerEvent(&ContainerEvent{Type: "die", ContainerID: "c1", Name: "web"})
+	if len(capture.stopped) != 1 || capture.stopped[0] != "c1" {
+		t.Errorf("Capture not stopped: %v", capture.stopped)
+	}
+	if _, ok := cm.events["c1"]; ok {
+		t.Errorf("Stopped container still tracked")
+	}
+}
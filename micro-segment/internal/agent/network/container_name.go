@@ -0,0 +1,65 @@
+// Package network 容器名称规范化
+package network
+
+import (
+	"sort"
+	"strings"
+)
+
+// shortIDLen 容器短ID长度，与docker ps一致
+const shortIDLen = 12
+
+// shortID 返回容器短ID
+func shortID(containerID string) string {
+	if len(containerID) > shortIDLen {
+		return containerID[:shortIDLen]
+	}
+	return containerID
+}
+
+// canonicalName 从Docker返回的名称列表中选出稳定的规范名称
+// Docker名称带前导斜杠，--link产生的别名形如/other/alias，
+// 优先取不含内部斜杠的名称，多个时取字典序最小者；都没有时取别名最后一段，
+// 名称列表为空时使用容器短ID
+func canonicalName(names []string, containerID string) string {
+	var primary, aliases []string
+	for _, name := range names {
+		name = strings.Trim(name, "/")
+		if name == "" {
+			continue
+		}
+		if strings.Contains(name, "/") {
+			aliases = append(aliases, name[strings.LastIndex(name, "/")+1:])
+		} else {
+			primary = append(primary, name)
+		}
+	}
+
+	if len(primary) == 0 {
+		primary = aliases
+	}
+	if len(primary) == 0 {
+		return shortID(containerID)
+	}
+	sort.Strings(primary)
+	return primary[0]
+}
+
+// containerName 返回容器的工作负载名称
+// 已跟踪的容器沿用之前分配的名称，与其他已跟踪容器重名时追加短ID后缀
+func (cm *ContainerMonitor) containerName(containerID string, names []string) string {
+	cm.eventsMutex.Lock()
+	defer cm.eventsMutex.Unlock()
+
+	if ev, ok := cm.events[containerID]; ok {
+		return ev.Name
+	}
+
+	name := canonicalName(names, containerID)
+	for id, ev := range cm.events {
+		if id != containerID && ev.Name == name {
+			return name + "-" + shortID(containerID)
+		}
+	}
+	return name
+}
@@ -0,0 +1,53 @@
+// Package network 容器状态映射
+package network
+
+import (
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+// 容器状态，与Docker State.Status取值一致
+const (
+	containerStateCreated    = "created"
+	containerStateRunning    = "running"
+	containerStatePaused     = "paused"
+	containerStateRestarting = "restarting"
+	containerStateRemoving   = "removing"
+	containerStateExited     = "exited"
+	containerStateDead       = "dead"
+	containerStateUnknown    = "unknown"
+)
+
+// containerState 由Docker inspect的State得到容器状态
+// 优先依据布尔标志：暂停和重启中的容器Running同样为true，需先判断；
+// 标志都未设置时使用Status，无法识别时返回unknown
+func containerState(state *types.ContainerState) string {
+	switch {
+	case state == nil:
+		return containerStateUnknown
+	case state.Dead:
+		return containerStateDead
+	case state.Restarting:
+		return containerStateRestarting
+	case state.Paused:
+		return containerStatePaused
+	case state.Running:
+		return containerStateRunning
+	}
+
+	switch status := strings.ToLower(state.Status); status {
+	case containerStateCreated, containerStateRemoving, containerStateExited, containerStateDead:
+		return status
+	}
+	return containerStateUnknown
+}
+
+// containerHealth 由Docker inspect的State得到健康检查状态
+// 未配置健康检查时返回空
+func containerHealth(state *types.ContainerState) string {
+	if state == nil || state.Health == nil || state.Health.Status == types.NoHealthcheck {
+		return ""
+	}
+	return strings.ToLower(state.Health.Status)
+}
@@ -0,0 +1,30 @@
+// Package network 命令执行器
+package network
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Executor 执行shell命令，返回标准输出
+// 流量捕获通过它执行nsenter、ip、tc、iptables等命令，测试时可替换为记录命令的实现
+type Executor interface {
+	Run(cmd string) (output string, err error)
+}
+
+// shellExecutor 通过sh -c执行命令
+type shellExecutor struct{}
+
+// Run 执行命令，失败时错误中附带标准错误输出
+func (shellExecutor) Run(cmd string) (string, error) {
+	output, err := exec.Command("sh", "-c", cmd).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			err = fmt.Errorf("%v: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+	}
+	return string(output), err
+}
@@ -0,0 +1,99 @@
+// Package network K8s Pod元数据解析
+package network
+
+import (
+	"strings"
+)
+
+// Kubernetes写入容器的标签
+const (
+	k8sPodNameLabel       = "io.kubernetes.pod.name"
+	k8sPodNamespaceLabel  = "io.kubernetes.pod.namespace"
+	k8sPodUIDLabel        = "io.kubernetes.pod.uid"
+	k8sContainerNameLabel = "io.kubernetes.container.name"
+
+	// Pod标签，用于推断所属控制器
+	k8sPodTemplateHashLabel = "pod-template-hash"
+	k8sRevisionHashLabel    = "controller-revision-hash"
+	k8sStatefulSetPodLabel  = "statefulset.kubernetes.io/pod-name"
+	k8sJobNameLabel         = "job-name"
+)
+
+// K8sPodInfo 从容器标签解析出的Pod信息
+type K8sPodInfo struct {
+	PodName       string // Pod名称
+	Namespace     string // 命名空间
+	PodUID        string // Pod UID
+	ContainerName string // Pod内容器名称
+	OwnerKind     string // 所属控制器类型
+	OwnerName     string // 所属控制器名称
+}
+
+// parseK8sLabels 解析K8s容器标签
+// 非K8s容器返回nil
+func parseK8sLabels(labels map[string]string) *K8sPodInfo {
+	podName, ok := labels[k8sPodNameLabel]
+	if !ok || podName == "" {
+		return nil
+	}
+
+	info := &K8sPodInfo{
+		PodName:       podName,
+		Namespace:     labels[k8sPodNamespaceLabel],
+		PodUID:        labels[k8sPodUIDLabel],
+		ContainerName: labels[k8sContainerNameLabel],
+	}
+	info.OwnerKind, info.OwnerName = k8sPodOwner(podName, labels)
+	return info
+}
+
+// k8sPodOwner 推断Pod所属控制器
+// 依据控制器写入Pod的标签和Pod命名规则
+func k8sPodOwner(podName string, labels map[string]string) (string, string) {
+	if job, ok := labels[k8sJobNameLabel]; ok {
+		return "Job", job
+	}
+
+	if hash, ok := labels[k8sPodTemplateHashLabel]; ok {
+		// Deployment: <deploy>-<pod-template-hash>-<suffix>
+		if i := strings.Index(podName, "-"+hash+"-"); i > 0 {
+			return "Deployment", podName[:i]
+		}
+		return "ReplicaSet", trimLastSegment(podName)
+	}
+
+	if _, ok := labels[k8sRevisionHashLabel]; ok {
+		// StatefulSet: <sts>-<ordinal>；DaemonSet: <ds>-<suffix>
+		if _, ok := labels[k8sStatefulSetPodLabel]; ok {
+			return "StatefulSet", trimLastSegment(podName)
+		}
+		return "DaemonSet", trimLastSegment(podName)
+	}
+
+	// 应用容器通常不带Pod标签，按Deployment命名规则推断
+	parts := strings.Split(podName, "-")
+	if n := len(parts); n >= 3 && len(parts[n-1]) == 5 && isLowerAlnum(parts[n-1]) &&
+		len(parts[n-2]) >= 8 && len(parts[n-2]) <= 10 && isLowerAlnum(parts[n-2]) {
+		return "Deployment", strings.Join(parts[:n-2], "-")
+	}
+
+	return "", ""
+}
+
+// isLowerAlnum 检查是否只包含小写字母和数字
+func isLowerAlnum(s string) bool {
+	for _, c := range s {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// trimLastSegment 去掉名称最后一个"-"后的部分
+func trimLastSegment(name string) string {
+	if i := strings.LastIndex(name, "-"); i > 0 {
+		return name[:i]
+	}
+	return name
+}
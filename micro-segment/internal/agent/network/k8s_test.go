@@ -0,0 +1,97 @@
+package network
+
+import "testing"
+
+func TestParseK8sLabels(t *testing.T) {
+	cases := []struct {
+		name      string
+		labels    map[string]string
+		podName   string
+		namespace string
+		ownerKind string
+		ownerName string
+	}{
+		{
+			name: "deployment",
+			labels: map[string]string{
+				"io.kubernetes.pod.name":       "web-7d4b9c8f6d-x2k9p",
+				"io.kubernetes.pod.namespace":  "shop",
+				"io.kubernetes.pod.uid":        "0b6c1f7e-3b0a-4f57-9a0c-2f1e1d7c9a11",
+				"io.kubernetes.container.name": "nginx",
+				"pod-template-hash":            "7d4b9c8f6d",
+			},
+			podName: "web-7d4b9c8f6d-x2k9p", namespace: "shop",
+			ownerKind: "Deployment", ownerName: "web",
+		},
+		{
+			name: "deployment without pod labels",
+			labels: map[string]string{
+				"io.kubernetes.pod.name":      "api-server-5f9c7b6d8-qw7rt",
+				"io.kubernetes.pod.namespace": "default",
+			},
+			podName: "api-server-5f9c7b6d8-qw7rt", namespace: "default",
+			ownerKind: "Deployment", ownerName: "api-server",
+		},
+		{
+			name: "statefulset",
+			labels: map[string]string{
+				"io.kubernetes.pod.name":             "redis-2",
+				"io.kubernetes.pod.namespace":        "cache",
+				"controller-revision-hash":           "redis-6c8d9f5b7",
+				"statefulset.kubernetes.io/pod-name": "redis-2",
+			},
+			podName: "redis-2", namespace: "cache",
+			ownerKind: "StatefulSet", ownerName: "redis",
+		},
+		{
+			name: "daemonset",
+			labels: map[string]string{
+				"io.kubernetes.pod.name":      "fluentd-8x7kq",
+				"io.kubernetes.pod.namespace": "kube-system",
+				"controller-revision-hash":    "5d6b7c9f8",
+			},
+			podName: "fluentd-8x7kq", namespace: "kube-system",
+			ownerKind: "DaemonSet", ownerName: "fluentd",
+		},
+		{
+			name: "job",
+			labels: map[string]string{
+				"io.kubernetes.pod.name":      "migrate-db-4h2kd",
+				"io.kubernetes.pod.namespace": "shop",
+				"job-name":                    "migrate-db",
+			},
+			podName: "migrate-db-4h2kd", namespace: "shop",
+			ownerKind: "Job", ownerName: "migrate-db",
+		},
+		{
+			name: "bare pod",
+			labels: map[string]string{
+				"io.kubernetes.pod.name":      "debug",
+				"io.kubernetes.pod.namespace": "default",
+			},
+			podName: "debug", namespace: "default",
+		},
+	}
+
+	for _, tc := range cases {
+		info := parseK8sLabels(tc.labels)
+		if info == nil {
+			t.Fatalf("%s: expected pod info", tc.name)
+		}
+		if info.PodName != tc.podName || info.Namespace != tc.namespace {
+			t.Errorf("%s: got pod %s/%s, want %s/%s", tc.name, info.Namespace, info.PodName, tc.namespace, tc.podName)
+		}
+		if info.OwnerKind != tc.ownerKind || info.OwnerName != tc.ownerName {
+			t.Errorf("%s: got owner %s/%s, want %s/%s", tc.name, info.OwnerKind, info.OwnerName, tc.ownerKind, tc.ownerName)
+		}
+	}
+}
+
+func TestParseK8sLabelsNonK8s(t *testing.T) {
+	if info := parseK8sLabels(map[string]string{"com.docker.compose.service": "web"}); info != nil {
+		t.Errorf("expected nil for docker container, got %+v", info)
+	}
+	if info := parseK8sLabels(nil); info != nil {
+		t.Errorf("expected nil for nil labels, got %+v", info)
+	}
+}
@@ -1,8 +1,9 @@
-// Package network 网络管理器，整合TC流量捕获和容器监控
+// Package network 网络管理器，整合流量捕获和容器监控
 package network
 
 import (
 	"fmt"
+	"net"
 	"sync"
 	"time"
 
@@ -11,11 +12,19 @@ import (
 
 // Manager 网络管理器
 type Manager struct {
-	tcCapture        *TCTrafficCapture
+	method           CaptureMethod
+	capture          Capturer
 	containerMonitor *ContainerMonitor
 	mutex           sync.RWMutex
 	running         bool
 	stats           *NetworkStats
+	samples         packetSamples
+
+	reconcileInterval time.Duration                              // 对账间隔，0表示不对账
+	listRunning       func() ([]*ContainerEvent, error)          // 列出运行中的容器，测试时替换
+	containerInfo     func(string) (*ContainerEvent, error)      // 查询容器状态，测试时替换
+	reconcileStop     chan struct{}                              // 关闭时通知对账循环退出
+	reconcileDone     chan struct{}                              // 对账循环退出后关闭
 }
 
 // NetworkStats 网络统计信息
@@ -25,28 +34,37 @@ type NetworkStats struct {
 	LastUpdate         time.Time `json:"last_update"`
 	TotalPackets       uint64    `json:"total_packets"`
 	TotalBytes         uint64    `json:"total_bytes"`
+
+	Commands map[string]CommandStats `json:"commands,omitempty"` // TC和网络配置命令执行统计，按类别，仅TC方式
 }
 
 // NewManager 创建网络管理器
-// 初始化TC流量捕获和容器监控组件
-func NewManager() (*Manager, error) {
-	log.Info("Initializing TC-based network manager")
+// 按捕获方式初始化流量捕获实现和容器监控组件
+func NewManager(method CaptureMethod) (*Manager, error) {
+	log.WithField("method", method).Info("Initializing network manager")
 	
-	// 创建TC流量捕获器
-	tcCapture := NewTCTrafficCapture()
+	// 创建流量捕获实现
+	capture, err := newCapturer(method)
+	if err != nil {
+		return nil, err
+	}
 	
 	// 创建容器监控器
-	containerMonitor, err := NewContainerMonitor(tcCapture)
+	containerMonitor, err := NewContainerMonitor(capture)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create container monitor: %v", err)
 	}
 	
 	manager := &Manager{
-		tcCapture:        tcCapture,
+		method:           method,
+		capture:          capture,
 		containerMonitor: containerMonitor,
 		stats: &NetworkStats{
 			LastUpdate: time.Now(),
 		},
+		reconcileInterval: defaultReconcileInterval,
+		listRunning:       containerMonitor.ListRunningContainers,
+		containerInfo:     containerMonitor.GetContainerInfo,
 	}
 	
 	return manager, nil
@@ -62,7 +80,7 @@ func (m *Manager) Start() error {
 		return fmt.Errorf("network manager is already running")
 	}
 	
-	log.Info("Starting TC-based network manager")
+	log.WithField("method", m.method).Info("Starting network manager")
 	
 	// 启动容器监控
 	if err := m.containerMonitor.Start(); err != nil {
@@ -72,14 +90,21 @@ func (m *Manager) Start() error {
 	// 启动统计更新
 	go m.statsUpdateLoop()
 	
+	// 启动容器与捕获状态对账
+	if m.reconcileInterval > 0 {
+		m.reconcileStop = make(chan struct{})
+		m.reconcileDone = make(chan struct{})
+		go m.reconcileLoop(m.reconcileInterval, m.reconcileStop, m.reconcileDone)
+	}
+	
 	m.running = true
 	
-	log.Info("TC-based network manager started successfully")
+	log.WithField("method", m.method).Info("Network manager started successfully")
 	return nil
 }
 
 // Stop 停止网络管理器
-// 停止监控并清理TC流量捕获规则
+// 停止监控并清理流量捕获规则
 func (m *Manager) Stop() error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
@@ -88,24 +113,55 @@ func (m *Manager) Stop() error {
 		return nil
 	}
 	
-	log.Info("Stopping TC-based network manager")
+	log.WithField("method", m.method).Info("Stopping network manager")
+	
+	// 等待对账循环退出，避免清理后补发捕获事件
+	if m.reconcileStop != nil {
+		close(m.reconcileStop)
+		<-m.reconcileDone
+		m.reconcileStop = nil
+		m.reconcileDone = nil
+	}
 	
 	// 停止容器监控
 	if err := m.containerMonitor.Stop(); err != nil {
 		log.WithError(err).Warn("Failed to stop container monitor")
 	}
 	
-	// 清理TC流量捕获规则
-	if err := m.tcCapture.Cleanup(); err != nil {
-		log.WithError(err).Warn("Failed to cleanup TC traffic capture")
+	// 清理流量捕获规则
+	if err := m.capture.Cleanup(); err != nil {
+		log.WithError(err).Warn("Failed to cleanup traffic capture")
 	}
 	
 	m.running = false
 	
-	log.Info("TC-based network manager stopped")
+	log.WithField("method", m.method).Info("Network manager stopped")
 	return nil
 }
 
+// SetOnContainerEvent 设置容器事件回调
+// 用于将容器生命周期事件上报为工作负载
+func (m *Manager) SetOnContainerEvent(cb func(*ContainerEvent)) {
+	m.containerMonitor.SetOnContainerEvent(cb)
+}
+
+// SetCaptureParallelism 设置同时执行的容器捕获启停任务数上限
+// 需在Start之前设置
+func (m *Manager) SetCaptureParallelism(n int) {
+	m.containerMonitor.SetCaptureParallelism(n)
+}
+
+// SetServiceLabels 设置推导工作负载服务名称的标签及其优先级
+// 需在Start之前设置
+func (m *Manager) SetServiceLabels(labels []string) {
+	m.containerMonitor.SetServiceLabels(labels)
+}
+
+// Method 获取流量捕获方式
+func (m *Manager) Method() CaptureMethod {
+	return m.method
+}
+
 // IsRunning 检查管理器是否运行中
 // 线程安全地返回管理器运行状态
 func (m *Manager) IsRunning() bool {
@@ -115,10 +171,13 @@ func (m *Manager) IsRunning() bool {
 }
 
 // SetDPConnected 设置DP连接状态
-// 更新DP连接状态，TC方案通过bridge mirror数据包
+// 更新DP连接状态，TC方案通过bridge mirror数据包，无需处理
 func (m *Manager) SetDPConnected(connected bool) {
+	if nfq, ok := m.capture.(*TrafficCapture); ok {
+		nfq.SetDPConnected(connected)
+		return
+	}
 	log.WithField("connected", connected).Info("DP connection status updated for TC capture")
-	// TC方案不需要特殊的DP连接处理，因为数据包通过bridge mirror到DP
 }
 
 // GetStats 获取网络统计信息
@@ -134,9 +193,9 @@ func (m *Manager) GetStats() *NetworkStats {
 }
 
 // GetCapturedContainers 获取正在捕获的容器列表
-// 返回当前配置了TC规则的容器ID列表
+// 返回当前配置了捕获规则的容器列表
 func (m *Manager) GetCapturedContainers() []string {
-	return m.tcCapture.GetCapturedContainers()
+	return m.capture.GetCapturedContainers()
 }
 
 // GetRunningContainers 获取运行中的容器列表
@@ -145,6 +204,32 @@ func (m *Manager) GetRunningContainers() ([]*ContainerEvent, error) {
 	return m.containerMonitor.ListRunningContainers()
 }
 
+// GetDockerSubnets 获取Docker网络子网
+// 用于内部子网自动发现
+func (m *Manager) GetDockerSubnets() ([]net.IPNet, error) {
+	return m.containerMonitor.ListNetworkSubnets()
+}
+
+// PauseCapture 暂停捕获指定容器，保留veth pair
+// 仅TC方式支持
+func (m *Manager) PauseCapture(containerID string) error {
+	p, ok := m.capture.(pausableCapturer)
+	if !ok {
+		return fmt.Errorf("pause not supported by %s capture", m.method)
+	}
+	return p.PauseContainer(containerID)
+}
+
+// ResumeCapture 恢复捕获指定容器
+// 仅TC方式支持
+func (m *Manager) ResumeCapture(containerID string) error {
+	p, ok := m.capture.(pausableCapturer)
+	if !ok {
+		return fmt.Errorf("resume not supported by %s capture", m.method)
+	}
+	return p.ResumeContainer(containerID)
+}
+
 // GetContainerInfo 获取容器信息
 // 查询指定容器的详细信息和网络配置
 func (m *Manager) GetContainerInfo(containerID string) (*ContainerEvent, error) {
@@ -152,7 +237,7 @@ func (m *Manager) GetContainerInfo(containerID string) (*ContainerEvent, error)
 }
 
 // ForceStartCapture 强制开始捕获指定容器
-// 手动为指定容器创建veth pair和TC规则
+// 手动为指定容器设置捕获规则
 func (m *Manager) ForceStartCapture(containerID string) error {
 	containerInfo, err := m.containerMonitor.GetContainerInfo(containerID)
 	if err != nil {
@@ -163,13 +248,13 @@ func (m *Manager) ForceStartCapture(containerID string) error {
 		return fmt.Errorf("container %s has no valid PID", containerID)
 	}
 	
-	return m.tcCapture.StartContainerCapture(containerID, containerInfo.Name, containerInfo.Pid)
+	return m.capture.StartContainerCapture(containerID, containerInfo.Name, containerInfo.Pid)
 }
 
 // ForceStopCapture 强制停止捕获指定容器
-// 手动清理指定容器的TC规则和veth pair
+// 手动清理指定容器的捕获规则
 func (m *Manager) ForceStopCapture(containerID string) error {
-	return m.tcCapture.StopContainerCapture(containerID)
+	return m.capture.StopContainerCapture(containerID)
 }
 
 // statsUpdateLoop 统计信息更新循环
@@ -192,9 +277,12 @@ func (m *Manager) statsUpdateLoop() {
 // updateStats 更新统计信息
 // 收集当前捕获状态和性能数据
 func (m *Manager) updateStats() {
-	capturedContainers := m.tcCapture.GetCapturedContainers()
+	capturedContainers := m.capture.GetCapturedContainers()
 	
 	m.stats.CapturedContainers = len(capturedContainers)
+	if src, ok := m.capture.(commandStatsSource); ok {
+		m.stats.Commands = src.CommandStats()
+	}
 	m.stats.LastUpdate = time.Now()
 	
 	// TODO: 从DP获取实际的包和字节统计
@@ -215,35 +303,19 @@ func (m *Manager) GetNetworkTopology() (map[string]interface{}, error) {
 		"captured":   m.GetCapturedContainers(),
 		"stats":      m.GetStats(),
 		"timestamp":  time.Now(),
-		"method":     "traffic_control",
+		"method":     m.method,
 	}
 	
 	return topology, nil
 }
 
 // ValidateSetup 验证网络设置
-// 检查TC、Docker等必需工具的可用性
+// 检查所选捕获方式依赖的命令及Docker的可用性
 func (m *Manager) ValidateSetup() error {
-	log.Info("Validating TC-based network setup")
-	
-	// 检查tc命令是否可用
-	if err := m.tcCapture.executeCommand("tc -Version"); err != nil {
-		return fmt.Errorf("tc command not available: %v", err)
-	}
-	
-	// 检查ip命令是否可用
-	if err := m.tcCapture.executeCommand("ip -Version"); err != nil {
-		return fmt.Errorf("ip command not available: %v", err)
-	}
-	
-	// 检查nsenter是否可用
-	if err := m.tcCapture.executeCommand("nsenter --version"); err != nil {
-		return fmt.Errorf("nsenter not available: %v", err)
-	}
+	log.WithField("method", m.method).Info("Validating network setup")
 	
-	// 检查ethtool是否可用
-	if err := m.tcCapture.executeCommand("ethtool --version"); err != nil {
-		log.Warn("ethtool not available, network offload features cannot be disabled")
+	if err := m.capture.ValidateSetup(); err != nil {
+		return err
 	}
 	
 	// 检查Docker是否可用
@@ -252,7 +324,10 @@ func (m *Manager) ValidateSetup() error {
 		return fmt.Errorf("docker not accessible: %v", err)
 	}
 	
-	log.WithField("containers", len(containers)).Info("TC-based network setup validation passed")
+	log.WithFields(log.Fields{
+		"method":     m.method,
+		"containers": len(containers),
+	}).Info("Network setup validation passed")
 	return nil
 }
 
@@ -264,8 +339,10 @@ func (m *Manager) GetDebugInfo() map[string]interface{} {
 		"captured_containers": m.GetCapturedContainers(),
 		"stats":              m.GetStats(),
 		"timestamp":          time.Now(),
-		"method":             "traffic_control",
-		"bridge_ready":       m.tcCapture.bridgeReady,
+		"method":             m.method,
+	}
+	if tc, ok := m.capture.(*TCTrafficCapture); ok {
+		debugInfo["bridge_ready"] = tc.bridgeReady
 	}
 	
 	// 获取运行中的容器
@@ -0,0 +1,186 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeCapturer 记录调用的流量捕获实现
+type fakeCapturer struct {
+	name     string
+	started  []string
+	stopped  []string
+	addrs    map[string]*IPConfig
+	validErr error
+}
+
+func (f *fakeCapturer) StartContainerCapture(containerID, containerName string, pid int) error {
+	f.started = append(f.started, containerID)
+	return nil
+}
+
+func (f *fakeCapturer) StopContainerCapture(containerID string) error {
+	f.stopped = append(f.stopped, containerID)
+	return nil
+}
+
+func (f *fakeCapturer) GetCapturedContainers() []string                   { return []string{f.name} }
+func (f *fakeCapturer) GetContainerIPConfigs(string) map[string]*IPConfig { return f.addrs }
+func (f *fakeCapturer) CheckIPChanges() []string                          { return nil }
+func (f *fakeCapturer) ValidateSetup() error                              { return f.validErr }
+func (f *fakeCapturer) Cleanup() error                                    { return nil }
+
+func TestManagerCaptureMethod(t *testing.T) {
+	orig := capturerConstructors
+	defer func() { capturerConstructors = orig }()
+
+	fakes := map[CaptureMethod]*fakeCapturer{
+		CaptureMethodTC:      {name: "tc", validErr: fmt.Errorf("tc missing")},
+		CaptureMethodNFQueue: {name: "nfqueue", validErr: fmt.Errorf("iptables missing")},
+	}
+	capturerConstructors = map[CaptureMethod]func() Capturer{}
+	for method, fake := range fakes {
+		fake := fake
+		capturerConstructors[method] = func() Capturer { return fake }
+	}
+
+	for _, s := range []string{"tc", "nfqueue"} {
+		method, err := ParseCaptureMethod(s)
+		if err != nil {
+			t.Fatalf("ParseCaptureMethod(%s) failed: %v", s, err)
+		}
+		m, err := NewManager(method)
+		if err != nil {
+			t.Fatalf("NewManager(%s) failed: %v", method, err)
+		}
+		fake := fakes[method]
+		if m.Method() != method || m.capture != Capturer(fake) || m.containerMonitor.capture != Capturer(fake) {
+			t.Fatalf("Manager for %s uses wrong capturer", method)
+		}
+
+		// 操作分派到所选实现
+		if err := m.ForceStopCapture("c1"); err != nil || len(fake.stopped) != 1 {
+			t.Errorf("%s: stop not dispatched: %v %v", method, err, fake.stopped)
+		}
+		if got := m.GetCapturedContainers(); len(got) != 1 || got[0] != s {
+			t.Errorf("%s: unexpected captured containers: %v", method, got)
+		}
+		if err := m.ValidateSetup(); err != fake.validErr {
+			t.Errorf("%s: unexpected validation error: %v", method, err)
+		}
+		if err := m.PauseCapture("c1"); err == nil {
+			t.Errorf("%s: pause should be unsupported", method)
+		}
+	}
+
+	if _, err := ParseCaptureMethod("ebpf"); err == nil {
+		t.Errorf("Expected error for unknown method")
+	}
+	if _, err := NewManager("ebpf"); err == nil {
+		t.Errorf("Expected error for unknown method")
+	}
+}
+
+func TestValidateSetupPerMethod(t *testing.T) {
+	noIptables := func(cmd string) bool { return strings.HasPrefix(cmd, "iptables") }
+
+	// TC方式不依赖iptables
+	tc, exec := newTestCapture()
+	exec.fail = noIptables
+	if err := tc.ValidateSetup(); err != nil {
+		t.Errorf("TC validation failed: %v", err)
+	}
+	if len(filterCmds(exec.cmds, "tc -Version")) != 1 {
+		t.Errorf("TC validation did not check tc: %v", exec.cmds)
+	}
+
+	nfq := &TrafficCapture{containers: make(map[string]*ContainerNetInfo), executor: exec}
+	if err := nfq.ValidateSetup(); err == nil || !strings.Contains(err.Error(), "iptables") {
+		t.Errorf("Expected iptables error, got %v", err)
+	}
+
+	// TC方式缺少tc命令
+	exec.fail = func(cmd string) bool { return strings.HasPrefix(cmd, "tc ") }
+	if err := tc.ValidateSetup(); err == nil || !strings.Contains(err.Error(), "tc command") {
+		t.Errorf("Expected tc error, got %v", err)
+	}
+	if err := nfq.ValidateSetup(); err != nil {
+		t.Errorf("NFQUEUE validation failed: %v", err)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	capture := &fakeCapturer{name: "c-old"}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cm := &ContainerMonitor{
+		capture: capture,
+		ctx:     ctx,
+		cancel:  cancel,
+		events:  make(map[string]*ContainerEvent),
+		pool:    newCapturePool(defaultCaptureParallelism),
+	}
+	m := &Manager{capture: capture, containerMonitor: cm}
+	m.listRunning = func() ([]*ContainerEvent, error) {
+		return []*ContainerEvent{{Type: "running", ContainerID: "c-new", Name: "web", Pid: 100}}, nil
+	}
+	state := containerStatePaused
+	m.containerInfo = func(id string) (*ContainerEvent, error) {
+		return &ContainerEvent{ContainerID: id, State: state}, nil
+	}
+
+	// 运行中未捕获的容器开始捕获，暂停的容器保留捕获
+	m.reconcile()
+	cm.pool.wait()
+	if len(capture.started) != 1 || capture.started[0] != "c-new" {
+		t.Fatalf("Capture not started for running container: %v", capture.started)
+	}
+	if len(capture.stopped) != 0 {
+		t.Fatalf("Paused container capture stopped: %v", capture.stopped)
+	}
+
+	// 已退出的容器停止捕获
+	state = containerStateExited
+	m.reconcile()
+	cm.pool.wait()
+	if len(capture.stopped) != 1 || capture.stopped[0] != "c-old" {
+		t.Errorf("Capture not stopped for exited container: %v", capture.stopped)
+	}
+}
+
+func TestReconcileLoopStop(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	m := &Manager{capture: &fakeCapturer{}}
+	m.listRunning = func() ([]*ContainerEvent, error) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+		return nil, fmt.Errorf("docker unavailable")
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go m.reconcileLoop(time.Millisecond, stop, done)
+
+	// 进行中的对账完成前循环不退出
+	<-started
+	close(stop)
+	select {
+	case <-done:
+		t.Fatal("Reconcile loop exited during reconciliation")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Reconcile loop did not exit after stop")
+	}
+}
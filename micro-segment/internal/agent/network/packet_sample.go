@@ -0,0 +1,148 @@
+// Package network 容器流量采样
+package network
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 采样限制，避免长时间采样写满磁盘
+const (
+	packetSampleMaxDuration = 5 * time.Minute
+	packetSampleMaxPackets  = 10000 // 单次采样最多写入的数据包数
+	packetSampleSnapLen     = 256   // 每个数据包最多保存的字节数
+)
+
+// PacketSample 一次容器流量采样
+// 采样在后台运行，达到时长或包数上限后结束
+type PacketSample struct {
+	ContainerID string
+	Path        string
+	Duration    time.Duration
+
+	done chan struct{}
+	err  error
+}
+
+// Wait 等待采样结束，返回采样命令的错误
+func (s *PacketSample) Wait() error {
+	<-s.done
+	return s.err
+}
+
+// packetSamples 进行中的采样，同一容器同时只允许一个采样
+type packetSamples struct {
+	mutex   sync.Mutex
+	running map[string]*PacketSample
+}
+
+// containerMACs 获取已捕获容器各接口的MAC地址，包括NV MAC和原始MAC
+func (tc *TCTrafficCapture) containerMACs(containerID string) ([]net.HardwareAddr, error) {
+	tc.mutex.RLock()
+	defer tc.mutex.RUnlock()
+
+	info, ok := tc.containers[containerID]
+	if !ok {
+		return nil, fmt.Errorf("container %s not captured", containerID)
+	}
+	var macs []net.HardwareAddr
+	for _, vethPair := range info.VethPairs {
+		for _, mac := range []net.HardwareAddr{vethPair.NVMAC, vethPair.OriginalMAC} {
+			if len(mac) > 0 {
+				macs = append(macs, mac)
+			}
+		}
+	}
+	if len(macs) == 0 {
+		return nil, fmt.Errorf("container %s has no captured interface", containerID)
+	}
+	return macs, nil
+}
+
+// packetSampleCommand 生成在指定接口上按MAC过滤写入pcap的tcpdump命令
+// 超时以SIGINT结束tcpdump，使其正常刷新文件并以0退出
+func packetSampleCommand(iface string, macs []net.HardwareAddr, duration time.Duration, path string) string {
+	hosts := make([]string, 0, len(macs))
+	for _, mac := range macs {
+		hosts = append(hosts, "ether host "+mac.String())
+	}
+	secs := int((duration + time.Second - 1) / time.Second)
+	return fmt.Sprintf("timeout --preserve-status -s INT %d tcpdump -i %s -n -U -s %d -c %d -w %s '%s'",
+		secs, iface, packetSampleSnapLen, packetSampleMaxPackets, path, strings.Join(hosts, " or "))
+}
+
+// startPacketSample 在后台执行采样命令
+func startPacketSample(executor Executor, sample *PacketSample, cmd string) {
+	go func() {
+		defer close(sample.done)
+		if output, err := executor.Run(cmd); err != nil {
+			sample.err = fmt.Errorf("packet sample failed: %v: %s", err, strings.TrimSpace(output))
+		}
+		log.WithFields(log.Fields{
+			"container": shortID(sample.ContainerID),
+			"path":      sample.Path,
+			"error":     sample.err,
+		}).Info("Packet sample finished")
+	}()
+}
+
+// StartPacketSample 采样容器镜像到nv-br的流量并写入pcap文件
+// 仅TC方式支持；时长不超过packetSampleMaxDuration，包数和每包字节数有上限，不覆盖已有文件
+func (m *Manager) StartPacketSample(containerID string, duration time.Duration, path string) (*PacketSample, error) {
+	if duration <= 0 || duration > packetSampleMaxDuration {
+		return nil, fmt.Errorf("invalid sample duration %v, must be in (0, %v]", duration, packetSampleMaxDuration)
+	}
+	if !filepath.IsAbs(path) {
+		return nil, fmt.Errorf("sample path must be absolute: %s", path)
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("sample file already exists: %s", path)
+	}
+
+	tc, ok := m.capture.(*TCTrafficCapture)
+	if !ok {
+		return nil, fmt.Errorf("packet sample not supported by %s capture", m.method)
+	}
+	macs, err := tc.containerMACs(containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	m.samples.mutex.Lock()
+	defer m.samples.mutex.Unlock()
+	if _, ok := m.samples.running[containerID]; ok {
+		return nil, fmt.Errorf("packet sample already running for container %s", containerID)
+	}
+	if m.samples.running == nil {
+		m.samples.running = make(map[string]*PacketSample)
+	}
+
+	sample := &PacketSample{
+		ContainerID: containerID,
+		Path:        path,
+		Duration:    duration,
+		done:        make(chan struct{}),
+	}
+	m.samples.running[containerID] = sample
+	startPacketSample(tc.executor, sample, packetSampleCommand(NV_BRIDGE_NAME, macs, duration, path))
+	go func() {
+		<-sample.done
+		m.samples.mutex.Lock()
+		delete(m.samples.running, containerID)
+		m.samples.mutex.Unlock()
+	}()
+
+	log.WithFields(log.Fields{
+		"container": shortID(containerID),
+		"duration":  duration,
+		"path":      path,
+	}).Info("Packet sample started")
+	return sample, nil
+}
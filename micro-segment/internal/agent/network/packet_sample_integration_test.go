@@ -0,0 +1,64 @@
+//go:build integration
+
+package network
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestPacketSampleFile 在veth pair上采样ping流量，需要root权限和tcpdump
+// 运行: go test -tags integration -run TestPacketSampleFile ./internal/agent/network/
+func TestPacketSampleFile(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+	if _, err := exec.LookPath("tcpdump"); err != nil {
+		t.Skip("tcpdump not installed")
+	}
+
+	run := func(cmd string) string {
+		out, err := shellExecutor{}.Run(cmd)
+		if err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+		return out
+	}
+	run("ip netns add ms-sample")
+	defer shellExecutor{}.Run("ip netns del ms-sample")
+	run("ip link add ms-smp0 type veth peer name ms-smp1")
+	defer shellExecutor{}.Run("ip link del ms-smp0")
+	run("ip link set ms-smp1 netns ms-sample")
+	run("ip addr add 169.254.77.1/30 dev ms-smp0")
+	run("ip link set ms-smp0 up")
+	run("ip netns exec ms-sample ip addr add 169.254.77.2/30 dev ms-smp1")
+	run("ip netns exec ms-sample ip link set ms-smp1 up")
+
+	mac, err := net.ParseMAC(run("ip netns exec ms-sample cat /sys/class/net/ms-smp1/address")[:17])
+	if err != nil {
+		t.Fatalf("ParseMAC failed: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "sample.pcap")
+	sample := &PacketSample{ContainerID: "ms-sample", Path: path, Duration: 3 * time.Second, done: make(chan struct{})}
+	startPacketSample(shellExecutor{}, sample, packetSampleCommand("ms-smp0", []net.HardwareAddr{mac}, sample.Duration, path))
+
+	time.Sleep(500 * time.Millisecond)
+	shellExecutor{}.Run("ping -c 3 -i 0.2 169.254.77.2")
+
+	if err := sample.Wait(); err != nil {
+		t.Fatalf("Sample failed: %v", err)
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Sample file not created: %v", err)
+	}
+	// pcap文件头24字节，之后为数据包记录
+	if st.Size() <= 24 {
+		t.Errorf("Sample file has no packets: %d bytes", st.Size())
+	}
+}
@@ -0,0 +1,57 @@
+package network
+
+import (
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStartPacketSample(t *testing.T) {
+	tc, exec := newTestCapture()
+	const id = "3f4e5d6c7b8a9f0e1d2c3b4a"
+	tc.containers[id] = &TCContainerInfo{ID: id, Name: "web", VethPairs: map[string]*VethPairInfo{
+		"eth0": {
+			OriginalMAC: net.HardwareAddr{0x02, 0x42, 0xac, 0x11, 0x00, 0x02},
+			NVMAC:       net.HardwareAddr{0x4e, 0x65, 0x75, 0x56, 0x00, 0x01},
+		},
+	}}
+	m := &Manager{method: CaptureMethodTC, capture: tc}
+	path := filepath.Join(t.TempDir(), "web.pcap")
+
+	sample, err := m.StartPacketSample(id, 1500*time.Millisecond, path)
+	if err != nil {
+		t.Fatalf("StartPacketSample failed: %v", err)
+	}
+	if err := sample.Wait(); err != nil {
+		t.Fatalf("Sample failed: %v", err)
+	}
+	cmds := filterCmds(exec.cmds, "tcpdump")
+	if len(cmds) != 1 {
+		t.Fatalf("Unexpected commands: %v", exec.cmds)
+	}
+	for _, want := range []string{
+		"timeout --preserve-status -s INT 2 ", "-i " + NV_BRIDGE_NAME, "-c 10000", "-s 256", "-w " + path,
+		"ether host 4e:65:75:56:00:01", "ether host 02:42:ac:11:00:02",
+	} {
+		if !strings.Contains(cmds[0], want) {
+			t.Errorf("Command %q missing %q", cmds[0], want)
+		}
+	}
+
+	for name, fn := range map[string]func() error{
+		"too long": func() error { _, err := m.StartPacketSample(id, time.Hour, path); return err },
+		"relative": func() error { _, err := m.StartPacketSample(id, time.Second, "web.pcap"); return err },
+		"unknown":  func() error { _, err := m.StartPacketSample("unknown", time.Second, path); return err },
+		"nfqueue": func() error {
+			nfq := &Manager{method: CaptureMethodNFQueue, capture: &fakeCapturer{}}
+			_, err := nfq.StartPacketSample(id, time.Second, path)
+			return err
+		},
+	} {
+		if fn() == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// Package network 运行中容器与捕获状态的周期对账
+package network
+
+import (
+	"time"
+
+	"github.com/docker/docker/client"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultReconcileInterval 默认对账间隔
+const defaultReconcileInterval = 60 * time.Second
+
+// SetReconcileInterval 设置运行中容器与捕获状态的对账间隔
+// 需在Start之前设置，0表示不对账
+func (m *Manager) SetReconcileInterval(d time.Duration) {
+	m.reconcileInterval = d
+}
+
+// reconcileLoop 对账循环
+// 弥补丢失的Docker事件：漏掉start时容器未被捕获，漏掉die时捕获残留；
+// stop关闭后退出并关闭done
+func (m *Manager) reconcileLoop(interval time.Duration, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			m.reconcile()
+		}
+	}
+}
+
+// reconcile 执行一次对账
+// 运行中未捕获的容器补发start事件；已捕获但不在运行列表中的容器，
+// 确认已退出或已删除后补发stop事件，暂停和状态未知的容器保持不变
+func (m *Manager) reconcile() {
+	running, err := m.listRunning()
+	if err != nil {
+		log.WithError(err).Warn("Failed to list running containers for reconciliation")
+		return
+	}
+
+	captured := make(map[string]bool)
+	for _, id := range m.capture.GetCapturedContainers() {
+		captured[id] = true
+	}
+
+	alive := make(map[string]bool, len(running))
+	for _, container := range running {
+		alive[container.ContainerID] = true
+		if captured[container.ContainerID] {
+			continue
+		}
+		log.WithFields(log.Fields{
+			"container": container.Name,
+			"id":        shortID(container.ContainerID),
+		}).Info("Reconcile: starting capture for running container")
+		event := *container
+		event.Type = "start"
+		m.containerMonitor.handleContainerEvent(&event)
+	}
+
+	for id := range captured {
+		if alive[id] || !m.containerGone(id) {
+			continue
+		}
+		log.WithField("id", shortID(id)).Info("Reconcile: stopping capture for dead container")
+		m.containerMonitor.handleContainerEvent(&ContainerEvent{Type: "stop", ContainerID: id, Name: id})
+	}
+}
+
+// containerGone 判断容器是否已退出或已删除
+func (m *Manager) containerGone(containerID string) bool {
+	info, err := m.containerInfo(containerID)
+	if err != nil {
+		return client.IsErrNotFound(err)
+	}
+	switch info.State {
+	case containerStateExited, containerStateDead, containerStateRemoving, containerStateCreated:
+		return true
+	}
+	return false
+}
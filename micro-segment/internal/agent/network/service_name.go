@@ -0,0 +1,57 @@
+// Package network 由容器标签推导服务名称
+package network
+
+import (
+	"strings"
+)
+
+// composeServiceLabel docker compose写入容器的服务名标签
+const composeServiceLabel = "com.docker.compose.service"
+
+// DefaultServiceLabels 默认用于推导服务名称的标签，按优先级排列
+var DefaultServiceLabels = []string{composeServiceLabel, k8sContainerNameLabel}
+
+// SetServiceLabels 设置推导服务名称的标签及其优先级
+// 需在Start之前设置，为空时使用DefaultServiceLabels
+func (cm *ContainerMonitor) SetServiceLabels(labels []string) {
+	cm.serviceLabels = labels
+}
+
+// serviceName 返回容器的服务名称
+func (cm *ContainerMonitor) serviceName(labels map[string]string, image string) string {
+	precedence := cm.serviceLabels
+	if len(precedence) == 0 {
+		precedence = DefaultServiceLabels
+	}
+	return deriveServiceName(labels, image, precedence)
+}
+
+// deriveServiceName 按标签优先级取第一个非空标签值作为服务名称
+// 都没有时回退到镜像名，去掉仓库地址、路径、标签和摘要
+func deriveServiceName(labels map[string]string, image string, precedence []string) string {
+	for _, label := range precedence {
+		if v := strings.TrimSpace(labels[label]); v != "" {
+			return v
+		}
+	}
+	return imageBaseName(image)
+}
+
+// imageBaseName 返回镜像的基础名称
+// 如registry.example.com:5000/team/nginx:1.25@sha256:...返回nginx
+func imageBaseName(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, "/"); i >= 0 {
+		image = image[i+1:]
+	}
+	if i := strings.Index(image, ":"); i >= 0 {
+		image = image[:i]
+	}
+	// 镜像ID没有名称可用
+	if image == "sha256" {
+		return ""
+	}
+	return image
+}
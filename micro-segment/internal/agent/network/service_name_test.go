@@ -0,0 +1,48 @@
+package network
+
+import "testing"
+
+func TestDeriveServiceName(t *testing.T) {
+	cases := []struct {
+		name   string
+		labels map[string]string
+		image  string
+		want   string
+	}{
+		{
+			name:   "compose",
+			labels: map[string]string{"com.docker.compose.service": "web", "com.docker.compose.project": "shop"},
+			image:  "shop-web:latest",
+			want:   "web",
+		},
+		{
+			name: "k8s",
+			labels: map[string]string{
+				"io.kubernetes.pod.name":       "api-7d4b9c8f6d-x2k9p",
+				"io.kubernetes.container.name": "api",
+			},
+			image: "registry.example.com:5000/team/api-server:1.2",
+			want:  "api",
+		},
+		{name: "image with registry and tag", image: "registry.example.com:5000/team/nginx:1.25", want: "nginx"},
+		{name: "image with digest", image: "redis@sha256:0123abcd", want: "redis"},
+		{name: "plain image", image: "postgres", want: "postgres"},
+		{name: "image id", image: "sha256:0123abcd", want: ""},
+	}
+	for _, c := range cases {
+		if got := deriveServiceName(c.labels, c.image, DefaultServiceLabels); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+
+	// 自定义优先级
+	cm := &ContainerMonitor{}
+	labels := map[string]string{"com.docker.compose.service": "web", "app": "frontend"}
+	if got := cm.serviceName(labels, "nginx"); got != "web" {
+		t.Errorf("Default precedence: got %q", got)
+	}
+	cm.SetServiceLabels([]string{"app", "com.docker.compose.service"})
+	if got := cm.serviceName(labels, "nginx"); got != "frontend" {
+		t.Errorf("Custom precedence: got %q", got)
+	}
+}
@@ -5,9 +5,10 @@ package network
 import (
 	"fmt"
 	"net"
-	"os/exec"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/vishvananda/netlink"
@@ -27,8 +28,40 @@ type TCTrafficCapture struct {
 	mutex       sync.RWMutex
 	containers  map[string]*TCContainerInfo // 容器网络信息
 	prefs       map[uint]bool               // TC优先级使用情况
+	indexes     map[uint]bool               // 接口索引使用情况，与TC优先级分开分配
 	portMap     map[string]*TCPortInfo      // 端口映射信息
 	bridgeReady bool                        // Bridge是否就绪
+
+	// 查询接口IP配置，测试时可替换
+	ipConfigFn func(pid int, iface string) (*IPConfig, error)
+	// 执行TC和网络配置命令，测试时可替换
+	executor Executor
+
+	// 按类别统计命令执行结果
+	cmdCounters [numCommandCategories]commandCounter
+}
+
+// 命令类别
+const (
+	commandQDisc         = iota // tc qdisc
+	commandFilter               // tc filter
+	commandLink                 // ip link/addr/route、ethtool等接口配置
+	numCommandCategories
+)
+
+// commandCategoryNames 命令类别名称
+var commandCategoryNames = [numCommandCategories]string{"qdisc", "filter", "link"}
+
+// commandCounter 命令执行计数，原子读写
+type commandCounter struct {
+	success atomic.Uint64
+	failed  atomic.Uint64
+}
+
+// CommandStats 某类命令的执行统计
+type CommandStats struct {
+	Success uint64 `json:"success"`
+	Failed  uint64 `json:"failed"`
 }
 
 // TCContainerInfo 容器网络信息
@@ -38,6 +71,7 @@ type TCContainerInfo struct {
 	Pid        int                       // 容器PID
 	VethPairs  map[string]*VethPairInfo  // veth pair信息
 	TCRules    []string                  // TC规则列表
+	Paused     bool                      // 暂停捕获，已移除到NV bridge的mirror规则
 }
 
 // VethPairInfo veth pair信息
@@ -48,13 +82,22 @@ type VethPairInfo struct {
 	OriginalMAC  net.HardwareAddr // 原始MAC地址
 	NVMAC        net.HardwareAddr // NeuVector分配的MAC地址
 	BroadcastMAC net.HardwareAddr // 广播MAC地址
-	Index        uint             // 接口索引
+	Index        uint             // 分配的索引，仅用于生成唯一的NV MAC
+	IfIndex      int              // 原始接口在容器命名空间中的内核ifindex
+	IPConfig     *IPConfig        // 接口当前IP配置
+}
+
+// containerInterface 容器命名空间中的网络接口
+type containerInterface struct {
+	Name    string // 接口名称，不含@后的对端
+	IfIndex int    // 内核ifindex
 }
 
 // TCPortInfo TC端口信息
 type TCPortInfo struct {
-	Index uint // 端口索引
-	Pref  uint // TC优先级
+	Index   uint // 端口索引
+	IfIndex int  // 原始接口的内核ifindex
+	Pref    uint // TC优先级
 }
 
 // NewTCTrafficCapture 创建TC流量捕获管理器
@@ -63,8 +106,11 @@ func NewTCTrafficCapture() *TCTrafficCapture {
 	tc := &TCTrafficCapture{
 		containers: make(map[string]*TCContainerInfo),
 		prefs:      make(map[uint]bool),
+		indexes:    make(map[uint]bool),
 		portMap:    make(map[string]*TCPortInfo),
 	}
+	tc.ipConfigFn = tc.getInterfaceIPConfig
+	tc.executor = shellExecutor{}
 	
 	// 初始化NeuVector bridge
 	if err := tc.initNVBridge(); err != nil {
@@ -133,21 +179,21 @@ func (tc *TCTrafficCapture) cleanupBridge(bridge netlink.Link) {
 // 为指定接口添加入口流量控制队列
 func (tc *TCTrafficCapture) addQDisc(port string) error {
 	cmd := fmt.Sprintf("tc qdisc add dev %s ingress", port)
-	return tc.executeCommand(cmd)
+	return tc.runCommand(cmd)
 }
 
 // addQDiscInNamespace 在指定网络命名空间中添加ingress qdisc
 // 在容器网络命名空间中配置流量控制队列
 func (tc *TCTrafficCapture) addQDiscInNamespace(pid int, port string) error {
 	cmd := fmt.Sprintf("nsenter -t %d -n tc qdisc add dev %s ingress", pid, port)
-	return tc.executeCommand(cmd)
+	return tc.runCommand(cmd)
 }
 
 // delQDisc 删除ingress qdisc
 // 移除指定接口的入口流量控制队列
 func (tc *TCTrafficCapture) delQDisc(port string) error {
 	cmd := fmt.Sprintf("tc qdisc del dev %s ingress", port)
-	return tc.executeCommand(cmd)
+	return tc.runCommand(cmd)
 }
 
 // disableOffload 禁用网络offload功能
@@ -166,7 +212,7 @@ func (tc *TCTrafficCapture) disableOffload(port string) {
 	
 	for _, feature := range offloadFeatures {
 		cmd := fmt.Sprintf("ethtool -K %s %s off", port, feature)
-		tc.executeCommand(cmd) // 忽略错误
+		tc.runCommand(cmd) // 忽略错误
 	}
 }
 
@@ -211,21 +257,22 @@ func (tc *TCTrafficCapture) StartContainerCapture(containerID, containerName str
 	
 	// 为每个接口创建veth pair和TC规则
 	for _, iface := range interfaces {
-		if iface == "lo" {
+		if iface.Name == "lo" {
 			continue // 跳过loopback接口
 		}
+		fields := log.Fields{"interface": iface.Name, "ifindex": iface.IfIndex}
 		
 		vethPair, err := tc.createVethPair(pid, iface, containerInfo)
 		if err != nil {
-			log.WithError(err).WithField("interface", iface).Error("Failed to create veth pair")
+			log.WithError(err).WithFields(fields).Error("Failed to create veth pair")
 			continue
 		}
 		
-		containerInfo.VethPairs[iface] = vethPair
+		containerInfo.VethPairs[iface.Name] = vethPair
 		
 		// 设置TC规则
 		if err := tc.setupTCRules(vethPair, containerInfo); err != nil {
-			log.WithError(err).WithField("interface", iface).Error("Failed to setup TC rules")
+			log.WithError(err).WithFields(fields).Error("Failed to setup TC rules")
 		}
 	}
 	
@@ -241,36 +288,44 @@ func (tc *TCTrafficCapture) StartContainerCapture(containerID, containerName str
 }
 
 // getContainerInterfaces 获取容器网络接口列表
-// 解析容器内的网络接口名称
-func (tc *TCTrafficCapture) getContainerInterfaces(pid int) ([]string, error) {
+// 解析容器内的网络接口名称和内核ifindex
+func (tc *TCTrafficCapture) getContainerInterfaces(pid int) ([]containerInterface, error) {
 	cmd := fmt.Sprintf("nsenter -t %d -n ip link show", pid)
-	output, err := exec.Command("sh", "-c", cmd).Output()
+	output, err := tc.executor.Run(cmd)
 	if err != nil {
 		return nil, err
 	}
-	
-	var interfaces []string
-	lines := strings.Split(string(output), "\n")
-	
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if strings.Contains(line, ": ") && !strings.HasPrefix(line, " ") {
-			// 解析接口行: "2: eth0@if3: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500"
-			parts := strings.Split(line, ": ")
-			if len(parts) >= 2 {
-				ifaceName := strings.Split(parts[1], "@")[0]
-				interfaces = append(interfaces, ifaceName)
-			}
+	return parseIPLinkOutput(output), nil
+}
+
+// parseIPLinkOutput 解析ip link show输出
+// 接口行形如"2: eth0@if3: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500"，
+// 首个数字为ifindex，缩进的link/ether等续行忽略
+func parseIPLinkOutput(output string) []containerInterface {
+	var interfaces []containerInterface
+	for _, line := range strings.Split(output, "\n") {
+		if line == "" || line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		parts := strings.SplitN(line, ": ", 3)
+		if len(parts) < 2 {
+			continue
 		}
+		index, err := strconv.Atoi(parts[0])
+		if err != nil {
+			continue
+		}
+		name := strings.Split(parts[1], "@")[0]
+		interfaces = append(interfaces, containerInterface{Name: name, IfIndex: index})
 	}
-	
-	return interfaces, nil
+	return interfaces
 }
 
 // createVethPair 创建veth pair
 // 为容器接口创建对应的veth pair用于流量mirror
-func (tc *TCTrafficCapture) createVethPair(pid int, originalIface string, containerInfo *TCContainerInfo) (*VethPairInfo, error) {
-	log.WithField("interface", originalIface).Debug("Creating veth pair")
+func (tc *TCTrafficCapture) createVethPair(pid int, iface containerInterface, containerInfo *TCContainerInfo) (*VethPairInfo, error) {
+	originalIface := iface.Name
+	log.WithFields(log.Fields{"interface": originalIface, "ifindex": iface.IfIndex}).Debug("Creating veth pair")
 	
 	// 生成接口名称
 	internalName := fmt.Sprintf("nv-in-%s", originalIface)
@@ -282,7 +337,7 @@ func (tc *TCTrafficCapture) createVethPair(pid int, originalIface string, contai
 		return nil, fmt.Errorf("failed to get original MAC: %v", err)
 	}
 	
-	// 获取可用的接口索引
+	// 分配索引用于生成唯一MAC，ifindex在不同容器命名空间中会重复，不能用于此处
 	index := tc.getAvailableIndex()
 	
 	// 生成NeuVector MAC地址 (4e:65:75:56 - "NeuV")
@@ -301,16 +356,22 @@ func (tc *TCTrafficCapture) createVethPair(pid int, originalIface string, contai
 	
 	// 在容器命名空间中重命名原始接口
 	if err := tc.renameInterface(pid, originalIface, externalName); err != nil {
+		// 重命名失败时原接口可能已被关闭，重新启用
+		tc.runCommand(fmt.Sprintf("nsenter -t %d -n ip link set %s up", pid, originalIface))
+		tc.releaseIndex(index)
 		return nil, fmt.Errorf("failed to rename interface: %v", err)
 	}
 	
 	// 创建veth pair
 	if err := tc.createVethPairInNamespace(pid, originalIface, internalName, index); err != nil {
+		tc.rollbackVethPair(pid, originalIface, internalName, externalName, index)
 		return nil, fmt.Errorf("failed to create veth pair: %v", err)
 	}
 	
 	// 配置接口
-	if err := tc.configureVethPair(pid, originalIface, internalName, externalName, originalMAC, nvMAC); err != nil {
+	ipConfig, err := tc.configureVethPair(pid, originalIface, internalName, externalName, originalMAC, nvMAC)
+	if err != nil {
+		tc.rollbackVethPair(pid, originalIface, internalName, externalName, index)
 		return nil, fmt.Errorf("failed to configure veth pair: %v", err)
 	}
 	
@@ -322,32 +383,56 @@ func (tc *TCTrafficCapture) createVethPair(pid int, originalIface string, contai
 		NVMAC:        nvMAC,
 		BroadcastMAC: bcMAC,
 		Index:        index,
+		IfIndex:      iface.IfIndex,
+		IPConfig:     ipConfig,
 	}
 	
 	return vethPair, nil
 }
 
+// rollbackVethPair 原接口重命名后的步骤失败时回滚
+// 删除已创建的veth pair并将原接口恢复原名和启用，避免捕获失败导致容器网络中断
+func (tc *TCTrafficCapture) rollbackVethPair(pid int, originalName, internalName, externalName string, index uint) {
+	log.WithField("interface", originalName).Warn("Rolling back veth pair setup")
+	
+	// 删除一端会自动删除另一端，主机侧再删除一次以防容器内一端已不存在
+	tc.runCommand(fmt.Sprintf("nsenter -t %d -n ip link del %s", pid, originalName))
+	tc.runCommand(fmt.Sprintf("ip link del %s", internalName))
+	
+	commands := []string{
+		fmt.Sprintf("nsenter -t %d -n ip link set %s name %s", pid, externalName, originalName),
+		fmt.Sprintf("nsenter -t %d -n ip link set %s up", pid, originalName),
+	}
+	for _, cmd := range commands {
+		if err := tc.runCommand(cmd); err != nil {
+			log.WithFields(log.Fields{"cmd": cmd, "error": err}).Error("Failed to restore container interface")
+			break
+		}
+	}
+	tc.releaseIndex(index)
+}
+
 // getInterfaceMAC 获取接口MAC地址
 // 从容器网络命名空间获取接口MAC地址
 func (tc *TCTrafficCapture) getInterfaceMAC(pid int, iface string) (net.HardwareAddr, error) {
 	// 方法1: 尝试从/sys/class/net读取
 	cmd := fmt.Sprintf("nsenter -t %d -n cat /sys/class/net/%s/address", pid, iface)
-	output, err := exec.Command("sh", "-c", cmd).Output()
+	output, err := tc.executor.Run(cmd)
 	if err == nil {
-		macStr := strings.TrimSpace(string(output))
+		macStr := strings.TrimSpace(output)
 		return net.ParseMAC(macStr)
 	}
 	
 	// 方法2: 从ip link show解析MAC地址
 	cmd = fmt.Sprintf("nsenter -t %d -n ip link show %s", pid, iface)
-	output, err = exec.Command("sh", "-c", cmd).Output()
+	output, err = tc.executor.Run(cmd)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get interface info: %v", err)
 	}
 	
 	// 解析输出: "2: eth0@if12: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc noqueue state UP"
 	//          "    link/ether 56:7e:4d:73:ab:e8 brd ff:ff:ff:ff:ff:ff link-netnsid 0"
-	lines := strings.Split(string(output), "\n")
+	lines := strings.Split(output, "\n")
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
 		if strings.HasPrefix(line, "link/ether ") {
@@ -362,27 +447,34 @@ func (tc *TCTrafficCapture) getInterfaceMAC(pid int, iface string) (net.Hardware
 }
 
 // getAvailableIndex 获取可用的接口索引
-// 分配唯一的接口索引用于MAC地址生成
+// 分配唯一的接口索引用于MAC地址生成，索引与TC优先级各自分配，互不占用
 func (tc *TCTrafficCapture) getAvailableIndex() uint {
 	for i := uint(1); i < TC_PREF_MAX; i++ {
-		if !tc.prefs[i] {
-			tc.prefs[i] = true
+		if !tc.indexes[i] {
+			tc.indexes[i] = true
 			return i
 		}
 	}
 	return 0
 }
 
+// releaseIndex 释放未使用的接口索引
+func (tc *TCTrafficCapture) releaseIndex(index uint) {
+	if index != 0 {
+		delete(tc.indexes, index)
+	}
+}
+
 // renameInterface 重命名接口
 // 在容器命名空间中重命名网络接口
 func (tc *TCTrafficCapture) renameInterface(pid int, oldName, newName string) error {
 	cmd := fmt.Sprintf("nsenter -t %d -n ip link set %s down", pid, oldName)
-	if err := tc.executeCommand(cmd); err != nil {
+	if err := tc.runCommand(cmd); err != nil {
 		return err
 	}
 	
 	cmd = fmt.Sprintf("nsenter -t %d -n ip link set %s name %s", pid, oldName, newName)
-	return tc.executeCommand(cmd)
+	return tc.runCommand(cmd)
 }
 
 // createVethPairInNamespace 在命名空间中创建veth pair
@@ -391,22 +483,22 @@ func (tc *TCTrafficCapture) createVethPairInNamespace(pid int, localName, peerNa
 	// 在容器命名空间中创建veth pair
 	cmd := fmt.Sprintf("nsenter -t %d -n ip link add %s type veth peer name %s", 
 		pid, localName, peerName)
-	if err := tc.executeCommand(cmd); err != nil {
+	if err := tc.runCommand(cmd); err != nil {
 		return err
 	}
 	
 	// 将peer接口移动到主机网络命名空间
 	cmd = fmt.Sprintf("nsenter -t %d -n ip link set %s netns 1", pid, peerName)
-	return tc.executeCommand(cmd)
+	return tc.runCommand(cmd)
 }
 
 // configureVethPair 配置veth pair
-// 设置MAC地址、IP配置和bridge连接
+// 设置MAC地址、IP配置和bridge连接，返回迁移到新接口的IP配置
 func (tc *TCTrafficCapture) configureVethPair(pid int, localName, peerName, externalName string, 
-	originalMAC, nvMAC net.HardwareAddr) error {
+	originalMAC, nvMAC net.HardwareAddr) (*IPConfig, error) {
 	
 	// 获取原始接口的IP配置
-	ipConfig, err := tc.getInterfaceIPConfig(pid, externalName)
+	ipConfig, err := tc.ipConfigFn(pid, externalName)
 	if err != nil {
 		log.WithError(err).Warn("Failed to get IP config, network may not work properly")
 	}
@@ -449,19 +541,19 @@ func (tc *TCTrafficCapture) configureVethPair(pid int, localName, peerName, exte
 	
 	// 执行容器内命令
 	for _, cmd := range commands {
-		if err := tc.executeCommand(cmd); err != nil {
+		if err := tc.runCommand(cmd); err != nil {
 			log.WithFields(log.Fields{"cmd": cmd, "error": err}).Warn("Container command failed")
 		}
 	}
 	
 	// 执行主机命令
 	for _, cmd := range hostCommands {
-		if err := tc.executeCommand(cmd); err != nil {
+		if err := tc.runCommand(cmd); err != nil {
 			log.WithFields(log.Fields{"cmd": cmd, "error": err}).Warn("Host command failed")
 		}
 	}
 	
-	return nil
+	return ipConfig, nil
 }
 
 // setupTCRules 设置Traffic Control规则
@@ -483,12 +575,14 @@ func (tc *TCTrafficCapture) setupTCRules(vethPair *VethPairInfo, containerInfo *
 	}
 	
 	tc.portMap[vethPair.InternalName] = &TCPortInfo{
-		Index: vethPair.Index,
-		Pref:  pref,
+		Index:   vethPair.Index,
+		IfIndex: vethPair.IfIndex,
+		Pref:    pref,
 	}
 	tc.portMap[vethPair.ExternalName] = &TCPortInfo{
-		Index: vethPair.Index,
-		Pref:  pref,
+		Index:   vethPair.Index,
+		IfIndex: vethPair.IfIndex,
+		Pref:    pref,
 	}
 	
 	// 设置容器内的TC规则（外部→内部）
@@ -530,7 +624,7 @@ func (tc *TCTrafficCapture) setupTCRules(vethPair *VethPairInfo, containerInfo *
 	allRules = append(allRules, bridgeRules...)
 	
 	for _, rule := range allRules {
-		if err := tc.executeCommand(rule); err != nil {
+		if err := tc.runCommand(rule); err != nil {
 			log.WithFields(log.Fields{"rule": rule, "error": err}).Warn("Failed to add TC rule")
 		} else {
 			containerInfo.TCRules = append(containerInfo.TCRules, rule)
@@ -574,10 +668,13 @@ func (tc *TCTrafficCapture) StopContainerCapture(containerID string) error {
 	
 	log.WithField("container", containerInfo.Name).Info("Stopping TC-based container traffic capture")
 	
-	// 删除TC规则
+	// 删除TC规则，暂停时mirror规则已删除
 	for _, rule := range containerInfo.TCRules {
+		if containerInfo.Paused && isBridgeMirrorRule(rule) {
+			continue
+		}
 		deleteRule := strings.Replace(rule, "add", "del", 1)
-		if err := tc.executeCommand(deleteRule); err != nil {
+		if err := tc.runCommand(deleteRule); err != nil {
 			log.WithFields(log.Fields{"rule": deleteRule, "error": err}).Warn("Failed to delete TC rule")
 		}
 	}
@@ -590,15 +687,15 @@ func (tc *TCTrafficCapture) StopContainerCapture(containerID string) error {
 	// 释放优先级
 	for ifaceName, vethPair := range containerInfo.VethPairs {
 		if portInfo, exists := tc.portMap[vethPair.InternalName]; exists {
-			tc.prefs[portInfo.Pref] = false
+			delete(tc.prefs, portInfo.Pref)
 			delete(tc.portMap, vethPair.InternalName)
 		}
 		if portInfo, exists := tc.portMap[vethPair.ExternalName]; exists {
-			tc.prefs[portInfo.Pref] = false
+			delete(tc.prefs, portInfo.Pref)
 			delete(tc.portMap, vethPair.ExternalName)
 		}
 		// 释放接口索引
-		tc.prefs[vethPair.Index] = false
+		tc.releaseIndex(vethPair.Index)
 		_ = ifaceName // 避免未使用变量警告
 	}
 	
@@ -608,6 +705,72 @@ func (tc *TCTrafficCapture) StopContainerCapture(containerID string) error {
 	return nil
 }
 
+// PauseContainer 暂停捕获容器流量
+// 只删除到NV bridge的mirror规则，保留veth pair和容器内转发规则，避免网络中断
+func (tc *TCTrafficCapture) PauseContainer(containerID string) error {
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	containerInfo, exists := tc.containers[containerID]
+	if !exists {
+		return fmt.Errorf("container %s not found", containerID)
+	}
+	if containerInfo.Paused {
+		return nil
+	}
+
+	for _, rule := range containerInfo.TCRules {
+		if !isBridgeMirrorRule(rule) {
+			continue
+		}
+		deleteRule := strings.Replace(rule, "add", "del", 1)
+		if err := tc.runCommand(deleteRule); err != nil {
+			log.WithFields(log.Fields{"rule": deleteRule, "error": err}).Warn("Failed to delete TC mirror rule")
+		}
+	}
+	containerInfo.Paused = true
+
+	log.WithField("container", containerInfo.Name).Info("Container traffic capture paused")
+	return nil
+}
+
+// ResumeContainer 恢复捕获容器流量
+// 重新添加暂停时删除的mirror规则
+func (tc *TCTrafficCapture) ResumeContainer(containerID string) error {
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	containerInfo, exists := tc.containers[containerID]
+	if !exists {
+		return fmt.Errorf("container %s not found", containerID)
+	}
+	if !containerInfo.Paused {
+		return nil
+	}
+
+	var firstErr error
+	for _, rule := range containerInfo.TCRules {
+		if !isBridgeMirrorRule(rule) {
+			continue
+		}
+		if err := tc.runCommand(rule); err != nil {
+			log.WithFields(log.Fields{"rule": rule, "error": err}).Warn("Failed to restore TC mirror rule")
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to restore mirror rule: %v", err)
+			}
+		}
+	}
+	containerInfo.Paused = false
+
+	log.WithField("container", containerInfo.Name).Info("Container traffic capture resumed")
+	return firstErr
+}
+
+// isBridgeMirrorRule 检查是否为mirror到NV bridge的规则
+func isBridgeMirrorRule(rule string) bool {
+	return strings.HasSuffix(rule, "mirror dev "+NV_BRIDGE_NAME)
+}
+
 // cleanupVethPair 清理veth pair
 // 删除qdisc和veth pair接口
 func (tc *TCTrafficCapture) cleanupVethPair(vethPair *VethPairInfo) {
@@ -617,7 +780,7 @@ func (tc *TCTrafficCapture) cleanupVethPair(vethPair *VethPairInfo) {
 	
 	// 删除veth pair（删除一端会自动删除另一端）
 	cmd := fmt.Sprintf("ip link del %s", vethPair.InternalName)
-	tc.executeCommand(cmd)
+	tc.runCommand(cmd)
 }
 
 // IPConfig 接口IP配置信息
@@ -626,20 +789,97 @@ type IPConfig struct {
 	Gateway string // 网关地址
 }
 
+// GetContainerIPConfigs 获取已捕获容器各接口的IP配置
+// 返回接口名到IP配置的映射，容器未捕获时返回nil
+func (tc *TCTrafficCapture) GetContainerIPConfigs(containerID string) map[string]*IPConfig {
+	tc.mutex.RLock()
+	defer tc.mutex.RUnlock()
+	
+	info, ok := tc.containers[containerID]
+	if !ok {
+		return nil
+	}
+	configs := make(map[string]*IPConfig, len(info.VethPairs))
+	for name, vethPair := range info.VethPairs {
+		if vethPair.IPConfig != nil {
+			cfg := *vethPair.IPConfig
+			configs[name] = &cfg
+		}
+	}
+	return configs
+}
+
+// CheckIPChanges 检查已捕获容器接口的IP是否变化
+// 变化时只刷新保存的IP配置，不重建veth pair，返回IP发生变化的容器ID
+func (tc *TCTrafficCapture) CheckIPChanges() []string {
+	type ifaceRef struct {
+		containerID string
+		pid         int
+		name        string
+		old         IPConfig
+	}
+	
+	// 复制快照，查询接口时不持有锁
+	tc.mutex.RLock()
+	var refs []ifaceRef
+	for id, info := range tc.containers {
+		for name, vethPair := range info.VethPairs {
+			ref := ifaceRef{containerID: id, pid: info.Pid, name: name}
+			if vethPair.IPConfig != nil {
+				ref.old = *vethPair.IPConfig
+			}
+			refs = append(refs, ref)
+		}
+	}
+	tc.mutex.RUnlock()
+	
+	changed := make(map[string]bool)
+	for _, ref := range refs {
+		cfg, err := tc.ipConfigFn(ref.pid, ref.name)
+		if err != nil || *cfg == ref.old {
+			continue
+		}
+		
+		tc.mutex.Lock()
+		if info, ok := tc.containers[ref.containerID]; ok {
+			if vethPair, ok := info.VethPairs[ref.name]; ok {
+				vethPair.IPConfig = cfg
+				changed[ref.containerID] = true
+				log.WithFields(log.Fields{
+					"container": info.Name, "interface": ref.name,
+					"old_ip": ref.old.IPAddr, "new_ip": cfg.IPAddr,
+				}).Info("Container interface IP changed")
+			}
+		}
+		tc.mutex.Unlock()
+	}
+	
+	ids := make([]string, 0, len(changed))
+	for id := range changed {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
 // getInterfaceIPConfig 获取接口的IP配置
-// 解析容器接口的IP地址和网关信息
 func (tc *TCTrafficCapture) getInterfaceIPConfig(pid int, iface string) (*IPConfig, error) {
+	return interfaceIPConfig(tc.executor, pid, iface)
+}
+
+// interfaceIPConfig 获取容器接口的IP配置
+// 解析容器接口的IP地址和网关信息
+func interfaceIPConfig(executor Executor, pid int, iface string) (*IPConfig, error) {
 	config := &IPConfig{}
 	
 	// 获取IP地址
 	cmd := fmt.Sprintf("nsenter -t %d -n ip addr show %s", pid, iface)
-	output, err := exec.Command("sh", "-c", cmd).Output()
+	output, err := executor.Run(cmd)
 	if err != nil {
 		return nil, err
 	}
 	
 	// 解析IP地址: "inet 172.17.0.2/16 brd 172.17.255.255 scope global nv-ex-eth0"
-	lines := strings.Split(string(output), "\n")
+	lines := strings.Split(output, "\n")
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
 		if strings.HasPrefix(line, "inet ") && !strings.Contains(line, "127.0.0.1") {
@@ -653,10 +893,10 @@ func (tc *TCTrafficCapture) getInterfaceIPConfig(pid int, iface string) (*IPConf
 	
 	// 获取默认路由
 	cmd = fmt.Sprintf("nsenter -t %d -n ip route show default", pid)
-	output, err = exec.Command("sh", "-c", cmd).Output()
+	output, err = executor.Run(cmd)
 	if err == nil {
 		// 解析默认路由: "default via 172.17.0.1 dev nv-ex-eth0"
-		line := strings.TrimSpace(string(output))
+		line := strings.TrimSpace(output)
 		if strings.HasPrefix(line, "default via ") {
 			parts := strings.Fields(line)
 			if len(parts) >= 3 {
@@ -671,17 +911,34 @@ func (tc *TCTrafficCapture) getInterfaceIPConfig(pid int, iface string) (*IPConf
 	
 	return config, nil
 }
+// ValidateSetup 检查TC方式依赖的tc、ip、nsenter命令
+// ethtool缺失时只告警，无法关闭网卡offload
+func (tc *TCTrafficCapture) ValidateSetup() error {
+	err := checkCommands(tc.executor, []commandCheck{
+		{"tc", "tc -Version"},
+		{"ip", "ip -Version"},
+		{"nsenter", "nsenter --version"},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := tc.executor.Run("ethtool --version"); err != nil {
+		log.Warn("ethtool not available, network offload features cannot be disabled")
+	}
+	return nil
+}
+
 // cleanupContainerInterfaces 清理容器接口
 // 删除容器和主机侧的nv-开头接口
 func (tc *TCTrafficCapture) cleanupContainerInterfaces(pid int) {
 	// 清理容器中的nv-接口
 	cmd := fmt.Sprintf("nsenter -t %d -n ip link show", pid)
-	output, err := exec.Command("sh", "-c", cmd).Output()
+	output, err := tc.executor.Run(cmd)
 	if err != nil {
 		return
 	}
 	
-	lines := strings.Split(string(output), "\n")
+	lines := strings.Split(output, "\n")
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
 		if strings.Contains(line, ": nv-") && !strings.HasPrefix(line, " ") {
@@ -691,18 +948,18 @@ func (tc *TCTrafficCapture) cleanupContainerInterfaces(pid int) {
 				ifaceName := strings.Split(parts[1], "@")[0]
 				// 删除nv-开头的接口
 				deleteCmd := fmt.Sprintf("nsenter -t %d -n ip link del %s", pid, ifaceName)
-				tc.executeCommand(deleteCmd) // 忽略错误
+				tc.runCommand(deleteCmd) // 忽略错误
 			}
 		}
 	}
 	
 	// 清理主机侧的nv-接口
-	hostOutput, err := exec.Command("ip", "link", "show").Output()
+	hostOutput, err := tc.executor.Run("ip link show")
 	if err != nil {
 		return
 	}
 	
-	hostLines := strings.Split(string(hostOutput), "\n")
+	hostLines := strings.Split(hostOutput, "\n")
 	for _, line := range hostLines {
 		line = strings.TrimSpace(line)
 		if strings.Contains(line, ": nv-") && !strings.HasPrefix(line, " ") {
@@ -712,24 +969,58 @@ func (tc *TCTrafficCapture) cleanupContainerInterfaces(pid int) {
 				// 删除nv-开头的接口（除了nv-br）
 				if ifaceName != NV_BRIDGE_NAME {
 					deleteCmd := fmt.Sprintf("ip link del %s", ifaceName)
-					tc.executeCommand(deleteCmd) // 忽略错误
+					tc.runCommand(deleteCmd) // 忽略错误
 				}
 			}
 		}
 	}
 }
+// runCommand 执行命令并按类别计数
+func (tc *TCTrafficCapture) runCommand(command string) error {
+	err := tc.executeCommand(command)
+	counter := &tc.cmdCounters[commandCategory(command)]
+	if err != nil {
+		counter.failed.Add(1)
+	} else {
+		counter.success.Add(1)
+	}
+	return err
+}
+
+// commandCategory 根据命令内容判断类别
+func commandCategory(command string) int {
+	switch {
+	case strings.Contains(command, "tc qdisc "):
+		return commandQDisc
+	case strings.Contains(command, "tc filter "):
+		return commandFilter
+	default:
+		return commandLink
+	}
+}
+
+// CommandStats 获取各类命令的成功和失败次数
+func (tc *TCTrafficCapture) CommandStats() map[string]CommandStats {
+	stats := make(map[string]CommandStats, numCommandCategories)
+	for i, name := range commandCategoryNames {
+		stats[name] = CommandStats{
+			Success: tc.cmdCounters[i].success.Load(),
+			Failed:  tc.cmdCounters[i].failed.Load(),
+		}
+	}
+	return stats
+}
+
 // executeCommand 执行系统命令
 // 执行TC和网络配置命令并记录日志
 func (tc *TCTrafficCapture) executeCommand(command string) error {
 	log.WithField("cmd", command).Debug("Executing TC command")
 	
-	cmd := exec.Command("sh", "-c", command)
-	output, err := cmd.CombinedOutput()
-	
+	output, err := tc.executor.Run(command)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"cmd":    command,
-			"output": string(output),
+			"output": output,
 			"error":  err,
 		}).Debug("TC command execution failed")
 		return err
@@ -0,0 +1,357 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+// fakeExecutor 记录命令并返回预设输出的执行器
+type fakeExecutor struct {
+	cmds    []string
+	outputs map[string]string     // 命令对应的输出
+	fail    func(cmd string) bool // 返回true时命令失败
+}
+
+// Run 记录命令，返回预设输出
+func (f *fakeExecutor) Run(cmd string) (string, error) {
+	f.cmds = append(f.cmds, cmd)
+	if f.fail != nil && f.fail(cmd) {
+		return "", fmt.Errorf("exit status 2")
+	}
+	return f.outputs[cmd], nil
+}
+
+// newTestCapture 创建记录命令而不执行的流量捕获器
+func newTestCapture() (*TCTrafficCapture, *fakeExecutor) {
+	exec := &fakeExecutor{outputs: make(map[string]string)}
+	tc := &TCTrafficCapture{
+		containers: make(map[string]*TCContainerInfo),
+		prefs:      make(map[uint]bool),
+		indexes:    make(map[uint]bool),
+		portMap:    make(map[string]*TCPortInfo),
+		executor:   exec,
+	}
+	tc.ipConfigFn = tc.getInterfaceIPConfig
+	return tc, exec
+}
+
+// filterCmds 筛选包含指定内容的命令
+func filterCmds(cmds []string, substr string) []string {
+	var result []string
+	for _, cmd := range cmds {
+		if strings.Contains(cmd, substr) {
+			result = append(result, cmd)
+		}
+	}
+	return result
+}
+
+func TestPauseResumeCapture(t *testing.T) {
+	tc, exec := newTestCapture()
+	cmds := &exec.cmds
+
+	const id = "3f4e5d6c7b8a9f0e1d2c3b4a"
+	info := &TCContainerInfo{ID: id, Name: "web", Pid: 1234, VethPairs: make(map[string]*VethPairInfo)}
+	vethPair := &VethPairInfo{
+		OriginalName: "eth0",
+		InternalName: "vin1",
+		ExternalName: "vex1",
+		NVMAC:        net.HardwareAddr{0x4e, 0x65, 0x75, 0x56, 0x00, 0x01},
+		Index:        1,
+	}
+	info.VethPairs["eth0"] = vethPair
+	if err := tc.setupTCRules(vethPair, info); err != nil {
+		t.Fatalf("setupTCRules failed: %v", err)
+	}
+	tc.containers[id] = info
+	rules := len(info.TCRules)
+
+	// 暂停只删除到NV bridge的mirror规则
+	*cmds = nil
+	if err := tc.PauseContainer(id); err != nil {
+		t.Fatalf("Pause failed: %v", err)
+	}
+	if !info.Paused {
+		t.Errorf("Paused flag not set")
+	}
+	if len(*cmds) != 1 || !strings.HasPrefix((*cmds)[0], "tc filter del dev vin1") ||
+		!strings.HasSuffix((*cmds)[0], "mirror dev "+NV_BRIDGE_NAME) {
+		t.Errorf("Unexpected pause commands: %v", *cmds)
+	}
+	if len(info.TCRules) != rules || len(info.VethPairs) != 1 {
+		t.Errorf("Capture state changed on pause: rules=%d veths=%d", len(info.TCRules), len(info.VethPairs))
+	}
+
+	// 重复暂停无操作
+	*cmds = nil
+	tc.PauseContainer(id)
+	if len(*cmds) != 0 {
+		t.Errorf("Unexpected commands on repeated pause: %v", *cmds)
+	}
+
+	// 恢复重新添加mirror规则
+	if err := tc.ResumeContainer(id); err != nil {
+		t.Fatalf("Resume failed: %v", err)
+	}
+	if info.Paused {
+		t.Errorf("Paused flag not cleared")
+	}
+	if len(*cmds) != 1 || !strings.HasPrefix((*cmds)[0], "tc filter add dev vin1") {
+		t.Errorf("Unexpected resume commands: %v", *cmds)
+	}
+	if del := filterCmds(*cmds, "ip link del"); len(del) != 0 {
+		t.Errorf("Veth removed: %v", del)
+	}
+
+	// 暂停后停止不重复删除mirror规则
+	tc.PauseContainer(id)
+	*cmds = nil
+	if err := tc.StopContainerCapture(id); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	if mirror := filterCmds(*cmds, "mirror dev "+NV_BRIDGE_NAME); len(mirror) != 0 {
+		t.Errorf("Paused mirror rule deleted again: %v", mirror)
+	}
+
+	if err := tc.PauseContainer("unknown"); err == nil {
+		t.Errorf("Expected error for unknown container")
+	}
+}
+
+func TestParseIPLinkOutput(t *testing.T) {
+	output := `1: lo: <LOOPBACK,UP,LOWER_UP> mtu 65536 qdisc noqueue state UNKNOWN mode DEFAULT group default qlen 1000
+    link/loopback 00:00:00:00:00:00 brd 00:00:00:00:00:00
+12: eth0@if13: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc noqueue state UP mode DEFAULT group default
+    link/ether 02:42:ac:11:00:02 brd ff:ff:ff:ff:ff:ff link-netnsid 0
+27: eth1@if28: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1450 qdisc noqueue state UP mode DEFAULT group default
+    link/ether 0a:58:0a:f4:01:05 brd ff:ff:ff:ff:ff:ff link-netnsid 1
+`
+	ifaces := parseIPLinkOutput(output)
+	want := []containerInterface{
+		{Name: "lo", IfIndex: 1},
+		{Name: "eth0", IfIndex: 12},
+		{Name: "eth1", IfIndex: 27},
+	}
+	if len(ifaces) != len(want) {
+		t.Fatalf("Expected %d interfaces, got %v", len(want), ifaces)
+	}
+	for i := range want {
+		if ifaces[i] != want[i] {
+			t.Errorf("Interface %d: expected %+v, got %+v", i, want[i], ifaces[i])
+		}
+	}
+
+	if ifaces := parseIPLinkOutput("garbage: line\n\n"); len(ifaces) != 0 {
+		t.Errorf("Expected no interfaces, got %v", ifaces)
+	}
+}
+
+func TestCommandStats(t *testing.T) {
+	tc, exec := newTestCapture()
+	exec.fail = func(cmd string) bool {
+		return strings.Contains(cmd, "dev vin1") || strings.Contains(cmd, "master")
+	}
+
+	info := &TCContainerInfo{ID: "c1", Name: "web", Pid: 1234, VethPairs: make(map[string]*VethPairInfo)}
+	vethPair := &VethPairInfo{
+		OriginalName: "eth0",
+		InternalName: "vin1",
+		ExternalName: "vex1",
+		NVMAC:        net.HardwareAddr{0x4e, 0x65, 0x75, 0x56, 0x00, 0x01},
+		Index:        1,
+	}
+	tc.setupTCRules(vethPair, info)
+	tc.runCommand("ip link set vex1 master " + NV_BRIDGE_NAME)
+	tc.runCommand("ip link set vex1 up")
+
+	stats := tc.CommandStats()
+	filters := stats["filter"]
+	if filters.Success != 3 || filters.Failed != 1 {
+		t.Errorf("Unexpected filter stats: %+v", filters)
+	}
+	if len(info.TCRules) != int(filters.Success) {
+		t.Errorf("Recorded rules %d != successful filters %d", len(info.TCRules), filters.Success)
+	}
+	if q := stats["qdisc"]; q.Success != 2 || q.Failed != 1 {
+		t.Errorf("Unexpected qdisc stats: %+v", q)
+	}
+	if l := stats["link"]; l.Success != 1 || l.Failed != 1 {
+		t.Errorf("Unexpected link stats: %+v", l)
+	}
+}
+
+func TestStartContainerCaptureCommands(t *testing.T) {
+	tc, exec := newTestCapture()
+	tc.bridgeReady = true
+	exec.outputs = map[string]string{
+		"nsenter -t 1234 -n ip link show": `1: lo: <LOOPBACK,UP,LOWER_UP> mtu 65536 qdisc noqueue state UNKNOWN
+    link/loopback 00:00:00:00:00:00 brd 00:00:00:00:00:00
+12: eth0@if13: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc noqueue state UP
+    link/ether 02:42:ac:11:00:02 brd ff:ff:ff:ff:ff:ff link-netnsid 0
+`,
+		"ip link show": `3: nv-br: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc noqueue state UP
+40: nv-in-eth0@if41: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc noqueue master nv-br state UP
+`,
+		"nsenter -t 1234 -n cat /sys/class/net/eth0/address": "02:42:ac:11:00:02\n",
+		"nsenter -t 1234 -n ip addr show nv-ex-eth0":         "    inet 172.17.0.2/16 brd 172.17.255.255 scope global nv-ex-eth0\n",
+		"nsenter -t 1234 -n ip route show default":           "default via 172.17.0.1 dev nv-ex-eth0\n",
+	}
+
+	const id = "3f4e5d6c7b8a9f0e1d2c3b4a"
+	if err := tc.StartContainerCapture(id, "web", 1234); err != nil {
+		t.Fatalf("StartContainerCapture failed: %v", err)
+	}
+
+	info := tc.containers[id]
+	if info == nil || len(info.VethPairs) != 1 {
+		t.Fatalf("Unexpected capture state: %+v", info)
+	}
+	veth := info.VethPairs["eth0"]
+	if veth.IfIndex != 12 || veth.OriginalMAC.String() != "02:42:ac:11:00:02" || veth.IPConfig.IPAddr != "172.17.0.2/16" {
+		t.Errorf("Unexpected veth pair: %+v", veth)
+	}
+
+	// 按顺序出现的关键命令
+	want := []string{
+		"ip link del nv-in-eth0",
+		"nsenter -t 1234 -n ip link set eth0 down",
+		"nsenter -t 1234 -n ip link set eth0 name nv-ex-eth0",
+		"nsenter -t 1234 -n ip link add eth0 type veth peer name nv-in-eth0",
+		"nsenter -t 1234 -n ip link set nv-in-eth0 netns 1",
+		"nsenter -t 1234 -n ip addr del 172.17.0.2/16 dev nv-ex-eth0",
+		"nsenter -t 1234 -n ip addr add 172.17.0.2/16 dev eth0",
+		"nsenter -t 1234 -n ip route add default via 172.17.0.1 dev eth0",
+		"ip link set nv-in-eth0 master " + NV_BRIDGE_NAME,
+		"nsenter -t 1234 -n tc qdisc add dev eth0 ingress",
+		"tc qdisc add dev nv-in-eth0 ingress",
+		"tc filter add dev nv-in-eth0 pref",
+	}
+	next := 0
+	for _, cmd := range exec.cmds {
+		if next < len(want) && strings.HasPrefix(cmd, want[next]) {
+			next++
+		}
+	}
+	if next != len(want) {
+		t.Errorf("Missing command %q in sequence:\n%s", want[next], strings.Join(exec.cmds, "\n"))
+	}
+	if del := filterCmds(exec.cmds, "ip link del "+NV_BRIDGE_NAME); len(del) != 0 {
+		t.Errorf("NV bridge removed: %v", del)
+	}
+	if len(info.TCRules) != 4 {
+		t.Errorf("Expected 4 TC rules, got %v", info.TCRules)
+	}
+}
+
+func TestCreateVethPairRollback(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		failOn   string
+		want     []string // 依次出现的回滚命令
+		unwanted string   // 不应出现的命令
+	}{
+		{
+			name:   "veth add fails",
+			failOn: "ip link add eth0 type veth",
+			want: []string{
+				"nsenter -t 1234 -n ip link del eth0",
+				"ip link del nv-in-eth0",
+				"nsenter -t 1234 -n ip link set nv-ex-eth0 name eth0",
+				"nsenter -t 1234 -n ip link set eth0 up",
+			},
+		},
+		{
+			name:   "netns move fails",
+			failOn: "ip link set nv-in-eth0 netns 1",
+			want: []string{
+				"nsenter -t 1234 -n ip link del eth0",
+				"nsenter -t 1234 -n ip link set nv-ex-eth0 name eth0",
+				"nsenter -t 1234 -n ip link set eth0 up",
+			},
+		},
+		{
+			// 重命名失败时原接口仍在，只需重新启用，不能删除
+			name:     "rename fails",
+			failOn:   "ip link set eth0 name nv-ex-eth0",
+			want:     []string{"nsenter -t 1234 -n ip link set eth0 up"},
+			unwanted: "ip link del eth0",
+		},
+	} {
+		capture, exec := newTestCapture()
+		exec.outputs["nsenter -t 1234 -n cat /sys/class/net/eth0/address"] = "02:42:ac:11:00:02\n"
+		exec.fail = func(cmd string) bool { return strings.Contains(cmd, tc.failOn) }
+
+		info := &TCContainerInfo{Pid: 1234, VethPairs: make(map[string]*VethPairInfo)}
+		if _, err := capture.createVethPair(1234, containerInterface{Name: "eth0", IfIndex: 12}, info); err == nil {
+			t.Errorf("%s: expected error", tc.name)
+			continue
+		}
+
+		var failedAt int
+		for i, cmd := range exec.cmds {
+			if strings.Contains(cmd, tc.failOn) {
+				failedAt = i
+			}
+		}
+		next := 0
+		for _, cmd := range exec.cmds[failedAt+1:] {
+			if next < len(tc.want) && cmd == tc.want[next] {
+				next++
+			}
+		}
+		if next != len(tc.want) {
+			t.Errorf("%s: missing rollback command %q in:\n%s", tc.name, tc.want[next], strings.Join(exec.cmds, "\n"))
+		}
+		if tc.unwanted != "" && len(filterCmds(exec.cmds, tc.unwanted)) != 0 {
+			t.Errorf("%s: unexpected command %q", tc.name, tc.unwanted)
+		}
+		if len(capture.indexes) != 0 || len(capture.prefs) != 0 {
+			t.Errorf("%s: index not released: %v %v", tc.name, capture.indexes, capture.prefs)
+		}
+	}
+}
+
+func TestIndexPrefSeparate(t *testing.T) {
+	capture, _ := newTestCapture()
+
+	// 接口索引与TC优先级取相同的值互不影响
+	index := capture.getAvailableIndex()
+	if pref := capture.getAvailablePref(index); pref != index {
+		t.Errorf("Pref for port index %d collided: got %d", index, pref)
+	}
+	capture.releaseIndex(index)
+	if !capture.prefs[index] {
+		t.Errorf("Releasing index %d freed pref", index)
+	}
+
+	// 耗尽接口索引，TC优先级仍可分配
+	for i := uint(1); i < TC_PREF_MAX-1; i++ {
+		capture.indexes[i] = true
+	}
+	if got := capture.getAvailableIndex(); got != TC_PREF_MAX-1 {
+		t.Fatalf("Unexpected index %d, want %d", got, TC_PREF_MAX-1)
+	}
+	if got := capture.getAvailableIndex(); got != 0 {
+		t.Errorf("Index allocated after exhaustion: %d", got)
+	}
+	if pref := capture.getAvailablePref(7); pref != 7 {
+		t.Errorf("Pref affected by exhausted indexes: %d", pref)
+	}
+
+	// 耗尽TC优先级，释放的接口索引仍可分配
+	capture, _ = newTestCapture()
+	for i := uint(1); i < TC_PREF_MAX-1; i++ {
+		capture.prefs[i] = true
+	}
+	if pref := capture.getAvailablePref(1); pref != TC_PREF_MAX-1 {
+		t.Fatalf("Unexpected pref %d, want %d", pref, TC_PREF_MAX-1)
+	}
+	if pref := capture.getAvailablePref(1); pref != 0 {
+		t.Errorf("Pref allocated after exhaustion: %d", pref)
+	}
+	if got := capture.getAvailableIndex(); got != 1 {
+		t.Errorf("Index affected by exhausted prefs: %d", got)
+	}
+}
@@ -4,7 +4,6 @@ package network
 import (
 	"fmt"
 	"net"
-	"os/exec"
 	"strings"
 	"sync"
 
@@ -26,6 +25,7 @@ type TrafficCapture struct {
 	containers  map[string]*ContainerNetInfo // 容器网络信息
 	nfqueueNum  int                          // NFQUEUE队列号
 	dpConnected bool                         // DP连接状态
+	executor    Executor                     // 命令执行器，测试时可替换
 }
 
 // ContainerNetInfo 容器网络信息
@@ -44,6 +44,7 @@ type IfaceInfo struct {
 	IPs     []net.IP         // IP地址列表
 	Peer    string           // veth peer接口名称
 	InHost  bool             // 是否在主机命名空间
+	IPConfig *IPConfig       // IP配置（含掩码和网关），用于上报工作负载地址
 }
 
 // NewTrafficCapture 创建流量捕获管理器
@@ -51,6 +52,7 @@ func NewTrafficCapture() *TrafficCapture {
 	tc := &TrafficCapture{
 		containers: make(map[string]*ContainerNetInfo),
 		nfqueueNum: DEFAULT_NFQUEUE_NUM,
+		executor:   shellExecutor{},
 	}
 	
 	// 初始化iptables链
@@ -108,7 +110,9 @@ func (tc *TrafficCapture) StartContainerCapture(containerID, containerName strin
 	
 	// 为每个接口设置NFQUEUE规则
 	for ifaceName, iface := range netInfo.Interfaces {
-		if err := tc.setupNFQueueRules(ifaceName, iface, pid); err != nil {
+		rules, err := tc.setupNFQueueRules(ifaceName, iface, pid)
+		netInfo.Rules = append(netInfo.Rules, rules...)
+		if err != nil {
 			log.WithError(err).WithField("interface", ifaceName).Error("Failed to setup NFQUEUE rules")
 			continue
 		}
@@ -129,6 +133,11 @@ func (tc *TrafficCapture) StopContainerCapture(containerID string) error {
 	tc.mutex.Lock()
 	defer tc.mutex.Unlock()
 	
+	return tc.stopContainerLocked(containerID)
+}
+
+// stopContainerLocked 删除容器的iptables规则，调用方需持有tc.mutex
+func (tc *TrafficCapture) stopContainerLocked(containerID string) error {
 	netInfo, exists := tc.containers[containerID]
 	if !exists {
 		return fmt.Errorf("container %s not found", containerID)
@@ -163,13 +172,13 @@ func (tc *TrafficCapture) getContainerNetworkInfo(containerID, containerName str
 	
 	// 进入容器网络命名空间获取接口信息
 	cmd := fmt.Sprintf("nsenter -t %d -n ip link show", pid)
-	output, err := exec.Command("sh", "-c", cmd).Output()
+	output, err := tc.executor.Run(cmd)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get container interfaces: %v", err)
 	}
 	
 	// 解析网络接口
-	interfaces := tc.parseNetworkInterfaces(string(output))
+	interfaces := tc.parseNetworkInterfaces(output)
 	for _, iface := range interfaces {
 		// 跳过loopback接口
 		if iface.Name == "lo" {
@@ -182,6 +191,9 @@ func (tc *TrafficCapture) getContainerNetworkInfo(containerID, containerName str
 			log.WithError(err).WithField("interface", iface.Name).Warn("Failed to get interface IPs")
 		}
 		iface.IPs = ips
+		if cfg, err := interfaceIPConfig(tc.executor, pid, iface.Name); err == nil {
+			iface.IPConfig = cfg
+		}
 		
 		netInfo.Interfaces[iface.Name] = iface
 	}
@@ -222,13 +234,13 @@ func (tc *TrafficCapture) parseNetworkInterfaces(output string) []*IfaceInfo {
 // getInterfaceIPs 获取接口IP地址
 func (tc *TrafficCapture) getInterfaceIPs(pid int, ifaceName string) ([]net.IP, error) {
 	cmd := fmt.Sprintf("nsenter -t %d -n ip addr show %s", pid, ifaceName)
-	output, err := exec.Command("sh", "-c", cmd).Output()
+	output, err := tc.executor.Run(cmd)
 	if err != nil {
 		return nil, err
 	}
 	
 	var ips []net.IP
-	lines := strings.Split(string(output), "\n")
+	lines := strings.Split(output, "\n")
 	
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
@@ -257,7 +269,8 @@ func (tc *TrafficCapture) getInterfaceMAC(ifaceName string) (net.HardwareAddr, e
 }
 
 // setupNFQueueRules 设置NFQUEUE规则
-func (tc *TrafficCapture) setupNFQueueRules(ifaceName string, iface *IfaceInfo, pid int) error {
+// 返回已成功添加的规则，用于停止捕获时删除
+func (tc *TrafficCapture) setupNFQueueRules(ifaceName string, iface *IfaceInfo, pid int) ([]string, error) {
 	log.WithField("interface", ifaceName).Debug("Setting up NFQUEUE rules")
 	
 	// 在容器网络命名空间中设置规则
@@ -271,31 +284,26 @@ func (tc *TrafficCapture) setupNFQueueRules(ifaceName string, iface *IfaceInfo,
 	}
 	
 	// 执行规则
+	added := make([]string, 0, len(rules))
 	for _, rule := range rules {
 		if err := tc.executeCommand(rule); err != nil {
-			return fmt.Errorf("failed to execute rule %s: %v", rule, err)
-		}
-		
-		// 保存规则用于后续删除
-		if netInfo, exists := tc.containers[iface.Name]; exists {
-			netInfo.Rules = append(netInfo.Rules, rule)
+			return added, fmt.Errorf("failed to execute rule %s: %v", rule, err)
 		}
+		added = append(added, rule)
 	}
 	
-	return nil
+	return added, nil
 }
 
 // executeCommand 执行shell命令
 func (tc *TrafficCapture) executeCommand(command string) error {
 	log.WithField("cmd", command).Debug("Executing command")
 	
-	cmd := exec.Command("sh", "-c", command)
-	output, err := cmd.CombinedOutput()
-	
+	output, err := tc.executor.Run(command)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"cmd":    command,
-			"output": string(output),
+			"output": output,
 			"error":  err,
 		}).Debug("Command execution failed")
 		return err
@@ -320,12 +328,46 @@ func (tc *TrafficCapture) GetCapturedContainers() []string {
 	
 	var containers []string
 	for id, info := range tc.containers {
-		containers = append(containers, fmt.Sprintf("%s (%s)", info.Name, id[:12]))
+		containers = append(containers, fmt.Sprintf("%s (%s)", info.Name, shortID(id)))
 	}
 	
 	return containers
 }
 
+// GetContainerIPConfigs 获取已捕获容器各接口的IP配置
+// 返回接口名到IP配置的映射，容器未捕获时返回nil
+func (tc *TrafficCapture) GetContainerIPConfigs(containerID string) map[string]*IPConfig {
+	tc.mutex.RLock()
+	defer tc.mutex.RUnlock()
+	
+	netInfo, ok := tc.containers[containerID]
+	if !ok {
+		return nil
+	}
+	configs := make(map[string]*IPConfig, len(netInfo.Interfaces))
+	for name, iface := range netInfo.Interfaces {
+		if iface.IPConfig != nil {
+			cfg := *iface.IPConfig
+			configs[name] = &cfg
+		}
+	}
+	return configs
+}
+
+// CheckIPChanges NFQUEUE规则按接口名匹配，不受IP变化影响，不跟踪IP变化
+func (tc *TrafficCapture) CheckIPChanges() []string {
+	return nil
+}
+
+// ValidateSetup 检查NFQUEUE方式依赖的iptables、ip、nsenter命令
+func (tc *TrafficCapture) ValidateSetup() error {
+	return checkCommands(tc.executor, []commandCheck{
+		{"iptables", "iptables --version"},
+		{"ip", "ip -Version"},
+		{"nsenter", "nsenter --version"},
+	})
+}
+
 // Cleanup 清理所有规则
 func (tc *TrafficCapture) Cleanup() error {
 	tc.mutex.Lock()
@@ -335,7 +377,7 @@ func (tc *TrafficCapture) Cleanup() error {
 	
 	// 停止所有容器的流量捕获
 	for containerID := range tc.containers {
-		if err := tc.StopContainerCapture(containerID); err != nil {
+		if err := tc.stopContainerLocked(containerID); err != nil {
 			log.WithError(err).WithField("container", containerID).Warn("Failed to stop container capture")
 		}
 	}
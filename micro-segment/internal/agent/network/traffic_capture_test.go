@@ -0,0 +1,44 @@
+package network
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNFQueueRulesRemoved(t *testing.T) {
+	exec := &fakeExecutor{outputs: map[string]string{
+		"nsenter -t 42 -n ip link show":          "1: lo: <LOOPBACK,UP> mtu 65536\n2: eth0@if7: <BROADCAST,UP> mtu 1500",
+		"nsenter -t 42 -n ip addr show eth0":     "    inet 172.17.0.5/16 brd 172.17.255.255 scope global eth0",
+		"nsenter -t 42 -n ip route show default": "default via 172.17.0.1 dev eth0",
+	}}
+	nfq := &TrafficCapture{containers: make(map[string]*ContainerNetInfo), executor: exec}
+
+	const id = "abc"
+	if err := nfq.StartContainerCapture(id, "web", 42); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if cfg := nfq.GetContainerIPConfigs(id)["eth0"]; cfg == nil || cfg.IPAddr != "172.17.0.5/16" || cfg.Gateway != "172.17.0.1" {
+		t.Errorf("Unexpected IP config: %+v", cfg)
+	}
+	if rules := nfq.containers[id].Rules; len(rules) != 2 {
+		t.Fatalf("Rules not recorded: %v", rules)
+	}
+
+	// Cleanup在持锁时删除各容器规则
+	done := make(chan struct{})
+	go func() {
+		nfq.Cleanup()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Cleanup deadlocked")
+	}
+	if deleted := filterCmds(exec.cmds, "iptables -D NV_"); len(deleted) != 2 {
+		t.Errorf("Unexpected deleted rules: %v", deleted)
+	}
+	if len(nfq.containers) != 0 {
+		t.Errorf("Containers not cleared")
+	}
+}
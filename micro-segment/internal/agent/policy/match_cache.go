@@ -0,0 +1,43 @@
+// Package policy 策略匹配结果缓存
+package policy
+
+import (
+	"net"
+
+	"github.com/micro-segment/internal/agent"
+	"github.com/micro-segment/internal/lru"
+)
+
+// defaultMatchCacheSize 默认缓存的连接元组数
+const defaultMatchCacheSize = 4096
+
+// matchKey 匹配缓存键
+type matchKey struct {
+	srcIP, dstIP [net.IPv6len]byte
+	dstPort      uint16
+	proto        uint8
+	app          uint32
+}
+
+// newMatchKey 构造匹配缓存键，IPv4统一为16字节形式
+func newMatchKey(srcIP, dstIP net.IP, dstPort uint16, proto uint8, app uint32) matchKey {
+	key := matchKey{dstPort: dstPort, proto: proto, app: app}
+	copy(key.srcIP[:], srcIP.To16())
+	copy(key.dstIP[:], dstIP.To16())
+	return key
+}
+
+// matchResult 匹配结果
+type matchResult struct {
+	id     uint32
+	action agent.PolicyAction
+}
+
+// matchCache 按连接元组缓存策略匹配结果的LRU
+// 规则任何变更都需调用Reset清空
+type matchCache = lru.Cache[matchKey, matchResult]
+
+// newMatchCache 创建最多保存size条结果的匹配缓存
+func newMatchCache(size int) *matchCache {
+	return lru.New[matchKey, matchResult](size)
+}
@@ -3,7 +3,10 @@
 package policy
 
 import (
+	"crypto/sha256"
+	"encoding/json"
 	"net"
+	"sort"
 	"sync"
 
 	log "github.com/sirupsen/logrus"
@@ -12,20 +15,39 @@ import (
 	"github.com/micro-segment/internal/agent/dp"
 )
 
+// dpSender 策略下发目标，由dp.DPPool实现
+type dpSender interface {
+	IsConnected() bool
+	SendPolicy(policies []*dp.DPPolicy) error
+}
+
 // NetworkPolicy 网络策略管理器
 type NetworkPolicy struct {
 	mutex    sync.RWMutex
 	rules    map[uint32]*agent.PolicyRule
-	dpClient *dp.DPClient
+	order    []uint32 // 规则评估顺序，与Controller下发的顺序一致
+	dpClient dpSender
+
+	// 按连接元组缓存的匹配结果，规则变更时清空
+	matches *matchCache
+
+	// 上次成功下发到DP的策略集哈希，相同时跳过下发
+	syncedHash [sha256.Size]byte
+	synced     bool
 }
 
 // NewNetworkPolicy 创建网络策略管理器
 // 初始化策略规则存储和DP客户端连接
-func NewNetworkPolicy(dpClient *dp.DPClient) *NetworkPolicy {
-	return &NetworkPolicy{
-		rules:    make(map[uint32]*agent.PolicyRule),
-		dpClient: dpClient,
+func NewNetworkPolicy(dpClient *dp.DPPool) *NetworkPolicy {
+	p := &NetworkPolicy{
+		rules:   make(map[uint32]*agent.PolicyRule),
+		matches: newMatchCache(defaultMatchCacheSize),
+	}
+	if dpClient != nil {
+		// 仅在非nil时赋值，避免接口持有nil指针
+		p.dpClient = dpClient
 	}
+	return p
 }
 
 // AddRule 添加规则
@@ -34,7 +56,11 @@ func (p *NetworkPolicy) AddRule(rule *agent.PolicyRule) {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
+	if _, ok := p.rules[rule.ID]; !ok {
+		p.order = append(p.order, rule.ID)
+	}
 	p.rules[rule.ID] = rule
+	p.matches.Reset()
 	log.WithFields(log.Fields{
 		"id":     rule.ID,
 		"from":   rule.From,
@@ -49,7 +75,16 @@ func (p *NetworkPolicy) DeleteRule(id uint32) {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
-	delete(p.rules, id)
+	if _, ok := p.rules[id]; ok {
+		delete(p.rules, id)
+		for i, v := range p.order {
+			if v == id {
+				p.order = append(p.order[:i], p.order[i+1:]...)
+				break
+			}
+		}
+	}
+	p.matches.Reset()
 	log.WithField("id", id).Debug("Policy rule deleted")
 }
 
@@ -83,11 +118,16 @@ func (p *NetworkPolicy) UpdateRules(rules []*agent.PolicyRule) {
 
 	// 清空旧规则
 	p.rules = make(map[uint32]*agent.PolicyRule)
+	p.order = make([]uint32, 0, len(rules))
 
 	// 添加新规则
 	for _, rule := range rules {
+		if _, ok := p.rules[rule.ID]; !ok {
+			p.order = append(p.order, rule.ID)
+		}
 		p.rules[rule.ID] = rule
 	}
+	p.matches.Reset()
 
 	log.WithField("count", len(rules)).Info("Policy rules updated")
 
@@ -95,8 +135,18 @@ func (p *NetworkPolicy) UpdateRules(rules []*agent.PolicyRule) {
 	p.syncToDP()
 }
 
+// Resync 强制向DP重新下发全部策略
+// DP重连后调用，DP重启后已丢失之前下发的策略
+func (p *NetworkPolicy) Resync() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.synced = false
+	p.syncToDP()
+}
+
 // syncToDP 同步策略到DP层
-// 将内存中的策略规则转换并发送到DP执行
+// 将内存中的策略规则转换并发送到DP执行，转换结果与上次成功下发的相同时跳过，调用方需持有写锁
 func (p *NetworkPolicy) syncToDP() {
 	if p.dpClient == nil || !p.dpClient.IsConnected() {
 		return
@@ -109,10 +159,28 @@ func (p *NetworkPolicy) syncToDP() {
 			dpPolicies = append(dpPolicies, dpPolicy)
 		}
 	}
+	sort.Slice(dpPolicies, func(i, j int) bool { return dpPolicies[i].ID < dpPolicies[j].ID })
+
+	hash, err := policyHash(dpPolicies)
+	if err == nil && p.synced && hash == p.syncedHash {
+		log.WithField("count", len(dpPolicies)).Debug("Policies unchanged, skip DP sync")
+		return
+	}
 
 	if err := p.dpClient.SendPolicy(dpPolicies); err != nil {
 		log.WithError(err).Error("Failed to sync policies to DP")
+		return
 	}
+	p.syncedHash, p.synced = hash, err == nil
+}
+
+// policyHash 计算按ID排序的DP策略集哈希
+func policyHash(policies []*dp.DPPolicy) ([sha256.Size]byte, error) {
+	data, err := json.Marshal(policies)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(data), nil
 }
 
 // ruleToDPPolicy 转换规则为DP策略
@@ -127,21 +195,72 @@ func (p *NetworkPolicy) ruleToDPPolicy(rule *agent.PolicyRule) *dp.DPPolicy {
 }
 
 // MatchPolicy 匹配策略
-// 根据网络五元组查找匹配的策略规则
-func (p *NetworkPolicy) MatchPolicy(srcIP, dstIP net.IP, dstPort uint16, proto uint8) (uint32, agent.PolicyAction) {
+// 根据连接元组按顺序查找第一条匹配的策略规则，结果按元组缓存
+func (p *NetworkPolicy) MatchPolicy(srcIP, dstIP net.IP, dstPort uint16, proto uint8, app uint32) (uint32, agent.PolicyAction) {
 	p.mutex.RLock()
 	defer p.mutex.RUnlock()
 
-	// 简化实现：遍历所有规则进行匹配
-	for _, rule := range p.rules {
-		// TODO: 实现完整的匹配逻辑
-		_ = rule
+	// 持有读锁时查询和写入缓存，规则变更清空缓存后不会写入过期结果
+	key := newMatchKey(srcIP, dstIP, dstPort, proto, app)
+	if result, ok := p.matches.Get(key); ok {
+		return result.id, result.action
 	}
+	id, action := p.matchRules(srcIP, dstIP, dstPort, proto, app)
+	p.matches.Put(key, matchResult{id: id, action: action})
+	return id, action
+}
 
-	// 默认返回Monitor模式下的Violate动作
+// matchRules 遍历规则匹配，调用方需持有读锁
+// 没有匹配的规则时返回Monitor模式下的Violate动作
+func (p *NetworkPolicy) matchRules(srcIP, dstIP net.IP, dstPort uint16, proto uint8, app uint32) (uint32, agent.PolicyAction) {
+	for _, id := range p.order {
+		rule := p.rules[id]
+		if !matchEndpoint(rule.From, srcIP) || !matchEndpoint(rule.To, dstIP) {
+			continue
+		}
+		// TODO: 实现端口匹配，与Controller一致暂不检查
+		if len(rule.Applications) > 0 && !matchApp(rule.Applications, app) {
+			continue
+		}
+		return rule.ID, rule.Action
+	}
 	return 0, agent.PolicyActionViolate
 }
 
+// matchEndpoint 匹配规则的一端
+// Agent无法解析组成员，仅匹配any和CIDR/IP
+func matchEndpoint(side string, ip net.IP) bool {
+	if side == "" || side == "any" {
+		return true
+	}
+	if _, ipnet, err := net.ParseCIDR(side); err == nil {
+		return ipnet.Contains(ip)
+	}
+	if sideIP := net.ParseIP(side); sideIP != nil {
+		return sideIP.Equal(ip)
+	}
+	return false
+}
+
+// matchApp 匹配应用，0表示任意应用
+func matchApp(apps []uint32, app uint32) bool {
+	for _, a := range apps {
+		if a == app || a == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// IsLogged 检查规则是否开启审计日志
+func (p *NetworkPolicy) IsLogged(id uint32) bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	rule, ok := p.rules[id]
+	return ok && rule.Log
+}
+
 // GetRuleCount 获取规则数量
 // 返回当前策略规则总数
 func (p *NetworkPolicy) GetRuleCount() int {
@@ -0,0 +1,140 @@
+package policy
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/micro-segment/internal/agent"
+	"github.com/micro-segment/internal/agent/dp"
+)
+
+// fakeSender 记录下发的策略
+type fakeSender struct {
+	connected bool
+	sent      [][]*dp.DPPolicy
+}
+
+func (f *fakeSender) IsConnected() bool { return f.connected }
+
+func (f *fakeSender) SendPolicy(policies []*dp.DPPolicy) error {
+	f.sent = append(f.sent, policies)
+	return nil
+}
+
+func TestUpdateRulesSkipsUnchanged(t *testing.T) {
+	sender := &fakeSender{connected: true}
+	p := NewNetworkPolicy(nil)
+	p.dpClient = sender
+
+	rules := func(action agent.PolicyAction) []*agent.PolicyRule {
+		return []*agent.PolicyRule{
+			{ID: 2, From: "web", To: "db", Action: action},
+			{ID: 1, From: "any", To: "web", Action: agent.PolicyActionAllow},
+		}
+	}
+
+	p.UpdateRules(rules(agent.PolicyActionAllow))
+	p.UpdateRules(rules(agent.PolicyActionAllow))
+	if len(sender.sent) != 1 {
+		t.Fatalf("Identical rules sent %d times", len(sender.sent))
+	}
+	if got := sender.sent[0]; len(got) != 2 || got[0].ID != 1 || got[1].ID != 2 {
+		t.Errorf("Policies not sorted by ID: %+v", got)
+	}
+
+	// 规则变化时重新下发
+	p.UpdateRules(rules(agent.PolicyActionDeny))
+	if len(sender.sent) != 2 || sender.sent[1][1].Action != uint8(agent.PolicyActionDeny) {
+		t.Fatalf("Changed rule not resynced: %d sends", len(sender.sent))
+	}
+
+	// DP重连后强制重新下发
+	p.Resync()
+	if len(sender.sent) != 3 {
+		t.Errorf("Resync did not send: %d sends", len(sender.sent))
+	}
+}
+
+func TestUpdateRulesDisconnected(t *testing.T) {
+	sender := &fakeSender{}
+	p := NewNetworkPolicy(nil)
+	p.dpClient = sender
+
+	rules := []*agent.PolicyRule{{ID: 1, Action: agent.PolicyActionAllow}}
+	p.UpdateRules(rules)
+	if len(sender.sent) != 0 {
+		t.Fatalf("Sent while disconnected")
+	}
+
+	// 未下发过的策略在连接后不被跳过
+	sender.connected = true
+	p.UpdateRules(rules)
+	if len(sender.sent) != 1 {
+		t.Errorf("Expected send after connect, got %d", len(sender.sent))
+	}
+}
+
+func TestMatchPolicyCache(t *testing.T) {
+	p := NewNetworkPolicy(nil)
+	p.UpdateRules([]*agent.PolicyRule{
+		{ID: 1, From: "10.0.0.0/24", To: "any", Action: agent.PolicyActionAllow},
+		{ID: 2, From: "any", To: "10.0.1.5", Action: agent.PolicyActionDeny},
+	})
+	src, dst := net.ParseIP("10.0.0.7"), net.ParseIP("10.0.1.5")
+
+	id, action := p.MatchPolicy(src, dst, 80, 6, 0)
+	if id != 1 || action != agent.PolicyActionAllow {
+		t.Fatalf("Unexpected match: %d %v", id, action)
+	}
+	if id2, action2 := p.MatchPolicy(src, dst, 80, 6, 0); id2 != id || action2 != action {
+		t.Errorf("Cached match differs: %d %v", id2, action2)
+	}
+	if p.matches.Len() != 1 {
+		t.Errorf("Match not cached: len=%d", p.matches.Len())
+	}
+	if id, _ := p.MatchPolicy(net.ParseIP("10.9.0.1"), dst, 80, 6, 0); id != 2 {
+		t.Errorf("Unexpected match for other source: %d", id)
+	}
+
+	// 规则更新后不返回过期结果
+	p.UpdateRules([]*agent.PolicyRule{{ID: 3, From: "any", To: "10.0.1.0/24", Action: agent.PolicyActionDeny}})
+	if p.matches.Len() != 0 {
+		t.Fatalf("Cache not invalidated on update")
+	}
+	if id, action := p.MatchPolicy(src, dst, 80, 6, 0); id != 3 || action != agent.PolicyActionDeny {
+		t.Errorf("Stale match after update: %d %v", id, action)
+	}
+
+	p.DeleteRule(3)
+	if id, action := p.MatchPolicy(src, dst, 80, 6, 0); id != 0 || action != agent.PolicyActionViolate {
+		t.Errorf("Stale match after delete: %d %v", id, action)
+	}
+	p.AddRule(&agent.PolicyRule{ID: 4, From: "any", To: "any", Applications: []uint32{1001}, Action: agent.PolicyActionAllow})
+	if id, _ := p.MatchPolicy(src, dst, 80, 6, 1001); id != 4 {
+		t.Errorf("Stale match after add: %d", id)
+	}
+}
+
+func TestMatchCacheBounded(t *testing.T) {
+	c := newMatchCache(2)
+	keys := make([]matchKey, 3)
+	for i := range keys {
+		keys[i] = newMatchKey(net.ParseIP(fmt.Sprintf("10.0.0.%d", i)), net.ParseIP("10.0.1.1"), 80, 6, 0)
+	}
+	c.Put(keys[0], matchResult{id: 1, action: agent.PolicyActionAllow})
+	c.Put(keys[1], matchResult{id: 2, action: agent.PolicyActionAllow})
+	c.Get(keys[0])
+	c.Put(keys[2], matchResult{id: 3, action: agent.PolicyActionAllow})
+
+	// 最久未使用的被淘汰
+	if c.Len() != 2 {
+		t.Fatalf("Cache exceeds bound: %d", c.Len())
+	}
+	if _, ok := c.Get(keys[1]); ok {
+		t.Errorf("Least recently used entry not evicted")
+	}
+	if result, ok := c.Get(keys[0]); !ok || result.id != 1 {
+		t.Errorf("Recently used entry evicted")
+	}
+}
@@ -15,6 +15,8 @@ package agent
 import (
 	"net"
 	"time"
+
+	pb "github.com/micro-segment/api/proto"
 )
 
 // PolicyMode 策略执行模式
@@ -27,14 +29,14 @@ const (
 	PolicyModeProtect PolicyMode = "Protect"
 )
 
-// PolicyAction 策略执行动作
+// PolicyAction 策略执行动作，取值与proto定义一致
 type PolicyAction uint8
 
 const (
-	PolicyActionOpen    PolicyAction = 0 // 开放
-	PolicyActionAllow   PolicyAction = 1 // 允许
-	PolicyActionDeny    PolicyAction = 2 // 拒绝
-	PolicyActionViolate PolicyAction = 3 // 违规
+	PolicyActionOpen    = PolicyAction(pb.PolicyActionOpen)    // 开放
+	PolicyActionAllow   = PolicyAction(pb.PolicyActionAllow)   // 允许
+	PolicyActionDeny    = PolicyAction(pb.PolicyActionDeny)    // 拒绝
+	PolicyActionViolate = PolicyAction(pb.PolicyActionViolate) // 违规
 )
 
 // Connection 网络连接信息，记录两个端点间的通信详情
@@ -63,6 +65,19 @@ type Connection struct {
 	LocalPeer    bool          // 是否为本地对等端
 	Scope        string        // 作用域
 	Network      string        // 网络名称
+	L7           []L7Meta      // 应用层元数据，仅描述用途，不参与聚合键
+	Capped       bool          // 会话数或违规数已达上限，不再累加
+	Summary      bool          // 映射表满时按端点对合并的低优先级流量汇总，不含端口和协议
+
+	InternetIngress bool // 客户端为公网地址、服务端为内部地址
+}
+
+// L7Meta 应用层元数据，由DP解析协议得到
+type L7Meta struct {
+	HTTPMethod string // HTTP请求方法
+	HTTPHost   string // HTTP Host头
+	DNSQuery   string // DNS查询域名
+	TLSSNI     string // TLS SNI
 }
 
 // ConnectionData 连接数据，包含MAC地址和连接信息
@@ -92,6 +107,9 @@ type ThreatLog struct {
 	WorkloadID   string    // 工作负载ID
 	WorkloadName string    // 工作负载名称
 	ReportedAt   time.Time // 报告时间
+	TcpFlags     uint8     // 触发数据包的TCP标志位
+	PktLen       uint16    // 触发数据包长度
+	PktSummary   string    // 触发数据包摘要
 }
 
 // Workload 工作负载定义，表示一个被保护的应用实例
@@ -102,10 +120,17 @@ type Workload struct {
 	HostName   string                  // 所属主机名
 	Domain     string                  // 域名
 	Service    string                  // 服务名称
+	Image      string                  // 镜像名称
+	Labels     map[string]string       // 容器标签
 	PolicyMode PolicyMode              // 策略模式
 	Running    bool                    // 运行状态
 	Pid        int                     // 进程ID
 	Ifaces     map[string][]IPAddr     // 网络接口映射
+	PodName    string                  // K8s Pod名称
+	OwnerKind  string                  // Pod所属控制器类型
+	OwnerName  string                  // Pod所属控制器名称
+	State      string                  // 容器状态，如running、paused、exited
+	Health     string                  // 健康检查状态，未配置时为空
 }
 
 // IPAddr IP地址信息，包含地址、网络和网关配置
@@ -143,6 +168,30 @@ type PolicyRule struct {
 	Applications []uint32      // 应用协议列表
 	Action       PolicyAction  // 执行动作
 	Ingress      bool          // 是否为入站规则
+	Log          bool          // 命中时记录审计，不影响动作
+}
+
+// Agent命令类型，与Controller下发的命令类型一致
+const (
+	CommandForceCapture = "force-capture" // 强制开启容器流量捕获
+	CommandForceStop    = "force-stop"    // 强制停止容器流量捕获
+	CommandGetDebug     = "get-debug"     // 获取网络模块调试信息
+	CommandPacketSample = "packet-sample" // 采样容器流量写入pcap文件
+)
+
+// Command Controller随心跳响应下发的远程命令
+type Command struct {
+	ID          string // 命令ID
+	Type        string // 命令类型
+	ContainerID string // 目标容器ID
+}
+
+// CommandResult 命令执行结果，随下一次心跳回传
+type CommandResult struct {
+	ID      string // 命令ID
+	Success bool   // 是否执行成功
+	Error   string // 失败原因
+	Output  string // 命令输出
 }
 
 // ContainerEvent 容器生命周期事件类型
@@ -0,0 +1,153 @@
+// Package alert 违规和威胁告警的Webhook推送
+// 告警经有界队列由后台协程以HTTP POST发送，队列满时丢弃，不阻塞上报处理
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	controller "github.com/micro-segment/internal/controller"
+)
+
+const (
+	// defaultQueueSize 待发送告警队列长度
+	defaultQueueSize = 256
+	// defaultRetries 发送失败后的重试次数
+	defaultRetries = 3
+	// defaultRetryDelay 首次重试间隔，之后按次数线性增加
+	defaultRetryDelay = time.Second
+	// requestTimeout 单次发送超时
+	requestTimeout = 5 * time.Second
+)
+
+// severityNames 严重级别名称，下标与连接严重级别一致
+var severityNames = []string{"info", "low", "medium", "high", "critical"}
+
+// ParseSeverity 解析严重级别名称
+func ParseSeverity(s string) (uint8, error) {
+	for i, name := range severityNames {
+		if strings.EqualFold(s, name) {
+			return uint8(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown severity: %s (expected one of %s)", s, strings.Join(severityNames, ", "))
+}
+
+// Webhook 告警Webhook
+type Webhook struct {
+	url       string
+	threshold uint8
+	client    *http.Client
+	queue     chan *controller.Alert
+
+	retries    int
+	retryDelay time.Duration
+
+	dropped uint64
+	stopCh  chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewWebhook 创建告警Webhook，严重级别不低于threshold的告警推送到url
+func NewWebhook(url string, threshold uint8) *Webhook {
+	return &Webhook{
+		url:        url,
+		threshold:  threshold,
+		client:     &http.Client{Timeout: requestTimeout},
+		queue:      make(chan *controller.Alert, defaultQueueSize),
+		retries:    defaultRetries,
+		retryDelay: defaultRetryDelay,
+		stopCh:     make(chan struct{}),
+	}
+}
+
+// Start 启动发送协程
+func (w *Webhook) Start() {
+	w.wg.Add(1)
+	go w.sendLoop()
+}
+
+// Stop 停止发送，队列中未发送的告警被丢弃
+func (w *Webhook) Stop() {
+	close(w.stopCh)
+	w.wg.Wait()
+}
+
+// Notify 提交告警，低于阈值的告警被忽略
+// 不阻塞，可在持有锁时调用；队列满时丢弃并计数
+func (w *Webhook) Notify(a *controller.Alert) {
+	if a.Severity < w.threshold {
+		return
+	}
+	select {
+	case w.queue <- a:
+	default:
+		if atomic.AddUint64(&w.dropped, 1)%100 == 1 {
+			log.WithFields(log.Fields{"type": a.Type, "dropped": atomic.LoadUint64(&w.dropped)}).Warn("Alert queue full, dropping alert")
+		}
+	}
+}
+
+// Dropped 获取因队列满丢弃的告警数
+func (w *Webhook) Dropped() uint64 {
+	return atomic.LoadUint64(&w.dropped)
+}
+
+// sendLoop 依次发送队列中的告警
+func (w *Webhook) sendLoop() {
+	defer w.wg.Done()
+	for {
+		select {
+		case a := <-w.queue:
+			w.deliver(a)
+		case <-w.stopCh:
+			return
+		}
+	}
+}
+
+// deliver 发送告警，失败时按间隔重试，停止时放弃
+func (w *Webhook) deliver(a *controller.Alert) {
+	body, err := json.Marshal(a)
+	if err != nil {
+		log.WithError(err).Error("Failed to marshal alert")
+		return
+	}
+
+	for attempt := 0; ; attempt++ {
+		err = w.post(body)
+		if err == nil {
+			return
+		}
+		if attempt >= w.retries {
+			break
+		}
+		select {
+		case <-time.After(w.retryDelay * time.Duration(attempt+1)):
+		case <-w.stopCh:
+			return
+		}
+	}
+	log.WithError(err).WithFields(log.Fields{"type": a.Type, "url": w.url}).Warn("Failed to deliver alert")
+}
+
+// post 发送一次告警，非2xx响应视为失败
+func (w *Webhook) post(body []byte) error {
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
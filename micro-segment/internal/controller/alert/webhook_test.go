@@ -0,0 +1,70 @@
+package alert
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	controller "github.com/micro-segment/internal/controller"
+)
+
+func TestWebhook(t *testing.T) {
+	received := make(chan *controller.Alert, 4)
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 首次请求失败，验证重试
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var a controller.Alert
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&a) != nil {
+			t.Errorf("Unexpected request: %s", r.Method)
+		}
+		received <- &a
+	}))
+	defer server.Close()
+
+	high, err := ParseSeverity("High")
+	if err != nil {
+		t.Fatalf("ParseSeverity failed: %v", err)
+	}
+	w := NewWebhook(server.URL, high)
+	w.retryDelay = time.Millisecond
+	w.Start()
+	defer w.Stop()
+
+	// 低于阈值的告警不发送
+	w.Notify(&controller.Alert{Type: "violation", Severity: 1, Violation: &controller.Violation{ID: "1"}})
+	w.Notify(&controller.Alert{Type: "threat", Severity: 4, Threat: &controller.ThreatLog{ID: "t1", ThreatName: "SYN flood"}})
+
+	select {
+	case a := <-received:
+		if a.Type != "threat" || a.Threat == nil || a.Threat.ThreatName != "SYN flood" {
+			t.Errorf("Unexpected payload: %+v", a)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Webhook not received")
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("Expected 2 requests with retry, got %d", n)
+	}
+
+	if _, err := ParseSeverity("urgent"); err == nil {
+		t.Errorf("Expected error for unknown severity")
+	}
+}
+
+func TestWebhookQueueFull(t *testing.T) {
+	// 未启动发送协程，队列满后丢弃而不阻塞
+	w := NewWebhook("http://127.0.0.1:0", 0)
+	for i := 0; i < defaultQueueSize+10; i++ {
+		w.Notify(&controller.Alert{Type: "threat"})
+	}
+	if w.Dropped() != 10 {
+		t.Errorf("Expected 10 dropped alerts, got %d", w.Dropped())
+	}
+}
@@ -0,0 +1,59 @@
+// Package cache 工作负载活动时间跟踪
+package cache
+
+import (
+	"sort"
+	"time"
+
+	controller "github.com/micro-segment/internal/controller"
+)
+
+// storeWorkload 保存工作负载，保留已有的活动时间，调用方需持有写锁
+func (c *Cache) storeWorkload(wl *controller.Workload) {
+	entry := &WorkloadCache{
+		Workload:   wl,
+		PolicyMode: c.effectiveMode(wl.ID, wl.PolicyMode),
+		LastSeenAt: time.Now(),
+	}
+	if old, ok := c.workloads[wl.ID]; ok && !old.ActiveAt.IsZero() {
+		entry.ActiveAt = old.ActiveAt
+		wl.LastActiveAt = old.ActiveAt
+	}
+	c.workloads[wl.ID] = entry
+}
+
+// touchWorkloads 以连接的最近活跃时间更新两端工作负载的活动时间，调用方需持有写锁
+// 连接未带时间时以当前时间为准，活动时间只前进不后退
+func (c *Cache) touchWorkloads(conn *controller.Connection) {
+	at := conn.LastSeenAt
+	if at.IsZero() {
+		at = c.now()
+	}
+	for _, id := range []string{conn.ClientWL, conn.ServerWL} {
+		cache, ok := c.workloads[id]
+		if !ok || !at.After(cache.ActiveAt) {
+			continue
+		}
+		cache.ActiveAt = at
+		// 替换而非原地修改，已返回给调用方的工作负载不受影响
+		updated := *cache.Workload
+		updated.LastActiveAt = at
+		cache.Workload = &updated
+	}
+}
+
+// IdleWorkloads 列出自since起没有连接活动的工作负载，按ID排序
+// 从未出现在连接中的工作负载也视为空闲
+func (c *Cache) IdleWorkloads(since time.Time) []*controller.Workload {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	result := make([]*controller.Workload, 0)
+	for _, cache := range c.workloads {
+		if cache.ActiveAt.Before(since) {
+			result = append(result, cache.Workload)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
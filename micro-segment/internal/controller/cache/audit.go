@@ -0,0 +1,95 @@
+// Package cache 提供Controller缓存管理
+package cache
+
+import (
+	"strconv"
+
+	pb "github.com/micro-segment/api/proto"
+	controller "github.com/micro-segment/internal/controller"
+)
+
+// maxViolations 保存的违规和审计记录条数上限
+const maxViolations = 4096
+
+// 记录级别
+const (
+	violationLevelViolation = "violation" // 拒绝或违规的连接
+	violationLevelAudit     = "audit"     // 命中开启审计日志的规则，动作不变
+)
+
+// SetRuleLogged 设置规则审计开关查询，通常为policy.Engine.IsRuleLogged
+// 命中开启审计的规则的连接即使被允许也记录，nil时只记录违规
+func (c *Cache) SetRuleLogged(logged func(id uint32) bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.ruleLogged = logged
+}
+
+// SetOnAlert 设置告警回调，保存违规记录和威胁日志后调用
+// 回调在持有缓存锁时调用，不得阻塞或访问缓存
+func (c *Cache) SetOnAlert(cb func(*controller.Alert)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.onAlert = cb
+}
+
+// recordViolation 记录违规或命中审计规则的连接，调用方需持有写锁
+// 超过maxViolations时丢弃最早的记录，违规记录触发告警，审计记录不触发
+func (c *Cache) recordViolation(conn *controller.Connection) {
+	var level string
+	switch {
+	case controller.PolicyAction(conn.PolicyAction) >= controller.PolicyActionDeny:
+		level = violationLevelViolation
+	case conn.PolicyID != 0 && c.ruleLogged != nil && c.ruleLogged(conn.PolicyID):
+		level = violationLevelAudit
+	default:
+		return
+	}
+
+	c.violationSeq++
+	violation := &controller.Violation{
+		ID:           strconv.FormatUint(c.violationSeq, 10),
+		ClientWL:     conn.ClientWL,
+		ServerWL:     conn.ServerWL,
+		ClientIP:     conn.ClientIP.String(),
+		ServerIP:     conn.ServerIP.String(),
+		ServerPort:   conn.ServerPort,
+		IPProto:      conn.IPProto,
+		PolicyAction: actionName(controller.PolicyAction(conn.PolicyAction)),
+		PolicyID:     conn.PolicyID,
+		Sessions:     conn.Sessions,
+		ReportedAt:   c.now(),
+		Level:        level,
+	}
+	c.violations = append(c.violations, violation)
+	if n := len(c.violations) - maxViolations; n > 0 {
+		c.violations = c.violations[n:]
+	}
+
+	if level == violationLevelViolation && c.onAlert != nil {
+		dup := *violation
+		c.onAlert(&controller.Alert{Type: controller.AlertTypeViolation, Severity: conn.Severity, Violation: &dup})
+	}
+}
+
+// ListViolations 列出违规和审计记录，按上报顺序
+func (c *Cache) ListViolations() []*controller.Violation {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	result := make([]*controller.Violation, 0, len(c.violations))
+	for _, v := range c.violations {
+		dup := *v
+		result = append(result, &dup)
+	}
+	return result
+}
+
+// actionName 策略动作名称，与REST规则中的动作字符串一致，未知取值返回数字
+func actionName(action controller.PolicyAction) string {
+	name, err := pb.PolicyActionName(uint32(action))
+	if err != nil {
+		return strconv.Itoa(int(action))
+	}
+	return name
+}
@@ -3,10 +3,16 @@
 package cache
 
 import (
+	"fmt"
+	"math"
 	"net"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	log "github.com/sirupsen/logrus"
+
 	pb "github.com/micro-segment/api/proto"
 	controller "github.com/micro-segment/internal/controller"
 	"github.com/micro-segment/internal/controller/graph"
@@ -36,14 +42,79 @@ type Cache struct {
 
 	// 连接缓存
 	connections map[string]*ConnectionCache
+	// 流量键 -> 连接key，用于合并不同Agent对同一流量的上报
+	flows map[flowKey]string
+
+	// 工作负载作为客户端和服务端观察到的端口，工作负载ID -> 端口
+	ports map[string]*workloadPorts
+
+	// 威胁日志，按上报顺序保存最近maxThreatLogs条
+	threats []*controller.ThreatLog
+
+	// 违规和审计记录，按上报顺序保存最近maxViolations条
+	violations   []*controller.Violation
+	violationSeq uint64
+
+	// 首次通信事件，按发生顺序保存最近maxFirstContacts条
+	firstContacts   []*controller.FirstContact
+	firstContactSeq uint64
+	// 已通信过的工作负载对，不区分方向
+	contactedPairs map[[2]string]bool
+
+	// 规则是否开启审计日志，可为nil
+	ruleLogged func(id uint32) bool
+
+	// 违规和威胁告警，可为nil
+	onAlert func(*controller.Alert)
+
+	// 外部端点地理信息查询，不得阻塞，可为nil
+	geoLookup func(ip net.IP) (*controller.GeoInfo, bool)
+
+	// 由标签生成Agent上报工作负载的服务名和域名
+	naming WorkloadNaming
+
+	// 成员策略模式变化时通知所属Agent，可为nil
+	onModeChange func(agentID string)
+
+	// 连接过期时间
+	connectionTTL time.Duration
+
+	// 严重级别衰减的静默期，0表示不衰减
+	severityQuiet time.Duration
+
+	// 时钟，测试时可替换
+	now func() time.Time
+
+	stopCh    chan struct{}
+	startOnce sync.Once // 保证只启动一个维护循环
+	stopOnce  sync.Once // 保证stopCh只关闭一次
 }
 
+// defaultConnectionTTL 默认连接过期时间
+const defaultConnectionTTL = 300 * time.Second
+
+// defaultSeverityQuiet 默认严重级别衰减静默期
+const defaultSeverityQuiet = 10 * time.Minute
+
+// maintenanceInterval 后台维护周期
+const maintenanceInterval = 30 * time.Second
+
+// maxL7Variants 每条连接保留的应用层元数据条数上限
+const maxL7Variants = 8
+
+// maxThreatLogs 保存的威胁日志条数上限
+const maxThreatLogs = 4096
+
+// maxConnThreats 单个连接关联的威胁ID上限
+const maxConnThreats = 16
+
 // WorkloadCache 工作负载缓存
 type WorkloadCache struct {
 	Workload    *controller.Workload
 	Groups      []string
 	PolicyMode  controller.PolicyMode
 	LastSeenAt  time.Time
+	ActiveAt    time.Time // 最近一次出现在连接中的时间，未出现过为零值
 }
 
 // GroupCache 组缓存
@@ -76,11 +147,67 @@ type AgentCache struct {
 type ConnectionCache struct {
 	Connection *controller.Connection
 	GraphKey   string
+	UpdatedAt  time.Time         // Controller收到上报的时间
+	SeverityAt time.Time         // 最近一次上报当前严重级别的时间，用于衰减
+	Threats    []uint32          // 关联到该连接的威胁ID，去重，最多maxConnThreats个
+	Ingress    DirectionCounters // 最近一次入向上报的计数
+	Egress     DirectionCounters // 最近一次出向上报的计数
+	LinkSeenAt time.Time         // 该客户端/服务端对的最近活跃时间，取历次上报的最大值
+
+	// 各Agent最近一次上报的该流量，Agent ID -> 连接，Connection为其合并结果
+	Observers map[string]*controller.Connection
+	flow      flowKey
+	tracked   bool // flow有效，已加入流量索引
+}
+
+// DirectionCounters 单方向的流量计数
+type DirectionCounters struct {
+	Bytes    uint64
+	Sessions uint32
+}
+
+// setDirection 保留已有连接另一方向的计数，按本次上报的Ingress标志更新本方向计数
+func (cc *ConnectionCache) setDirection(old *ConnectionCache, conn *controller.Connection) {
+	if old != nil {
+		cc.Ingress, cc.Egress = old.Ingress, old.Egress
+	}
+	counters := DirectionCounters{Bytes: conn.Bytes, Sessions: conn.Sessions}
+	if conn.Ingress {
+		cc.Ingress = counters
+	} else {
+		cc.Egress = counters
+	}
+}
+
+// setLinkSeen 更新链接最近活跃时间，乱序到达的旧上报不会使其回退
+func (cc *ConnectionCache) setLinkSeen(old *ConnectionCache) {
+	cc.LinkSeenAt = cc.lastActive()
+	if old != nil && old.LinkSeenAt.After(cc.LinkSeenAt) {
+		cc.LinkSeenAt = old.LinkSeenAt
+	}
+}
+
+// lastActive 返回连接最近活跃时间
+// LastSeenAt未知时以Controller收到上报的时间为准
+func (cc *ConnectionCache) lastActive() time.Time {
+	if !cc.Connection.LastSeenAt.IsZero() {
+		return cc.Connection.LastSeenAt
+	}
+	return cc.UpdatedAt
+}
+
+// secondsToTime 将DP上报的epoch秒转换为time.Time
+// 0表示未知，返回零值
+func secondsToTime(sec uint32) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(sec), 0)
 }
 
 // NewCache 创建新缓存
 func NewCache() *Cache {
-	return &Cache{
+	c := &Cache{
 		workloads:   make(map[string]*WorkloadCache),
 		groups:      make(map[string]*GroupCache),
 		policies:    make(map[uint32]*PolicyCache),
@@ -88,6 +215,63 @@ func NewCache() *Cache {
 		agents:      make(map[string]*AgentCache),
 		wlGraph:     graph.NewGraph(),
 		connections: make(map[string]*ConnectionCache),
+		flows:       make(map[flowKey]string),
+		ports:       make(map[string]*workloadPorts),
+
+		contactedPairs: make(map[[2]string]bool),
+
+		connectionTTL: defaultConnectionTTL,
+		severityQuiet: defaultSeverityQuiet,
+		now:           time.Now,
+		stopCh:        make(chan struct{}),
+	}
+	c.wlGraph.RegisterNewLinkHook(c.onNewLink)
+	return c
+}
+
+// Start 启动缓存后台维护；重复调用无效
+func (c *Cache) Start() {
+	c.startOnce.Do(func() {
+		go c.maintenanceLoop()
+	})
+}
+
+// Stop 停止缓存后台维护；重复调用无效
+func (c *Cache) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stopCh)
+	})
+}
+
+// SetConnectionTTL 设置连接过期时间
+func (c *Cache) SetConnectionTTL(ttl time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.connectionTTL = ttl
+}
+
+// SetSeverityQuietPeriod 设置严重级别衰减静默期
+// 连接在静默期内没有再上报同等或更高严重级别时降低一级，0表示不衰减
+func (c *Cache) SetSeverityQuietPeriod(quiet time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.severityQuiet = quiet
+}
+
+// maintenanceLoop 后台维护循环
+// 定期清理过期连接并衰减连接严重级别
+func (c *Cache) maintenanceLoop() {
+	ticker := time.NewTicker(maintenanceInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.PurgeExpiredConnections()
+			c.DecaySeverity()
+		case <-c.stopCh:
+			return
+		}
 	}
 }
 
@@ -98,11 +282,7 @@ func (c *Cache) AddWorkload(wl *controller.Workload) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	c.workloads[wl.ID] = &WorkloadCache{
-		Workload:   wl,
-		PolicyMode: wl.PolicyMode,
-		LastSeenAt: time.Now(),
-	}
+	c.storeWorkload(wl)
 }
 
 // GetWorkload 获取工作负载
@@ -121,10 +301,48 @@ func (c *Cache) DeleteWorkload(id string) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
+	c.deleteWorkload(id)
+}
+
+// deleteWorkload 删除工作负载及其端口记录、连接和拓扑节点，调用方需持有写锁
+// 连接一并删除，否则严重级别衰减或策略重新评估会按残留连接重建已删除的节点
+func (c *Cache) deleteWorkload(id string) {
 	delete(c.workloads, id)
+	delete(c.ports, id)
+	c.forgetContacts(id)
+	for key, cache := range c.connections {
+		if cache.Connection.ClientWL == id || cache.Connection.ServerWL == id {
+			delete(c.connections, key)
+			c.forgetFlow(key, cache)
+		}
+	}
 	c.wlGraph.DeleteNode(id)
 }
 
+// ReplaceAgentWorkloads 以Agent上报的完整列表替换其工作负载
+// 列表中的工作负载归属该Agent，原属于该Agent但不在列表中的工作负载连同拓扑节点一并删除
+// 其他Agent的工作负载不受影响，返回删除的数量
+func (c *Cache) ReplaceAgentWorkloads(agentID string, wls []*controller.Workload) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	current := make(map[string]bool, len(wls))
+	for _, wl := range wls {
+		wl.AgentID = agentID
+		current[wl.ID] = true
+		c.storeWorkload(wl)
+	}
+
+	removed := 0
+	for id, cache := range c.workloads {
+		if cache.Workload.AgentID == agentID && !current[id] {
+			c.deleteWorkload(id)
+			removed++
+		}
+	}
+	return removed
+}
+
 // ListWorkloads 列出所有工作负载
 func (c *Cache) ListWorkloads() []*controller.Workload {
 	c.mutex.RLock()
@@ -140,15 +358,41 @@ func (c *Cache) ListWorkloads() []*controller.Workload {
 // --- 组管理 ---
 
 // AddGroup 添加组
+// 替换同名组时原成员不再属于该组，其策略模式重新计算
 func (c *Cache) AddGroup(group *controller.Group) {
 	c.mutex.Lock()
-	defer c.mutex.Unlock()
-
+	var old []string
+	if cache, ok := c.groups[group.Name]; ok {
+		old = memberIDs(cache.Members)
+	}
 	c.groups[group.Name] = &GroupCache{
 		Group:        group,
 		Members:      make(map[string]bool),
 		UsedByPolicy: make(map[uint32]bool),
 	}
+	agents := c.applyGroupModes(old)
+	cb := c.onModeChange
+	c.mutex.Unlock()
+
+	notifyModeChange(cb, agents)
+}
+
+// UpdateGroup 更新组
+// 保留已有的成员和策略引用，组策略模式变化时级联到成员，返回组是否存在
+func (c *Cache) UpdateGroup(group *controller.Group) bool {
+	c.mutex.Lock()
+	cache, ok := c.groups[group.Name]
+	if !ok {
+		c.mutex.Unlock()
+		return false
+	}
+	cache.Group = group
+	agents := c.applyGroupModes(memberIDs(cache.Members))
+	cb := c.onModeChange
+	c.mutex.Unlock()
+
+	notifyModeChange(cb, agents)
+	return true
 }
 
 // GetGroup 获取组
@@ -163,11 +407,19 @@ func (c *Cache) GetGroup(name string) *controller.Group {
 }
 
 // DeleteGroup 删除组
+// 原成员的策略模式重新计算
 func (c *Cache) DeleteGroup(name string) {
 	c.mutex.Lock()
-	defer c.mutex.Unlock()
-
+	var members []string
+	if cache, ok := c.groups[name]; ok {
+		members = memberIDs(cache.Members)
+	}
 	delete(c.groups, name)
+	agents := c.applyGroupModes(members)
+	cb := c.onModeChange
+	c.mutex.Unlock()
+
+	notifyModeChange(cb, agents)
 }
 
 // ListGroups 列出所有组
@@ -182,24 +434,52 @@ func (c *Cache) ListGroups() []*controller.Group {
 	return result
 }
 
-// AddGroupMember 添加组成员
+// AddGroupMember 添加组成员，成员继承组策略模式
 func (c *Cache) AddGroupMember(groupName, workloadID string) {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
+	c.setGroupMember(groupName, workloadID, true)
+}
 
-	if cache, ok := c.groups[groupName]; ok {
+// RemoveGroupMember 移除组成员，成员策略模式按剩余的组重新计算
+func (c *Cache) RemoveGroupMember(groupName, workloadID string) {
+	c.setGroupMember(groupName, workloadID, false)
+}
+
+// setGroupMember 更新组成员关系并同步成员策略模式
+func (c *Cache) setGroupMember(groupName, workloadID string, member bool) {
+	c.mutex.Lock()
+	cache, ok := c.groups[groupName]
+	if !ok {
+		c.mutex.Unlock()
+		return
+	}
+	if member {
 		cache.Members[workloadID] = true
+	} else {
+		delete(cache.Members, workloadID)
 	}
+	agents := c.applyGroupModes([]string{workloadID})
+	cb := c.onModeChange
+	c.mutex.Unlock()
+
+	notifyModeChange(cb, agents)
 }
 
-// RemoveGroupMember 移除组成员
-func (c *Cache) RemoveGroupMember(groupName, workloadID string) {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
+// GetWorkloadGroups 获取工作负载所属的组
+// 返回包含任一指定工作负载的组名
+func (c *Cache) GetWorkloadGroups(workloadIDs []string) []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
 
-	if cache, ok := c.groups[groupName]; ok {
-		delete(cache.Members, workloadID)
+	var result []string
+	for name, cache := range c.groups {
+		for _, id := range workloadIDs {
+			if cache.Members[id] {
+				result = append(result, name)
+				break
+			}
+		}
 	}
+	return result
 }
 
 // --- 策略管理 ---
@@ -273,68 +553,347 @@ func (c *Cache) UpdateConnection(conn *controller.Connection) {
 	// 生成连接key
 	key := c.connectionKey(conn)
 
-	// 更新连接缓存
-	c.connections[key] = &ConnectionCache{
+	// 更新连接缓存，保留已关联的威胁
+	var threats []uint32
+	old, ok := c.connections[key]
+	if ok {
+		threats = old.Threats
+	}
+	conn.ByteRate = byteRate(conn)
+	entry := &ConnectionCache{
 		Connection: conn,
 		GraphKey:   key,
+		UpdatedAt:  c.now(),
+		SeverityAt: c.carrySeverity(key, conn),
+		Threats:    threats,
 	}
+	entry.setDirection(old, conn)
+	entry.setLinkSeen(old)
+	c.connections[key] = entry
+	c.recordPort(conn)
+	c.touchWorkloads(conn)
+	c.enrichGeo(conn)
 
 	// 更新网络拓扑图
-	attr := &GraphAttr{
-		Bytes:        conn.Bytes,
-		Sessions:     conn.Sessions,
-		Severity:     conn.Severity,
-		PolicyAction: conn.PolicyAction,
-	}
+	attr := entry.graphAttr()
 	c.wlGraph.AddLink(conn.ClientWL, "graph", conn.ServerWL, attr)
 }
 
+// carrySeverity 保留已有连接的较高严重级别
+// 新上报的严重级别不低于已有级别时刷新衰减计时，返回当前级别的计时起点
+func (c *Cache) carrySeverity(key string, conn *controller.Connection) time.Time {
+	old, ok := c.connections[key]
+	if !ok || conn.Severity >= old.Connection.Severity {
+		return c.now()
+	}
+	conn.Severity = old.Connection.Severity
+	return old.SeverityAt
+}
+
+// byteRate 计算连接在首末次出现时间之间的平均字节速率（字节/秒）
+// 时间未知或首末次相同（仅观察到一次）时无法计算，返回0
+func byteRate(conn *controller.Connection) float64 {
+	if conn.FirstSeenAt.IsZero() || conn.LastSeenAt.IsZero() {
+		return 0
+	}
+	d := conn.LastSeenAt.Sub(conn.FirstSeenAt)
+	if d <= 0 {
+		return 0
+	}
+	return float64(conn.Bytes) / d.Seconds()
+}
+
 // connectionKey 生成连接key
 func (c *Cache) connectionKey(conn *controller.Connection) string {
 	return conn.ClientWL + "-" + conn.ServerWL
 }
 
+// ListConnections 列出所有连接
+// 在锁内复制，调用方可在连接并发更新时安全遍历和修改，按连接key排序
+func (c *Cache) ListConnections() []*controller.Connection {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	keys := make([]string, 0, len(c.connections))
+	for key := range c.connections {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := make([]*controller.Connection, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, copyConnection(c.connections[key].Connection))
+	}
+	return result
+}
+
+// copyConnection 深拷贝连接，IP和应用层元数据不与缓存共享
+func copyConnection(conn *controller.Connection) *controller.Connection {
+	dup := *conn
+	dup.ClientIP = append(net.IP(nil), conn.ClientIP...)
+	dup.ServerIP = append(net.IP(nil), conn.ServerIP...)
+	if conn.L7 != nil {
+		dup.L7 = append([]controller.L7Meta(nil), conn.L7...)
+	}
+	if conn.Agents != nil {
+		dup.Agents = append([]string(nil), conn.Agents...)
+	}
+	return &dup
+}
+
+// PurgeExpiredConnections 清理过期连接
+// 删除链接最近活跃时间早于TTL的连接及其拓扑链接，返回清理数量
+func (c *Cache) PurgeExpiredConnections() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	deadline := c.now().Add(-c.connectionTTL)
+	count := 0
+	for key, cache := range c.connections {
+		if cache.LinkSeenAt.Before(deadline) {
+			delete(c.connections, key)
+			c.forgetFlow(key, cache)
+			c.wlGraph.DeleteLink(cache.Connection.ClientWL, "graph", cache.Connection.ServerWL)
+			count++
+		}
+	}
+	return count
+}
+
+// DecaySeverity 衰减连接严重级别
+// 静默期内没有再上报同等或更高严重级别的连接降低一级，威胁日志不受影响，返回衰减的连接数
+func (c *Cache) DecaySeverity() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.severityQuiet <= 0 {
+		return 0
+	}
+
+	now := c.now()
+	count := 0
+	for _, cache := range c.connections {
+		conn := cache.Connection
+		if conn.Severity == 0 || now.Sub(cache.SeverityAt) < c.severityQuiet {
+			continue
+		}
+
+		// 替换而非原地修改，已返回给调用方的连接不受影响
+		updated := *conn
+		updated.Severity--
+		cache.Connection = &updated
+		cache.SeverityAt = now
+
+		attr := cache.graphAttr()
+		c.wlGraph.AddLink(updated.ClientWL, "graph", updated.ServerWL, attr)
+		count++
+	}
+	return count
+}
+
+// PolicyMatcher 策略匹配函数，与policy.Engine.MatchConnection一致
+type PolicyMatcher func(from, to string, clientIP, serverIP net.IP, port uint16, proto uint8, app uint32) (uint32, controller.PolicyAction)
+
+// ReevaluateConnections 按当前策略重新评估已有连接的策略动作
+// 只处理端点属于groups的连接，all为true时处理全部连接，返回动作发生变化的连接数
+func (c *Cache) ReevaluateConnections(groups []string, all bool, match PolicyMatcher) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	dirty := make(map[string]bool, len(groups))
+	for _, g := range groups {
+		dirty[g] = true
+	}
+
+	memberOf := c.workloadGroupNames()
+	count := 0
+	for _, cache := range c.connections {
+		conn := cache.Connection
+		from := policyEndpointNames(conn.ClientWL, memberOf)
+		to := policyEndpointNames(conn.ServerWL, memberOf)
+		if !all && !anyDirty(from, dirty) && !anyDirty(to, dirty) {
+			continue
+		}
+
+		id, action := matchEndpoints(from, to, conn, match)
+		if id == conn.PolicyID && uint8(action) == conn.PolicyAction {
+			continue
+		}
+
+		// 替换而非原地修改，已返回给调用方的连接不受影响
+		updated := *conn
+		updated.PolicyID = id
+		updated.PolicyAction = uint8(action)
+		cache.Connection = &updated
+
+		attr := cache.graphAttr()
+		c.wlGraph.AddLink(updated.ClientWL, "graph", updated.ServerWL, attr)
+		count++
+	}
+	return count
+}
+
+// workloadGroupNames 生成工作负载到所属组名的映射，组名已排序
+func (c *Cache) workloadGroupNames() map[string][]string {
+	memberOf := make(map[string][]string)
+	for name, cache := range c.groups {
+		for id := range cache.Members {
+			memberOf[id] = append(memberOf[id], name)
+		}
+	}
+	for _, names := range memberOf {
+		sort.Strings(names)
+	}
+	return memberOf
+}
+
+// policyEndpointNames 连接端点参与策略匹配的名称
+// 不属于任何组的端点直接使用其名称
+func policyEndpointNames(id string, memberOf map[string][]string) []string {
+	if names, ok := memberOf[id]; ok {
+		return names
+	}
+	return []string{id}
+}
+
+// anyDirty 检查名称是否有受规则变更影响的
+func anyDirty(names []string, dirty map[string]bool) bool {
+	for _, n := range names {
+		if dirty[n] {
+			return true
+		}
+	}
+	return false
+}
+
+// matchEndpoints 对端点所属组逐对匹配策略
+// 返回第一条命中的规则，均未命中时使用默认动作
+func matchEndpoints(from, to []string, conn *controller.Connection, match PolicyMatcher) (uint32, controller.PolicyAction) {
+	for _, f := range from {
+		for _, t := range to {
+			if id, action := match(f, t, conn.ClientIP, conn.ServerIP, conn.ServerPort, conn.IPProto, conn.Application); id != 0 {
+				return id, action
+			}
+		}
+	}
+	return match(from[0], to[0], conn.ClientIP, conn.ServerIP, conn.ServerPort, conn.IPProto, conn.Application)
+}
+
 // GraphAttr 图属性
 type GraphAttr struct {
 	Bytes        uint64
 	Sessions     uint32
 	Severity     uint8
 	PolicyAction uint8
+	PolicyID     uint32 // 产生策略动作的规则，0表示默认动作
+	LastSeenAt   time.Time
+
+	// 按方向拆分的计数
+	IngressBytes    uint64
+	IngressSessions uint32
+	EgressBytes     uint64
+	EgressSessions  uint32
+}
+
+// graphAttr 由连接缓存生成图链接属性
+func (cc *ConnectionCache) graphAttr() *GraphAttr {
+	conn := cc.Connection
+	return &GraphAttr{
+		Bytes:        conn.Bytes,
+		Sessions:     conn.Sessions,
+		Severity:     conn.Severity,
+		PolicyAction: conn.PolicyAction,
+		PolicyID:     conn.PolicyID,
+		LastSeenAt:   cc.LinkSeenAt,
+
+		IngressBytes:    cc.Ingress.Bytes,
+		IngressSessions: cc.Ingress.Sessions,
+		EgressBytes:     cc.Egress.Bytes,
+		EgressSessions:  cc.Egress.Sessions,
+	}
 }
 
 // --- 网络拓扑图 ---
 
 // GetNetworkGraph 获取网络拓扑图
-func (c *Cache) GetNetworkGraph() *controller.NetworkGraph {
+// domain非空时只返回该域内的工作负载及其链接，跨域链接的对端作为边界节点返回
+func (c *Cache) GetNetworkGraph(domain string) *controller.NetworkGraph {
+	return c.GetAggregatedNetworkGraph(domain, ExternalAggregation{})
+}
+
+// GetAggregatedNetworkGraph 获取外部端点按CIDR聚合的网络拓扑图
+// 同一网段的外部端点合并为一个external超级节点，指向同一对节点的链接计数相加，
+// 工作负载和主机保持独立
+func (c *Cache) GetAggregatedNetworkGraph(domain string, agg ExternalAggregation) *controller.NetworkGraph {
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
 
 	nodes := make([]controller.GraphNode, 0)
 	links := make([]controller.GraphLink, 0)
 
+	inDomain := func(id string) bool {
+		if domain == "" {
+			return true
+		}
+		cache, ok := c.workloads[id]
+		return ok && cache.Workload.Domain == domain
+	}
+
 	// 收集所有节点
 	for _, cache := range c.workloads {
-		nodes = append(nodes, controller.GraphNode{
-			ID:         cache.Workload.ID,
-			Name:       cache.Workload.Name,
-			Kind:       "workload",
-			Domain:     cache.Workload.Domain,
-			Service:    cache.Workload.Service,
-			PolicyMode: string(cache.Workload.PolicyMode),
-		})
+		if !inDomain(cache.Workload.ID) {
+			continue
+		}
+		nodes = append(nodes, workloadGraphNode(cache.Workload))
 	}
 
 	// 收集所有链接
+	boundary := make(map[string]bool)
+	merged := make(map[[2]string]int) // 超级节点链接 -> links下标
 	for _, cache := range c.connections {
 		conn := cache.Connection
-		links = append(links, controller.GraphLink{
-			From:         conn.ClientWL,
-			To:           conn.ServerWL,
-			Bytes:        conn.Bytes,
-			Sessions:     conn.Sessions,
-			Severity:     conn.Severity,
-			PolicyAction: conn.PolicyAction,
-		})
+		fromIn, toIn := inDomain(conn.ClientWL), inDomain(conn.ServerWL)
+		if !fromIn && !toIn {
+			continue
+		}
+		from, fromSuper := c.supernode(conn.ClientWL, conn.ClientIP, agg)
+		if !fromSuper {
+			from = conn.ClientWL
+		}
+		to, toSuper := c.supernode(conn.ServerWL, conn.ServerIP, agg)
+		if !toSuper {
+			to = conn.ServerWL
+		}
+		for _, end := range []struct {
+			id    string
+			super bool
+			geo   *controller.GeoInfo
+		}{{from, fromSuper, conn.ClientGeo}, {to, toSuper, conn.ServerGeo}} {
+			if boundary[end.id] {
+				continue
+			}
+			if end.super {
+				boundary[end.id] = true
+				nodes = append(nodes, controller.GraphNode{ID: end.id, Name: end.id, Kind: "external", Boundary: domain != ""})
+			} else if domain != "" && !inDomain(end.id) {
+				boundary[end.id] = true
+				node := c.boundaryGraphNode(end.id)
+				if node.Kind == "external" {
+					node.Geo = end.geo
+				}
+				nodes = append(nodes, node)
+			}
+		}
+		link := connectionGraphLink(cache, from, to)
+		if fromSuper || toSuper {
+			key := [2]string{from, to}
+			if i, ok := merged[key]; ok {
+				mergeGraphLink(&links[i], link)
+				continue
+			}
+			merged[key] = len(links)
+		}
+		links = append(links, link)
 	}
 
 	return &controller.NetworkGraph{
@@ -343,6 +902,55 @@ func (c *Cache) GetNetworkGraph() *controller.NetworkGraph {
 	}
 }
 
+// connectionGraphLink 由连接生成from到to的图链接
+func connectionGraphLink(cache *ConnectionCache, from, to string) controller.GraphLink {
+	conn := cache.Connection
+	return controller.GraphLink{
+		From:         from,
+		To:           to,
+		Bytes:        conn.Bytes,
+		Sessions:     conn.Sessions,
+		Severity:     conn.Severity,
+		PolicyAction: conn.PolicyAction,
+		PolicyID:     conn.PolicyID,
+		Threats:      append([]uint32(nil), cache.Threats...),
+		LastSeenAt:   cache.LinkSeenAt,
+		ByteRate:     conn.ByteRate,
+
+		IngressBytes:    cache.Ingress.Bytes,
+		IngressSessions: cache.Ingress.Sessions,
+		EgressBytes:     cache.Egress.Bytes,
+		EgressSessions:  cache.Egress.Sessions,
+	}
+}
+
+// workloadGraphNode 由工作负载生成图节点
+func workloadGraphNode(wl *controller.Workload) controller.GraphNode {
+	return controller.GraphNode{
+		ID:         wl.ID,
+		Name:       wl.Name,
+		Kind:       "workload",
+		Domain:     wl.Domain,
+		Service:    wl.Service,
+		PolicyMode: string(wl.PolicyMode),
+	}
+}
+
+// boundaryGraphNode 生成域外端点的边界节点
+// 已知工作负载保留其信息，主机和其他未知端点分别标记为host和external
+func (c *Cache) boundaryGraphNode(id string) controller.GraphNode {
+	var node controller.GraphNode
+	if cache, ok := c.workloads[id]; ok {
+		node = workloadGraphNode(cache.Workload)
+	} else if cache, ok := c.hosts[id]; ok {
+		node = controller.GraphNode{ID: id, Name: cache.Host.Name, Kind: "host"}
+	} else {
+		node = controller.GraphNode{ID: id, Name: id, Kind: "external"}
+	}
+	node.Boundary = true
+	return node
+}
+
 // GetGraphNodeCount 获取图节点数量
 func (c *Cache) GetGraphNodeCount() int {
 	return c.wlGraph.GetNodeCount()
@@ -353,6 +961,47 @@ func (c *Cache) GetGraphLinkCount() int {
 	return c.wlGraph.GetLinkCount()
 }
 
+// MaxGraphStatsTop 图统计返回的节点数上限
+const MaxGraphStatsTop = 100
+
+// GetGraphStats 获取拓扑图节点度数统计
+// 返回按fan-in、fan-out及总度数排序的前top个节点，top超过MaxGraphStatsTop时按上限截断
+func (c *Cache) GetGraphStats(top int) *controller.GraphStats {
+	if top > MaxGraphStatsTop {
+		top = MaxGraphStatsTop
+	}
+	degrees := c.wlGraph.Degrees()
+	stats := &controller.GraphStats{Nodes: len(degrees), Links: c.wlGraph.GetLinkCount()}
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	convert := func(list []graph.NodeDegree) []controller.NodeDegree {
+		ret := make([]controller.NodeDegree, 0, len(list))
+		for _, d := range list {
+			node := c.boundaryGraphNode(d.Node)
+			ret = append(ret, controller.NodeDegree{
+				ID: d.Node, Name: node.Name, Kind: node.Kind, In: d.In, Out: d.Out,
+			})
+		}
+		return ret
+	}
+	stats.TopFanIn = convert(graph.TopDegrees(degrees, top, func(d graph.NodeDegree) int { return d.In }))
+	stats.TopFanOut = convert(graph.TopDegrees(degrees, top, func(d graph.NodeDegree) int { return d.Out }))
+	stats.TopDegree = convert(graph.TopDegrees(degrees, top, func(d graph.NodeDegree) int { return d.In + d.Out }))
+	return stats
+}
+
+// GetGraphCommunities 获取拓扑图社区划分
+func (c *Cache) GetGraphCommunities() *controller.GraphCommunities {
+	nodes := c.wlGraph.Communities()
+	ids := make(map[int]bool)
+	for _, id := range nodes {
+		ids[id] = true
+	}
+	return &controller.GraphCommunities{Count: len(ids), Nodes: nodes}
+}
+
 // --- 主机管理 ---
 
 // AddHost 添加主机
@@ -438,97 +1087,485 @@ func (c *Cache) UpdateAgentStatus(id string, online bool) {
 }
 
 // UpdateWorkloadFromProto 从proto更新工作负载
-func (c *Cache) UpdateWorkloadFromProto(wl *pb.Workload) {
-	if wl == nil {
-		return
+// 不记录上报的Agent，等同于agentID为空的UpdateAgentWorkloadFromProto
+func (c *Cache) UpdateWorkloadFromProto(wl *pb.Workload) error {
+	return c.UpdateAgentWorkloadFromProto("", wl)
+}
+
+// UpdateAgentWorkloadFromProto 从proto更新Agent上报的工作负载
+// 缺少ID的记录被拒绝，无法解析的接口地址被忽略
+func (c *Cache) UpdateAgentWorkloadFromProto(agentID string, wl *pb.Workload) error {
+	workload, err := workloadFromProto(wl)
+	if err != nil {
+		return err
 	}
+	workload.AgentID = agentID
 
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
+	c.naming.apply(workload)
+	c.storeWorkload(workload)
+	return nil
+}
+
+// ReplaceAgentWorkloadsFromProto 以Agent注册时上报的完整列表替换其工作负载
+// 忽略缺少ID的工作负载，返回删除的数量
+func (c *Cache) ReplaceAgentWorkloadsFromProto(agentID string, pbWls []*pb.Workload) int {
+	c.mutex.RLock()
+	naming := c.naming
+	c.mutex.RUnlock()
+
+	wls := make([]*controller.Workload, 0, len(pbWls))
+	for _, wl := range pbWls {
+		if workload, err := workloadFromProto(wl); err == nil {
+			naming.apply(workload)
+			wls = append(wls, workload)
+		}
+	}
+	return c.ReplaceAgentWorkloads(agentID, wls)
+}
+
+// workloadFromProto 转换proto工作负载，拒绝缺少ID的工作负载
+func workloadFromProto(wl *pb.Workload) (*controller.Workload, error) {
+	if wl == nil {
+		return nil, fmt.Errorf("nil workload")
+	}
+	if wl.Id == "" {
+		log.WithField("name", wl.Name).Warn("Reject workload without id")
+		return nil, fmt.Errorf("missing workload id")
+	}
+
 	// 转换接口
+	ifaces := ifacesFromProto(wl.Id, wl.Ifaces)
+
+	// 转换策略模式
+	var mode controller.PolicyMode
+	switch wl.PolicyMode {
+	case "Protect":
+		mode = controller.PolicyModeProtect
+	default:
+		mode = controller.PolicyModeMonitor
+	}
+
+	return &controller.Workload{
+		ID:         wl.Id,
+		Name:       wl.Name,
+		HostID:     wl.HostId,
+		HostName:   wl.HostName,
+		Domain:     wl.Domain,
+		Service:    wl.Service,
+		Image:      wl.Image,
+		PolicyMode: mode,
+		Running:    wl.Running,
+		Ifaces:     ifaces,
+		Labels:     wl.Labels,
+		PodName:    wl.PodName,
+		OwnerKind:  wl.OwnerKind,
+		OwnerName:  wl.OwnerName,
+		State:      wl.State,
+		Health:     wl.Health,
+	}, nil
+}
+
+// ifacesFromProto 转换网络接口，忽略非法地址
+// owner为接口所属的工作负载或主机ID，用于日志
+func ifacesFromProto(owner string, pbIfaces []*pb.NetworkInterface) map[string][]controller.IPAddr {
 	ifaces := make(map[string][]controller.IPAddr)
-	for _, iface := range wl.Ifaces {
+	for _, iface := range pbIfaces {
+		if iface == nil {
+			continue
+		}
 		addrs := make([]controller.IPAddr, 0, len(iface.Addrs))
 		for _, addr := range iface.Addrs {
+			if addr == nil {
+				continue
+			}
+			ip := net.ParseIP(addr.Ip)
+			if ip == nil {
+				log.WithFields(log.Fields{
+					"owner": owner, "iface": iface.Name, "ip": addr.Ip,
+				}).Warn("Ignore invalid interface address")
+				continue
+			}
 			addrs = append(addrs, controller.IPAddr{
-				IP:    net.ParseIP(addr.Ip),
+				IP:    ip,
 				Scope: addr.Scope,
 			})
 		}
 		ifaces[iface.Name] = addrs
 	}
+	return ifaces
+}
 
-	// 转换策略模式
-	var mode controller.PolicyMode
-	switch wl.PolicyMode {
-	case "Protect":
-		mode = controller.PolicyModeProtect
-	default:
-		mode = controller.PolicyModeMonitor
+// UpdateHostFromProto 从proto更新主机
+// 保留已关联的工作负载列表
+func (c *Cache) UpdateHostFromProto(host *pb.Host) error {
+	if host == nil {
+		return fmt.Errorf("nil host")
+	}
+	if host.Id == "" {
+		log.WithField("name", host.Name).Warn("Reject host without id")
+		return fmt.Errorf("missing host id")
 	}
 
-	c.workloads[wl.Id] = &WorkloadCache{
-		Workload: &controller.Workload{
-			ID:         wl.Id,
-			Name:       wl.Name,
-			HostID:     wl.HostId,
-			HostName:   wl.HostName,
-			Domain:     wl.Domain,
-			Service:    wl.Service,
-			Image:      wl.Image,
-			PolicyMode: mode,
-			Running:    wl.Running,
-			Ifaces:     ifaces,
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	workloads := make([]string, 0)
+	if old, ok := c.hosts[host.Id]; ok {
+		workloads = old.Workloads
+	}
+	c.hosts[host.Id] = &HostCache{
+		Host: &controller.Host{
+			ID:       host.Id,
+			Name:     host.Name,
+			Platform: host.Platform,
+			Ifaces:   ifacesFromProto(host.Id, host.Ifaces),
 		},
-		PolicyMode: mode,
-		LastSeenAt: time.Now(),
+		Workloads: workloads,
 	}
+	return nil
 }
 
-// UpdateConnectionFromProto 从proto更新连接
-func (c *Cache) UpdateConnectionFromProto(conn *pb.Connection) {
+// l7FromProto 转换应用层元数据，忽略空记录
+func l7FromProto(meta []*pb.L7Metadata) []controller.L7Meta {
+	var result []controller.L7Meta
+	for _, m := range meta {
+		if m == nil {
+			continue
+		}
+		l7 := controller.L7Meta{
+			HTTPMethod: m.HttpMethod,
+			HTTPHost:   m.HttpHost,
+			DNSQuery:   m.DnsQuery,
+			TLSSNI:     m.TlsSni,
+		}
+		if l7 != (controller.L7Meta{}) {
+			result = append(result, l7)
+		}
+	}
+	return result
+}
+
+// mergeL7 合并应用层元数据，去重并保留最多maxL7Variants条
+func mergeL7(dst, src []controller.L7Meta) []controller.L7Meta {
+	for _, m := range src {
+		if len(dst) >= maxL7Variants {
+			break
+		}
+		dup := false
+		for _, d := range dst {
+			if d == m {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			dst = append(dst, m)
+		}
+	}
+	return dst
+}
+
+// UpdateConnectionFromProto 从proto更新连接，上报的Agent未知
+// IP字节长度非法的记录被拒绝，不写入缓存和拓扑图
+func (c *Cache) UpdateConnectionFromProto(conn *pb.Connection) error {
+	return c.UpdateAgentConnection("", conn)
+}
+
+// UpdateAgentConnection 从agentID上报的proto更新连接
+// 客户端和服务端所在主机的Agent都上报同一流量时合并为一条连接，Agents记录观察到该流量的Agent
+func (c *Cache) UpdateAgentConnection(agentID string, conn *pb.Connection) error {
 	if conn == nil {
-		return
+		return fmt.Errorf("nil connection")
+	}
+
+	clientIP := pb.DecodeIP(conn.ClientIp)
+	serverIP := pb.DecodeIP(conn.ServerIp)
+	if clientIP == nil || serverIP == nil {
+		log.WithFields(log.Fields{
+			"client_wl": conn.ClientWl, "server_wl": conn.ServerWl,
+			"client_ip_len": len(conn.ClientIp), "server_ip_len": len(conn.ServerIp),
+		}).Warn("Reject connection with malformed IP")
+		return fmt.Errorf("malformed connection ip")
 	}
 
+	// 端口、协议和策略动作超出范围说明记录已损坏，拒绝；严重级别截断为最大值
+	fields := log.Fields{"client_wl": conn.ClientWl, "server_wl": conn.ServerWl}
+	if err := checkRange(fields, "client_port", conn.ClientPort, math.MaxUint16); err != nil {
+		return err
+	}
+	if err := checkRange(fields, "server_port", conn.ServerPort, math.MaxUint16); err != nil {
+		return err
+	}
+	if err := checkRange(fields, "ip_proto", conn.IpProto, math.MaxUint8); err != nil {
+		return err
+	}
+	if err := checkRange(fields, "policy_action", conn.PolicyAction, uint32(controller.PolicyActionViolate)); err != nil {
+		return err
+	}
+	severity := clampUint8(fields, "severity", conn.Severity)
+
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
 	ctrlConn := &controller.Connection{
 		ClientWL:     conn.ClientWl,
 		ServerWL:     conn.ServerWl,
-		ClientIP:     net.IP(conn.ClientIp),
-		ServerIP:     net.IP(conn.ServerIp),
+		ClientIP:     clientIP,
+		ServerIP:     serverIP,
 		ClientPort:   uint16(conn.ClientPort),
 		ServerPort:   uint16(conn.ServerPort),
 		IPProto:      uint8(conn.IpProto),
 		Application:  conn.Application,
 		Bytes:        conn.Bytes,
 		Sessions:     conn.Sessions,
-		FirstSeenAt:  conn.FirstSeenAt,
-		LastSeenAt:   conn.LastSeenAt,
+		FirstSeenAt:  secondsToTime(conn.FirstSeenAt),
+		LastSeenAt:   secondsToTime(conn.LastSeenAt),
 		ThreatID:     conn.ThreatId,
-		Severity:     uint8(conn.Severity),
+		Severity:     severity,
 		PolicyAction: uint8(conn.PolicyAction),
 		PolicyID:     conn.PolicyId,
 		Ingress:      conn.Ingress,
 		ExternalPeer: conn.ExternalPeer,
 		LocalPeer:    conn.LocalPeer,
+		Capped:       conn.Capped,
+		Summary:      conn.Summary,
+
+		InternetIngress: conn.InternetIngress,
+	}
+
+	ctrlConn.ByteRate = byteRate(ctrlConn)
+	if agentID != "" {
+		ctrlConn.Agents = []string{agentID}
 	}
 
+	// 同一流量已由其他Agent以不同的端点标识上报时，合并到已有连接
 	key := ctrlConn.ClientWL + "-" + ctrlConn.ServerWL
-	c.connections[key] = &ConnectionCache{
+	oldKey := key
+	observed := ctrlConn
+	observers := map[string]*controller.Connection{agentID: observed}
+	flow, tracked := newFlowKey(ctrlConn)
+	if cache, k, ok := c.findFlow(flow); tracked && ok {
+		for id, obs := range cache.Observers {
+			if id != agentID {
+				observers[id] = obs
+			}
+		}
+		if len(observers) > 1 {
+			oldKey = k
+			ctrlConn = c.mergeObservations(observers)
+			key = ctrlConn.ClientWL + "-" + ctrlConn.ServerWL
+		}
+	}
+
+	var l7 []controller.L7Meta
+	var threats []uint32
+	old, ok := c.connections[oldKey]
+	if ok {
+		l7 = old.Connection.L7
+		threats = old.Threats
+	}
+	ctrlConn.L7 = mergeL7(l7, l7FromProto(conn.L7))
+
+	entry := &ConnectionCache{
 		Connection: ctrlConn,
 		GraphKey:   key,
+		UpdatedAt:  c.now(),
+		SeverityAt: c.carrySeverity(oldKey, ctrlConn),
+		Threats:    threats,
+		Observers:  observers,
+		flow:       flow,
+		tracked:    tracked,
+	}
+	entry.setDirection(old, observed)
+	entry.setLinkSeen(old)
+	if ok && key != oldKey {
+		// 合并后端点标识变化，移除按原端点标识保存的连接和链接
+		delete(c.connections, oldKey)
+		c.forgetFlow(oldKey, old)
+		c.wlGraph.DeleteLink(old.Connection.ClientWL, "graph", old.Connection.ServerWL)
+	}
+	c.connections[key] = entry
+	if tracked {
+		c.flows[flow] = key
 	}
+	c.recordPort(ctrlConn)
+	c.touchWorkloads(ctrlConn)
+	c.enrichGeo(ctrlConn)
+	c.recordViolation(ctrlConn)
 
 	// 更新网络拓扑图
-	attr := &GraphAttr{
-		Bytes:        ctrlConn.Bytes,
-		Sessions:     ctrlConn.Sessions,
-		Severity:     ctrlConn.Severity,
-		PolicyAction: ctrlConn.PolicyAction,
-	}
+	attr := entry.graphAttr()
 	c.wlGraph.AddLink(ctrlConn.ClientWL, "graph", ctrlConn.ServerWL, attr)
+	return nil
+}
+
+// checkRange 检查proto整型字段是否超出目标类型范围，超出时记录日志并返回错误
+func checkRange(fields log.Fields, field string, v, max uint32) error {
+	if v <= max {
+		return nil
+	}
+	log.WithFields(fields).WithFields(log.Fields{field: v, "max": max}).Warn("Reject out of range field")
+	return fmt.Errorf("%s out of range: %d", field, v)
+}
+
+// clampUint8 转换为uint8，超出范围时截断为最大值并记录日志
+func clampUint8(fields log.Fields, field string, v uint32) uint8 {
+	if v <= math.MaxUint8 {
+		return uint8(v)
+	}
+	log.WithFields(fields).WithField(field, v).Warn("Clamp out of range field")
+	return math.MaxUint8
+}
+
+// --- 威胁日志 ---
+
+// AddThreatFromProto 保存Agent上报的威胁日志
+// 超过maxThreatLogs时丢弃最早的记录
+func (c *Cache) AddThreatFromProto(agentID string, t *pb.ThreatLog) error {
+	if t == nil {
+		return fmt.Errorf("nil threat log")
+	}
+
+	fields := log.Fields{"agent_id": agentID, "threat_id": t.ThreatId}
+	if err := checkRange(fields, "server_port", t.ServerPort, math.MaxUint16); err != nil {
+		return err
+	}
+	if err := checkRange(fields, "ip_proto", t.IpProto, math.MaxUint8); err != nil {
+		return err
+	}
+	if err := checkRange(fields, "tcp_flags", t.TcpFlags, math.MaxUint8); err != nil {
+		return err
+	}
+	pktLen := uint16(math.MaxUint16)
+	if t.PktLen <= math.MaxUint16 {
+		pktLen = uint16(t.PktLen)
+	} else {
+		log.WithFields(fields).WithField("pkt_len", t.PktLen).Warn("Clamp out of range packet length")
+	}
+
+	threat := &controller.ThreatLog{
+		ID:         t.Id,
+		ThreatID:   t.ThreatId,
+		ThreatName: t.ThreatName,
+		Severity:   t.Severity,
+		ClientWL:   t.ClientWl,
+		ServerWL:   t.ServerWl,
+		ServerPort: uint16(t.ServerPort),
+		IPProto:    uint8(t.IpProto),
+		PktIngress: t.PktIngress,
+		AgentID:    agentID,
+		TcpFlags:   uint8(t.TcpFlags),
+		PktLen:     pktLen,
+		PktSummary: t.PktSummary,
+	}
+	if threat.ThreatName == "" {
+		threat.ThreatName = threatName(t.ThreatId)
+	}
+	if ip := pb.DecodeIP(t.ClientIp); ip != nil {
+		threat.ClientIP = ip.String()
+	}
+	if ip := pb.DecodeIP(t.ServerIp); ip != nil {
+		threat.ServerIP = ip.String()
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if t.ReportedAt != 0 {
+		threat.ReportedAt = time.Unix(int64(t.ReportedAt), 0)
+	} else {
+		threat.ReportedAt = c.now()
+	}
+
+	c.correlateThreat(threat)
+
+	c.threats = append(c.threats, threat)
+	if n := len(c.threats) - maxThreatLogs; n > 0 {
+		// 切掉头部，append扩容时旧数组随之释放
+		c.threats = c.threats[n:]
+	}
+	if c.onAlert != nil {
+		c.onAlert(&controller.Alert{
+			Type:     controller.AlertTypeThreat,
+			Severity: severityFromString(threat.Severity),
+			Threat:   threat,
+		})
+	}
+	return nil
+}
+
+// correlateThreat 将威胁关联到五元组匹配的连接
+// 按客户端/服务端IP、服务端端口和协议匹配，关联后提升连接严重级别
+// 没有匹配连接时威胁仅作为独立日志保存，调用方需持有写锁
+func (c *Cache) correlateThreat(threat *controller.ThreatLog) int {
+	severity := severityFromString(threat.Severity)
+	count := 0
+	for _, cache := range c.connections {
+		conn := cache.Connection
+		if conn.ServerPort != threat.ServerPort || conn.IPProto != threat.IPProto ||
+			conn.ClientIP.String() != threat.ClientIP || conn.ServerIP.String() != threat.ServerIP {
+			continue
+		}
+		count++
+
+		if !containsThreat(cache.Threats, threat.ThreatID) && len(cache.Threats) < maxConnThreats {
+			cache.Threats = append(cache.Threats, threat.ThreatID)
+		}
+		if severity <= conn.Severity {
+			continue
+		}
+
+		// 替换而非原地修改，已返回给调用方的连接不受影响
+		updated := *conn
+		updated.Severity = severity
+		if updated.ThreatID == 0 {
+			updated.ThreatID = threat.ThreatID
+		}
+		cache.Connection = &updated
+		cache.SeverityAt = c.now()
+
+		attr := cache.graphAttr()
+		c.wlGraph.AddLink(updated.ClientWL, "graph", updated.ServerWL, attr)
+	}
+	return count
+}
+
+// containsThreat 检查威胁ID是否已关联
+func containsThreat(ids []uint32, id uint32) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// severityFromString 将威胁日志的严重级别转换为连接严重级别
+// 与Agent上报时的转换相反，未知级别视为Info
+func severityFromString(severity string) uint8 {
+	switch strings.ToLower(severity) {
+	case "low":
+		return 1
+	case "medium":
+		return 2
+	case "high":
+		return 3
+	case "critical":
+		return 4
+	default:
+		return 0
+	}
+}
+
+// ListThreats 列出威胁日志，按上报顺序排列
+func (c *Cache) ListThreats() []*controller.ThreatLog {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	result := make([]*controller.ThreatLog, len(c.threats))
+	copy(result, c.threats)
+	return result
 }
@@ -0,0 +1,1436 @@
+package cache
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/micro-segment/api/proto"
+	controller "github.com/micro-segment/internal/controller"
+	"github.com/micro-segment/internal/controller/policy"
+)
+
+var (
+	ip1 = []byte{10, 0, 0, 1}
+	ip2 = []byte{10, 0, 0, 2}
+)
+
+func TestSecondsToTime(t *testing.T) {
+	if ts := secondsToTime(0); !ts.IsZero() {
+		t.Errorf("Zero seconds should be unknown: %v", ts)
+	}
+
+	ts := secondsToTime(1700000000)
+	if ts.Unix() != 1700000000 {
+		t.Errorf("Unexpected time: %v", ts)
+	}
+}
+
+func TestConnectionTimesFromProto(t *testing.T) {
+	c := NewCache()
+	c.UpdateConnectionFromProto(&pb.Connection{
+		ClientWl:    "wl1",
+		ServerWl:    "wl2",
+		ClientIp:    ip1,
+		ServerIp:    ip2,
+		FirstSeenAt: 1700000000,
+		LastSeenAt:  1700000060,
+	})
+
+	conns := c.ListConnections()
+	if len(conns) != 1 {
+		t.Fatalf("Unexpected connections: %d", len(conns))
+	}
+	if conns[0].FirstSeenAt.Unix() != 1700000000 || conns[0].LastSeenAt.Unix() != 1700000060 {
+		t.Errorf("Unexpected times: %v %v", conns[0].FirstSeenAt, conns[0].LastSeenAt)
+	}
+}
+
+func TestListConnectionsCopies(t *testing.T) {
+	c := NewCache()
+	c.UpdateConnectionFromProto(&pb.Connection{
+		ClientWl: "b", ServerWl: "c", ClientIp: ip1, ServerIp: ip2,
+		L7: []*pb.L7Metadata{{HttpHost: "api"}},
+	})
+	c.UpdateConnectionFromProto(&pb.Connection{ClientWl: "a", ServerWl: "b", ClientIp: ip1, ServerIp: ip2})
+
+	conns := c.ListConnections()
+	if len(conns) != 2 || conns[0].ClientWL != "a" || conns[1].ClientWL != "b" {
+		t.Fatalf("Unexpected connections: %+v", conns)
+	}
+
+	// 修改返回值不影响缓存
+	conns[1].Bytes = 999
+	conns[1].ClientIP[3] = 99
+	conns[1].L7[0].HTTPHost = "changed"
+	again := c.ListConnections()
+	if again[1].Bytes != 0 || !again[1].ClientIP.Equal(net.IP(ip1)) || again[1].L7[0].HTTPHost != "api" {
+		t.Errorf("Cache mutated through returned copy: %+v", again[1])
+	}
+
+	// 并发更新时遍历安全，需配合-race运行
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			c.UpdateConnectionFromProto(&pb.Connection{
+				ClientWl: "a", ServerWl: fmt.Sprintf("s%d", i%10), ClientIp: ip1, ServerIp: ip2, Bytes: uint64(i),
+			})
+			c.DecaySeverity()
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		for _, conn := range c.ListConnections() {
+			_ = conn.Bytes + uint64(conn.Severity) + uint64(conn.PolicyAction)
+		}
+	}
+	<-done
+}
+
+func TestPurgeExpiredConnections(t *testing.T) {
+	now := time.Unix(1700001000, 0)
+	c := NewCache()
+	c.now = func() time.Time { return now }
+	c.SetConnectionTTL(300 * time.Second)
+
+	// 最近活跃
+	c.UpdateConnectionFromProto(&pb.Connection{ClientWl: "a", ServerWl: "b", ClientIp: ip1, ServerIp: ip2, LastSeenAt: 1700000900})
+	// 已过期
+	c.UpdateConnectionFromProto(&pb.Connection{ClientWl: "a", ServerWl: "c", ClientIp: ip1, ServerIp: ip2, LastSeenAt: 1700000100})
+	// 时间未知，以上报时间为准
+	c.UpdateConnectionFromProto(&pb.Connection{ClientWl: "a", ServerWl: "d", ClientIp: ip1, ServerIp: ip2})
+
+	if n := c.PurgeExpiredConnections(); n != 1 {
+		t.Errorf("Unexpected purge count: %d", n)
+	}
+	if len(c.ListConnections()) != 2 {
+		t.Errorf("Unexpected connections: %v", c.ListConnections())
+	}
+	if c.GetGraphLinkCount() != 2 {
+		t.Errorf("Unexpected graph links: %d", c.GetGraphLinkCount())
+	}
+
+	// 上报时间也过期后被清理
+	now = now.Add(time.Hour)
+	if n := c.PurgeExpiredConnections(); n != 2 {
+		t.Errorf("Unexpected purge count: %d", n)
+	}
+}
+
+func TestMalformedConnectionIP(t *testing.T) {
+	c := NewCache()
+
+	bad := [][]byte{nil, {}, {10, 0, 0}, {1, 2, 3, 4, 5}, make([]byte, 17)}
+	for _, b := range bad {
+		if err := c.UpdateConnectionFromProto(&pb.Connection{ClientWl: "a", ServerWl: "b", ClientIp: b, ServerIp: ip2}); err == nil {
+			t.Errorf("Malformed client ip accepted: %v", b)
+		}
+		if err := c.UpdateConnectionFromProto(&pb.Connection{ClientWl: "a", ServerWl: "b", ClientIp: ip1, ServerIp: b}); err == nil {
+			t.Errorf("Malformed server ip accepted: %v", b)
+		}
+	}
+	if err := c.UpdateConnectionFromProto(nil); err == nil {
+		t.Errorf("Nil connection accepted")
+	}
+	if len(c.ListConnections()) != 0 || c.GetGraphLinkCount() != 0 {
+		t.Errorf("Malformed connections stored")
+	}
+
+	v6 := net.ParseIP("fd00::1")
+	if err := c.UpdateConnectionFromProto(&pb.Connection{ClientWl: "a", ServerWl: "b", ClientIp: ip1, ServerIp: v6}); err != nil {
+		t.Errorf("Valid connection rejected: %v", err)
+	}
+	conns := c.ListConnections()
+	if len(conns) != 1 || !conns[0].ServerIP.Equal(v6) {
+		t.Errorf("Unexpected connections: %v", conns)
+	}
+}
+
+func TestConnectionIPEncoding(t *testing.T) {
+	c := NewCache()
+	v6 := net.ParseIP("2001:db8::1")
+	c.UpdateConnectionFromProto(&pb.Connection{ClientWl: "a", ServerWl: "b",
+		ClientIp: pb.EncodeIP(net.IPv4(10, 0, 0, 1)), ServerIp: pb.EncodeIP(v6)})
+	c.AddThreatFromProto("agent", &pb.ThreatLog{ThreatId: 1, ClientWl: "a", ServerWl: "b",
+		ClientIp: []byte{10, 0, 0, 1}, ServerIp: pb.EncodeIP(v6)})
+
+	conns := c.ListConnections()
+	if len(conns) != 1 {
+		t.Fatalf("Unexpected connections: %v", conns)
+	}
+	if conns[0].ClientIP.String() != "10.0.0.1" || len(conns[0].ClientIP) != net.IPv4len {
+		t.Errorf("IPv4 not decoded to 4-byte form: %v", []byte(conns[0].ClientIP))
+	}
+	if !conns[0].ServerIP.Equal(v6) || conns[0].ServerIP.To4() != nil {
+		t.Errorf("IPv6 misclassified: %v", conns[0].ServerIP)
+	}
+	// 新旧编码上报的威胁与连接按IP关联
+	if threats := c.ListThreats(); len(threats) != 1 || threats[0].ClientIP != "10.0.0.1" || threats[0].ServerIP != "2001:db8::1" {
+		t.Errorf("Unexpected threats: %+v", threats)
+	}
+	if links := c.GetNetworkGraph("").Links; len(links) != 1 || len(links[0].Threats) != 1 {
+		t.Errorf("Threat not correlated: %+v", links)
+	}
+}
+
+func TestMalformedWorkload(t *testing.T) {
+	c := NewCache()
+
+	if err := c.UpdateWorkloadFromProto(nil); err == nil {
+		t.Errorf("Nil workload accepted")
+	}
+	if err := c.UpdateWorkloadFromProto(&pb.Workload{Name: "noid"}); err == nil {
+		t.Errorf("Workload without id accepted")
+	}
+
+	err := c.UpdateWorkloadFromProto(&pb.Workload{
+		Id: "wl1",
+		Ifaces: []*pb.NetworkInterface{
+			nil,
+			{Name: "eth0", Addrs: []*pb.IPAddress{nil, {Ip: "garbage"}, {Ip: "172.17.0.2"}}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Valid workload rejected: %v", err)
+	}
+	wl := c.GetWorkload("wl1")
+	if wl == nil || len(wl.Ifaces["eth0"]) != 1 {
+		t.Errorf("Unexpected workload: %+v", wl)
+	}
+}
+
+func TestWorkloadPodMetadata(t *testing.T) {
+	c := NewCache()
+
+	err := c.UpdateWorkloadFromProto(&pb.Workload{
+		Id:        "wl1",
+		Name:      "k8s_nginx_web-7d4b9c8f6d-x2k9p_shop",
+		Domain:    "shop",
+		PodName:   "web-7d4b9c8f6d-x2k9p",
+		OwnerKind: "Deployment",
+		OwnerName: "web",
+		State:     "paused",
+		Health:    "unhealthy",
+	})
+	if err != nil {
+		t.Fatalf("Workload rejected: %v", err)
+	}
+	wl := c.GetWorkload("wl1")
+	if wl.Domain != "shop" || wl.PodName != "web-7d4b9c8f6d-x2k9p" {
+		t.Errorf("Unexpected pod: %s/%s", wl.Domain, wl.PodName)
+	}
+	if wl.OwnerKind != "Deployment" || wl.OwnerName != "web" {
+		t.Errorf("Unexpected owner: %s/%s", wl.OwnerKind, wl.OwnerName)
+	}
+	if wl.State != "paused" || wl.Health != "unhealthy" {
+		t.Errorf("Unexpected state: %s/%s", wl.State, wl.Health)
+	}
+}
+
+func TestConnectionL7FromProto(t *testing.T) {
+	c := NewCache()
+
+	report := func(l7 ...*pb.L7Metadata) {
+		err := c.UpdateConnectionFromProto(&pb.Connection{
+			ClientWl: "a", ServerWl: "b", ClientIp: ip1, ServerIp: ip2, L7: l7,
+		})
+		if err != nil {
+			t.Fatalf("Connection rejected: %v", err)
+		}
+	}
+
+	report(nil, &pb.L7Metadata{}, &pb.L7Metadata{HttpMethod: "GET", HttpHost: "web"})
+	conns := c.ListConnections()
+	if len(conns) != 1 || len(conns[0].L7) != 1 {
+		t.Fatalf("Unexpected connections: %+v", conns)
+	}
+	if l7 := conns[0].L7[0]; l7.HTTPMethod != "GET" || l7.HTTPHost != "web" {
+		t.Errorf("Unexpected L7 metadata: %+v", l7)
+	}
+
+	// 后续上报与已有元数据合并去重，并限制条数
+	for i := 0; i < maxL7Variants*2; i++ {
+		report(&pb.L7Metadata{HttpMethod: "GET", HttpHost: "web"},
+			&pb.L7Metadata{DnsQuery: fmt.Sprintf("host%d.internal", i)})
+	}
+	conns = c.ListConnections()
+	if len(conns[0].L7) != maxL7Variants {
+		t.Errorf("Expected %d L7 variants, got %d", maxL7Variants, len(conns[0].L7))
+	}
+	if conns[0].L7[0].HTTPHost != "web" || conns[0].L7[1].DNSQuery != "host0.internal" {
+		t.Errorf("Unexpected L7 order: %+v", conns[0].L7)
+	}
+}
+
+func TestNetworkGraphDomainFilter(t *testing.T) {
+	c := NewCache()
+	for _, wl := range []*pb.Workload{
+		{Id: "web", Domain: "shop"},
+		{Id: "api", Domain: "shop"},
+		{Id: "db", Domain: "data"},
+		{Id: "etl", Domain: "data"},
+	} {
+		if err := c.UpdateWorkloadFromProto(wl); err != nil {
+			t.Fatalf("Workload rejected: %v", err)
+		}
+	}
+	c.AddHost(&controller.Host{ID: "host1", Name: "node-1"})
+
+	for _, l := range [][2]string{
+		{"web", "api"},      // 域内
+		{"api", "db"},       // 跨域
+		{"etl", "db"},       // 其他域内
+		{"external", "web"}, // 外部入站
+		{"host1", "api"},    // 主机
+	} {
+		err := c.UpdateConnectionFromProto(&pb.Connection{ClientWl: l[0], ServerWl: l[1], ClientIp: ip1, ServerIp: ip2})
+		if err != nil {
+			t.Fatalf("Connection rejected: %v", err)
+		}
+	}
+
+	if g := c.GetNetworkGraph(""); len(g.Nodes) != 4 || len(g.Links) != 5 {
+		t.Errorf("Unexpected full graph: %d nodes %d links", len(g.Nodes), len(g.Links))
+	}
+
+	g := c.GetNetworkGraph("shop")
+	nodes := make(map[string]controller.GraphNode)
+	for _, n := range g.Nodes {
+		nodes[n.ID] = n
+	}
+	if len(nodes) != 5 || len(g.Links) != 4 {
+		t.Fatalf("Unexpected shop graph: %+v", g)
+	}
+	if n := nodes["web"]; n.Boundary || n.Kind != "workload" {
+		t.Errorf("Unexpected in-domain node: %+v", n)
+	}
+	if n := nodes["db"]; !n.Boundary || n.Kind != "workload" || n.Domain != "data" {
+		t.Errorf("Unexpected cross-domain node: %+v", n)
+	}
+	if n := nodes["external"]; !n.Boundary || n.Kind != "external" {
+		t.Errorf("Unexpected external node: %+v", n)
+	}
+	if n := nodes["host1"]; !n.Boundary || n.Kind != "host" || n.Name != "node-1" {
+		t.Errorf("Unexpected host node: %+v", n)
+	}
+	if _, ok := nodes["etl"]; ok {
+		t.Errorf("Unrelated workload included")
+	}
+	for _, l := range g.Links {
+		if l.From == "etl" {
+			t.Errorf("Unrelated link included: %+v", l)
+		}
+	}
+
+	if g := c.GetNetworkGraph("none"); len(g.Nodes) != 0 || len(g.Links) != 0 {
+		t.Errorf("Unexpected graph for unknown domain: %+v", g)
+	}
+}
+
+func TestNetworkGraphExternalAggregation(t *testing.T) {
+	c := NewCache()
+	for _, wl := range []*pb.Workload{{Id: "web", Domain: "shop"}, {Id: "api", Domain: "shop"}} {
+		if err := c.UpdateWorkloadFromProto(wl); err != nil {
+			t.Fatalf("Workload rejected: %v", err)
+		}
+	}
+	for i := 1; i <= 20; i++ {
+		ip := net.IPv4(203, 0, 113, byte(i))
+		c.UpdateConnection(&controller.Connection{
+			ClientWL: ip.String(), ServerWL: "web", ClientIP: ip, ServerIP: net.IPv4(10, 0, 0, 1),
+			ServerPort: 443, IPProto: 6, Bytes: 100, Sessions: 1, Severity: uint8(i % 3),
+		})
+	}
+	c.UpdateConnection(&controller.Connection{
+		ClientWL: "198.51.100.7", ServerWL: "web", ClientIP: net.IPv4(198, 51, 100, 7), ServerIP: net.IPv4(10, 0, 0, 1),
+		ServerPort: 443, IPProto: 6, Bytes: 5, Sessions: 1,
+	})
+	c.UpdateConnection(&controller.Connection{
+		ClientWL: "web", ServerWL: "api", ClientIP: net.IPv4(10, 0, 0, 1), ServerIP: net.IPv4(10, 0, 0, 2),
+		ServerPort: 80, IPProto: 6, Bytes: 7, Sessions: 1,
+	})
+
+	if g := c.GetNetworkGraph(""); len(g.Links) != 22 {
+		t.Fatalf("Unexpected unaggregated links: %d", len(g.Links))
+	}
+
+	g := c.GetAggregatedNetworkGraph("", ExternalAggregation{IPv4Prefix: 24})
+	nodes := make(map[string]controller.GraphNode)
+	for _, n := range g.Nodes {
+		nodes[n.ID] = n
+	}
+	if len(nodes) != 4 || len(g.Links) != 3 {
+		t.Fatalf("Unexpected aggregated graph: %+v", g)
+	}
+	if n := nodes["203.0.113.0/24"]; n.Kind != "external" || n.Boundary {
+		t.Errorf("Unexpected supernode: %+v", n)
+	}
+	if _, ok := nodes["198.51.100.0/24"]; !ok {
+		t.Errorf("Other subnet not kept separate: %+v", nodes)
+	}
+	for _, l := range g.Links {
+		switch l.From {
+		case "203.0.113.0/24":
+			if l.To != "web" || l.Bytes != 2000 || l.Sessions != 20 || l.Severity != 2 {
+				t.Errorf("Unexpected merged link: %+v", l)
+			}
+		case "198.51.100.0/24":
+			if l.Bytes != 5 {
+				t.Errorf("Unexpected link: %+v", l)
+			}
+		case "web":
+			if l.To != "api" || l.Bytes != 7 {
+				t.Errorf("Internal link changed: %+v", l)
+			}
+		default:
+			t.Errorf("Unexpected link: %+v", l)
+		}
+	}
+
+	// 按域过滤时超级节点作为边界节点返回
+	g = c.GetAggregatedNetworkGraph("shop", ExternalAggregation{IPv4Prefix: 16})
+	if len(g.Nodes) != 4 || len(g.Links) != 3 {
+		t.Fatalf("Unexpected domain graph: %+v", g)
+	}
+	for _, n := range g.Nodes {
+		if n.Kind == "external" && !n.Boundary {
+			t.Errorf("Supernode not marked boundary: %+v", n)
+		}
+	}
+
+	// 仅聚合IPv6时IPv4端点保持独立
+	if g := c.GetAggregatedNetworkGraph("", ExternalAggregation{IPv6Prefix: 64}); len(g.Links) != 22 {
+		t.Errorf("IPv4 endpoints aggregated: %d links", len(g.Links))
+	}
+}
+
+func TestGeoEnrichment(t *testing.T) {
+	c := NewCache()
+	c.UpdateWorkloadFromProto(&pb.Workload{Id: "web", Domain: "shop"})
+	conns := []*controller.Connection{
+		{ClientWL: "8.8.8.8", ServerWL: "web", ClientIP: net.IPv4(8, 8, 8, 8), ServerIP: net.IPv4(10, 0, 0, 1), ServerPort: 443, IPProto: 6},
+		{ClientWL: "192.168.1.5", ServerWL: "web", ClientIP: net.IPv4(192, 168, 1, 5), ServerIP: net.IPv4(10, 0, 0, 1), ServerPort: 443, IPProto: 6},
+	}
+
+	// 未配置时不补充
+	c.UpdateConnection(conns[0])
+	if conn := c.ListConnections()[0]; conn.ClientGeo != nil {
+		t.Errorf("Geo set without lookup: %+v", conn.ClientGeo)
+	}
+
+	var looked []string
+	c.SetGeoLookup(func(ip net.IP) (*controller.GeoInfo, bool) {
+		looked = append(looked, ip.String())
+		if ip.Equal(net.IPv4(8, 8, 8, 8)) {
+			return &controller.GeoInfo{Country: "US", ASN: 15169}, true
+		}
+		return nil, false
+	})
+	for _, conn := range conns {
+		c.UpdateConnection(conn)
+	}
+	if len(looked) != 1 || looked[0] != "8.8.8.8" {
+		t.Errorf("Unexpected lookups: %v", looked)
+	}
+	for _, conn := range c.ListConnections() {
+		switch conn.ClientWL {
+		case "8.8.8.8":
+			if conn.ClientGeo == nil || conn.ClientGeo.Country != "US" || conn.ServerGeo != nil {
+				t.Errorf("Unexpected geo: %+v %+v", conn.ClientGeo, conn.ServerGeo)
+			}
+		default:
+			if conn.ClientGeo != nil {
+				t.Errorf("Private peer enriched: %+v", conn.ClientGeo)
+			}
+		}
+	}
+
+	for _, n := range c.GetNetworkGraph("shop").Nodes {
+		if n.ID == "8.8.8.8" && (n.Geo == nil || n.Geo.ASN != 15169) {
+			t.Errorf("Graph node not enriched: %+v", n)
+		}
+		if n.ID != "8.8.8.8" && n.Geo != nil {
+			t.Errorf("Unexpected node geo: %+v", n)
+		}
+	}
+}
+
+func TestReevaluateConnectionsOnDeny(t *testing.T) {
+	c := NewCache()
+	p := policy.NewEngine()
+	c.AddGroup(&controller.Group{Name: "web"})
+	c.AddGroup(&controller.Group{Name: "db"})
+	c.AddGroupMember("web", "w1")
+	c.AddGroupMember("db", "d1")
+
+	p.AddRule(&controller.PolicyRule{ID: 1, From: "web", To: "db", Action: "allow", Priority: 10})
+	p.TakeDirtyGroups()
+
+	for _, l := range [][2]string{{"w1", "d1"}, {"x1", "y1"}} {
+		err := c.UpdateConnectionFromProto(&pb.Connection{
+			ClientWl: l[0], ServerWl: l[1], ClientIp: ip1, ServerIp: ip2,
+			ServerPort: 3306, IpProto: 6, PolicyId: 1, PolicyAction: uint32(controller.PolicyActionAllow),
+		})
+		if err != nil {
+			t.Fatalf("Connection rejected: %v", err)
+		}
+	}
+	before := c.ListConnections()
+
+	// 新增优先级更高的deny规则
+	p.AddRule(&controller.PolicyRule{ID: 2, From: "web", To: "db", Action: "deny", Priority: 1})
+	groups, all := p.TakeDirtyGroups()
+
+	evaluated := make(map[string]bool)
+	match := func(from, to string, clientIP, serverIP net.IP, port uint16, proto uint8, app uint32) (uint32, controller.PolicyAction) {
+		evaluated[from+"-"+to] = true
+		return p.MatchConnection(from, to, clientIP, serverIP, port, proto, app)
+	}
+	if n := c.ReevaluateConnections(groups, all, match); n != 1 {
+		t.Errorf("Expected 1 connection changed, got %d", n)
+	}
+	if evaluated["x1-y1"] {
+		t.Errorf("Unaffected connection re-evaluated")
+	}
+
+	for _, l := range c.GetNetworkGraph("").Links {
+		switch l.From {
+		case "w1":
+			if l.PolicyAction != uint8(controller.PolicyActionDeny) {
+				t.Errorf("Link not recolored: %+v", l)
+			}
+		case "x1":
+			if l.PolicyAction != uint8(controller.PolicyActionAllow) {
+				t.Errorf("Unaffected link changed: %+v", l)
+			}
+		}
+	}
+	for _, conn := range c.ListConnections() {
+		if conn.ClientWL == "w1" && conn.PolicyID != 2 {
+			t.Errorf("Unexpected policy id: %d", conn.PolicyID)
+		}
+	}
+	for _, conn := range before {
+		if conn.PolicyAction != uint8(controller.PolicyActionAllow) {
+			t.Errorf("Previously returned connection modified: %+v", conn)
+		}
+	}
+
+	// 删除deny规则后恢复
+	p.DeleteRule(2)
+	groups, all = p.TakeDirtyGroups()
+	if n := c.ReevaluateConnections(groups, all, p.MatchConnection); n != 1 {
+		t.Errorf("Expected 1 connection changed back, got %d", n)
+	}
+}
+
+func TestThreatFromProto(t *testing.T) {
+	c := NewCache()
+
+	if err := c.AddThreatFromProto("agent1", nil); err == nil {
+		t.Errorf("Nil threat accepted")
+	}
+	err := c.AddThreatFromProto("agent1", &pb.ThreatLog{
+		Id: "t1", ThreatId: 1001, ThreatName: "TCP SYN flood", Severity: "High",
+		ClientIp: ip1, ServerIp: ip2, ServerPort: 80, IpProto: 6,
+		TcpFlags: 0x02, PktLen: 60, PktSummary: "10.0.0.1:4321 > 10.0.0.2:80 [SYN]",
+		ReportedAt: 1700000000,
+	})
+	if err != nil {
+		t.Fatalf("Threat rejected: %v", err)
+	}
+
+	threats := c.ListThreats()
+	if len(threats) != 1 {
+		t.Fatalf("Expected 1 threat, got %d", len(threats))
+	}
+	th := threats[0]
+	if th.AgentID != "agent1" || th.ClientIP != "10.0.0.1" || th.ServerIP != "10.0.0.2" {
+		t.Errorf("Unexpected threat: %+v", th)
+	}
+	if th.TcpFlags != 0x02 || th.PktLen != 60 || th.PktSummary != "10.0.0.1:4321 > 10.0.0.2:80 [SYN]" {
+		t.Errorf("Packet context lost: %+v", th)
+	}
+	if !th.ReportedAt.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("Unexpected reported_at: %v", th.ReportedAt)
+	}
+
+	// 超过上限时丢弃最早的记录
+	for i := 0; i < maxThreatLogs; i++ {
+		c.AddThreatFromProto("agent1", &pb.ThreatLog{Id: fmt.Sprintf("n%d", i)})
+	}
+	threats = c.ListThreats()
+	if len(threats) != maxThreatLogs || threats[0].ID != "n0" {
+		t.Errorf("Unexpected threats after overflow: %d first=%s", len(threats), threats[0].ID)
+	}
+}
+
+func TestSeverityDecay(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	c := NewCache()
+	c.now = func() time.Time { return now }
+	c.SetSeverityQuietPeriod(10 * time.Minute)
+
+	c.AddThreatFromProto("agent", &pb.ThreatLog{ThreatId: 7, Severity: "critical"})
+	c.UpdateConnectionFromProto(&pb.Connection{ClientWl: "a", ServerWl: "b", ClientIp: ip1, ServerIp: ip2, ThreatId: 7, Severity: 3})
+
+	// 后续低严重级别的上报不覆盖已有级别，也不刷新计时
+	now = now.Add(5 * time.Minute)
+	c.UpdateConnectionFromProto(&pb.Connection{ClientWl: "a", ServerWl: "b", ClientIp: ip1, ServerIp: ip2, ThreatId: 7})
+	if sev := c.ListConnections()[0].Severity; sev != 3 {
+		t.Fatalf("Severity lowered by report: %d", sev)
+	}
+	if n := c.DecaySeverity(); n != 0 {
+		t.Errorf("Decayed within quiet period: %d", n)
+	}
+
+	// 静默期后降低一级
+	now = now.Add(5 * time.Minute)
+	if n := c.DecaySeverity(); n != 1 {
+		t.Errorf("Unexpected decay count: %d", n)
+	}
+	conn := c.ListConnections()[0]
+	if conn.Severity != 2 || conn.ThreatID != 7 {
+		t.Errorf("Unexpected connection after decay: severity=%d threat=%d", conn.Severity, conn.ThreatID)
+	}
+	if attr := c.wlGraph.Attr("a", "graph", "b").(*GraphAttr); attr.Severity != 2 {
+		t.Errorf("Graph link not decayed: %d", attr.Severity)
+	}
+
+	// 再次上报高严重级别后重新计时
+	now = now.Add(5 * time.Minute)
+	c.UpdateConnectionFromProto(&pb.Connection{ClientWl: "a", ServerWl: "b", ClientIp: ip1, ServerIp: ip2, Severity: 3})
+	now = now.Add(5 * time.Minute)
+	if n := c.DecaySeverity(); n != 0 {
+		t.Errorf("Decayed after fresh high severity: %d", n)
+	}
+
+	// 持续静默直到归零
+	for i := 0; i < 5; i++ {
+		now = now.Add(10 * time.Minute)
+		c.DecaySeverity()
+	}
+	if sev := c.ListConnections()[0].Severity; sev != 0 {
+		t.Errorf("Severity not decayed to zero: %d", sev)
+	}
+
+	// 历史威胁记录保持不变
+	if threats := c.ListThreats(); len(threats) != 1 || threats[0].Severity != "critical" {
+		t.Errorf("Threat log changed: %+v", threats)
+	}
+}
+
+func TestGraphLinkPolicyID(t *testing.T) {
+	c := NewCache()
+	c.UpdateConnectionFromProto(&pb.Connection{ClientWl: "a", ServerWl: "b", ClientIp: ip1, ServerIp: ip2,
+		PolicyId: 7, PolicyAction: uint32(controller.PolicyActionDeny)})
+	// 未命中规则，默认动作
+	c.UpdateConnectionFromProto(&pb.Connection{ClientWl: "a", ServerWl: "c", ClientIp: ip1, ServerIp: ip2})
+
+	links := make(map[string]controller.GraphLink)
+	for _, link := range c.GetNetworkGraph("").Links {
+		links[link.To] = link
+	}
+	if l := links["b"]; l.PolicyID != 7 || l.PolicyAction != uint8(controller.PolicyActionDeny) {
+		t.Errorf("Unexpected link: %+v", l)
+	}
+	if l := links["c"]; l.PolicyID != 0 {
+		t.Errorf("Unexpected default link: %+v", l)
+	}
+	if attr := c.wlGraph.Attr("a", "graph", "b").(*GraphAttr); attr.PolicyID != 7 {
+		t.Errorf("Unexpected graph attr: %+v", attr)
+	}
+
+	// 重新评估后规则ID随之更新
+	match := func(from, to string, clientIP, serverIP net.IP, port uint16, proto uint8, app uint32) (uint32, controller.PolicyAction) {
+		return 9, controller.PolicyActionAllow
+	}
+	c.ReevaluateConnections(nil, true, match)
+	if attr := c.wlGraph.Attr("a", "graph", "b").(*GraphAttr); attr.PolicyID != 9 {
+		t.Errorf("Graph attr not updated: %+v", attr)
+	}
+}
+
+func TestViolationAudit(t *testing.T) {
+	c := NewCache()
+	c.SetRuleLogged(func(id uint32) bool { return id == 5 })
+	c.UpdateConnectionFromProto(&pb.Connection{ClientWl: "a", ServerWl: "b", ClientIp: ip1, ServerIp: ip2,
+		PolicyId: 5, PolicyAction: uint32(controller.PolicyActionAllow)})
+	c.UpdateConnectionFromProto(&pb.Connection{ClientWl: "a", ServerWl: "c", ClientIp: ip1, ServerIp: ip2,
+		PolicyId: 6, PolicyAction: uint32(controller.PolicyActionAllow)})
+	c.UpdateConnectionFromProto(&pb.Connection{ClientWl: "a", ServerWl: "d", ClientIp: ip1, ServerIp: ip2,
+		PolicyId: 7, PolicyAction: uint32(controller.PolicyActionDeny)})
+
+	vs := c.ListViolations()
+	if len(vs) != 2 {
+		t.Fatalf("Unexpected violations: %+v", vs)
+	}
+	if v := vs[0]; v.ServerWL != "b" || v.Level != "audit" || v.PolicyAction != "allow" || v.PolicyID != 5 {
+		t.Errorf("Unexpected audit record: %+v", v)
+	}
+	if v := vs[1]; v.ServerWL != "d" || v.Level != "violation" || v.PolicyAction != "deny" {
+		t.Errorf("Unexpected violation record: %+v", v)
+	}
+}
+
+func TestFirstContacts(t *testing.T) {
+	c := NewCache()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+	for _, id := range []string{"web", "db", "api"} {
+		c.AddWorkload(&controller.Workload{ID: id})
+	}
+	conn := func(client, server string, action controller.PolicyAction, policyID uint32) *pb.Connection {
+		return &pb.Connection{ClientWl: client, ServerWl: server, ClientIp: ip1, ServerIp: ip2, ServerPort: 5432, IpProto: 6,
+			PolicyAction: uint32(action), PolicyId: policyID}
+	}
+
+	c.UpdateConnectionFromProto(conn("web", "db", controller.PolicyActionViolate, 0))
+	c.UpdateConnectionFromProto(conn("web", "db", controller.PolicyActionViolate, 0))
+	c.UpdateConnectionFromProto(conn("db", "web", controller.PolicyActionViolate, 0))   // 反方向不重复记录
+	c.UpdateConnectionFromProto(conn("api", "db", controller.PolicyActionAllow, 3))     // 规则允许的通信
+	c.UpdateConnectionFromProto(conn("web", "unknown", controller.PolicyActionOpen, 0)) // 未知端点
+
+	events := c.ListFirstContacts()
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 first-contact event, got %+v", events)
+	}
+	if e := events[0]; e.ClientWL != "web" || e.ServerWL != "db" || e.ServerPort != 5432 ||
+		e.PolicyAction != "violate" || !e.ReportedAt.Equal(now) {
+		t.Errorf("Unexpected event: %+v", e)
+	}
+
+	// 连接过期后再次出现不视为首次通信
+	now = now.Add(2 * defaultConnectionTTL)
+	c.PurgeExpiredConnections()
+	c.UpdateConnectionFromProto(conn("web", "db", controller.PolicyActionViolate, 0))
+	if events := c.ListFirstContacts(); len(events) != 1 {
+		t.Errorf("Expired pair reported again: %+v", events)
+	}
+
+	// 工作负载删除后重新出现视为首次通信
+	c.DeleteWorkload("db")
+	c.AddWorkload(&controller.Workload{ID: "db"})
+	c.UpdateConnectionFromProto(conn("web", "db", controller.PolicyActionViolate, 0))
+	if events := c.ListFirstContacts(); len(events) != 2 || events[1].ID != "2" {
+		t.Errorf("Re-added workload not reported: %+v", events)
+	}
+}
+
+func TestAlerts(t *testing.T) {
+	c := NewCache()
+	c.SetRuleLogged(func(id uint32) bool { return true })
+	var alerts []*controller.Alert
+	c.SetOnAlert(func(a *controller.Alert) { alerts = append(alerts, a) })
+
+	// 审计记录不告警
+	c.UpdateConnectionFromProto(&pb.Connection{ClientWl: "a", ServerWl: "b", ClientIp: ip1, ServerIp: ip2,
+		PolicyId: 5, PolicyAction: uint32(controller.PolicyActionAllow)})
+	c.UpdateConnectionFromProto(&pb.Connection{ClientWl: "a", ServerWl: "d", ClientIp: ip1, ServerIp: ip2,
+		PolicyId: 7, PolicyAction: uint32(controller.PolicyActionDeny), Severity: 3})
+	c.AddThreatFromProto("agent", &pb.ThreatLog{Id: "t1", ThreatId: 1, Severity: "Critical"})
+
+	if len(alerts) != 2 {
+		t.Fatalf("Unexpected alerts: %+v", alerts)
+	}
+	if a := alerts[0]; a.Type != controller.AlertTypeViolation || a.Severity != 3 || a.Violation.ServerWL != "d" {
+		t.Errorf("Unexpected violation alert: %+v", a)
+	}
+	if a := alerts[1]; a.Type != controller.AlertTypeThreat || a.Severity != 4 || a.Threat.ID != "t1" {
+		t.Errorf("Unexpected threat alert: %+v", a)
+	}
+}
+
+func TestWorkloadActivity(t *testing.T) {
+	c := NewCache()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+	for _, id := range []string{"a", "b", "idle"} {
+		c.AddWorkload(&controller.Workload{ID: id})
+	}
+	before := c.GetWorkload("a")
+
+	c.UpdateConnectionFromProto(&pb.Connection{ClientWl: "a", ServerWl: "b", ClientIp: ip1, ServerIp: ip2})
+	if at := c.GetWorkload("a").LastActiveAt; !at.Equal(now) {
+		t.Errorf("Activity not recorded: %v", at)
+	}
+	if !before.LastActiveAt.IsZero() {
+		t.Errorf("Returned workload modified in place")
+	}
+
+	// 活动时间不后退，重新上报工作负载时保留
+	seen := now.Add(-time.Hour)
+	c.UpdateConnection(&controller.Connection{ClientWL: "b", ServerWL: "a", LastSeenAt: seen})
+	c.AddWorkload(&controller.Workload{ID: "b", Name: "b2"})
+	if at := c.GetWorkload("b").LastActiveAt; !at.Equal(now) {
+		t.Errorf("Activity lost or moved back: %v", at)
+	}
+
+	idle := c.IdleWorkloads(now.Add(-time.Minute))
+	if len(idle) != 1 || idle[0].ID != "idle" {
+		t.Errorf("Unexpected idle workloads: %v", idle)
+	}
+	if idle := c.IdleWorkloads(now.Add(time.Minute)); len(idle) != 3 {
+		t.Errorf("Expected all workloads idle, got %d", len(idle))
+	}
+}
+
+func TestQueryThreats(t *testing.T) {
+	c := NewCache()
+	base := uint64(1700000000)
+	for i, th := range []*pb.ThreatLog{
+		{Id: "t1", ThreatId: 1001, Severity: "Low", ClientWl: "a", ServerWl: "b", ReportedAt: base},
+		{Id: "t2", ThreatId: 2022, Severity: "High", ClientWl: "a", ServerWl: "db", ReportedAt: base + 20},
+		{Id: "t3", ThreatId: 2009, ThreatName: "Heartbleed", Severity: "Critical", ClientWl: "c", ServerWl: "b", ReportedAt: base + 10},
+		{Id: "t4", ThreatId: 9999, Severity: "Medium", ClientWl: "a", ServerWl: "b", ReportedAt: base + 20},
+	} {
+		if err := c.AddThreatFromProto("agent", th); err != nil {
+			t.Fatalf("Threat %d rejected: %v", i, err)
+		}
+	}
+
+	ids := func(threats []*controller.ThreatLog) string {
+		var s []string
+		for _, th := range threats {
+			s = append(s, th.ID)
+		}
+		return strings.Join(s, ",")
+	}
+
+	// 从新到旧，时间相同时后收到的在前
+	all := c.QueryThreats(ThreatFilter{})
+	if got := ids(all); got != "t4,t2,t3,t1" {
+		t.Errorf("Unexpected order: %s", got)
+	}
+	names := map[string]string{}
+	for _, th := range all {
+		names[th.ID] = th.ThreatName
+	}
+	if names["t1"] != "TCP.SYN.Flood" || names["t3"] != "Heartbleed" || names["t4"] != "threat-9999" {
+		t.Errorf("Unexpected threat names: %v", names)
+	}
+
+	for _, tc := range []struct {
+		filter ThreatFilter
+		want   string
+	}{
+		{ThreatFilter{MinSeverity: 3}, "t2,t3"},
+		{ThreatFilter{ClientWL: "a", ServerWL: "b"}, "t4,t1"},
+		{ThreatFilter{ThreatID: 2022}, "t2"},
+		{ThreatFilter{Since: time.Unix(int64(base+10), 0), Until: time.Unix(int64(base+15), 0)}, "t3"},
+	} {
+		if got := ids(c.QueryThreats(tc.filter)); got != tc.want {
+			t.Errorf("%+v: got %s, want %s", tc.filter, got, tc.want)
+		}
+	}
+}
+
+func TestConnectionFieldRange(t *testing.T) {
+	c := NewCache()
+
+	cases := []*pb.Connection{
+		{ClientWl: "a", ServerWl: "b", ClientIp: ip1, ServerIp: ip2, ServerPort: 65536},
+		{ClientWl: "a", ServerWl: "b", ClientIp: ip1, ServerIp: ip2, ClientPort: 70000},
+		{ClientWl: "a", ServerWl: "b", ClientIp: ip1, ServerIp: ip2, IpProto: 256},
+		{ClientWl: "a", ServerWl: "b", ClientIp: ip1, ServerIp: ip2, PolicyAction: 258},
+	}
+	for _, conn := range cases {
+		if err := c.UpdateConnectionFromProto(conn); err == nil {
+			t.Errorf("Expected rejection: %v", conn)
+		}
+	}
+	if n := len(c.ListConnections()); n != 0 {
+		t.Fatalf("Rejected connections stored: %d", n)
+	}
+
+	// 严重级别截断而非回绕
+	err := c.UpdateConnectionFromProto(&pb.Connection{ClientWl: "a", ServerWl: "b", ClientIp: ip1, ServerIp: ip2,
+		ServerPort: 65535, IpProto: 6, Severity: 300})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	conn := c.ListConnections()[0]
+	if conn.Severity != 255 || conn.ServerPort != 65535 {
+		t.Errorf("Unexpected connection: severity=%d port=%d", conn.Severity, conn.ServerPort)
+	}
+}
+
+func TestThreatFieldRange(t *testing.T) {
+	c := NewCache()
+
+	for _, threat := range []*pb.ThreatLog{
+		{ThreatId: 1, ServerPort: 65536},
+		{ThreatId: 2, IpProto: 300},
+		{ThreatId: 3, TcpFlags: 0x1ff},
+	} {
+		if err := c.AddThreatFromProto("agent", threat); err == nil {
+			t.Errorf("Expected rejection: %v", threat)
+		}
+	}
+
+	if err := c.AddThreatFromProto("agent", &pb.ThreatLog{ThreatId: 4, PktLen: 100000}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	threats := c.ListThreats()
+	if len(threats) != 1 || threats[0].PktLen != 65535 {
+		t.Errorf("Unexpected threats: %+v", threats)
+	}
+}
+
+func TestThreatCorrelation(t *testing.T) {
+	c := NewCache()
+	c.UpdateConnectionFromProto(&pb.Connection{ClientWl: "a", ServerWl: "b", ClientIp: ip1, ServerIp: ip2,
+		ServerPort: 80, IpProto: 6, Severity: 1})
+
+	threat := &pb.ThreatLog{ThreatId: 1001, Severity: "High", ClientIp: ip1, ServerIp: ip2, ServerPort: 80, IpProto: 6}
+	if err := c.AddThreatFromProto("agent", threat); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	g := c.GetNetworkGraph("")
+	if len(g.Links) != 1 {
+		t.Fatalf("Unexpected links: %+v", g.Links)
+	}
+	link := g.Links[0]
+	if link.Severity != 3 || len(link.Threats) != 1 || link.Threats[0] != 1001 {
+		t.Errorf("Link not correlated: %+v", link)
+	}
+	if conn := c.ListConnections()[0]; conn.Severity != 3 || conn.ThreatID != 1001 {
+		t.Errorf("Connection not correlated: %+v", conn)
+	}
+
+	// 较低级别的威胁只追加引用，重复威胁不重复记录
+	c.AddThreatFromProto("agent", &pb.ThreatLog{ThreatId: 1002, Severity: "Low", ClientIp: ip1, ServerIp: ip2, ServerPort: 80, IpProto: 6})
+	c.AddThreatFromProto("agent", threat)
+	link = c.GetNetworkGraph("").Links[0]
+	if link.Severity != 3 || len(link.Threats) != 2 {
+		t.Errorf("Unexpected link: %+v", link)
+	}
+
+	// 后续连接上报保留关联
+	c.UpdateConnectionFromProto(&pb.Connection{ClientWl: "a", ServerWl: "b", ClientIp: ip1, ServerIp: ip2,
+		ServerPort: 80, IpProto: 6})
+	if link = c.GetNetworkGraph("").Links[0]; link.Severity != 3 || len(link.Threats) != 2 {
+		t.Errorf("Correlation lost on update: %+v", link)
+	}
+
+	// 无匹配连接的威胁独立保存
+	c.AddThreatFromProto("agent", &pb.ThreatLog{ThreatId: 1003, Severity: "Critical", ClientIp: ip1, ServerIp: ip2, ServerPort: 443, IpProto: 6})
+	if link = c.GetNetworkGraph("").Links[0]; link.Severity != 3 || len(link.Threats) != 2 {
+		t.Errorf("Unmatched threat correlated: %+v", link)
+	}
+	if n := len(c.ListThreats()); n != 4 {
+		t.Errorf("Unexpected threat count: %d", n)
+	}
+}
+
+func TestReplaceAgentWorkloads(t *testing.T) {
+	c := NewCache()
+	c.UpdateAgentWorkloadFromProto("agent1", &pb.Workload{Id: "a1"})
+	c.UpdateAgentWorkloadFromProto("agent1", &pb.Workload{Id: "a2"})
+	c.UpdateAgentWorkloadFromProto("agent2", &pb.Workload{Id: "b1"})
+	c.UpdateWorkloadFromProto(&pb.Workload{Id: "x1"})
+	c.UpdateConnection(&controller.Connection{ClientWL: "a1", ServerWL: "b1", ServerPort: 80, IPProto: 6})
+
+	removed := c.ReplaceAgentWorkloads("agent1", []*controller.Workload{{ID: "a2", Name: "a2-new"}, {ID: "a3"}})
+	if removed != 1 {
+		t.Errorf("Expected 1 workload removed, got %d", removed)
+	}
+	if c.GetWorkload("a1") != nil {
+		t.Errorf("Stale workload kept")
+	}
+	if c.GetWorkloadPorts("a1") != nil || c.wlGraph.Node("a1") != "" {
+		t.Errorf("Stale workload state kept")
+	}
+	if wl := c.GetWorkload("a2"); wl == nil || wl.Name != "a2-new" || wl.AgentID != "agent1" {
+		t.Errorf("Unexpected workload: %+v", wl)
+	}
+	for _, id := range []string{"a3", "b1", "x1"} {
+		if c.GetWorkload(id) == nil {
+			t.Errorf("Workload %s missing", id)
+		}
+	}
+
+	// 空列表删除该Agent的全部工作负载
+	if removed := c.ReplaceAgentWorkloads("agent1", nil); removed != 2 {
+		t.Errorf("Expected 2 workloads removed, got %d", removed)
+	}
+	if n := len(c.ListWorkloads()); n != 2 {
+		t.Errorf("Unexpected workload count: %d", n)
+	}
+}
+
+func TestGraphLinkDirectionCounters(t *testing.T) {
+	c := NewCache()
+	c.UpdateConnectionFromProto(&pb.Connection{ClientWl: "a", ServerWl: "b", ClientIp: ip1, ServerIp: ip2,
+		ServerPort: 80, IpProto: 6, Bytes: 1000, Sessions: 3, Ingress: true})
+	c.UpdateConnectionFromProto(&pb.Connection{ClientWl: "a", ServerWl: "b", ClientIp: ip1, ServerIp: ip2,
+		ServerPort: 80, IpProto: 6, Bytes: 400, Sessions: 2, Ingress: false})
+
+	links := c.GetNetworkGraph("").Links
+	if len(links) != 1 {
+		t.Fatalf("Unexpected links: %+v", links)
+	}
+	l := links[0]
+	if l.IngressBytes != 1000 || l.IngressSessions != 3 || l.EgressBytes != 400 || l.EgressSessions != 2 {
+		t.Errorf("Unexpected direction counters: %+v", l)
+	}
+	attr := c.wlGraph.Attr("a", "graph", "b").(*GraphAttr)
+	if attr.IngressBytes != 1000 || attr.EgressBytes != 400 || attr.IngressSessions != 3 || attr.EgressSessions != 2 {
+		t.Errorf("Unexpected graph attr: %+v", attr)
+	}
+
+	// 再次入向上报只更新入向计数
+	c.UpdateConnectionFromProto(&pb.Connection{ClientWl: "a", ServerWl: "b", ClientIp: ip1, ServerIp: ip2,
+		ServerPort: 80, IpProto: 6, Bytes: 1500, Sessions: 4, Ingress: true})
+	l = c.GetNetworkGraph("").Links[0]
+	if l.IngressBytes != 1500 || l.IngressSessions != 4 || l.EgressBytes != 400 || l.EgressSessions != 2 {
+		t.Errorf("Unexpected direction counters after update: %+v", l)
+	}
+}
+
+func TestGraphLinkLastSeen(t *testing.T) {
+	c := NewCache()
+	report := func(lastSeen uint32) {
+		c.UpdateConnectionFromProto(&pb.Connection{ClientWl: "a", ServerWl: "b", ClientIp: ip1, ServerIp: ip2,
+			ServerPort: 80, IpProto: 6, Sessions: 1, LastSeenAt: lastSeen})
+	}
+	check := func(want int64) {
+		t.Helper()
+		if l := c.GetNetworkGraph("").Links[0]; l.LastSeenAt.Unix() != want {
+			t.Errorf("Unexpected link last seen: %v, want %d", l.LastSeenAt, want)
+		}
+		if attr := c.wlGraph.Attr("a", "graph", "b").(*GraphAttr); attr.LastSeenAt.Unix() != want {
+			t.Errorf("Unexpected graph attr last seen: %v, want %d", attr.LastSeenAt, want)
+		}
+	}
+
+	report(1700000060)
+	check(1700000060)
+	// 乱序到达的旧上报不回退
+	report(1700000000)
+	check(1700000060)
+	report(1700000120)
+	check(1700000120)
+}
+
+func TestWorkloadNaming(t *testing.T) {
+	c := NewCache()
+	if err := c.SetWorkloadNaming(WorkloadNaming{Service: "{app}-{tier}", Domain: "{namespace}"}); err != nil {
+		t.Fatalf("SetWorkloadNaming: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name            string
+		labels          map[string]string
+		service, domain string // Agent上报的值
+		wantSvc, wantDo string
+	}{
+		{"all labels", map[string]string{"app": "shop", "tier": "web", "namespace": "prod"}, "", "", "shop-web", "prod"},
+		{"reported kept", map[string]string{"app": "shop", "tier": "web", "namespace": "prod"}, "cart", "dev", "cart", "dev"},
+		{"missing label", map[string]string{"app": "shop", "namespace": "prod"}, "", "", "", "prod"},
+		{"empty label", map[string]string{"app": "shop", "tier": "web", "namespace": ""}, "", "", "shop-web", ""},
+		{"no labels", nil, "", "", "", ""},
+	} {
+		if err := c.UpdateAgentWorkloadFromProto("agent1", &pb.Workload{
+			Id: tc.name, Labels: tc.labels, Service: tc.service, Domain: tc.domain,
+		}); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if wl := c.GetWorkload(tc.name); wl.Service != tc.wantSvc || wl.Domain != tc.wantDo {
+			t.Errorf("%s: got service %q domain %q, want %q %q", tc.name, wl.Service, wl.Domain, tc.wantSvc, tc.wantDo)
+		}
+	}
+
+	// 注册时上报的完整列表同样生效
+	c.ReplaceAgentWorkloadsFromProto("agent2", []*pb.Workload{{Id: "r1", Labels: map[string]string{"app": "api", "tier": "v1", "namespace": "qa"}}})
+	if wl := c.GetWorkload("r1"); wl.Service != "api-v1" || wl.Domain != "qa" {
+		t.Errorf("Replace not mapped: %q %q", wl.Service, wl.Domain)
+	}
+
+	for _, tmpl := range []string{"{app", "app}", "{}", "{a{b}}"} {
+		if err := c.SetWorkloadNaming(WorkloadNaming{Service: tmpl}); err == nil {
+			t.Errorf("Template %q accepted", tmpl)
+		}
+	}
+}
+
+func TestCrossAgentFlowMerge(t *testing.T) {
+	c := NewCache()
+	now := time.Unix(1700000000, 0)
+	c.now = func() time.Time { return now }
+	c.AddWorkload(&controller.Workload{ID: "web"})
+	c.AddWorkload(&controller.Workload{ID: "db"})
+
+	// 客户端主机Agent只认识web，时钟快10分钟；服务端主机Agent只认识db
+	skew := uint32(600)
+	c.UpdateAgentConnection("agent-a", &pb.Connection{ClientWl: "web", ServerWl: "10.0.0.2", ClientIp: ip1, ServerIp: ip2,
+		ServerPort: 3306, IpProto: 6, Bytes: 1000, Sessions: 10,
+		FirstSeenAt: uint32(now.Unix()) - 10 + skew, LastSeenAt: uint32(now.Unix()) + skew})
+	c.UpdateAgentConnection("agent-b", &pb.Connection{ClientWl: "10.0.0.1", ServerWl: "db", ClientIp: ip1, ServerIp: ip2,
+		ServerPort: 3306, IpProto: 6, Bytes: 900, Sessions: 12, Ingress: true,
+		FirstSeenAt: uint32(now.Unix()) - 20, LastSeenAt: uint32(now.Unix()) - 5})
+
+	conns := c.ListConnections()
+	if len(conns) != 1 {
+		t.Fatalf("Flow not merged: %d connections", len(conns))
+	}
+	conn := conns[0]
+	if conn.ClientWL != "web" || conn.ServerWL != "db" {
+		t.Errorf("Unexpected endpoints: %s -> %s", conn.ClientWL, conn.ServerWL)
+	}
+	if conn.Bytes != 1000 || conn.Sessions != 12 {
+		t.Errorf("Unexpected merged counters: %d bytes %d sessions", conn.Bytes, conn.Sessions)
+	}
+	if strings.Join(conn.Agents, ",") != "agent-a,agent-b" {
+		t.Errorf("Unexpected agents: %v", conn.Agents)
+	}
+	// 超前的时钟被回拨，字节速率按同一Agent的首末次时间计算
+	if !conn.LastSeenAt.Equal(now) || !conn.FirstSeenAt.Equal(now.Add(-10*time.Second)) || conn.ByteRate != 100 {
+		t.Errorf("Unexpected times: %v %v rate %v", conn.FirstSeenAt, conn.LastSeenAt, conn.ByteRate)
+	}
+
+	g := c.GetNetworkGraph("")
+	if len(g.Links) != 1 || g.Links[0].From != "web" || g.Links[0].To != "db" {
+		t.Fatalf("Unexpected links: %+v", g.Links)
+	}
+	if l := g.Links[0]; l.EgressBytes != 1000 || l.IngressBytes != 900 {
+		t.Errorf("Unexpected direction counters: %+v", l)
+	}
+
+	// 同一Agent的后续上报仍合并到同一连接
+	c.UpdateAgentConnection("agent-b", &pb.Connection{ClientWl: "10.0.0.1", ServerWl: "db", ClientIp: ip1, ServerIp: ip2,
+		ServerPort: 3306, IpProto: 6, Bytes: 2000, Sessions: 20, Ingress: true})
+	if conns = c.ListConnections(); len(conns) != 1 || conns[0].Bytes != 2000 || c.GetGraphLinkCount() != 1 {
+		t.Errorf("Later report not merged: %d connections", len(conns))
+	}
+
+	// 不同端口是不同流量
+	c.UpdateAgentConnection("agent-b", &pb.Connection{ClientWl: "10.0.0.1", ServerWl: "db", ClientIp: ip1, ServerIp: ip2,
+		ServerPort: 5432, IpProto: 6, Bytes: 1, Sessions: 1, Ingress: true})
+	if conns = c.ListConnections(); len(conns) != 2 {
+		t.Errorf("Different flow merged: %d connections", len(conns))
+	}
+}
+
+func TestPruneNetworkGraph(t *testing.T) {
+	build := func() *controller.NetworkGraph {
+		return &controller.NetworkGraph{
+			Nodes: []controller.GraphNode{
+				{ID: "web", Kind: "workload"}, {ID: "db", Kind: "workload"}, {ID: "dns", Kind: "workload"},
+				{ID: "probe", Kind: "workload"}, {ID: "idle", Kind: "workload"}, {ID: "1.2.3.4", Kind: "external"},
+			},
+			Links: []controller.GraphLink{
+				{From: "web", To: "db", Bytes: 100000, Sessions: 50},
+				{From: "web", To: "dns", Bytes: 200, Sessions: 40},
+				{From: "probe", To: "db", Bytes: 300, Sessions: 1, PolicyID: 7, PolicyAction: uint8(controller.PolicyActionAllow)},
+				{From: "1.2.3.4", To: "web", Bytes: 50, Sessions: 1, PolicyAction: uint8(controller.PolicyActionDeny)},
+			},
+		}
+	}
+	ids := func(g *controller.NetworkGraph) string {
+		var s []string
+		for _, n := range g.Nodes {
+			s = append(s, n.ID)
+		}
+		for _, l := range g.Links {
+			s = append(s, l.From+">"+l.To)
+		}
+		return strings.Join(s, ",")
+	}
+
+	for _, tc := range []struct {
+		name  string
+		prune GraphPrune
+		want  string
+	}{
+		{"no thresholds", GraphPrune{}, "web,db,dns,probe,idle,1.2.3.4,web>db,web>dns,probe>db,1.2.3.4>web"},
+		{"min bytes", GraphPrune{MinBytes: 1000}, "web,db,idle,web>db"},
+		{"min sessions", GraphPrune{MinSessions: 10}, "web,db,dns,idle,web>db,web>dns"},
+		{"both", GraphPrune{MinBytes: 250, MinSessions: 10}, "web,db,idle,web>db"},
+		// 策略相关的工作负载节点保留，外部节点仍删除
+		{"keep policy", GraphPrune{MinBytes: 1000, KeepPolicy: true}, "web,db,probe,idle,web>db"},
+	} {
+		g := build()
+		PruneNetworkGraph(g, tc.prune)
+		if got := ids(g); got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestByteRate(t *testing.T) {
+	base := time.Unix(1700000000, 0)
+	for _, tc := range []struct {
+		name        string
+		bytes       uint64
+		first, last time.Time
+		want        float64
+	}{
+		{"average", 1000, base, base.Add(10 * time.Second), 100},
+		{"single observation", 1000, base, base, 0},
+		{"out of order", 1000, base.Add(time.Second), base, 0},
+		{"unknown first", 1000, time.Time{}, base, 0},
+		{"unknown last", 1000, base, time.Time{}, 0},
+	} {
+		conn := &controller.Connection{Bytes: tc.bytes, FirstSeenAt: tc.first, LastSeenAt: tc.last}
+		if got := byteRate(conn); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestConnectionByteRate(t *testing.T) {
+	c := NewCache()
+	report := func(bytes uint64, first, last uint32) {
+		c.UpdateConnectionFromProto(&pb.Connection{ClientWl: "a", ServerWl: "b", ClientIp: ip1, ServerIp: ip2,
+			ServerPort: 80, IpProto: 6, Sessions: 1, Bytes: bytes, FirstSeenAt: first, LastSeenAt: last})
+	}
+
+	report(5000, 1700000000, 1700000000)
+	if conn := c.ListConnections()[0]; conn.ByteRate != 0 {
+		t.Errorf("Unexpected rate for single observation: %v", conn.ByteRate)
+	}
+	report(5000, 1700000000, 1700000020)
+	if conn := c.ListConnections()[0]; conn.ByteRate != 250 {
+		t.Errorf("Unexpected connection rate: %v", conn.ByteRate)
+	}
+	if l := c.GetNetworkGraph("").Links[0]; l.ByteRate != 250 {
+		t.Errorf("Unexpected link rate: %v", l.ByteRate)
+	}
+}
+
+func TestDeleteWorkloadConcurrentLinks(t *testing.T) {
+	c := NewCache()
+	c.SetSeverityQuietPeriod(0)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 300; i++ {
+				server := fmt.Sprintf("wl%d", i%3)
+				c.UpdateConnectionFromProto(&pb.Connection{ClientWl: "client", ServerWl: server,
+					ClientIp: ip1, ServerIp: ip2, Severity: 2})
+				c.AddWorkload(&controller.Workload{ID: server})
+				c.DeleteWorkload(fmt.Sprintf("wl%d", (i+w)%3))
+				c.DecaySeverity()
+				c.GetNetworkGraph("")
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// 删除后残留连接不会在衰减或重新评估时重建节点
+	c.UpdateConnectionFromProto(&pb.Connection{ClientWl: "client", ServerWl: "wl0", ClientIp: ip1, ServerIp: ip2, Severity: 2})
+	for _, id := range []string{"wl0", "wl1", "wl2"} {
+		c.DeleteWorkload(id)
+	}
+	c.DecaySeverity()
+	c.ReevaluateConnections(nil, true, func(from, to string, clientIP, serverIP net.IP, port uint16, proto uint8, app uint32) (uint32, controller.PolicyAction) {
+		return 1, controller.PolicyActionDeny
+	})
+	if n := c.wlGraph.Node("wl0"); n != "" {
+		t.Errorf("Deleted node resurrected")
+	}
+	if links := c.GetNetworkGraph("").Links; len(links) != 0 || c.GetGraphLinkCount() != 0 {
+		t.Errorf("Links to deleted workloads remain: %+v", links)
+	}
+}
+
+func TestGroupModePropagation(t *testing.T) {
+	c := NewCache()
+	var pushed []string
+	c.SetOnModeChange(func(agentID string) { pushed = append(pushed, agentID) })
+
+	c.ReplaceAgentWorkloads("a1", []*controller.Workload{
+		{ID: "w1", PolicyMode: controller.PolicyModeMonitor},
+		{ID: "w3", PolicyMode: controller.PolicyModeProtect},
+	})
+	c.ReplaceAgentWorkloads("a2", []*controller.Workload{{ID: "w2", PolicyMode: controller.PolicyModeMonitor}})
+	c.AddGroup(&controller.Group{Name: "web"})
+	c.AddGroupMember("web", "w1")
+	c.AddGroupMember("web", "w2")
+	if len(pushed) != 0 {
+		t.Fatalf("Unexpected push without group mode: %v", pushed)
+	}
+
+	expect := func(step string, modes map[string]controller.PolicyMode) {
+		t.Helper()
+		for id, mode := range modes {
+			if got := c.GetWorkloadPolicyMode(id); got != mode {
+				t.Errorf("%s: %s mode %q, want %q", step, id, got, mode)
+			}
+		}
+	}
+
+	if !c.ApplyGroupMode("web", controller.PolicyModeProtect) {
+		t.Fatalf("ApplyGroupMode failed")
+	}
+	expect("protect", map[string]controller.PolicyMode{"w1": controller.PolicyModeProtect, "w2": controller.PolicyModeProtect})
+	if c.GetGroup("web").PolicyMode != controller.PolicyModeProtect {
+		t.Errorf("Group mode not updated")
+	}
+	if len(pushed) != 2 || pushed[0] != "a1" || pushed[1] != "a2" {
+		t.Errorf("Unexpected pushes: %v", pushed)
+	}
+
+	// 新成员继承组模式，退出后恢复自身模式
+	c.ApplyGroupMode("web", controller.PolicyModeMonitor)
+	c.AddGroupMember("web", "w3")
+	expect("join", map[string]controller.PolicyMode{"w1": controller.PolicyModeMonitor, "w3": controller.PolicyModeMonitor})
+	c.RemoveGroupMember("web", "w3")
+	expect("leave", map[string]controller.PolicyMode{"w3": controller.PolicyModeProtect})
+
+	// 任一所属组为Protect时为Protect
+	c.AddGroup(&controller.Group{Name: "db", PolicyMode: controller.PolicyModeProtect})
+	c.AddGroupMember("db", "w1")
+	expect("multi", map[string]controller.PolicyMode{"w1": controller.PolicyModeProtect, "w2": controller.PolicyModeMonitor})
+
+	// 重新上报的工作负载保持组模式
+	c.ReplaceAgentWorkloads("a2", []*controller.Workload{{ID: "w2", PolicyMode: controller.PolicyModeProtect}})
+	expect("refresh", map[string]controller.PolicyMode{"w2": controller.PolicyModeMonitor})
+
+	pushed = nil
+	c.DeleteGroup("db")
+	expect("delete", map[string]controller.PolicyMode{"w1": controller.PolicyModeMonitor})
+	if len(pushed) != 1 || pushed[0] != "a1" {
+		t.Errorf("Unexpected pushes after delete: %v", pushed)
+	}
+
+	if c.ApplyGroupMode("missing", controller.PolicyModeProtect) {
+		t.Errorf("Expected missing group to fail")
+	}
+}
+
+func TestApplyGroupMode(t *testing.T) {
+	c := NewCache()
+	c.ReplaceAgentWorkloads("a1", []*controller.Workload{
+		{ID: "w1", PolicyMode: controller.PolicyModeMonitor},
+		{ID: "w2", PolicyMode: controller.PolicyModeMonitor},
+	})
+	c.ReplaceAgentWorkloads("a2", []*controller.Workload{{ID: "w3", PolicyMode: controller.PolicyModeMonitor}})
+	c.AddGroup(&controller.Group{Name: "web"})
+	c.AddGroupMember("web", "w1")
+	c.AddGroupMember("web", "w3")
+
+	c.ApplyGroupMode("web", controller.PolicyModeProtect)
+	for id, mode := range map[string]controller.PolicyMode{
+		"w1": controller.PolicyModeProtect,
+		"w2": controller.PolicyModeMonitor, // 非成员不受影响
+		"w3": controller.PolicyModeProtect,
+	} {
+		if got := c.GetWorkloadPolicyMode(id); got != mode {
+			t.Errorf("%s mode %q, want %q", id, got, mode)
+		}
+	}
+
+	modes := c.AgentWorkloadModes("a1")
+	if len(modes) != 2 || modes[0].WorkloadId != "w1" || modes[0].Mode != "Protect" || modes[1].Mode != "Monitor" {
+		t.Errorf("Unexpected agent workload modes: %v", modes)
+	}
+	if modes := c.AgentWorkloadModes("unknown"); len(modes) != 0 {
+		t.Errorf("Unexpected modes for unknown agent: %v", modes)
+	}
+}
+
+func TestServiceGraph(t *testing.T) {
+	c := NewCache()
+	for _, wl := range []*controller.Workload{
+		{ID: "web-1", Service: "web", Domain: "shop"},
+		{ID: "web-2", Service: "web", Domain: "shop"},
+		{ID: "db-1", Service: "db", Domain: "shop"},
+		{ID: "db-3", Service: "db", Domain: "shop"},
+		{ID: "db-2", Service: "db", Domain: "data"}, // 其他域的同名服务
+		{ID: "tool"},
+	} {
+		c.AddWorkload(wl)
+	}
+	for i, conn := range []struct {
+		from, to string
+		port     uint16
+		app      uint32
+		bytes    uint64
+	}{
+		{"web-1", "db-1", 3306, 2001, 100},
+		{"web-2", "db-1", 3306, 2001, 200},
+		{"web-2", "db-3", 3307, 2001, 50},
+		{"web-1", "db-3", 8080, 1001, 1000},
+		{"web-1", "db-2", 3306, 2001, 10},
+		{"tool", "web-2", 22, 0, 5},
+	} {
+		c.UpdateConnection(&controller.Connection{
+			ClientWL: conn.from, ServerWL: conn.to, ClientIP: net.IPv4(10, 0, 0, byte(i)), ServerIP: net.IPv4(10, 0, 1, byte(i)),
+			ServerPort: conn.port, IPProto: 6, Application: conn.app, Bytes: conn.bytes, Sessions: 1,
+		})
+	}
+
+	g := c.GetServiceGraph("", ExternalAggregation{})
+	nodes := make(map[string]controller.GraphNode)
+	for _, n := range g.Nodes {
+		nodes[n.ID] = n
+	}
+	if len(nodes) != 4 || nodes["service:shop/web"].Kind != "service" || nodes["service:data/db"].Name != "db" || nodes["tool"].Kind != "workload" {
+		t.Fatalf("Unexpected service nodes: %+v", g.Nodes)
+	}
+	links := make(map[[2]string]controller.GraphLink)
+	for _, l := range g.Links {
+		links[[2]string{l.From, l.To}] = l
+	}
+	if len(links) != 3 {
+		t.Fatalf("Unexpected service links: %+v", g.Links)
+	}
+
+	// 同一服务对的连接按应用聚合
+	l := links[[2]string{"service:shop/web", "service:shop/db"}]
+	if l.Bytes != 1350 || l.Sessions != 4 || len(l.Applications) != 2 {
+		t.Fatalf("Unexpected web->db link: %+v", l)
+	}
+	http, mysql := l.Applications[0], l.Applications[1]
+	if http.Application != 1001 || http.Bytes != 1000 || http.Sessions != 1 ||
+		len(http.Ports) != 1 || http.Ports[0] != (controller.ServicePort{Port: 8080, IPProto: 6}) {
+		t.Errorf("Unexpected HTTP aggregation: %+v", http)
+	}
+	if mysql.Application != 2001 || mysql.Bytes != 350 || mysql.Sessions != 3 ||
+		len(mysql.Ports) != 2 || mysql.Ports[0].Port != 3306 || mysql.Ports[1].Port != 3307 {
+		t.Errorf("Unexpected MySQL aggregation: %+v", mysql)
+	}
+	if l := links[[2]string{"tool", "service:shop/web"}]; len(l.Applications) != 1 || l.Applications[0].Application != 0 {
+		t.Errorf("Unexpected tool->web link: %+v", l)
+	}
+
+	// 按域过滤时其他域的服务为边界节点
+	g = c.GetServiceGraph("shop", ExternalAggregation{})
+	for _, n := range g.Nodes {
+		if n.ID == "service:data/db" && !n.Boundary {
+			t.Errorf("Cross-domain service not marked boundary: %+v", n)
+		}
+		if n.ID == "tool" && !n.Boundary {
+			t.Errorf("Out-of-domain workload not marked boundary: %+v", n)
+		}
+	}
+}
+
+func TestStartStopIdempotent(t *testing.T) {
+	c := NewCache()
+	before := runtime.NumGoroutine()
+	c.Start()
+	c.Start()
+	if n := runtime.NumGoroutine() - before; n != 1 {
+		t.Errorf("Expected 1 maintenance loop, got %d new goroutines", n)
+	}
+
+	c.Stop()
+	c.Stop()
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > before && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("Maintenance loop leaked: %d goroutines, %d before start", n, before)
+	}
+}
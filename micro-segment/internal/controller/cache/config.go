@@ -0,0 +1,51 @@
+// Package cache 集群配置的导出与导入
+package cache
+
+import (
+	"time"
+
+	controller "github.com/micro-segment/internal/controller"
+)
+
+// Settings 获取当前集群运行参数
+func (c *Cache) Settings() controller.ClusterSettings {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return controller.ClusterSettings{
+		ConnectionTTL: uint32(c.connectionTTL / time.Second),
+		SeverityQuiet: uint32(c.severityQuiet / time.Second),
+	}
+}
+
+// ReplaceGroups 以给定的组整体替换全部组
+// 同名组保留已有的成员和策略引用，不再存在的组被删除，成员策略模式重新计算
+func (c *Cache) ReplaceGroups(groups []*controller.Group) {
+	c.mutex.Lock()
+	var affected []string
+	next := make(map[string]*GroupCache, len(groups))
+	for _, group := range groups {
+		if cache, ok := c.groups[group.Name]; ok {
+			cache.Group = group
+			next[group.Name] = cache
+			affected = append(affected, memberIDs(cache.Members)...)
+			continue
+		}
+		next[group.Name] = &GroupCache{
+			Group:        group,
+			Members:      make(map[string]bool),
+			UsedByPolicy: make(map[uint32]bool),
+		}
+	}
+	for name, cache := range c.groups {
+		if _, ok := next[name]; !ok {
+			affected = append(affected, memberIDs(cache.Members)...)
+		}
+	}
+	c.groups = next
+	agents := c.applyGroupModes(affected)
+	cb := c.onModeChange
+	c.mutex.Unlock()
+
+	notifyModeChange(cb, agents)
+}
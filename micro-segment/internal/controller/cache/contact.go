@@ -0,0 +1,86 @@
+// Package cache 工作负载首次通信事件
+package cache
+
+import (
+	"strconv"
+
+	controller "github.com/micro-segment/internal/controller"
+)
+
+// maxFirstContacts 保存的首次通信事件条数上限
+const maxFirstContacts = 1024
+
+// contactPair 工作负载对，不区分方向
+func contactPair(a, b string) [2]string {
+	if a > b {
+		a, b = b, a
+	}
+	return [2]string{a, b}
+}
+
+// onNewLink 拓扑图新链接钩子，由AddLink在调用方持有写锁时触发
+// 两端均为已知工作负载且此前从未通信过时记录首次通信事件；
+// 连接由规则显式允许的视为已知通信，不记录
+func (c *Cache) onNewLink(src, link, dst string) {
+	cache, ok := c.connections[src+"-"+dst]
+	if !ok {
+		return
+	}
+	if _, ok := c.workloads[src]; !ok {
+		return
+	}
+	if _, ok := c.workloads[dst]; !ok {
+		return
+	}
+
+	pair := contactPair(src, dst)
+	if c.contactedPairs[pair] {
+		return
+	}
+	c.contactedPairs[pair] = true
+
+	conn := cache.Connection
+	if controller.PolicyAction(conn.PolicyAction) == controller.PolicyActionAllow && conn.PolicyID != 0 {
+		return
+	}
+
+	c.firstContactSeq++
+	c.firstContacts = append(c.firstContacts, &controller.FirstContact{
+		ID:           strconv.FormatUint(c.firstContactSeq, 10),
+		ClientWL:     conn.ClientWL,
+		ServerWL:     conn.ServerWL,
+		ClientIP:     conn.ClientIP.String(),
+		ServerIP:     conn.ServerIP.String(),
+		ServerPort:   conn.ServerPort,
+		IPProto:      conn.IPProto,
+		PolicyAction: actionName(controller.PolicyAction(conn.PolicyAction)),
+		PolicyID:     conn.PolicyID,
+		ReportedAt:   c.now(),
+	})
+	if n := len(c.firstContacts) - maxFirstContacts; n > 0 {
+		c.firstContacts = c.firstContacts[n:]
+	}
+}
+
+// forgetContacts 删除工作负载参与的已通信记录，调用方需持有写锁
+// 同ID的工作负载重新出现后与其他工作负载的通信视为首次通信
+func (c *Cache) forgetContacts(id string) {
+	for pair := range c.contactedPairs {
+		if pair[0] == id || pair[1] == id {
+			delete(c.contactedPairs, pair)
+		}
+	}
+}
+
+// ListFirstContacts 列出首次通信事件，按发生顺序
+func (c *Cache) ListFirstContacts() []*controller.FirstContact {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	result := make([]*controller.FirstContact, 0, len(c.firstContacts))
+	for _, e := range c.firstContacts {
+		dup := *e
+		result = append(result, &dup)
+	}
+	return result
+}
@@ -0,0 +1,30 @@
+// Package cache 公网暴露面统计
+package cache
+
+import (
+	"sort"
+
+	controller "github.com/micro-segment/internal/controller"
+)
+
+// ListExposure 列出被公网客户端访问过的工作负载及端口，按工作负载ID排序
+// 端口来自Agent标记为internet_ingress的连接，流量汇总不含端口，不计入
+func (c *Cache) ListExposure() []controller.WorkloadExposure {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	result := make([]controller.WorkloadExposure, 0)
+	for id, wp := range c.ports {
+		if len(wp.exposed) == 0 {
+			continue
+		}
+		exposure := controller.WorkloadExposure{ID: id, Ports: wp.exposed.list()}
+		if cache, ok := c.workloads[id]; ok {
+			exposure.Name = cache.Workload.Name
+			exposure.Domain = cache.Workload.Domain
+		}
+		result = append(result, exposure)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
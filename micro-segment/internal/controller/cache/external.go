@@ -0,0 +1,84 @@
+// Package cache 拓扑图外部端点聚合
+package cache
+
+import (
+	"net"
+
+	controller "github.com/micro-segment/internal/controller"
+)
+
+// ExternalAggregation 外部端点按CIDR聚合的粒度
+// 前缀长度为0表示该地址族不聚合
+type ExternalAggregation struct {
+	IPv4Prefix int
+	IPv6Prefix int
+}
+
+// enabled 是否启用聚合
+func (a ExternalAggregation) enabled() bool {
+	return a.IPv4Prefix > 0 || a.IPv6Prefix > 0
+}
+
+// supernode 返回外部端点所属的超级节点ID（CIDR），不聚合时返回false
+// 已知工作负载和主机不聚合，端点IP优先取连接中的IP，其次按ID解析，调用方需持有读锁
+func (c *Cache) supernode(id string, ip net.IP, agg ExternalAggregation) (string, bool) {
+	if !agg.enabled() {
+		return "", false
+	}
+	if _, ok := c.workloads[id]; ok {
+		return "", false
+	}
+	if _, ok := c.hosts[id]; ok {
+		return "", false
+	}
+	if ip == nil {
+		ip = net.ParseIP(id)
+	}
+	if ip == nil {
+		return "", false
+	}
+
+	var mask net.IPMask
+	if v4 := ip.To4(); v4 != nil {
+		if agg.IPv4Prefix <= 0 {
+			return "", false
+		}
+		ip, mask = v4, net.CIDRMask(agg.IPv4Prefix, 8*net.IPv4len)
+	} else {
+		if agg.IPv6Prefix <= 0 {
+			return "", false
+		}
+		mask = net.CIDRMask(agg.IPv6Prefix, 8*net.IPv6len)
+	}
+	if mask == nil {
+		return "", false
+	}
+	ipnet := net.IPNet{IP: ip.Mask(mask), Mask: mask}
+	return ipnet.String(), true
+}
+
+// mergeGraphLink 将链接的计数累加到已有链接
+// 严重级别和最近活跃时间取最大值，策略动作取最严格的一条及其规则
+func mergeGraphLink(dst *controller.GraphLink, src controller.GraphLink) {
+	dst.Bytes += src.Bytes
+	dst.Sessions += src.Sessions
+	dst.ByteRate += src.ByteRate
+	dst.IngressBytes += src.IngressBytes
+	dst.IngressSessions += src.IngressSessions
+	dst.EgressBytes += src.EgressBytes
+	dst.EgressSessions += src.EgressSessions
+	if src.Severity > dst.Severity {
+		dst.Severity = src.Severity
+	}
+	if src.PolicyAction > dst.PolicyAction {
+		dst.PolicyAction, dst.PolicyID = src.PolicyAction, src.PolicyID
+	}
+	if src.LastSeenAt.After(dst.LastSeenAt) {
+		dst.LastSeenAt = src.LastSeenAt
+	}
+	for _, id := range src.Threats {
+		if !containsThreat(dst.Threats, id) {
+			dst.Threats = append(dst.Threats, id)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Package cache 跨Agent同一流量的合并
+package cache
+
+import (
+	"sort"
+	"time"
+
+	controller "github.com/micro-segment/internal/controller"
+)
+
+// flowKey 跨Agent识别同一流量的键：客户端IP、服务端IP、服务端端口和协议
+// 客户端端口为临时端口且已被Agent聚合，不参与
+type flowKey struct {
+	client, server [16]byte
+	port           uint16
+	proto          uint8
+}
+
+// newFlowKey 生成连接的流量键，汇总记录不含端口和协议，不参与去重
+func newFlowKey(conn *controller.Connection) (flowKey, bool) {
+	if conn.Summary {
+		return flowKey{}, false
+	}
+	var k flowKey
+	copy(k.client[:], conn.ClientIP.To16())
+	copy(k.server[:], conn.ServerIP.To16())
+	k.port = conn.ServerPort
+	k.proto = conn.IPProto
+	return k, true
+}
+
+// findFlow 查找同一流量的已有连接缓存及其key，调用方需持有锁
+func (c *Cache) findFlow(flow flowKey) (*ConnectionCache, string, bool) {
+	key, ok := c.flows[flow]
+	if !ok {
+		return nil, "", false
+	}
+	cache, ok := c.connections[key]
+	if !ok || !cache.tracked || cache.flow != flow {
+		return nil, "", false
+	}
+	return cache, key, true
+}
+
+// forgetFlow 删除连接缓存时移除其流量索引，调用方需持有写锁
+func (c *Cache) forgetFlow(key string, cache *ConnectionCache) {
+	if cache.tracked && c.flows[cache.flow] == key {
+		delete(c.flows, cache.flow)
+	}
+}
+
+// mergeObservations 合并各Agent对同一流量的观察，调用方需持有锁
+// 两端Agent统计的是同一份流量，计数取最大值而非相加；
+// 首次出现时间和字节速率取字节数最多的观察，避免混用不同Agent的时钟，
+// 最近出现时间取各观察的最大值，超前于Controller时钟的部分被扣除
+func (c *Cache) mergeObservations(observers map[string]*controller.Connection) *controller.Connection {
+	ids := make([]string, 0, len(observers))
+	for id := range observers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	now := c.now()
+	var primary *controller.Connection
+	var lastSeen time.Time
+	for _, id := range ids {
+		obs := skewAdjusted(observers[id], now)
+		if primary == nil || obs.Bytes > primary.Bytes ||
+			(obs.Bytes == primary.Bytes && obs.LastSeenAt.After(primary.LastSeenAt)) {
+			primary = obs
+		}
+		if obs.LastSeenAt.After(lastSeen) {
+			lastSeen = obs.LastSeenAt
+		}
+	}
+
+	merged := *primary
+	merged.LastSeenAt = lastSeen
+	merged.Agents = nil
+	for _, id := range ids {
+		obs := observers[id]
+		if id != "" {
+			merged.Agents = append(merged.Agents, id)
+		}
+		if _, ok := c.workloads[obs.ClientWL]; ok {
+			if _, known := c.workloads[merged.ClientWL]; !known {
+				merged.ClientWL = obs.ClientWL
+			}
+		}
+		if _, ok := c.workloads[obs.ServerWL]; ok {
+			if _, known := c.workloads[merged.ServerWL]; !known {
+				merged.ServerWL = obs.ServerWL
+			}
+		}
+		if obs.Sessions > merged.Sessions {
+			merged.Sessions = obs.Sessions
+		}
+		if obs.Severity > merged.Severity {
+			merged.Severity, merged.ThreatID = obs.Severity, obs.ThreatID
+		}
+		if obs.PolicyAction > merged.PolicyAction {
+			merged.PolicyAction, merged.PolicyID = obs.PolicyAction, obs.PolicyID
+		}
+		merged.Capped = merged.Capped || obs.Capped
+		merged.InternetIngress = merged.InternetIngress || obs.InternetIngress
+	}
+	return &merged
+}
+
+// skewAdjusted 最近出现时间超前于now时，将首末次出现时间一并回拨
+func skewAdjusted(conn *controller.Connection, now time.Time) *controller.Connection {
+	skew := conn.LastSeenAt.Sub(now)
+	if skew <= 0 {
+		return conn
+	}
+	adjusted := *conn
+	adjusted.LastSeenAt = now
+	if !adjusted.FirstSeenAt.IsZero() {
+		adjusted.FirstSeenAt = adjusted.FirstSeenAt.Add(-skew)
+	}
+	return &adjusted
+}
@@ -0,0 +1,47 @@
+// Package cache 外部端点地理信息
+package cache
+
+import (
+	"net"
+
+	controller "github.com/micro-segment/internal/controller"
+)
+
+// SetGeoLookup 设置外部端点地理信息查询，通常为geo.Enricher.Lookup
+// 查询在持有缓存锁时调用，不得阻塞；nil时不做地理信息补充
+func (c *Cache) SetGeoLookup(lookup func(ip net.IP) (*controller.GeoInfo, bool)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.geoLookup = lookup
+}
+
+// enrichGeo 为连接的外部端点补充地理信息，调用方需持有写锁
+// 尚未解析完成的端点本次留空，后续上报时补充
+func (c *Cache) enrichGeo(conn *controller.Connection) {
+	if c.geoLookup == nil {
+		return
+	}
+	if c.externalEndpoint(conn.ClientWL, conn.ClientIP) {
+		if info, ok := c.geoLookup(conn.ClientIP); ok {
+			conn.ClientGeo = info
+		}
+	}
+	if c.externalEndpoint(conn.ServerWL, conn.ServerIP) {
+		if info, ok := c.geoLookup(conn.ServerIP); ok {
+			conn.ServerGeo = info
+		}
+	}
+}
+
+// externalEndpoint 判断连接端点是否为外部公网端点，调用方需持有读锁
+// 已知工作负载和主机以及私有、回环等非公网单播地址不是外部端点
+func (c *Cache) externalEndpoint(id string, ip net.IP) bool {
+	if ip == nil || !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	if _, ok := c.workloads[id]; ok {
+		return false
+	}
+	_, ok := c.hosts[id]
+	return !ok
+}
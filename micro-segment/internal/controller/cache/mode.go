@@ -0,0 +1,131 @@
+// Package cache 组策略模式向成员工作负载的传播
+package cache
+
+import (
+	"sort"
+
+	pb "github.com/micro-segment/api/proto"
+	controller "github.com/micro-segment/internal/controller"
+)
+
+// SetOnModeChange 设置成员策略模式变化回调，参数为需要重新推送的Agent ID
+// 回调在释放缓存锁后调用，通常为gRPC服务器的ResyncAgent
+func (c *Cache) SetOnModeChange(cb func(agentID string)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.onModeChange = cb
+}
+
+// ApplyGroupMode 设置组策略模式并重新计算当前成员生效的模式，组不存在返回false
+// 非成员不受影响，成员模式发生变化时通知其所属Agent
+func (c *Cache) ApplyGroupMode(name string, mode controller.PolicyMode) bool {
+	c.mutex.Lock()
+	cache, ok := c.groups[name]
+	if !ok {
+		c.mutex.Unlock()
+		return false
+	}
+	group := *cache.Group
+	group.PolicyMode = mode
+	cache.Group = &group
+
+	agents := c.applyGroupModes(memberIDs(cache.Members))
+	cb := c.onModeChange
+	c.mutex.Unlock()
+
+	notifyModeChange(cb, agents)
+	return true
+}
+
+// memberIDs 组成员ID列表
+func memberIDs(members map[string]bool) []string {
+	ids := make([]string, 0, len(members))
+	for id := range members {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// effectiveMode 计算工作负载生效的策略模式，调用方需持有锁
+// 所属组设置了模式时以组为准，多个组中任一为Protect即为Protect；否则沿用工作负载自身上报的模式
+func (c *Cache) effectiveMode(id string, own controller.PolicyMode) controller.PolicyMode {
+	mode := controller.PolicyMode("")
+	for _, cache := range c.groups {
+		if !cache.Members[id] || cache.Group.PolicyMode == "" {
+			continue
+		}
+		if cache.Group.PolicyMode == controller.PolicyModeProtect {
+			return controller.PolicyModeProtect
+		}
+		mode = cache.Group.PolicyMode
+	}
+	if mode == "" {
+		return own
+	}
+	return mode
+}
+
+// applyGroupModes 重新计算指定工作负载的策略模式，调用方需持有写锁
+// 返回模式发生变化的工作负载所属Agent，去重并排序
+func (c *Cache) applyGroupModes(ids []string) []string {
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		wl, ok := c.workloads[id]
+		if !ok {
+			continue
+		}
+		mode := c.effectiveMode(id, wl.Workload.PolicyMode)
+		if mode == wl.PolicyMode {
+			continue
+		}
+		wl.PolicyMode = mode
+		if wl.Workload.AgentID != "" {
+			seen[wl.Workload.AgentID] = true
+		}
+	}
+
+	agents := make([]string, 0, len(seen))
+	for id := range seen {
+		agents = append(agents, id)
+	}
+	sort.Strings(agents)
+	return agents
+}
+
+// notifyModeChange 通知Agent成员模式已变化，需在释放锁后调用
+func notifyModeChange(cb func(agentID string), agents []string) {
+	if cb == nil {
+		return
+	}
+	for _, id := range agents {
+		cb(id)
+	}
+}
+
+// GetWorkloadPolicyMode 获取工作负载生效的策略模式，工作负载不存在返回空
+func (c *Cache) GetWorkloadPolicyMode(id string) controller.PolicyMode {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if wl, ok := c.workloads[id]; ok {
+		return wl.PolicyMode
+	}
+	return ""
+}
+
+// AgentWorkloadModes 获取Agent上报的各工作负载生效的策略模式，按工作负载ID排序
+// 随策略推送下发，Agent据此设置DP执行模式
+func (c *Cache) AgentWorkloadModes(agentID string) []*pb.WorkloadMode {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	var modes []*pb.WorkloadMode
+	for id, wl := range c.workloads {
+		if wl.Workload.AgentID != agentID {
+			continue
+		}
+		modes = append(modes, &pb.WorkloadMode{WorkloadId: id, Mode: string(wl.PolicyMode)})
+	}
+	sort.Slice(modes, func(i, j int) bool { return modes[i].WorkloadId < modes[j].WorkloadId })
+	return modes
+}
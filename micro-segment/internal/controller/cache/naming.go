@@ -0,0 +1,82 @@
+// Package cache 由容器标签生成工作负载服务名和域名
+package cache
+
+import (
+	"fmt"
+	"strings"
+
+	controller "github.com/micro-segment/internal/controller"
+)
+
+// WorkloadNaming 由容器标签生成工作负载服务名和域名的模板
+// 模板中的{标签名}替换为标签值，如"{app}"、"{app}-{tier}"，引用的标签缺失时不生成；空模板不生效
+type WorkloadNaming struct {
+	Service string
+	Domain  string
+}
+
+// Validate 校验模板的花括号成对且标签名非空
+func (n WorkloadNaming) Validate() error {
+	for _, t := range []struct{ name, tmpl string }{{"service", n.Service}, {"domain", n.Domain}} {
+		if _, err := expandLabels(t.tmpl, nil, true); err != nil {
+			return fmt.Errorf("invalid %s template %q: %w", t.name, t.tmpl, err)
+		}
+	}
+	return nil
+}
+
+// SetWorkloadNaming 设置工作负载命名模板，作用于之后从Agent上报的工作负载
+// Agent已上报服务名或域名时保留上报值，只填充空字段
+func (c *Cache) SetWorkloadNaming(n WorkloadNaming) error {
+	if err := n.Validate(); err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.naming = n
+	return nil
+}
+
+// apply 按标签填充工作负载空的服务名和域名
+func (n WorkloadNaming) apply(wl *controller.Workload) {
+	if wl.Service == "" && n.Service != "" {
+		wl.Service, _ = expandLabels(n.Service, wl.Labels, false)
+	}
+	if wl.Domain == "" && n.Domain != "" {
+		wl.Domain, _ = expandLabels(n.Domain, wl.Labels, false)
+	}
+}
+
+// expandLabels 替换模板中的{标签名}，引用的标签缺失或值为空时返回空字符串
+// syntaxOnly时只检查模板语法
+func expandLabels(tmpl string, labels map[string]string, syntaxOnly bool) (string, error) {
+	var b strings.Builder
+	for rest := tmpl; rest != ""; {
+		open := strings.IndexAny(rest, "{}")
+		if open < 0 {
+			b.WriteString(rest)
+			break
+		}
+		if rest[open] == '}' {
+			return "", fmt.Errorf("unmatched '}'")
+		}
+		b.WriteString(rest[:open])
+		end := strings.IndexAny(rest[open+1:], "{}")
+		if end < 0 || rest[open+1+end] != '}' {
+			return "", fmt.Errorf("unmatched '{'")
+		}
+		name := rest[open+1 : open+1+end]
+		if name == "" {
+			return "", fmt.Errorf("empty label name")
+		}
+		if !syntaxOnly {
+			value := labels[name]
+			if value == "" {
+				return "", nil
+			}
+			b.WriteString(value)
+		}
+		rest = rest[open+2+end:]
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,132 @@
+// Package cache 提供Controller缓存管理
+package cache
+
+import (
+	"sort"
+	"time"
+
+	controller "github.com/micro-segment/internal/controller"
+)
+
+// maxWorkloadPorts 每个工作负载每个方向记录的端口数上限
+// 超出时淘汰最久未见的端口
+const maxWorkloadPorts = 256
+
+// portKey 端口与协议
+type portKey struct {
+	port  uint16
+	proto uint8
+}
+
+// portSet 端口集合
+type portSet map[portKey]*controller.PortUsage
+
+// workloadPorts 工作负载作为服务端和客户端观察到的端口
+type workloadPorts struct {
+	server  portSet
+	client  portSet
+	exposed portSet // 作为服务端被公网客户端访问的端口
+}
+
+// recordPort 记录连接两端工作负载观察到的端口，调用方需持有写锁
+// 服务端记录被访问的端口，客户端记录访问的对端端口；流量汇总不含端口，不记录
+func (c *Cache) recordPort(conn *controller.Connection) {
+	if conn.Summary {
+		return
+	}
+	first, last := conn.FirstSeenAt, conn.LastSeenAt
+	if last.IsZero() {
+		last = c.now()
+	}
+	if first.IsZero() || first.After(last) {
+		first = last
+	}
+
+	key := portKey{port: conn.ServerPort, proto: conn.IPProto}
+	if conn.ServerWL != "" {
+		wp := c.workloadPorts(conn.ServerWL)
+		wp.server.record(key, first, last)
+		if conn.InternetIngress {
+			wp.exposed.record(key, first, last)
+		}
+	}
+	if conn.ClientWL != "" {
+		c.workloadPorts(conn.ClientWL).client.record(key, first, last)
+	}
+}
+
+// workloadPorts 获取或创建工作负载的端口记录，调用方需持有写锁
+func (c *Cache) workloadPorts(id string) *workloadPorts {
+	wp, ok := c.ports[id]
+	if !ok {
+		wp = &workloadPorts{server: make(portSet), client: make(portSet), exposed: make(portSet)}
+		c.ports[id] = wp
+	}
+	return wp
+}
+
+// record 记录端口的首末次出现时间
+func (s portSet) record(key portKey, first, last time.Time) {
+	if usage, ok := s[key]; ok {
+		if first.Before(usage.FirstSeenAt) {
+			usage.FirstSeenAt = first
+		}
+		if last.After(usage.LastSeenAt) {
+			usage.LastSeenAt = last
+		}
+		return
+	}
+
+	if len(s) >= maxWorkloadPorts {
+		s.evictOldest()
+	}
+	s[key] = &controller.PortUsage{
+		Port:        key.port,
+		IPProto:     key.proto,
+		FirstSeenAt: first,
+		LastSeenAt:  last,
+	}
+}
+
+// evictOldest 淘汰最久未见的端口
+func (s portSet) evictOldest() {
+	var oldest portKey
+	var oldestAt *controller.PortUsage
+	for key, usage := range s {
+		if oldestAt == nil || usage.LastSeenAt.Before(oldestAt.LastSeenAt) {
+			oldest, oldestAt = key, usage
+		}
+	}
+	delete(s, oldest)
+}
+
+// list 按端口和协议排序返回端口列表
+func (s portSet) list() []controller.PortUsage {
+	result := make([]controller.PortUsage, 0, len(s))
+	for _, usage := range s {
+		result = append(result, *usage)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Port != result[j].Port {
+			return result[i].Port < result[j].Port
+		}
+		return result[i].IPProto < result[j].IPProto
+	})
+	return result
+}
+
+// GetWorkloadPorts 获取工作负载在连接中观察到的端口
+// 分别返回作为服务端和客户端的端口，工作负载没有记录时返回nil
+func (c *Cache) GetWorkloadPorts(id string) *controller.WorkloadPorts {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	wp, ok := c.ports[id]
+	if !ok {
+		return nil
+	}
+	return &controller.WorkloadPorts{
+		Server: wp.server.list(),
+		Client: wp.client.list(),
+	}
+}
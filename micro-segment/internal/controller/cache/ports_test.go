@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	pb "github.com/micro-segment/api/proto"
+	controller "github.com/micro-segment/internal/controller"
+)
+
+func TestWorkloadPorts(t *testing.T) {
+	c := NewCache()
+	conn := func(client string, port, proto, first, last uint32) *pb.Connection {
+		return &pb.Connection{ClientWl: client, ServerWl: "db", ClientIp: ip1, ServerIp: ip2,
+			ServerPort: port, IpProto: proto, FirstSeenAt: first, LastSeenAt: last}
+	}
+	c.UpdateConnectionFromProto(conn("a", 5432, 6, 1700000000, 1700000100))
+	c.UpdateConnectionFromProto(conn("b", 5432, 6, 1700000050, 1700000300))
+	c.UpdateConnectionFromProto(conn("c", 53, 17, 1700000200, 1700000200))
+	c.UpdateConnectionFromProto(conn("d", 53, 6, 1700000200, 1700000250))
+
+	ports := c.GetWorkloadPorts("db").Server
+	want := []controller.PortUsage{
+		{Port: 53, IPProto: 6, FirstSeenAt: time.Unix(1700000200, 0), LastSeenAt: time.Unix(1700000250, 0)},
+		{Port: 53, IPProto: 17, FirstSeenAt: time.Unix(1700000200, 0), LastSeenAt: time.Unix(1700000200, 0)},
+		{Port: 5432, IPProto: 6, FirstSeenAt: time.Unix(1700000000, 0), LastSeenAt: time.Unix(1700000300, 0)},
+	}
+	if len(ports) != len(want) {
+		t.Fatalf("Unexpected ports: %+v", ports)
+	}
+	for i := range want {
+		if ports[i].Port != want[i].Port || ports[i].IPProto != want[i].IPProto ||
+			!ports[i].FirstSeenAt.Equal(want[i].FirstSeenAt) || !ports[i].LastSeenAt.Equal(want[i].LastSeenAt) {
+			t.Errorf("Port %d: got %+v, want %+v", i, ports[i], want[i])
+		}
+	}
+
+	if client := c.GetWorkloadPorts("db").Client; len(client) != 0 {
+		t.Errorf("Unexpected client ports: %+v", client)
+	}
+
+	// 客户端记录访问的对端端口
+	a := c.GetWorkloadPorts("a")
+	if len(a.Server) != 0 || len(a.Client) != 1 || a.Client[0].Port != 5432 || a.Client[0].IPProto != 6 {
+		t.Errorf("Unexpected client ports: %+v", a)
+	}
+
+	// 删除工作负载后清空
+	c.DeleteWorkload("db")
+	if ports := c.GetWorkloadPorts("db"); ports != nil {
+		t.Errorf("Ports kept after delete: %+v", ports)
+	}
+}
+
+func TestListExposure(t *testing.T) {
+	c := NewCache()
+	c.UpdateWorkloadFromProto(&pb.Workload{Id: "web", Name: "nginx", Domain: "shop"})
+	for _, conn := range []*pb.Connection{
+		{ClientWl: "8.8.8.8", ServerWl: "web", ServerPort: 443, IpProto: 6, InternetIngress: true},
+		{ClientWl: "1.1.1.1", ServerWl: "web", ServerPort: 80, IpProto: 6, InternetIngress: true},
+		{ClientWl: "api", ServerWl: "web", ServerPort: 8080, IpProto: 6},
+		{ClientWl: "web", ServerWl: "db", ServerPort: 5432, IpProto: 6},
+	} {
+		conn.ClientIp, conn.ServerIp = ip1, ip2
+		if err := c.UpdateConnectionFromProto(conn); err != nil {
+			t.Fatalf("Connection rejected: %v", err)
+		}
+	}
+
+	exposure := c.ListExposure()
+	if len(exposure) != 1 || exposure[0].ID != "web" || exposure[0].Name != "nginx" || exposure[0].Domain != "shop" {
+		t.Fatalf("Unexpected exposure: %+v", exposure)
+	}
+	ports := exposure[0].Ports
+	if len(ports) != 2 || ports[0].Port != 80 || ports[1].Port != 443 {
+		t.Errorf("Unexpected exposed ports: %+v", ports)
+	}
+
+	c.DeleteWorkload("web")
+	if exposure := c.ListExposure(); len(exposure) != 0 {
+		t.Errorf("Exposure kept after delete: %+v", exposure)
+	}
+}
+
+func TestWorkloadPortsCap(t *testing.T) {
+	c := NewCache()
+	base := time.Unix(1700000000, 0)
+	for i := 0; i <= maxWorkloadPorts; i++ {
+		c.UpdateConnection(&controller.Connection{
+			ClientWL:   "a",
+			ServerWL:   "db",
+			ServerPort: uint16(1000 + i),
+			IPProto:    6,
+			LastSeenAt: base.Add(time.Duration(i) * time.Second),
+		})
+	}
+
+	ports := c.GetWorkloadPorts("db").Server
+	if len(ports) != maxWorkloadPorts {
+		t.Fatalf("Unexpected port count: %d", len(ports))
+	}
+	// 最久未见的端口被淘汰
+	if ports[0].Port != 1001 || ports[len(ports)-1].Port != uint16(1000+maxWorkloadPorts) {
+		t.Errorf("Unexpected ports kept: %d..%d", ports[0].Port, ports[len(ports)-1].Port)
+	}
+	if n := len(c.GetWorkloadPorts("a").Client); n != maxWorkloadPorts {
+		t.Errorf("Unexpected client port count: %d", n)
+	}
+}
+
+func TestWorkloadPortsBothRoles(t *testing.T) {
+	c := NewCache()
+	c.UpdateConnection(&controller.Connection{ClientWL: "web", ServerWL: "db", ServerPort: 5432, IPProto: 6})
+	c.UpdateConnection(&controller.Connection{ClientWL: "web", ServerWL: "dns", ServerPort: 53, IPProto: 17})
+	c.UpdateConnection(&controller.Connection{ClientWL: "lb", ServerWL: "web", ServerPort: 8080, IPProto: 6})
+
+	ports := c.GetWorkloadPorts("web")
+	if len(ports.Server) != 1 || ports.Server[0].Port != 8080 {
+		t.Errorf("Unexpected server ports: %+v", ports.Server)
+	}
+	if len(ports.Client) != 2 || ports.Client[0].Port != 53 || ports.Client[0].IPProto != 17 ||
+		ports.Client[1].Port != 5432 || ports.Client[1].IPProto != 6 {
+		t.Errorf("Unexpected client ports: %+v", ports.Client)
+	}
+	if c.GetWorkloadPorts("unknown") != nil {
+		t.Errorf("Unexpected ports for unknown workload")
+	}
+}
@@ -0,0 +1,50 @@
+// Package cache 拓扑图输出时的链接过滤
+package cache
+
+import (
+	controller "github.com/micro-segment/internal/controller"
+)
+
+// GraphPrune 拓扑图输出时的链接过滤条件，只作用于响应，不修改缓存
+type GraphPrune struct {
+	MinBytes    uint64 // 字节数低于该值的链接被省略
+	MinSessions uint32 // 会话数低于该值的链接被省略
+	KeepPolicy  bool   // 保留命中规则或被拒绝的链接上的工作负载节点，即使其链接被省略
+}
+
+// enabled 是否设置了过滤条件
+func (p GraphPrune) enabled() bool {
+	return p.MinBytes > 0 || p.MinSessions > 0
+}
+
+// PruneNetworkGraph 省略低于阈值的链接，并删除因此失去全部链接的节点
+// 原本就没有链接的节点保留，KeepPolicy时与策略相关的工作负载节点也保留
+func PruneNetworkGraph(graph *controller.NetworkGraph, p GraphPrune) {
+	if !p.enabled() {
+		return
+	}
+
+	linked := make(map[string]bool)   // 过滤前有链接的节点
+	kept := make(map[string]bool)     // 过滤后仍有链接的节点
+	relevant := make(map[string]bool) // 被省略的策略相关链接的端点
+	links := graph.Links[:0]
+	for _, link := range graph.Links {
+		linked[link.From], linked[link.To] = true, true
+		if link.Bytes >= p.MinBytes && link.Sessions >= p.MinSessions {
+			links = append(links, link)
+			kept[link.From], kept[link.To] = true, true
+		} else if link.PolicyID != 0 || controller.PolicyAction(link.PolicyAction) >= controller.PolicyActionDeny {
+			relevant[link.From], relevant[link.To] = true, true
+		}
+	}
+	graph.Links = links
+
+	nodes := graph.Nodes[:0]
+	for _, node := range graph.Nodes {
+		if linked[node.ID] && !kept[node.ID] && !(p.KeepPolicy && relevant[node.ID] && node.Kind == "workload") {
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	graph.Nodes = nodes
+}
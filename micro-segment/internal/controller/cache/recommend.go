@@ -0,0 +1,88 @@
+// Package cache 提供Controller缓存管理
+package cache
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	controller "github.com/micro-segment/internal/controller"
+)
+
+// recommendComment 推荐规则的备注
+const recommendComment = "recommended from observed traffic"
+
+// RecommendPolicies 根据组成员的已观察连接生成候选allow规则
+// 按对端和方向合并，同一对端的端口合并到一条规则，规则ID为0由调用方分配
+// 对端属于多个组时取名称最小的组，不属于任何组时使用工作负载ID，组不存在时返回nil
+func (c *Cache) RecommendPolicies(group string) []*controller.PolicyRule {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	gc, ok := c.groups[group]
+	if !ok {
+		return nil
+	}
+
+	type peerKey struct{ from, to string }
+	memberOf := c.workloadGroupNames()
+	ports := make(map[peerKey]map[string]bool)
+	add := func(key peerKey, conn *controller.Connection) {
+		set, ok := ports[key]
+		if !ok {
+			set = make(map[string]bool)
+			ports[key] = set
+		}
+		set[portString(conn.IPProto, conn.ServerPort)] = true
+	}
+
+	for _, cache := range c.connections {
+		conn := cache.Connection
+		clientIn, serverIn := gc.Members[conn.ClientWL], gc.Members[conn.ServerWL]
+		switch {
+		case clientIn && serverIn:
+			add(peerKey{group, group}, conn)
+		case clientIn:
+			add(peerKey{group, policyEndpointNames(conn.ServerWL, memberOf)[0]}, conn)
+		case serverIn:
+			add(peerKey{policyEndpointNames(conn.ClientWL, memberOf)[0], group}, conn)
+		}
+	}
+
+	rules := make([]*controller.PolicyRule, 0, len(ports))
+	for key, set := range ports {
+		list := make([]string, 0, len(set))
+		for p := range set {
+			list = append(list, p)
+		}
+		sort.Strings(list)
+		rules = append(rules, &controller.PolicyRule{
+			From:    key.from,
+			To:      key.to,
+			Ports:   strings.Join(list, ","),
+			Action:  "allow",
+			Comment: recommendComment,
+		})
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].From != rules[j].From {
+			return rules[i].From < rules[j].From
+		}
+		return rules[i].To < rules[j].To
+	})
+	return rules
+}
+
+// portString 将协议和端口格式化为规则端口，如tcp/80
+func portString(proto uint8, port uint16) string {
+	switch proto {
+	case 6:
+		return fmt.Sprintf("tcp/%d", port)
+	case 17:
+		return fmt.Sprintf("udp/%d", port)
+	case 1:
+		return "icmp"
+	default:
+		return fmt.Sprintf("%d/%d", proto, port)
+	}
+}
@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"reflect"
+	"testing"
+
+	controller "github.com/micro-segment/internal/controller"
+)
+
+func TestRecommendPolicies(t *testing.T) {
+	c := NewCache()
+	for group, members := range map[string][]string{
+		"web": {"web1", "web2"},
+		"db":  {"db1"},
+		"lb":  {"lb1"},
+	} {
+		c.AddGroup(&controller.Group{Name: group, PolicyMode: controller.PolicyModeMonitor})
+		for _, m := range members {
+			c.AddGroupMember(group, m)
+		}
+	}
+
+	for _, conn := range []*controller.Connection{
+		{ClientWL: "lb1", ServerWL: "web1", ServerPort: 8080, IPProto: 6},
+		{ClientWL: "lb1", ServerWL: "web2", ServerPort: 8443, IPProto: 6},
+		{ClientWL: "web1", ServerWL: "db1", ServerPort: 5432, IPProto: 6},
+		{ClientWL: "web2", ServerWL: "db1", ServerPort: 5432, IPProto: 6},
+		{ClientWL: "web2", ServerWL: "dns-1", ServerPort: 53, IPProto: 17},
+		{ClientWL: "web1", ServerWL: "web2", ServerPort: 7946, IPProto: 6},
+		{ClientWL: "lb1", ServerWL: "db1", ServerPort: 5432, IPProto: 6}, // 与web组无关
+	} {
+		c.UpdateConnection(conn)
+	}
+
+	var got [][3]string
+	for _, rule := range c.RecommendPolicies("web") {
+		if rule.ID != 0 || rule.Action != "allow" {
+			t.Errorf("Unexpected rule: %+v", rule)
+		}
+		got = append(got, [3]string{rule.From, rule.To, rule.Ports})
+	}
+	want := [][3]string{
+		{"lb", "web", "tcp/8080,tcp/8443"},
+		{"web", "db", "tcp/5432"},
+		{"web", "dns-1", "udp/53"},
+		{"web", "web", "tcp/7946"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected recommendations:\n got %v\nwant %v", got, want)
+	}
+
+	if rules := c.RecommendPolicies("missing"); rules != nil {
+		t.Errorf("Unexpected rules for unknown group: %v", rules)
+	}
+}
@@ -0,0 +1,166 @@
+// Package cache 服务级拓扑图
+package cache
+
+import (
+	"net"
+	"sort"
+
+	controller "github.com/micro-segment/internal/controller"
+)
+
+// serviceNodeID 服务节点ID，不同域的同名服务是不同节点
+func serviceNodeID(domain, service string) string {
+	if domain == "" {
+		return "service:" + service
+	}
+	return "service:" + domain + "/" + service
+}
+
+// serviceGraphNode 返回工作负载所属的服务节点，端点不是有服务名的工作负载时返回false，调用方需持有读锁
+func (c *Cache) serviceGraphNode(id string) (controller.GraphNode, bool) {
+	cache, ok := c.workloads[id]
+	if !ok || cache.Workload.Service == "" {
+		return controller.GraphNode{}, false
+	}
+	wl := cache.Workload
+	return controller.GraphNode{
+		ID:      serviceNodeID(wl.Domain, wl.Service),
+		Name:    wl.Service,
+		Kind:    "service",
+		Domain:  wl.Domain,
+		Service: wl.Service,
+	}, true
+}
+
+// serviceApp 链接上一种应用的聚合
+type serviceApp struct {
+	app   controller.LinkApplication
+	ports map[controller.ServicePort]bool
+}
+
+// GetServiceGraph 获取服务级拓扑图
+// 有服务名的工作负载按域和服务名合并为service节点，无服务名的工作负载、主机和外部端点保持原节点；
+// 同一对节点间各工作负载对的连接合并为一条链接，并按应用聚合计数和访问的服务端端口；
+// 域过滤和外部端点聚合同GetAggregatedNetworkGraph
+func (c *Cache) GetServiceGraph(domain string, agg ExternalAggregation) *controller.NetworkGraph {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	nodes := make([]controller.GraphNode, 0)
+	links := make([]controller.GraphLink, 0)
+
+	inDomain := func(id string) bool {
+		if domain == "" {
+			return true
+		}
+		cache, ok := c.workloads[id]
+		return ok && cache.Workload.Domain == domain
+	}
+	seen := make(map[string]bool)
+	addNode := func(node controller.GraphNode) {
+		if !seen[node.ID] {
+			seen[node.ID] = true
+			nodes = append(nodes, node)
+		}
+	}
+
+	// 收集域内的服务和工作负载节点
+	for _, cache := range c.workloads {
+		if !inDomain(cache.Workload.ID) {
+			continue
+		}
+		if node, ok := c.serviceGraphNode(cache.Workload.ID); ok {
+			addNode(node)
+		} else {
+			addNode(workloadGraphNode(cache.Workload))
+		}
+	}
+
+	// endpoint 返回连接端点对应的节点ID，并收集外部超级节点和域外边界节点
+	endpoint := func(id string, ip net.IP, geo *controller.GeoInfo) string {
+		if super, ok := c.supernode(id, ip, agg); ok {
+			addNode(controller.GraphNode{ID: super, Name: super, Kind: "external", Boundary: domain != ""})
+			return super
+		}
+		if node, ok := c.serviceGraphNode(id); ok {
+			node.Boundary = !inDomain(id)
+			addNode(node)
+			return node.ID
+		}
+		if !inDomain(id) {
+			node := c.boundaryGraphNode(id)
+			if node.Kind == "external" {
+				node.Geo = geo
+			}
+			addNode(node)
+		}
+		return id
+	}
+
+	index := make(map[[2]string]int) // 节点对 -> links下标
+	var apps []map[uint32]*serviceApp
+	for _, cache := range c.connections {
+		conn := cache.Connection
+		if !inDomain(conn.ClientWL) && !inDomain(conn.ServerWL) {
+			continue
+		}
+		from := endpoint(conn.ClientWL, conn.ClientIP, conn.ClientGeo)
+		to := endpoint(conn.ServerWL, conn.ServerIP, conn.ServerGeo)
+
+		link := connectionGraphLink(cache, from, to)
+		key := [2]string{from, to}
+		i, ok := index[key]
+		if ok {
+			mergeGraphLink(&links[i], link)
+		} else {
+			i = len(links)
+			index[key] = i
+			links = append(links, link)
+			apps = append(apps, make(map[uint32]*serviceApp))
+		}
+
+		a, ok := apps[i][conn.Application]
+		if !ok {
+			a = &serviceApp{
+				app:   controller.LinkApplication{Application: conn.Application},
+				ports: make(map[controller.ServicePort]bool),
+			}
+			apps[i][conn.Application] = a
+		}
+		a.app.Bytes += conn.Bytes
+		a.app.Sessions += conn.Sessions
+		// 汇总连接不含端口和协议
+		if !conn.Summary {
+			a.ports[controller.ServicePort{Port: conn.ServerPort, IPProto: conn.IPProto}] = true
+		}
+	}
+
+	for i := range links {
+		links[i].Applications = linkApplications(apps[i])
+	}
+	return &controller.NetworkGraph{
+		Nodes: nodes,
+		Links: links,
+	}
+}
+
+// linkApplications 生成按应用标识排序的应用列表，端口按协议和端口号排序
+func linkApplications(apps map[uint32]*serviceApp) []controller.LinkApplication {
+	list := make([]controller.LinkApplication, 0, len(apps))
+	for _, a := range apps {
+		app := a.app
+		app.Ports = make([]controller.ServicePort, 0, len(a.ports))
+		for port := range a.ports {
+			app.Ports = append(app.Ports, port)
+		}
+		sort.Slice(app.Ports, func(i, j int) bool {
+			if app.Ports[i].IPProto != app.Ports[j].IPProto {
+				return app.Ports[i].IPProto < app.Ports[j].IPProto
+			}
+			return app.Ports[i].Port < app.Ports[j].Port
+		})
+		list = append(list, app)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Application < list[j].Application })
+	return list
+}
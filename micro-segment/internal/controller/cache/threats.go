@@ -0,0 +1,100 @@
+// Package cache 威胁日志查询
+package cache
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	controller "github.com/micro-segment/internal/controller"
+)
+
+// threatNames 威胁ID到名称，与defs.h中的THRT_ID_*保持一致
+var threatNames = map[uint32]string{
+	1001: "TCP.SYN.Flood",
+	1002: "ICMP.Flood",
+	1003: "Source.IP.Session.Limit",
+	2001: "Invalid.Packet.Format",
+	2002: "IP.Fragment.Teardrop",
+	2003: "TCP.SYN.With.Data",
+	2004: "TCP.Split.Handshake",
+	2005: "TCP.No.Client.Data",
+	2006: "Ping.Death",
+	2007: "DNS.Loop.Pointer",
+	2008: "SSH.Version.1",
+	2009: "SSL.Heartbleed",
+	2010: "SSL.Cipher.Overflow",
+	2011: "SSL.Version.2or3",
+	2012: "SSL.TLS1.0",
+	2013: "HTTP.Negative.Body.Length",
+	2014: "HTTP.Request.Smuggling",
+	2015: "HTTP.Request.Slowloris",
+	2016: "TCP.Small.Window",
+	2017: "DNS.Stack.Overflow",
+	2018: "MySQL.Access.Deny",
+	2019: "DNS.Zone.Transfer",
+	2020: "ICMP.Tunneling",
+	2021: "DNS.Type.Null",
+	2022: "SQL.Injection",
+	2023: "Apache.Struts.Remote.Code.Execution",
+	2024: "DNS.Tunneling",
+	2025: "TCP.SACK.DDoS.With.Small.MSS",
+	2026: "K8S.externalIPs.MitM",
+	2027: "SSL.TLS1.1",
+}
+
+// threatName 查询威胁ID对应的名称，未知ID返回数字形式，0返回空
+func threatName(id uint32) string {
+	if id == 0 {
+		return ""
+	}
+	if name, ok := threatNames[id]; ok {
+		return name
+	}
+	return fmt.Sprintf("threat-%d", id)
+}
+
+// ThreatFilter 威胁日志查询条件，零值字段不过滤
+type ThreatFilter struct {
+	MinSeverity uint8     // 严重级别下限，0-4对应info到critical
+	ClientWL    string    // 客户端工作负载
+	ServerWL    string    // 服务端工作负载
+	ThreatID    uint32    // 威胁ID
+	Since       time.Time // 上报时间不早于
+	Until       time.Time // 上报时间不晚于
+}
+
+// match 检查威胁日志是否满足条件
+func (f *ThreatFilter) match(t *controller.ThreatLog) bool {
+	switch {
+	case f.MinSeverity > 0 && severityFromString(t.Severity) < f.MinSeverity:
+		return false
+	case f.ClientWL != "" && t.ClientWL != f.ClientWL:
+		return false
+	case f.ServerWL != "" && t.ServerWL != f.ServerWL:
+		return false
+	case f.ThreatID != 0 && t.ThreatID != f.ThreatID:
+		return false
+	case !f.Since.IsZero() && t.ReportedAt.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && t.ReportedAt.After(f.Until):
+		return false
+	}
+	return true
+}
+
+// QueryThreats 查询满足条件的威胁日志，按上报时间从新到旧排列
+// 上报时间相同时后收到的在前
+func (c *Cache) QueryThreats(f ThreatFilter) []*controller.ThreatLog {
+	c.mutex.RLock()
+	result := make([]*controller.ThreatLog, 0)
+	for i := len(c.threats) - 1; i >= 0; i-- {
+		if f.match(c.threats[i]) {
+			result = append(result, c.threats[i])
+		}
+	}
+	c.mutex.RUnlock()
+
+	sort.SliceStable(result, func(i, j int) bool { return result[i].ReportedAt.After(result[j].ReportedAt) })
+	return result
+}
@@ -0,0 +1,128 @@
+// Package geo 异步解析与结果缓存
+package geo
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+
+	controller "github.com/micro-segment/internal/controller"
+)
+
+const (
+	// defaultQueueSize 待解析IP队列长度
+	defaultQueueSize = 1024
+	// defaultCacheSize 缓存的解析结果数上限
+	defaultCacheSize = 65536
+)
+
+// Enricher 异步解析外部端点的地理信息并缓存结果
+// Lookup只读缓存，未命中时提交后台解析并立即返回，不阻塞调用方
+type Enricher struct {
+	resolver Resolver
+
+	mutex   sync.RWMutex
+	results map[string]*controller.GeoInfo // IP -> 信息，nil表示无记录
+	pending map[string]bool                // 已提交待解析的IP
+	size    int
+
+	queue   chan net.IP
+	dropped uint64
+	stopCh  chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewEnricher 创建地理信息解析器，resolver为nil时Lookup总是未命中
+func NewEnricher(resolver Resolver) *Enricher {
+	return &Enricher{
+		resolver: resolver,
+		results:  make(map[string]*controller.GeoInfo),
+		pending:  make(map[string]bool),
+		size:     defaultCacheSize,
+		queue:    make(chan net.IP, defaultQueueSize),
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Start 启动解析协程
+func (e *Enricher) Start() {
+	e.wg.Add(1)
+	go e.resolveLoop()
+}
+
+// Stop 停止解析，队列中未解析的IP被丢弃
+func (e *Enricher) Stop() {
+	close(e.stopCh)
+	e.wg.Wait()
+}
+
+// Lookup 查询缓存的地理信息，返回的信息在调用方间共享，不得修改
+// 未解析过的IP提交后台解析并返回false，可在持有锁时调用；队列满时丢弃，下次查询重新提交
+func (e *Enricher) Lookup(ip net.IP) (*controller.GeoInfo, bool) {
+	if e.resolver == nil || ip == nil {
+		return nil, false
+	}
+	key := ip.String()
+
+	e.mutex.RLock()
+	info, done := e.results[key]
+	queued := e.pending[key]
+	e.mutex.RUnlock()
+	if done {
+		return info, info != nil
+	}
+	if queued {
+		return nil, false
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.pending[key] {
+		return nil, false
+	}
+	select {
+	case e.queue <- ip:
+		e.pending[key] = true
+	default:
+		atomic.AddUint64(&e.dropped, 1)
+	}
+	return nil, false
+}
+
+// Dropped 获取因队列满未能提交解析的次数
+func (e *Enricher) Dropped() uint64 {
+	return atomic.LoadUint64(&e.dropped)
+}
+
+// resolveLoop 依次解析队列中的IP
+func (e *Enricher) resolveLoop() {
+	defer e.wg.Done()
+	for {
+		select {
+		case ip := <-e.queue:
+			e.resolve(ip)
+		case <-e.stopCh:
+			return
+		}
+	}
+}
+
+// resolve 解析IP并保存结果，缓存满时淘汰任意一条
+func (e *Enricher) resolve(ip net.IP) {
+	var result *controller.GeoInfo
+	if info, ok := e.resolver.Lookup(ip); ok {
+		result = &info
+	}
+
+	key := ip.String()
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	delete(e.pending, key)
+	if len(e.results) >= e.size {
+		for k := range e.results {
+			delete(e.results, k)
+			break
+		}
+	}
+	e.results[key] = result
+}
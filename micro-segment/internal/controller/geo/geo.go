@@ -0,0 +1,118 @@
+// Package geo 外部端点的国家和ASN查询
+// 查询结果经Enricher异步解析并缓存，不阻塞连接上报处理
+package geo
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	controller "github.com/micro-segment/internal/controller"
+)
+
+// Resolver 按IP查询国家和ASN，可能较慢，由Enricher在后台调用
+type Resolver interface {
+	Lookup(ip net.IP) (controller.GeoInfo, bool)
+}
+
+// Table 按网段查询的地理信息表，最长前缀匹配
+type Table struct {
+	prefixes []int                                 // 出现过的前缀长度，从长到短
+	networks map[int]map[string]controller.GeoInfo // 前缀长度 -> 网络地址 -> 信息
+}
+
+// LoadTable 从CSV文件加载地理信息表
+// 每行为network,country,asn[,org]，network为CIDR，asn可带AS前缀或为空；
+// 空行、#开头的注释及network表头行被忽略，格式与GeoLite2导出的ASN/国家合并表一致
+func LoadTable(path string) (*Table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	t := &Table{networks: make(map[int]map[string]controller.GeoInfo)}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, "network,") {
+			continue
+		}
+		if err := t.add(strings.Split(text, ",")); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// add 添加一条网段记录
+func (t *Table) add(fields []string) error {
+	if len(fields) < 3 {
+		return fmt.Errorf("expected network,country,asn[,org]")
+	}
+	_, ipnet, err := net.ParseCIDR(strings.TrimSpace(fields[0]))
+	if err != nil {
+		return err
+	}
+	info := controller.GeoInfo{Country: strings.ToUpper(strings.TrimSpace(fields[1]))}
+	if s := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(fields[2])), "AS"); s != "" {
+		asn, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid asn %q", fields[2])
+		}
+		info.ASN = uint32(asn)
+	}
+	if len(fields) > 3 {
+		info.Org = strings.TrimSpace(strings.Join(fields[3:], ","))
+	}
+
+	ones, _ := ipnet.Mask.Size()
+	if ipnet.IP.To4() == nil {
+		ones += 1000 // IPv6与IPv4前缀分开保存
+	}
+	networks, ok := t.networks[ones]
+	if !ok {
+		networks = make(map[string]controller.GeoInfo)
+		t.networks[ones] = networks
+		t.prefixes = append(t.prefixes, ones)
+		sort.Sort(sort.Reverse(sort.IntSlice(t.prefixes)))
+	}
+	networks[ipnet.IP.String()] = info
+	return nil
+}
+
+// Lookup 按最长前缀匹配查询IP所在网段的信息
+func (t *Table) Lookup(ip net.IP) (controller.GeoInfo, bool) {
+	v4 := ip.To4()
+	for _, ones := range t.prefixes {
+		var key string
+		switch {
+		case ones < 1000 && v4 != nil:
+			key = v4.Mask(net.CIDRMask(ones, 8*net.IPv4len)).String()
+		case ones >= 1000 && v4 == nil:
+			key = ip.Mask(net.CIDRMask(ones-1000, 8*net.IPv6len)).String()
+		default:
+			continue
+		}
+		if info, ok := t.networks[ones][key]; ok {
+			return info, true
+		}
+	}
+	return controller.GeoInfo{}, false
+}
+
+// Len 获取网段记录数
+func (t *Table) Len() int {
+	n := 0
+	for _, networks := range t.networks {
+		n += len(networks)
+	}
+	return n
+}
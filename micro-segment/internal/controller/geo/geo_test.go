@@ -0,0 +1,122 @@
+package geo
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	controller "github.com/micro-segment/internal/controller"
+)
+
+func TestLoadTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "geo.csv")
+	data := "network,country,asn,org\n" +
+		"# comment\n" +
+		"8.8.0.0/16,us,AS15169,Google LLC\n" +
+		"8.8.8.0/24,US,15169,Google, LLC\n" +
+		"203.0.113.0/24,jp,,\n" +
+		"2001:4860::/32,US,15169,Google LLC\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	table, err := LoadTable(path)
+	if err != nil {
+		t.Fatalf("LoadTable failed: %v", err)
+	}
+	if table.Len() != 4 {
+		t.Errorf("Unexpected networks: %d", table.Len())
+	}
+
+	cases := []struct {
+		ip   string
+		want controller.GeoInfo
+		ok   bool
+	}{
+		{"8.8.8.8", controller.GeoInfo{Country: "US", ASN: 15169, Org: "Google, LLC"}, true}, // 最长前缀
+		{"8.8.4.4", controller.GeoInfo{Country: "US", ASN: 15169, Org: "Google LLC"}, true},
+		{"203.0.113.9", controller.GeoInfo{Country: "JP"}, true},
+		{"2001:4860:4860::8888", controller.GeoInfo{Country: "US", ASN: 15169, Org: "Google LLC"}, true},
+		{"1.1.1.1", controller.GeoInfo{}, false},
+	}
+	for _, c := range cases {
+		info, ok := table.Lookup(net.ParseIP(c.ip))
+		if ok != c.ok || info != c.want {
+			t.Errorf("%s: got %+v %v", c.ip, info, ok)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte("8.8.8.0/24,US,ASx\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadTable(path); err == nil {
+		t.Errorf("Invalid asn accepted")
+	}
+}
+
+// fakeResolver 返回固定结果，release关闭前阻塞
+type fakeResolver struct {
+	calls   int32
+	release chan struct{}
+}
+
+func (f *fakeResolver) Lookup(ip net.IP) (controller.GeoInfo, bool) {
+	atomic.AddInt32(&f.calls, 1)
+	<-f.release
+	if ip.Equal(net.ParseIP("8.8.8.8")) {
+		return controller.GeoInfo{Country: "US", ASN: 15169}, true
+	}
+	return controller.GeoInfo{}, false
+}
+
+func TestEnricher(t *testing.T) {
+	resolver := &fakeResolver{release: make(chan struct{})}
+	e := NewEnricher(resolver)
+	e.Start()
+	defer e.Stop()
+
+	// 解析未完成时立即返回
+	for i := 0; i < 3; i++ {
+		if _, ok := e.Lookup(net.ParseIP("8.8.8.8")); ok {
+			t.Fatalf("Unexpected hit before resolution")
+		}
+	}
+	e.Lookup(net.ParseIP("1.1.1.1"))
+	close(resolver.release)
+
+	var info *controller.GeoInfo
+	var ok bool
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if info, ok = e.Lookup(net.ParseIP("8.8.8.8")); ok {
+			break
+		}
+	}
+	if !ok || info.Country != "US" || info.ASN != 15169 {
+		t.Fatalf("Unexpected result: %+v %v", info, ok)
+	}
+
+	// 无记录的结果同样缓存
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		e.mutex.RLock()
+		_, done := e.results["1.1.1.1"]
+		e.mutex.RUnlock()
+		if done {
+			break
+		}
+	}
+	if _, ok := e.Lookup(net.ParseIP("1.1.1.1")); ok {
+		t.Errorf("Unexpected hit for unknown IP")
+	}
+	if calls := atomic.LoadInt32(&resolver.calls); calls != 2 {
+		t.Errorf("Expected 2 resolver calls, got %d", calls)
+	}
+}
+
+func TestEnricherNoResolver(t *testing.T) {
+	e := NewEnricher(nil)
+	if _, ok := e.Lookup(net.ParseIP("8.8.8.8")); ok || len(e.queue) != 0 {
+		t.Errorf("Lookup without resolver not a no-op")
+	}
+}
@@ -4,6 +4,7 @@ package graph
 
 import (
 	"reflect"
+	"sort"
 	"sync"
 )
 
@@ -355,3 +356,151 @@ func (g *Graph) GetLinkCount() int {
 	}
 	return count
 }
+
+// NodeDegree 节点的入度和出度，按不同的对端节点计数，自环不计
+type NodeDegree struct {
+	Node string
+	In   int
+	Out  int
+}
+
+// Degrees 计算所有节点的入度和出度
+// 在读锁内一次遍历完成，返回顺序不固定
+func (g *Graph) Degrees() []NodeDegree {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	ret := make([]NodeDegree, 0, len(g.nodes))
+	for v, n := range g.nodes {
+		ret = append(ret, NodeDegree{Node: v, In: peerCount(v, n.ins), Out: peerCount(v, n.outs)})
+	}
+	return ret
+}
+
+// peerCount 统计各链接类型下不同的对端节点数
+func peerCount(self string, links map[string]*graphLink) int {
+	if len(links) == 1 {
+		for _, l := range links {
+			if _, ok := l.ends[self]; ok {
+				return len(l.ends) - 1
+			}
+			return len(l.ends)
+		}
+	}
+	peers := make(map[string]struct{})
+	for _, l := range links {
+		for v := range l.ends {
+			if v != self {
+				peers[v] = struct{}{}
+			}
+		}
+	}
+	return len(peers)
+}
+
+// TopDegrees 按key降序返回前n个key大于0的节点，相同时按节点名排序
+// 不修改degrees
+func TopDegrees(degrees []NodeDegree, n int, key func(NodeDegree) int) []NodeDegree {
+	ret := make([]NodeDegree, 0, len(degrees))
+	for _, d := range degrees {
+		if key(d) > 0 {
+			ret = append(ret, d)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ki, kj := key(ret[i]), key(ret[j]); ki != kj {
+			return ki > kj
+		}
+		return ret[i].Node < ret[j].Node
+	})
+	if n >= 0 && len(ret) > n {
+		ret = ret[:n]
+	}
+	return ret
+}
+
+// communityMaxRounds 标签传播的最大轮数，保证大图上的计算量有界
+const communityMaxRounds = 20
+
+// Communities 按无向投影计算节点社区
+// 使用标签传播：节点按名称排序后依次取邻居中出现最多的标签，
+// 当前标签并列最多时保持不变，否则取最小标签；标签稳定或达到最大轮数后停止。
+// 返回节点 -> 社区ID，社区ID按节点名顺序从0连续编号，不连通的节点必然属于不同社区
+func (g *Graph) Communities() map[string]int {
+	g.mutex.RLock()
+	names := make([]string, 0, len(g.nodes))
+	for v := range g.nodes {
+		names = append(names, v)
+	}
+	sort.Strings(names)
+	index := make(map[string]int, len(names))
+	for i, v := range names {
+		index[v] = i
+	}
+	neighbors := make([][]int, len(names))
+	for i, v := range names {
+		seen := make(map[int]bool)
+		n := g.nodes[v]
+		for _, links := range []map[string]*graphLink{n.ins, n.outs} {
+			for _, l := range links {
+				for peer := range l.ends {
+					if j, ok := index[peer]; ok && j != i && !seen[j] {
+						seen[j] = true
+						neighbors[i] = append(neighbors[i], j)
+					}
+				}
+			}
+		}
+	}
+	g.mutex.RUnlock()
+
+	labels := make([]int, len(names))
+	for i := range labels {
+		labels[i] = i
+	}
+	counts := make(map[int]int)
+	for round := 0; round < communityMaxRounds; round++ {
+		changed := false
+		for i, peers := range neighbors {
+			if len(peers) == 0 {
+				continue
+			}
+			for k := range counts {
+				delete(counts, k)
+			}
+			best := 0
+			for _, j := range peers {
+				counts[labels[j]]++
+				if counts[labels[j]] > best {
+					best = counts[labels[j]]
+				}
+			}
+			if counts[labels[i]] == best {
+				continue
+			}
+			label := -1
+			for l, c := range counts {
+				if c == best && (label < 0 || l < label) {
+					label = l
+				}
+			}
+			labels[i] = label
+			changed = true
+		}
+		if !changed {
+			break
+		}
+	}
+
+	ret := make(map[string]int, len(names))
+	ids := make(map[int]int)
+	for i, v := range names {
+		id, ok := ids[labels[i]]
+		if !ok {
+			id = len(ids)
+			ids[labels[i]] = id
+		}
+		ret[v] = id
+	}
+	return ret
+}
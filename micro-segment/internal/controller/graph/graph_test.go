@@ -0,0 +1,180 @@
+package graph
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// checkConsistent 检查出链接与入链接互相对应，且不存在空链接
+func checkConsistent(t *testing.T, g *Graph) {
+	t.Helper()
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	for src, gn := range g.nodes {
+		for link, gl := range gn.outs {
+			if len(gl.ends) == 0 {
+				t.Errorf("Empty out link %s/%s", src, link)
+			}
+			for dst := range gl.ends {
+				d, ok := g.nodes[dst]
+				if !ok {
+					t.Errorf("Out link %s->%s to missing node", src, dst)
+					continue
+				}
+				if _, ok := d.ins[link].ends[src]; !ok {
+					t.Errorf("Out link %s->%s has no matching in link", src, dst)
+				}
+			}
+		}
+		for link, gl := range gn.ins {
+			if len(gl.ends) == 0 {
+				t.Errorf("Empty in link %s/%s", src, link)
+			}
+			for from := range gl.ends {
+				f, ok := g.nodes[from]
+				if !ok {
+					t.Errorf("In link %s<-%s from missing node", src, from)
+					continue
+				}
+				if _, ok := f.outs[link].ends[src]; !ok {
+					t.Errorf("In link %s<-%s has no matching out link", src, from)
+				}
+			}
+		}
+	}
+}
+
+func TestDeleteNode(t *testing.T) {
+	g := NewGraph()
+	g.AddLink("a", "graph", "b", 1)
+	g.AddLink("b", "graph", "a", 2)
+	g.AddLink("a", "graph", "a", 3)
+	g.AddLink("c", "graph", "a", 4)
+
+	if g.DeleteNode("a") != "a" {
+		t.Fatalf("Node not deleted")
+	}
+	if g.Node("a") != "" || g.GetLinkCount() != 0 {
+		t.Errorf("Unexpected graph: nodes=%v links=%d", g.All(), g.GetLinkCount())
+	}
+	checkConsistent(t, g)
+
+	if g.DeleteNode("a") != "" {
+		t.Errorf("Deleted missing node")
+	}
+}
+
+func TestConcurrentAddDelete(t *testing.T) {
+	g := NewGraph()
+	const workers, rounds = 8, 500
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				src, dst := fmt.Sprintf("n%d", (w+i)%5), fmt.Sprintf("n%d", i%5)
+				switch i % 4 {
+				case 0, 1:
+					g.AddLink(src, "graph", dst, i)
+				case 2:
+					g.DeleteLink(src, "graph", dst)
+				case 3:
+					g.DeleteNode(dst)
+				}
+				g.Attr(src, "graph", dst)
+				g.Outs(src)
+				g.GetLinkCount()
+			}
+		}(w)
+	}
+	wg.Wait()
+	checkConsistent(t, g)
+}
+
+func TestDegrees(t *testing.T) {
+	// hub访问a、b、c，a、b访问db，c自环，hub与a之间还有另一种链接
+	g := NewGraph()
+	for _, l := range [][2]string{{"hub", "a"}, {"hub", "b"}, {"hub", "c"}, {"a", "db"}, {"b", "db"}, {"c", "c"}} {
+		g.AddLink(l[0], "graph", l[1], nil)
+	}
+	g.AddLink("hub", "policy", "a", nil)
+
+	got := make(map[string]NodeDegree)
+	for _, d := range g.Degrees() {
+		got[d.Node] = d
+	}
+	want := map[string]NodeDegree{
+		"hub": {Node: "hub", In: 0, Out: 3},
+		"a":   {Node: "a", In: 1, Out: 1},
+		"b":   {Node: "b", In: 1, Out: 1},
+		"c":   {Node: "c", In: 1, Out: 0},
+		"db":  {Node: "db", In: 2, Out: 0},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Unexpected degrees: %v", got)
+	}
+	for node, d := range want {
+		if got[node] != d {
+			t.Errorf("Degree of %s = %+v, want %+v", node, got[node], d)
+		}
+	}
+
+	degrees := g.Degrees()
+	fanIn := TopDegrees(degrees, 2, func(d NodeDegree) int { return d.In })
+	if len(fanIn) != 2 || fanIn[0].Node != "db" || fanIn[1].Node != "a" {
+		t.Errorf("Unexpected fan-in top: %v", fanIn)
+	}
+	fanOut := TopDegrees(degrees, 10, func(d NodeDegree) int { return d.Out })
+	if len(fanOut) != 3 || fanOut[0].Node != "hub" {
+		t.Errorf("Unexpected fan-out top: %v", fanOut)
+	}
+	if top := TopDegrees(degrees, 0, func(d NodeDegree) int { return d.In + d.Out }); len(top) != 0 {
+		t.Errorf("Expected empty top, got %v", top)
+	}
+}
+
+func TestCommunities(t *testing.T) {
+	// 两个不连通的簇
+	g := NewGraph()
+	for _, l := range [][2]string{{"a1", "a2"}, {"a2", "a3"}, {"a3", "a1"}, {"b1", "b2"}, {"b2", "b3"}, {"b3", "b1"}} {
+		g.AddLink(l[0], "graph", l[1], nil)
+	}
+	comm := g.Communities()
+	if len(comm) != 6 {
+		t.Fatalf("Unexpected communities: %v", comm)
+	}
+	for _, c := range []string{"a", "b"} {
+		if comm[c+"1"] != comm[c+"2"] || comm[c+"1"] != comm[c+"3"] {
+			t.Errorf("Cluster %s split: %v", c, comm)
+		}
+	}
+	if comm["a1"] == comm["b1"] {
+		t.Errorf("Disconnected clusters share a community: %v", comm)
+	}
+
+	// 全连通的团属于同一社区
+	g = NewGraph()
+	nodes := []string{"n1", "n2", "n3", "n4", "n5"}
+	for _, src := range nodes {
+		for _, dst := range nodes {
+			if src != dst {
+				g.AddLink(src, "graph", dst, nil)
+			}
+		}
+	}
+	comm = g.Communities()
+	for _, v := range nodes {
+		if comm[v] != 0 {
+			t.Errorf("Clique split: %v", comm)
+			break
+		}
+	}
+
+	if comm := NewGraph().Communities(); len(comm) != 0 {
+		t.Errorf("Expected empty communities, got %v", comm)
+	}
+}
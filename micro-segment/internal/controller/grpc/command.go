@@ -0,0 +1,149 @@
+// Package grpc 提供gRPC服务
+package grpc
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	pb "github.com/micro-segment/api/proto"
+	controller "github.com/micro-segment/internal/controller"
+)
+
+// maxAgentCommands 保留的最近命令记录数，超出时丢弃最旧的记录
+const maxAgentCommands = 256
+
+var (
+	// ErrInvalidCommand 命令类型未知或缺少参数
+	ErrInvalidCommand = errors.New("invalid agent command")
+	// ErrAgentUnavailable Agent不存在或已离线
+	ErrAgentUnavailable = errors.New("agent not found or offline")
+)
+
+// commandQueue Agent命令队列，由Server.mutex保护
+// 命令排队后随Agent下一次心跳响应下发，执行结果随后续心跳回传
+type commandQueue struct {
+	seq     uint64
+	pending map[string][]*controller.AgentCommand // 按Agent ID待下发的命令
+	byID    map[string]*controller.AgentCommand
+	recent  []*controller.AgentCommand // 按创建顺序的最近命令
+}
+
+func newCommandQueue() commandQueue {
+	return commandQueue{
+		pending: make(map[string][]*controller.AgentCommand),
+		byID:    make(map[string]*controller.AgentCommand),
+	}
+}
+
+// validateCommand 校验命令类型及参数
+func validateCommand(cmdType, containerID string) error {
+	switch cmdType {
+	case controller.AgentCommandForceCapture, controller.AgentCommandForceStop, controller.AgentCommandPacketSample:
+		if containerID == "" {
+			return fmt.Errorf("%w: %s requires container_id", ErrInvalidCommand, cmdType)
+		}
+	case controller.AgentCommandGetDebug:
+	default:
+		return fmt.Errorf("%w: unknown type %q", ErrInvalidCommand, cmdType)
+	}
+	return nil
+}
+
+// QueueAgentCommand 为在线Agent排队一条命令
+// 命令在该Agent下一次心跳时下发，返回排队后的命令记录
+func (s *Server) QueueAgentCommand(agentID, cmdType, containerID string) (controller.AgentCommand, error) {
+	if err := validateCommand(cmdType, containerID); err != nil {
+		return controller.AgentCommand{}, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	state, ok := s.agents[agentID]
+	if !ok || !state.Online {
+		return controller.AgentCommand{}, fmt.Errorf("%w: %s", ErrAgentUnavailable, agentID)
+	}
+
+	q := &s.commands
+	q.seq++
+	cmd := &controller.AgentCommand{
+		ID:          strconv.FormatUint(q.seq, 10),
+		AgentID:     agentID,
+		Type:        cmdType,
+		ContainerID: containerID,
+		Status:      controller.AgentCommandPending,
+		CreatedAt:   time.Now(),
+	}
+	q.pending[agentID] = append(q.pending[agentID], cmd)
+	q.byID[cmd.ID] = cmd
+	q.recent = append(q.recent, cmd)
+	if len(q.recent) > maxAgentCommands {
+		delete(q.byID, q.recent[0].ID)
+		q.recent = q.recent[1:]
+	}
+
+	log.WithFields(log.Fields{
+		"agent_id": agentID,
+		"command":  cmdType,
+		"id":       cmd.ID,
+	}).Info("Agent command queued")
+	return *cmd, nil
+}
+
+// ListAgentCommands 列出Agent最近的命令记录，按创建顺序
+func (s *Server) ListAgentCommands(agentID string) []controller.AgentCommand {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	result := make([]controller.AgentCommand, 0)
+	for _, cmd := range s.commands.recent {
+		if cmd.AgentID == agentID {
+			result = append(result, *cmd)
+		}
+	}
+	return result
+}
+
+// takeCommands 取出Agent待下发的命令并标记为已下发，调用方需持有s.mutex
+func (s *Server) takeCommands(agentID string) []*pb.AgentCommand {
+	pending := s.commands.pending[agentID]
+	if len(pending) == 0 {
+		return nil
+	}
+	delete(s.commands.pending, agentID)
+
+	cmds := make([]*pb.AgentCommand, 0, len(pending))
+	for _, cmd := range pending {
+		cmd.Status = controller.AgentCommandSent
+		cmds = append(cmds, &pb.AgentCommand{
+			Id:          cmd.ID,
+			Type:        cmd.Type,
+			ContainerId: cmd.ContainerID,
+		})
+	}
+	return cmds
+}
+
+// applyCommandResults 记录Agent回传的命令执行结果，调用方需持有s.mutex
+// 忽略未知命令或其他Agent的命令
+func (s *Server) applyCommandResults(agentID string, results []*pb.AgentCommandResult) {
+	now := time.Now()
+	for _, r := range results {
+		cmd, ok := s.commands.byID[r.Id]
+		if !ok || cmd.AgentID != agentID {
+			continue
+		}
+		if r.Success {
+			cmd.Status = controller.AgentCommandDone
+		} else {
+			cmd.Status = controller.AgentCommandFailed
+		}
+		cmd.Error = r.Error
+		cmd.Output = r.Output
+		cmd.DoneAt = now
+	}
+}
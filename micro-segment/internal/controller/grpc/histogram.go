@@ -0,0 +1,45 @@
+// Package grpc 提供gRPC服务
+package grpc
+
+import (
+	"strconv"
+	"sync/atomic"
+
+	controller "github.com/micro-segment/internal/controller"
+)
+
+// reportSizeBounds 连接上报批量大小直方图的桶上界，按4倍递增
+// 最后一个上界与Agent单次上报上限connectionListMax一致，落入溢出桶说明Agent端配置不一致
+var reportSizeBounds = [...]uint64{0, 1, 4, 16, 64, 256, 1024, 4096, 8192}
+
+// sizeHistogram 固定桶直方图，计数原子递增
+type sizeHistogram struct {
+	counts [len(reportSizeBounds) + 1]atomic.Uint64
+}
+
+// observe 记录一次观测值
+func (h *sizeHistogram) observe(n int) {
+	v := uint64(n)
+	for i, bound := range reportSizeBounds {
+		if v <= bound {
+			h.counts[i].Add(1)
+			return
+		}
+	}
+	h.counts[len(reportSizeBounds)].Add(1)
+}
+
+// snapshot 返回各桶计数，桶之间不累加
+func (h *sizeHistogram) snapshot() []controller.HistogramBucket {
+	buckets := make([]controller.HistogramBucket, 0, len(h.counts))
+	for i, bound := range reportSizeBounds {
+		buckets = append(buckets, controller.HistogramBucket{
+			LE:    strconv.FormatUint(bound, 10),
+			Count: h.counts[i].Load(),
+		})
+	}
+	return append(buckets, controller.HistogramBucket{
+		LE:    "+Inf",
+		Count: h.counts[len(reportSizeBounds)].Load(),
+	})
+}
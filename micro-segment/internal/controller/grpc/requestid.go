@@ -0,0 +1,44 @@
+// Package grpc 请求ID拦截器
+package grpc
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/micro-segment/internal/controller/requestid"
+)
+
+// incomingRequestID 取出请求元数据中的请求ID，未携带或不合法时生成新ID
+func incomingRequestID(ctx context.Context) string {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(requestid.MetadataKey); len(ids) > 0 {
+			id = ids[0]
+		}
+	}
+	return requestid.Resolve(id)
+}
+
+// unaryRequestID 为一元调用关联请求ID并在响应头中回传
+func unaryRequestID(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	id := incomingRequestID(ctx)
+	grpc.SetHeader(ctx, metadata.Pairs(requestid.MetadataKey, id))
+	return handler(requestid.NewContext(ctx, id), req)
+}
+
+// requestIDStream 携带请求ID context的服务端流
+type requestIDStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *requestIDStream) Context() context.Context { return s.ctx }
+
+// streamRequestID 为流式调用关联请求ID并在响应头中回传
+func streamRequestID(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	id := incomingRequestID(ss.Context())
+	ss.SetHeader(metadata.Pairs(requestid.MetadataKey, id))
+	return handler(srv, &requestIDStream{ServerStream: ss, ctx: requestid.NewContext(ss.Context(), id)})
+}
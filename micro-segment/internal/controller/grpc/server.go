@@ -6,14 +6,18 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"sort"
 	"sync"
 	"time"
 
+	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
 
 	pb "github.com/micro-segment/api/proto"
+	controller "github.com/micro-segment/internal/controller"
 	"github.com/micro-segment/internal/controller/cache"
 	"github.com/micro-segment/internal/controller/policy"
+	"github.com/micro-segment/internal/controller/requestid"
 )
 
 // Server gRPC服务器
@@ -25,6 +29,7 @@ type Server struct {
 	grpcServer *grpc.Server
 	port       int
 	running    bool
+	stopCh     chan struct{}
 
 	// 依赖
 	cache  *cache.Cache
@@ -33,6 +38,24 @@ type Server struct {
 	// Agent管理
 	agents map[string]*AgentState
 
+	// 按Agent的重新同步信号，关闭时通知该Agent的策略订阅流重新推送
+	resyncChs map[string]chan struct{}
+
+	// 同一主机重复注册的处理策略
+	duplicatePolicy DuplicateAgentPolicy
+
+	// 被同一主机新Agent取代的旧Agent，其心跳返回非零码且不再使其上线
+	superseded map[string]*supersededAgent
+
+	// 超过此时间未收到心跳的Agent标记离线，注册时告知Agent
+	agentTimeout time.Duration
+
+	// 每次连接上报携带的连接数分布
+	reportSizes sizeHistogram
+
+	// 随心跳下发的Agent命令
+	commands commandQueue
+
 	// 回调函数
 	onAgentJoin  func(agentID, hostID string)
 	onAgentLeave func(agentID string)
@@ -44,6 +67,38 @@ type AgentState struct {
 	LastSeen   time.Time
 	Online     bool
 	Stats      *pb.AgentStats
+	Captures   []string // 最近一次心跳上报的正在捕获的容器
+}
+
+// supersededAgent 被取代的旧Agent
+type supersededAgent struct {
+	by       string    // 取代它的新Agent ID
+	lastSeen time.Time // 被取代时间或之后最近一次心跳时间
+}
+
+// DefaultAgentTimeout 默认Agent心跳超时
+const DefaultAgentTimeout = 60 * time.Second
+
+// DuplicateAgentPolicy 同一主机已有在线Agent时新注册的处理策略
+type DuplicateAgentPolicy string
+
+const (
+	// DuplicateAgentReplace 移除旧Agent，接受新Agent
+	DuplicateAgentReplace DuplicateAgentPolicy = "replace"
+	// DuplicateAgentReject 拒绝新Agent注册
+	DuplicateAgentReject DuplicateAgentPolicy = "reject"
+	// DuplicateAgentOffline 将旧Agent标记离线并保留记录，接受新Agent
+	DuplicateAgentOffline DuplicateAgentPolicy = "offline"
+)
+
+// ParseDuplicateAgentPolicy 解析重复注册处理策略
+func ParseDuplicateAgentPolicy(s string) (DuplicateAgentPolicy, error) {
+	switch p := DuplicateAgentPolicy(s); p {
+	case DuplicateAgentReplace, DuplicateAgentReject, DuplicateAgentOffline:
+		return p, nil
+	default:
+		return "", fmt.Errorf("invalid duplicate agent policy: %s", s)
+	}
 }
 
 // NewServer 创建gRPC服务器
@@ -54,7 +109,33 @@ func NewServer(port int, c *cache.Cache, p *policy.Engine) *Server {
 		cache:  c,
 		policy: p,
 		agents: make(map[string]*AgentState),
+
+		resyncChs:       make(map[string]chan struct{}),
+		duplicatePolicy: DuplicateAgentReplace,
+		superseded:      make(map[string]*supersededAgent),
+		agentTimeout:    DefaultAgentTimeout,
+		commands:        newCommandQueue(),
+	}
+}
+
+// SetDuplicateAgentPolicy 设置重复注册处理策略
+// 默认replace，需在Start之前设置
+func (s *Server) SetDuplicateAgentPolicy(p DuplicateAgentPolicy) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.duplicatePolicy = p
+}
+
+// SetAgentTimeout 设置Agent心跳超时
+// 以秒为单位告知Agent，不足1秒时返回错误，需在Start之前设置
+func (s *Server) SetAgentTimeout(timeout time.Duration) error {
+	if timeout < time.Second {
+		return fmt.Errorf("invalid agent timeout: %v", timeout)
 	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.agentTimeout = timeout
+	return nil
 }
 
 // SetOnAgentJoin 设置Agent加入回调
@@ -85,10 +166,14 @@ func (s *Server) Start() error {
 		return fmt.Errorf("failed to listen: %v", err)
 	}
 
-	s.grpcServer = grpc.NewServer()
+	s.grpcServer = grpc.NewServer(
+		grpc.UnaryInterceptor(unaryRequestID),
+		grpc.StreamInterceptor(streamRequestID),
+	)
 	pb.RegisterControllerServiceServer(s.grpcServer, s)
 
 	s.running = true
+	s.stopCh = make(chan struct{})
 
 	go func() {
 		if err := s.grpcServer.Serve(s.listener); err != nil {
@@ -112,6 +197,8 @@ func (s *Server) Stop() {
 		return
 	}
 
+	// 先结束策略订阅流，否则GracefulStop会一直等待
+	close(s.stopCh)
 	s.grpcServer.GracefulStop()
 	s.listener.Close()
 	s.running = false
@@ -126,14 +213,21 @@ func (s *Server) IsRunning() bool {
 }
 
 // agentTimeoutChecker 检测Agent超时
-// 定期检查Agent心跳超时并标记离线
+// 按超时的一半周期检查Agent心跳超时并标记离线
 func (s *Server) agentTimeoutChecker() {
-	ticker := time.NewTicker(30 * time.Second)
+	s.mutex.RLock()
+	stopCh := s.stopCh
+	ticker := time.NewTicker(s.agentTimeout / 2)
+	s.mutex.RUnlock()
 	defer ticker.Stop()
 
-	for s.running {
-		<-ticker.C
-		s.checkAgentTimeout()
+	for {
+		select {
+		case <-ticker.C:
+			s.checkAgentTimeout()
+		case <-stopCh:
+			return
+		}
 	}
 }
 
@@ -143,17 +237,23 @@ func (s *Server) checkAgentTimeout() {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	timeout := 60 * time.Second
 	now := time.Now()
 
 	for agentID, state := range s.agents {
-		if state.Online && now.Sub(state.LastSeen) > timeout {
+		if state.Online && now.Sub(state.LastSeen) > s.agentTimeout {
 			state.Online = false
 			if s.onAgentLeave != nil {
 				go s.onAgentLeave(agentID)
 			}
 		}
 	}
+
+	// 记录已移除且超时未再发送心跳的旧Agent视为已退出，不再保留标记
+	for agentID, old := range s.superseded {
+		if _, kept := s.agents[agentID]; !kept && now.Sub(old.lastSeen) > s.agentTimeout {
+			delete(s.superseded, agentID)
+		}
+	}
 }
 
 // ============================================
@@ -163,15 +263,68 @@ func (s *Server) checkAgentTimeout() {
 // Register Agent注册
 // 处理Agent注册请求并返回集群配置
 func (s *Server) Register(ctx context.Context, req *pb.AgentInfo) (*pb.RegisterResponse, error) {
+	logger := requestid.Logger(ctx)
+
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	// 同一主机已有Agent，通常是Agent重启后使用了新的ID
+	for agentID, state := range s.agents {
+		if agentID == req.AgentId || state.Info.HostId != req.HostId {
+			continue
+		}
+		if !state.Online {
+			// 已离线的旧记录不影响注册，replace策略下一并清理
+			if s.duplicatePolicy == DuplicateAgentReplace {
+				delete(s.agents, agentID)
+			}
+			continue
+		}
+
+		logger.WithFields(log.Fields{
+			"host_id": req.HostId, "old_agent": agentID, "new_agent": req.AgentId,
+			"policy": s.duplicatePolicy,
+		}).Warn("Duplicate agent registration")
+
+		switch s.duplicatePolicy {
+		case DuplicateAgentReject:
+			return &pb.RegisterResponse{
+				Code:    1,
+				Message: fmt.Sprintf("agent %s already registered for host %s", agentID, req.HostId),
+			}, nil
+		case DuplicateAgentOffline:
+			state.Online = false
+		default:
+			delete(s.agents, agentID)
+		}
+
+		// 旧Agent可能仍在运行，标记后其心跳不再使其上线，并清理其上报的工作负载
+		s.superseded[agentID] = &supersededAgent{by: req.AgentId, lastSeen: time.Now()}
+		removed := s.cache.ReplaceAgentWorkloads(agentID, nil)
+		logger.WithFields(log.Fields{"old_agent": agentID, "removed": removed}).Info("Superseded agent workloads removed")
+
+		if s.onAgentLeave != nil {
+			go s.onAgentLeave(agentID)
+		}
+	}
+
+	delete(s.superseded, req.AgentId)
 	s.agents[req.AgentId] = &AgentState{
 		Info:     req,
 		LastSeen: time.Now(),
 		Online:   true,
 	}
 
+	// 按Agent上报的完整列表清理上次会话遗留的工作负载
+	if req.FullSync {
+		removed := s.cache.ReplaceAgentWorkloadsFromProto(req.AgentId, req.Workloads)
+		logger.WithFields(log.Fields{
+			"agent_id": req.AgentId, "workloads": len(req.Workloads), "removed": removed,
+		}).Info("Agent workloads synced")
+	}
+	// 注册信息随Agent状态长期保存，不保留工作负载列表
+	req.Workloads = nil
+
 	if s.onAgentJoin != nil {
 		go s.onAgentJoin(req.AgentId, req.HostId)
 	}
@@ -181,6 +334,8 @@ func (s *Server) Register(ctx context.Context, req *pb.AgentInfo) (*pb.RegisterR
 		Message:        "registered",
 		ClusterId:      "micro-segment-cluster",
 		ReportInterval: 5,
+
+		HeartbeatTimeout: uint32(s.agentTimeout / time.Second),
 	}, nil
 }
 
@@ -190,24 +345,40 @@ func (s *Server) Heartbeat(ctx context.Context, req *pb.HeartbeatRequest) (*pb.H
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	resp := &pb.HeartbeatResponse{
+		Code:      0,
+		Timestamp: uint64(time.Now().Unix()),
+	}
+	if old, ok := s.superseded[req.AgentId]; ok {
+		old.lastSeen = time.Now()
+		resp.Code = 1
+		requestid.Logger(ctx).WithFields(log.Fields{
+			"agent_id": req.AgentId, "new_agent": old.by,
+		}).Warn("Heartbeat from superseded agent")
+		return resp, nil
+	}
 	if state, ok := s.agents[req.AgentId]; ok {
 		state.LastSeen = time.Now()
 		state.Online = true
 		state.Stats = req.Stats
+		state.Captures = req.CapturedContainers
+
+		// 记录上一批命令的执行结果并下发新的命令
+		s.applyCommandResults(req.AgentId, req.Results)
+		resp.Commands = s.takeCommands(req.AgentId)
 	}
 
-	return &pb.HeartbeatResponse{
-		Code:      0,
-		Timestamp: uint64(time.Now().Unix()),
-	}, nil
+	return resp, nil
 }
 
 // ReportConnections 上报连接
 // 接收Agent上报的网络连接数据并更新缓存
 func (s *Server) ReportConnections(ctx context.Context, req *pb.ConnectionReport) (*pb.ReportResponse, error) {
+	s.reportSizes.observe(len(req.Connections))
+
 	// 处理连接上报
 	for _, conn := range req.Connections {
-		s.cache.UpdateConnectionFromProto(conn)
+		s.cache.UpdateAgentConnection(req.AgentId, conn)
 	}
 
 	return &pb.ReportResponse{
@@ -217,11 +388,19 @@ func (s *Server) ReportConnections(ctx context.Context, req *pb.ConnectionReport
 	}, nil
 }
 
+// ReportSizeHistogram 获取连接上报批量大小直方图
+// 各桶为每次ReportConnections携带的连接数落在该区间的次数
+func (s *Server) ReportSizeHistogram() []controller.HistogramBucket {
+	return s.reportSizes.snapshot()
+}
+
 // ReportThreats 上报威胁日志
 // 接收Agent上报的安全威胁检测结果
 func (s *Server) ReportThreats(ctx context.Context, req *pb.ThreatReport) (*pb.ReportResponse, error) {
 	// 处理威胁日志
-	// TODO: 存储威胁日志
+	for _, threat := range req.Threats {
+		s.cache.AddThreatFromProto(req.AgentId, threat)
+	}
 
 	return &pb.ReportResponse{
 		Code:    0,
@@ -234,7 +413,12 @@ func (s *Server) ReportThreats(ctx context.Context, req *pb.ThreatReport) (*pb.R
 func (s *Server) ReportWorkload(ctx context.Context, req *pb.WorkloadEvent) (*pb.ReportResponse, error) {
 	switch req.EventType {
 	case "add", "update":
-		s.cache.UpdateWorkloadFromProto(req.Workload)
+		if err := s.cache.UpdateAgentWorkloadFromProto(req.AgentId, req.Workload); err != nil {
+			return &pb.ReportResponse{
+				Code:    1,
+				Message: err.Error(),
+			}, nil
+		}
 	case "delete":
 		if req.Workload != nil {
 			s.cache.DeleteWorkload(req.Workload.Id)
@@ -247,45 +431,166 @@ func (s *Server) ReportWorkload(ctx context.Context, req *pb.WorkloadEvent) (*pb
 	}, nil
 }
 
+// ReportHost 上报主机信息
+// 更新主机缓存
+func (s *Server) ReportHost(ctx context.Context, req *pb.HostReport) (*pb.ReportResponse, error) {
+	if err := s.cache.UpdateHostFromProto(req.Host); err != nil {
+		return &pb.ReportResponse{
+			Code:    1,
+			Message: err.Error(),
+		}, nil
+	}
+
+	return &pb.ReportResponse{
+		Code:    0,
+		Message: "ok",
+	}, nil
+}
+
 // GetPolicies 获取策略
-// 返回指定工作负载的网络策略规则列表
+// 返回指定工作负载的网络策略规则列表，使用策略引擎预编译的结果
+// 指定Agent时附带其工作负载生效的策略模式
 func (s *Server) GetPolicies(ctx context.Context, req *pb.PolicyRequest) (*pb.PolicyList, error) {
-	rules := s.policy.ListRules()
-
-	pbRules := make([]*pb.PolicyRule, 0, len(rules))
-	for _, rule := range rules {
-		pbRules = append(pbRules, &pb.PolicyRule{
-			Id:       rule.ID,
-			From:     rule.From,
-			To:       rule.To,
-			Ports:    rule.Ports,
-			Action:   actionToProto(rule.Action),
-			Priority: rule.Priority,
-			Disable:  rule.Disable,
-			Comment:  rule.Comment,
-		})
+	var list *pb.PolicyList
+	if len(req.WorkloadIds) == 0 {
+		list = s.policy.CompiledPolicies()
+	} else {
+		groups := s.cache.GetWorkloadGroups(req.WorkloadIds)
+		list = s.policy.CompiledPoliciesForGroups(groups)
 	}
+	return s.withWorkloadModes(req.AgentId, list), nil
+}
 
-	return &pb.PolicyList{
-		Rules: pbRules,
-	}, nil
+// withWorkloadModes 为Agent附带工作负载策略模式
+// 编译结果被共享复用，有模式时返回引用相同规则的新列表
+func (s *Server) withWorkloadModes(agentID string, list *pb.PolicyList) *pb.PolicyList {
+	if agentID == "" {
+		return list
+	}
+	modes := s.cache.AgentWorkloadModes(agentID)
+	if len(modes) == 0 {
+		return list
+	}
+	return &pb.PolicyList{Rules: list.Rules, WorkloadModes: modes}
 }
 
-// actionToProto 转换动作到proto
-// 将策略动作字符串转换为protobuf枚举值
-func actionToProto(action string) uint32 {
-	switch action {
-	case "open":
-		return 0
-	case "allow":
-		return 1
-	case "deny":
-		return 2
-	case "violate":
-		return 3
-	default:
-		return 3
+// WatchPolicies 订阅策略
+// 订阅时推送当前规则集，之后每次规则变更或请求重新同步时推送新的规则集，直到Agent断开或服务器停止
+func (s *Server) WatchPolicies(req *pb.PolicyRequest, stream pb.ControllerService_WatchPoliciesServer) error {
+	s.mutex.RLock()
+	stopCh := s.stopCh
+	s.mutex.RUnlock()
+
+	logger := requestid.Logger(stream.Context()).WithField("agent_id", req.AgentId)
+	logger.Info("Agent subscribed to policies")
+	defer logger.Info("Agent policy subscription ended")
+
+	for {
+		changed := s.policy.Changed()
+		resync := s.resyncChan(req.AgentId)
+
+		list, err := s.GetPolicies(stream.Context(), req)
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(list); err != nil {
+			return err
+		}
+
+		select {
+		case <-changed:
+		case <-resync:
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-stopCh:
+			return nil
+		}
+	}
+}
+
+// ResyncAgent 强制向指定Agent重新推送完整策略
+// Agent不存在或已离线时返回错误
+func (s *Server) ResyncAgent(agentID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	state, ok := s.agents[agentID]
+	if !ok || !state.Online {
+		return fmt.Errorf("agent not found or offline: %s", agentID)
+	}
+
+	if ch, ok := s.resyncChs[agentID]; ok {
+		close(ch)
+		delete(s.resyncChs, agentID)
+	}
+	log.WithField("agent_id", agentID).Info("Agent policy resync requested")
+	return nil
+}
+
+// resyncChan 获取Agent当前的重新同步信号
+func (s *Server) resyncChan(agentID string) <-chan struct{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ch, ok := s.resyncChs[agentID]
+	if !ok {
+		ch = make(chan struct{})
+		s.resyncChs[agentID] = ch
+	}
+	return ch
+}
+
+// ListAgentStates 列出所有Agent状态的快照
+// 在锁内复制，调用方可在Register/Heartbeat并发修改时安全使用，按Agent ID排序
+func (s *Server) ListAgentStates() []controller.AgentStateSnapshot {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	result := make([]controller.AgentStateSnapshot, 0, len(s.agents))
+	for id, state := range s.agents {
+		snap := controller.AgentStateSnapshot{
+			ID:       id,
+			Online:   state.Online,
+			LastSeen: state.LastSeen,
+		}
+		if info := state.Info; info != nil {
+			snap.HostID = info.HostId
+			snap.HostName = info.HostName
+			snap.Version = info.Version
+			snap.Platform = info.Platform
+		}
+		if st := state.Stats; st != nil {
+			snap.Stats = &controller.AgentStats{
+				WorkloadCount:   st.WorkloadCount,
+				ConnectionCount: st.ConnectionCount,
+				PolicyCount:     st.PolicyCount,
+				MemoryUsage:     st.MemoryUsage,
+				CPUUsage:        st.CpuUsage,
+			}
+		}
+		result = append(result, snap)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+// GetAgentCaptures 获取Agent最近一次心跳上报的正在捕获的容器，Agent不存在返回false
+func (s *Server) GetAgentCaptures(agentID string) (controller.AgentCaptures, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	state, ok := s.agents[agentID]
+	if !ok {
+		return controller.AgentCaptures{}, false
 	}
+	containers := make([]string, len(state.Captures))
+	copy(containers, state.Captures)
+	return controller.AgentCaptures{
+		AgentID:    agentID,
+		Online:     state.Online,
+		Containers: containers,
+		ReportedAt: state.LastSeen,
+	}, true
 }
 
 // GetAgentCount 获取Agent数量
@@ -0,0 +1,672 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+
+	pb "github.com/micro-segment/api/proto"
+	"github.com/micro-segment/internal/agent"
+	agentgrpc "github.com/micro-segment/internal/agent/grpc"
+	controller "github.com/micro-segment/internal/controller"
+	"github.com/micro-segment/internal/controller/cache"
+	"github.com/micro-segment/internal/controller/policy"
+	"github.com/micro-segment/internal/controller/requestid"
+)
+
+// newTestServer 创建不监听端口的测试服务器，返回Agent离开事件通道
+func newTestServer(dup DuplicateAgentPolicy) (*Server, chan string) {
+	s := NewServer(0, cache.NewCache(), policy.NewEngine())
+	s.SetDuplicateAgentPolicy(dup)
+	left := make(chan string, 4)
+	s.SetOnAgentLeave(func(agentID string) { left <- agentID })
+	return s, left
+}
+
+// waitLeave 等待Agent离开回调
+func waitLeave(t *testing.T, left chan string) string {
+	select {
+	case id := <-left:
+		return id
+	case <-time.After(time.Second):
+		t.Fatalf("onAgentLeave not fired")
+		return ""
+	}
+}
+
+func register(t *testing.T, s *Server, agentID, hostID string) *pb.RegisterResponse {
+	resp, err := s.Register(context.Background(), &pb.AgentInfo{AgentId: agentID, HostId: hostID})
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	return resp
+}
+
+func TestDuplicateAgentReplace(t *testing.T) {
+	s, left := newTestServer(DuplicateAgentReplace)
+
+	register(t, s, "a1", "host1")
+	register(t, s, "b1", "host2")
+	if resp := register(t, s, "a2", "host1"); resp.Code != 0 {
+		t.Fatalf("Registration rejected: %s", resp.Message)
+	}
+
+	if id := waitLeave(t, left); id != "a1" {
+		t.Errorf("Unexpected leave: %s", id)
+	}
+	if _, ok := s.agents["a1"]; ok {
+		t.Errorf("Old agent not removed")
+	}
+	if s.GetAgentCount() != 2 {
+		t.Errorf("Unexpected agent count: %d", s.GetAgentCount())
+	}
+
+	// 同一Agent重复注册不视为冲突
+	register(t, s, "a2", "host1")
+	select {
+	case id := <-left:
+		t.Errorf("Unexpected leave: %s", id)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestDuplicateAgentReject(t *testing.T) {
+	s, left := newTestServer(DuplicateAgentReject)
+
+	register(t, s, "a1", "host1")
+	if resp := register(t, s, "a2", "host1"); resp.Code == 0 {
+		t.Fatalf("Duplicate registration accepted")
+	}
+	if _, ok := s.agents["a2"]; ok {
+		t.Errorf("Rejected agent stored")
+	}
+	if !s.agents["a1"].Online {
+		t.Errorf("Existing agent affected")
+	}
+
+	// 旧Agent离线后允许注册
+	s.agents["a1"].Online = false
+	if resp := register(t, s, "a2", "host1"); resp.Code != 0 {
+		t.Errorf("Registration rejected after old agent offline: %s", resp.Message)
+	}
+	select {
+	case id := <-left:
+		t.Errorf("Unexpected leave: %s", id)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestDuplicateAgentOffline(t *testing.T) {
+	s, left := newTestServer(DuplicateAgentOffline)
+
+	register(t, s, "a1", "host1")
+	if resp := register(t, s, "a2", "host1"); resp.Code != 0 {
+		t.Fatalf("Registration rejected: %s", resp.Message)
+	}
+
+	if id := waitLeave(t, left); id != "a1" {
+		t.Errorf("Unexpected leave: %s", id)
+	}
+	old, ok := s.agents["a1"]
+	if !ok || old.Online {
+		t.Errorf("Old agent not kept offline: %+v", old)
+	}
+	if !s.agents["a2"].Online {
+		t.Errorf("New agent not online")
+	}
+}
+
+func TestSupersededAgentHeartbeat(t *testing.T) {
+	for _, dup := range []DuplicateAgentPolicy{DuplicateAgentReplace, DuplicateAgentOffline} {
+		s, left := newTestServer(dup)
+		register(t, s, "a1", "host1")
+		s.cache.UpdateAgentWorkloadFromProto("a1", &pb.Workload{Id: "wl-old", Name: "old"})
+		s.cache.UpdateAgentWorkloadFromProto("b1", &pb.Workload{Id: "wl-other", Name: "other"})
+		register(t, s, "a2", "host1")
+		waitLeave(t, left)
+
+		// 旧Agent的工作负载被清理，其他Agent的不受影响
+		if s.cache.GetWorkload("wl-old") != nil {
+			t.Errorf("%s: superseded agent workload not removed", dup)
+		}
+		if s.cache.GetWorkload("wl-other") == nil {
+			t.Errorf("%s: other agent workload removed", dup)
+		}
+
+		// 仍在运行的旧Agent发送心跳不会重新上线
+		resp, err := s.Heartbeat(context.Background(), &pb.HeartbeatRequest{AgentId: "a1"})
+		if err != nil || resp.Code == 0 {
+			t.Errorf("%s: heartbeat from superseded agent accepted: %v %v", dup, resp, err)
+		}
+		if old, ok := s.agents["a1"]; ok && old.Online {
+			t.Errorf("%s: superseded agent back online", dup)
+		}
+		if resp, _ := s.Heartbeat(context.Background(), &pb.HeartbeatRequest{AgentId: "a2"}); resp.Code != 0 {
+			t.Errorf("%s: heartbeat from new agent rejected", dup)
+		}
+
+		// 记录已移除的旧Agent超时未发送心跳后不再保留标记
+		s.superseded["a1"].lastSeen = time.Now().Add(-2 * s.agentTimeout)
+		s.checkAgentTimeout()
+		_, marked := s.superseded["a1"]
+		if _, kept := s.agents["a1"]; marked != kept {
+			t.Errorf("%s: unexpected superseded mark: marked=%v kept=%v", dup, marked, kept)
+		}
+	}
+}
+
+func TestParseDuplicateAgentPolicy(t *testing.T) {
+	for _, v := range []string{"replace", "reject", "offline"} {
+		if p, err := ParseDuplicateAgentPolicy(v); err != nil || string(p) != v {
+			t.Errorf("Parse %s: %v %v", v, p, err)
+		}
+	}
+	if _, err := ParseDuplicateAgentPolicy("ignore"); err == nil {
+		t.Errorf("Invalid policy accepted")
+	}
+}
+
+func TestWatchPoliciesPush(t *testing.T) {
+	p := policy.NewEngine()
+	p.AddRule(&controller.PolicyRule{ID: 1, From: "web", To: "db", Action: "allow", Priority: 1})
+
+	s := NewServer(0, cache.NewCache(), p)
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer s.Stop()
+	addr := fmt.Sprintf("127.0.0.1:%d", s.listener.Addr().(*net.TCPAddr).Port)
+
+	pushed := make(chan []*agent.PolicyRule, 4)
+	client := agentgrpc.NewClient(addr, "agent1", "host1", "node-1", "test")
+	client.SetOnPolicies(func(rules []*agent.PolicyRule) { pushed <- rules })
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer client.Disconnect()
+	if err := client.Register(); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	recv := func() []*agent.PolicyRule {
+		select {
+		case rules := <-pushed:
+			return rules
+		case <-time.After(2 * time.Second):
+			t.Fatalf("No policy push received")
+			return nil
+		}
+	}
+
+	// 订阅时推送当前规则
+	if rules := recv(); len(rules) != 1 || rules[0].Action != agent.PolicyActionAllow {
+		t.Fatalf("Unexpected initial rules: %+v", rules)
+	}
+
+	// 修改规则后推送新规则集
+	p.UpdateRule(&controller.PolicyRule{ID: 1, From: "web", To: "db", Action: "deny", Priority: 1})
+	if rules := recv(); len(rules) != 1 || rules[0].Action != agent.PolicyActionDeny {
+		t.Errorf("Unexpected rules after update: %+v", rules)
+	}
+
+	p.AddRule(&controller.PolicyRule{ID: 2, From: "any", To: "web", Action: "allow", Priority: 2})
+	if rules := recv(); len(rules) != 2 {
+		t.Errorf("Unexpected rules after add: %+v", rules)
+	}
+
+	p.DeleteRule(1)
+	if rules := recv(); len(rules) != 1 || rules[0].ID != 2 {
+		t.Errorf("Unexpected rules after delete: %+v", rules)
+	}
+}
+
+// fakePolicyStream 记录推送内容的策略订阅流
+type fakePolicyStream struct {
+	pb.ControllerService_WatchPoliciesServer
+	ctx  context.Context
+	sent chan *pb.PolicyList
+}
+
+func (f *fakePolicyStream) Context() context.Context { return f.ctx }
+
+func (f *fakePolicyStream) Send(list *pb.PolicyList) error {
+	f.sent <- list
+	return nil
+}
+
+func TestResyncAgent(t *testing.T) {
+	s, _ := newTestServer(DuplicateAgentReplace)
+	s.policy.AddRule(&controller.PolicyRule{ID: 1, From: "web", To: "db", Action: "allow", Priority: 1})
+	register(t, s, "agent1", "host1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := &fakePolicyStream{ctx: ctx, sent: make(chan *pb.PolicyList, 4)}
+	done := make(chan error, 1)
+	go func() { done <- s.WatchPolicies(&pb.PolicyRequest{AgentId: "agent1"}, stream) }()
+
+	recv := func() *pb.PolicyList {
+		select {
+		case list := <-stream.sent:
+			return list
+		case <-time.After(time.Second):
+			t.Fatalf("No policy push received")
+			return nil
+		}
+	}
+	recv()
+
+	if err := s.ResyncAgent("agent1"); err != nil {
+		t.Fatalf("Resync failed: %v", err)
+	}
+	if list := recv(); len(list.Rules) != 1 {
+		t.Errorf("Unexpected resync push: %v", list.Rules)
+	}
+
+	// 未知或离线Agent
+	if err := s.ResyncAgent("unknown"); err == nil {
+		t.Errorf("Expected error for unknown agent")
+	}
+	s.agents["agent1"].Online = false
+	if err := s.ResyncAgent("agent1"); err == nil {
+		t.Errorf("Expected error for offline agent")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("WatchPolicies did not return after cancel")
+	}
+}
+
+func TestReportHost(t *testing.T) {
+	c := cache.NewCache()
+	s := NewServer(0, c, policy.NewEngine())
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer s.Stop()
+	addr := fmt.Sprintf("127.0.0.1:%d", s.listener.Addr().(*net.TCPAddr).Port)
+
+	client := agentgrpc.NewClient(addr, "agent1", "host1", "node-1", "test")
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer client.Disconnect()
+
+	err := client.ReportHost(&agent.Host{
+		ID:       "host1",
+		Name:     "node-1",
+		Platform: "linux",
+		Ifaces: map[string][]agent.IPAddr{
+			"eth0": {{IP: net.ParseIP("10.0.2.15"), Scope: "global"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("ReportHost failed: %v", err)
+	}
+
+	host := c.GetHost("host1")
+	if host == nil || host.Name != "node-1" || host.Platform != "linux" {
+		t.Fatalf("Unexpected host: %+v", host)
+	}
+	if addrs := host.Ifaces["eth0"]; len(addrs) != 1 || addrs[0].IP.String() != "10.0.2.15" {
+		t.Errorf("Unexpected host addresses: %+v", host.Ifaces)
+	}
+
+	// 缺少主机ID被拒绝
+	if err := client.ReportHost(&agent.Host{Name: "node-2"}); err == nil {
+		t.Errorf("Expected error for host without id")
+	}
+	if n := len(c.ListHosts()); n != 1 {
+		t.Errorf("Unexpected host count: %d", n)
+	}
+}
+
+func TestRegisterWorkloadSync(t *testing.T) {
+	c := cache.NewCache()
+	s := NewServer(0, c, policy.NewEngine())
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer s.Stop()
+	addr := fmt.Sprintf("127.0.0.1:%d", s.listener.Addr().(*net.TCPAddr).Port)
+
+	// 上次会话遗留的工作负载
+	c.UpdateAgentWorkloadFromProto("agent1", &pb.Workload{Id: "wl-old", Name: "old"})
+	c.UpdateAgentWorkloadFromProto("agent1", &pb.Workload{Id: "wl-kept", Name: "kept"})
+	c.UpdateAgentWorkloadFromProto("agent2", &pb.Workload{Id: "wl-other", Name: "other"})
+
+	client := agentgrpc.NewClient(addr, "agent1", "host1", "node-1", "test")
+	client.SetWorkloadSource(func() []*agent.Workload {
+		return []*agent.Workload{{ID: "wl-kept", Name: "kept"}, {ID: "wl-new", Name: "new"}}
+	})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer client.Disconnect()
+	if err := client.Register(); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	if c.GetWorkload("wl-old") != nil {
+		t.Errorf("Stale workload not removed")
+	}
+	for _, id := range []string{"wl-kept", "wl-new", "wl-other"} {
+		if c.GetWorkload(id) == nil {
+			t.Errorf("Workload %s missing", id)
+		}
+	}
+	if wl := c.GetWorkload("wl-new"); wl != nil && wl.AgentID != "agent1" {
+		t.Errorf("Unexpected agent: %s", wl.AgentID)
+	}
+}
+
+func TestReportSizeHistogram(t *testing.T) {
+	s, _ := newTestServer(DuplicateAgentReplace)
+
+	for _, n := range []int{0, 1, 3, 4, 5, 100, 8192, 9000} {
+		conns := make([]*pb.Connection, n)
+		for i := range conns {
+			conns[i] = &pb.Connection{ClientWl: "a", ServerWl: fmt.Sprint(i), ClientIp: []byte{10, 0, 0, 1}, ServerIp: []byte{10, 0, 0, 2}}
+		}
+		s.ReportConnections(context.Background(), &pb.ConnectionReport{AgentId: "agent1", Connections: conns})
+	}
+
+	want := map[string]uint64{"0": 1, "1": 1, "4": 2, "16": 1, "256": 1, "8192": 1, "+Inf": 1}
+	buckets := s.ReportSizeHistogram()
+	if len(buckets) != len(reportSizeBounds)+1 {
+		t.Fatalf("Unexpected bucket count: %d", len(buckets))
+	}
+	for _, b := range buckets {
+		if b.Count != want[b.LE] {
+			t.Errorf("Bucket le=%s: got %d, want %d", b.LE, b.Count, want[b.LE])
+		}
+	}
+}
+
+func TestListAgentStatesConcurrent(t *testing.T) {
+	s, _ := newTestServer(DuplicateAgentReplace)
+	for i := 0; i < 4; i++ {
+		register(t, s, fmt.Sprintf("agent%d", i), fmt.Sprintf("host%d", i))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for n := 0; n < 500; n++ {
+			id := fmt.Sprintf("agent%d", n%4)
+			s.Heartbeat(context.Background(), &pb.HeartbeatRequest{
+				AgentId: id,
+				Stats:   &pb.AgentStats{WorkloadCount: uint32(n)},
+			})
+			if n%50 == 0 {
+				s.Register(context.Background(), &pb.AgentInfo{AgentId: id, HostId: fmt.Sprintf("host%d", n%4)})
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			states := s.ListAgentStates()
+			if len(states) != 4 || states[0].ID != "agent0" || states[3].HostID != "host3" {
+				t.Fatalf("Unexpected states: %+v", states)
+			}
+			for _, st := range states {
+				if !st.Online || st.Stats == nil {
+					t.Errorf("Unexpected state: %+v", st)
+				}
+			}
+			return
+		default:
+		}
+
+		// 修改快照不影响服务器状态
+		for _, st := range s.ListAgentStates() {
+			st.Online = false
+			if st.Stats != nil {
+				st.Stats.WorkloadCount = 0
+			}
+		}
+	}
+}
+
+func TestHeartbeatCaptures(t *testing.T) {
+	s, _ := newTestServer(DuplicateAgentReplace)
+	register(t, s, "agent1", "host1")
+
+	if _, ok := s.GetAgentCaptures("nope"); ok {
+		t.Errorf("Captures returned for unknown agent")
+	}
+	s.Heartbeat(context.Background(), &pb.HeartbeatRequest{AgentId: "agent1", CapturedContainers: []string{"c1", "c2"}})
+	got, ok := s.GetAgentCaptures("agent1")
+	if !ok || !got.Online || len(got.Containers) != 2 || got.Containers[0] != "c1" || got.ReportedAt.IsZero() {
+		t.Fatalf("Unexpected captures: %+v %v", got, ok)
+	}
+
+	// 返回副本，后续心跳覆盖为最新列表
+	got.Containers[0] = "changed"
+	s.Heartbeat(context.Background(), &pb.HeartbeatRequest{AgentId: "agent1"})
+	if got, _ := s.GetAgentCaptures("agent1"); len(got.Containers) != 0 {
+		t.Errorf("Captures not replaced: %v", got.Containers)
+	}
+}
+
+func TestAgentCommandQueue(t *testing.T) {
+	s, _ := newTestServer(DuplicateAgentReplace)
+	register(t, s, "agent1", "host1")
+	register(t, s, "agent2", "host2")
+
+	heartbeat := func(agentID string, results ...*pb.AgentCommandResult) []*pb.AgentCommand {
+		resp, err := s.Heartbeat(context.Background(), &pb.HeartbeatRequest{AgentId: agentID, Results: results})
+		if err != nil {
+			t.Fatalf("Heartbeat failed: %v", err)
+		}
+		return resp.Commands
+	}
+
+	capture, err := s.QueueAgentCommand("agent1", controller.AgentCommandForceCapture, "c1")
+	if err != nil {
+		t.Fatalf("Queue failed: %v", err)
+	}
+	debug, _ := s.QueueAgentCommand("agent1", controller.AgentCommandGetDebug, "")
+	if capture.Status != controller.AgentCommandPending || capture.ID == debug.ID {
+		t.Fatalf("Unexpected commands: %+v %+v", capture, debug)
+	}
+
+	// 只下发给目标Agent，按排队顺序且只下发一次
+	if cmds := heartbeat("agent2"); len(cmds) != 0 {
+		t.Errorf("Commands sent to wrong agent: %v", cmds)
+	}
+	cmds := heartbeat("agent1")
+	if len(cmds) != 2 || cmds[0].Id != capture.ID || cmds[0].ContainerId != "c1" || cmds[1].Type != controller.AgentCommandGetDebug {
+		t.Fatalf("Unexpected commands: %v", cmds)
+	}
+	if cmds := heartbeat("agent1"); len(cmds) != 0 {
+		t.Errorf("Commands sent twice: %v", cmds)
+	}
+	if list := s.ListAgentCommands("agent1"); len(list) != 2 || list[0].Status != controller.AgentCommandSent {
+		t.Fatalf("Unexpected command list: %+v", list)
+	}
+
+	// 结果随后续心跳回传，忽略其他Agent回传的结果
+	heartbeat("agent2", &pb.AgentCommandResult{Id: debug.ID, Success: true})
+	heartbeat("agent1",
+		&pb.AgentCommandResult{Id: capture.ID, Error: "no pid"},
+		&pb.AgentCommandResult{Id: debug.ID, Success: true, Output: "{}"},
+	)
+	list := s.ListAgentCommands("agent1")
+	if list[0].Status != controller.AgentCommandFailed || list[0].Error != "no pid" || list[0].DoneAt.IsZero() {
+		t.Errorf("Unexpected failed command: %+v", list[0])
+	}
+	if list[1].Status != controller.AgentCommandDone || list[1].Output != "{}" {
+		t.Errorf("Unexpected done command: %+v", list[1])
+	}
+	if list := s.ListAgentCommands("agent2"); len(list) != 0 {
+		t.Errorf("Unexpected agent2 commands: %+v", list)
+	}
+}
+
+func TestAgentCommandValidation(t *testing.T) {
+	s, _ := newTestServer(DuplicateAgentReplace)
+	register(t, s, "agent1", "host1")
+
+	for _, tc := range []struct {
+		agentID, cmdType, containerID string
+		want                          error
+	}{
+		{"agent1", "reboot", "", ErrInvalidCommand},
+		{"agent1", controller.AgentCommandForceStop, "", ErrInvalidCommand},
+		{"agent1", controller.AgentCommandPacketSample, "", ErrInvalidCommand},
+		{"unknown", controller.AgentCommandGetDebug, "", ErrAgentUnavailable},
+	} {
+		if _, err := s.QueueAgentCommand(tc.agentID, tc.cmdType, tc.containerID); !errors.Is(err, tc.want) {
+			t.Errorf("Queue(%s, %s) = %v, want %v", tc.agentID, tc.cmdType, err, tc.want)
+		}
+	}
+
+	s.agents["agent1"].Online = false
+	if _, err := s.QueueAgentCommand("agent1", controller.AgentCommandGetDebug, ""); !errors.Is(err, ErrAgentUnavailable) {
+		t.Errorf("Expected error for offline agent, got %v", err)
+	}
+}
+
+func TestHeartbeatTimeoutNegotiation(t *testing.T) {
+	s := NewServer(0, cache.NewCache(), policy.NewEngine())
+	if err := s.SetAgentTimeout(500 * time.Millisecond); err == nil {
+		t.Errorf("Expected error for sub-second timeout")
+	}
+	if err := s.SetAgentTimeout(6 * time.Second); err != nil {
+		t.Fatalf("SetAgentTimeout failed: %v", err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer s.Stop()
+	addr := fmt.Sprintf("127.0.0.1:%d", s.listener.Addr().(*net.TCPAddr).Port)
+
+	if resp := register(t, s, "agent0", "host0"); resp.HeartbeatTimeout != 6 {
+		t.Errorf("Unexpected heartbeat timeout: %d", resp.HeartbeatTimeout)
+	}
+
+	// 配置值超过超时的安全比例时缩短，否则保持不变
+	for _, tc := range []struct {
+		configured, want time.Duration
+	}{
+		{10 * time.Second, 2 * time.Second},
+		{time.Second, time.Second},
+	} {
+		client := agentgrpc.NewClient(addr, "agent-"+tc.configured.String(), "host-"+tc.configured.String(), "node", "test")
+		client.SetHeartbeatInterval(tc.configured)
+		if err := client.Connect(); err != nil {
+			t.Fatalf("Connect failed: %v", err)
+		}
+		if err := client.Register(); err != nil {
+			t.Fatalf("Register failed: %v", err)
+		}
+		got := client.HeartbeatInterval()
+		client.Disconnect()
+		if got != tc.want || got >= s.agentTimeout {
+			t.Errorf("Heartbeat interval for %v = %v, want %v", tc.configured, got, tc.want)
+		}
+	}
+}
+
+func TestWatchPoliciesWorkloadModes(t *testing.T) {
+	c := cache.NewCache()
+	s := NewServer(0, c, policy.NewEngine())
+	c.SetOnModeChange(func(agentID string) { s.ResyncAgent(agentID) })
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer s.Stop()
+	addr := fmt.Sprintf("127.0.0.1:%d", s.listener.Addr().(*net.TCPAddr).Port)
+
+	pushed := make(chan map[string]agent.PolicyMode, 4)
+	client := agentgrpc.NewClient(addr, "agent1", "host1", "node-1", "test")
+	client.SetOnPolicies(func([]*agent.PolicyRule) {})
+	client.SetOnWorkloadModes(func(modes map[string]agent.PolicyMode) { pushed <- modes })
+	client.SetWorkloadSource(func() []*agent.Workload {
+		return []*agent.Workload{{ID: "w1", PolicyMode: agent.PolicyModeMonitor}}
+	})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer client.Disconnect()
+	if err := client.Register(); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	recv := func() map[string]agent.PolicyMode {
+		select {
+		case modes := <-pushed:
+			return modes
+		case <-time.After(2 * time.Second):
+			t.Fatalf("No policy push received")
+			return nil
+		}
+	}
+	if modes := recv(); modes["w1"] != agent.PolicyModeMonitor {
+		t.Fatalf("Unexpected initial modes: %v", modes)
+	}
+
+	// 组模式变化推送到成员所属Agent
+	c.AddGroup(&controller.Group{Name: "web"})
+	c.AddGroupMember("web", "w1")
+	c.ApplyGroupMode("web", controller.PolicyModeProtect)
+	if modes := recv(); modes["w1"] != agent.PolicyModeProtect {
+		t.Errorf("Unexpected modes after group change: %v", modes)
+	}
+}
+
+func TestRequestIDInterceptor(t *testing.T) {
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+
+	s := NewServer(0, cache.NewCache(), policy.NewEngine())
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer s.Stop()
+	addr := fmt.Sprintf("127.0.0.1:%d", s.listener.Addr().(*net.TCPAddr).Port)
+
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	client := pb.NewControllerServiceClient(conn)
+
+	// 携带的请求ID在响应头中回传，并出现在处理日志中
+	ctx := metadata.AppendToOutgoingContext(context.Background(), requestid.MetadataKey, "reg-1")
+	var header metadata.MD
+	if _, err := client.Register(ctx, &pb.AgentInfo{AgentId: "agent1", HostId: "host1", FullSync: true}, grpc.Header(&header)); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if ids := header.Get(requestid.MetadataKey); len(ids) != 1 || ids[0] != "reg-1" {
+		t.Errorf("Request id not echoed: %v", header)
+	}
+	entry := hook.LastEntry()
+	if entry == nil || entry.Message != "Agent workloads synced" || entry.Data[requestid.LogField] != "reg-1" {
+		t.Errorf("Handler log missing request id: %+v", entry)
+	}
+
+	// 未携带时生成新ID
+	header = nil
+	if _, err := client.Heartbeat(context.Background(), &pb.HeartbeatRequest{AgentId: "agent1"}, grpc.Header(&header)); err != nil {
+		t.Fatalf("Heartbeat failed: %v", err)
+	}
+	if ids := header.Get(requestid.MetadataKey); len(ids) != 1 || ids[0] == "" {
+		t.Errorf("Request id not generated: %v", header)
+	}
+}
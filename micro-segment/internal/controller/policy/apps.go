@@ -0,0 +1,120 @@
+// Package policy 应用协议名称与DP应用标识的映射
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	controller "github.com/micro-segment/internal/controller"
+)
+
+// ErrUnknownApp 规则引用了未知的应用名称
+var ErrUnknownApp = errors.New("unknown application")
+
+// appIDs 应用名称到DP应用标识，与dp/defs.h中的DPI_APP_*保持一致
+var appIDs = map[string]uint32{
+	"HTTP":          1001,
+	"SSL":           1002,
+	"SSH":           1003,
+	"DNS":           1004,
+	"DHCP":          1005,
+	"NTP":           1006,
+	"TFTP":          1007,
+	"ECHO":          1008,
+	"RTSP":          1009,
+	"SIP":           1010,
+	"MySQL":         2001,
+	"Redis":         2002,
+	"ZooKeeper":     2003,
+	"Cassandra":     2004,
+	"MongoDB":       2005,
+	"PostgreSQL":    2006,
+	"Kafka":         2007,
+	"Couchbase":     2008,
+	"WordPress":     2009,
+	"ActiveMQ":      2010,
+	"CouchDB":       2011,
+	"ElasticSearch": 2012,
+	"Memcached":     2013,
+	"RabbitMQ":      2014,
+	"Radius":        2015,
+	"VoltDB":        2016,
+	"Consul":        2017,
+	"Syslog":        2018,
+	"etcd":          2019,
+	"Spark":         2020,
+	"Apache":        2021,
+	"nginx":         2022,
+	"Jetty":         2023,
+	"NodeJS":        2024,
+	"Erlang":        2025,
+	"TNS":           2026,
+	"TDS":           2027,
+	"gRPC":          2028,
+}
+
+// appNames 应用标识到名称，按appIDs构建
+var appNames = func() map[uint32]string {
+	names := make(map[uint32]string, len(appIDs))
+	for name, id := range appIDs {
+		names[id] = name
+	}
+	return names
+}()
+
+// AppID 查询应用名称对应的标识，名称不区分大小写
+func AppID(name string) (uint32, bool) {
+	if id, ok := appIDs[name]; ok {
+		return id, true
+	}
+	for n, id := range appIDs {
+		if strings.EqualFold(n, name) {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// AppName 查询应用标识对应的名称，未知标识返回数字形式
+func AppName(id uint32) string {
+	if name, ok := appNames[id]; ok {
+		return name
+	}
+	return fmt.Sprintf("%d", id)
+}
+
+// resolveApps 将规则的应用名称解析合并到Applications
+// 解析后AppNames按Applications重新生成，便于展示
+func resolveApps(rule *controller.PolicyRule) error {
+	if len(rule.AppNames) == 0 && len(rule.Applications) == 0 {
+		return nil
+	}
+
+	ids := make(map[uint32]bool, len(rule.Applications)+len(rule.AppNames))
+	for _, id := range rule.Applications {
+		ids[id] = true
+	}
+	for _, name := range rule.AppNames {
+		id, ok := AppID(name)
+		if !ok {
+			return fmt.Errorf("%w: %q", ErrUnknownApp, name)
+		}
+		ids[id] = true
+	}
+
+	apps := make([]uint32, 0, len(ids))
+	for id := range ids {
+		apps = append(apps, id)
+	}
+	sort.Slice(apps, func(i, j int) bool { return apps[i] < apps[j] })
+
+	names := make([]string, len(apps))
+	for i, id := range apps {
+		names[i] = AppName(id)
+	}
+	rule.Applications = apps
+	rule.AppNames = names
+	return nil
+}
@@ -0,0 +1,36 @@
+// Package policy 策略匹配结果缓存
+package policy
+
+import (
+	"net"
+
+	controller "github.com/micro-segment/internal/controller"
+	"github.com/micro-segment/internal/lru"
+)
+
+// defaultMatchCacheSize 默认缓存的匹配元组数
+const defaultMatchCacheSize = 8192
+
+// matchKey 匹配缓存键，未提供IP时对应字段为零值
+type matchKey struct {
+	from, to           string
+	clientIP, serverIP [net.IPv6len]byte
+	port               uint16
+	proto              uint8
+	app                uint32
+}
+
+// matchResult 匹配结果
+type matchResult struct {
+	id     uint32
+	action controller.PolicyAction
+}
+
+// matchCache 按匹配元组缓存规则ID和动作的LRU
+// 规则、规则顺序、组策略模式或组禁用状态变化时需调用Reset清空
+type matchCache = lru.Cache[matchKey, matchResult]
+
+// newMatchCache 创建最多保存size条结果的匹配缓存
+func newMatchCache(size int) *matchCache {
+	return lru.New[matchKey, matchResult](size)
+}
@@ -3,14 +3,27 @@
 package policy
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	pb "github.com/micro-segment/api/proto"
 	controller "github.com/micro-segment/internal/controller"
 )
 
+// ErrUnknownGroup 严格模式下规则引用了不存在的组
+var ErrUnknownGroup = errors.New("unknown group")
+
+// ErrRuleNotFound 规则不存在
+var ErrRuleNotFound = errors.New("rule not found")
+
+// ErrInvalidRule 规则字段不合法
+var ErrInvalidRule = errors.New("invalid rule")
+
 // Engine 策略引擎
 type Engine struct {
 	mutex sync.RWMutex
@@ -21,32 +34,137 @@ type Engine struct {
 	// 规则顺序
 	ruleOrder []uint32
 
+	// 规则From/To中的CIDR/IP解析结果，规则变更时重建
+	ruleNets map[string]*net.IPNet
+
 	// 组策略模式
 	groupModes map[string]controller.PolicyMode
+
+	// 已禁用的组
+	disabledGroups map[string]bool
+
+	// 编译后的proto规则列表，规则变更时失效
+	compiled *pb.PolicyList
+	// 按组范围编译的规则子集，key为排序后的组名
+	compiledScoped map[string]*pb.PolicyList
+
+	// 规则变更通知，变更时关闭并替换为新通道
+	changeCh chan struct{}
+
+	// 上次取出后规则变更涉及的组
+	dirtyGroups map[string]bool
+
+	// 规则持久化存储，所有规则变更先写入存储
+	store RuleStore
+
+	// 组存在性检查，非nil时为严格模式，拒绝引用不存在组的规则
+	groupExists func(name string) bool
+
+	// 按匹配元组缓存的匹配结果，规则或组状态变化时清空
+	matches *matchCache
 }
 
 // NewEngine 创建策略引擎
+// 规则仅保存在内存中
 func NewEngine() *Engine {
+	return newEngine(NewMemoryStore())
+}
+
+// NewEngineWithStore 使用指定存储创建策略引擎
+// 从存储加载已有规则
+func NewEngineWithStore(store RuleStore) (*Engine, error) {
+	rules, err := store.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rules: %v", err)
+	}
+
+	e := newEngine(store)
+	for _, rule := range rules {
+		if err := validateAction(rule); err != nil {
+			return nil, fmt.Errorf("failed to load rule %d: %w", rule.ID, err)
+		}
+		e.rules[rule.ID] = rule
+	}
+	e.updateRuleOrder()
+	return e, nil
+}
+
+// newEngine 创建空策略引擎
+func newEngine(store RuleStore) *Engine {
 	return &Engine{
+		store:      store,
 		rules:      make(map[uint32]*controller.PolicyRule),
 		ruleOrder:  make([]uint32, 0),
 		groupModes: make(map[string]controller.PolicyMode),
+
+		disabledGroups: make(map[string]bool),
+		changeCh:       make(chan struct{}),
+		dirtyGroups:    make(map[string]bool),
+		matches:        newMatchCache(defaultMatchCacheSize),
 	}
 }
 
-// AddRule 添加规则
-func (e *Engine) AddRule(rule *controller.PolicyRule) error {
+// SetGroupValidator 设置组存在性检查，启用严格模式
+// 严格模式下新增或更新规则时From/To必须是已存在的组、any、CIDR或通配符，nil恢复宽松模式
+func (e *Engine) SetGroupValidator(exists func(name string) bool) {
 	e.mutex.Lock()
 	defer e.mutex.Unlock()
+	e.groupExists = exists
+}
 
+// groupValidator 获取当前的组存在性检查
+// 检查可能获取Cache的锁，调用方需在释放引擎锁后执行，避免与持有Cache锁匹配连接的重新评估死锁
+func (e *Engine) groupValidator() func(name string) bool {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.groupExists
+}
+
+// validateRuleGroups 检查规则引用的组是否存在，exists为nil时不检查
+func validateRuleGroups(rule *controller.PolicyRule, exists func(name string) bool) error {
+	if exists == nil {
+		return nil
+	}
+	for _, name := range []string{rule.From, rule.To} {
+		if name == "any" || strings.Contains(name, "*") {
+			continue
+		}
+		if parseEndpointNet(name) != nil {
+			continue
+		}
+		if !exists(name) {
+			return fmt.Errorf("%w: %q", ErrUnknownGroup, name)
+		}
+	}
+	return nil
+}
+
+// AddRule 添加规则
+func (e *Engine) AddRule(rule *controller.PolicyRule) error {
 	if rule.ID == 0 {
-		return fmt.Errorf("rule ID cannot be 0")
+		return fmt.Errorf("%w: rule ID cannot be 0", ErrInvalidRule)
+	}
+	if err := validateRuleGroups(rule, e.groupValidator()); err != nil {
+		return err
+	}
+	if err := resolveApps(rule); err != nil {
+		return err
+	}
+	if err := validateAction(rule); err != nil {
+		return err
 	}
 
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
 	rule.CreatedAt = time.Now()
 	rule.UpdatedAt = time.Now()
 
+	if err := e.store.Save(rule); err != nil {
+		return fmt.Errorf("failed to save rule %d: %v", rule.ID, err)
+	}
 	e.rules[rule.ID] = rule
+	e.markDirty(rule)
 
 	// 更新规则顺序
 	e.updateRuleOrder()
@@ -56,15 +174,41 @@ func (e *Engine) AddRule(rule *controller.PolicyRule) error {
 
 // UpdateRule 更新规则
 func (e *Engine) UpdateRule(rule *controller.PolicyRule) error {
+	e.mutex.RLock()
+	_, ok := e.rules[rule.ID]
+	exists := e.groupExists
+	e.mutex.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %d", ErrRuleNotFound, rule.ID)
+	}
+	if err := validateRuleGroups(rule, exists); err != nil {
+		return err
+	}
+	if err := resolveApps(rule); err != nil {
+		return err
+	}
+	if err := validateAction(rule); err != nil {
+		return err
+	}
+
 	e.mutex.Lock()
 	defer e.mutex.Unlock()
 
-	if _, ok := e.rules[rule.ID]; !ok {
-		return fmt.Errorf("rule %d not found", rule.ID)
+	// 校验期间规则可能已被删除
+	old, ok := e.rules[rule.ID]
+	if !ok {
+		return fmt.Errorf("%w: %d", ErrRuleNotFound, rule.ID)
 	}
-
 	rule.UpdatedAt = time.Now()
+	if err := e.store.Save(rule); err != nil {
+		return fmt.Errorf("failed to save rule %d: %v", rule.ID, err)
+	}
 	e.rules[rule.ID] = rule
+	e.markDirty(old)
+	e.markDirty(rule)
+
+	// 优先级可能变化，重新排序
+	e.updateRuleOrder()
 
 	return nil
 }
@@ -74,11 +218,106 @@ func (e *Engine) DeleteRule(id uint32) error {
 	e.mutex.Lock()
 	defer e.mutex.Unlock()
 
-	if _, ok := e.rules[id]; !ok {
-		return fmt.Errorf("rule %d not found", id)
+	rule, ok := e.rules[id]
+	if !ok {
+		return fmt.Errorf("%w: %d", ErrRuleNotFound, id)
 	}
 
+	if err := e.store.Delete(id); err != nil {
+		return fmt.Errorf("failed to delete rule %d: %v", id, err)
+	}
 	delete(e.rules, id)
+	e.markDirty(rule)
+	e.updateRuleOrder()
+
+	return nil
+}
+
+// DeleteRulesWhere 删除所有满足pred的规则，返回按ID升序的已删除规则ID
+// 存储删除失败时停止，已删除的规则仍生效，返回已删除的ID和错误
+func (e *Engine) DeleteRulesWhere(pred func(rule *controller.PolicyRule) bool) ([]uint32, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	var ids []uint32
+	for id, rule := range e.rules {
+		if pred(rule) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	deleted := make([]uint32, 0, len(ids))
+	var err error
+	for _, id := range ids {
+		if err = e.store.Delete(id); err != nil {
+			err = fmt.Errorf("failed to delete rule %d: %v", id, err)
+			break
+		}
+		e.markDirty(e.rules[id])
+		delete(e.rules, id)
+		deleted = append(deleted, id)
+	}
+	if len(deleted) > 0 {
+		e.updateRuleOrder()
+	}
+	return deleted, err
+}
+
+// ReplaceRules 以给定的规则整体替换全部规则
+// 先校验全部规则，任一不合法时不做任何修改；校验通过后设置规则的创建和更新时间，
+// 再由存储一次写入全部规则，写入失败时存储和引擎均保留原有规则；
+// 严格模式下groupExists非nil时代替当前的组检查，用于与组一同导入的场景
+func (e *Engine) ReplaceRules(rules []*controller.PolicyRule, groupExists func(name string) bool) error {
+	exists := e.groupValidator()
+	if exists != nil && groupExists != nil {
+		exists = groupExists
+	}
+	next := make(map[uint32]*controller.PolicyRule, len(rules))
+	for _, rule := range rules {
+		if rule.ID == 0 {
+			return fmt.Errorf("%w: rule ID cannot be 0", ErrInvalidRule)
+		}
+		if _, ok := next[rule.ID]; ok {
+			return fmt.Errorf("%w: duplicate rule ID %d", ErrInvalidRule, rule.ID)
+		}
+		if err := validateRuleGroups(rule, exists); err != nil {
+			return err
+		}
+		if err := resolveApps(rule); err != nil {
+			return err
+		}
+		if err := validateAction(rule); err != nil {
+			return err
+		}
+		next[rule.ID] = rule
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	now := time.Now()
+	for _, rule := range rules {
+		if rule.CreatedAt.IsZero() {
+			rule.CreatedAt = now
+		}
+		rule.UpdatedAt = now
+	}
+	if err := e.store.Replace(rules); err != nil {
+		return fmt.Errorf("failed to replace rules: %v", err)
+	}
+	for id, rule := range e.rules {
+		if _, ok := next[id]; !ok {
+			e.markDirty(rule)
+		}
+	}
+	for _, rule := range next {
+		if old, ok := e.rules[rule.ID]; ok {
+			e.markDirty(old)
+		}
+		e.markDirty(rule)
+	}
+	e.rules = next
 	e.updateRuleOrder()
 
 	return nil
@@ -107,45 +346,265 @@ func (e *Engine) ListRules() []*controller.PolicyRule {
 }
 
 // updateRuleOrder 更新规则顺序
+// 所有规则变更都经过此处，同时使编译结果和匹配缓存失效
 func (e *Engine) updateRuleOrder() {
+	e.invalidateCompiled()
+	e.matches.Reset()
+
 	e.ruleOrder = make([]uint32, 0, len(e.rules))
-	for id := range e.rules {
+	e.ruleNets = make(map[string]*net.IPNet)
+	for id, rule := range e.rules {
 		e.ruleOrder = append(e.ruleOrder, id)
+		for _, side := range []string{rule.From, rule.To} {
+			if ipnet := parseEndpointNet(side); ipnet != nil {
+				e.ruleNets[side] = ipnet
+			}
+		}
 	}
 
-	// 按优先级排序
+	// 按优先级排序，优先级相同时按ID升序，保证评估顺序确定
 	sort.Slice(e.ruleOrder, func(i, j int) bool {
 		ri := e.rules[e.ruleOrder[i]]
 		rj := e.rules[e.ruleOrder[j]]
-		return ri.Priority < rj.Priority
+		if ri.Priority != rj.Priority {
+			return ri.Priority < rj.Priority
+		}
+		return ri.ID < rj.ID
 	})
 }
 
+// invalidateCompiled 丢弃编译结果并通知规则变更，调用方需持有写锁
+func (e *Engine) invalidateCompiled() {
+	e.compiled = nil
+	e.compiledScoped = nil
+
+	close(e.changeCh)
+	e.changeCh = make(chan struct{})
+}
+
+// markDirty 记录规则变更涉及的组，调用方需持有写锁
+func (e *Engine) markDirty(rule *controller.PolicyRule) {
+	e.dirtyGroups[rule.From] = true
+	e.dirtyGroups[rule.To] = true
+}
+
+// TakeDirtyGroups 取出并清空上次调用以来规则变更涉及的组
+// 涉及any或CIDR时all为true，表示所有组都可能受影响
+func (e *Engine) TakeDirtyGroups() (groups []string, all bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	for name := range e.dirtyGroups {
+		// CIDR规则按IP匹配，可能影响任意组的连接
+		if name == "any" || parseEndpointNet(name) != nil {
+			all = true
+		}
+		groups = append(groups, name)
+	}
+	e.dirtyGroups = make(map[string]bool)
+	return groups, all
+}
+
+// Changed 返回规则变更通知通道
+// 通道在下一次规则变更时关闭，需在读取规则之前获取以免漏掉变更
+func (e *Engine) Changed() <-chan struct{} {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.changeCh
+}
+
+// CompiledPolicies 获取编译后的全量规则列表
+// 返回值在规则变更前被共享复用，调用方不得修改
+func (e *Engine) CompiledPolicies() *pb.PolicyList {
+	e.mutex.RLock()
+	list := e.compiled
+	e.mutex.RUnlock()
+	if list != nil {
+		return list
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.compiled == nil {
+		e.compiled = e.compile(nil)
+	}
+	return e.compiled
+}
+
+// CompiledPoliciesForGroups 获取与指定组相关的编译规则子集
+// 包含From或To为其中任一组或any的规则，返回值不得修改
+func (e *Engine) CompiledPoliciesForGroups(groups []string) *pb.PolicyList {
+	scope := make(map[string]bool, len(groups))
+	for _, g := range groups {
+		scope[g] = true
+	}
+	names := make([]string, 0, len(scope))
+	for g := range scope {
+		names = append(names, g)
+	}
+	sort.Strings(names)
+	key := strings.Join(names, ",")
+
+	e.mutex.RLock()
+	list := e.compiledScoped[key]
+	e.mutex.RUnlock()
+	if list != nil {
+		return list
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if list = e.compiledScoped[key]; list == nil {
+		if e.compiledScoped == nil {
+			e.compiledScoped = make(map[string]*pb.PolicyList)
+		}
+		list = e.compile(scope)
+		e.compiledScoped[key] = list
+	}
+	return list
+}
+
+// compile 按规则顺序转换为proto规则列表
+// scope为nil时包含全部规则
+func (e *Engine) compile(scope map[string]bool) *pb.PolicyList {
+	rules := make([]*pb.PolicyRule, 0, len(e.ruleOrder))
+	for _, id := range e.ruleOrder {
+		rule, ok := e.rules[id]
+		if !ok {
+			continue
+		}
+		if scope != nil && !e.inScope(rule.From, scope) && !e.inScope(rule.To, scope) {
+			continue
+		}
+		rules = append(rules, &pb.PolicyRule{
+			Id:           rule.ID,
+			From:         rule.From,
+			To:           rule.To,
+			Ports:        rule.Ports,
+			Applications: rule.Applications,
+			Action:       actionValue(rule.Action),
+			Priority:     rule.Priority,
+			Disable:      rule.Disable,
+			Comment:      rule.Comment,
+			Log:          rule.Log,
+		})
+	}
+	return &pb.PolicyList{Rules: rules}
+}
+
+// inScope 检查规则端点是否在组范围内
+// any和CIDR/IP端点不指定组，可能匹配任何工作负载，视为在范围内；调用方需持有锁
+func (e *Engine) inScope(name string, scope map[string]bool) bool {
+	if name == "any" || scope[name] {
+		return true
+	}
+	_, ok := e.ruleNets[name]
+	return ok
+}
+
+// validateAction 校验规则动作，未知动作返回ErrInvalidRule
+func validateAction(rule *controller.PolicyRule) error {
+	if _, err := pb.ParsePolicyAction(rule.Action); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidRule, err)
+	}
+	return nil
+}
+
+// actionValue 已校验规则动作的proto取值
+func actionValue(action string) uint32 {
+	v, _ := pb.ParsePolicyAction(action) // 规则加入引擎前已校验
+	return v
+}
+
 // SetGroupMode 设置组策略模式
 func (e *Engine) SetGroupMode(groupName string, mode controller.PolicyMode) {
 	e.mutex.Lock()
 	defer e.mutex.Unlock()
 
 	e.groupModes[groupName] = mode
+	e.matches.Reset()
+}
+
+// ClearGroupMode 清除组策略模式，组恢复默认的Monitor模式
+// 用于删除组或组不再指定模式，避免之后同名的组沿用原模式
+func (e *Engine) ClearGroupMode(groupName string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if _, ok := e.groupModes[groupName]; ok {
+		delete(e.groupModes, groupName)
+		e.matches.Reset()
+	}
 }
 
 // GetGroupMode 获取组策略模式
 func (e *Engine) GetGroupMode(groupName string) controller.PolicyMode {
 	e.mutex.RLock()
 	defer e.mutex.RUnlock()
+	return e.groupMode(groupName)
+}
 
+// groupMode 获取组策略模式，调用方需持有锁
+func (e *Engine) groupMode(groupName string) controller.PolicyMode {
 	if mode, ok := e.groupModes[groupName]; ok {
 		return mode
 	}
 	return controller.PolicyModeMonitor // 默认Monitor模式
 }
 
+// SetGroupDisabled 设置组禁用状态
+// 规则本身保持不变，重新启用后立即恢复执行
+func (e *Engine) SetGroupDisabled(groupName string, disabled bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if disabled {
+		e.disabledGroups[groupName] = true
+	} else {
+		delete(e.disabledGroups, groupName)
+	}
+	e.matches.Reset()
+}
+
+// IsGroupDisabled 检查组是否被禁用
+func (e *Engine) IsGroupDisabled(groupName string) bool {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.disabledGroups[groupName]
+}
+
 // MatchPolicy 匹配策略
-// 返回匹配的规则ID和动作
+// 返回匹配的规则ID和动作，不提供IP时CIDR规则不参与匹配
 func (e *Engine) MatchPolicy(from, to string, port uint16, proto uint8, app uint32) (uint32, controller.PolicyAction) {
+	return e.MatchConnection(from, to, nil, nil, port, proto, app)
+}
+
+// MatchConnection 按连接匹配策略
+// From/To为CIDR或IP的规则与连接的客户端/服务端IP比较，其余按组或工作负载名比较；
+// 结果按匹配元组缓存，重复的查询不再遍历规则
+func (e *Engine) MatchConnection(from, to string, clientIP, serverIP net.IP, port uint16, proto uint8, app uint32) (uint32, controller.PolicyAction) {
 	e.mutex.RLock()
 	defer e.mutex.RUnlock()
 
+	// 持有读锁时查询和写入缓存，变更清空缓存后不会写入过期结果
+	key := matchKey{from: from, to: to, port: port, proto: proto, app: app}
+	copy(key.clientIP[:], clientIP.To16())
+	copy(key.serverIP[:], serverIP.To16())
+	if result, ok := e.matches.Get(key); ok {
+		return result.id, result.action
+	}
+	id, action := e.matchRules(from, to, clientIP, serverIP, port, proto, app)
+	e.matches.Put(key, matchResult{id: id, action: action})
+	return id, action
+}
+
+// matchRules 按规则顺序匹配，调用方需持有读锁
+func (e *Engine) matchRules(from, to string, clientIP, serverIP net.IP, port uint16, proto uint8, app uint32) (uint32, controller.PolicyAction) {
+	// 任一端所在组被禁用，视为开放
+	if e.disabledGroups[from] || e.disabledGroups[to] {
+		return 0, controller.PolicyActionOpen
+	}
+
 	for _, id := range e.ruleOrder {
 		rule := e.rules[id]
 		if rule.Disable {
@@ -153,12 +612,12 @@ func (e *Engine) MatchPolicy(from, to string, port uint16, proto uint8, app uint
 		}
 
 		// 检查From匹配
-		if rule.From != from && rule.From != "any" {
+		if !e.matchEndpoint(rule.From, from, clientIP) {
 			continue
 		}
 
 		// 检查To匹配
-		if rule.To != to && rule.To != "any" {
+		if !e.matchEndpoint(rule.To, to, serverIP) {
 			continue
 		}
 
@@ -177,13 +636,40 @@ func (e *Engine) MatchPolicy(from, to string, port uint16, proto uint8, app uint
 		}
 
 		// 匹配成功，返回动作
-		return rule.ID, e.actionFromString(rule.Action)
+		return rule.ID, controller.PolicyAction(actionValue(rule.Action))
 	}
 
 	// 没有匹配的规则，使用默认动作
 	return 0, e.getDefaultAction(to)
 }
 
+// matchEndpoint 匹配规则的一端
+// 规则端为CIDR或IP时比较连接IP，否则比较名称
+func (e *Engine) matchEndpoint(side, name string, ip net.IP) bool {
+	if side == "any" || side == name {
+		return true
+	}
+	if ipnet, ok := e.ruleNets[side]; ok {
+		return ip != nil && ipnet.Contains(ip)
+	}
+	return false
+}
+
+// parseEndpointNet 将规则端解析为网段，单个IP视为主机网段，非CIDR/IP返回nil
+func parseEndpointNet(side string) *net.IPNet {
+	if _, ipnet, err := net.ParseCIDR(side); err == nil {
+		return ipnet
+	}
+	ip := net.ParseIP(side)
+	if ip == nil {
+		return nil
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
 // matchPort 匹配端口
 func (e *Engine) matchPort(ports string, port uint16, proto uint8) bool {
 	// 简化实现：只检查"any"
@@ -204,21 +690,9 @@ func (e *Engine) matchApp(apps []uint32, app uint32) bool {
 	return false
 }
 
-// actionFromString 从字符串转换动作
-func (e *Engine) actionFromString(action string) controller.PolicyAction {
-	switch action {
-	case "allow":
-		return controller.PolicyActionAllow
-	case "deny":
-		return controller.PolicyActionDeny
-	default:
-		return controller.PolicyActionViolate
-	}
-}
-
-// getDefaultAction 获取默认动作
+// getDefaultAction 获取默认动作，调用方需持有锁
 func (e *Engine) getDefaultAction(groupName string) controller.PolicyAction {
-	mode := e.GetGroupMode(groupName)
+	mode := e.groupMode(groupName)
 	switch mode {
 	case controller.PolicyModeProtect:
 		return controller.PolicyActionDeny
@@ -227,6 +701,15 @@ func (e *Engine) getDefaultAction(groupName string) controller.PolicyAction {
 	}
 }
 
+// IsRuleLogged 检查规则是否开启审计日志
+func (e *Engine) IsRuleLogged(id uint32) bool {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	rule, ok := e.rules[id]
+	return ok && rule.Log
+}
+
 // GetRuleCount 获取规则数量
 func (e *Engine) GetRuleCount() int {
 	e.mutex.RLock()
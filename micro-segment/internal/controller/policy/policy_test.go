@@ -0,0 +1,559 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/micro-segment/api/proto"
+	controller "github.com/micro-segment/internal/controller"
+	"github.com/micro-segment/internal/controller/cache"
+)
+
+func TestDisabledGroup(t *testing.T) {
+	e := NewEngine()
+	e.SetGroupMode("db", controller.PolicyModeProtect)
+	e.AddRule(&controller.PolicyRule{ID: 1, From: "web", To: "db", Action: "deny", Priority: 1})
+
+	if id, action := e.MatchPolicy("web", "db", 3306, 6, 0); id != 1 || action != controller.PolicyActionDeny {
+		t.Fatalf("Unexpected match: %d %d", id, action)
+	}
+
+	// 禁用目标组后不再匹配规则或默认动作
+	e.SetGroupDisabled("db", true)
+	if id, action := e.MatchPolicy("web", "db", 3306, 6, 0); id != 0 || action != controller.PolicyActionOpen {
+		t.Errorf("Disabled group flagged: %d %d", id, action)
+	}
+	if id, action := e.MatchPolicy("app", "db", 3306, 6, 0); id != 0 || action != controller.PolicyActionOpen {
+		t.Errorf("Disabled group flagged: %d %d", id, action)
+	}
+
+	// 重新启用后恢复
+	e.SetGroupDisabled("db", false)
+	if id, action := e.MatchPolicy("web", "db", 3306, 6, 0); id != 1 || action != controller.PolicyActionDeny {
+		t.Errorf("Unexpected match after re-enable: %d %d", id, action)
+	}
+	if e.GetRule(1) == nil {
+		t.Errorf("Rule lost")
+	}
+}
+
+func TestCompiledPoliciesInvalidation(t *testing.T) {
+	e := NewEngine()
+	e.AddRule(&controller.PolicyRule{ID: 1, From: "web", To: "db", Action: "allow", Priority: 10})
+	e.AddRule(&controller.PolicyRule{ID: 2, From: "any", To: "web", Action: "allow", Priority: 20})
+
+	list := e.CompiledPolicies()
+	if len(list.Rules) != 2 || list.Rules[0].Id != 1 {
+		t.Fatalf("Unexpected compiled rules: %v", list.Rules)
+	}
+	if e.CompiledPolicies() != list {
+		t.Errorf("Compiled list not reused")
+	}
+	scoped := e.CompiledPoliciesForGroups([]string{"db"})
+	if len(scoped.Rules) != 2 {
+		t.Errorf("Unexpected scoped rules: %v", scoped.Rules)
+	}
+
+	// 新增
+	e.AddRule(&controller.PolicyRule{ID: 3, From: "app", To: "cache", Action: "deny", Priority: 30})
+	if list = e.CompiledPolicies(); len(list.Rules) != 3 {
+		t.Errorf("Add not reflected: %v", list.Rules)
+	}
+	if s := e.CompiledPoliciesForGroups([]string{"db"}); s == scoped || len(s.Rules) != 2 {
+		t.Errorf("Scoped list not invalidated on add: %v", s.Rules)
+	}
+
+	// 更新
+	e.UpdateRule(&controller.PolicyRule{ID: 3, From: "app", To: "cache", Action: "allow", Priority: 30})
+	if list = e.CompiledPolicies(); list.Rules[2].Action != pb.PolicyActionAllow {
+		t.Errorf("Update not reflected: %v", list.Rules[2])
+	}
+
+	// 调整优先级导致重新排序
+	e.UpdateRule(&controller.PolicyRule{ID: 3, From: "app", To: "cache", Action: "allow", Priority: 1})
+	if list = e.CompiledPolicies(); list.Rules[0].Id != 3 {
+		t.Errorf("Reorder not reflected: %v", list.Rules)
+	}
+
+	// 删除
+	e.DeleteRule(1)
+	if list = e.CompiledPolicies(); len(list.Rules) != 2 {
+		t.Errorf("Delete not reflected: %v", list.Rules)
+	}
+	if s := e.CompiledPoliciesForGroups([]string{"db"}); len(s.Rules) != 1 || s.Rules[0].Id != 2 {
+		t.Errorf("Scoped list not invalidated on delete: %v", s.Rules)
+	}
+}
+
+func TestCompiledPoliciesForGroups(t *testing.T) {
+	e := NewEngine()
+	e.AddRule(&controller.PolicyRule{ID: 1, From: "web", To: "db", Action: "allow", Priority: 1})
+	e.AddRule(&controller.PolicyRule{ID: 2, From: "app", To: "cache", Action: "allow", Priority: 2})
+	e.AddRule(&controller.PolicyRule{ID: 3, From: "any", To: "any", Action: "allow", Priority: 3})
+
+	a := e.CompiledPoliciesForGroups([]string{"web", "cache"})
+	if len(a.Rules) != 3 {
+		t.Errorf("Unexpected rules: %v", a.Rules)
+	}
+	// 组顺序和重复不影响缓存键
+	if b := e.CompiledPoliciesForGroups([]string{"cache", "web", "web"}); b != a {
+		t.Errorf("Scoped list not reused")
+	}
+	if c := e.CompiledPoliciesForGroups(nil); len(c.Rules) != 1 || c.Rules[0].Id != 3 {
+		t.Errorf("Unexpected rules for empty scope: %v", c.Rules)
+	}
+}
+
+func TestCompiledPoliciesForGroupsCIDR(t *testing.T) {
+	e := NewEngine()
+	e.AddRule(&controller.PolicyRule{ID: 1, From: "10.0.0.0/8", To: "0.0.0.0/0", Action: "deny", Priority: 1})
+	e.AddRule(&controller.PolicyRule{ID: 2, From: "web", To: "db", Action: "allow", Priority: 2})
+	e.AddRule(&controller.PolicyRule{ID: 3, From: "app", To: "10.1.2.3", Action: "allow", Priority: 3})
+	e.AddRule(&controller.PolicyRule{ID: 4, From: "app", To: "cache", Action: "allow", Priority: 4})
+
+	// CIDR/IP端点的规则不限定组，下发给所有范围
+	if all := e.CompiledPolicies(); len(all.Rules) != 4 {
+		t.Fatalf("Unexpected rules: %v", all.Rules)
+	}
+	scoped := e.CompiledPoliciesForGroups([]string{"web"})
+	var ids []uint32
+	for _, rule := range scoped.Rules {
+		ids = append(ids, rule.Id)
+	}
+	if len(ids) != 3 || ids[0] != 1 || ids[1] != 2 || ids[2] != 3 {
+		t.Errorf("Unexpected scoped rules: %v", ids)
+	}
+}
+
+// BenchmarkGetPolicies 对比预编译与每次请求重新转换的开销
+func BenchmarkGetPolicies(b *testing.B) {
+	e := NewEngine()
+	for i := 1; i <= 500; i++ {
+		e.AddRule(&controller.PolicyRule{ID: uint32(i), From: "web", To: "db", Action: "allow", Priority: uint32(i)})
+	}
+
+	b.Run("compiled", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			e.CompiledPolicies()
+		}
+	})
+	b.Run("uncompiled", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			e.mutex.Lock()
+			e.invalidateCompiled()
+			e.mutex.Unlock()
+			e.CompiledPolicies()
+		}
+	})
+}
+
+func TestMatchCache(t *testing.T) {
+	e := NewEngine()
+	e.AddRule(&controller.PolicyRule{ID: 1, From: "web", To: "db", Action: "allow", Priority: 10})
+
+	id, action := e.MatchPolicy("web", "db", 3306, 6, 0)
+	if id != 1 || action != controller.PolicyActionAllow {
+		t.Fatalf("Unexpected match: %d %v", id, action)
+	}
+	if id2, action2 := e.MatchPolicy("web", "db", 3306, 6, 0); id2 != id || action2 != action {
+		t.Errorf("Cached match differs: %d %v", id2, action2)
+	}
+	if e.matches.Len() != 1 {
+		t.Errorf("Match not cached: len=%d", e.matches.Len())
+	}
+
+	// 规则变更后不返回过期结果
+	checks := []struct {
+		name   string
+		change func()
+		id     uint32
+		action controller.PolicyAction
+	}{
+		{"add", func() { e.AddRule(&controller.PolicyRule{ID: 2, From: "web", To: "db", Action: "deny", Priority: 1}) }, 2, controller.PolicyActionDeny},
+		{"update", func() {
+			e.UpdateRule(&controller.PolicyRule{ID: 2, From: "web", To: "db", Action: "deny", Priority: 20})
+		}, 1, controller.PolicyActionAllow},
+		{"delete", func() { e.DeleteRule(1) }, 2, controller.PolicyActionDeny},
+		{"disable group", func() { e.SetGroupDisabled("db", true) }, 0, controller.PolicyActionOpen},
+		{"enable group", func() { e.SetGroupDisabled("db", false) }, 2, controller.PolicyActionDeny},
+	}
+	for _, c := range checks {
+		c.change()
+		if id, action := e.MatchPolicy("web", "db", 3306, 6, 0); id != c.id || action != c.action {
+			t.Errorf("%s: stale match %d %v", c.name, id, action)
+		}
+	}
+
+	e.DeleteRule(2)
+	if _, action := e.MatchPolicy("web", "db", 3306, 6, 0); action != controller.PolicyActionViolate {
+		t.Errorf("Unexpected default action: %v", action)
+	}
+	e.SetGroupMode("db", controller.PolicyModeProtect)
+	if _, action := e.MatchPolicy("web", "db", 3306, 6, 0); action != controller.PolicyActionDeny {
+		t.Errorf("Stale default action after mode change: %v", action)
+	}
+
+	// 缓存有上限，淘汰最久未使用的结果
+	c := newMatchCache(2)
+	for i, to := range []string{"a", "b", "c"} {
+		c.Put(matchKey{from: "web", to: to}, matchResult{id: uint32(i), action: controller.PolicyActionAllow})
+	}
+	if _, ok := c.Get(matchKey{from: "web", to: "a"}); ok || c.Len() != 2 {
+		t.Errorf("Cache not bounded: len=%d", c.Len())
+	}
+}
+
+func TestDefaultActionWithPendingWriter(t *testing.T) {
+	e := NewEngine()
+	// 不匹配的规则延长两次取读锁之间的间隔
+	for i := 1; i <= 200; i++ {
+		e.AddRule(&controller.PolicyRule{ID: uint32(i), From: fmt.Sprintf("g%d", i), To: "db", Action: "allow"})
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// 端口各不相同，每次都未命中缓存而取组的默认动作
+		for i := 0; i < 20000; i++ {
+			e.MatchPolicy("web", "db", uint16(i), 6, 0)
+		}
+	}()
+
+	stop := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				e.SetGroupMode("db", controller.PolicyModeProtect)
+			}
+		}
+	}()
+	defer close(stop)
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("MatchPolicy deadlocked with a pending writer")
+	}
+}
+
+// BenchmarkMatchPolicy 对比命中缓存与每次遍历规则的开销
+func BenchmarkMatchPolicy(b *testing.B) {
+	e := NewEngine()
+	for i := 1; i <= 500; i++ {
+		e.AddRule(&controller.PolicyRule{ID: uint32(i), From: fmt.Sprintf("g%d", i), To: "db", Action: "allow", Priority: uint32(i)})
+	}
+
+	b.Run("cached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			e.MatchPolicy("web", "db", 3306, 6, 0)
+		}
+	})
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			e.matches.Reset()
+			e.MatchPolicy("web", "db", 3306, 6, 0)
+		}
+	})
+}
+
+func TestTakeDirtyGroups(t *testing.T) {
+	e := NewEngine()
+	e.AddRule(&controller.PolicyRule{ID: 1, From: "web", To: "db", Action: "allow", Priority: 1})
+	e.UpdateRule(&controller.PolicyRule{ID: 1, From: "web", To: "cache", Action: "allow", Priority: 1})
+
+	groups, all := e.TakeDirtyGroups()
+	if all || len(groups) != 3 {
+		t.Errorf("Unexpected dirty groups: %v all=%v", groups, all)
+	}
+	if groups, _ = e.TakeDirtyGroups(); len(groups) != 0 {
+		t.Errorf("Dirty groups not cleared: %v", groups)
+	}
+
+	e.AddRule(&controller.PolicyRule{ID: 2, From: "any", To: "db", Action: "allow", Priority: 2})
+	if _, all = e.TakeDirtyGroups(); !all {
+		t.Errorf("Rule with any not flagged as affecting all groups")
+	}
+}
+
+func TestDeleteRulesWhere(t *testing.T) {
+	e := NewEngine()
+	for _, rule := range []*controller.PolicyRule{
+		{ID: 1, From: "web", To: "db", Action: "allow"},
+		{ID: 2, From: "web", To: "cache", Action: "deny"},
+		{ID: 3, From: "api", To: "db", Action: "deny"},
+		{ID: 4, From: "api", To: "cache", Action: "allow"},
+	} {
+		if err := e.AddRule(rule); err != nil {
+			t.Fatalf("AddRule: %v", err)
+		}
+	}
+	e.TakeDirtyGroups()
+
+	deleted, err := e.DeleteRulesWhere(func(rule *controller.PolicyRule) bool { return rule.Action == "deny" })
+	if err != nil || len(deleted) != 2 || deleted[0] != 2 || deleted[1] != 3 {
+		t.Fatalf("Unexpected deleted rules: %v %v", deleted, err)
+	}
+	if e.GetRule(1) == nil || e.GetRule(4) == nil || e.GetRuleCount() != 2 {
+		t.Errorf("Unexpected remaining rules: %v", e.ListRules())
+	}
+	if groups, _ := e.TakeDirtyGroups(); len(groups) != 4 {
+		t.Errorf("Unexpected dirty groups: %v", groups)
+	}
+
+	deleted, _ = e.DeleteRulesWhere(func(rule *controller.PolicyRule) bool { return rule.From == "web" })
+	if len(deleted) != 1 || deleted[0] != 1 || e.GetRuleCount() != 1 || e.GetRule(4) == nil {
+		t.Errorf("Unexpected delete by from group: %v %v", deleted, e.ListRules())
+	}
+
+	// 无匹配时不触发变更通知
+	changed := e.Changed()
+	if deleted, _ = e.DeleteRulesWhere(func(*controller.PolicyRule) bool { return false }); len(deleted) != 0 {
+		t.Errorf("Unexpected delete: %v", deleted)
+	}
+	select {
+	case <-changed:
+		t.Errorf("Change notified without deletion")
+	default:
+	}
+}
+
+func TestRuleOrderTiebreak(t *testing.T) {
+	e := NewEngine()
+	for _, id := range []uint32{7, 3, 9, 1, 5} {
+		e.AddRule(&controller.PolicyRule{ID: id, From: "web", To: "db", Action: "allow", Priority: 10})
+	}
+	e.AddRule(&controller.PolicyRule{ID: 8, From: "web", To: "db", Action: "deny", Priority: 1})
+
+	want := []uint32{8, 1, 3, 5, 7, 9}
+	for n := 0; n < 20; n++ {
+		// 触发重新排序
+		e.UpdateRule(&controller.PolicyRule{ID: 5, From: "web", To: "db", Action: "allow", Priority: 10})
+		rules := e.ListRules()
+		for i, rule := range rules {
+			if rule.ID != want[i] {
+				t.Fatalf("Unexpected order: %v", rules)
+			}
+		}
+	}
+
+	// 同优先级重叠规则由ID最小的规则命中
+	e.DeleteRule(8)
+	if id, _ := e.MatchPolicy("web", "db", 3306, 6, 0); id != 1 {
+		t.Errorf("Unexpected match: %d", id)
+	}
+}
+
+func TestStrictGroupValidation(t *testing.T) {
+	groups := map[string]bool{"web": true, "db": true}
+	rules := []*controller.PolicyRule{
+		{ID: 1, From: "web", To: "db", Action: "allow"},
+		{ID: 2, From: "any", To: "db", Action: "deny"},
+		{ID: 3, From: "10.0.0.0/8", To: "web", Action: "allow"},
+		{ID: 4, From: "web", To: "db-*", Action: "allow"},
+	}
+
+	// 宽松模式接受任意组名
+	e := NewEngine()
+	if err := e.AddRule(&controller.PolicyRule{ID: 9, From: "wbe", To: "db", Action: "allow"}); err != nil {
+		t.Errorf("Lenient mode rejected rule: %v", err)
+	}
+
+	e = NewEngine()
+	e.SetGroupValidator(func(name string) bool { return groups[name] })
+	for _, rule := range rules {
+		if err := e.AddRule(rule); err != nil {
+			t.Errorf("Rule %d rejected: %v", rule.ID, err)
+		}
+	}
+
+	err := e.AddRule(&controller.PolicyRule{ID: 5, From: "wbe", To: "db", Action: "allow"})
+	if !errors.Is(err, ErrUnknownGroup) || !strings.Contains(err.Error(), "wbe") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if e.GetRule(5) != nil {
+		t.Errorf("Rejected rule stored")
+	}
+
+	err = e.UpdateRule(&controller.PolicyRule{ID: 1, From: "web", To: "dbs", Action: "allow"})
+	if !errors.Is(err, ErrUnknownGroup) || !strings.Contains(err.Error(), "dbs") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if e.GetRule(1).To != "db" {
+		t.Errorf("Rejected update applied")
+	}
+}
+
+func TestStrictGroupsConcurrentReevaluation(t *testing.T) {
+	c := cache.NewCache()
+	c.AddGroup(&controller.Group{Name: "web"})
+	c.AddGroup(&controller.Group{Name: "db"})
+	for i := 0; i < 50; i++ {
+		c.UpdateConnection(&controller.Connection{
+			ClientWL: fmt.Sprintf("wl%d", i), ServerWL: "db", ClientIP: net.IPv4(10, 0, 0, byte(i)), ServerIP: net.IPv4(10, 0, 1, 1),
+			ServerPort: uint16(1000 + i), IPProto: 6,
+		})
+	}
+	e := NewEngine()
+	e.SetGroupValidator(func(name string) bool { return c.GetGroup(name) != nil })
+
+	// 后台重新评估持有Cache锁匹配策略，同时规则变更经严格模式检查读取Cache
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				c.ReevaluateConnections(nil, true, e.MatchConnection)
+			}
+		}
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < 5000; i++ {
+			rule := &controller.PolicyRule{ID: uint32(i%5 + 1), From: "web", To: "db", Action: "allow"}
+			if err := e.AddRule(rule); err != nil {
+				done <- err
+				return
+			}
+			if err := e.UpdateRule(&controller.PolicyRule{ID: rule.ID, From: "web", To: "db", Action: "deny"}); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- e.ReplaceRules([]*controller.PolicyRule{{ID: 1, From: "web", To: "db", Action: "allow"}}, nil)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Rule change failed: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Rule changes deadlocked with connection reevaluation")
+	}
+}
+func TestRuleAction(t *testing.T) {
+	e := NewEngine()
+	for id, action := range map[uint32]string{1: "open", 2: "allow", 3: "deny", 4: "violate"} {
+		to := fmt.Sprintf("db%d", id)
+		e.SetGroupMode(to, controller.PolicyModeProtect)
+		if err := e.AddRule(&controller.PolicyRule{ID: id, From: "web", To: to, Action: action}); err != nil {
+			t.Fatalf("Rule %d rejected: %v", id, err)
+		}
+	}
+	// 四种动作均按proto定义的取值匹配，open不再被当作violate
+	for id, want := range map[uint32]controller.PolicyAction{
+		1: controller.PolicyActionOpen,
+		2: controller.PolicyActionAllow,
+		3: controller.PolicyActionDeny,
+		4: controller.PolicyActionViolate,
+	} {
+		if got, action := e.MatchPolicy("web", fmt.Sprintf("db%d", id), 80, 6, 0); got != id || action != want {
+			t.Errorf("Rule %d matched %d %d, want %d %d", id, got, action, id, want)
+		}
+	}
+
+	for _, action := range []string{"", "alow"} {
+		err := e.AddRule(&controller.PolicyRule{ID: 9, From: "web", To: "db", Action: action})
+		if !errors.Is(err, ErrInvalidRule) || !errors.Is(err, pb.ErrUnknownPolicyAction) {
+			t.Errorf("Action %q: unexpected error %v", action, err)
+		}
+	}
+	if e.GetRule(9) != nil {
+		t.Errorf("Rejected rule stored")
+	}
+
+	err := e.UpdateRule(&controller.PolicyRule{ID: 2, From: "web", To: "db2", Action: "block"})
+	if !errors.Is(err, pb.ErrUnknownPolicyAction) {
+		t.Errorf("Unexpected update error: %v", err)
+	}
+	if e.GetRule(2).Action != "allow" {
+		t.Errorf("Rejected update applied")
+	}
+}
+
+func TestCIDRRules(t *testing.T) {
+	e := NewEngine()
+	e.SetGroupMode("db", controller.PolicyModeProtect)
+	e.AddRule(&controller.PolicyRule{ID: 1, From: "0.0.0.0/0", To: "web", Action: "allow", Priority: 10})
+	e.AddRule(&controller.PolicyRule{ID: 2, From: "app", To: "10.1.0.0/16", Action: "deny", Priority: 20})
+	e.AddRule(&controller.PolicyRule{ID: 3, From: "192.168.1.5", To: "db", Action: "allow", Priority: 30})
+	e.AddRule(&controller.PolicyRule{ID: 4, From: "app", To: "db", Action: "allow", Priority: 40})
+
+	internet := net.ParseIP("203.0.113.9")
+	tests := []struct {
+		from, to           string
+		clientIP, serverIP net.IP
+		id                 uint32
+		action             controller.PolicyAction
+	}{
+		{"external", "web", internet, net.ParseIP("10.0.0.2"), 1, controller.PolicyActionAllow},
+		{"app", "cache", net.ParseIP("10.0.0.3"), net.ParseIP("10.1.2.3"), 2, controller.PolicyActionDeny},
+		{"app", "cache", net.ParseIP("10.0.0.3"), net.ParseIP("10.2.2.3"), 0, controller.PolicyActionViolate},
+		{"admin", "db", net.ParseIP("192.168.1.5"), net.ParseIP("10.0.0.4"), 3, controller.PolicyActionAllow},
+		{"admin", "db", net.ParseIP("192.168.1.6"), net.ParseIP("10.0.0.4"), 0, controller.PolicyActionDeny},
+		{"app", "db", net.ParseIP("10.0.0.3"), net.ParseIP("10.0.0.4"), 4, controller.PolicyActionAllow},
+	}
+	for _, tt := range tests {
+		id, action := e.MatchConnection(tt.from, tt.to, tt.clientIP, tt.serverIP, 80, 6, 0)
+		if id != tt.id || action != tt.action {
+			t.Errorf("%s(%v) -> %s(%v): got %d %d, want %d %d",
+				tt.from, tt.clientIP, tt.to, tt.serverIP, id, action, tt.id, tt.action)
+		}
+	}
+
+	// 不提供IP时CIDR规则不匹配
+	if id, _ := e.MatchPolicy("external", "web", 80, 6, 0); id != 0 {
+		t.Errorf("CIDR rule matched without IP: %d", id)
+	}
+
+	// CIDR规则变更影响全部连接
+	e.TakeDirtyGroups()
+	e.DeleteRule(2)
+	if _, all := e.TakeDirtyGroups(); !all {
+		t.Errorf("CIDR rule change not marked as affecting all groups")
+	}
+}
+
+func TestRuleAppNames(t *testing.T) {
+	e := NewEngine()
+	rule := &controller.PolicyRule{ID: 1, From: "web", To: "db", Action: "allow", AppNames: []string{"mysql", "HTTP"}}
+	if err := e.AddRule(rule); err != nil {
+		t.Fatalf("AddRule failed: %v", err)
+	}
+	if len(rule.Applications) != 2 || rule.Applications[0] != 1001 || rule.Applications[1] != 2001 {
+		t.Errorf("Unexpected applications: %v", rule.Applications)
+	}
+	if len(rule.AppNames) != 2 || rule.AppNames[0] != "HTTP" || rule.AppNames[1] != "MySQL" {
+		t.Errorf("Unexpected app names: %v", rule.AppNames)
+	}
+
+	// 应用标识下发到Agent
+	list := e.CompiledPolicies()
+	if len(list.Rules) != 1 || len(list.Rules[0].Applications) != 2 || list.Rules[0].Applications[1] != 2001 {
+		t.Errorf("Applications not compiled: %v", list.Rules)
+	}
+	if id, _ := e.MatchPolicy("web", "db", 3306, 6, 2001); id != 1 {
+		t.Errorf("Expected MySQL to match rule 1, got %d", id)
+	}
+	if id, _ := e.MatchPolicy("web", "db", 6379, 6, 2002); id != 0 {
+		t.Errorf("Expected Redis not to match, got %d", id)
+	}
+
+	err := e.UpdateRule(&controller.PolicyRule{ID: 1, From: "web", To: "db", Action: "allow", AppNames: []string{"mysq"}})
+	if !errors.Is(err, ErrUnknownApp) {
+		t.Errorf("Expected ErrUnknownApp, got %v", err)
+	}
+	if got := e.GetRule(1); len(got.Applications) != 2 {
+		t.Errorf("Rule changed by failed update: %v", got.Applications)
+	}
+}
@@ -0,0 +1,248 @@
+// Package policy 提供策略管理功能
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	controller "github.com/micro-segment/internal/controller"
+)
+
+// RuleStore 规则存储后端
+// 引擎的所有规则变更都经由存储持久化，启动时通过Load恢复
+type RuleStore interface {
+	// Load 加载已持久化的全部规则
+	Load() ([]*controller.PolicyRule, error)
+	// Save 新增或覆盖规则
+	Save(rule *controller.PolicyRule) error
+	// Delete 删除规则，规则不存在时不报错
+	Delete(id uint32) error
+	// Replace 以给定的规则整体替换全部规则，一次写入，失败时保留原有规则
+	Replace(rules []*controller.PolicyRule) error
+	// List 列出存储中的全部规则
+	List() ([]*controller.PolicyRule, error)
+}
+
+// MemoryStore 内存规则存储，进程退出后规则丢失
+type MemoryStore struct {
+	mutex sync.Mutex
+	rules map[uint32]*controller.PolicyRule
+}
+
+// NewMemoryStore 创建内存规则存储
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{rules: make(map[uint32]*controller.PolicyRule)}
+}
+
+// Load 加载全部规则
+func (s *MemoryStore) Load() ([]*controller.PolicyRule, error) {
+	return s.List()
+}
+
+// Save 保存规则
+func (s *MemoryStore) Save(rule *controller.PolicyRule) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.rules[rule.ID] = rule
+	return nil
+}
+
+// Delete 删除规则
+func (s *MemoryStore) Delete(id uint32) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.rules, id)
+	return nil
+}
+
+// Replace 整体替换全部规则
+func (s *MemoryStore) Replace(rules []*controller.PolicyRule) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.rules = make(map[uint32]*controller.PolicyRule, len(rules))
+	for _, rule := range rules {
+		s.rules[rule.ID] = rule
+	}
+	return nil
+}
+
+// List 列出全部规则
+func (s *MemoryStore) List() ([]*controller.PolicyRule, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	result := make([]*controller.PolicyRule, 0, len(s.rules))
+	for _, rule := range s.rules {
+		result = append(result, rule)
+	}
+	return result, nil
+}
+
+// FileStore 文件规则存储
+// 规则以JSON数组保存在单个文件中，每次变更先写临时文件再重命名，保证文件完整
+type FileStore struct {
+	mutex sync.Mutex
+	path  string
+	rules map[uint32]*controller.PolicyRule
+}
+
+// NewFileStore 创建文件规则存储
+// 文件不存在时视为空存储，首次变更时创建
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// Load 从文件加载全部规则
+func (s *FileStore) Load() ([]*controller.PolicyRule, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.load()
+}
+
+// load 从文件读取规则，调用方需持有锁
+// 读取失败时保持未加载状态，避免后续写入覆盖原文件
+func (s *FileStore) load() ([]*controller.PolicyRule, error) {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		s.rules = make(map[uint32]*controller.PolicyRule)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rule file: %v", err)
+	}
+
+	var rules []*controller.PolicyRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse rule file %s: %v", s.path, err)
+	}
+	s.rules = make(map[uint32]*controller.PolicyRule, len(rules))
+	for _, rule := range rules {
+		s.rules[rule.ID] = rule
+	}
+	return rules, nil
+}
+
+// Save 保存规则并写回文件
+func (s *FileStore) Save(rule *controller.PolicyRule) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.ensureLoaded(); err != nil {
+		return err
+	}
+	old, existed := s.rules[rule.ID]
+	s.rules[rule.ID] = rule
+	if err := s.flush(); err != nil {
+		// 写入失败时还原，保持内存与文件一致
+		if existed {
+			s.rules[rule.ID] = old
+		} else {
+			delete(s.rules, rule.ID)
+		}
+		return err
+	}
+	return nil
+}
+
+// Delete 删除规则并写回文件
+func (s *FileStore) Delete(id uint32) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.ensureLoaded(); err != nil {
+		return err
+	}
+	old, ok := s.rules[id]
+	if !ok {
+		return nil
+	}
+	delete(s.rules, id)
+	if err := s.flush(); err != nil {
+		s.rules[id] = old
+		return err
+	}
+	return nil
+}
+
+// Replace 整体替换全部规则并写回文件
+// 只写一次文件，写入失败时还原，保持内存与文件一致
+func (s *FileStore) Replace(rules []*controller.PolicyRule) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.ensureLoaded(); err != nil {
+		return err
+	}
+	old := s.rules
+	s.rules = make(map[uint32]*controller.PolicyRule, len(rules))
+	for _, rule := range rules {
+		s.rules[rule.ID] = rule
+	}
+	if err := s.flush(); err != nil {
+		s.rules = old
+		return err
+	}
+	return nil
+}
+
+// List 列出全部规则
+func (s *FileStore) List() ([]*controller.PolicyRule, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.ensureLoaded(); err != nil {
+		return nil, err
+	}
+	result := make([]*controller.PolicyRule, 0, len(s.rules))
+	for _, rule := range s.rules {
+		result = append(result, rule)
+	}
+	return result, nil
+}
+
+// ensureLoaded 未调用Load时先从文件加载，调用方需持有锁
+func (s *FileStore) ensureLoaded() error {
+	if s.rules != nil {
+		return nil
+	}
+	_, err := s.load()
+	return err
+}
+
+// flush 将全部规则写回文件，调用方需持有锁
+func (s *FileStore) flush() error {
+	rules := make([]*controller.PolicyRule, 0, len(s.rules))
+	for _, rule := range s.rules {
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+
+	data, err := json.MarshalIndent(rules, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to write rule file: %v", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write rule file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write rule file: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write rule file: %v", err)
+	}
+	return nil
+}
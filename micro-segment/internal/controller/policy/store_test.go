@@ -0,0 +1,160 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	controller "github.com/micro-segment/internal/controller"
+)
+
+// testRuleStore 对存储执行规则增删改并校验重新加载后的结果
+func testRuleStore(t *testing.T, open func() RuleStore) {
+	e, err := NewEngineWithStore(open())
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	e.AddRule(&controller.PolicyRule{ID: 1, From: "web", To: "db", Action: "allow", Priority: 20})
+	e.AddRule(&controller.PolicyRule{ID: 2, From: "any", To: "db", Action: "deny", Priority: 10})
+	e.AddRule(&controller.PolicyRule{ID: 3, From: "app", To: "cache", Action: "allow", Priority: 30})
+	e.UpdateRule(&controller.PolicyRule{ID: 1, From: "web", To: "db", Action: "deny", Priority: 20})
+	e.DeleteRule(3)
+
+	// 重新加载后规则、顺序与匹配结果一致
+	e, err = NewEngineWithStore(open())
+	if err != nil {
+		t.Fatalf("Failed to reload engine: %v", err)
+	}
+	rules := e.ListRules()
+	if len(rules) != 2 || rules[0].ID != 2 || rules[1].ID != 1 {
+		t.Fatalf("Unexpected rules: %+v", rules)
+	}
+	if rules[1].Action != "deny" {
+		t.Errorf("Update not persisted: %+v", rules[1])
+	}
+	if id, action := e.MatchPolicy("web", "db", 3306, 6, 0); id != 2 || action != controller.PolicyActionDeny {
+		t.Errorf("Unexpected match: %d %d", id, action)
+	}
+
+	// 整体替换后只保留新的规则
+	err = e.ReplaceRules([]*controller.PolicyRule{
+		{ID: 2, From: "any", To: "db", Action: "allow", Priority: 10},
+		{ID: 4, From: "app", To: "db", Action: "deny", Priority: 5},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Failed to replace rules: %v", err)
+	}
+	e, err = NewEngineWithStore(open())
+	if err != nil {
+		t.Fatalf("Failed to reload engine: %v", err)
+	}
+	rules = e.ListRules()
+	if len(rules) != 2 || rules[0].ID != 4 || rules[1].ID != 2 || rules[1].Action != "allow" {
+		t.Errorf("Replace not persisted: %+v", rules)
+	}
+}
+
+// countingStore 记录写入次数的规则存储
+type countingStore struct {
+	*MemoryStore
+	writes int
+}
+
+func (s *countingStore) Save(rule *controller.PolicyRule) error {
+	s.writes++
+	return s.MemoryStore.Save(rule)
+}
+
+func (s *countingStore) Delete(id uint32) error {
+	s.writes++
+	return s.MemoryStore.Delete(id)
+}
+
+func (s *countingStore) Replace(rules []*controller.PolicyRule) error {
+	s.writes++
+	return s.MemoryStore.Replace(rules)
+}
+
+func TestReplaceRulesSingleWrite(t *testing.T) {
+	store := &countingStore{MemoryStore: NewMemoryStore()}
+	e, err := NewEngineWithStore(store)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	e.AddRule(&controller.PolicyRule{ID: 1, From: "web", To: "db", Action: "allow"})
+	e.AddRule(&controller.PolicyRule{ID: 2, From: "app", To: "db", Action: "allow"})
+
+	store.writes = 0
+	rules := []*controller.PolicyRule{
+		{ID: 2, From: "app", To: "db", Action: "deny"},
+		{ID: 3, From: "web", To: "cache", Action: "allow"},
+		{ID: 4, From: "any", To: "db", Action: "deny"},
+	}
+	if err := e.ReplaceRules(rules, nil); err != nil {
+		t.Fatalf("Failed to replace rules: %v", err)
+	}
+	if store.writes != 1 {
+		t.Errorf("Expected 1 store write, got %d", store.writes)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	testRuleStore(t, func() RuleStore { return store })
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	testRuleStore(t, func() RuleStore { return NewFileStore(path) })
+
+	rules, err := NewFileStore(path).List()
+	if err != nil || len(rules) != 2 {
+		t.Errorf("Unexpected stored rules: %v %v", rules, err)
+	}
+}
+
+func TestFileStoreErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	// 文件损坏时拒绝加载
+	path := filepath.Join(dir, "bad.json")
+	os.WriteFile(path, []byte("{"), 0o644)
+	if _, err := NewEngineWithStore(NewFileStore(path)); err == nil {
+		t.Errorf("Expected error for corrupt rule file")
+	}
+
+	// 写入失败时规则不生效
+	e, err := NewEngineWithStore(NewFileStore(filepath.Join(dir, "missing", "rules.json")))
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	if err := e.AddRule(&controller.PolicyRule{ID: 1, From: "web", To: "db", Action: "allow"}); err == nil {
+		t.Errorf("Expected save error")
+	}
+	if e.GetRule(1) != nil {
+		t.Errorf("Rule applied despite save failure")
+	}
+
+	// 整体替换写入失败时存储和引擎均保留原有规则
+	sub := filepath.Join(dir, "sub")
+	os.Mkdir(sub, 0o755)
+	store := NewFileStore(filepath.Join(sub, "rules.json"))
+	e, err = NewEngineWithStore(store)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	if err := e.AddRule(&controller.PolicyRule{ID: 1, From: "web", To: "db", Action: "allow"}); err != nil {
+		t.Fatalf("Failed to add rule: %v", err)
+	}
+	os.RemoveAll(sub)
+	err = e.ReplaceRules([]*controller.PolicyRule{{ID: 2, From: "app", To: "db", Action: "deny"}}, nil)
+	if err == nil {
+		t.Fatalf("Expected replace error")
+	}
+	if e.GetRule(1) == nil || e.GetRule(2) != nil {
+		t.Errorf("Engine rules changed despite replace failure")
+	}
+	if rules, _ := store.List(); len(rules) != 1 || rules[0].ID != 1 {
+		t.Errorf("Store rules changed despite replace failure: %+v", rules)
+	}
+}
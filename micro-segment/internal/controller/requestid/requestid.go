@@ -0,0 +1,70 @@
+// Package requestid 请求ID的生成、传递和关联日志
+// REST请求通过X-Request-ID头、gRPC请求通过x-request-id元数据携带，
+// 未携带或不合法时生成新ID，处理过程中的日志均带有request_id字段
+package requestid
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Header REST请求和响应携带请求ID的头
+	Header = "X-Request-ID"
+	// MetadataKey gRPC请求和响应携带请求ID的元数据键
+	MetadataKey = "x-request-id"
+	// LogField 日志中的请求ID字段
+	LogField = "request_id"
+)
+
+// maxLen 接受的客户端请求ID最大长度
+const maxLen = 64
+
+// contextKey 请求ID在context中的键
+type contextKey struct{}
+
+// Resolve 返回客户端携带的合法请求ID，未携带或不合法时生成新ID
+// 合法ID不超过maxLen个字符，仅包含字母、数字和-_.:
+func Resolve(id string) string {
+	if valid(id) {
+		return id
+	}
+	return uuid.NewString()
+}
+
+// valid 检查客户端请求ID，避免超长或含控制字符的值写入日志和响应头
+func valid(id string) bool {
+	if id == "" || len(id) > maxLen {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.' || c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// NewContext 返回携带请求ID的context
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext 获取context中的请求ID，不存在返回空
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Logger 返回带请求ID字段的日志记录器，context中没有请求ID时不带该字段
+func Logger(ctx context.Context) *log.Entry {
+	if id := FromContext(ctx); id != "" {
+		return log.WithField(LogField, id)
+	}
+	return log.NewEntry(log.StandardLogger())
+}
@@ -0,0 +1,32 @@
+package requestid
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	if id := Resolve("abc-123_x.y:z"); id != "abc-123_x.y:z" {
+		t.Errorf("Valid id replaced: %s", id)
+	}
+	for _, bad := range []string{"", "has space", "line\nbreak", strings.Repeat("a", maxLen+1)} {
+		id := Resolve(bad)
+		if id == bad || !valid(id) {
+			t.Errorf("Invalid id %q not replaced: %q", bad, id)
+		}
+	}
+	if Resolve("") == Resolve("") {
+		t.Errorf("Generated ids not unique")
+	}
+}
+
+func TestLogger(t *testing.T) {
+	if _, ok := Logger(context.Background()).Data[LogField]; ok {
+		t.Errorf("Unexpected request id without context value")
+	}
+	ctx := NewContext(context.Background(), "req-1")
+	if FromContext(ctx) != "req-1" || Logger(ctx).Data[LogField] != "req-1" {
+		t.Errorf("Request id not attached: %v", Logger(ctx).Data)
+	}
+}
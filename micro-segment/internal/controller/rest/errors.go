@@ -0,0 +1,97 @@
+// Package rest REST API错误码
+package rest
+
+import (
+	"errors"
+	"net/http"
+
+	ctrlgrpc "github.com/micro-segment/internal/controller/grpc"
+	"github.com/micro-segment/internal/controller/policy"
+)
+
+// ErrorCode 应用错误码，在响应体code字段返回，0表示成功
+// 错误码与HTTP状态码分离，取值保持稳定供客户端判断
+type ErrorCode int
+
+// 应用错误码
+const (
+	ErrValidation       ErrorCode = 1001 // 请求参数或请求体不合法
+	ErrNotFound         ErrorCode = 1002 // 资源不存在
+	ErrConflict         ErrorCode = 1003 // 资源状态冲突
+	ErrMethodNotAllowed ErrorCode = 1004 // 不支持的请求方法
+	ErrUnavailable      ErrorCode = 1005 // 功能不可用
+	ErrInternal         ErrorCode = 1006 // 内部错误
+)
+
+// HTTPStatus 错误码对应的HTTP状态码
+func (c ErrorCode) HTTPStatus() int {
+	switch c {
+	case ErrValidation:
+		return http.StatusBadRequest
+	case ErrNotFound:
+		return http.StatusNotFound
+	case ErrConflict:
+		return http.StatusConflict
+	case ErrMethodNotAllowed:
+		return http.StatusMethodNotAllowed
+	case ErrUnavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// APIError REST API错误
+type APIError struct {
+	Code    ErrorCode
+	Message string
+}
+
+// Error 实现error接口
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// newAPIError 创建API错误
+func newAPIError(code ErrorCode, message string) *APIError {
+	return &APIError{Code: code, Message: message}
+}
+
+// errMethodNotAllowed 路由方法不匹配时返回的错误
+var errMethodNotAllowed = newAPIError(ErrMethodNotAllowed, "method not allowed")
+
+// policyError 将策略引擎错误转换为API错误
+// 规则不存在返回ErrNotFound，引用不存在的组或应用及字段不合法返回ErrValidation
+func policyError(err error) error {
+	switch {
+	case errors.Is(err, policy.ErrRuleNotFound):
+		return newAPIError(ErrNotFound, err.Error())
+	case errors.Is(err, policy.ErrInvalidRule), errors.Is(err, policy.ErrUnknownGroup), errors.Is(err, policy.ErrUnknownApp):
+		return newAPIError(ErrValidation, err.Error())
+	}
+	return err
+}
+
+// commandError 将Agent命令错误映射为APIError
+func commandError(err error) error {
+	switch {
+	case errors.Is(err, ctrlgrpc.ErrInvalidCommand):
+		return newAPIError(ErrValidation, err.Error())
+	case errors.Is(err, ctrlgrpc.ErrAgentUnavailable):
+		return newAPIError(ErrNotFound, err.Error())
+	}
+	return err
+}
+
+// writeError 写入错误响应
+// APIError按其错误码返回，其他错误视为内部错误
+func writeError(w http.ResponseWriter, err error) {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		apiErr = newAPIError(ErrInternal, err.Error())
+	}
+	writeJSON(w, apiErr.Code.HTTPStatus(), Response{
+		Code:    int(apiErr.Code),
+		Message: apiErr.Message,
+	})
+}
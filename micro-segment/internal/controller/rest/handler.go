@@ -4,18 +4,51 @@ package rest
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
 
 	controller "github.com/micro-segment/internal/controller"
+	"github.com/micro-segment/internal/controller/alert"
 	"github.com/micro-segment/internal/controller/cache"
 	"github.com/micro-segment/internal/controller/policy"
+	"github.com/micro-segment/internal/controller/requestid"
 )
 
 // Handler REST API处理器
 type Handler struct {
-	cache  *cache.Cache
-	policy *policy.Engine
+	cache    *cache.Cache
+	policy   *policy.Engine
+	resyncer AgentResyncer
+	reports  ReportStats
+	agents   AgentLister
+	commands AgentCommander
+}
+
+// AgentResyncer 向Agent重新推送策略，由gRPC服务器实现
+type AgentResyncer interface {
+	ResyncAgent(agentID string) error
+}
+
+// AgentLister 列出Agent状态快照，由gRPC服务器实现
+type AgentLister interface {
+	ListAgentStates() []controller.AgentStateSnapshot
+	GetAgentCaptures(agentID string) (controller.AgentCaptures, bool)
+}
+
+// AgentCommander 排队和查询Agent远程命令，由gRPC服务器实现
+type AgentCommander interface {
+	QueueAgentCommand(agentID, cmdType, containerID string) (controller.AgentCommand, error)
+	ListAgentCommands(agentID string) []controller.AgentCommand
+}
+
+// ReportStats Agent上报统计，由gRPC服务器实现
+type ReportStats interface {
+	ReportSizeHistogram() []controller.HistogramBucket
 }
 
 // NewHandler 创建处理器
@@ -42,15 +75,6 @@ func writeJSON(w http.ResponseWriter, status int, data interface{}) {
 	json.NewEncoder(w).Encode(data)
 }
 
-// writeError 写入错误响应
-// 返回标准格式的错误响应
-func writeError(w http.ResponseWriter, status int, message string) {
-	writeJSON(w, status, Response{
-		Code:    status,
-		Message: message,
-	})
-}
-
 // writeSuccess 写入成功响应
 // 返回标准格式的成功响应
 func writeSuccess(w http.ResponseWriter, data interface{}) {
@@ -69,24 +93,67 @@ func (h *Handler) ListWorkloads(w http.ResponseWriter, r *http.Request) {
 	writeSuccess(w, workloads)
 }
 
+// ListIdleWorkloads 列出空闲工作负载
+// since为RFC3339时间，返回自该时间起没有连接活动的工作负载
+func (h *Handler) ListIdleWorkloads(w http.ResponseWriter, r *http.Request) {
+	s := r.URL.Query().Get("since")
+	if s == "" {
+		writeError(w, newAPIError(ErrValidation, "missing since"))
+		return
+	}
+	since, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		writeError(w, newAPIError(ErrValidation, "invalid since"))
+		return
+	}
+	writeSuccess(w, h.cache.IdleWorkloads(since))
+}
+
 // GetWorkload 获取工作负载
 // 根据ID查询单个工作负载详情
 func (h *Handler) GetWorkload(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Query().Get("id")
 	if id == "" {
-		writeError(w, http.StatusBadRequest, "missing workload id")
+		writeError(w, newAPIError(ErrValidation, "missing workload id"))
 		return
 	}
 
 	wl := h.cache.GetWorkload(id)
 	if wl == nil {
-		writeError(w, http.StatusNotFound, "workload not found")
+		writeError(w, newAPIError(ErrNotFound, "workload not found"))
 		return
 	}
 
 	writeSuccess(w, wl)
 }
 
+// ListExposure 列出公网暴露面
+// 返回被公网客户端访问过的内部工作负载及端口
+func (h *Handler) ListExposure(w http.ResponseWriter, r *http.Request) {
+	writeSuccess(w, h.cache.ListExposure())
+}
+
+// GetWorkloadPorts 获取工作负载端口清单
+// 返回连接中观察到的该工作负载作为服务端和客户端的端口及首末次出现时间
+func (h *Handler) GetWorkloadPorts(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		writeError(w, newAPIError(ErrValidation, "missing workload id"))
+		return
+	}
+
+	ports := h.cache.GetWorkloadPorts(id)
+	if ports == nil {
+		if h.cache.GetWorkload(id) == nil {
+			writeError(w, newAPIError(ErrNotFound, "workload not found"))
+			return
+		}
+		ports = &controller.WorkloadPorts{Server: []controller.PortUsage{}, Client: []controller.PortUsage{}}
+	}
+
+	writeSuccess(w, ports)
+}
+
 // --- 组API ---
 
 // ListGroups 列出组
@@ -101,13 +168,13 @@ func (h *Handler) ListGroups(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) GetGroup(w http.ResponseWriter, r *http.Request) {
 	name := r.URL.Query().Get("name")
 	if name == "" {
-		writeError(w, http.StatusBadRequest, "missing group name")
+		writeError(w, newAPIError(ErrValidation, "missing group name"))
 		return
 	}
 
 	group := h.cache.GetGroup(name)
 	if group == nil {
-		writeError(w, http.StatusNotFound, "group not found")
+		writeError(w, newAPIError(ErrNotFound, "group not found"))
 		return
 	}
 
@@ -119,29 +186,122 @@ func (h *Handler) GetGroup(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) CreateGroup(w http.ResponseWriter, r *http.Request) {
 	var group controller.Group
 	if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid request body")
+		writeError(w, newAPIError(ErrValidation, "invalid request body"))
 		return
 	}
 
 	if group.Name == "" {
-		writeError(w, http.StatusBadRequest, "missing group name")
+		writeError(w, newAPIError(ErrValidation, "missing group name"))
+		return
+	}
+	if group.PolicyMode != "" && !validPolicyMode(group.PolicyMode) {
+		writeError(w, newAPIError(ErrValidation, "invalid policy mode"))
 		return
 	}
 
+	now := time.Now()
+	group.CreatedAt = now
+	group.UpdatedAt = now
+	if group.PolicyMode != "" {
+		h.policy.SetGroupMode(group.Name, group.PolicyMode)
+	} else {
+		h.policy.ClearGroupMode(group.Name)
+	}
 	h.cache.AddGroup(&group)
+	h.policy.SetGroupDisabled(group.Name, group.Disabled)
+	writeSuccess(w, group)
+}
+
+// UpdateGroup 更新组
+// 更新安全组属性，包括启用/禁用状态
+func (h *Handler) UpdateGroup(w http.ResponseWriter, r *http.Request) {
+	var group controller.Group
+	if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
+		writeError(w, newAPIError(ErrValidation, "invalid request body"))
+		return
+	}
+
+	if group.Name == "" {
+		writeError(w, newAPIError(ErrValidation, "missing group name"))
+		return
+	}
+
+	old := h.cache.GetGroup(group.Name)
+	if old == nil {
+		writeError(w, newAPIError(ErrNotFound, "group not found"))
+		return
+	}
+
+	// 未指定模式时保留原模式，避免清空已传播到成员的组模式
+	if group.PolicyMode == "" {
+		group.PolicyMode = old.PolicyMode
+	} else if !validPolicyMode(group.PolicyMode) {
+		writeError(w, newAPIError(ErrValidation, "invalid policy mode"))
+		return
+	}
+
+	group.CreatedAt = old.CreatedAt
+	group.UpdatedAt = time.Now()
+	if group.PolicyMode != "" {
+		h.policy.SetGroupMode(group.Name, group.PolicyMode)
+	}
+	h.cache.UpdateGroup(&group)
+	h.policy.SetGroupDisabled(group.Name, group.Disabled)
 	writeSuccess(w, group)
 }
 
+// validPolicyMode 检查策略模式是否合法
+func validPolicyMode(mode controller.PolicyMode) bool {
+	return mode == controller.PolicyModeMonitor || mode == controller.PolicyModeProtect
+}
+
+// GroupModeRequest 设置组策略模式请求
+type GroupModeRequest struct {
+	Name       string                `json:"name"`
+	PolicyMode controller.PolicyMode `json:"policy_mode"`
+}
+
+// SetGroupMode 设置组策略模式
+// 模式级联到组内所有成员工作负载，并向成员所属Agent重新推送
+func (h *Handler) SetGroupMode(w http.ResponseWriter, r *http.Request) {
+	var req GroupModeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, newAPIError(ErrValidation, "invalid request body"))
+		return
+	}
+	if req.Name == "" {
+		writeError(w, newAPIError(ErrValidation, "missing group name"))
+		return
+	}
+	if !validPolicyMode(req.PolicyMode) {
+		writeError(w, newAPIError(ErrValidation, "invalid policy mode"))
+		return
+	}
+	if h.cache.GetGroup(req.Name) == nil {
+		writeError(w, newAPIError(ErrNotFound, "group not found"))
+		return
+	}
+
+	h.policy.SetGroupMode(req.Name, req.PolicyMode)
+	h.cache.ApplyGroupMode(req.Name, req.PolicyMode)
+	requestid.Logger(r.Context()).WithFields(log.Fields{
+		"group": req.Name, "mode": req.PolicyMode,
+	}).Info("Group policy mode changed")
+	writeSuccess(w, h.cache.GetGroup(req.Name))
+}
+
 // DeleteGroup 删除组
 // 根据名称删除安全组
 func (h *Handler) DeleteGroup(w http.ResponseWriter, r *http.Request) {
 	name := r.URL.Query().Get("name")
 	if name == "" {
-		writeError(w, http.StatusBadRequest, "missing group name")
+		writeError(w, newAPIError(ErrValidation, "missing group name"))
 		return
 	}
 
 	h.cache.DeleteGroup(name)
+	h.policy.SetGroupDisabled(name, false)
+	h.policy.ClearGroupMode(name)
 	writeSuccess(w, nil)
 }
 
@@ -154,24 +314,46 @@ func (h *Handler) ListPolicies(w http.ResponseWriter, r *http.Request) {
 	writeSuccess(w, rules)
 }
 
+// RecommendPolicies 推荐策略
+// 根据Monitor模式组的已观察连接生成候选allow规则，仅返回不应用
+func (h *Handler) RecommendPolicies(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("group")
+	if name == "" {
+		writeError(w, newAPIError(ErrValidation, "missing group name"))
+		return
+	}
+
+	group := h.cache.GetGroup(name)
+	if group == nil {
+		writeError(w, newAPIError(ErrNotFound, "group not found"))
+		return
+	}
+	if group.PolicyMode != "" && group.PolicyMode != controller.PolicyModeMonitor {
+		writeError(w, newAPIError(ErrConflict, "group not in Monitor mode"))
+		return
+	}
+
+	writeSuccess(w, h.cache.RecommendPolicies(name))
+}
+
 // GetPolicy 获取策略
 // 根据ID查询单个策略规则详情
 func (h *Handler) GetPolicy(w http.ResponseWriter, r *http.Request) {
 	idStr := r.URL.Query().Get("id")
 	if idStr == "" {
-		writeError(w, http.StatusBadRequest, "missing policy id")
+		writeError(w, newAPIError(ErrValidation, "missing policy id"))
 		return
 	}
 
 	id, err := strconv.ParseUint(idStr, 10, 32)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, "invalid policy id")
+		writeError(w, newAPIError(ErrValidation, "invalid policy id"))
 		return
 	}
 
 	rule := h.policy.GetRule(uint32(id))
 	if rule == nil {
-		writeError(w, http.StatusNotFound, "policy not found")
+		writeError(w, newAPIError(ErrNotFound, "policy not found"))
 		return
 	}
 
@@ -183,15 +365,16 @@ func (h *Handler) GetPolicy(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) CreatePolicy(w http.ResponseWriter, r *http.Request) {
 	var rule controller.PolicyRule
 	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid request body")
+		writeError(w, newAPIError(ErrValidation, "invalid request body"))
 		return
 	}
 
 	if err := h.policy.AddRule(&rule); err != nil {
-		writeError(w, http.StatusBadRequest, err.Error())
+		writeError(w, policyError(err))
 		return
 	}
 
+	requestid.Logger(r.Context()).WithField("id", rule.ID).Info("Policy rule created")
 	writeSuccess(w, rule)
 }
 
@@ -200,15 +383,16 @@ func (h *Handler) CreatePolicy(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) UpdatePolicy(w http.ResponseWriter, r *http.Request) {
 	var rule controller.PolicyRule
 	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid request body")
+		writeError(w, newAPIError(ErrValidation, "invalid request body"))
 		return
 	}
 
 	if err := h.policy.UpdateRule(&rule); err != nil {
-		writeError(w, http.StatusNotFound, err.Error())
+		writeError(w, policyError(err))
 		return
 	}
 
+	requestid.Logger(r.Context()).WithField("id", rule.ID).Info("Policy rule updated")
 	writeSuccess(w, rule)
 }
 
@@ -216,32 +400,237 @@ func (h *Handler) UpdatePolicy(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) DeletePolicy(w http.ResponseWriter, r *http.Request) {
 	idStr := r.URL.Query().Get("id")
 	if idStr == "" {
-		writeError(w, http.StatusBadRequest, "missing policy id")
+		writeError(w, newAPIError(ErrValidation, "missing policy id"))
 		return
 	}
 
 	id, err := strconv.ParseUint(idStr, 10, 32)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, "invalid policy id")
+		writeError(w, newAPIError(ErrValidation, "invalid policy id"))
 		return
 	}
 
 	if err := h.policy.DeleteRule(uint32(id)); err != nil {
-		writeError(w, http.StatusNotFound, err.Error())
+		writeError(w, policyError(err))
 		return
 	}
+	requestid.Logger(r.Context()).WithField("id", id).Info("Policy rule deleted")
 
 	writeSuccess(w, nil)
 }
 
+// DeletePolicies 按条件批量删除策略
+// 支持from、to、action、disabled条件，同时指定时需全部满足；
+// 至少指定一个条件，或以all=true删除全部规则，返回已删除的规则ID
+func (h *Handler) DeletePolicies(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	from, to, action := query.Get("from"), query.Get("to"), query.Get("action")
+	var disabled *bool
+	if s := query.Get("disabled"); s != "" {
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			writeError(w, newAPIError(ErrValidation, "invalid disabled"))
+			return
+		}
+		disabled = &v
+	}
+	if from == "" && to == "" && action == "" && disabled == nil && query.Get("all") != "true" {
+		writeError(w, newAPIError(ErrValidation, "missing filter, use all=true to delete all rules"))
+		return
+	}
+
+	deleted, err := h.policy.DeleteRulesWhere(func(rule *controller.PolicyRule) bool {
+		return (from == "" || rule.From == from) && (to == "" || rule.To == to) &&
+			(action == "" || rule.Action == action) && (disabled == nil || rule.Disable == *disabled)
+	})
+	logger := requestid.Logger(r.Context()).WithField("ids", deleted)
+	if err != nil {
+		logger.WithError(err).Warn("Policy rules partially deleted")
+		writeError(w, err)
+		return
+	}
+	logger.Info("Policy rules deleted")
+
+	writeSuccess(w, deleted)
+}
+
 // --- 网络拓扑API ---
 
 // GetNetworkGraph 获取网络拓扑图
+// 支持domain参数按域（K8s namespace）过滤，链接附带产生策略动作的规则备注；
+// external_prefix/external_prefix6参数指定外部端点按IPv4/IPv6网段聚合的前缀长度；
+// level=service时按服务合并工作负载，链接附带按应用聚合的流量和端口
 func (h *Handler) GetNetworkGraph(w http.ResponseWriter, r *http.Request) {
-	graph := h.cache.GetNetworkGraph()
+	query := r.URL.Query()
+	var agg cache.ExternalAggregation
+	for _, p := range []struct {
+		name string
+		max  int
+		val  *int
+	}{
+		{"external_prefix", 32, &agg.IPv4Prefix},
+		{"external_prefix6", 128, &agg.IPv6Prefix},
+	} {
+		s := query.Get(p.name)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > p.max {
+			writeError(w, newAPIError(ErrValidation, "invalid "+p.name))
+			return
+		}
+		*p.val = n
+	}
+
+	var prune cache.GraphPrune
+	if s := query.Get("min_bytes"); s != "" {
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			writeError(w, newAPIError(ErrValidation, "invalid min_bytes"))
+			return
+		}
+		prune.MinBytes = n
+	}
+	if s := query.Get("min_sessions"); s != "" {
+		n, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			writeError(w, newAPIError(ErrValidation, "invalid min_sessions"))
+			return
+		}
+		prune.MinSessions = uint32(n)
+	}
+	if s := query.Get("keep_policy"); s != "" {
+		keep, err := strconv.ParseBool(s)
+		if err != nil {
+			writeError(w, newAPIError(ErrValidation, "invalid keep_policy"))
+			return
+		}
+		prune.KeepPolicy = keep
+	}
+
+	var graph *controller.NetworkGraph
+	switch query.Get("level") {
+	case "", "workload":
+		graph = h.cache.GetAggregatedNetworkGraph(query.Get("domain"), agg)
+	case "service":
+		graph = h.cache.GetServiceGraph(query.Get("domain"), agg)
+	default:
+		writeError(w, newAPIError(ErrValidation, "invalid level"))
+		return
+	}
+	cache.PruneNetworkGraph(graph, prune)
+	for i := range graph.Links {
+		link := &graph.Links[i]
+		for j := range link.Applications {
+			if app := &link.Applications[j]; app.Application != 0 {
+				app.Name = policy.AppName(app.Application)
+			}
+		}
+		if link.PolicyID == 0 {
+			continue
+		}
+		if rule := h.policy.GetRule(link.PolicyID); rule != nil {
+			link.PolicyComment = rule.Comment
+		}
+	}
 	writeSuccess(w, graph)
 }
 
+// defaultGraphStatsTop 图统计默认返回的节点数
+const defaultGraphStatsTop = 10
+
+// GetGraphStats 获取拓扑图节点度数统计
+// top参数指定各排行返回的节点数，默认10，超过上限时截断
+func (h *Handler) GetGraphStats(w http.ResponseWriter, r *http.Request) {
+	top := defaultGraphStatsTop
+	if s := r.URL.Query().Get("top"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			writeError(w, newAPIError(ErrValidation, "invalid top"))
+			return
+		}
+		top = n
+	}
+	writeSuccess(w, h.cache.GetGraphStats(top))
+}
+
+// GetGraphCommunities 获取拓扑图社区划分
+// 用于可视化时将紧密连接的节点分组显示
+func (h *Handler) GetGraphCommunities(w http.ResponseWriter, r *http.Request) {
+	writeSuccess(w, h.cache.GetGraphCommunities())
+}
+
+// --- 连接API ---
+
+// ListConnections 列出连接
+func (h *Handler) ListConnections(w http.ResponseWriter, r *http.Request) {
+	conns := h.cache.ListConnections()
+	writeSuccess(w, conns)
+}
+
+// RecomputeConnections 按当前策略重新计算全部连接的策略动作
+// 同步更新连接和拓扑链接的动作及规则ID，返回动作发生变化的连接数
+func (h *Handler) RecomputeConnections(w http.ResponseWriter, r *http.Request) {
+	n := h.cache.ReevaluateConnections(nil, true, h.policy.MatchConnection)
+	writeSuccess(w, map[string]int{"changed": n})
+}
+
+// ListViolations 列出违规和审计记录
+func (h *Handler) ListViolations(w http.ResponseWriter, r *http.Request) {
+	violations := h.cache.ListViolations()
+	writeSuccess(w, violations)
+}
+
+// ListFirstContacts 列出首次通信事件
+func (h *Handler) ListFirstContacts(w http.ResponseWriter, r *http.Request) {
+	writeSuccess(w, h.cache.ListFirstContacts())
+}
+
+// ListThreats 查询威胁日志
+// 支持severity（严重级别下限）、client_wl、server_wl、threat_id及since、until（RFC3339）条件，
+// 按上报时间从新到旧排列
+func (h *Handler) ListThreats(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := cache.ThreatFilter{
+		ClientWL: query.Get("client_wl"),
+		ServerWL: query.Get("server_wl"),
+	}
+	if s := query.Get("severity"); s != "" {
+		severity, err := alert.ParseSeverity(s)
+		if err != nil {
+			writeError(w, newAPIError(ErrValidation, "invalid severity"))
+			return
+		}
+		filter.MinSeverity = severity
+	}
+	if s := query.Get("threat_id"); s != "" {
+		id, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			writeError(w, newAPIError(ErrValidation, "invalid threat_id"))
+			return
+		}
+		filter.ThreatID = uint32(id)
+	}
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{{"since", &filter.Since}, {"until", &filter.Until}} {
+		s := query.Get(p.name)
+		if s == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			writeError(w, newAPIError(ErrValidation, "invalid "+p.name))
+			return
+		}
+		*p.t = t
+	}
+
+	writeSuccess(w, h.cache.QueryThreats(filter))
+}
+
 // --- 主机API ---
 
 // ListHosts 列出主机
@@ -254,10 +643,224 @@ func (h *Handler) ListHosts(w http.ResponseWriter, r *http.Request) {
 
 // ListAgents 列出Agent
 func (h *Handler) ListAgents(w http.ResponseWriter, r *http.Request) {
+	if h.agents != nil {
+		writeSuccess(w, h.agents.ListAgentStates())
+		return
+	}
 	agents := h.cache.ListAgents()
 	writeSuccess(w, agents)
 }
 
+// GetAgentCaptures 获取Agent正在捕获流量的容器
+// 为Agent最近一次心跳上报的列表，用于排查工作负载没有流量的原因
+func (h *Handler) GetAgentCaptures(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("agent_id")
+	if id == "" {
+		writeError(w, newAPIError(ErrValidation, "missing agent id"))
+		return
+	}
+	if h.agents == nil {
+		writeError(w, newAPIError(ErrUnavailable, "agent state not available"))
+		return
+	}
+
+	captures, ok := h.agents.GetAgentCaptures(id)
+	if !ok {
+		writeError(w, newAPIError(ErrNotFound, "agent not found"))
+		return
+	}
+	writeSuccess(w, captures)
+}
+
+// ResyncAgent 强制向Agent重新推送策略
+// Agent不存在或已离线时返回404
+func (h *Handler) ResyncAgent(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		writeError(w, newAPIError(ErrValidation, "missing agent id"))
+		return
+	}
+	if h.resyncer == nil {
+		writeError(w, newAPIError(ErrUnavailable, "agent resync not available"))
+		return
+	}
+
+	if err := h.resyncer.ResyncAgent(id); err != nil {
+		writeError(w, newAPIError(ErrNotFound, err.Error()))
+		return
+	}
+	requestid.Logger(r.Context()).WithField("agent_id", id).Info("Agent resync requested via REST")
+	writeSuccess(w, nil)
+}
+
+// agentCommandRequest 下发Agent命令请求体
+type agentCommandRequest struct {
+	Type        string `json:"type"`
+	ContainerID string `json:"container_id"`
+}
+
+// ListAgentCommands 列出Agent最近的命令及执行结果
+func (h *Handler) ListAgentCommands(w http.ResponseWriter, r *http.Request) {
+	if h.commands == nil {
+		writeError(w, newAPIError(ErrUnavailable, "agent commands not available"))
+		return
+	}
+	writeSuccess(w, h.commands.ListAgentCommands(r.PathValue("id")))
+}
+
+// QueueAgentCommand 为Agent排队远程命令，随其下一次心跳下发
+// Agent不存在或已离线时返回404
+func (h *Handler) QueueAgentCommand(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		writeError(w, newAPIError(ErrValidation, "missing agent id"))
+		return
+	}
+	if h.commands == nil {
+		writeError(w, newAPIError(ErrUnavailable, "agent commands not available"))
+		return
+	}
+
+	var req agentCommandRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, newAPIError(ErrValidation, "invalid request body"))
+		return
+	}
+
+	cmd, err := h.commands.QueueAgentCommand(id, req.Type, req.ContainerID)
+	if err != nil {
+		writeError(w, commandError(err))
+		return
+	}
+	requestid.Logger(r.Context()).WithFields(log.Fields{
+		"agent_id": id, "command": cmd.Type, "command_id": cmd.ID,
+	}).Info("Agent command requested via REST")
+	writeSuccess(w, cmd)
+}
+
+// --- 配置API ---
+
+// ExportConfig 导出集群配置
+// 返回包含组、策略、组模式和运行参数的单个版本化文档，不包含连接和Agent等运行时状态
+func (h *Handler) ExportConfig(w http.ResponseWriter, r *http.Request) {
+	groups := h.cache.ListGroups()
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+
+	modes := make(map[string]controller.PolicyMode)
+	for _, group := range groups {
+		if group.PolicyMode != "" {
+			modes[group.Name] = group.PolicyMode
+		}
+	}
+
+	writeSuccess(w, controller.ClusterConfig{
+		Version:    controller.ClusterConfigVersion,
+		ExportedAt: time.Now(),
+		Groups:     groups,
+		Policies:   h.policy.ListRules(),
+		GroupModes: modes,
+		Settings:   h.cache.Settings(),
+	})
+}
+
+// validateConfig 校验导入的集群配置，返回文档中的组名集合
+func validateConfig(cfg *controller.ClusterConfig) (map[string]bool, error) {
+	if cfg.Version != controller.ClusterConfigVersion {
+		return nil, newAPIError(ErrValidation, fmt.Sprintf("unsupported config version %d, expected %d",
+			cfg.Version, controller.ClusterConfigVersion))
+	}
+
+	names := make(map[string]bool, len(cfg.Groups))
+	for _, group := range cfg.Groups {
+		if group == nil || group.Name == "" {
+			return nil, newAPIError(ErrValidation, "missing group name")
+		}
+		if names[group.Name] {
+			return nil, newAPIError(ErrValidation, fmt.Sprintf("duplicate group %q", group.Name))
+		}
+		if group.PolicyMode != "" && !validPolicyMode(group.PolicyMode) {
+			return nil, newAPIError(ErrValidation, fmt.Sprintf("invalid policy mode for group %q", group.Name))
+		}
+		names[group.Name] = true
+	}
+	for name, mode := range cfg.GroupModes {
+		if !names[name] {
+			return nil, newAPIError(ErrValidation, fmt.Sprintf("group mode for unknown group %q", name))
+		}
+		if !validPolicyMode(mode) {
+			return nil, newAPIError(ErrValidation, fmt.Sprintf("invalid policy mode for group %q", name))
+		}
+	}
+	for _, rule := range cfg.Policies {
+		if rule == nil {
+			return nil, newAPIError(ErrValidation, "invalid policy")
+		}
+	}
+	if cfg.Settings.ConnectionTTL == 0 {
+		return nil, newAPIError(ErrValidation, "connection_ttl must be positive")
+	}
+	return names, nil
+}
+
+// ImportConfig 导入集群配置
+// 先校验整个文档，全部合法后整体替换组、策略、组模式和运行参数，任一不合法时不做修改
+func (h *Handler) ImportConfig(w http.ResponseWriter, r *http.Request) {
+	var cfg controller.ClusterConfig
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		writeError(w, newAPIError(ErrValidation, "invalid request body"))
+		return
+	}
+
+	names, err := validateConfig(&cfg)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	// 规则最后校验且校验通过后立即生效，此后的组和参数替换不会失败
+	if err := h.policy.ReplaceRules(cfg.Policies, func(name string) bool { return names[name] }); err != nil {
+		writeError(w, policyError(err))
+		return
+	}
+
+	now := time.Now()
+	for _, group := range cfg.Groups {
+		if mode, ok := cfg.GroupModes[group.Name]; ok {
+			group.PolicyMode = mode
+		}
+		if group.CreatedAt.IsZero() {
+			group.CreatedAt = now
+		}
+		group.UpdatedAt = now
+	}
+	// 引擎中的组状态与导入的组一致：删除的组和未指定模式的组清除原模式
+	for _, old := range h.cache.ListGroups() {
+		if !names[old.Name] {
+			h.policy.SetGroupDisabled(old.Name, false)
+			h.policy.ClearGroupMode(old.Name)
+		}
+	}
+	for _, group := range cfg.Groups {
+		if group.PolicyMode != "" {
+			h.policy.SetGroupMode(group.Name, group.PolicyMode)
+		} else {
+			h.policy.ClearGroupMode(group.Name)
+		}
+		h.policy.SetGroupDisabled(group.Name, group.Disabled)
+	}
+	h.cache.ReplaceGroups(cfg.Groups)
+	h.cache.SetConnectionTTL(time.Duration(cfg.Settings.ConnectionTTL) * time.Second)
+	h.cache.SetSeverityQuietPeriod(time.Duration(cfg.Settings.SeverityQuiet) * time.Second)
+
+	requestid.Logger(r.Context()).WithFields(log.Fields{
+		"groups":   len(cfg.Groups),
+		"policies": len(cfg.Policies),
+	}).Info("Cluster config imported")
+	writeSuccess(w, map[string]int{
+		"groups":   len(cfg.Groups),
+		"policies": len(cfg.Policies),
+	})
+}
+
 // --- 统计API ---
 
 // GetStats 获取统计信息
@@ -271,5 +874,8 @@ func (h *Handler) GetStats(w http.ResponseWriter, r *http.Request) {
 		"graph_nodes": h.cache.GetGraphNodeCount(),
 		"graph_links": h.cache.GetGraphLinkCount(),
 	}
+	if h.reports != nil {
+		stats["report_sizes"] = h.reports.ReportSizeHistogram()
+	}
 	writeSuccess(w, stats)
 }
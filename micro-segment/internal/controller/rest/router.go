@@ -4,8 +4,11 @@ package rest
 import (
 	"net/http"
 
+	log "github.com/sirupsen/logrus"
+
 	"github.com/micro-segment/internal/controller/cache"
 	"github.com/micro-segment/internal/controller/policy"
+	"github.com/micro-segment/internal/controller/requestid"
 )
 
 // Router REST API路由器
@@ -28,24 +31,47 @@ func NewRouter(c *cache.Cache, p *policy.Engine) *Router {
 func (r *Router) setupRoutes() {
 	// 工作负载
 	r.mux.HandleFunc("/api/v1/workloads", r.handleWorkloads)
+	r.mux.HandleFunc("/api/v1/workloads/idle", r.handleIdleWorkloads)
 	r.mux.HandleFunc("/api/v1/workload", r.handleWorkload)
+	r.mux.HandleFunc("/api/v1/workload/ports", r.handleWorkloadPorts)
+	r.mux.HandleFunc("/api/v1/exposure", r.handleExposure)
 
 	// 组
 	r.mux.HandleFunc("/api/v1/groups", r.handleGroups)
 	r.mux.HandleFunc("/api/v1/group", r.handleGroup)
+	r.mux.HandleFunc("/api/v1/group/mode", r.handleGroupMode)
 
 	// 策略
 	r.mux.HandleFunc("/api/v1/policies", r.handlePolicies)
+	r.mux.HandleFunc("/api/v1/policies/recommend", r.handlePolicyRecommend)
 	r.mux.HandleFunc("/api/v1/policy", r.handlePolicy)
 
 	// 网络拓扑
 	r.mux.HandleFunc("/api/v1/graph", r.handleGraph)
+	r.mux.HandleFunc("/api/v1/graph/stats", r.handleGraphStats)
+	r.mux.HandleFunc("/api/v1/graph/communities", r.handleGraphCommunities)
+
+	// 连接
+	r.mux.HandleFunc("/api/v1/connections", r.handleConnections)
+	r.mux.HandleFunc("/api/v1/connections/recompute", r.handleConnectionsRecompute)
+	r.mux.HandleFunc("/api/v1/violations", r.handleViolations)
+	r.mux.HandleFunc("/api/v1/threats", r.handleThreats)
+
+	// 事件
+	r.mux.HandleFunc("/api/v1/events/first-contact", r.handleFirstContacts)
 
 	// 主机
 	r.mux.HandleFunc("/api/v1/hosts", r.handleHosts)
 
 	// Agent
 	r.mux.HandleFunc("/api/v1/agents", r.handleAgents)
+	r.mux.HandleFunc("/api/v1/agents/captures", r.handleAgentCaptures)
+	r.mux.HandleFunc("/api/v1/agents/{id}/resync", r.handleAgentResync)
+	r.mux.HandleFunc("/api/v1/agents/{id}/commands", r.handleAgentCommands)
+
+	// 集群配置
+	r.mux.HandleFunc("/api/v1/config/export", r.handleConfigExport)
+	r.mux.HandleFunc("/api/v1/config/import", r.handleConfigImport)
 
 	// 统计
 	r.mux.HandleFunc("/api/v1/stats", r.handleStats)
@@ -54,18 +80,56 @@ func (r *Router) setupRoutes() {
 	r.mux.HandleFunc("/health", r.handleHealth)
 }
 
+// SetAgentResyncer 设置Agent策略重新推送实现
+func (r *Router) SetAgentResyncer(resyncer AgentResyncer) {
+	r.handler.resyncer = resyncer
+}
+
+// SetAgentLister 设置Agent状态来源
+// 设置后Agent列表返回gRPC服务器维护的在线状态与心跳统计
+func (r *Router) SetAgentLister(agents AgentLister) {
+	r.handler.agents = agents
+}
+
+// SetAgentCommander 设置Agent远程命令实现
+func (r *Router) SetAgentCommander(commands AgentCommander) {
+	r.handler.commands = commands
+}
+
+// SetReportStats 设置Agent上报统计来源
+func (r *Router) SetReportStats(reports ReportStats) {
+	r.handler.reports = reports
+}
+
 // ServeHTTP 实现http.Handler接口
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	// CORS
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+requestid.Header)
+	w.Header().Set("Access-Control-Expose-Headers", requestid.Header)
+
+	// 关联请求ID，处理过程中的日志均带有该ID
+	id := requestid.Resolve(req.Header.Get(requestid.Header))
+	w.Header().Set(requestid.Header, id)
+	req = req.WithContext(requestid.NewContext(req.Context(), id))
+	requestid.Logger(req.Context()).WithFields(log.Fields{
+		"method": req.Method,
+		"path":   req.URL.Path,
+	}).Debug("REST request")
 
 	if req.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
+	// 客户端接受gzip时压缩较大的JSON响应
+	if acceptsGzip(req) {
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.Close()
+		w = gw
+	}
+
 	r.mux.ServeHTTP(w, req)
 }
 
@@ -75,7 +139,17 @@ func (r *Router) handleWorkloads(w http.ResponseWriter, req *http.Request) {
 	case http.MethodGet:
 		r.handler.ListWorkloads(w, req)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, errMethodNotAllowed)
+	}
+}
+
+// handleIdleWorkloads 处理空闲工作负载列表
+func (r *Router) handleIdleWorkloads(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		r.handler.ListIdleWorkloads(w, req)
+	default:
+		writeError(w, errMethodNotAllowed)
 	}
 }
 
@@ -85,7 +159,27 @@ func (r *Router) handleWorkload(w http.ResponseWriter, req *http.Request) {
 	case http.MethodGet:
 		r.handler.GetWorkload(w, req)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, errMethodNotAllowed)
+	}
+}
+
+// handleWorkloadPorts 处理工作负载端口清单
+func (r *Router) handleWorkloadPorts(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		r.handler.GetWorkloadPorts(w, req)
+	default:
+		writeError(w, errMethodNotAllowed)
+	}
+}
+
+// handleExposure 处理公网暴露面
+func (r *Router) handleExposure(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		r.handler.ListExposure(w, req)
+	default:
+		writeError(w, errMethodNotAllowed)
 	}
 }
 
@@ -95,7 +189,7 @@ func (r *Router) handleGroups(w http.ResponseWriter, req *http.Request) {
 	case http.MethodGet:
 		r.handler.ListGroups(w, req)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, errMethodNotAllowed)
 	}
 }
 
@@ -106,10 +200,22 @@ func (r *Router) handleGroup(w http.ResponseWriter, req *http.Request) {
 		r.handler.GetGroup(w, req)
 	case http.MethodPost:
 		r.handler.CreateGroup(w, req)
+	case http.MethodPut:
+		r.handler.UpdateGroup(w, req)
 	case http.MethodDelete:
 		r.handler.DeleteGroup(w, req)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, errMethodNotAllowed)
+	}
+}
+
+// handleGroupMode 处理组策略模式
+func (r *Router) handleGroupMode(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPut:
+		r.handler.SetGroupMode(w, req)
+	default:
+		writeError(w, errMethodNotAllowed)
 	}
 }
 
@@ -118,8 +224,20 @@ func (r *Router) handlePolicies(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodGet:
 		r.handler.ListPolicies(w, req)
+	case http.MethodDelete:
+		r.handler.DeletePolicies(w, req)
+	default:
+		writeError(w, errMethodNotAllowed)
+	}
+}
+
+// handlePolicyRecommend 处理策略推荐
+func (r *Router) handlePolicyRecommend(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		r.handler.RecommendPolicies(w, req)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, errMethodNotAllowed)
 	}
 }
 
@@ -135,7 +253,7 @@ func (r *Router) handlePolicy(w http.ResponseWriter, req *http.Request) {
 	case http.MethodDelete:
 		r.handler.DeletePolicy(w, req)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, errMethodNotAllowed)
 	}
 }
 
@@ -145,7 +263,77 @@ func (r *Router) handleGraph(w http.ResponseWriter, req *http.Request) {
 	case http.MethodGet:
 		r.handler.GetNetworkGraph(w, req)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, errMethodNotAllowed)
+	}
+}
+
+// handleGraphStats 处理拓扑图度数统计
+func (r *Router) handleGraphStats(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		r.handler.GetGraphStats(w, req)
+	default:
+		writeError(w, errMethodNotAllowed)
+	}
+}
+
+// handleGraphCommunities 处理拓扑图社区划分
+func (r *Router) handleGraphCommunities(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		r.handler.GetGraphCommunities(w, req)
+	default:
+		writeError(w, errMethodNotAllowed)
+	}
+}
+
+// handleConnections 处理连接列表
+func (r *Router) handleConnections(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		r.handler.ListConnections(w, req)
+	default:
+		writeError(w, errMethodNotAllowed)
+	}
+}
+
+// handleConnectionsRecompute 处理连接策略动作重新计算
+func (r *Router) handleConnectionsRecompute(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		r.handler.RecomputeConnections(w, req)
+	default:
+		writeError(w, errMethodNotAllowed)
+	}
+}
+
+// handleViolations 处理违规和审计记录
+func (r *Router) handleViolations(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		r.handler.ListViolations(w, req)
+	default:
+		writeError(w, errMethodNotAllowed)
+	}
+}
+
+// handleFirstContacts 处理首次通信事件
+func (r *Router) handleFirstContacts(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		r.handler.ListFirstContacts(w, req)
+	default:
+		writeError(w, errMethodNotAllowed)
+	}
+}
+
+// handleThreats 处理威胁日志查询
+func (r *Router) handleThreats(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		r.handler.ListThreats(w, req)
+	default:
+		writeError(w, errMethodNotAllowed)
 	}
 }
 
@@ -155,7 +343,7 @@ func (r *Router) handleHosts(w http.ResponseWriter, req *http.Request) {
 	case http.MethodGet:
 		r.handler.ListHosts(w, req)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, errMethodNotAllowed)
 	}
 }
 
@@ -165,7 +353,59 @@ func (r *Router) handleAgents(w http.ResponseWriter, req *http.Request) {
 	case http.MethodGet:
 		r.handler.ListAgents(w, req)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, errMethodNotAllowed)
+	}
+}
+
+// handleAgentResync 处理Agent策略重新推送
+func (r *Router) handleAgentResync(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		r.handler.ResyncAgent(w, req)
+	default:
+		writeError(w, errMethodNotAllowed)
+	}
+}
+
+// handleAgentCaptures 处理Agent正在捕获的容器
+func (r *Router) handleAgentCaptures(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		r.handler.GetAgentCaptures(w, req)
+	default:
+		writeError(w, errMethodNotAllowed)
+	}
+}
+
+// handleAgentCommands 处理Agent远程命令
+func (r *Router) handleAgentCommands(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		r.handler.ListAgentCommands(w, req)
+	case http.MethodPost:
+		r.handler.QueueAgentCommand(w, req)
+	default:
+		writeError(w, errMethodNotAllowed)
+	}
+}
+
+// handleConfigExport 处理集群配置导出
+func (r *Router) handleConfigExport(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		r.handler.ExportConfig(w, req)
+	default:
+		writeError(w, errMethodNotAllowed)
+	}
+}
+
+// handleConfigImport 处理集群配置导入
+func (r *Router) handleConfigImport(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		r.handler.ImportConfig(w, req)
+	default:
+		writeError(w, errMethodNotAllowed)
 	}
 }
 
@@ -175,7 +415,7 @@ func (r *Router) handleStats(w http.ResponseWriter, req *http.Request) {
 	case http.MethodGet:
 		r.handler.GetStats(w, req)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, errMethodNotAllowed)
 	}
 }
 
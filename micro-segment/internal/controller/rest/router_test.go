@@ -0,0 +1,868 @@
+package rest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	logtest "github.com/sirupsen/logrus/hooks/test"
+
+	pb "github.com/micro-segment/api/proto"
+	controller "github.com/micro-segment/internal/controller"
+	"github.com/micro-segment/internal/controller/cache"
+	"github.com/micro-segment/internal/controller/policy"
+	"github.com/micro-segment/internal/controller/requestid"
+)
+
+// newTestRouter 创建带有足够连接数据的路由器，连接列表超过压缩阈值
+func newTestRouter() *Router {
+	c := cache.NewCache()
+	for i := 0; i < 50; i++ {
+		c.UpdateConnection(&controller.Connection{
+			ClientWL: fmt.Sprintf("client-%d", i),
+			ServerWL: "server",
+			ClientIP: net.IPv4(10, 0, 0, byte(i)),
+			ServerIP: net.IPv4(10, 0, 1, 1),
+			Bytes:    uint64(i),
+		})
+	}
+	return NewRouter(c, policy.NewEngine())
+}
+
+func get(r http.Handler, path string, gz bool) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if gz {
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestGzipResponse(t *testing.T) {
+	r := newTestRouter()
+
+	plain := get(r, "/api/v1/connections", false)
+	if plain.Code != http.StatusOK || plain.Header().Get("Content-Encoding") != "" {
+		t.Fatalf("Unexpected plain response: %d %v", plain.Code, plain.Header())
+	}
+	if plain.Body.Len() < gzipMinSize {
+		t.Fatalf("Test payload below threshold: %d", plain.Body.Len())
+	}
+
+	compressed := get(r, "/api/v1/connections", true)
+	if compressed.Code != http.StatusOK || compressed.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Unexpected gzip response: %d %v", compressed.Code, compressed.Header())
+	}
+	if compressed.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("Missing Vary header: %v", compressed.Header())
+	}
+	zr, err := gzip.NewReader(compressed.Body)
+	if err != nil {
+		t.Fatalf("Invalid gzip body: %v", err)
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("Failed to decompress: %v", err)
+	}
+	// 连接列表顺序不固定，按客户端索引后比较
+	if a, b := connectionsByClient(t, body), connectionsByClient(t, plain.Body.Bytes()); !reflect.DeepEqual(a, b) {
+		t.Errorf("Decompressed JSON differs:\n%v\n%v", a, b)
+	}
+
+	// 小响应不压缩
+	health := get(r, "/health", true)
+	if health.Header().Get("Content-Encoding") != "" || health.Body.String() != `{"status":"ok"}` {
+		t.Errorf("Small response compressed: %v %q", health.Header(), health.Body.String())
+	}
+
+	// 错误状态码保留
+	missing := get(r, "/api/v1/workload", true)
+	if missing.Code != http.StatusBadRequest || missing.Header().Get("Content-Encoding") != "" {
+		t.Errorf("Unexpected error response: %d %v", missing.Code, missing.Header())
+	}
+}
+
+// connectionsByClient 解析连接列表响应，按客户端工作负载索引
+func connectionsByClient(t *testing.T, body []byte) map[string]map[string]interface{} {
+	var resp struct {
+		Data []map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	result := make(map[string]map[string]interface{})
+	for _, conn := range resp.Data {
+		result[conn["client_wl"].(string)] = conn
+	}
+	return result
+}
+
+func TestGzipSkipsStream(t *testing.T) {
+	r := newTestRouter()
+	event := bytes.Repeat([]byte("x"), gzipMinSize)
+	r.mux.HandleFunc("/test/stream", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.(http.Flusher).Flush()
+		w.Write([]byte("data: "))
+		w.Write(event)
+		w.(http.Flusher).Flush()
+	})
+
+	w := get(r, "/test/stream", true)
+	if w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("Stream compressed: %v", w.Header())
+	}
+	if !w.Flushed || w.Body.Len() != len(event)+6 {
+		t.Errorf("Unexpected stream body: flushed=%v len=%d", w.Flushed, w.Body.Len())
+	}
+}
+
+func TestRecommendPoliciesMode(t *testing.T) {
+	c := cache.NewCache()
+	c.AddGroup(&controller.Group{Name: "web", PolicyMode: controller.PolicyModeMonitor})
+	c.AddGroup(&controller.Group{Name: "db", PolicyMode: controller.PolicyModeProtect})
+	r := NewRouter(c, policy.NewEngine())
+
+	for path, code := range map[string]int{
+		"/api/v1/policies/recommend?group=web":     http.StatusOK,
+		"/api/v1/policies/recommend?group=db":      http.StatusConflict,
+		"/api/v1/policies/recommend?group=missing": http.StatusNotFound,
+		"/api/v1/policies/recommend":               http.StatusBadRequest,
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, nil))
+		if w.Code != code {
+			t.Errorf("%s: got %d, want %d", path, w.Code, code)
+		}
+	}
+}
+
+func TestCreatePolicyAppNames(t *testing.T) {
+	e := policy.NewEngine()
+	r := NewRouter(cache.NewCache(), e)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/policy", bytes.NewBufferString(body)))
+		return w
+	}
+
+	w := post(`{"id": 1, "from": "web", "to": "db", "app_names": ["MySQL"], "action": "allow"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Create failed: %d %s", w.Code, w.Body.String())
+	}
+	if rule := e.GetRule(1); rule == nil || !reflect.DeepEqual(rule.Applications, []uint32{2001}) {
+		t.Errorf("App name not resolved: %+v", rule)
+	}
+
+	if w := post(`{"id": 2, "from": "web", "to": "db", "app_names": ["nosuchapp"], "action": "allow"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for unknown app, got %d", w.Code)
+	}
+	if e.GetRule(2) != nil {
+		t.Errorf("Rule with unknown app created")
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	c := cache.NewCache()
+	c.AddGroup(&controller.Group{Name: "db", PolicyMode: controller.PolicyModeProtect})
+	r := NewRouter(c, policy.NewEngine())
+
+	for _, tc := range []struct {
+		method, path, body string
+		status             int
+		code               ErrorCode
+		message            string
+	}{
+		{http.MethodGet, "/api/v1/workload", "", http.StatusBadRequest, ErrValidation, "missing workload id"},
+		{http.MethodGet, "/api/v1/workload?id=nope", "", http.StatusNotFound, ErrNotFound, "workload not found"},
+		{http.MethodPost, "/api/v1/policies/recommend?group=db", "", http.StatusConflict, ErrConflict, "group not in Monitor mode"},
+		{http.MethodPost, "/api/v1/group", "{", http.StatusBadRequest, ErrValidation, "invalid request body"},
+		{http.MethodPost, "/api/v1/policy", `{"id": 0}`, http.StatusBadRequest, ErrValidation, "invalid rule: rule ID cannot be 0"},
+		{http.MethodPut, "/api/v1/policy", `{"id": 5}`, http.StatusNotFound, ErrNotFound, "rule not found: 5"},
+		{http.MethodDelete, "/api/v1/policy?id=5", "", http.StatusNotFound, ErrNotFound, "rule not found: 5"},
+		{http.MethodPost, "/api/v1/agents/a1/resync", "", http.StatusServiceUnavailable, ErrUnavailable, "agent resync not available"},
+		{http.MethodDelete, "/api/v1/workloads", "", http.StatusMethodNotAllowed, ErrMethodNotAllowed, "method not allowed"},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, bytes.NewBufferString(tc.body)))
+		var resp Response
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Errorf("%s %s: invalid body %q", tc.method, tc.path, w.Body.String())
+			continue
+		}
+		if w.Code != tc.status || resp.Code != int(tc.code) || resp.Message != tc.message {
+			t.Errorf("%s %s: got %d %+v, want %d %d %q", tc.method, tc.path, w.Code, resp, tc.status, tc.code, tc.message)
+		}
+	}
+}
+
+func TestListThreats(t *testing.T) {
+	c := cache.NewCache()
+	r := NewRouter(c, policy.NewEngine())
+	c.AddThreatFromProto("agent", &pb.ThreatLog{Id: "t1", ThreatId: 1001, Severity: "Low", ClientWl: "a", ReportedAt: 1700000000})
+	c.AddThreatFromProto("agent", &pb.ThreatLog{Id: "t2", ThreatId: 2022, Severity: "High", ClientWl: "a", ReportedAt: 1700000060})
+
+	var resp struct {
+		Data []controller.ThreatLog `json:"data"`
+	}
+	w := get(r, "/api/v1/threats?client_wl=a", false)
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &resp) != nil {
+		t.Fatalf("List threats failed: %d %s", w.Code, w.Body.String())
+	}
+	if len(resp.Data) != 2 || resp.Data[0].ID != "t2" || resp.Data[1].ThreatName != "TCP.SYN.Flood" {
+		t.Errorf("Unexpected threats: %+v", resp.Data)
+	}
+
+	w = get(r, "/api/v1/threats?severity=high&until=2023-11-14T22:15:00Z", false)
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &resp) != nil || len(resp.Data) != 1 || resp.Data[0].ID != "t2" {
+		t.Errorf("Unexpected filtered threats: %d %s", w.Code, w.Body.String())
+	}
+
+	for _, query := range []string{"severity=urgent", "threat_id=x", "since=today"} {
+		if w := get(r, "/api/v1/threats?"+query, false); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, w.Code)
+		}
+	}
+}
+
+func TestListFirstContacts(t *testing.T) {
+	c := cache.NewCache()
+	r := NewRouter(c, policy.NewEngine())
+	c.AddWorkload(&controller.Workload{ID: "web"})
+	c.AddWorkload(&controller.Workload{ID: "db"})
+	for i := 0; i < 3; i++ {
+		c.UpdateConnection(&controller.Connection{
+			ClientWL: "web", ServerWL: "db", ClientIP: net.IPv4(10, 0, 0, 1), ServerIP: net.IPv4(10, 0, 0, 2),
+			ServerPort: 5432, IPProto: 6, Bytes: uint64(i),
+		})
+	}
+
+	w := get(r, "/api/v1/events/first-contact", false)
+	var resp struct {
+		Data []controller.FirstContact `json:"data"`
+	}
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &resp) != nil {
+		t.Fatalf("First contacts failed: %d %s", w.Code, w.Body.String())
+	}
+	if len(resp.Data) != 1 || resp.Data[0].ClientWL != "web" || resp.Data[0].ServerWL != "db" {
+		t.Errorf("Unexpected first contacts: %+v", resp.Data)
+	}
+}
+
+func TestListExposure(t *testing.T) {
+	c := cache.NewCache()
+	r := NewRouter(c, policy.NewEngine())
+	c.UpdateConnection(&controller.Connection{
+		ClientWL: "8.8.8.8", ServerWL: "web", ClientIP: net.IPv4(8, 8, 8, 8), ServerIP: net.IPv4(10, 0, 0, 2),
+		ServerPort: 443, IPProto: 6, InternetIngress: true,
+	})
+
+	w := get(r, "/api/v1/exposure", false)
+	var resp struct {
+		Data []controller.WorkloadExposure `json:"data"`
+	}
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &resp) != nil {
+		t.Fatalf("Exposure failed: %d %s", w.Code, w.Body.String())
+	}
+	if len(resp.Data) != 1 || resp.Data[0].ID != "web" || len(resp.Data[0].Ports) != 1 || resp.Data[0].Ports[0].Port != 443 {
+		t.Errorf("Unexpected exposure: %+v", resp.Data)
+	}
+}
+
+func TestIdleWorkloads(t *testing.T) {
+	c := cache.NewCache()
+	r := NewRouter(c, policy.NewEngine())
+	for _, id := range []string{"web", "db", "batch"} {
+		c.AddWorkload(&controller.Workload{ID: id, Name: id})
+	}
+	start := time.Now().Add(-time.Second)
+	c.UpdateConnection(&controller.Connection{
+		ClientWL: "web", ServerWL: "db", ClientIP: net.IPv4(10, 0, 0, 1), ServerIP: net.IPv4(10, 0, 0, 2),
+		ServerPort: 5432, IPProto: 6,
+	})
+
+	var wl struct {
+		Data controller.Workload `json:"data"`
+	}
+	w := get(r, "/api/v1/workload?id=web", false)
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &wl) != nil || wl.Data.LastActiveAt.Before(start) {
+		t.Fatalf("Unexpected workload activity: %d %s", w.Code, w.Body.String())
+	}
+
+	w = get(r, "/api/v1/workloads/idle?since="+start.Format(time.RFC3339), false)
+	var resp struct {
+		Data []controller.Workload `json:"data"`
+	}
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &resp) != nil {
+		t.Fatalf("Idle workloads failed: %d %s", w.Code, w.Body.String())
+	}
+	if len(resp.Data) != 1 || resp.Data[0].ID != "batch" {
+		t.Errorf("Unexpected idle workloads: %+v", resp.Data)
+	}
+
+	for _, query := range []string{"", "?since=yesterday"} {
+		if w := get(r, "/api/v1/workloads/idle"+query, false); w.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected 400, got %d", query, w.Code)
+		}
+	}
+}
+
+func TestDeletePolicies(t *testing.T) {
+	p := policy.NewEngine()
+	r := NewRouter(cache.NewCache(), p)
+	for _, rule := range []*controller.PolicyRule{
+		{ID: 1, From: "web", To: "db", Action: "allow"},
+		{ID: 2, From: "web", To: "cache", Action: "deny", Disable: true},
+		{ID: 3, From: "api", To: "db", Action: "deny"},
+	} {
+		if err := p.AddRule(rule); err != nil {
+			t.Fatalf("AddRule: %v", err)
+		}
+	}
+
+	del := func(query string) (*httptest.ResponseRecorder, []uint32) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/v1/policies"+query, nil))
+		var resp struct {
+			Data []uint32 `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w, resp.Data
+	}
+
+	for _, query := range []string{"", "?all=false", "?disabled=maybe"} {
+		if w, _ := del(query); w.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected 400, got %d", query, w.Code)
+		}
+	}
+	if p.GetRuleCount() != 3 {
+		t.Fatalf("Rules deleted without filter")
+	}
+
+	if w, ids := del("?from=web&action=deny&disabled=true"); w.Code != http.StatusOK || len(ids) != 1 || ids[0] != 2 {
+		t.Fatalf("Unexpected filtered delete: %d %v", w.Code, ids)
+	}
+	if w, ids := del("?all=true"); w.Code != http.StatusOK || len(ids) != 2 || p.GetRuleCount() != 0 {
+		t.Errorf("Unexpected delete all: %d %v", w.Code, ids)
+	}
+}
+
+func TestRecomputeConnections(t *testing.T) {
+	c := cache.NewCache()
+	e := policy.NewEngine()
+	r := NewRouter(c, e)
+
+	rule := &controller.PolicyRule{ID: 1, From: "web", To: "db", Ports: "tcp/3306", Action: "allow"}
+	if err := e.AddRule(rule); err != nil {
+		t.Fatalf("AddRule: %v", err)
+	}
+	c.UpdateConnection(&controller.Connection{
+		ClientWL: "web", ServerWL: "db", ClientIP: net.IPv4(10, 0, 0, 1), ServerIP: net.IPv4(10, 0, 0, 2),
+		ServerPort: 3306, IPProto: 6, PolicyID: 1, PolicyAction: uint8(controller.PolicyActionAllow),
+	})
+
+	recompute := func() int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/connections/recompute", nil))
+		var resp struct {
+			Data map[string]int `json:"data"`
+		}
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &resp) != nil {
+			t.Fatalf("Recompute failed: %d %s", w.Code, w.Body.String())
+		}
+		return resp.Data["changed"]
+	}
+
+	if n := recompute(); n != 0 {
+		t.Errorf("Unchanged policy recomputed %d connections", n)
+	}
+
+	updated := *rule
+	updated.Action = "deny"
+	if err := e.UpdateRule(&updated); err != nil {
+		t.Fatalf("UpdateRule: %v", err)
+	}
+	if n := recompute(); n != 1 {
+		t.Errorf("Expected 1 changed connection, got %d", n)
+	}
+	conns := c.ListConnections()
+	if len(conns) != 1 || conns[0].PolicyID != 1 || conns[0].PolicyAction != uint8(controller.PolicyActionDeny) {
+		t.Errorf("Connection action not flipped: %+v", conns)
+	}
+	if links := c.GetNetworkGraph("").Links; len(links) != 1 || links[0].PolicyAction != uint8(controller.PolicyActionDeny) {
+		t.Errorf("Graph link action not flipped: %+v", links)
+	}
+
+	if w := get(r, "/api/v1/connections/recompute", false); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET allowed: %d", w.Code)
+	}
+}
+
+func TestGraphStats(t *testing.T) {
+	c := cache.NewCache()
+	r := NewRouter(c, policy.NewEngine())
+	for i, server := range []string{"a", "b", "c"} {
+		c.UpdateConnection(&controller.Connection{
+			ClientWL: "hub", ServerWL: server, ClientIP: net.IPv4(10, 0, 0, 1), ServerIP: net.IPv4(10, 0, 1, byte(i)),
+			ServerPort: 80, IPProto: 6,
+		})
+	}
+
+	w := get(r, "/api/v1/graph/stats?top=2", false)
+	var resp struct {
+		Data controller.GraphStats `json:"data"`
+	}
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &resp) != nil {
+		t.Fatalf("Graph stats failed: %d %s", w.Code, w.Body.String())
+	}
+	stats := resp.Data
+	if stats.Nodes != 4 || stats.Links != 3 {
+		t.Errorf("Unexpected counts: %+v", stats)
+	}
+	if len(stats.TopFanOut) != 1 || stats.TopFanOut[0].ID != "hub" || stats.TopFanOut[0].Out != 3 {
+		t.Errorf("Unexpected fan-out: %+v", stats.TopFanOut)
+	}
+	if len(stats.TopFanIn) != 2 || stats.TopFanIn[0].ID != "a" || stats.TopFanIn[0].In != 1 {
+		t.Errorf("Unexpected fan-in: %+v", stats.TopFanIn)
+	}
+
+	for _, top := range []string{"0", "-1", "x"} {
+		if w := get(r, "/api/v1/graph/stats?top="+top, false); w.Code != http.StatusBadRequest {
+			t.Errorf("top=%s: expected 400, got %d", top, w.Code)
+		}
+	}
+}
+
+func TestGraphExternalAggregation(t *testing.T) {
+	c := cache.NewCache()
+	r := NewRouter(c, policy.NewEngine())
+	for i := 1; i <= 10; i++ {
+		ip := net.IPv4(203, 0, 113, byte(i))
+		c.UpdateConnection(&controller.Connection{
+			ClientWL: ip.String(), ServerWL: "web", ClientIP: ip, ServerIP: net.IPv4(10, 0, 0, 1),
+			ServerPort: 443, IPProto: 6, Bytes: 10,
+		})
+	}
+
+	w := get(r, "/api/v1/graph?external_prefix=24", false)
+	var resp struct {
+		Data controller.NetworkGraph `json:"data"`
+	}
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &resp) != nil {
+		t.Fatalf("Graph failed: %d %s", w.Code, w.Body.String())
+	}
+	links := resp.Data.Links
+	if len(links) != 1 || links[0].From != "203.0.113.0/24" || links[0].Bytes != 100 {
+		t.Errorf("Unexpected aggregated links: %+v", links)
+	}
+
+	for _, q := range []string{"external_prefix=0", "external_prefix=33", "external_prefix6=129", "external_prefix=x"} {
+		if w := get(r, "/api/v1/graph?"+q, false); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", q, w.Code)
+		}
+	}
+}
+
+func TestGraphPrune(t *testing.T) {
+	c := cache.NewCache()
+	r := NewRouter(c, policy.NewEngine())
+	for i, l := range []struct {
+		from, to string
+		bytes    uint64
+	}{{"web", "db", 100000}, {"web", "dns", 100}} {
+		c.UpdateConnection(&controller.Connection{
+			ClientWL: l.from, ServerWL: l.to, ClientIP: net.IPv4(10, 0, 0, byte(i)), ServerIP: net.IPv4(10, 0, 1, byte(i)),
+			ServerPort: 80, IPProto: 6, Bytes: l.bytes, Sessions: 1,
+		})
+	}
+
+	w := get(r, "/api/v1/graph?min_bytes=1000", false)
+	var resp struct {
+		Data controller.NetworkGraph `json:"data"`
+	}
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &resp) != nil {
+		t.Fatalf("Graph failed: %d %s", w.Code, w.Body.String())
+	}
+	if links := resp.Data.Links; len(links) != 1 || links[0].To != "db" {
+		t.Errorf("Unexpected pruned links: %+v", links)
+	}
+	for _, n := range resp.Data.Nodes {
+		if n.ID == "dns" {
+			t.Errorf("Isolated node not dropped: %+v", resp.Data.Nodes)
+		}
+	}
+	// 过滤不影响缓存
+	if n := c.GetGraphLinkCount(); n != 2 {
+		t.Errorf("Cached links changed: %d", n)
+	}
+
+	for _, q := range []string{"min_bytes=-1", "min_sessions=x", "keep_policy=maybe"} {
+		if w := get(r, "/api/v1/graph?"+q, false); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", q, w.Code)
+		}
+	}
+}
+
+func TestServiceGraphLevel(t *testing.T) {
+	c := cache.NewCache()
+	r := NewRouter(c, policy.NewEngine())
+	for i, id := range []string{"web-1", "web-2", "db-1"} {
+		c.AddWorkload(&controller.Workload{ID: id, Service: id[:len(id)-2]})
+		if id != "db-1" {
+			c.UpdateConnection(&controller.Connection{
+				ClientWL: id, ServerWL: "db-1", ClientIP: net.IPv4(10, 0, 0, byte(i)), ServerIP: net.IPv4(10, 0, 1, 1),
+				ServerPort: 3306, IPProto: 6, Application: 2001, Bytes: 100, Sessions: 1,
+			})
+		}
+	}
+
+	w := get(r, "/api/v1/graph?level=service", false)
+	var resp struct {
+		Data controller.NetworkGraph `json:"data"`
+	}
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &resp) != nil {
+		t.Fatalf("Service graph failed: %d %s", w.Code, w.Body.String())
+	}
+	links := resp.Data.Links
+	if len(resp.Data.Nodes) != 2 || len(links) != 1 || links[0].From != "service:web" || links[0].To != "service:db" {
+		t.Fatalf("Unexpected service graph: %+v", resp.Data)
+	}
+	if apps := links[0].Applications; len(apps) != 1 || apps[0].Name != "MySQL" || apps[0].Bytes != 200 || apps[0].Sessions != 2 {
+		t.Errorf("Unexpected link applications: %+v", apps)
+	}
+
+	if w := get(r, "/api/v1/graph?level=pod", false); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for unknown level, got %d", w.Code)
+	}
+}
+
+func TestGraphCommunities(t *testing.T) {
+	c := cache.NewCache()
+	r := NewRouter(c, policy.NewEngine())
+	for i, l := range [][2]string{{"web", "db"}, {"api", "cache"}} {
+		c.UpdateConnection(&controller.Connection{
+			ClientWL: l[0], ServerWL: l[1], ClientIP: net.IPv4(10, 0, 0, byte(i)), ServerIP: net.IPv4(10, 0, 1, byte(i)),
+			ServerPort: 80, IPProto: 6,
+		})
+	}
+
+	w := get(r, "/api/v1/graph/communities", false)
+	var resp struct {
+		Data controller.GraphCommunities `json:"data"`
+	}
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &resp) != nil {
+		t.Fatalf("Graph communities failed: %d %s", w.Code, w.Body.String())
+	}
+	nodes := resp.Data.Nodes
+	if resp.Data.Count != 2 || len(nodes) != 4 {
+		t.Fatalf("Unexpected communities: %+v", resp.Data)
+	}
+	if nodes["web"] != nodes["db"] || nodes["api"] != nodes["cache"] || nodes["web"] == nodes["api"] {
+		t.Errorf("Unexpected community assignment: %v", nodes)
+	}
+}
+
+func TestSetGroupMode(t *testing.T) {
+	c := cache.NewCache()
+	p := policy.NewEngine()
+	c.AddWorkload(&controller.Workload{ID: "w1", PolicyMode: controller.PolicyModeMonitor})
+	c.AddGroup(&controller.Group{Name: "web"})
+	c.AddGroupMember("web", "w1")
+	r := NewRouter(c, p)
+
+	put := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/api/v1/group/mode", bytes.NewBufferString(body)))
+		return w
+	}
+
+	if w := put(`{"name":"web","policy_mode":"Protect"}`); w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status %d: %s", w.Code, w.Body.String())
+	}
+	if c.GetWorkloadPolicyMode("w1") != controller.PolicyModeProtect || p.GetGroupMode("web") != controller.PolicyModeProtect {
+		t.Errorf("Mode not propagated: wl=%q group=%q", c.GetWorkloadPolicyMode("w1"), p.GetGroupMode("web"))
+	}
+
+	// 更新组未指定模式时保留原模式
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/api/v1/group", bytes.NewBufferString(`{"name":"web","comment":"x"}`)))
+	if w.Code != http.StatusOK || c.GetWorkloadPolicyMode("w1") != controller.PolicyModeProtect {
+		t.Errorf("Group update reset mode: %d %q", w.Code, c.GetWorkloadPolicyMode("w1"))
+	}
+
+	for body, code := range map[string]int{
+		`{"name":"web","policy_mode":"Block"}`:       http.StatusBadRequest,
+		`{"policy_mode":"Monitor"}`:                  http.StatusBadRequest,
+		`{"name":"missing","policy_mode":"Monitor"}`: http.StatusNotFound,
+	} {
+		if w := put(body); w.Code != code {
+			t.Errorf("%s: got %d, want %d", body, w.Code, code)
+		}
+	}
+}
+
+func TestCreateDeleteGroupMode(t *testing.T) {
+	c := cache.NewCache()
+	p := policy.NewEngine()
+	r := NewRouter(c, p)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, bytes.NewBufferString(body)))
+		return w
+	}
+
+	if w := do(http.MethodPost, "/api/v1/group", `{"name":"web","policy_mode":"Block"}`); w.Code != http.StatusBadRequest || c.GetGroup("web") != nil {
+		t.Errorf("Invalid mode accepted: %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/api/v1/group", `{"name":"web","policy_mode":"Protect"}`); w.Code != http.StatusOK {
+		t.Fatalf("Create failed: %d %s", w.Code, w.Body.String())
+	}
+	if mode := p.GetGroupMode("web"); mode != controller.PolicyModeProtect {
+		t.Errorf("Mode not applied to policy engine: %s", mode)
+	}
+
+	// 删除后重新创建的同名组不沿用原模式
+	if w := do(http.MethodDelete, "/api/v1/group?name=web", ""); w.Code != http.StatusOK {
+		t.Fatalf("Delete failed: %d", w.Code)
+	}
+	if mode := p.GetGroupMode("web"); mode != controller.PolicyModeMonitor {
+		t.Errorf("Mode kept after delete: %s", mode)
+	}
+	p.SetGroupMode("web", controller.PolicyModeProtect)
+	if w := do(http.MethodPost, "/api/v1/group", `{"name":"web"}`); w.Code != http.StatusOK {
+		t.Fatalf("Create failed: %d", w.Code)
+	}
+	if _, action := p.MatchPolicy("any", "web", 80, 6, 0); action != controller.PolicyActionViolate {
+		t.Errorf("Recreated group inherited mode: %v", action)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+
+	c := cache.NewCache()
+	c.AddGroup(&controller.Group{Name: "web"})
+	r := NewRouter(c, policy.NewEngine())
+
+	put := func(id string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, "/api/v1/group/mode", bytes.NewBufferString(`{"name":"web","policy_mode":"Protect"}`))
+		if id != "" {
+			req.Header.Set(requestid.Header, id)
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// 客户端携带的请求ID回传并出现在处理日志中
+	w := put("req-42")
+	if w.Code != http.StatusOK || w.Header().Get(requestid.Header) != "req-42" {
+		t.Fatalf("Request id not echoed: %d %v", w.Code, w.Header())
+	}
+	entry := hook.LastEntry()
+	if entry == nil || entry.Message != "Group policy mode changed" || entry.Data[requestid.LogField] != "req-42" {
+		t.Errorf("Handler log missing request id: %+v", entry)
+	}
+
+	// 未携带时生成新ID
+	hook.Reset()
+	w = put("")
+	id := w.Header().Get(requestid.Header)
+	if id == "" || hook.LastEntry() == nil || hook.LastEntry().Data[requestid.LogField] != id {
+		t.Errorf("Generated request id not logged: %q %+v", id, hook.LastEntry())
+	}
+}
+
+// exportConfig 导出集群配置，清除时间戳以便比较
+func exportConfig(t *testing.T, r http.Handler) ([]byte, controller.ClusterConfig) {
+	t.Helper()
+	w := get(r, "/api/v1/config/export", false)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Export failed: %d %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Data json.RawMessage `json:"data"`
+	}
+	var cfg controller.ClusterConfig
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Invalid export body: %v", err)
+	}
+	if err := json.Unmarshal(resp.Data, &cfg); err != nil {
+		t.Fatalf("Invalid config document: %v", err)
+	}
+	cfg.ExportedAt = time.Time{}
+	for _, g := range cfg.Groups {
+		g.CreatedAt, g.UpdatedAt = time.Time{}, time.Time{}
+	}
+	for _, p := range cfg.Policies {
+		p.CreatedAt, p.UpdatedAt = time.Time{}, time.Time{}
+	}
+	return resp.Data, cfg
+}
+
+func postConfig(r http.Handler, body []byte) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/config/import", bytes.NewReader(body)))
+	return w
+}
+
+func TestConfigRoundTrip(t *testing.T) {
+	c := cache.NewCache()
+	p := policy.NewEngine()
+	c.AddGroup(&controller.Group{Name: "web", Comment: "frontend"})
+	c.AddGroup(&controller.Group{Name: "db", Disabled: true})
+	c.ApplyGroupMode("db", controller.PolicyModeProtect)
+	c.SetConnectionTTL(90 * time.Second)
+	c.SetSeverityQuietPeriod(0)
+	for _, rule := range []*controller.PolicyRule{
+		{ID: 1, From: "web", To: "db", Ports: "tcp/3306", Action: "allow"},
+		{ID: 2, From: "any", To: "db", Action: "deny", Priority: 10, Log: true},
+	} {
+		if err := p.AddRule(rule); err != nil {
+			t.Fatalf("AddRule: %v", err)
+		}
+	}
+	doc, want := exportConfig(t, NewRouter(c, p))
+	if want.Version != controller.ClusterConfigVersion || len(want.Groups) != 2 || len(want.Policies) != 2 ||
+		want.GroupModes["db"] != controller.PolicyModeProtect || want.Settings.ConnectionTTL != 90 {
+		t.Fatalf("Unexpected export: %+v", want)
+	}
+
+	// 导入到已有其他配置的集群，原配置被整体替换
+	c2 := cache.NewCache()
+	p2 := policy.NewEngine()
+	c2.AddGroup(&controller.Group{Name: "old"})
+	p2.SetGroupDisabled("old", true)
+	p2.AddRule(&controller.PolicyRule{ID: 9, From: "old", To: "any", Action: "deny"})
+	p2.SetGroupValidator(func(name string) bool { return c2.GetGroup(name) != nil })
+	r2 := NewRouter(c2, p2)
+	if w := postConfig(r2, doc); w.Code != http.StatusOK {
+		t.Fatalf("Import failed: %d %s", w.Code, w.Body.String())
+	}
+
+	_, got := exportConfig(t, r2)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Round trip mismatch:\ngot  %+v\nwant %+v", got, want)
+	}
+	if c2.GetGroup("old") != nil || p2.GetRule(9) != nil || p2.IsGroupDisabled("old") {
+		t.Errorf("Old config not replaced")
+	}
+	if !p2.IsGroupDisabled("db") || p2.GetGroupMode("db") != controller.PolicyModeProtect {
+		t.Errorf("Group state not applied to policy engine")
+	}
+}
+
+func TestConfigImportClearsGroupModes(t *testing.T) {
+	c := cache.NewCache()
+	p := policy.NewEngine()
+	r := NewRouter(c, p)
+	for _, name := range []string{"web", "db"} {
+		c.AddGroup(&controller.Group{Name: name})
+		c.ApplyGroupMode(name, controller.PolicyModeProtect)
+		p.SetGroupMode(name, controller.PolicyModeProtect)
+	}
+	if _, action := p.MatchPolicy("web", "db", 3306, 6, 0); action != controller.PolicyActionDeny {
+		t.Fatalf("Unexpected default action before import: %v", action)
+	}
+
+	// 导入的db不指定模式，web被删除
+	body := []byte(`{"version": 1, "groups": [{"name": "db"}], "settings": {"connection_ttl": 60}}`)
+	if w := postConfig(r, body); w.Code != http.StatusOK {
+		t.Fatalf("Import failed: %d %s", w.Code, w.Body.String())
+	}
+	_, cfg := exportConfig(t, r)
+	if len(cfg.GroupModes) != 0 {
+		t.Errorf("Unexpected exported group modes: %v", cfg.GroupModes)
+	}
+	for _, name := range []string{"web", "db"} {
+		if mode := p.GetGroupMode(name); mode != controller.PolicyModeMonitor {
+			t.Errorf("%s: engine mode not cleared: %s", name, mode)
+		}
+	}
+	if _, action := p.MatchPolicy("web", "db", 3306, 6, 0); action != controller.PolicyActionViolate {
+		t.Errorf("Stale protect default after import: %v", action)
+	}
+}
+
+func TestConfigImportRejected(t *testing.T) {
+	c := cache.NewCache()
+	p := policy.NewEngine()
+	c.AddGroup(&controller.Group{Name: "web"})
+	p.AddRule(&controller.PolicyRule{ID: 1, From: "web", To: "any", Action: "allow"})
+	p.SetGroupValidator(func(name string) bool { return c.GetGroup(name) != nil })
+	r := NewRouter(c, p)
+
+	for _, tc := range []struct{ body, message string }{
+		{`{"version": 2, "groups": [{"name": "db"}], "settings": {"connection_ttl": 60}}`,
+			"unsupported config version 2, expected 1"},
+		{`{"version": 1, "groups": [{"name": "db"}], "policies": [{"id": 2, "from": "db", "to": "nope", "action": "allow"}], "settings": {"connection_ttl": 60}}`,
+			`unknown group: "nope"`},
+		{`{"version": 1, "groups": [{"name": "db"}], "group_modes": {"web": "Protect"}, "settings": {"connection_ttl": 60}}`,
+			`group mode for unknown group "web"`},
+	} {
+		w := postConfig(r, []byte(tc.body))
+		var resp Response
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if w.Code != http.StatusBadRequest || resp.Message != tc.message {
+			t.Errorf("Got %d %q, want 400 %q", w.Code, resp.Message, tc.message)
+		}
+	}
+
+	// 拒绝时不做任何修改
+	if c.GetGroup("web") == nil || c.GetGroup("db") != nil || p.GetRule(1) == nil || p.GetRule(2) != nil {
+		t.Errorf("Rejected import modified config")
+	}
+}
+
+// fakeAgentLister 固定的Agent状态来源
+type fakeAgentLister struct {
+	captures map[string]controller.AgentCaptures
+}
+
+func (f *fakeAgentLister) ListAgentStates() []controller.AgentStateSnapshot { return nil }
+
+func (f *fakeAgentLister) GetAgentCaptures(agentID string) (controller.AgentCaptures, bool) {
+	c, ok := f.captures[agentID]
+	return c, ok
+}
+
+func TestGetAgentCaptures(t *testing.T) {
+	r := NewRouter(cache.NewCache(), policy.NewEngine())
+	if w := get(r, "/api/v1/agents/captures?agent_id=a1", false); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 without agent lister, got %d", w.Code)
+	}
+
+	r.SetAgentLister(&fakeAgentLister{captures: map[string]controller.AgentCaptures{
+		"a1": {AgentID: "a1", Online: true, Containers: []string{"c1", "c2"}},
+	}})
+	for path, code := range map[string]int{
+		"/api/v1/agents/captures":             http.StatusBadRequest,
+		"/api/v1/agents/captures?agent_id=a2": http.StatusNotFound,
+	} {
+		if w := get(r, path, false); w.Code != code {
+			t.Errorf("%s: got %d, want %d", path, w.Code, code)
+		}
+	}
+
+	w := get(r, "/api/v1/agents/captures?agent_id=a1", false)
+	var resp struct {
+		Data controller.AgentCaptures `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("Unexpected response: %d %s", w.Code, w.Body.String())
+	}
+	if !reflect.DeepEqual(resp.Data.Containers, []string{"c1", "c2"}) || !resp.Data.Online {
+		t.Errorf("Unexpected captures: %+v", resp.Data)
+	}
+}
@@ -4,6 +4,8 @@ package controller
 import (
 	"net"
 	"time"
+
+	pb "github.com/micro-segment/api/proto"
 )
 
 // PolicyMode 策略模式
@@ -16,18 +18,18 @@ const (
 	PolicyModeProtect PolicyMode = "Protect"
 )
 
-// PolicyAction 策略动作
+// PolicyAction 策略动作，取值与proto定义一致
 type PolicyAction uint8
 
 const (
 	// PolicyActionOpen 开放
-	PolicyActionOpen PolicyAction = 0
+	PolicyActionOpen = PolicyAction(pb.PolicyActionOpen)
 	// PolicyActionAllow 允许
-	PolicyActionAllow PolicyAction = 1
+	PolicyActionAllow = PolicyAction(pb.PolicyActionAllow)
 	// PolicyActionDeny 拒绝
-	PolicyActionDeny PolicyAction = 2
+	PolicyActionDeny = PolicyAction(pb.PolicyActionDeny)
 	// PolicyActionViolate 违规
-	PolicyActionViolate PolicyAction = 3
+	PolicyActionViolate = PolicyAction(pb.PolicyActionViolate)
 )
 
 // Group 容器组
@@ -36,6 +38,7 @@ type Group struct {
 	Comment     string            `json:"comment,omitempty"`
 	Domain      string            `json:"domain,omitempty"`
 	PolicyMode  PolicyMode        `json:"policy_mode"`
+	Disabled    bool              `json:"disabled"` // 禁用后不再对组内流量执行策略
 	Members     []string          `json:"members,omitempty"`
 	Criteria    []GroupCriteria   `json:"criteria,omitempty"`
 	CreatedAt   time.Time         `json:"created_at"`
@@ -57,8 +60,10 @@ type PolicyRule struct {
 	To           string       `json:"to"`
 	Ports        string       `json:"ports,omitempty"`
 	Applications []uint32     `json:"applications,omitempty"`
+	AppNames     []string     `json:"app_names,omitempty"`
 	Action       string       `json:"action"`
 	Disable      bool         `json:"disable"`
+	Log          bool         `json:"log,omitempty"` // 命中的连接记录审计，不影响动作
 	Priority     uint32       `json:"priority"`
 	CreatedAt    time.Time    `json:"created_at"`
 	UpdatedAt    time.Time    `json:"updated_at"`
@@ -76,8 +81,8 @@ type Connection struct {
 	Application  uint32    `json:"application"`
 	Bytes        uint64    `json:"bytes"`
 	Sessions     uint32    `json:"sessions"`
-	FirstSeenAt  uint32    `json:"first_seen_at"`
-	LastSeenAt   uint32    `json:"last_seen_at"`
+	FirstSeenAt  time.Time `json:"first_seen_at"` // 零值表示未知
+	LastSeenAt   time.Time `json:"last_seen_at"`  // 零值表示未知
 	ThreatID     uint32    `json:"threat_id,omitempty"`
 	Severity     uint8     `json:"severity,omitempty"`
 	PolicyAction uint8     `json:"policy_action"`
@@ -85,6 +90,49 @@ type Connection struct {
 	Ingress      bool      `json:"ingress"`
 	ExternalPeer bool      `json:"external_peer"`
 	LocalPeer    bool      `json:"local_peer"`
+	L7           []L7Meta  `json:"l7,omitempty"`
+	Capped       bool      `json:"capped,omitempty"` // 计数已饱和，实际值可能更大
+	Summary      bool      `json:"summary,omitempty"` // Agent容量超限时合并的其他流量汇总，不含端口和协议
+	ByteRate     float64   `json:"byte_rate"`         // 首末次出现时间之间的平均字节速率（字节/秒），时间未知或仅观察到一次时为0
+
+	InternetIngress bool `json:"internet_ingress,omitempty"` // 客户端为公网地址、服务端为内部地址
+
+	// 观察到该流量的Agent，客户端和服务端所在主机的Agent都上报时合并为一条连接
+	Agents []string `json:"agents,omitempty"`
+
+	// 外部端点的国家和ASN，未配置地理信息库或尚未解析时为空
+	ClientGeo *GeoInfo `json:"client_geo,omitempty"`
+	ServerGeo *GeoInfo `json:"server_geo,omitempty"`
+}
+
+// WorkloadPorts 工作负载在连接中观察到的端口
+type WorkloadPorts struct {
+	Server []PortUsage `json:"server"` // 作为服务端被访问的端口
+	Client []PortUsage `json:"client"` // 作为客户端访问的对端端口
+}
+
+// WorkloadExposure 被公网客户端访问的工作负载及其端口
+type WorkloadExposure struct {
+	ID     string      `json:"id"`
+	Name   string      `json:"name,omitempty"`
+	Domain string      `json:"domain,omitempty"`
+	Ports  []PortUsage `json:"ports"`
+}
+
+// PortUsage 连接中观察到的端口与协议
+type PortUsage struct {
+	Port        uint16    `json:"port"`
+	IPProto     uint8     `json:"ip_proto"`
+	FirstSeenAt time.Time `json:"first_seen_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+}
+
+// L7Meta 连接的应用层元数据
+type L7Meta struct {
+	HTTPMethod string `json:"http_method,omitempty"`
+	HTTPHost   string `json:"http_host,omitempty"`
+	DNSQuery   string `json:"dns_query,omitempty"`
+	TLSSNI     string `json:"tls_sni,omitempty"`
 }
 
 // Workload 工作负载
@@ -99,6 +147,14 @@ type Workload struct {
 	PolicyMode  PolicyMode        `json:"policy_mode"`
 	Running     bool              `json:"running"`
 	Ifaces      map[string][]IPAddr `json:"ifaces,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	PodName     string            `json:"pod_name,omitempty"`
+	OwnerKind   string            `json:"owner_kind,omitempty"`
+	OwnerName   string            `json:"owner_name,omitempty"`
+	State       string            `json:"state,omitempty"`  // 容器状态，如running、paused、exited
+	Health      string            `json:"health,omitempty"` // 健康检查状态，未配置时为空
+	AgentID     string            `json:"agent_id,omitempty"` // 上报该工作负载的Agent
+	LastActiveAt time.Time        `json:"last_active_at,omitempty"` // 最近一次出现在连接中的时间
 	CreatedAt   time.Time         `json:"created_at"`
 }
 
@@ -125,6 +181,66 @@ type Agent struct {
 	JoinedAt time.Time `json:"joined_at"`
 }
 
+// AgentStateSnapshot Agent状态快照
+// 由gRPC服务器在锁内复制生成，与其内部状态互不影响
+type AgentStateSnapshot struct {
+	ID       string      `json:"id"`
+	HostID   string      `json:"host_id"`
+	HostName string      `json:"host_name,omitempty"`
+	Version  string      `json:"version,omitempty"`
+	Platform string      `json:"platform,omitempty"`
+	Online   bool        `json:"online"`
+	LastSeen time.Time   `json:"last_seen"`
+	Stats    *AgentStats `json:"stats,omitempty"` // 最近一次心跳上报的统计，未上报时为nil
+}
+
+// AgentCaptures Agent正在捕获流量的容器，随心跳上报
+type AgentCaptures struct {
+	AgentID    string    `json:"agent_id"`
+	Online     bool      `json:"online"`
+	Containers []string  `json:"containers"`
+	ReportedAt time.Time `json:"reported_at"` // 最近一次心跳时间
+}
+
+// AgentStats Agent心跳上报的统计
+type AgentStats struct {
+	WorkloadCount   uint32  `json:"workload_count"`
+	ConnectionCount uint32  `json:"connection_count"`
+	PolicyCount     uint32  `json:"policy_count"`
+	MemoryUsage     uint64  `json:"memory_usage"`
+	CPUUsage        float32 `json:"cpu_usage"`
+}
+
+// Agent命令类型
+const (
+	AgentCommandForceCapture = "force-capture" // 强制开启容器流量捕获
+	AgentCommandForceStop    = "force-stop"    // 强制停止容器流量捕获
+	AgentCommandGetDebug     = "get-debug"     // 获取Agent网络模块调试信息
+	AgentCommandPacketSample = "packet-sample" // 采样容器流量写入Agent主机上的pcap文件
+)
+
+// Agent命令状态
+const (
+	AgentCommandPending = "pending" // 等待下次心跳下发
+	AgentCommandSent    = "sent"    // 已随心跳响应下发
+	AgentCommandDone    = "done"    // Agent执行成功
+	AgentCommandFailed  = "failed"  // Agent执行失败
+)
+
+// AgentCommand 下发给Agent的远程命令
+// 排队后随该Agent下一次心跳响应下发，执行结果随后续心跳回传
+type AgentCommand struct {
+	ID          string    `json:"id"`
+	AgentID     string    `json:"agent_id"`
+	Type        string    `json:"type"`
+	ContainerID string    `json:"container_id,omitempty"`
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	Output      string    `json:"output,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	DoneAt      time.Time `json:"done_at,omitempty"`
+}
+
 // Violation 违规记录
 type Violation struct {
 	ID           string    `json:"id"`
@@ -142,6 +258,20 @@ type Violation struct {
 	Level        string    `json:"level"`
 }
 
+// FirstContact 首次通信事件，此前从未通信过的两个工作负载之间出现连接
+type FirstContact struct {
+	ID           string    `json:"id"`
+	ClientWL     string    `json:"client_wl"`
+	ServerWL     string    `json:"server_wl"`
+	ClientIP     string    `json:"client_ip"`
+	ServerIP     string    `json:"server_ip"`
+	ServerPort   uint16    `json:"server_port"`
+	IPProto      uint8     `json:"ip_proto"`
+	PolicyAction string    `json:"policy_action"`
+	PolicyID     uint32    `json:"policy_id"`
+	ReportedAt   time.Time `json:"reported_at"`
+}
+
 // ThreatLog 威胁日志
 type ThreatLog struct {
 	ID         string    `json:"id"`
@@ -154,9 +284,35 @@ type ThreatLog struct {
 	ServerIP   string    `json:"server_ip"`
 	ServerPort uint16    `json:"server_port"`
 	IPProto    uint8     `json:"ip_proto"`
+	PktIngress bool      `json:"pkt_ingress"`
+	AgentID    string    `json:"agent_id,omitempty"`
+	TcpFlags   uint8     `json:"tcp_flags,omitempty"`
+	PktLen     uint16    `json:"pkt_len,omitempty"`
+	PktSummary string    `json:"pkt_summary,omitempty"`
 	ReportedAt time.Time `json:"reported_at"`
 }
 
+// 告警类型
+const (
+	AlertTypeViolation = "violation"
+	AlertTypeThreat    = "threat"
+)
+
+// Alert 告警，违规连接或威胁产生时推送
+type Alert struct {
+	Type      string     `json:"type"`
+	Severity  uint8      `json:"severity"` // 与连接严重级别一致，0-4对应info到critical
+	Violation *Violation `json:"violation,omitempty"`
+	Threat    *ThreatLog `json:"threat,omitempty"`
+}
+
+// GeoInfo 外部端点的国家和自治系统
+type GeoInfo struct {
+	Country string `json:"country,omitempty"` // ISO 3166国家代码
+	ASN     uint32 `json:"asn,omitempty"`
+	Org     string `json:"org,omitempty"` // 自治系统所属组织
+}
+
 // GraphNode 图节点
 type GraphNode struct {
 	ID       string `json:"id"`
@@ -165,16 +321,47 @@ type GraphNode struct {
 	Domain   string `json:"domain,omitempty"`
 	Service  string `json:"service,omitempty"`
 	PolicyMode string `json:"policy_mode,omitempty"`
+	Boundary bool   `json:"boundary,omitempty"` // 按域过滤时，域外的链接端点
+	Geo      *GeoInfo `json:"geo,omitempty"`    // 外部端点的国家和ASN
 }
 
 // GraphLink 图链接
 type GraphLink struct {
-	From         string `json:"from"`
-	To           string `json:"to"`
-	Bytes        uint64 `json:"bytes"`
-	Sessions     uint32 `json:"sessions"`
-	Severity     uint8  `json:"severity,omitempty"`
-	PolicyAction uint8  `json:"policy_action"`
+	From          string   `json:"from"`
+	To            string   `json:"to"`
+	Bytes         uint64   `json:"bytes"`
+	Sessions      uint32   `json:"sessions"`
+	Severity      uint8    `json:"severity,omitempty"`
+	PolicyAction  uint8    `json:"policy_action"`
+	PolicyID      uint32   `json:"policy_id"`                // 产生策略动作的规则，0表示默认动作
+	PolicyComment string   `json:"policy_comment,omitempty"` // 规则备注，由REST层填充
+	Threats       []uint32 `json:"threats,omitempty"`        // 关联到该链接的威胁ID
+	LastSeenAt    time.Time `json:"last_seen_at"`            // 链接最近活跃时间，客户端可据此淡化陈旧链接
+	ByteRate      float64   `json:"byte_rate"`               // 连接的平均字节速率（字节/秒）
+
+	// 按方向拆分的计数，为各方向最近一次上报的值
+	IngressBytes    uint64 `json:"ingress_bytes"`
+	IngressSessions uint32 `json:"ingress_sessions"`
+	EgressBytes     uint64 `json:"egress_bytes"`
+	EgressSessions  uint32 `json:"egress_sessions"`
+
+	// 服务级拓扑图中链接上按应用聚合的流量，按应用标识排序
+	Applications []LinkApplication `json:"applications,omitempty"`
+}
+
+// LinkApplication 服务间链接上一种应用的流量
+type LinkApplication struct {
+	Application uint32        `json:"application"`    // 0表示未识别
+	Name        string        `json:"name,omitempty"` // 应用名称，由REST层填充
+	Ports       []ServicePort `json:"ports"`          // 访问的服务端端口
+	Bytes       uint64        `json:"bytes"`
+	Sessions    uint32        `json:"sessions"`
+}
+
+// ServicePort 服务端端口
+type ServicePort struct {
+	Port    uint16 `json:"port"`
+	IPProto uint8  `json:"ip_proto"`
 }
 
 // NetworkGraph 网络拓扑图
@@ -182,3 +369,53 @@ type NetworkGraph struct {
 	Nodes []GraphNode `json:"nodes"`
 	Links []GraphLink `json:"links"`
 }
+
+// NodeDegree 图节点的入度和出度，按不同对端节点计数
+type NodeDegree struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Kind string `json:"kind"` // workload, host, external
+	In   int    `json:"in"`   // 访问该节点的不同对端数（fan-in）
+	Out  int    `json:"out"`  // 该节点访问的不同对端数（fan-out）
+}
+
+// GraphStats 网络拓扑图统计
+type GraphStats struct {
+	Nodes     int          `json:"nodes"`
+	Links     int          `json:"links"`
+	TopFanIn  []NodeDegree `json:"top_fan_in"`
+	TopFanOut []NodeDegree `json:"top_fan_out"`
+	TopDegree []NodeDegree `json:"top_degree"` // 按入度与出度之和排序
+}
+
+// GraphCommunities 网络拓扑图社区划分
+type GraphCommunities struct {
+	Count int            `json:"count"` // 社区数
+	Nodes map[string]int `json:"nodes"` // 节点ID -> 社区ID
+}
+
+// HistogramBucket 直方图桶，LE为桶上界，+Inf表示溢出桶
+type HistogramBucket struct {
+	LE    string `json:"le"`
+	Count uint64 `json:"count"`
+}
+
+// ClusterConfigVersion 集群配置文档格式版本，格式不兼容变化时递增
+const ClusterConfigVersion = 1
+
+// ClusterConfig 集群配置导出文档，用于备份和迁移
+// 仅包含配置，不包含连接、Agent等运行时状态
+type ClusterConfig struct {
+	Version    int                   `json:"version"`
+	ExportedAt time.Time             `json:"exported_at"`
+	Groups     []*Group              `json:"groups"`
+	Policies   []*PolicyRule         `json:"policies"`
+	GroupModes map[string]PolicyMode `json:"group_modes,omitempty"` // 组名 -> 策略模式，优先于组中的policy_mode
+	Settings   ClusterSettings       `json:"settings"`
+}
+
+// ClusterSettings 集群运行参数
+type ClusterSettings struct {
+	ConnectionTTL uint32 `json:"connection_ttl"` // 连接过期时间，秒
+	SeverityQuiet uint32 `json:"severity_quiet"` // 严重级别衰减静默期，秒，0表示不衰减
+}
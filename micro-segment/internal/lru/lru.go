@@ -0,0 +1,81 @@
+// Package lru 并发安全的定长LRU缓存
+package lru
+
+import (
+	"container/list"
+	"sync"
+)
+
+// entry 缓存项
+type entry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+// Cache 最多保存size条的LRU缓存，超过容量时淘汰最久未使用的项
+type Cache[K comparable, V any] struct {
+	mutex   sync.Mutex
+	size    int
+	entries map[K]*list.Element
+	lru     *list.List // 最近使用的在前
+}
+
+// New 创建最多保存size条的缓存，size小于1时按1处理
+func New[K comparable, V any](size int) *Cache[K, V] {
+	if size < 1 {
+		size = 1
+	}
+	return &Cache[K, V]{
+		size:    size,
+		entries: make(map[K]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// Get 查询缓存项，命中时标记为最近使用
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*entry[K, V]).value, true
+}
+
+// Put 保存缓存项，超过容量时淘汰最久未使用的项
+func (c *Cache[K, V]) Put(key K, value V) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*entry[K, V]).value = value
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&entry[K, V]{key: key, value: value})
+	if c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*entry[K, V]).key)
+	}
+}
+
+// Reset 清空全部缓存项
+func (c *Cache[K, V]) Reset() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.entries = make(map[K]*list.Element)
+	c.lru.Init()
+}
+
+// Len 获取缓存项数
+func (c *Cache[K, V]) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.lru.Len()
+}
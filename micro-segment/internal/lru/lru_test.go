@@ -0,0 +1,45 @@
+package lru
+
+import "testing"
+
+func TestCache(t *testing.T) {
+	c := New[string, int](2)
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("Hit on empty cache")
+	}
+	c.Put("a", 1)
+	c.Put("b", 2)
+	c.Get("a")
+	c.Put("c", 3)
+
+	// 最久未使用的被淘汰
+	if c.Len() != 2 {
+		t.Fatalf("Cache exceeds bound: %d", c.Len())
+	}
+	if _, ok := c.Get("b"); ok {
+		t.Errorf("Least recently used entry not evicted")
+	}
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Errorf("Unexpected value for a: %d %v", v, ok)
+	}
+
+	// 已有的键更新值且不增加项数
+	c.Put("c", 30)
+	if v, _ := c.Get("c"); v != 30 || c.Len() != 2 {
+		t.Errorf("Update failed: value=%d len=%d", v, c.Len())
+	}
+
+	c.Reset()
+	if _, ok := c.Get("a"); ok || c.Len() != 0 {
+		t.Errorf("Cache not reset: len=%d", c.Len())
+	}
+}
+
+func TestCacheMinSize(t *testing.T) {
+	c := New[int, int](0)
+	c.Put(1, 1)
+	c.Put(2, 2)
+	if v, ok := c.Get(2); !ok || v != 2 || c.Len() != 1 {
+		t.Errorf("Unexpected cache state: value=%d ok=%v len=%d", v, ok, c.Len())
+	}
+}